@@ -0,0 +1,315 @@
+package api
+
+// The Admin/Monitor API: transports expose it on a separate endpoint and pass
+// its requests with the admin flag set. It can be used to inspect sessions
+// and handles and to change some settings at runtime, and it's protected by
+// the admin_secret of the configuration (if set), which every request but
+// info and ping must carry.
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/events"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/session"
+	"github.com/xroger88/go-janus/util"
+)
+
+var (
+	adminGlobalRequests = []string{
+		"info", "ping", "list_sessions", "set_log_level", "set_session_timeout", "accept_new_sessions",
+		"add_token", "list_tokens", "remove_token", "query_eventhandler", "message_plugin",
+	}
+	adminSessionRequests = []string{"list_handles", "destroy_session"}
+	adminHandleRequests  = []string{"handle_info", "detach_handle", "hangup_webrtc"}
+)
+
+// whether we're accepting new sessions (1) or not (0), accessed atomically
+var acceptingSessions int32 = 1
+
+// AcceptingSessions tells whether new sessions can be created
+func AcceptingSessions() bool {
+	return atomic.LoadInt32(&acceptingSessions) == 1
+}
+
+func handleAdminRequest(msg message) map[string]interface{} {
+	transaction := msg.str("transaction")
+	if err := msg.validate(
+		param{"transaction", kindString, true},
+		param{"janus", kindString, true},
+		param{"session_id", kindPositiveInteger, false},
+		param{"handle_id", kindPositiveInteger, false},
+	); err != nil {
+		return errorResponse(msg.uint("session_id"), transaction, err)
+	}
+	verb := strings.ToLower(msg.str("janus"))
+	sessionID, handleID := msg.uint("session_id"), msg.uint("handle_id")
+
+	if !known(verb, adminGlobalRequests, adminSessionRequests, adminHandleRequests) {
+		return errorResponse(sessionID, transaction, newError(ErrorUnknownRequest, "Unknown request '%s'", verb))
+	}
+	if sessionID == 0 && handleID == 0 {
+		switch verb {
+		case "info":
+			return serverInfo(transaction)
+		case "ping":
+			return map[string]interface{}{"janus": "pong", "transaction": transaction}
+		}
+	}
+	if err := authorizeAdmin(msg); err != nil {
+		return errorResponse(sessionID, transaction, err)
+	}
+
+	if sessionID == 0 && handleID == 0 {
+		if !known(verb, adminGlobalRequests) {
+			return errorResponse(0, transaction, newError(ErrorInvalidRequestPath, "Unhandled request '%s' at this path", verb))
+		}
+		return adminGlobalRequest(verb, transaction, msg)
+	}
+	if sessionID == 0 {
+		return errorResponse(0, transaction, newError(ErrorSessionNotFound, "Invalid session"))
+	}
+	s := session.Find(sessionID)
+	if s == nil || s.Destroyed() {
+		return errorResponse(sessionID, transaction, newError(ErrorSessionNotFound, "No such session %d", sessionID))
+	}
+
+	if handleID == 0 {
+		switch verb {
+		case "list_handles":
+			var ids []uint64
+			for _, h := range s.Handles() {
+				ids = append(ids, h.ID)
+			}
+			sortIDs(ids)
+			return successResponse(sessionID, transaction, map[string]interface{}{"handles": ids})
+		case "destroy_session":
+			s.Destroy(false)
+			return successResponse(sessionID, transaction, nil)
+		}
+		return errorResponse(sessionID, transaction, newError(ErrorInvalidRequestPath, "Unhandled request '%s' at this path", verb))
+	}
+
+	if !known(verb, adminHandleRequests) {
+		return errorResponse(sessionID, transaction, newError(ErrorInvalidRequestPath, "Unhandled request '%s' at this path", verb))
+	}
+	h := s.Handle(handleID)
+	if h == nil {
+		return errorResponse(sessionID, transaction, newError(ErrorHandleNotFound, "No such handle %d in session %d", handleID, sessionID))
+	}
+	switch verb {
+	case "detach_handle":
+		if err := h.Detach(); err != nil {
+			return errorResponse(sessionID, transaction, newError(ErrorPluginDetach, "Couldn't detach from plugin: %v", err))
+		}
+		return successResponse(sessionID, transaction, nil)
+	case "hangup_webrtc":
+		h.Hangup("Admin API")
+		return successResponse(sessionID, transaction, nil)
+	}
+	return successResponse(sessionID, transaction, map[string]interface{}{
+		"handle_id": h.ID,
+		"info":      handleInfo(h),
+	})
+}
+
+func authorizeAdmin(msg message) *Error {
+	secret := config.Conf.General.Admin_secret
+	if secret == "" {
+		return nil
+	}
+	if err := msg.validate(param{"admin_secret", kindString, false}); err != nil {
+		return err
+	}
+	if !msg.has("admin_secret") || !secretMatches(msg.str("admin_secret"), secret) {
+		return newError(ErrorUnauthorized, "")
+	}
+	return nil
+}
+
+func sortIDs(ids []uint64) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
+
+func adminGlobalRequest(verb, transaction string, msg message) map[string]interface{} {
+	switch verb {
+	case "list_sessions":
+		ids := []uint64{}
+		for _, s := range session.List() {
+			ids = append(ids, s.ID)
+		}
+		sortIDs(ids)
+		return successResponse(0, transaction, map[string]interface{}{"sessions": ids})
+
+	case "set_log_level":
+		if err := msg.validate(param{"level", kindPositiveInteger, true}); err != nil {
+			return errorResponse(0, transaction, err)
+		}
+		level := msg.uint("level")
+		if level > 7 {
+			return errorResponse(0, transaction, newError(ErrorInvalidElementType, "Invalid element type (level should be between 0 and 7)"))
+		}
+		util.SetDebugLevel(int(level))
+		return successResponse(0, transaction, map[string]interface{}{"level": util.DebugLevel()})
+
+	case "set_session_timeout":
+		if err := msg.validate(param{"timeout", kindPositiveInteger, true}); err != nil {
+			return errorResponse(0, transaction, err)
+		}
+		timeout := msg.uint("timeout")
+		session.SetTimeout(time.Duration(timeout) * time.Second)
+		return successResponse(0, transaction, map[string]interface{}{"timeout": timeout})
+
+	case "accept_new_sessions":
+		if err := msg.validate(param{"accept", kindBool, true}); err != nil {
+			return errorResponse(0, transaction, err)
+		}
+		accept := msg.bool("accept")
+		if accept {
+			atomic.StoreInt32(&acceptingSessions, 1)
+		} else {
+			atomic.StoreInt32(&acceptingSessions, 0)
+		}
+		return successResponse(0, transaction, map[string]interface{}{"accept": accept})
+
+	case "add_token":
+		return addToken(transaction, msg)
+
+	case "list_tokens":
+		list, err := ListTokens()
+		if err != nil {
+			return errorResponse(0, transaction, newError(ErrorUnknown, "Stored-Token based authentication disabled"))
+		}
+		tokens := []interface{}{}
+		for token, allowed := range list {
+			if allowed == nil {
+				allowed = pluginPackages()
+			}
+			tokens = append(tokens, map[string]interface{}{"token": token, "allowed_plugins": allowed})
+		}
+		return successResponse(0, transaction, map[string]interface{}{
+			"data": map[string]interface{}{"tokens": tokens},
+		})
+
+	case "remove_token":
+		if err := msg.validate(param{"token", kindString, true}); err != nil {
+			return errorResponse(0, transaction, err)
+		}
+		switch RemoveToken(msg.str("token")) {
+		case nil:
+			return successResponse(0, transaction, nil)
+		case ErrTokenNotFound:
+			return errorResponse(0, transaction, newError(ErrorTokenNotFound, "Error removing token"))
+		}
+		return errorResponse(0, transaction, newError(ErrorUnknown, "Stored-Token based authentication disabled"))
+
+	case "query_eventhandler":
+		if err := msg.validate(
+			param{"handler", kindString, true},
+			param{"request", kindObject, true},
+		); err != nil {
+			return errorResponse(0, transaction, err)
+		}
+		h := events.Find(msg.str("handler"))
+		if h == nil || !events.Enabled() {
+			return errorResponse(0, transaction, newError(ErrorPluginNotFound, "Invalid event handler"))
+		}
+		response, err := h.HandleRequest(msg["request"])
+		if err != nil {
+			return errorResponse(0, transaction, newError(ErrorUnknown, "%v", err))
+		}
+		return successResponse(0, transaction, map[string]interface{}{"response": response})
+	}
+
+	// message_plugin
+	if err := msg.validate(
+		param{"plugin", kindString, true},
+		param{"request", kindObject, true},
+	); err != nil {
+		return errorResponse(0, transaction, err)
+	}
+	pkg := msg.str("plugin")
+	p := plugins.Find(pkg)
+	if p == nil {
+		return errorResponse(0, transaction, newError(ErrorPluginNotFound, "No such plugin '%s'", pkg))
+	}
+	handler, ok := p.(plugins.AdminMessageHandler)
+	if !ok {
+		return errorResponse(0, transaction, newError(ErrorUnknown, "Plugin doesn't support Admin API messages"))
+	}
+	response := handler.HandleAdminMessage(msg["request"])
+	if response == nil {
+		return errorResponse(0, transaction, newError(ErrorPluginMessage, "Plugin didn't provide any response"))
+	}
+	return successResponse(0, transaction, map[string]interface{}{"response": response})
+}
+
+func pluginPackages() []string {
+	list := []string{}
+	for _, p := range plugins.List() {
+		list = append(list, p.Package())
+	}
+	sort.Strings(list)
+	return list
+}
+
+func addToken(transaction string, msg message) map[string]interface{} {
+	if err := msg.validate(
+		param{"token", kindString, true},
+		param{"plugins", kindArray, false},
+	); err != nil {
+		return errorResponse(0, transaction, err)
+	}
+	var allowed []string
+	if msg.has("plugins") {
+		if err := json.Unmarshal(msg["plugins"], &allowed); err != nil {
+			return errorResponse(0, transaction, newError(ErrorInvalidElementType, "Invalid element in the allowed plugins array"))
+		}
+		for _, pkg := range allowed {
+			if plugins.Find(pkg) == nil {
+				return errorResponse(0, transaction, newError(ErrorPluginNotFound, "No such plugin '%s'", pkg))
+			}
+		}
+	}
+	if err := AddToken(msg.str("token"), allowed); err != nil {
+		return errorResponse(0, transaction, newError(ErrorUnknown, "Stored-Token based authentication disabled"))
+	}
+	if len(allowed) == 0 {
+		allowed = pluginPackages()
+	}
+	return successResponse(0, transaction, map[string]interface{}{
+		"data": map[string]interface{}{"plugins": allowed},
+	})
+}
+
+// handleInfo collects everything we know about a handle
+func handleInfo(h *session.Handle) map[string]interface{} {
+	s := h.Session
+	info := map[string]interface{}{
+		"session_id":            s.ID,
+		"session_last_activity": s.LastActivity().UnixNano() / int64(time.Microsecond),
+		"handle_id":             h.ID,
+		"created":               h.Created.UnixNano() / int64(time.Microsecond),
+		"current_time":          time.Now().UnixNano() / int64(time.Microsecond),
+		"loop_index":            h.LoopIndex(),
+		"plugin":                h.Plugin.Package(),
+		"state":                 h.State().String(),
+		"pending-trickles":      h.PendingTrickles(),
+		"webrtc":                h.PeerConnectionInfo(),
+	}
+	if h.OpaqueID != "" {
+		info["opaque_id"] = h.OpaqueID
+	}
+	if reason := h.HangupReason(); reason != "" {
+		info["hangup_reason"] = reason
+	}
+	if specific := h.Plugin.QuerySession(h.PluginSession); specific != nil {
+		info["plugin_specific"] = specific
+	}
+	return info
+}
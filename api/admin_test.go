@@ -0,0 +1,62 @@
+package api
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/session"
+)
+
+// testPeerConnection only describes itself
+type testPeerConnection struct {
+	closed bool
+}
+
+func (pc *testPeerConnection) SendRTP(packet *plugins.RTPPacket) error   { return nil }
+func (pc *testPeerConnection) SendRTCP(packet *plugins.RTCPPacket) error { return nil }
+func (pc *testPeerConnection) SendData(packet *plugins.DataPacket) error { return nil }
+func (pc *testPeerConnection) Close()                                    { pc.closed = true }
+
+func (pc *testPeerConnection) Info() *session.PeerConnectionInfo {
+	return &session.PeerConnectionInfo{
+		ICE:   map[string]interface{}{"state": "connected"},
+		DTLS:  map[string]interface{}{"state": "connected", "role": "server"},
+		Media: []map[string]interface{}{{"mid": "0", "type": "audio"}},
+	}
+}
+
+func TestHandleInfoWebRTC(t *testing.T) {
+	tt := newTestTransport()
+	sessionID, handleID := newTestHandle(t, tt)
+	request := `{"janus":"handle_info","transaction":"t","session_id":` + strconv.FormatUint(sessionID, 10) + `,"handle_id":` + strconv.FormatUint(handleID, 10) + `}`
+	webrtc := func() map[string]interface{} {
+		response := tt.request(t, true, request)
+		info, _ := response["info"].(map[string]interface{})
+		section, _ := info["webrtc"].(map[string]interface{})
+		if section == nil {
+			t.Fatalf("no webrtc section in %v", response)
+		}
+		return section
+	}
+
+	want := map[string]interface{}{
+		"ice":   map[string]interface{}{"state": "none"},
+		"dtls":  map[string]interface{}{"state": "none"},
+		"media": []interface{}{},
+	}
+	if got := webrtc(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v without a PeerConnection, want %v", got, want)
+	}
+
+	session.Find(sessionID).Handle(handleID).SetPeerConnection(&testPeerConnection{})
+	want = map[string]interface{}{
+		"ice":   map[string]interface{}{"state": "connected"},
+		"dtls":  map[string]interface{}{"state": "connected", "role": "server"},
+		"media": []interface{}{map[string]interface{}{"mid": "0", "type": "audio"}},
+	}
+	if got := webrtc(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,496 @@
+package api
+
+// The Janus API router: transports pass here whatever requests they get,
+// no matter how (HTTP, WebSockets, ...), and get back the responses to send
+// to the client through the session.Transport interface. The requests,
+// responses and error codes are the same as the original Janus, so that
+// janus.js and the other existing clients work with go-janus unchanged.
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/events"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/session"
+	"github.com/xroger88/go-janus/webrtc"
+)
+
+// requests that don't need a session, and the ones that need a handle
+var (
+	globalRequests  = []string{"info", "ping", "create"}
+	sessionRequests = []string{"keepalive", "attach", "destroy", "claim"}
+	handleRequests  = []string{"message", "trickle", "detach", "hangup"}
+)
+
+func known(verb string, lists ...[]string) bool {
+	for _, list := range lists {
+		for _, v := range list {
+			if v == verb {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IncomingRequest handles a request a transport got from a client. The
+// response is sent back with the SendMessage of the source transport,
+// together with requestID, which is only meaningful to the transport.
+func IncomingRequest(source *session.Source, requestID interface{}, admin bool, data []byte) {
+	var response map[string]interface{}
+	msg, err := parseMessage(data)
+	if err != nil {
+		response = errorResponse(0, "", err)
+	} else if admin {
+		response = handleAdminRequest(msg)
+	} else {
+		response = handleRequest(source, msg)
+	}
+	reply(source, requestID, admin, response)
+}
+
+func reply(source *session.Source, requestID interface{}, admin bool, response map[string]interface{}) {
+	if source == nil || source.Transport == nil {
+		log.Warnf("No transport to send the response to: %v", response)
+		return
+	}
+	if err := source.Transport.SendMessage(source.Instance, requestID, admin, response); err != nil {
+		log.Warnf("Error sending response: %v", err)
+	}
+}
+
+// ErrorResponse builds an error response, for transports that need to reject requests on their own
+// (an empty reason means the generic description of the code)
+func ErrorResponse(sessionID uint64, transaction string, code int, reason string) map[string]interface{} {
+	if reason == "" {
+		return errorResponse(sessionID, transaction, newError(code, ""))
+	}
+	return errorResponse(sessionID, transaction, newError(code, "%s", reason))
+}
+
+func errorResponse(sessionID uint64, transaction string, err *Error) map[string]interface{} {
+	log.Debugf("Returning Janus API error %d (%s)", err.Code, err.Reason)
+	response := map[string]interface{}{
+		"janus": "error",
+		"error": map[string]interface{}{
+			"code":   err.Code,
+			"reason": err.Reason,
+		},
+	}
+	if sessionID > 0 {
+		response["session_id"] = sessionID
+	}
+	if transaction != "" {
+		response["transaction"] = transaction
+	}
+	return response
+}
+
+func successResponse(sessionID uint64, transaction string, fields map[string]interface{}) map[string]interface{} {
+	response := map[string]interface{}{
+		"janus":       "success",
+		"transaction": transaction,
+	}
+	if sessionID > 0 {
+		response["session_id"] = sessionID
+	}
+	for k, v := range fields {
+		response[k] = v
+	}
+	return response
+}
+
+func handleRequest(source *session.Source, msg message) map[string]interface{} {
+	transaction := msg.str("transaction")
+	if err := msg.validate(
+		param{"transaction", kindString, true},
+		param{"janus", kindString, true},
+		param{"session_id", kindPositiveInteger, false},
+		param{"handle_id", kindPositiveInteger, false},
+	); err != nil {
+		return errorResponse(msg.uint("session_id"), transaction, err)
+	}
+	verb := strings.ToLower(msg.str("janus"))
+	sessionID, handleID := msg.uint("session_id"), msg.uint("handle_id")
+
+	if !known(verb, globalRequests, sessionRequests, handleRequests) {
+		return errorResponse(sessionID, transaction, newError(ErrorUnknownRequest, "Unknown request '%s'", verb))
+	}
+	if sessionID == 0 && handleID == 0 {
+		if !known(verb, globalRequests) {
+			return errorResponse(0, transaction, newError(ErrorInvalidRequestPath, "Unhandled request '%s' at this path", verb))
+		}
+		switch verb {
+		case "info":
+			return serverInfo(transaction)
+		case "ping":
+			return map[string]interface{}{"janus": "pong", "transaction": transaction}
+		}
+		if _, err := authorize(msg); err != nil {
+			return errorResponse(0, transaction, err)
+		}
+		return createSession(source, transaction, msg)
+	}
+	if sessionID == 0 {
+		return errorResponse(0, transaction, newError(ErrorSessionNotFound, "Invalid session"))
+	}
+	if known(verb, globalRequests) {
+		return errorResponse(sessionID, transaction, newError(ErrorInvalidRequestPath, "Unhandled request '%s' at this path", verb))
+	}
+
+	s := session.Find(sessionID)
+	if s == nil || s.Destroyed() {
+		return errorResponse(sessionID, transaction, newError(ErrorSessionNotFound, "No such session %d", sessionID))
+	}
+	tokenOnly, err := authorize(msg)
+	if err != nil {
+		return errorResponse(sessionID, transaction, err)
+	}
+	s.Touch()
+
+	if handleID == 0 {
+		if !known(verb, sessionRequests) {
+			return errorResponse(sessionID, transaction, newError(ErrorInvalidRequestPath, "Unhandled request '%s' at this path", verb))
+		}
+		switch verb {
+		case "keepalive":
+			return map[string]interface{}{"janus": "ack", "session_id": sessionID, "transaction": transaction}
+		case "attach":
+			return attach(s, transaction, msg, tokenOnly)
+		case "destroy":
+			s.Destroy(false)
+			return successResponse(sessionID, transaction, nil)
+		}
+		s.Claim(source)
+		return successResponse(sessionID, transaction, nil)
+	}
+
+	if !known(verb, handleRequests) {
+		return errorResponse(sessionID, transaction, newError(ErrorInvalidRequestPath, "Unhandled request '%s' at this path", verb))
+	}
+	h := s.Handle(handleID)
+	if h == nil {
+		return errorResponse(sessionID, transaction, newError(ErrorHandleNotFound, "No such handle %d in session %d", handleID, sessionID))
+	}
+	switch verb {
+	case "detach":
+		if err := h.Detach(); err != nil {
+			return errorResponse(sessionID, transaction, newError(ErrorPluginDetach, "Couldn't detach from plugin: %v", err))
+		}
+		return successResponse(sessionID, transaction, nil)
+	case "hangup":
+		h.Hangup("Janus API")
+		return successResponse(sessionID, transaction, nil)
+	case "trickle":
+		return trickle(h, transaction, msg)
+	}
+	return pluginMessage(h, transaction, msg)
+}
+
+func createSession(source *session.Source, transaction string, msg message) map[string]interface{} {
+	if err := msg.validate(param{"id", kindPositiveInteger, false}); err != nil {
+		return errorResponse(0, transaction, err)
+	}
+	if !AcceptingSessions() {
+		return errorResponse(0, transaction, newError(ErrorNotAcceptingSessions, ""))
+	}
+	s, err := session.New(source, msg.uint("id"))
+	if err != nil {
+		return errorResponse(0, transaction, newError(ErrorSessionConflict, "Session ID already in use"))
+	}
+	return successResponse(0, transaction, map[string]interface{}{
+		"data": map[string]interface{}{"id": s.ID},
+	})
+}
+
+// attach creates a new handle; if the request was authorized by its token
+// only, the token must also be allowed to access the plugin
+func attach(s *session.Session, transaction string, msg message, tokenOnly bool) map[string]interface{} {
+	if err := msg.validate(
+		param{"plugin", kindString, true},
+		param{"opaque_id", kindString, false},
+		param{"loop_index", kindPositiveInteger, false},
+	); err != nil {
+		return errorResponse(s.ID, transaction, err)
+	}
+	pkg := msg.str("plugin")
+	p := plugins.Find(pkg)
+	if p == nil {
+		return errorResponse(s.ID, transaction, newError(ErrorPluginNotFound, "No such plugin '%s'", pkg))
+	}
+	if tokenOnly && !CheckTokenPlugin(msg.str("token"), pkg) {
+		return errorResponse(s.ID, transaction, newError(ErrorUnauthorizedPlugin, "Provided token can't access plugin '%s'", pkg))
+	}
+	loopIndex := -1
+	if msg.has("loop_index") {
+		loopIndex = int(msg.uint("loop_index"))
+	}
+	h, err := s.Attach(p, msg.str("opaque_id"), loopIndex)
+	if err != nil {
+		return errorResponse(s.ID, transaction, newError(ErrorPluginAttach, "Couldn't attach to plugin: %v", err))
+	}
+	return successResponse(s.ID, transaction, map[string]interface{}{
+		"data": map[string]interface{}{"id": h.ID},
+	})
+}
+
+// parseJSEP validates the jsep object of a request (or of a plugin event)
+func parseJSEP(raw json.RawMessage) (*plugins.JSEP, *Error) {
+	var msg message
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return nil, newError(ErrorInvalidJSONObject, "Invalid jsep object")
+	}
+	if err := msg.validate(
+		param{"type", kindString, true},
+		param{"sdp", kindString, true},
+		param{"trickle", kindBool, false},
+	); err != nil {
+		return nil, err
+	}
+	jsep := &plugins.JSEP{Type: strings.ToLower(msg.str("type")), SDP: msg.str("sdp")}
+	if msg.has("trickle") {
+		trickle := msg.bool("trickle")
+		jsep.Trickle = &trickle
+	}
+	if jsep.Type != "offer" && jsep.Type != "answer" {
+		return nil, newError(ErrorJSEPUnknownType, "JSEP error: unknown message type '%s'", jsep.Type)
+	}
+	if !strings.HasPrefix(strings.TrimSpace(jsep.SDP), "v=") {
+		return nil, newError(ErrorJSEPInvalidSDP, "JSEP error: invalid SDP")
+	}
+	return jsep, nil
+}
+
+// startNegotiation moves the handle to the negotiating state when an offer
+// or answer goes through, unless a PeerConnection is already being set up
+func startNegotiation(h *session.Handle) {
+	switch h.State() {
+	case session.StateAttached, session.StateHangup:
+		h.SetState(session.StateNegotiating)
+	}
+}
+
+func pluginMessage(h *session.Handle, transaction string, msg message) map[string]interface{} {
+	sessionID := h.Session.ID
+	if err := msg.validate(
+		param{"body", kindObject, true},
+		param{"jsep", kindObject, false},
+	); err != nil {
+		return errorResponse(sessionID, transaction, err)
+	}
+	var jsep *plugins.JSEP
+	if msg.has("jsep") {
+		var err *Error
+		if jsep, err = parseJSEP(msg["jsep"]); err != nil {
+			return errorResponse(sessionID, transaction, err)
+		}
+		// the plugin gets the SDP without the ICE and DTLS attributes
+		stripped, werr := webrtc.Remote(h, jsep)
+		switch {
+		case werr == webrtc.ErrUnexpectedAnswer:
+			return errorResponse(sessionID, transaction, newError(ErrorUnexpectedAnswer, ""))
+		case werr != nil:
+			return errorResponse(sessionID, transaction, newError(ErrorJSEPInvalidSDP, "JSEP error: %v", werr))
+		}
+		jsep = stripped
+		startNegotiation(h)
+	}
+
+	result := h.Plugin.HandleMessage(h.PluginSession, transaction, msg["body"], jsep)
+	if result == nil {
+		return errorResponse(sessionID, transaction, newError(ErrorPluginMessage, "Plugin didn't give a result"))
+	}
+	switch result.Type {
+	case plugins.ResultOK:
+		if result.Content == nil {
+			return errorResponse(sessionID, transaction, newError(ErrorPluginMessage, "Plugin didn't provide any content for this synchronous response"))
+		}
+		return successResponse(sessionID, transaction, map[string]interface{}{
+			"sender": h.ID,
+			"plugindata": map[string]interface{}{
+				"plugin": h.Plugin.Package(),
+				"data":   result.Content,
+			},
+		})
+	case plugins.ResultOKWait:
+		response := map[string]interface{}{"janus": "ack", "session_id": sessionID, "transaction": transaction}
+		if result.Text != "" {
+			response["hint"] = result.Text
+		}
+		return response
+	}
+	reason := result.Text
+	if reason == "" {
+		reason = "Plugin returned a severe (unrecoverable) error"
+	}
+	return errorResponse(sessionID, transaction, newError(ErrorPluginMessage, "%s", reason))
+}
+
+func trickle(h *session.Handle, transaction string, msg message) map[string]interface{} {
+	sessionID := h.Session.ID
+	if err := msg.validate(
+		param{"candidate", kindObject, false},
+		param{"candidates", kindArray, false},
+	); err != nil {
+		return errorResponse(sessionID, transaction, err)
+	}
+	var candidates []json.RawMessage
+	switch {
+	case msg.has("candidate") && msg.has("candidates"):
+		return errorResponse(sessionID, transaction, newError(ErrorInvalidJSON, "Can't have both candidate and candidates"))
+	case msg.has("candidate"):
+		candidates = append(candidates, msg["candidate"])
+	case msg.has("candidates"):
+		json.Unmarshal(msg["candidates"], &candidates)
+	default:
+		return errorResponse(sessionID, transaction, newError(ErrorMissingMandatoryElem, "Missing mandatory element (candidate|candidates)"))
+	}
+	for _, raw := range candidates {
+		if err := validateCandidate(raw); err != nil {
+			return errorResponse(sessionID, transaction, err)
+		}
+	}
+	if err := h.Trickle(candidates...); err != nil {
+		return errorResponse(sessionID, transaction, newError(ErrorWebRTCState, "%v", err))
+	}
+	webrtc.Trickle(h)
+	return map[string]interface{}{"janus": "ack", "session_id": sessionID, "transaction": transaction}
+}
+
+// validateCandidate checks a trickled candidate is either a real one or the end-of-candidates marker
+func validateCandidate(raw json.RawMessage) *Error {
+	var candidate message
+	if err := json.Unmarshal(raw, &candidate); err != nil || candidate == nil {
+		return newError(ErrorInvalidElementType, "Invalid element type (candidate should be an object)")
+	}
+	if candidate.has("completed") {
+		if err := candidate.validate(param{"completed", kindBool, true}); err != nil {
+			return err
+		}
+		if !candidate.bool("completed") {
+			return newError(ErrorInvalidElementType, "Invalid element type (completed should be true)")
+		}
+		return nil
+	}
+	return candidate.validate(
+		param{"candidate", kindString, true},
+		param{"sdpMid", kindString, false},
+		param{"sdpMLineIndex", kindPositiveInteger, false},
+	)
+}
+
+// gateway is what plugins get as their core callbacks
+type gateway struct{}
+
+// Callbacks is passed to the plugins at init time
+var Callbacks plugins.Callbacks = gateway{}
+
+// handleOf returns the handle behind a plugin session, unless it's gone
+func handleOf(ps *plugins.PluginSession) *session.Handle {
+	if ps == nil || ps.Stopped() {
+		return nil
+	}
+	h, _ := ps.Gateway.(*session.Handle)
+	return h
+}
+
+func (gateway) PushEvent(ps *plugins.PluginSession, plugin plugins.Plugin, transaction string, message interface{}, jsep *plugins.JSEP) error {
+	h := handleOf(ps)
+	if h == nil {
+		return fmt.Errorf("invalid plugin session")
+	}
+	if message == nil {
+		return fmt.Errorf("no message to push")
+	}
+	event := map[string]interface{}{
+		"janus":      "event",
+		"session_id": h.Session.ID,
+		"sender":     h.ID,
+		"plugindata": map[string]interface{}{
+			"plugin": plugin.Package(),
+			"data":   message,
+		},
+	}
+	if transaction != "" {
+		event["transaction"] = transaction
+	}
+	if jsep != nil {
+		if jsep.Type != "offer" && jsep.Type != "answer" {
+			return fmt.Errorf("unknown JSEP type '%s'", jsep.Type)
+		}
+		// the client gets the SDP with the ICE and DTLS attributes
+		merged, err := webrtc.Local(h, jsep)
+		if err != nil {
+			return err
+		}
+		startNegotiation(h)
+		event["jsep"] = merged
+	}
+	h.Session.Notify(event)
+	return nil
+}
+
+func (gateway) RelayRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	if h := handleOf(ps); h != nil && packet != nil {
+		h.RelayRTP(packet)
+	}
+}
+
+func (gateway) RelayRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {
+	if h := handleOf(ps); h != nil && packet != nil {
+		h.RelayRTCP(packet)
+	}
+}
+
+func (gateway) RelayData(ps *plugins.PluginSession, packet *plugins.DataPacket) {
+	if h := handleOf(ps); h != nil && packet != nil {
+		h.RelayData(packet)
+	}
+}
+
+func (gateway) ClosePC(ps *plugins.PluginSession) {
+	if h := handleOf(ps); h != nil {
+		h.Hangup("Close PC")
+	}
+}
+
+func (gateway) EndSession(ps *plugins.PluginSession) {
+	if h := handleOf(ps); h != nil {
+		// not from here, the plugin may be holding its own locks
+		go h.Detach()
+	}
+}
+
+func (gateway) EventsIsEnabled() bool {
+	return events.Enabled()
+}
+
+func (gateway) NotifyEvent(plugin plugins.Plugin, ps *plugins.PluginSession, event interface{}) {
+	if !events.Enabled() || plugin == nil || event == nil {
+		return
+	}
+	e := map[string]interface{}{
+		"type":      events.TypePlugin,
+		"timestamp": time.Now().UnixNano() / int64(time.Microsecond),
+		"event": map[string]interface{}{
+			"plugin": plugin.Package(),
+			"data":   event,
+		},
+	}
+	if ps != nil {
+		h := handleOf(ps)
+		if h == nil {
+			return
+		}
+		e["session_id"] = h.Session.ID
+		e["handle_id"] = h.ID
+		if h.OpaqueID != "" {
+			e["opaque_id"] = h.OpaqueID
+		}
+	}
+	events.Notify(e)
+}
@@ -0,0 +1,334 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/session"
+)
+
+// testTransport collects what the core sends back: responses to requests
+// (which carry a request ID) and events (which don't)
+type testTransport struct {
+	responses chan map[string]interface{}
+	events    chan map[string]interface{}
+}
+
+func newTestTransport() *testTransport {
+	return &testTransport{
+		responses: make(chan map[string]interface{}, 16),
+		events:    make(chan map[string]interface{}, 16),
+	}
+}
+
+func (t *testTransport) SendMessage(instance interface{}, requestID interface{}, admin bool, message map[string]interface{}) error {
+	// go through JSON, as a real transport would, so that numbers look the same everywhere
+	data, _ := json.Marshal(message)
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	if requestID == nil {
+		t.events <- decoded
+	} else {
+		t.responses <- decoded
+	}
+	return nil
+}
+
+func (t *testTransport) SessionCreated(instance interface{}, sessionID uint64)                     {}
+func (t *testTransport) SessionOver(instance interface{}, sessionID uint64, timeout, claimed bool) {}
+func (t *testTransport) SessionClaimed(instance interface{}, sessionID uint64)                     {}
+
+// request sends a request the way a transport would and returns the response
+func (t *testTransport) request(tb testing.TB, admin bool, request string) map[string]interface{} {
+	tb.Helper()
+	IncomingRequest(&session.Source{Transport: t, Instance: t}, 1, admin, []byte(request))
+	select {
+	case response := <-t.responses:
+		return response
+	default:
+		tb.Fatalf("no response to %s", request)
+		return nil
+	}
+}
+
+// event waits for the next event sent to the client
+func (t *testTransport) event(tb testing.TB) map[string]interface{} {
+	tb.Helper()
+	select {
+	case event := <-t.events:
+		return event
+	case <-time.After(time.Second):
+		tb.Fatal("no event")
+		return nil
+	}
+}
+
+// testPlugin answers messages according to their "result": "ok", "empty"
+// (ok without content), "wait", "error" or "nil"
+type testPlugin struct {
+	plugins.Plugin
+}
+
+const testPackage = "janus.plugin.apitest"
+
+func (testPlugin) Package() string                                { return testPackage }
+func (testPlugin) Name() string                                   { return "API test" }
+func (testPlugin) Description() string                            { return "" }
+func (testPlugin) Author() string                                 { return "" }
+func (testPlugin) Version() int                                   { return 1 }
+func (testPlugin) VersionString() string                          { return "0.0.1" }
+func (testPlugin) CreateSession(ps *plugins.PluginSession) error  { return nil }
+func (testPlugin) DestroySession(ps *plugins.PluginSession) error { return nil }
+func (testPlugin) HangupMedia(ps *plugins.PluginSession)          {}
+func (testPlugin) QuerySession(ps *plugins.PluginSession) interface{} {
+	return map[string]interface{}{"test": true}
+}
+
+func (testPlugin) HandleMessage(ps *plugins.PluginSession, transaction string, message json.RawMessage, jsep *plugins.JSEP) *plugins.Result {
+	var body struct{ Result string }
+	json.Unmarshal(message, &body)
+	switch body.Result {
+	case "ok":
+		return &plugins.Result{Type: plugins.ResultOK, Content: map[string]interface{}{"echo": true}}
+	case "empty":
+		return &plugins.Result{Type: plugins.ResultOK}
+	case "wait":
+		return &plugins.Result{Type: plugins.ResultOKWait, Text: "hold on"}
+	case "error":
+		return &plugins.Result{Type: plugins.ResultError, Text: "nope"}
+	}
+	return nil
+}
+
+func init() {
+	plugins.Register(testPlugin{})
+}
+
+// errorCode returns the code of an error response, or 0 if it isn't one
+func errorCode(response map[string]interface{}) int {
+	if response["janus"] != "error" {
+		return 0
+	}
+	code, _ := response["error"].(map[string]interface{})["code"].(float64)
+	return int(code)
+}
+
+func id(response map[string]interface{}) uint64 {
+	data, _ := response["data"].(map[string]interface{})
+	id, _ := data["id"].(float64)
+	return uint64(id)
+}
+
+// newTestHandle creates a session and attaches a handle to the test plugin
+func newTestHandle(t *testing.T, tt *testTransport) (uint64, uint64) {
+	t.Helper()
+	created := tt.request(t, false, `{"janus":"create","transaction":"c"}`)
+	sessionID := id(created)
+	if sessionID == 0 {
+		t.Fatalf("create failed: %v", created)
+	}
+	t.Cleanup(func() {
+		if s := session.Find(sessionID); s != nil {
+			s.Destroy(false)
+		}
+	})
+	attached := tt.request(t, false, `{"janus":"attach","transaction":"a","session_id":`+strconv.FormatUint(sessionID, 10)+`,"plugin":"`+testPackage+`"}`)
+	handleID := id(attached)
+	if handleID == 0 {
+		t.Fatalf("attach failed: %v", attached)
+	}
+	return sessionID, handleID
+}
+
+// testSDP is what a browser would offer for audio, without candidates
+const testSDP = "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\na=group:BUNDLE 0\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\nc=IN IP4 0.0.0.0\r\na=mid:0\r\na=sendrecv\r\na=rtcp-mux\r\na=rtpmap:111 opus/48000/2\r\n" +
+	"a=ice-ufrag:test\r\na=ice-pwd:testtesttesttesttesttest\r\na=setup:actpass\r\n" +
+	"a=fingerprint:sha-256 AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB\r\n"
+
+func TestRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string // {session} and {handle} stand for the IDs of a live session and handle
+		janus   string // the kind of response expected, unless code is set
+		code    int    // the error code expected
+	}{
+		// parsing and validation of the request itself
+		{"empty", ``, "", ErrorMissingRequest},
+		{"not json", `{"janus":`, "", ErrorInvalidJSON},
+		{"not an object", `["janus"]`, "", ErrorInvalidJSONObject},
+		{"null", `null`, "", ErrorInvalidJSONObject},
+		{"no transaction", `{"janus":"ping"}`, "", ErrorMissingMandatoryElem},
+		{"no verb", `{"transaction":"t"}`, "", ErrorMissingMandatoryElem},
+		{"verb not a string", `{"janus":1,"transaction":"t"}`, "", ErrorInvalidElementType},
+		{"transaction not a string", `{"janus":"ping","transaction":1}`, "", ErrorInvalidElementType},
+		{"session not a number", `{"janus":"keepalive","transaction":"t","session_id":"1"}`, "", ErrorInvalidElementType},
+		{"negative handle", `{"janus":"detach","transaction":"t","session_id":{session},"handle_id":-1}`, "", ErrorInvalidElementType},
+		{"unknown verb", `{"janus":"dance","transaction":"t"}`, "", ErrorUnknownRequest},
+
+		// verbs and paths
+		{"ping", `{"janus":"ping","transaction":"t"}`, "pong", 0},
+		{"ping is case insensitive", `{"janus":"PING","transaction":"t"}`, "pong", 0},
+		{"info", `{"janus":"info","transaction":"t"}`, "server_info", 0},
+		{"session verb at root", `{"janus":"keepalive","transaction":"t"}`, "", ErrorInvalidRequestPath},
+		{"handle verb at root", `{"janus":"message","transaction":"t"}`, "", ErrorInvalidRequestPath},
+		{"handle without session", `{"janus":"message","transaction":"t","handle_id":{handle}}`, "", ErrorSessionNotFound},
+		{"no such session", `{"janus":"keepalive","transaction":"t","session_id":1}`, "", ErrorSessionNotFound},
+		{"global verb at session", `{"janus":"create","transaction":"t","session_id":{session}}`, "", ErrorInvalidRequestPath},
+		{"info at session", `{"janus":"info","transaction":"t","session_id":{session}}`, "", ErrorInvalidRequestPath},
+		{"handle verb at session", `{"janus":"message","transaction":"t","session_id":{session},"body":{}}`, "", ErrorInvalidRequestPath},
+		{"session verb at handle", `{"janus":"attach","transaction":"t","session_id":{session},"handle_id":{handle},"plugin":"` + testPackage + `"}`, "", ErrorInvalidRequestPath},
+		{"no such handle", `{"janus":"detach","transaction":"t","session_id":{session},"handle_id":1}`, "", ErrorHandleNotFound},
+		{"keepalive", `{"janus":"keepalive","transaction":"t","session_id":{session}}`, "ack", 0},
+		{"claim", `{"janus":"claim","transaction":"t","session_id":{session}}`, "success", 0},
+
+		// create and attach
+		{"create with a taken id", `{"janus":"create","transaction":"t","id":{session}}`, "", ErrorSessionConflict},
+		{"create with a bad id", `{"janus":"create","transaction":"t","id":"x"}`, "", ErrorInvalidElementType},
+		{"attach without plugin", `{"janus":"attach","transaction":"t","session_id":{session}}`, "", ErrorMissingMandatoryElem},
+		{"attach to unknown plugin", `{"janus":"attach","transaction":"t","session_id":{session},"plugin":"janus.plugin.nope"}`, "", ErrorPluginNotFound},
+		{"attach to a loop", `{"janus":"attach","transaction":"t","session_id":{session},"plugin":"` + testPackage + `","loop_index":0}`, "", ErrorPluginAttach},
+		{"attach", `{"janus":"attach","transaction":"t","session_id":{session},"plugin":"` + testPackage + `","opaque_id":"o"}`, "success", 0},
+
+		// messages to the plugin
+		{"message without body", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle}}`, "", ErrorMissingMandatoryElem},
+		{"body not an object", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":[]}`, "", ErrorInvalidElementType},
+		{"jsep not an object", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":{},"jsep":"x"}`, "", ErrorInvalidElementType},
+		{"jsep without sdp", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":{},"jsep":{"type":"offer"}}`, "", ErrorMissingMandatoryElem},
+		{"jsep of unknown type", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":{},"jsep":{"type":"pranswer","sdp":"v=0"}}`, "", ErrorJSEPUnknownType},
+		{"jsep with bad sdp", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":{},"jsep":{"type":"offer","sdp":"hello"}}`, "", ErrorJSEPInvalidSDP},
+		{"jsep without fingerprint", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":{},"jsep":{"type":"offer","sdp":"v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=ice-ufrag:a\r\na=ice-pwd:b\r\n"}}`, "", ErrorJSEPInvalidSDP},
+		{"answer without offer", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":{},"jsep":{"type":"answer","sdp":"` + strings.Replace(testSDP, "\r\n", `\r\n`, -1) + `"}}`, "", ErrorUnexpectedAnswer},
+		{"plugin responds", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":{"result":"ok"}}`, "success", 0},
+		{"plugin responds later", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":{"result":"wait"}}`, "ack", 0},
+		{"plugin fails", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":{"result":"error"}}`, "", ErrorPluginMessage},
+		{"plugin without result", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":{"result":"nil"}}`, "", ErrorPluginMessage},
+		{"plugin without content", `{"janus":"message","transaction":"t","session_id":{session},"handle_id":{handle},"body":{"result":"empty"}}`, "", ErrorPluginMessage},
+
+		// trickle
+		{"trickle without candidates", `{"janus":"trickle","transaction":"t","session_id":{session},"handle_id":{handle}}`, "", ErrorMissingMandatoryElem},
+		{"trickle with both", `{"janus":"trickle","transaction":"t","session_id":{session},"handle_id":{handle},"candidate":{"completed":true},"candidates":[]}`, "", ErrorInvalidJSON},
+		{"trickle a string", `{"janus":"trickle","transaction":"t","session_id":{session},"handle_id":{handle},"candidate":"x"}`, "", ErrorInvalidElementType},
+		{"trickle without candidate line", `{"janus":"trickle","transaction":"t","session_id":{session},"handle_id":{handle},"candidate":{"sdpMid":"0"}}`, "", ErrorMissingMandatoryElem},
+		{"trickle not completed", `{"janus":"trickle","transaction":"t","session_id":{session},"handle_id":{handle},"candidate":{"completed":false}}`, "", ErrorInvalidElementType},
+		{"trickle bad candidate in list", `{"janus":"trickle","transaction":"t","session_id":{session},"handle_id":{handle},"candidates":[{"candidate":"a"},{"candidate":1}]}`, "", ErrorInvalidElementType},
+		{"trickle", `{"janus":"trickle","transaction":"t","session_id":{session},"handle_id":{handle},"candidate":{"candidate":"candidate:1 1 udp 1 192.0.2.1 5000 typ host","sdpMid":"0","sdpMLineIndex":0}}`, "ack", 0},
+		{"trickle completed", `{"janus":"trickle","transaction":"t","session_id":{session},"handle_id":{handle},"candidate":{"completed":true}}`, "ack", 0},
+	}
+	tt := newTestTransport()
+	sessionID, handleID := newTestHandle(t, tt)
+	ids := strings.NewReplacer("{session}", strconv.FormatUint(sessionID, 10), "{handle}", strconv.FormatUint(handleID, 10))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := tt.request(t, false, ids.Replace(test.request))
+			if code := errorCode(response); code != test.code {
+				t.Fatalf("got error code %d, want %d: %v", code, test.code, response)
+			}
+			if test.code == 0 && response["janus"] != test.janus {
+				t.Fatalf("got %v response, want %s: %v", response["janus"], test.janus, response)
+			}
+			if test.request != "" && strings.Contains(test.request, `"transaction":"t"`) && response["transaction"] != "t" {
+				t.Errorf("got transaction %v, want t", response["transaction"])
+			}
+		})
+	}
+}
+
+func TestHandleFlow(t *testing.T) {
+	tt := newTestTransport()
+	sessionID, handleID := newTestHandle(t, tt)
+	h := session.Find(sessionID).Handle(handleID)
+	path := `"session_id":` + strconv.FormatUint(sessionID, 10) + `,"handle_id":` + strconv.FormatUint(handleID, 10)
+
+	// candidates wait until the WebRTC stack knows the ICE credentials of the client
+	trickle := `{"janus":"trickle","transaction":"t",` + path + `,"candidates":[{"candidate":"candidate:1 1 udp 1 192.0.2.1 5000 typ host"},{"completed":true}]}`
+	if response := tt.request(t, false, trickle); response["janus"] != "ack" {
+		t.Fatalf("got %v, want an ack", response)
+	}
+	if pending := h.PendingTrickles(); pending != 2 {
+		t.Fatalf("got %d pending candidates, want 2", pending)
+	}
+
+	// an offer starts the negotiation of a PeerConnection, which takes them
+	offer := `{"janus":"message","transaction":"m",` + path + `,"body":{"result":"wait"},"jsep":{"type":"OFFER","sdp":"` + strings.Replace(testSDP, "\r\n", `\r\n`, -1) + `"}}`
+	if response := tt.request(t, false, offer); response["janus"] != "ack" || response["hint"] != "hold on" {
+		t.Fatalf("got %v, want an ack with the hint", response)
+	}
+	if state := h.State(); state != session.StateNegotiating {
+		t.Fatalf("got state %s after an offer, want %s", state, session.StateNegotiating)
+	}
+	if pending := h.PendingTrickles(); pending != 0 {
+		t.Fatalf("got %d pending candidates after the offer, want 0", pending)
+	}
+
+	// events the plugin pushes reach the client, with the jsep
+	if err := Callbacks.PushEvent(h.PluginSession, testPlugin{}, "m", map[string]interface{}{"done": true}, &plugins.JSEP{Type: "answer", SDP: testSDP}); err != nil {
+		t.Fatal(err)
+	}
+	event := tt.event(t)
+	if event["janus"] != "event" || event["transaction"] != "m" || event["sender"] != float64(handleID) || event["jsep"] == nil {
+		t.Fatalf("got %v, want the plugin event with its jsep", event)
+	}
+	if data := event["plugindata"].(map[string]interface{}); data["plugin"] != testPackage {
+		t.Fatalf("got plugindata %v", data)
+	}
+	// with the ICE and DTLS parameters of the PeerConnection added
+	if answer := event["jsep"].(map[string]interface{})["sdp"].(string); !strings.Contains(answer, "a=ice-ufrag:") || !strings.Contains(answer, "a=fingerprint:sha-256 ") {
+		t.Fatalf("got answer %q, want it with our ICE and DTLS parameters", answer)
+	}
+
+	// hangup closes the PeerConnection but keeps the handle
+	if response := tt.request(t, false, `{"janus":"hangup","transaction":"h",`+path+`}`); response["janus"] != "success" {
+		t.Fatalf("got %v, want success", response)
+	}
+	if event := tt.event(t); event["janus"] != "hangup" || event["reason"] != "Janus API" {
+		t.Fatalf("got %v, want a hangup event", event)
+	}
+	if state := h.State(); state != session.StateHangup {
+		t.Fatalf("got state %s after hangup, want %s", state, session.StateHangup)
+	}
+
+	// detach gets rid of the handle
+	if response := tt.request(t, false, `{"janus":"detach","transaction":"d",`+path+`}`); response["janus"] != "success" {
+		t.Fatalf("got %v, want success", response)
+	}
+	if event := tt.event(t); event["janus"] != "detached" {
+		t.Fatalf("got %v, want a detached event", event)
+	}
+	if response := tt.request(t, false, `{"janus":"detach","transaction":"d",`+path+`}`); errorCode(response) != ErrorHandleNotFound {
+		t.Fatalf("got %v detaching twice, want error %d", response, ErrorHandleNotFound)
+	}
+
+	// and destroy of the session
+	destroy := `{"janus":"destroy","transaction":"x","session_id":` + strconv.FormatUint(sessionID, 10) + `}`
+	if response := tt.request(t, false, destroy); response["janus"] != "success" {
+		t.Fatalf("got %v, want success", response)
+	}
+	if response := tt.request(t, false, destroy); errorCode(response) != ErrorSessionNotFound {
+		t.Fatalf("got %v destroying twice, want error %d", response, ErrorSessionNotFound)
+	}
+}
+
+func TestNotAcceptingSessions(t *testing.T) {
+	tt := newTestTransport()
+	setAccepting(t, false)
+	if response := tt.request(t, false, `{"janus":"create","transaction":"t"}`); errorCode(response) != ErrorNotAcceptingSessions {
+		t.Fatalf("got %v, want error %d", response, ErrorNotAcceptingSessions)
+	}
+}
+
+// setAccepting changes whether new sessions are accepted for the duration of the test
+func setAccepting(t *testing.T, accepting bool) {
+	old := atomic.LoadInt32(&acceptingSessions)
+	value := int32(0)
+	if accepting {
+		value = 1
+	}
+	atomic.StoreInt32(&acceptingSessions, value)
+	t.Cleanup(func() { atomic.StoreInt32(&acceptingSessions, old) })
+}
@@ -0,0 +1,212 @@
+package api
+
+// Requests can be authenticated in two ways, same as the original Janus:
+//
+//   - a shared api_secret, that requests must carry as "apisecret"
+//   - token based authentication (token_auth), where requests carry a "token":
+//     tokens are either added/removed via the Admin API (stored tokens), or,
+//     if token_auth_secret is set, signed with HMAC-SHA1 by the application
+//     so that the core can verify them on its own (signed tokens)
+//
+// A request is authorized if either check passes. Tokens can also restrict
+// which plugins can be attached to.
+//
+// Signed tokens look like "<expiry>,janus,<plugin1>,<plugin2>:<signature>",
+// where expiry is a unix timestamp (seconds) and the signature is the base64
+// of the HMAC-SHA1 of everything before the colon, keyed with the secret.
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xroger88/go-janus/config"
+)
+
+const tokenRealm = "janus"
+
+var (
+	ErrTokenAuthDisabled = errors.New("token based authentication is disabled")
+	ErrSignedTokens      = errors.New("stored tokens can't be used with signed tokens")
+	ErrTokenNotFound     = errors.New("token not found")
+)
+
+var (
+	tokensMutex sync.RWMutex
+	// stored tokens and the plugins they can attach to (nil means any plugin)
+	tokens = make(map[string]map[string]bool)
+)
+
+// secretMatches compares two secrets in constant time
+func secretMatches(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// TokenAuthEnabled tells whether requests must carry a valid token
+func TokenAuthEnabled() bool {
+	return config.Conf.General.Token_auth
+}
+
+// SignedTokens tells whether tokens are signed with token_auth_secret, rather than stored
+func SignedTokens() bool {
+	return TokenAuthEnabled() && config.Conf.General.Token_auth_secret != ""
+}
+
+// checkSignature verifies a signed token, returning the plugins it gives access to
+func checkSignature(token string) ([]string, bool) {
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	mac := hmac.New(sha1.New, []byte(config.Conf.General.Token_auth_secret))
+	mac.Write([]byte(parts[0]))
+	signature, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, false
+	}
+	data := strings.Split(parts[0], ",")
+	if len(data) < 2 || data[1] != tokenRealm {
+		return nil, false
+	}
+	expiry, err := strconv.ParseInt(data[0], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return nil, false
+	}
+	return data[2:], true
+}
+
+// CheckToken tells whether a token is valid
+func CheckToken(token string) bool {
+	if !TokenAuthEnabled() || token == "" {
+		return false
+	}
+	if SignedTokens() {
+		_, ok := checkSignature(token)
+		return ok
+	}
+	tokensMutex.RLock()
+	defer tokensMutex.RUnlock()
+	for stored := range tokens {
+		if secretMatches(token, stored) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTokenPlugin tells whether a valid token can attach to the plugin
+func CheckTokenPlugin(token, plugin string) bool {
+	if !TokenAuthEnabled() || token == "" {
+		return false
+	}
+	if SignedTokens() {
+		allowed, ok := checkSignature(token)
+		if !ok {
+			return false
+		}
+		for _, p := range allowed {
+			if p == plugin {
+				return true
+			}
+		}
+		return false
+	}
+	tokensMutex.RLock()
+	defer tokensMutex.RUnlock()
+	for stored, allowed := range tokens {
+		if secretMatches(token, stored) {
+			return allowed == nil || allowed[plugin]
+		}
+	}
+	return false
+}
+
+// AddToken stores a token, allowed to attach to the given plugins (all of them if none)
+func AddToken(token string, plugins []string) error {
+	if !TokenAuthEnabled() {
+		return ErrTokenAuthDisabled
+	}
+	if SignedTokens() {
+		return ErrSignedTokens
+	}
+	var allowed map[string]bool
+	if len(plugins) > 0 {
+		allowed = make(map[string]bool)
+		for _, p := range plugins {
+			allowed[p] = true
+		}
+	}
+	tokensMutex.Lock()
+	defer tokensMutex.Unlock()
+	tokens[token] = allowed
+	return nil
+}
+
+// RemoveToken removes a stored token
+func RemoveToken(token string) error {
+	if !TokenAuthEnabled() {
+		return ErrTokenAuthDisabled
+	}
+	if SignedTokens() {
+		return ErrSignedTokens
+	}
+	tokensMutex.Lock()
+	defer tokensMutex.Unlock()
+	if _, found := tokens[token]; !found {
+		return ErrTokenNotFound
+	}
+	delete(tokens, token)
+	return nil
+}
+
+// ListTokens returns the stored tokens and the plugins they can attach to (nil means any)
+func ListTokens() (map[string][]string, error) {
+	if !TokenAuthEnabled() {
+		return nil, ErrTokenAuthDisabled
+	}
+	if SignedTokens() {
+		return nil, ErrSignedTokens
+	}
+	tokensMutex.RLock()
+	defer tokensMutex.RUnlock()
+	list := make(map[string][]string, len(tokens))
+	for token, allowed := range tokens {
+		var plugins []string
+		for p := range allowed {
+			plugins = append(plugins, p)
+		}
+		sort.Strings(plugins)
+		list[token] = plugins
+	}
+	return list, nil
+}
+
+// authorize checks the apisecret and token of a request. tokenOnly tells
+// whether only the token was valid, which means the plugins it can attach
+// to must be checked too.
+func authorize(msg message) (tokenOnly bool, err *Error) {
+	secret := config.Conf.General.Api_secret
+	if secret == "" && !TokenAuthEnabled() {
+		return false, nil
+	}
+	if err := msg.validate(
+		param{"apisecret", kindString, false},
+		param{"token", kindString, false},
+	); err != nil {
+		return false, err
+	}
+	if secret != "" && msg.has("apisecret") && secretMatches(msg.str("apisecret"), secret) {
+		return false, nil
+	}
+	if msg.has("token") && CheckToken(msg.str("token")) {
+		return true, nil
+	}
+	return false, newError(ErrorUnauthorized, "")
+}
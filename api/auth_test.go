@@ -0,0 +1,225 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/session"
+)
+
+// setAuth configures authentication for the duration of the test
+func setAuth(t *testing.T, apiSecret string, tokenAuth bool, tokenSecret string) {
+	general := &config.Conf.General
+	old := *general
+	general.Api_secret, general.Token_auth, general.Token_auth_secret = apiSecret, tokenAuth, tokenSecret
+	t.Cleanup(func() {
+		*general = old
+		tokensMutex.Lock()
+		tokens = make(map[string]map[string]bool)
+		tokensMutex.Unlock()
+	})
+}
+
+// signToken makes a token the way applications do, e.g. janus_token.js
+func signToken(secret string, expiry time.Time, realm string, plugins ...string) string {
+	return sign(secret, strings.Join(append([]string{strconv.FormatInt(expiry.Unix(), 10), realm}, plugins...), ","))
+}
+
+func sign(secret, data string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(data))
+	return data + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestSignedTokens(t *testing.T) {
+	setAuth(t, "", true, "s3cret")
+	later, earlier := time.Now().Add(time.Hour), time.Now().Add(-time.Second)
+	valid := signToken("s3cret", later, "janus", "janus.plugin.echotest", "janus.plugin.videoroom")
+	tests := []struct {
+		name   string
+		token  string
+		valid  bool
+		plugin string // a plugin the token can attach to, if valid
+	}{
+		{"valid", valid, true, "janus.plugin.echotest"},
+		{"valid for another plugin", valid, true, "janus.plugin.videoroom"},
+		{"no plugins", signToken("s3cret", later, "janus"), true, ""},
+		{"wrong secret", signToken("secret", later, "janus", "janus.plugin.echotest"), false, ""},
+		{"wrong realm", signToken("s3cret", later, "jan", "janus.plugin.echotest"), false, ""},
+		{"no realm", signToken("s3cret", later, ""), false, ""},
+		{"expired", signToken("s3cret", earlier, "janus", "janus.plugin.echotest"), false, ""},
+		{"tampered", strings.Replace(valid, "echotest", "streaming", 1), false, ""},
+		{"no signature", strings.Split(valid, ":")[0], false, ""},
+		{"signature not base64", strings.Split(valid, ":")[0] + ":***", false, ""},
+		{"bad expiry", sign("s3cret", "tomorrow,janus"), false, ""},
+		{"empty", "", false, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid := CheckToken(test.token); valid != test.valid {
+				t.Fatalf("got valid %v, want %v", valid, test.valid)
+			}
+			if test.plugin != "" && !CheckTokenPlugin(test.token, test.plugin) {
+				t.Errorf("token can't attach to %s", test.plugin)
+			}
+			if CheckTokenPlugin(test.token, "janus.plugin.textroom") {
+				t.Errorf("token can attach to a plugin it wasn't signed for")
+			}
+		})
+	}
+	if err := AddToken("abc", nil); err != ErrSignedTokens {
+		t.Errorf("got error %v adding a stored token, want %v", err, ErrSignedTokens)
+	}
+	if _, err := ListTokens(); err != ErrSignedTokens {
+		t.Errorf("got error %v listing stored tokens, want %v", err, ErrSignedTokens)
+	}
+}
+
+func TestStoredTokens(t *testing.T) {
+	setAuth(t, "", true, "")
+	if err := AddToken("any", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToken("echo", []string{"janus.plugin.echotest"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		token   string
+		plugin  string
+		valid   bool
+		allowed bool
+	}{
+		{"any", "janus.plugin.echotest", true, true},
+		{"any", "janus.plugin.videoroom", true, true},
+		{"echo", "janus.plugin.echotest", true, true},
+		{"echo", "janus.plugin.videoroom", true, false},
+		{"ech", "janus.plugin.echotest", false, false},
+		{"", "janus.plugin.echotest", false, false},
+	}
+	for _, test := range tests {
+		if valid := CheckToken(test.token); valid != test.valid {
+			t.Errorf("token %q: got valid %v, want %v", test.token, valid, test.valid)
+		}
+		if allowed := CheckTokenPlugin(test.token, test.plugin); allowed != test.allowed {
+			t.Errorf("token %q, plugin %s: got allowed %v, want %v", test.token, test.plugin, allowed, test.allowed)
+		}
+	}
+
+	list, err := ListTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]string{"any": nil, "echo": {"janus.plugin.echotest"}}; !reflect.DeepEqual(list, want) {
+		t.Errorf("got tokens %v, want %v", list, want)
+	}
+	if err := RemoveToken("echo"); err != nil {
+		t.Fatal(err)
+	}
+	if CheckToken("echo") {
+		t.Error("removed token is still valid")
+	}
+	if err := RemoveToken("echo"); err != ErrTokenNotFound {
+		t.Errorf("got error %v removing twice, want %v", err, ErrTokenNotFound)
+	}
+}
+
+func TestTokenAuthDisabled(t *testing.T) {
+	setAuth(t, "", false, "")
+	if err := AddToken("abc", nil); err != ErrTokenAuthDisabled {
+		t.Errorf("got error %v, want %v", err, ErrTokenAuthDisabled)
+	}
+	if err := RemoveToken("abc"); err != ErrTokenAuthDisabled {
+		t.Errorf("got error %v, want %v", err, ErrTokenAuthDisabled)
+	}
+	if CheckToken("abc") {
+		t.Error("token valid with token auth disabled")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name        string
+		apiSecret   string
+		tokenSecret string // signed tokens if set, stored ones otherwise
+		tokenAuth   bool
+		request     string // {token} stands for a valid token for echotest only
+		code        int
+	}{
+		{"no auth", "", "", false, `{"janus":"create","transaction":"t"}`, 0},
+		{"api secret", "pw", "", false, `{"janus":"create","transaction":"t","apisecret":"pw"}`, 0},
+		{"wrong api secret", "pw", "", false, `{"janus":"create","transaction":"t","apisecret":"px"}`, ErrorUnauthorized},
+		{"missing api secret", "pw", "", false, `{"janus":"create","transaction":"t"}`, ErrorUnauthorized},
+		{"api secret not a string", "pw", "", false, `{"janus":"create","transaction":"t","apisecret":1}`, ErrorInvalidElementType},
+		{"ping needs no secret", "pw", "", false, `{"janus":"ping","transaction":"t"}`, 0},
+		{"info needs no secret", "pw", "", false, `{"janus":"info","transaction":"t"}`, 0},
+		{"stored token", "", "", true, `{"janus":"create","transaction":"t","token":"{token}"}`, 0},
+		{"signed token", "", "s3cret", true, `{"janus":"create","transaction":"t","token":"{token}"}`, 0},
+		{"wrong token", "", "", true, `{"janus":"create","transaction":"t","token":"nope"}`, ErrorUnauthorized},
+		{"missing token", "", "s3cret", true, `{"janus":"create","transaction":"t"}`, ErrorUnauthorized},
+		{"token instead of api secret", "pw", "", true, `{"janus":"create","transaction":"t","token":"{token}"}`, 0},
+		{"api secret instead of token", "pw", "", true, `{"janus":"create","transaction":"t","apisecret":"pw"}`, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setAuth(t, test.apiSecret, test.tokenAuth, test.tokenSecret)
+			token := "t0ken"
+			if test.tokenSecret != "" {
+				token = signToken(test.tokenSecret, time.Now().Add(time.Hour), "janus", "janus.plugin.echotest")
+			} else if test.tokenAuth {
+				AddToken(token, []string{"janus.plugin.echotest"})
+			}
+			tt := newTestTransport()
+			response := tt.request(t, false, strings.Replace(test.request, "{token}", token, 1))
+			if code := errorCode(response); code != test.code {
+				t.Fatalf("got error code %d, want %d: %v", code, test.code, response)
+			}
+			if id := id(response); id != 0 {
+				if s := session.Find(id); s != nil {
+					s.Destroy(false)
+				}
+			}
+		})
+	}
+}
+
+// A token that is all a request has must also be allowed to access the
+// plugin, while the api secret gives access to all of them
+func TestAuthorizeAttach(t *testing.T) {
+	setAuth(t, "pw", true, "")
+	AddToken("echo", []string{"janus.plugin.echotest"})
+	AddToken("test", []string{testPackage})
+	tt := newTestTransport()
+	created := tt.request(t, false, `{"janus":"create","transaction":"t","apisecret":"pw"}`)
+	sessionID := id(created)
+	if sessionID == 0 {
+		t.Fatalf("create failed: %v", created)
+	}
+	defer session.Find(sessionID).Destroy(false)
+	attach := `{"janus":"attach","transaction":"t","session_id":` + strconv.FormatUint(sessionID, 10) + `,"plugin":"` + testPackage + `",`
+	tests := []struct {
+		name string
+		auth string
+		code int
+	}{
+		{"token for another plugin", `"token":"echo"`, ErrorUnauthorizedPlugin},
+		{"token for the plugin", `"token":"test"`, 0},
+		{"api secret", `"apisecret":"pw"`, 0},
+		{"api secret and token for another plugin", `"apisecret":"pw","token":"echo"`, 0},
+		{"nothing", `"opaque_id":"x"`, ErrorUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := tt.request(t, false, attach+test.auth+`}`)
+			if code := errorCode(response); code != test.code {
+				t.Fatalf("got error code %d, want %d: %v", code, test.code, response)
+			}
+		})
+	}
+}
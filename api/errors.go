@@ -0,0 +1,86 @@
+package api
+
+import "fmt"
+
+// Error codes of the Janus API, the same as in the original Janus (apierror.h)
+const (
+	ErrorUnauthorized         = 403
+	ErrorUnauthorizedPlugin   = 405
+	ErrorUnknown              = 490
+	ErrorTransportSpecific    = 450
+	ErrorMissingRequest       = 452
+	ErrorUnknownRequest       = 453
+	ErrorInvalidJSON          = 454
+	ErrorInvalidJSONObject    = 455
+	ErrorMissingMandatoryElem = 456
+	ErrorInvalidRequestPath   = 457
+	ErrorSessionNotFound      = 458
+	ErrorHandleNotFound       = 459
+	ErrorPluginNotFound       = 460
+	ErrorPluginAttach         = 461
+	ErrorPluginMessage        = 462
+	ErrorPluginDetach         = 463
+	ErrorJSEPUnknownType      = 464
+	ErrorJSEPInvalidSDP       = 465
+	ErrorTrickleInvalidStream = 466
+	ErrorInvalidElementType   = 467
+	ErrorSessionConflict      = 468
+	ErrorUnexpectedAnswer     = 469
+	ErrorTokenNotFound        = 470
+	ErrorWebRTCState          = 471
+	ErrorNotAcceptingSessions = 472
+)
+
+var errorTexts = map[int]string{
+	ErrorUnauthorized:         "Unauthorized request (wrong or missing secret/token)",
+	ErrorUnauthorizedPlugin:   "Unauthorized access to plugin (token is not allowed to)",
+	ErrorUnknown:              "Unknown error",
+	ErrorTransportSpecific:    "Transport specific error",
+	ErrorMissingRequest:       "Missing request",
+	ErrorUnknownRequest:       "Unknown request",
+	ErrorInvalidJSON:          "Invalid JSON",
+	ErrorInvalidJSONObject:    "Invalid JSON Object",
+	ErrorMissingMandatoryElem: "Missing mandatory element",
+	ErrorInvalidRequestPath:   "Invalid path for this request",
+	ErrorSessionNotFound:      "Session not found",
+	ErrorHandleNotFound:       "Handle not found",
+	ErrorPluginNotFound:       "Plugin not found",
+	ErrorPluginAttach:         "Error attaching plugin",
+	ErrorPluginMessage:        "Error sending message to plugin",
+	ErrorPluginDetach:         "Error detaching from plugin",
+	ErrorJSEPUnknownType:      "Unsupported JSEP type",
+	ErrorJSEPInvalidSDP:       "Invalid SDP",
+	ErrorTrickleInvalidStream: "Invalid stream",
+	ErrorInvalidElementType:   "Invalid element type",
+	ErrorSessionConflict:      "Session ID already in use",
+	ErrorUnexpectedAnswer:     "Unexpected ANSWER (no OFFER)",
+	ErrorTokenNotFound:        "Token not found",
+	ErrorWebRTCState:          "Wrong WebRTC state",
+	ErrorNotAcceptingSessions: "Currently not accepting new sessions",
+}
+
+// ErrorText returns the generic description of an error code
+func ErrorText(code int) string {
+	if text, found := errorTexts[code]; found {
+		return text
+	}
+	return "Unknown error"
+}
+
+// Error is a Janus API error, with the code and reason sent to the client
+type Error struct {
+	Code   int
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Reason)
+}
+
+// newError returns an API error; with no format the generic description of the code is used
+func newError(code int, format string, args ...interface{}) *Error {
+	if format == "" {
+		return &Error{Code: code, Reason: ErrorText(code)}
+	}
+	return &Error{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
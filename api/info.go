@@ -0,0 +1,124 @@
+package api
+
+// The info request tells clients what this server is and what it can do:
+// version, configuration flags, and the plugins, transports and event
+// handlers that are loaded, so that clients can check a plugin is there
+// before attaching to it.
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/events"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/session"
+)
+
+const (
+	Name          = "Janus WebRTC Server (go-janus)"
+	Author        = "xroger88"
+	Version       = 1
+	VersionString = "0.1"
+)
+
+// Commit and BuildTime are set at build time, e.g.
+//
+//	go build -ldflags "-X github.com/xroger88/go-janus/api.Commit=$(git rev-parse HEAD)"
+var (
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+var started = time.Now()
+
+// Component is what plugins, transports and event handlers tell about themselves
+type Component interface {
+	Package() string
+	Name() string
+	Description() string
+	Author() string
+	Version() int
+	VersionString() string
+}
+
+var (
+	transportsMutex sync.Mutex
+	transports      []Component
+)
+
+// TransportStarted adds a transport to the ones listed by info
+func TransportStarted(t Component) {
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+	transports = append(transports, t)
+}
+
+func describe(c Component) map[string]interface{} {
+	return map[string]interface{}{
+		"name":           c.Name(),
+		"author":         c.Author(),
+		"description":    c.Description(),
+		"version":        c.Version(),
+		"version_string": c.VersionString(),
+	}
+}
+
+func serverInfo(transaction string) map[string]interface{} {
+	general, media, nat := &config.Conf.General, &config.Conf.Media, &config.Conf.Nat
+
+	pluginsInfo := map[string]interface{}{}
+	for _, p := range plugins.List() {
+		pluginsInfo[p.Package()] = describe(p)
+	}
+	transportsInfo := map[string]interface{}{}
+	transportsMutex.Lock()
+	for _, t := range transports {
+		transportsInfo[t.Package()] = describe(t)
+	}
+	transportsMutex.Unlock()
+	eventsInfo := map[string]interface{}{}
+	if events.Enabled() {
+		for _, h := range events.List() {
+			eventsInfo[h.Package()] = describe(h)
+		}
+	}
+
+	return map[string]interface{}{
+		"janus":          "server_info",
+		"transaction":    transaction,
+		"name":           Name,
+		"version":        Version,
+		"version_string": VersionString,
+		"author":         Author,
+		"commit-hash":    Commit,
+		"compile-time":   BuildTime,
+		"server-name":    general.Server_name,
+		"uptime":         int64(time.Since(started) / time.Second),
+
+		"log-to-stdout":           general.Log_to_stdout,
+		"log-to-file":             general.Log_to_file != "",
+		"data_channels":           true,
+		"accepting-new-sessions":  AcceptingSessions(),
+		"session-timeout":         int64(session.Timeout() / time.Second),
+		"reclaim-session-timeout": int64(session.ReclaimTimeout() / time.Second),
+		"local-ip":                general.Interface,
+		"ipv6":                    media.Ipv6,
+		"ice-lite":                nat.Ice_lite,
+		"ice-tcp":                 nat.Ice_tcp,
+		"full-trickle":            nat.Full_trickle,
+		"rfc-4588":                media.Rfc_4588,
+		"max-nack-queue":          media.Max_nack_queue,
+		"dtls-mtu":                media.Dtls_mtu,
+		"static-event-loops":      general.Event_loops,
+		"loop-indication":         general.Allow_loop_indication,
+		"api_secret":              general.Api_secret != "",
+		"auth_token":              TokenAuthEnabled(),
+		"auth_token_signed":       SignedTokens(),
+		"event_handlers":          events.Enabled(),
+
+		"plugins":    pluginsInfo,
+		"transports": transportsInfo,
+		"events":     eventsInfo,
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+// Requests are parsed into a map of raw JSON values, so that we can tell a
+// missing element from one of the wrong type and reply with the same error
+// codes and reasons the original Janus does (JANUS_VALIDATE_JSON_OBJECT).
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+type message map[string]json.RawMessage
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindPositiveInteger
+	kindInteger
+	kindBool
+	kindObject
+	kindArray
+)
+
+var kindNames = map[kind]string{
+	kindString:          "a string",
+	kindPositiveInteger: "a positive integer",
+	kindInteger:         "an integer",
+	kindBool:            "a boolean",
+	kindObject:          "an object",
+	kindArray:           "an array",
+}
+
+// param describes an element of a request
+type param struct {
+	name      string
+	kind      kind
+	mandatory bool
+}
+
+func parseMessage(data []byte) (message, *Error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, newError(ErrorMissingRequest, "")
+	}
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return nil, newError(ErrorInvalidJSONObject, "")
+		}
+		return nil, newError(ErrorInvalidJSON, "JSON error: %v", err)
+	}
+	if msg == nil {
+		return nil, newError(ErrorInvalidJSONObject, "")
+	}
+	return msg, nil
+}
+
+func (k kind) matches(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return false
+	}
+	switch k {
+	case kindString:
+		return raw[0] == '"'
+	case kindBool:
+		return bytes.Equal(raw, []byte("true")) || bytes.Equal(raw, []byte("false"))
+	case kindObject:
+		return raw[0] == '{'
+	case kindArray:
+		return raw[0] == '['
+	case kindInteger:
+		_, err := strconv.ParseInt(string(raw), 10, 64)
+		return err == nil
+	case kindPositiveInteger:
+		_, err := strconv.ParseUint(string(raw), 10, 64)
+		return err == nil
+	}
+	return false
+}
+
+// validate checks the elements of the request, returning the first error found
+func (m message) validate(params ...param) *Error {
+	for _, p := range params {
+		raw, found := m[p.name]
+		if !found || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			if p.mandatory {
+				return newError(ErrorMissingMandatoryElem, "Missing mandatory element (%s)", p.name)
+			}
+			continue
+		}
+		if !p.kind.matches(raw) {
+			return newError(ErrorInvalidElementType, "Invalid element type (%s should be %s)", p.name, kindNames[p.kind])
+		}
+	}
+	return nil
+}
+
+func (m message) has(name string) bool {
+	raw, found := m[name]
+	return found && !bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+// The getters below are meant to be used after validate, so they don't report errors
+
+func (m message) str(name string) string {
+	var s string
+	json.Unmarshal(m[name], &s)
+	return s
+}
+
+func (m message) uint(name string) uint64 {
+	var u uint64
+	json.Unmarshal(m[name], &u)
+	return u
+}
+
+func (m message) int(name string) int64 {
+	var i int64
+	json.Unmarshal(m[name], &i)
+	return i
+}
+
+func (m message) bool(name string) bool {
+	var b bool
+	json.Unmarshal(m[name], &b)
+	return b
+}
@@ -43,8 +43,11 @@ func init() {
 		"Open the specified config file `path` when starting Janus")
 	UintVar(&Flags.Http_port, []string{"hp", "http_port"}, DEF_HTTP_PORT,
 		"Web server will be listen to http port")
+}
 
-	// parse the flags in command line
+// Parse parses the flags in command line. It's left to main, as the tests
+// of the packages using Flags have flags of their own.
+func Parse() {
 	flag.Parse()
 }
 
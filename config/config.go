@@ -11,6 +11,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/xroger88/go-janus/util"
 	"gopkg.in/yaml.v2"
@@ -38,8 +40,10 @@ type ConfigType struct {
 		Admin_secret            string
 		Server_name             string
 		Session_timeout         int
-		Reclain_session_timeout int
+		Reclaim_session_timeout int
 		Recordings_tmp_ext      string
+		Event_loops             int
+		Allow_loop_indication   bool
 	}
 	Certificates struct {
 		Cert_pem string
@@ -77,7 +81,8 @@ type ConfigType struct {
 		Disable string
 	}
 	Transports struct {
-		Disable string
+		Disable           string
+		Max_queued_events int
 	}
 	Events struct {
 		Broadcast    bool
@@ -125,7 +130,40 @@ func SaveConfig(filepath string) {
 	Conf.setConf(filepath)
 }
 
+// LoadComponent reads the configuration of a plugin, transport or event
+// handler from <folder>/<pkg>.yaml (e.g. janus.transport.http.yaml) into out.
+// A missing file is not an error: out just keeps its defaults.
+func LoadComponent(folder, pkg string, out interface{}) error {
+	cfp := filepath.Join(folder, pkg+".yaml")
+	yamlFile, err := ioutil.ReadFile(cfp)
+	if os.IsNotExist(err) {
+		log.Printf("No configuration file %s, using defaults", cfp)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(yamlFile, out)
+}
+
 func PrintAll() {
 	fmt.Printf("*** The Configuration Details *** \n")
 	util.PrintValue(0, &Conf)
 }
+
+// Listed tells whether a name is in a comma separated list
+func Listed(list, name string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" && item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Disabled tells whether a component is in one of the disable lists of the
+// configuration: a comma separated list of package names (janus.plugin.echotest)
+// or of the library names the original Janus uses (libjanus_echotest.so)
+func Disabled(list, pkg string) bool {
+	legacy := "libjanus_" + pkg[strings.LastIndex(pkg, ".")+1:] + ".so"
+	return Listed(list, pkg) || Listed(list, legacy)
+}
@@ -0,0 +1,231 @@
+package config
+
+// TLS settings shared by the transports serving HTTPS and WSS. Each transport
+// may have its own certificate, or fall back to the one in the certificates
+// section of conf.yaml (the DTLS one). Certificates are checked for changes
+// while running, so they can be renewed without restarting the server.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/youmark/pkcs8"
+)
+
+// how often the certificate files are checked for changes, at most
+const certificateCheck = 10 * time.Second
+
+// TLS is the certificates section of a transport configuration
+type TLS struct {
+	Cert_pem    string
+	Cert_key    string
+	Cert_pwd    string
+	Min_version string   // 1.0, 1.1, 1.2 (default) or 1.3
+	Ciphers     []string // as named by crypto/tls, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ServerConfig builds the TLS configuration of a server. If clientCA is not
+// empty, clients must present a certificate signed by one of the authorities
+// in that file, which is meant for the Admin API.
+func (t *TLS) ServerConfig(clientCA string) (*tls.Config, error) {
+	cert := &certificate{certFile: t.Cert_pem, keyFile: t.Cert_key, password: t.Cert_pwd}
+	if cert.certFile == "" {
+		cert.certFile = Conf.Certificates.Cert_pem
+		cert.keyFile = Conf.Certificates.Cert_key
+		cert.password = Conf.Certificates.Cert_pwd
+	}
+	if cert.certFile == "" || cert.keyFile == "" {
+		return nil, errors.New("no certificate and key configured")
+	}
+	if err := cert.load(); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cert.get,
+	}
+	if t.Min_version != "" {
+		version, ok := tlsVersions[t.Min_version]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLS version %q", t.Min_version)
+		}
+		config.MinVersion = version
+	}
+	if len(t.Ciphers) > 0 {
+		suites := make(map[string]uint16)
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range t.Ciphers {
+			id, ok := suites[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite %q", name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+	if clientCA != "" {
+		data, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// LoadCertificate reads a certificate and its key, which may be encrypted
+// with password, e.g. the DTLS one of the certificates section
+func LoadCertificate(certFile, keyFile, password string) (*tls.Certificate, error) {
+	cert := &certificate{certFile: certFile, keyFile: keyFile, password: password}
+	if err := cert.load(); err != nil {
+		return nil, err
+	}
+	return cert.cert, nil
+}
+
+// certificate reloads the certificate and key when the files change
+type certificate struct {
+	certFile, keyFile, password string
+
+	mutex    sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+	checked  time.Time
+}
+
+func (c *certificate) lastModified() time.Time {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (c *certificate) load() error {
+	modified := c.lastModified()
+	certPEM, err := ioutil.ReadFile(c.certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(c.keyFile)
+	if err != nil {
+		return err
+	}
+	if keyPEM, err = c.decrypt(keyPEM); err != nil {
+		return fmt.Errorf("can't decrypt %s: %v", c.keyFile, err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	c.cert, c.modified, c.checked = &cert, modified, time.Now()
+	return nil
+}
+
+// decrypt turns an encrypted key into a plain one, be it PKCS#8 (what
+// OpenSSL writes nowadays) or legacy PEM encryption
+func (c *certificate) decrypt(keyPEM []byte) ([]byte, error) {
+	block, _ := pem.Decode(keyPEM)
+	switch {
+	case block == nil:
+		return keyPEM, nil
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(c.password))
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	case x509.IsEncryptedPEMBlock(block):
+		der, err := x509.DecryptPEMBlock(block, []byte(c.password))
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+	}
+	return keyPEM, nil
+}
+
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if time.Since(c.checked) < certificateCheck {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+	if !c.lastModified().After(c.modified) {
+		return c.cert, nil
+	}
+	// a broken new certificate is not a good reason to stop serving the old one
+	if err := c.load(); err != nil {
+		log.Warnf("Can't reload the certificate %s, keeping the old one: %v", c.certFile, err)
+		c.modified = c.lastModified()
+		return c.cert, nil
+	}
+	log.Infof("Certificate %s reloaded", c.certFile)
+	return c.cert, nil
+}
+
+// ClientTLS is the tls section of a transport connecting to a broker
+type ClientTLS struct {
+	Enable      bool
+	Cacert      string
+	Client_cert string
+	Client_key  string
+	Verify_peer bool
+}
+
+// ClientConfig builds the TLS configuration to connect to a broker, or
+// returns nil if TLS is not enabled
+func (t *ClientTLS) ClientConfig() (*tls.Config, error) {
+	if !t.Enable {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: !t.Verify_peer}
+	if t.Cacert != "" {
+		data, err := ioutil.ReadFile(t.Cacert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", t.Cacert)
+		}
+	}
+	if t.Client_cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Client_cert, t.Client_key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
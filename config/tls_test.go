@@ -0,0 +1,208 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/youmark/pkcs8"
+)
+
+// testKey is a self-signed certificate and its key, in PEM
+type testKey struct {
+	key  *ecdsa.PrivateKey
+	cert []byte
+}
+
+func newTestKey(t *testing.T, name string) testKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (k testKey) plain(t *testing.T) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(k.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func (k testKey) pkcs8(t *testing.T, password string) []byte {
+	der, err := pkcs8.ConvertPrivateKeyToPKCS8(k.key, []byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der})
+}
+
+func (k testKey) legacy(t *testing.T, password string) []byte {
+	der, err := x509.MarshalECPrivateKey(k.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte(password), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(block)
+}
+
+func write(t *testing.T, path string, data []byte) string {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	key, other := newTestKey(t, "server"), newTestKey(t, "other")
+	certFile := write(t, filepath.Join(dir, "cert.pem"), key.cert)
+	tests := []struct {
+		name     string
+		key      []byte
+		password string
+		err      bool
+	}{
+		{"plain key", key.plain(t), "", false},
+		{"PKCS#8 encrypted key", key.pkcs8(t, "secret"), "secret", false},
+		{"legacy encrypted key", key.legacy(t, "secret"), "secret", false},
+		{"wrong PKCS#8 password", key.pkcs8(t, "secret"), "guess", true},
+		{"wrong legacy password", key.legacy(t, "secret"), "guess", true},
+		{"key of another certificate", other.plain(t), "", true},
+		{"no key", nil, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyFile := filepath.Join(dir, "missing.pem")
+			if test.key != nil {
+				keyFile = write(t, filepath.Join(dir, "key.pem"), test.key)
+			}
+			cert, err := LoadCertificate(certFile, keyFile, test.password)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v", err)
+			}
+			if err == nil && cert.PrivateKey == nil {
+				t.Error("got no private key")
+			}
+		})
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	key := newTestKey(t, "server")
+	certFile := write(t, filepath.Join(dir, "cert.pem"), key.cert)
+	keyFile := write(t, filepath.Join(dir, "key.pem"), key.plain(t))
+	notPEM := write(t, filepath.Join(dir, "ca.txt"), []byte("not a certificate"))
+
+	// without a certificate of their own, transports use the DTLS one
+	saved := Conf.Certificates
+	t.Cleanup(func() { Conf.Certificates = saved })
+	Conf.Certificates.Cert_pem, Conf.Certificates.Cert_key = certFile, keyFile
+
+	tests := []struct {
+		name     string
+		tls      TLS
+		clientCA string
+		version  uint16
+		ciphers  int
+		err      bool
+	}{
+		{"own certificate", TLS{Cert_pem: certFile, Cert_key: keyFile}, "", tls.VersionTLS12, 0, false},
+		{"certificate of conf.yaml", TLS{}, "", tls.VersionTLS12, 0, false},
+		{"no key", TLS{Cert_pem: certFile}, "", 0, 0, true},
+		{"TLS 1.3", TLS{Min_version: "1.3"}, "", tls.VersionTLS13, 0, false},
+		{"invalid version", TLS{Min_version: "4"}, "", 0, 0, true},
+		{"ciphers", TLS{Ciphers: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", " TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256 "}}, "", tls.VersionTLS12, 2, false},
+		{"unknown cipher", TLS{Ciphers: []string{"TLS_NULL"}}, "", 0, 0, true},
+		{"client CA", TLS{}, certFile, tls.VersionTLS12, 0, false},
+		{"client CA not PEM", TLS{}, notPEM, 0, 0, true},
+		{"client CA missing", TLS{}, filepath.Join(dir, "missing.pem"), 0, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := test.tls.ServerConfig(test.clientCA)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if config.MinVersion != test.version || len(config.CipherSuites) != test.ciphers {
+				t.Errorf("got version %x and %d cipher suites, want %x and %d", config.MinVersion, len(config.CipherSuites), test.version, test.ciphers)
+			}
+			if verify := config.ClientAuth == tls.RequireAndVerifyClientCert; verify != (test.clientCA != "") {
+				t.Errorf("got client certificates verified: %v", verify)
+			}
+			if cert, err := config.GetCertificate(nil); err != nil || cert == nil {
+				t.Errorf("got certificate %v, error %v", cert, err)
+			}
+		})
+	}
+}
+
+func TestReloadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	first, second := newTestKey(t, "first"), newTestKey(t, "second")
+	certFile := write(t, filepath.Join(dir, "cert.pem"), first.cert)
+	keyFile := write(t, filepath.Join(dir, "key.pem"), first.plain(t))
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := c.get(nil)
+
+	// renewed files, seen once it's time to check them again
+	renew := func(cert, key []byte) {
+		write(t, certFile, cert)
+		write(t, keyFile, key)
+		later := time.Now().Add(time.Minute)
+		for _, file := range []string{certFile, keyFile} {
+			if err := os.Chtimes(file, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	renew(second.cert, second.plain(t))
+	if cert, _ := c.get(nil); cert != old {
+		t.Error("got the certificate reloaded before the next check")
+	}
+	c.checked = time.Time{}
+	renewed, _ := c.get(nil)
+	if renewed == old || renewed.Leaf != nil && renewed.Leaf.Subject.CommonName != "second" {
+		t.Fatal("got the old certificate after renewing it")
+	}
+
+	// a broken renewal keeps what was working
+	renew(second.cert, first.plain(t))
+	c.checked = time.Time{}
+	if cert, _ := c.get(nil); cert != renewed {
+		t.Error("got the certificate replaced by a broken one")
+	}
+}
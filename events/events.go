@@ -0,0 +1,142 @@
+package events
+
+// Event handlers receive live events happening in the core and in the
+// plugins (sessions created, handles attached, media stats and so on) and
+// can forward them somewhere else, e.g. a monitoring backend. This mirrors
+// the janus_eventhandler struct of the original Janus source tree.
+// Events are only delivered if broadcast is enabled in the configuration.
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/config"
+)
+
+// Types of the events, as in Janus
+const (
+	TypeSession   = 1
+	TypeHandle    = 2
+	TypeJSEP      = 8
+	TypeWebRTC    = 16
+	TypeMedia     = 32
+	TypePlugin    = 64
+	TypeTransport = 128
+	TypeCore      = 256
+)
+
+// ErrNoQueries is returned by handlers that don't support queries via the Admin API
+var ErrNoQueries = errors.New("event handler doesn't support queries")
+
+// APIVersion is the version of the Handler interface, bumped whenever it
+// changes: Go plugins built against another version are refused
+const APIVersion = 1
+
+// Handler is the interface every event handler implements
+type Handler interface {
+	Package() string
+	Name() string
+	Description() string
+	Author() string
+	Version() int
+	VersionString() string
+
+	Init(configPath string) error
+	Destroy()
+
+	// IncomingEvent is called for each event; it must not block
+	IncomingEvent(event map[string]interface{})
+	// HandleRequest answers a query_eventhandler request of the Admin API
+	HandleRequest(request json.RawMessage) (interface{}, error)
+}
+
+var (
+	mutex     sync.RWMutex
+	handlers  = make(map[string]Handler)
+	broadcast bool
+)
+
+// Register makes an event handler available
+func Register(h Handler) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	handlers[h.Package()] = h
+}
+
+// Find returns the event handler registered with the given package name, if any
+func Find(pkg string) Handler {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return handlers[pkg]
+}
+
+// List returns all the registered event handlers
+func List() []Handler {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	list := make([]Handler, 0, len(handlers))
+	for _, h := range handlers {
+		list = append(list, h)
+	}
+	return list
+}
+
+func unregister(h Handler) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(handlers, h.Package())
+}
+
+// InitAll initializes the registered event handlers if broadcast is enabled,
+// except the ones in the disable list (e.g. libjanus_sampleevh.so)
+func InitAll(enabled bool, disable, configPath string) {
+	mutex.Lock()
+	broadcast = enabled
+	mutex.Unlock()
+	if !enabled {
+		log.Infoln("Event handlers support disabled")
+		return
+	}
+	for _, h := range List() {
+		if config.Disabled(disable, h.Package()) {
+			log.Infof("Event handler %s disabled in the configuration, skipping it", h.Package())
+			unregister(h)
+			continue
+		}
+		if err := h.Init(configPath); err != nil {
+			log.Errorf("Error initializing event handler %s, skipping it: %v", h.Package(), err)
+			unregister(h)
+			continue
+		}
+		log.Infof("Loaded event handler: %s (%s %s)", h.Package(), h.Name(), h.VersionString())
+	}
+}
+
+// DestroyAll tells all the event handlers we're shutting down
+func DestroyAll() {
+	if !Enabled() {
+		return
+	}
+	for _, h := range List() {
+		h.Destroy()
+	}
+}
+
+// Enabled tells whether events are broadcast to the handlers
+func Enabled() bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return broadcast && len(handlers) > 0
+}
+
+// Notify passes an event to all the handlers
+func Notify(event map[string]interface{}) {
+	if !Enabled() {
+		return
+	}
+	for _, h := range List() {
+		h.IncomingEvent(event)
+	}
+}
@@ -0,0 +1,160 @@
+package loader
+
+// Plugins, transports and event handlers can also be shipped separately
+// from the gateway, as Go plugins (go build -buildmode=plugin) dropped in
+// the plugins_folder, transports_folder and events_folder of the
+// configuration. A Go plugin is a main package exporting:
+//
+//	var APIVersion = plugins.APIVersion      (or transports/events.APIVersion)
+//	func New() plugins.Plugin                (or transports.Transport/events.Handler)
+//
+// APIVersion is the version of the interface the plugin was built against,
+// which must match the one of the gateway; the Go runtime itself refuses
+// plugins built with another toolchain or other versions of the packages
+// they share with the gateway. Unlike the built-in components, Go plugins
+// must not register themselves in init: the loader registers what New
+// returns, once it checked it's not disabled. Files in the disable lists
+// (e.g. libjanus_echotest.so) aren't even opened.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/events"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/transports"
+)
+
+// component is what we know about the kind of things a folder holds
+type component struct {
+	what       string // for the logs
+	apiVersion int
+	// register checks what New returned and registers it, returning its package
+	register func(v interface{}, disable string) (string, error)
+}
+
+var (
+	pluginComponent = component{"plugin", plugins.APIVersion, func(v interface{}, disable string) (string, error) {
+		newPlugin, ok := v.(func() plugins.Plugin)
+		if !ok {
+			return "", fmt.Errorf("New is a %T, not a func() plugins.Plugin", v)
+		}
+		p := newPlugin()
+		if err := check(p.Package(), disable, plugins.Find(p.Package()) != nil); err != nil {
+			return p.Package(), err
+		}
+		plugins.Register(p)
+		return p.Package(), nil
+	}}
+	transportComponent = component{"transport", transports.APIVersion, func(v interface{}, disable string) (string, error) {
+		newTransport, ok := v.(func() transports.Transport)
+		if !ok {
+			return "", fmt.Errorf("New is a %T, not a func() transports.Transport", v)
+		}
+		t := newTransport()
+		if err := check(t.Package(), disable, transports.Find(t.Package()) != nil); err != nil {
+			return t.Package(), err
+		}
+		transports.Register(t)
+		return t.Package(), nil
+	}}
+	eventsComponent = component{"event handler", events.APIVersion, func(v interface{}, disable string) (string, error) {
+		newHandler, ok := v.(func() events.Handler)
+		if !ok {
+			return "", fmt.Errorf("New is a %T, not a func() events.Handler", v)
+		}
+		h := newHandler()
+		if err := check(h.Package(), disable, events.Find(h.Package()) != nil); err != nil {
+			return h.Package(), err
+		}
+		events.Register(h)
+		return h.Package(), nil
+	}}
+)
+
+// errDisabled means the component is in the disable list, which is not an error
+var errDisabled = fmt.Errorf("disabled in the configuration")
+
+func check(pkg, disable string, registered bool) error {
+	if pkg == "" {
+		return fmt.Errorf("empty package name")
+	}
+	if config.Disabled(disable, pkg) {
+		return errDisabled
+	}
+	if registered {
+		return fmt.Errorf("%s is already registered", pkg)
+	}
+	return nil
+}
+
+// instantiate checks the symbols of a Go plugin, found with lookup, and
+// registers what its New returns
+func (c component) instantiate(lookup func(name string) (interface{}, error), disable string) (string, error) {
+	symbol, err := lookup("APIVersion")
+	if err != nil {
+		return "", err
+	}
+	version, ok := symbol.(*int)
+	if !ok {
+		return "", fmt.Errorf("APIVersion is a %T, not an int", symbol)
+	}
+	if *version != c.apiVersion {
+		return "", fmt.Errorf("built for version %d of the %s API, but this is version %d", *version, c.what, c.apiVersion)
+	}
+	symbol, err = lookup("New")
+	if err != nil {
+		return "", err
+	}
+	return c.register(symbol, disable)
+}
+
+// LoadPlugins loads the Go plugins in the plugins folder
+func LoadPlugins(folder, disable string) {
+	load(pluginComponent, folder, disable)
+}
+
+// LoadTransports loads the Go plugins in the transports folder
+func LoadTransports(folder, disable string) {
+	load(transportComponent, folder, disable)
+}
+
+// LoadEventHandlers loads the Go plugins in the event handlers folder
+func LoadEventHandlers(folder, disable string) {
+	load(eventsComponent, folder, disable)
+}
+
+func load(c component, folder, disable string) {
+	folder = strings.TrimSpace(folder)
+	if folder == "" {
+		return
+	}
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		log.Infof("Not loading any %s from %s: %v", c.what, folder, err)
+		return
+	}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".so" {
+			continue
+		}
+		if config.Listed(disable, f.Name()) {
+			log.Infof("Not loading %s %s: disabled in the configuration", c.what, f.Name())
+			continue
+		}
+		path := filepath.Join(folder, f.Name())
+		pkg, err := open(c, path, disable)
+		switch {
+		case err == errDisabled:
+			log.Infof("Not registering %s %s from %s: disabled in the configuration", c.what, pkg, path)
+		case err != nil:
+			log.Errorf("Error loading %s %s: %v", c.what, path, err)
+		default:
+			log.Infof("Loaded %s %s from %s", c.what, pkg, path)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package loader
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/transports"
+)
+
+// what the tests load: only the package name matters to the loader
+type testPlugin struct {
+	plugins.Plugin
+	pkg string
+}
+
+func (p *testPlugin) Package() string { return p.pkg }
+
+type testTransport struct {
+	transports.Transport
+	pkg string
+}
+
+func (t *testTransport) Package() string { return t.pkg }
+
+// registered components stay, so each run of the tests needs new packages
+var runs int
+
+func TestInstantiate(t *testing.T) {
+	runs++
+	pkg := func(name string) string { return fmt.Sprintf("janus.plugin.%s%d", name, runs) }
+	newPlugin := func(pkg string) func() plugins.Plugin {
+		return func() plugins.Plugin { return &testPlugin{pkg: pkg} }
+	}
+	version, newer := plugins.APIVersion, plugins.APIVersion+1
+	transportVersion := transports.APIVersion
+	transport := fmt.Sprintf("janus.transport.loadertest%d", runs)
+
+	tests := []struct {
+		name      string
+		component component
+		symbols   map[string]interface{}
+		disable   string
+		err       string // part of the error, if any
+	}{
+		{"plugin", pluginComponent, map[string]interface{}{"APIVersion": &version, "New": newPlugin(pkg("ok"))}, "", ""},
+		{"transport", transportComponent, map[string]interface{}{"APIVersion": &transportVersion, "New": func() transports.Transport { return &testTransport{pkg: transport} }}, "", ""},
+		{"another version", pluginComponent, map[string]interface{}{"APIVersion": &newer, "New": newPlugin(pkg("newer"))}, "", "built for version"},
+		{"version not an int", pluginComponent, map[string]interface{}{"APIVersion": "1", "New": newPlugin(pkg("string"))}, "", "not an int"},
+		{"no version", pluginComponent, map[string]interface{}{"New": newPlugin(pkg("noversion"))}, "", "APIVersion not found"},
+		{"no New", pluginComponent, map[string]interface{}{"APIVersion": &version}, "", "New not found"},
+		{"New of a transport", pluginComponent, map[string]interface{}{"APIVersion": &version, "New": func() transports.Transport { return &testTransport{pkg: pkg("transport")} }}, "", "not a func() plugins.Plugin"},
+		{"New of a plugin", transportComponent, map[string]interface{}{"APIVersion": &transportVersion, "New": newPlugin(pkg("plugin"))}, "", "not a func() transports.Transport"},
+		{"already registered", pluginComponent, map[string]interface{}{"APIVersion": &version, "New": newPlugin(pkg("ok"))}, "", "already registered"},
+		{"empty package", pluginComponent, map[string]interface{}{"APIVersion": &version, "New": newPlugin("")}, "", "empty package"},
+		{"disabled", pluginComponent, map[string]interface{}{"APIVersion": &version, "New": newPlugin(pkg("disabled"))}, "janus.plugin.x, " + pkg("disabled"), errDisabled.Error()},
+		{"disabled library", pluginComponent, map[string]interface{}{"APIVersion": &version, "New": newPlugin(pkg("legacy"))}, fmt.Sprintf("libjanus_legacy%d.so", runs), errDisabled.Error()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lookup := func(name string) (interface{}, error) {
+				if symbol, ok := test.symbols[name]; ok {
+					return symbol, nil
+				}
+				return nil, fmt.Errorf("symbol %s not found", name)
+			}
+			name, err := test.component.instantiate(lookup, test.disable)
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("got %v", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Fatalf("got %v, want %s", err, test.err)
+			}
+			// what's rejected isn't registered, but for what already was
+			if registered := plugins.Find(name) != nil || transports.Find(name) != nil; registered != (err == nil || name == pkg("ok")) {
+				t.Errorf("got %s registered: %v", name, registered)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build (linux && cgo) || (darwin && cgo) || (freebsd && cgo)
+// +build linux,cgo darwin,cgo freebsd,cgo
+
+package loader
+
+import "plugin"
+
+func open(c component, path, disable string) (string, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return "", err
+	}
+	return c.instantiate(func(name string) (interface{}, error) { return p.Lookup(name) }, disable)
+}
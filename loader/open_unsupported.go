@@ -0,0 +1,10 @@
+//go:build (!linux && !darwin && !freebsd) || !cgo
+// +build !linux,!darwin,!freebsd !cgo
+
+package loader
+
+import "errors"
+
+func open(c component, path, disable string) (string, error) {
+	return "", errors.New("Go plugins are not supported on this platform")
+}
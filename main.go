@@ -2,12 +2,39 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "github.com/sevlyar/go-daemon"
 	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
 	"github.com/xroger88/go-janus/cmdflag"
 	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/events"
+	"github.com/xroger88/go-janus/loader"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/plugins/external"
+	"github.com/xroger88/go-janus/session"
+	"github.com/xroger88/go-janus/transports"
 	"github.com/xroger88/go-janus/util"
+	"github.com/xroger88/go-janus/webrtc"
+
+	// the plugins and transports register themselves, importing them is enough
+	_ "github.com/xroger88/go-janus/plugins/audiobridge"
+	_ "github.com/xroger88/go-janus/plugins/echotest"
+	_ "github.com/xroger88/go-janus/plugins/sip"
+	_ "github.com/xroger88/go-janus/plugins/streaming"
+	_ "github.com/xroger88/go-janus/plugins/textroom"
+	_ "github.com/xroger88/go-janus/plugins/videocall"
+	_ "github.com/xroger88/go-janus/plugins/videoroom"
+	_ "github.com/xroger88/go-janus/transports/grpcapi"
+	_ "github.com/xroger88/go-janus/transports/mqtt"
+	_ "github.com/xroger88/go-janus/transports/pfunix"
+	_ "github.com/xroger88/go-janus/transports/rabbitmq"
+	_ "github.com/xroger88/go-janus/transports/rest"
+	_ "github.com/xroger88/go-janus/transports/websockets"
 )
 
 func init() {
@@ -20,13 +47,14 @@ func main() {
 	// But these of stuffs will be rewriten with Cobra and Viper
 
 	// check simple flags
+	cmdflag.Parse()
 	if cmdflag.Flags.Show_help {
 		cmdflag.PrintHelpMessage()
 		return
 	}
 
 	if cmdflag.Flags.Show_version {
-		fmt.Println("Version: 0.1 (2018-07-17)")
+		fmt.Printf("Version: %s (commit %s, built %s)\n", api.VersionString, api.Commit, api.BuildTime)
 		return
 	}
 
@@ -53,6 +81,8 @@ func main() {
 		util.LogInit(cmdflag.Flags.Disable_stdout, log_file)
 	}
 
+	util.SetDebugLevel(config.Conf.General.Debug_level)
+
 	// show the configuration set overrided by command-line flags
 	if cmdflag.Flags.Show_config {
 		config.PrintAll()
@@ -61,4 +91,36 @@ func main() {
 
 	log.Infoln("*** I will make go-janus by referring janus source tree ***")
 
+	// a broken DTLS certificate or NAT setting is better found now than on the first call
+	if err := webrtc.Init(); err != nil {
+		log.Fatalf("Can't start the WebRTC stack: %v", err)
+	}
+
+	session.InitLoops(config.Conf.General.Event_loops, config.Conf.General.Allow_loop_indication)
+	session.StartWatchdog(time.Duration(config.Conf.General.Session_timeout)*time.Second,
+		time.Duration(config.Conf.General.Reclaim_session_timeout)*time.Second)
+
+	// components shipped as Go plugins or executables join the built-in ones
+	general := &config.Conf.General
+	loader.LoadEventHandlers(general.Events_folder, config.Conf.Events.Disable)
+	loader.LoadPlugins(general.Plugins_folder, config.Conf.Plugins.Disable)
+	external.LoadAll(general.Plugins_folder, config.Conf.Plugins.Disable)
+	loader.LoadTransports(general.Transports_folder, config.Conf.Transports.Disable)
+
+	events.InitAll(config.Conf.Events.Broadcast, config.Conf.Events.Disable, config.Conf.General.Configs_folder)
+	plugins.InitAll(config.Conf.Plugins.Disable, api.Callbacks, config.Conf.General.Configs_folder)
+
+	transports.InitAll(config.Conf.Transports.Disable, config.Conf.General.Configs_folder)
+
+	// run until we're told to stop
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Infof("Stopping on signal %v", sig)
+
+	transports.DestroyAll()
+	plugins.DestroyAll()
+	events.DestroyAll()
+	log.Infoln("Bye!")
+
 }
@@ -0,0 +1,426 @@
+package audiobridge
+
+// The AudioBridge plugin is an audio MCU: in each room, what participants
+// send is decoded and mixed, and each participant gets the mix of all the
+// others, encoded for it alone. Bandwidth stays the same however many
+// participants there are. Requests and events are the ones of
+// janus.plugin.audiobridge of the original Janus.
+//
+// Participants can use Opus, PCMU or PCMA; Opus needs libopus, and is only
+// available when built with -tags opus: without it, the plugin refuses to
+// start, as Opus is what browsers use.
+//
+// Rooms are managed with synchronous requests, answered right away:
+//
+//	{"request": "create", "room": 1234, "description": "...", "secret": "...",
+//	 "pin": "...", "is_private": false, "sampling_rate": 16000,
+//	 "audiolevel_event": false, "audio_active_packets": 100, "audio_level_average": 25,
+//	 "record": false, "record_file": "...", "record_dir": "...", "permanent": false}
+//	{"request": "edit", "room": 1234, "secret": "...", "new_description": "...", ...}
+//	{"request": "destroy", "room": 1234, "secret": "...", "permanent": false}
+//	{"request": "exists", "room": 1234}
+//	{"request": "list"}
+//	{"request": "listparticipants", "room": 1234}
+//	{"request": "kick", "room": 1234, "secret": "...", "id": 5678}
+//	{"request": "mute" or "unmute", "room": 1234, "secret": "...", "id": 5678}
+//	{"request": "enable_recording", "room": 1234, "secret": "...", "record": true,
+//	 "record_file": "...", "record_dir": "..."}
+//
+// Participants use asynchronous requests, answered with events:
+//
+//	{"request": "join", "room": 1234, "id": 5678, "pin": "...", "display": "...",
+//	 "muted": false, "volume": 100, "codec": "opus", "quality": 4, "expected_loss": 0}
+//	{"request": "configure", "muted": false, "display": "...", "volume": 100,
+//	 "quality": 4, "expected_loss": 0}
+//	{"request": "leave"}
+//
+// The offer of a participant comes with join or configure, and is answered
+// right away.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/plugins"
+)
+
+const Package = "janus.plugin.audiobridge"
+
+// error codes of the events and responses
+const (
+	ErrorNoMessage      = 480
+	ErrorInvalidJSON    = 481
+	ErrorInvalidRequest = 482
+	ErrorMissingElement = 483
+	ErrorInvalidElement = 484
+	ErrorNoSuchRoom     = 485
+	ErrorRoomExists     = 486
+	ErrorUnauthorized   = 487
+	ErrorNoSuchUser     = 488
+	ErrorCodec          = 489
+	ErrorNotJoined      = 490
+	ErrorAlreadyJoined  = 491
+	ErrorInvalidSDP     = 492
+	ErrorIDExists       = 493
+	ErrorUnknown        = 499
+)
+
+var errNoOpus = errors.New("built without Opus support, which needs libopus: rebuild with -tags opus")
+
+// Config is the content of janus.plugin.audiobridge.yaml in the configs folder
+type Config struct {
+	General struct {
+		// Admin_key, if set, must be provided to create rooms
+		Admin_key string
+		// Events tells whether to notify the event handlers
+		Events bool
+	}
+	// Rooms are created at startup; permanent rooms created or changed
+	// via the API are saved here too
+	Rooms []RoomConfig
+}
+
+type Plugin struct {
+	config     Config
+	configPath string
+	gateway    plugins.Callbacks
+	messages   chan *message
+	done       chan struct{}
+
+	// mutex protects rooms, and the rooms of the configuration
+	mutex sync.Mutex
+	rooms map[uint64]*room
+}
+
+// message is an asynchronous request waiting for the handler
+type message struct {
+	ps          *plugins.PluginSession
+	transaction string
+	request     string
+	body        json.RawMessage
+	jsep        *plugins.JSEP
+}
+
+// session is the state of a handle attached to the plugin
+type session struct {
+	ps *plugins.PluginSession
+
+	mutex sync.Mutex
+	// participant is nil until joined
+	participant *participant
+	hangingUp   int32
+	destroyed   int32
+}
+
+// requestError is an error along with the code to tell clients about it
+type requestError struct {
+	code int
+	err  error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func fail(code int, format string, args ...interface{}) error {
+	return &requestError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// errorEvent describes an error the way clients expect it
+func errorEvent(err error) map[string]interface{} {
+	code := ErrorUnknown
+	if e, ok := err.(*requestError); ok {
+		code = e.code
+	}
+	return map[string]interface{}{"audiobridge": "event", "error_code": code, "error": err.Error()}
+}
+
+// decode parses the body of a request, with the right error codes
+func decode(body json.RawMessage, out interface{}) error {
+	err := plugins.Decode(body, out)
+	switch {
+	case err == nil:
+		return nil
+	case err == plugins.ErrNoMessage:
+		return &requestError{ErrorNoMessage, err}
+	case err == plugins.ErrInvalidJSON:
+		return &requestError{ErrorInvalidJSON, err}
+	}
+	if e, ok := err.(*plugins.ElementError); ok && e.Missing {
+		return &requestError{ErrorMissingElement, err}
+	}
+	return &requestError{ErrorInvalidElement, err}
+}
+
+func missing(name string) error {
+	return &requestError{ErrorMissingElement, plugins.Missing(name)}
+}
+
+func invalid(name, reason string) error {
+	return &requestError{ErrorInvalidElement, plugins.Invalid(name, reason)}
+}
+
+func init() {
+	plugins.Register(New())
+}
+
+// New returns the AudioBridge plugin, to be started with Init
+func New() *Plugin {
+	return &Plugin{
+		messages: make(chan *message, 100),
+		done:     make(chan struct{}),
+		rooms:    make(map[uint64]*room),
+	}
+}
+
+func (p *Plugin) Package() string { return Package }
+func (p *Plugin) Name() string    { return "JANUS AudioBridge plugin" }
+func (p *Plugin) Description() string {
+	return "This is a plugin implementing an audio conference bridge for Janus, mixing Opus streams."
+}
+func (p *Plugin) Author() string        { return api.Author }
+func (p *Plugin) Version() int          { return api.Version }
+func (p *Plugin) VersionString() string { return api.VersionString }
+
+// Init reads the configuration, creates the rooms it lists and starts the
+// message handler
+func (p *Plugin) Init(gateway plugins.Callbacks, configPath string) error {
+	if coders["opus"] == nil {
+		return errNoOpus
+	}
+	p.config.General.Events = true
+	if err := config.LoadComponent(configPath, Package, &p.config); err != nil {
+		return err
+	}
+	p.configPath = configPath
+	p.gateway = gateway
+	for _, rc := range p.config.Rooms {
+		r, err := newRoom(rc)
+		if err != nil {
+			log.Warnf("[%s] Skipping room %d: %v", Package, rc.Room, err)
+			continue
+		}
+		if _, ok := p.rooms[r.Room]; ok {
+			log.Warnf("[%s] Skipping room %d: defined twice", Package, rc.Room)
+			continue
+		}
+		p.rooms[r.Room] = r
+		go p.mix(r)
+		log.Infof("[%s] Created room %d (%s)", Package, r.Room, r.Description)
+	}
+	go p.handler()
+	return nil
+}
+
+func (p *Plugin) Destroy() {
+	select {
+	case <-p.done:
+		return
+	default:
+		close(p.done)
+	}
+	p.mutex.Lock()
+	for id, r := range p.rooms {
+		r.stop()
+		delete(p.rooms, id)
+	}
+	p.mutex.Unlock()
+	log.Infof("%s destroyed", Package)
+}
+
+func getSession(ps *plugins.PluginSession) *session {
+	s, _ := ps.Plugin.(*session)
+	if s == nil || atomic.LoadInt32(&s.destroyed) == 1 {
+		return nil
+	}
+	return s
+}
+
+func (s *session) getParticipant() *participant {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.participant
+}
+
+func (p *Plugin) CreateSession(ps *plugins.PluginSession) error {
+	ps.Plugin = &session{ps: ps}
+	return nil
+}
+
+// DestroySession makes the participant leave, if it didn't yet
+func (p *Plugin) DestroySession(ps *plugins.PluginSession) error {
+	s := getSession(ps)
+	if s == nil {
+		return fmt.Errorf("no session associated with this handle")
+	}
+	atomic.StoreInt32(&s.destroyed, 1)
+	if pt := s.getParticipant(); pt != nil {
+		p.leave(pt, nil)
+	}
+	return nil
+}
+
+func (p *Plugin) QuerySession(ps *plugins.PluginSession) interface{} {
+	s := getSession(ps)
+	if s == nil {
+		return nil
+	}
+	info := map[string]interface{}{
+		"hangingup": atomic.LoadInt32(&s.hangingUp),
+		"destroyed": atomic.LoadInt32(&s.destroyed),
+	}
+	if pt := s.getParticipant(); pt != nil {
+		pt.query(info)
+	}
+	return info
+}
+
+// HandleMessage answers the requests about rooms right away, and queues the
+// ones of participants for the handler
+func (p *Plugin) HandleMessage(ps *plugins.PluginSession, transaction string, body json.RawMessage, jsep *plugins.JSEP) *plugins.Result {
+	if getSession(ps) == nil {
+		return &plugins.Result{Type: plugins.ResultError, Text: "No session associated with this handle"}
+	}
+	var r struct {
+		Request *string `json:"request"`
+	}
+	if err := decode(body, &r); err != nil {
+		return &plugins.Result{Type: plugins.ResultOK, Content: errorEvent(err)}
+	}
+	if r.Request == nil {
+		return &plugins.Result{Type: plugins.ResultOK, Content: errorEvent(missing("request"))}
+	}
+	switch *r.Request {
+	case "create", "edit", "destroy", "exists", "list", "listparticipants", "kick", "mute", "unmute", "enable_recording":
+		return &plugins.Result{Type: plugins.ResultOK, Content: p.manage(*r.Request, body, false)}
+	case "join", "configure", "leave":
+	default:
+		err := fail(ErrorInvalidRequest, "Unknown request '%s'", *r.Request)
+		return &plugins.Result{Type: plugins.ResultOK, Content: errorEvent(err)}
+	}
+	select {
+	case p.messages <- &message{ps: ps, transaction: transaction, request: *r.Request, body: body, jsep: jsep}:
+	case <-p.done:
+		return &plugins.Result{Type: plugins.ResultError, Text: "Shutting down"}
+	}
+	return &plugins.Result{Type: plugins.ResultOKWait}
+}
+
+// HandleAdminMessage accepts the requests about rooms via the Admin API
+func (p *Plugin) HandleAdminMessage(body json.RawMessage) interface{} {
+	var r struct {
+		Request *string `json:"request"`
+	}
+	if err := decode(body, &r); err != nil {
+		return errorEvent(err)
+	}
+	if r.Request == nil {
+		return errorEvent(missing("request"))
+	}
+	switch *r.Request {
+	case "create", "edit", "destroy", "exists", "list", "listparticipants", "kick", "mute", "unmute", "enable_recording":
+		return p.manage(*r.Request, body, true)
+	}
+	return errorEvent(fail(ErrorInvalidRequest, "Unknown request '%s'", *r.Request))
+}
+
+func (p *Plugin) SetupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil {
+		return
+	}
+	log.Infof("[%s] WebRTC media is now available", Package)
+	atomic.StoreInt32(&s.hangingUp, 0)
+	if pt := s.getParticipant(); pt != nil {
+		p.setup(pt)
+	}
+}
+
+func (p *Plugin) IncomingRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 || packet.Video {
+		return
+	}
+	if pt := s.getParticipant(); pt != nil {
+		p.incoming(pt, packet.Buffer)
+	}
+}
+
+// participants get the mix, and nothing else: RTCP is left to the core
+func (p *Plugin) IncomingRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {}
+func (p *Plugin) IncomingData(ps *plugins.PluginSession, packet *plugins.DataPacket) {}
+func (p *Plugin) SlowLink(ps *plugins.PluginSession, uplink, video bool)             {}
+
+// HangupMedia makes the participant leave the room
+func (p *Plugin) HangupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil || !atomic.CompareAndSwapInt32(&s.hangingUp, 0, 1) {
+		return
+	}
+	log.Infof("[%s] No WebRTC media anymore", Package)
+	if pt := s.getParticipant(); pt != nil {
+		p.leave(pt, nil)
+	}
+}
+
+func (p *Plugin) notify(ps *plugins.PluginSession, event map[string]interface{}) {
+	if p.config.General.Events && p.gateway.EventsIsEnabled() {
+		p.gateway.NotifyEvent(p, ps, event)
+	}
+}
+
+// handler processes the requests of participants, one at a time
+func (p *Plugin) handler() {
+	for {
+		select {
+		case m := <-p.messages:
+			p.handle(m)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Plugin) handle(m *message) {
+	s := getSession(m.ps)
+	if s == nil {
+		log.Warnf("[%s] No session associated with this handle", Package)
+		return
+	}
+	var (
+		event map[string]interface{}
+		jsep  *plugins.JSEP
+		err   error
+	)
+	pt := s.getParticipant()
+	switch {
+	case m.request == "join":
+		if pt != nil {
+			err = fail(ErrorAlreadyJoined, "Already in a room (leave it first)")
+			break
+		}
+		event, jsep, err = p.join(s, m)
+	case pt == nil:
+		err = fail(ErrorNotJoined, "Can't %s (not in a room)", m.request)
+	case m.request == "configure":
+		event, jsep, err = p.configure(pt, m)
+	case m.request == "leave":
+		p.leave(pt, nil)
+		p.gateway.ClosePC(s.ps)
+		event = map[string]interface{}{"audiobridge": "left", "room": pt.room.Room, "id": pt.id}
+	}
+	if err != nil {
+		log.Warnf("[%s] %v", Package, err)
+		if err := p.gateway.PushEvent(m.ps, p, m.transaction, errorEvent(err), nil); err != nil {
+			log.Warnf("[%s] Error pushing event: %v", Package, err)
+		}
+		return
+	}
+	if err := p.gateway.PushEvent(m.ps, p, m.transaction, event, jsep); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+}
@@ -0,0 +1,311 @@
+package audiobridge
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/plugins"
+)
+
+// Init wants Opus, which needs libopus: without the opus build tag, G.711
+// stands in for it, and the tests only offer PCMU anyway
+func TestMain(m *testing.M) {
+	if coders["opus"] == nil {
+		coders["opus"] = coders["pcmu"]
+	}
+	os.Exit(m.Run())
+}
+
+// testGateway stands in for the core: the events of the plugin go to the
+// channel each handle has in its Gateway, the mix it sends to rtp
+type testGateway struct {
+	plugins.Callbacks
+	rtp chan relayed
+}
+
+type testEvent struct {
+	transaction string
+	data        map[string]interface{}
+	jsep        *plugins.JSEP
+}
+
+type relayed struct {
+	ps     *plugins.PluginSession
+	packet *plugins.RTPPacket
+}
+
+func (g *testGateway) PushEvent(ps *plugins.PluginSession, plugin plugins.Plugin, transaction string, message interface{}, jsep *plugins.JSEP) error {
+	e := testEvent{transaction: transaction, jsep: jsep}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &e.data); err != nil {
+		return err
+	}
+	ps.Gateway.(chan testEvent) <- e
+	return nil
+}
+
+// RelayRTP drops what the test doesn't take in time: the mixer sends a
+// frame every 20ms
+func (g *testGateway) RelayRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	select {
+	case g.rtp <- relayed{ps, packet}:
+	default:
+	}
+}
+
+func (g *testGateway) RelayRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {}
+func (g *testGateway) RelayData(ps *plugins.PluginSession, packet *plugins.DataPacket) {}
+func (g *testGateway) ClosePC(ps *plugins.PluginSession)                               {}
+func (g *testGateway) EventsIsEnabled() bool                                           { return false }
+
+func startPlugin(t *testing.T) (*Plugin, *testGateway) {
+	p := New()
+	g := &testGateway{rtp: make(chan relayed, 100)}
+	if err := p.Init(g, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Destroy)
+	return p, g
+}
+
+// attach creates a handle, whose events can be taken with next
+func attach(t *testing.T, p *Plugin) *plugins.PluginSession {
+	ps := plugins.NewPluginSession(make(chan testEvent, 100))
+	if err := p.CreateSession(ps); err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+func next(t *testing.T, ps *plugins.PluginSession) testEvent {
+	t.Helper()
+	select {
+	case e := <-ps.Gateway.(chan testEvent):
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return testEvent{}
+}
+
+// request sends a synchronous request, and returns the response
+func request(t *testing.T, p *Plugin, ps *plugins.PluginSession, body string) map[string]interface{} {
+	t.Helper()
+	r := p.HandleMessage(ps, "", json.RawMessage(body), nil)
+	if r.Type != plugins.ResultOK {
+		t.Fatalf("got %+v sending %s", r, body)
+	}
+	data, _ := json.Marshal(r.Content)
+	var response map[string]interface{}
+	json.Unmarshal(data, &response)
+	return response
+}
+
+// send sends an asynchronous request, and returns the event answering it
+func send(t *testing.T, p *Plugin, ps *plugins.PluginSession, body string, jsep *plugins.JSEP) testEvent {
+	t.Helper()
+	if r := p.HandleMessage(ps, "t", json.RawMessage(body), jsep); r.Type != plugins.ResultOKWait {
+		t.Fatalf("got %+v sending %s", r, body)
+	}
+	for {
+		if e := next(t, ps); e.transaction == "t" {
+			return e
+		}
+	}
+}
+
+func code(m map[string]interface{}) int {
+	c, _ := m["error_code"].(float64)
+	return int(c)
+}
+
+const offer = "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 0\r\nc=IN IP4 0.0.0.0\r\na=mid:0\r\na=rtpmap:0 PCMU/8000\r\na=sendrecv\r\n"
+
+func TestRooms(t *testing.T) {
+	p, _ := startPlugin(t)
+	ps := attach(t, p)
+	tests := []struct {
+		name string
+		body string
+		want string // the audiobridge element of the response
+		code int
+	}{
+		{"create", `{"request":"create","room":1234,"secret":"s","description":"test"}`, "created", 0},
+		{"create again", `{"request":"create","room":1234}`, "", ErrorRoomExists},
+		{"invalid sampling rate", `{"request":"create","sampling_rate":44100}`, "", ErrorInvalidElement},
+		{"exists", `{"request":"exists","room":1234}`, "success", 0},
+		{"list", `{"request":"list"}`, "success", 0},
+		{"edit without the secret", `{"request":"edit","room":1234,"new_description":"x"}`, "", ErrorUnauthorized},
+		{"edit", `{"request":"edit","room":1234,"secret":"s","new_description":"x"}`, "edited", 0},
+		{"listparticipants", `{"request":"listparticipants","room":1234}`, "participants", 0},
+		{"no such room", `{"request":"listparticipants","room":42}`, "", ErrorNoSuchRoom},
+		{"missing room", `{"request":"destroy"}`, "", ErrorMissingElement},
+		{"kick nobody", `{"request":"kick","room":1234,"secret":"s","id":5}`, "", ErrorNoSuchUser},
+		{"mute nobody", `{"request":"mute","room":1234,"secret":"s","id":5}`, "", ErrorNoSuchUser},
+		{"recording without record", `{"request":"enable_recording","room":1234,"secret":"s"}`, "", ErrorMissingElement},
+		{"unknown request", `{"request":"dance"}`, "", ErrorInvalidRequest},
+		{"no request", `{}`, "", ErrorMissingElement},
+		{"invalid request", `{"request":5}`, "", ErrorInvalidElement},
+		{"destroy", `{"request":"destroy","room":1234,"secret":"s"}`, "destroyed", 0},
+		{"destroy again", `{"request":"destroy","room":1234,"secret":"s"}`, "", ErrorNoSuchRoom},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := request(t, p, ps, test.body)
+			if code(r) != test.code {
+				t.Fatalf("got %v, want error code %d", r, test.code)
+			}
+			if test.want != "" && r["audiobridge"] != test.want {
+				t.Errorf("got %v, want %s", r, test.want)
+			}
+		})
+	}
+}
+
+func TestJoinAndLeave(t *testing.T) {
+	p, g := startPlugin(t)
+	admin := attach(t, p)
+	request(t, p, admin, `{"request":"create","room":1,"secret":"s","pin":"1234","sampling_rate":8000}`)
+
+	alice := attach(t, p)
+	tests := []struct {
+		name string
+		body string
+		jsep *plugins.JSEP
+		code int
+	}{
+		{"missing room", `{"request":"join"}`, nil, ErrorMissingElement},
+		{"no such room", `{"request":"join","room":42}`, nil, ErrorNoSuchRoom},
+		{"wrong pin", `{"request":"join","room":1,"pin":"0000"}`, nil, ErrorUnauthorized},
+		{"invalid codec", `{"request":"join","room":1,"pin":"1234","codec":"nope"}`, nil, ErrorInvalidElement},
+		{"invalid quality", `{"request":"join","room":1,"pin":"1234","quality":11}`, nil, ErrorInvalidElement},
+		{"answer", `{"request":"join","room":1,"pin":"1234"}`, &plugins.JSEP{Type: "answer", SDP: offer}, ErrorInvalidSDP},
+		{"configure before joining", `{"request":"configure","muted":true}`, nil, ErrorNotJoined},
+		{"leave before joining", `{"request":"leave"}`, nil, ErrorNotJoined},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if e := send(t, p, alice, test.body, test.jsep); code(e.data) != test.code {
+				t.Errorf("got %v, want error code %d", e.data, test.code)
+			}
+		})
+	}
+
+	e := send(t, p, alice, `{"request":"join","room":1,"pin":"1234","id":1,"display":"alice"}`, &plugins.JSEP{Type: "offer", SDP: offer})
+	if e.data["audiobridge"] != "joined" || e.jsep == nil || e.jsep.Type != "answer" {
+		t.Fatalf("got %v %+v joining", e.data, e.jsep)
+	}
+	if e := send(t, p, alice, `{"request":"join","room":1,"pin":"1234"}`, nil); code(e.data) != ErrorAlreadyJoined {
+		t.Errorf("got %v joining twice", e.data)
+	}
+	bob := attach(t, p)
+	if e := send(t, p, bob, `{"request":"join","room":1,"pin":"1234","id":1}`, nil); code(e.data) != ErrorIDExists {
+		t.Errorf("got %v joining with the id of another", e.data)
+	}
+	e = send(t, p, bob, `{"request":"join","room":1,"pin":"1234","id":2,"display":"bob"}`, &plugins.JSEP{Type: "offer", SDP: offer})
+	if list, _ := e.data["participants"].([]interface{}); len(list) != 1 || list[0].(map[string]interface{})["display"] != "alice" {
+		t.Errorf("got %v, want alice in the room", e.data)
+	}
+	if e := next(t, alice); e.data["participants"] == nil {
+		t.Errorf("got %v, want bob joining", e.data)
+	}
+	p.SetupMedia(alice)
+	p.SetupMedia(bob)
+
+	// bob hears alice
+	loud := make([]byte, 12+160)
+	loud[0], loud[11] = 0x80, 1
+	for i := 12; i < len(loud); i++ {
+		loud[i] = 0x80 // µ-law for a loud positive sample
+	}
+	heard := false
+	for seq, start := uint16(1), time.Now(); !heard; seq++ {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("bob didn't hear alice")
+		}
+		loud[2], loud[3] = byte(seq>>8), byte(seq)
+		p.IncomingRTP(alice, &plugins.RTPPacket{Mindex: -1, Buffer: append([]byte(nil), loud...)})
+		select {
+		case r := <-g.rtp:
+			if r.ps != bob {
+				continue
+			}
+			if r.packet.Buffer[1]&0x7f != 0 {
+				t.Fatalf("got payload type %d, want PCMU", r.packet.Buffer[1]&0x7f)
+			}
+			for _, b := range r.packet.Buffer[12:] {
+				heard = heard || b != 0xff
+			}
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	if e := send(t, p, bob, `{"request":"configure","volume":-1}`, nil); code(e.data) != ErrorInvalidElement {
+		t.Errorf("got %v with a negative volume", e.data)
+	}
+	if e := send(t, p, bob, `{"request":"configure","muted":true}`, nil); e.data["result"] != "ok" {
+		t.Errorf("got %v configuring", e.data)
+	}
+	if r := request(t, p, admin, `{"request":"kick","room":1,"id":2}`); code(r) != ErrorUnauthorized {
+		t.Errorf("got %v kicking without the secret", r)
+	}
+	if r := request(t, p, admin, `{"request":"kick","room":1,"secret":"s","id":2}`); r["audiobridge"] != "success" {
+		t.Fatalf("got %v kicking", r)
+	}
+	for {
+		if e := next(t, bob); e.data["kicked"] != nil {
+			break
+		}
+	}
+	if e := send(t, p, bob, `{"request":"leave"}`, nil); code(e.data) != ErrorNotJoined {
+		t.Errorf("got %v leaving once kicked", e.data)
+	}
+	if e := send(t, p, alice, `{"request":"leave"}`, nil); e.data["audiobridge"] != "left" {
+		t.Errorf("got %v leaving", e.data)
+	}
+	if r := request(t, p, admin, `{"request":"listparticipants","room":1}`); len(r["participants"].([]interface{})) != 0 {
+		t.Errorf("got %v once everybody left", r)
+	}
+
+	// destroying the handle leaves the room too
+	send(t, p, alice, `{"request":"join","room":1,"pin":"1234"}`, nil)
+	if err := p.DestroySession(alice); err != nil {
+		t.Fatal(err)
+	}
+	if r := request(t, p, admin, `{"request":"listparticipants","room":1}`); len(r["participants"].([]interface{})) != 0 {
+		t.Errorf("got %v once the handle was destroyed", r)
+	}
+	if r := p.HandleMessage(alice, "t", json.RawMessage(`{"request":"leave"}`), nil); r.Type != plugins.ResultError {
+		t.Errorf("got %+v after destroying the handle", r)
+	}
+}
+
+func TestDestroyRoom(t *testing.T) {
+	p, _ := startPlugin(t)
+	admin := attach(t, p)
+	request(t, p, admin, `{"request":"create","room":1,"secret":"s"}`)
+	alice := attach(t, p)
+	if e := send(t, p, alice, `{"request":"join","room":1}`, nil); e.data["audiobridge"] != "joined" {
+		t.Fatalf("got %v joining", e.data)
+	}
+
+	// the participants are told, and are out
+	if r := request(t, p, admin, `{"request":"destroy","room":1,"secret":"s"}`); r["audiobridge"] != "destroyed" {
+		t.Fatalf("got %v destroying", r)
+	}
+	if e := next(t, alice); e.data["audiobridge"] != "destroyed" {
+		t.Errorf("got %v, want the room destroyed", e.data)
+	}
+	if e := send(t, p, alice, `{"request":"configure","muted":true}`, nil); code(e.data) != ErrorNotJoined {
+		t.Errorf("got %v configuring in a destroyed room", e.data)
+	}
+	if r := request(t, p, admin, `{"request":"exists","room":1}`); r["exists"] != false {
+		t.Errorf("got %v", r)
+	}
+}
@@ -0,0 +1,148 @@
+package audiobridge
+
+// coder decodes what a participant sends, and encodes the mix it gets, at
+// the sampling rate of the room; it's used with the mutex of the
+// participant held
+type coder interface {
+	// decode appends the samples of a payload to pcm
+	decode(payload []byte, pcm []int16) ([]int16, error)
+	// encode returns the payload for a frame of samples
+	encode(pcm []int16) ([]byte, error)
+	// configure sets the quality of the encoding (1-10, for the complexity)
+	// and the expected packet loss (in %, for the FEC)
+	configure(quality, loss int)
+}
+
+// coders create coders for the codecs participants can use; G.711 is always
+// there, Opus needs the opus build tag (see opus.go)
+var coders = map[string]func(rate int) (coder, error){
+	"pcmu": func(rate int) (coder, error) { return &g711{rate: rate}, nil },
+	"pcma": func(rate int) (coder, error) { return &g711{rate: rate, alaw: true}, nil },
+}
+
+// preference is the order in which the codecs of an offer are picked
+var preference = []string{"opus", "pcmu", "pcma"}
+
+// g711 is PCMU (µ-law) or PCMA (A-law), always sampled at 8000 Hz
+type g711 struct {
+	rate int
+	alaw bool
+}
+
+func (c *g711) decode(payload []byte, pcm []int16) ([]int16, error) {
+	samples := make([]int16, len(payload))
+	for i, b := range payload {
+		if c.alaw {
+			samples[i] = alawDecode(b)
+		} else {
+			samples[i] = ulawDecode(b)
+		}
+	}
+	return append(pcm, resample(samples, 8000, c.rate)...), nil
+}
+
+func (c *g711) encode(pcm []int16) ([]byte, error) {
+	samples := resample(pcm, c.rate, 8000)
+	payload := make([]byte, len(samples))
+	for i, s := range samples {
+		if c.alaw {
+			payload[i] = alawEncode(s)
+		} else {
+			payload[i] = ulawEncode(s)
+		}
+	}
+	return payload, nil
+}
+
+func (c *g711) configure(quality, loss int) {}
+
+// µ-law and A-law as in the reference implementation of G.711
+const (
+	ulawBias = 0x84
+	ulawClip = 32635
+)
+
+func ulawEncode(s int16) byte {
+	sample, sign := int(s), 0
+	if sample < 0 {
+		sample, sign = -sample, 0x80
+	}
+	if sample > ulawClip {
+		sample = ulawClip
+	}
+	sample += ulawBias
+	exponent := 7
+	for mask := 0x4000; sample&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (sample >> uint(exponent+3)) & 0x0f
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+func ulawDecode(u byte) int16 {
+	u = ^u
+	exponent := uint(u>>4) & 0x07
+	sample := (int(u&0x0f)<<3+ulawBias)<<exponent - ulawBias
+	if u&0x80 != 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
+
+func alawEncode(s int16) byte {
+	sample, mask := int(s)>>3, 0xd5
+	if sample < 0 {
+		sample, mask = -sample-1, 0x55
+	}
+	segment := 0
+	for end := 0x1f; segment < 8 && sample > end; end = end<<1 | 1 {
+		segment++
+	}
+	if segment >= 8 {
+		return byte(0x7f ^ mask)
+	}
+	a := segment << 4
+	if segment < 2 {
+		a |= (sample >> 1) & 0x0f
+	} else {
+		a |= (sample >> uint(segment)) & 0x0f
+	}
+	return byte(a ^ mask)
+}
+
+func alawDecode(a byte) int16 {
+	a ^= 0x55
+	sample := int(a&0x0f) << 4
+	switch segment := uint(a&0x70) >> 4; segment {
+	case 0:
+		sample += 8
+	case 1:
+		sample += 0x108
+	default:
+		sample = (sample + 0x108) << (segment - 1)
+	}
+	if a&0x80 != 0 {
+		return int16(sample)
+	}
+	return int16(-sample)
+}
+
+// resample converts samples from a rate to another, with a linear
+// interpolation: it's only used for G.711, whose quality doesn't deserve
+// more than that
+func resample(in []int16, from, to int) []int16 {
+	if from == to || len(in) == 0 {
+		return in
+	}
+	out := make([]int16, len(in)*to/from)
+	for i := range out {
+		pos := i * from
+		j, frac := pos/to, pos%to
+		s := int(in[j])
+		if j+1 < len(in) {
+			s += (int(in[j+1]) - s) * frac / to
+		}
+		out[i] = int16(s)
+	}
+	return out
+}
@@ -0,0 +1,173 @@
+package audiobridge
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/rtp"
+)
+
+// the mixer works on 20ms frames
+const framesPerSecond = 50
+
+// mix mixes the audio of a room every 20ms, until it's destroyed: each
+// participant gets the mix minus its own voice
+func (p *Plugin) mix(rm *room) {
+	samples := rm.Sampling_rate / framesPerSecond
+	mix := make([]int32, samples)
+	pcm := make([]int16, samples)
+	var rec *wavWriter
+	defer func() {
+		if rec != nil {
+			p.stopRecording(rm, rec)
+		}
+	}()
+	ticker := time.NewTicker(time.Second / framesPerSecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rm.done:
+			return
+		case <-ticker.C:
+		}
+		rm.mutex.Lock()
+		list := rm.list()
+		record, file, dir := rm.Record, rm.Record_file, rm.Record_dir
+		rm.mutex.Unlock()
+
+		switch {
+		case record && rec == nil:
+			rec = p.startRecording(rm, file, dir)
+		case !record && rec != nil:
+			p.stopRecording(rm, rec)
+			rec = nil
+		}
+		if len(list) == 0 && rec == nil {
+			continue
+		}
+
+		for i := range mix {
+			mix[i] = 0
+		}
+		frames := make([][]int32, len(list))
+		for i, pt := range list {
+			if frames[i] = pt.take(samples); frames[i] != nil {
+				for j, s := range frames[i] {
+					mix[j] += s
+				}
+			}
+		}
+		if rec != nil {
+			clip(mix, nil, pcm)
+			if err := rec.write(pcm); err != nil {
+				log.Errorf("[%s] Error recording room %d: %v", Package, rm.Room, err)
+				p.stopRecording(rm, rec)
+				rec = nil
+				rm.mutex.Lock()
+				rm.Record = false
+				rm.mutex.Unlock()
+			}
+		}
+		for i, pt := range list {
+			clip(mix, frames[i], pcm)
+			p.send(pt, pcm)
+		}
+	}
+}
+
+// take returns the next frame of a participant, with its volume applied,
+// or nil if there's nothing to mix
+func (pt *participant) take(samples int) []int32 {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	if !pt.active || pt.muted || len(pt.pending) < samples {
+		return nil
+	}
+	if len(pt.frame) != samples {
+		pt.frame = make([]int32, samples)
+	}
+	for i, s := range pt.pending[:samples] {
+		pt.frame[i] = int32(s) * int32(pt.volume) / 100
+	}
+	pt.pending = append(pt.pending[:0], pt.pending[samples:]...)
+	return pt.frame
+}
+
+// clip puts the mix, minus own if not nil, in pcm
+func clip(mix, own []int32, pcm []int16) {
+	for i, s := range mix {
+		if own != nil {
+			s -= own[i]
+		}
+		switch {
+		case s > math.MaxInt16:
+			s = math.MaxInt16
+		case s < math.MinInt16:
+			s = math.MinInt16
+		}
+		pcm[i] = int16(s)
+	}
+}
+
+// send encodes a frame for a participant, and sends it
+func (p *Plugin) send(pt *participant, pcm []int16) {
+	pt.mutex.Lock()
+	if !pt.active || pt.coder == nil {
+		pt.mutex.Unlock()
+		return
+	}
+	payload, err := pt.coder.encode(pcm)
+	if err != nil {
+		pt.mutex.Unlock()
+		log.Warnf("[%s] Error encoding audio for participant %d: %v", Package, pt.id, err)
+		return
+	}
+	buf := make([]byte, rtp.HeaderSize, rtp.HeaderSize+len(payload))
+	buf[0] = 0x80
+	rtp.SetPayloadType(buf, uint8(pt.pt))
+	pt.seq++
+	pt.ts += pt.tsStep
+	rtp.SetSeq(buf, pt.seq)
+	rtp.SetTimestamp(buf, pt.ts)
+	pt.mutex.Unlock()
+	p.gateway.RelayRTP(pt.s.ps, &plugins.RTPPacket{Mindex: -1, Buffer: append(buf, payload...)})
+}
+
+// startRecording creates the WAV file the mix of a room is recorded to
+func (p *Plugin) startRecording(rm *room, file, dir string) *wavWriter {
+	if file == "" {
+		file = fmt.Sprintf("janus-audioroom-%d-%d.wav", rm.Room, time.Now().Unix())
+	}
+	if dir != "" {
+		file = filepath.Join(dir, file)
+	}
+	rec, err := createWAV(file, rm.Sampling_rate)
+	if err != nil {
+		log.Errorf("[%s] Error recording room %d: %v", Package, rm.Room, err)
+		rm.mutex.Lock()
+		rm.Record = false
+		rm.mutex.Unlock()
+		return nil
+	}
+	log.Infof("[%s] Recording room %d to %s", Package, rm.Room, file)
+	rm.mutex.Lock()
+	rm.recording = file
+	rm.mutex.Unlock()
+	p.notify(nil, map[string]interface{}{"event": "recording", "room": rm.Room, "record": true, "file": file})
+	return rec
+}
+
+func (p *Plugin) stopRecording(rm *room, rec *wavWriter) {
+	if err := rec.close(); err != nil {
+		log.Errorf("[%s] Error closing the recording of room %d: %v", Package, rm.Room, err)
+	}
+	log.Infof("[%s] Stopped recording room %d to %s", Package, rm.Room, rec.path)
+	rm.mutex.Lock()
+	rm.recording = ""
+	rm.mutex.Unlock()
+	p.notify(nil, map[string]interface{}{"event": "recording", "room": rm.Room, "record": false, "file": rec.path})
+}
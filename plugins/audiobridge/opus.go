@@ -0,0 +1,66 @@
+//go:build opus
+// +build opus
+
+package audiobridge
+
+// Opus support needs libopus (and its headers) to build, so it's only there
+// when building with -tags opus.
+
+import (
+	opus "gopkg.in/hraban/opus.v2"
+)
+
+func init() {
+	coders["opus"] = newOpus
+}
+
+// maximum duration of an Opus packet
+const maxOpusFrame = 120
+
+type opusCoder struct {
+	dec *opus.Decoder
+	enc *opus.Encoder
+	pcm []int16
+	out []byte
+}
+
+func newOpus(rate int) (coder, error) {
+	dec, err := opus.NewDecoder(rate, 1)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := opus.NewEncoder(rate, 1, opus.AppVoIP)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.SetInBandFEC(true); err != nil {
+		return nil, err
+	}
+	return &opusCoder{
+		dec: dec,
+		enc: enc,
+		pcm: make([]int16, rate*maxOpusFrame/1000),
+		out: make([]byte, 1500),
+	}, nil
+}
+
+func (c *opusCoder) decode(payload []byte, pcm []int16) ([]int16, error) {
+	n, err := c.dec.Decode(payload, c.pcm)
+	if err != nil {
+		return pcm, err
+	}
+	return append(pcm, c.pcm[:n]...), nil
+}
+
+func (c *opusCoder) encode(pcm []int16) ([]byte, error) {
+	n, err := c.enc.Encode(pcm, c.out)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), c.out[:n]...), nil
+}
+
+func (c *opusCoder) configure(quality, loss int) {
+	c.enc.SetComplexity(quality)
+	c.enc.SetPacketLossPerc(loss)
+}
@@ -0,0 +1,439 @@
+package audiobridge
+
+import (
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sdp"
+	"github.com/xroger88/go-janus/util"
+)
+
+// participant is the member of a room a session joined as
+type participant struct {
+	s    *session
+	room *room
+	id   uint64
+
+	mutex   sync.Mutex
+	display string
+	muted   bool
+	// volume is the gain (in %) of what the participant sends
+	volume int
+	// quality and expectedLoss tune the Opus encoder
+	quality, expectedLoss int
+	// codec is the one asked for at join, if any
+	codec string
+
+	// the negotiated codec and its payload type; active is set once the
+	// PeerConnection is up
+	offered, active bool
+	pt              int
+	coder           coder
+	levelExtID      int
+
+	// pending are the decoded samples the mixer didn't take yet
+	pending []int16
+	lastSeq uint16
+	gotSeq  bool
+	// seq and ts of what's sent, the timestamp going up by tsStep per frame
+	seq    uint16
+	ts     uint32
+	tsStep uint32
+	// frame is where the mixer puts what it takes
+	frame []int32
+
+	// talking detection, from the audio levels
+	talking    bool
+	levelSum   int
+	levelCount int
+}
+
+// maximum delay of what's pending, in frames: the oldest samples are
+// dropped beyond that
+const maxPending = 10
+
+// describe is how a participant appears in events; the caller holds its
+// mutex
+func (pt *participant) describe() map[string]interface{} {
+	d := map[string]interface{}{"id": pt.id, "setup": pt.active, "muted": pt.muted}
+	if pt.display != "" {
+		d["display"] = pt.display
+	}
+	if enabled, _, _ := pt.room.audioLevels(); enabled {
+		d["talking"] = pt.talking
+	}
+	return d
+}
+
+func (pt *participant) query(info map[string]interface{}) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	info["room"] = pt.room.Room
+	info["id"] = pt.id
+	if pt.display != "" {
+		info["display"] = pt.display
+	}
+	info["muted"] = pt.muted
+	info["active"] = pt.active
+	info["volume_gain"] = pt.volume
+	info["pending_samples"] = len(pt.pending)
+	if pt.offered {
+		info["codec"] = pt.codec
+		info["payload_type"] = pt.pt
+	}
+	if enabled, _, _ := pt.room.audioLevels(); enabled {
+		info["talking"] = pt.talking
+	}
+}
+
+// join adds the session to a room
+func (p *Plugin) join(s *session, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Room         *uint64 `json:"room"`
+		ID           *uint64 `json:"id"`
+		Pin          string  `json:"pin"`
+		Display      string  `json:"display"`
+		Muted        bool    `json:"muted"`
+		Volume       *int    `json:"volume"`
+		Codec        string  `json:"codec"`
+		Quality      *int    `json:"quality"`
+		ExpectedLoss *int    `json:"expected_loss"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Room == nil {
+		return nil, nil, missing("room")
+	}
+	if r.ID != nil && *r.ID == 0 {
+		return nil, nil, invalid("id", "should not be 0")
+	}
+	if r.Codec != "" && coders[r.Codec] == nil {
+		return nil, nil, invalid("codec", "is not a supported codec")
+	}
+	pt := &participant{s: s, display: r.Display, muted: r.Muted, volume: 100, quality: 4, codec: r.Codec}
+	if r.ID != nil {
+		pt.id = *r.ID
+	}
+	if err := pt.tune(r.Volume, r.Quality, r.ExpectedLoss); err != nil {
+		return nil, nil, err
+	}
+	rm, err := p.room(*r.Room)
+	if err != nil {
+		return nil, nil, err
+	}
+	rm.mutex.Lock()
+	if rm.destroyed {
+		rm.mutex.Unlock()
+		return nil, nil, fail(ErrorNoSuchRoom, "No such room (%d)", rm.Room)
+	}
+	if rm.Pin != "" && r.Pin != rm.Pin {
+		rm.mutex.Unlock()
+		return nil, nil, fail(ErrorUnauthorized, "Unauthorized (wrong pin)")
+	}
+	if pt.id != 0 && rm.participants[pt.id] != nil {
+		rm.mutex.Unlock()
+		return nil, nil, fail(ErrorIDExists, "User ID %d already exists", pt.id)
+	}
+	for pt.id == 0 || rm.participants[pt.id] != nil {
+		pt.id = util.RandomUint64()
+	}
+	pt.room = rm
+	others := rm.list()
+	rm.participants[pt.id] = pt
+	rm.mutex.Unlock()
+	s.mutex.Lock()
+	s.participant = pt
+	s.mutex.Unlock()
+
+	list := make([]map[string]interface{}, 0, len(others))
+	for _, other := range others {
+		other.mutex.Lock()
+		list = append(list, other.describe())
+		other.mutex.Unlock()
+	}
+	log.Infof("[%s] Participant %d joined room %d", Package, pt.id, rm.Room)
+	p.changed(pt)
+	p.notify(s.ps, map[string]interface{}{"event": "joined", "room": rm.Room, "id": pt.id, "display": pt.display, "muted": pt.muted})
+	event := map[string]interface{}{"audiobridge": "joined", "room": rm.Room, "id": pt.id, "participants": list}
+	if pt.display != "" {
+		event["display"] = pt.display
+	}
+	if m.jsep == nil {
+		return event, nil, nil
+	}
+	answer, err := p.negotiate(pt, m.jsep)
+	if err != nil {
+		p.leave(pt, nil)
+		return nil, nil, err
+	}
+	return event, answer, nil
+}
+
+// tune checks and sets the volume and the encoding settings
+func (pt *participant) tune(volume, quality, loss *int) error {
+	if volume != nil {
+		if *volume < 0 {
+			return invalid("volume", "should not be negative")
+		}
+		pt.volume = *volume
+	}
+	if quality != nil {
+		if *quality < 1 || *quality > 10 {
+			return invalid("quality", "should be between 1 and 10")
+		}
+		pt.quality = *quality
+	}
+	if loss != nil {
+		if *loss < 0 || *loss > 20 {
+			return invalid("expected_loss", "should be between 0 and 20")
+		}
+		pt.expectedLoss = *loss
+	}
+	if pt.coder != nil && (quality != nil || loss != nil) {
+		pt.coder.configure(pt.quality, pt.expectedLoss)
+	}
+	return nil
+}
+
+// configure changes how a participant is mixed, and answers its offer if any
+func (p *Plugin) configure(pt *participant, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Muted        *bool   `json:"muted"`
+		Display      *string `json:"display"`
+		Volume       *int    `json:"volume"`
+		Quality      *int    `json:"quality"`
+		ExpectedLoss *int    `json:"expected_loss"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, nil, err
+	}
+	if _, err := p.room(pt.room.Room); err != nil {
+		return nil, nil, err
+	}
+	pt.mutex.Lock()
+	if err := pt.tune(r.Volume, r.Quality, r.ExpectedLoss); err != nil {
+		pt.mutex.Unlock()
+		return nil, nil, err
+	}
+	changed := false
+	if r.Muted != nil && *r.Muted != pt.muted {
+		pt.muted, changed = *r.Muted, true
+		if pt.muted {
+			pt.pending = pt.pending[:0]
+		}
+	}
+	if r.Display != nil && *r.Display != pt.display {
+		pt.display, changed = *r.Display, true
+	}
+	active := pt.active
+	pt.mutex.Unlock()
+
+	var answer *plugins.JSEP
+	if m.jsep != nil {
+		var err error
+		if answer, err = p.negotiate(pt, m.jsep); err != nil {
+			return nil, nil, err
+		}
+	}
+	if changed && active {
+		p.changed(pt)
+	}
+	p.notify(pt.s.ps, map[string]interface{}{"event": "configured", "room": pt.room.Room, "id": pt.id})
+	return map[string]interface{}{"audiobridge": "event", "room": pt.room.Room, "result": "ok"}, answer, nil
+}
+
+// negotiate answers the offer of a participant, with a single audio m-line
+func (p *Plugin) negotiate(pt *participant, jsep *plugins.JSEP) (*plugins.JSEP, error) {
+	if jsep.Type != "offer" {
+		return nil, fail(ErrorInvalidSDP, "Unexpected %s, participants send offers", jsep.Type)
+	}
+	offer, err := sdp.Parse(jsep.SDP)
+	if err != nil {
+		return nil, fail(ErrorInvalidSDP, "Error parsing offer: %v", err)
+	}
+	pt.mutex.Lock()
+	wanted := pt.codec
+	pt.mutex.Unlock()
+	codec := pick(offer.MLine(sdp.Audio), wanted)
+	if codec == "" {
+		return nil, fail(ErrorInvalidSDP, "No audio codec we support in the offer")
+	}
+	rm := pt.room
+	rm.mutex.Lock()
+	rate := rm.Sampling_rate
+	rm.mutex.Unlock()
+	c, err := coders[codec](rate)
+	if err != nil {
+		return nil, fail(ErrorCodec, "Error creating the %s coder: %v", codec, err)
+	}
+	a := sdp.GenerateAnswer(offer, sdp.AnswerOptions{
+		NoVideo:        true,
+		NoData:         true,
+		AudioDirection: sdp.SendRecv,
+		AudioCodec:     codec,
+		Extensions:     []string{sdp.ExtMid, sdp.ExtAudioLevel},
+	})
+	a.Name = "AudioBridge " + strconv.FormatUint(rm.Room, 10)
+	am := a.MLine(sdp.Audio)
+
+	pt.mutex.Lock()
+	pt.offered = true
+	pt.codec = codec
+	pt.pt = am.CodecPT(codec)
+	pt.levelExtID = am.ExtmapID(sdp.ExtAudioLevel)
+	pt.coder = c
+	pt.coder.configure(pt.quality, pt.expectedLoss)
+	pt.pending, pt.gotSeq = nil, false
+	pt.seq, pt.ts = uint16(util.RandomUint64()), uint32(util.RandomUint64())
+	pt.tsStep = uint32(sdp.ClockRate(codec) / framesPerSecond)
+	pt.mutex.Unlock()
+	log.Infof("[%s] Participant %d of room %d negotiated %s", Package, pt.id, rm.Room, codec)
+	return &plugins.JSEP{Type: "answer", SDP: a.String()}, nil
+}
+
+// pick returns the codec to use with an m-line: the one asked for if it has
+// it, or the first we support in order of preference
+func pick(m *sdp.MLine, wanted string) string {
+	if m == nil || m.Port == 0 {
+		return ""
+	}
+	if wanted != "" && m.CodecPT(wanted) >= 0 {
+		return wanted
+	}
+	for _, codec := range preference {
+		if coders[codec] != nil && m.CodecPT(codec) >= 0 {
+			return codec
+		}
+	}
+	return ""
+}
+
+// setup adds a participant whose PeerConnection is up to the mix
+func (p *Plugin) setup(pt *participant) {
+	pt.mutex.Lock()
+	if !pt.offered || pt.active {
+		pt.mutex.Unlock()
+		return
+	}
+	pt.active = true
+	pt.mutex.Unlock()
+	p.changed(pt)
+}
+
+// leave removes a participant from its room, and tells the others with
+// event ("leaving" by default)
+func (p *Plugin) leave(pt *participant, event map[string]interface{}) {
+	if !p.remove(pt) {
+		return
+	}
+	rm := pt.room
+	log.Infof("[%s] Participant %d left room %d", Package, pt.id, rm.Room)
+	if event == nil {
+		event = map[string]interface{}{"audiobridge": "event", "room": rm.Room, "leaving": pt.id}
+	}
+	p.broadcast(rm, pt, event)
+	p.notify(pt.s.ps, map[string]interface{}{"event": "left", "room": rm.Room, "id": pt.id})
+}
+
+// remove takes a participant out of its room, and tells whether it was in
+func (p *Plugin) remove(pt *participant) bool {
+	rm := pt.room
+	rm.mutex.Lock()
+	in := rm.participants[pt.id] == pt
+	if in {
+		delete(rm.participants, pt.id)
+	}
+	rm.mutex.Unlock()
+	pt.s.mutex.Lock()
+	if pt.s.participant == pt {
+		pt.s.participant = nil
+	}
+	pt.s.mutex.Unlock()
+	pt.mutex.Lock()
+	pt.active, pt.offered = false, false
+	pt.coder, pt.pending = nil, nil
+	pt.mutex.Unlock()
+	return in
+}
+
+// incoming decodes what a participant sends, for the mixer, and checks
+// whether it's talking
+func (p *Plugin) incoming(pt *participant, packet []byte) {
+	if !rtp.IsRTP(packet) {
+		return
+	}
+	payload, err := rtp.Payload(packet)
+	if err != nil || len(payload) == 0 {
+		return
+	}
+	pt.mutex.Lock()
+	if !pt.active || pt.coder == nil || int(rtp.PayloadType(packet)) != pt.pt {
+		pt.mutex.Unlock()
+		return
+	}
+	// late packets (and duplicates) are of no use to the mixer
+	seq := rtp.Seq(packet)
+	if pt.gotSeq && (seq == pt.lastSeq || seq-pt.lastSeq >= 0x8000) {
+		pt.mutex.Unlock()
+		return
+	}
+	pt.lastSeq, pt.gotSeq = seq, true
+	talking := p.checkTalking(pt, packet)
+	if !pt.muted {
+		if pt.pending, err = pt.coder.decode(payload, pt.pending); err != nil {
+			log.Warnf("[%s] Error decoding audio of participant %d: %v", Package, pt.id, err)
+		}
+		max := maxPending * pt.room.Sampling_rate / framesPerSecond
+		if len(pt.pending) > max {
+			pt.pending = append(pt.pending[:0], pt.pending[len(pt.pending)-max:]...)
+		}
+	}
+	pt.mutex.Unlock()
+	if talking != nil {
+		p.talked(pt, *talking)
+	}
+}
+
+// checkTalking accounts for the audio level of a packet, and returns the
+// new talking state if it changed; the caller holds the mutex of the
+// participant
+func (p *Plugin) checkTalking(pt *participant, packet []byte) *bool {
+	rm := pt.room
+	enabled, activePackets, levelAverage := rm.audioLevels()
+	if !enabled || pt.levelExtID == 0 {
+		return nil
+	}
+	level, _, ok := rtp.AudioLevel(packet, pt.levelExtID)
+	if !ok {
+		return nil
+	}
+	pt.levelSum += level
+	pt.levelCount++
+	if pt.levelCount < activePackets {
+		return nil
+	}
+	average := pt.levelSum / pt.levelCount
+	pt.levelSum, pt.levelCount = 0, 0
+	talking := !pt.muted && average < levelAverage
+	if talking == pt.talking {
+		return nil
+	}
+	pt.talking = talking
+	return &talking
+}
+
+// talked tells the room that a participant started or stopped talking
+func (p *Plugin) talked(pt *participant, talking bool) {
+	rm := pt.room
+	event := map[string]interface{}{
+		"audiobridge": map[bool]string{true: "talking", false: "stopped-talking"}[talking],
+		"room":        rm.Room,
+		"id":          pt.id,
+	}
+	p.broadcast(rm, nil, event)
+	p.notify(pt.s.ps, event)
+}
@@ -0,0 +1,547 @@
+package audiobridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RoomConfig describes a room, both in the configuration and in create
+// requests (where elements have the same names)
+type RoomConfig struct {
+	// Room is the unique id of the room, a random one if 0
+	Room        uint64
+	Description string
+	// Is_private rooms don't show up in list requests
+	Is_private bool
+	// Secret is needed to edit, destroy, kick and mute, Pin to join
+	Secret string
+	Pin    string
+	// Sampling_rate of the mix: 8000, 12000, 16000, 24000 or 48000
+	Sampling_rate int
+	// Audiolevel_event tells participants when others start and stop
+	// talking: the average of the audio levels they send over
+	// Audio_active_packets packets is compared to Audio_level_average
+	// (0 is the loudest, 127 silence)
+	Audiolevel_event     bool
+	Audio_active_packets int
+	Audio_level_average  int
+	// Record the mix to Record_file in Record_dir, as a WAV file
+	// (janus-audioroom-<room>-<time>.wav by default)
+	Record      bool
+	Record_file string
+	Record_dir  string
+}
+
+// defaultRoom is what create requests start from
+func defaultRoom() RoomConfig {
+	return RoomConfig{Sampling_rate: 16000, Audio_active_packets: 100, Audio_level_average: 25}
+}
+
+type room struct {
+	mutex sync.Mutex
+	RoomConfig
+	participants map[uint64]*participant
+	// recording is the file the mixer writes to, if any
+	recording string
+	destroyed bool
+	// done stops the mixer
+	done chan struct{}
+}
+
+func newRoom(rc RoomConfig) (*room, error) {
+	switch rc.Sampling_rate {
+	case 0:
+		rc.Sampling_rate = 16000
+	case 8000, 12000, 16000, 24000, 48000:
+	default:
+		return nil, invalid("sampling_rate", "should be 8000, 12000, 16000, 24000 or 48000")
+	}
+	if rc.Audio_active_packets <= 0 {
+		rc.Audio_active_packets = 100
+	}
+	if rc.Audio_level_average <= 0 || rc.Audio_level_average > 127 {
+		rc.Audio_level_average = 25
+	}
+	if rc.Room == 0 {
+		rc.Room = util.RandomUint64()
+	}
+	return &room{
+		RoomConfig:   rc,
+		participants: make(map[uint64]*participant),
+		done:         make(chan struct{}),
+	}, nil
+}
+
+// stop marks a room as destroyed, and stops its mixer
+func (r *room) stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.destroyed {
+		r.destroyed = true
+		close(r.done)
+	}
+}
+
+// room returns a room which hasn't been destroyed
+func (p *Plugin) room(id uint64) (*room, error) {
+	p.mutex.Lock()
+	r := p.rooms[id]
+	p.mutex.Unlock()
+	if r == nil {
+		return nil, fail(ErrorNoSuchRoom, "No such room (%d)", id)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.destroyed {
+		return nil, fail(ErrorNoSuchRoom, "No such room (%d)", id)
+	}
+	return r, nil
+}
+
+// audioLevels returns the settings of the talking events of a room
+func (r *room) audioLevels() (enabled bool, activePackets, levelAverage int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.Audiolevel_event, r.Audio_active_packets, r.Audio_level_average
+}
+
+// list returns the participants of a room; the caller holds its mutex
+func (r *room) list() []*participant {
+	list := make([]*participant, 0, len(r.participants))
+	for _, pt := range r.participants {
+		list = append(list, pt)
+	}
+	return list
+}
+
+// broadcast sends an event to the participants of a room, but except
+func (p *Plugin) broadcast(r *room, except *participant, event map[string]interface{}) {
+	r.mutex.Lock()
+	list := r.list()
+	r.mutex.Unlock()
+	for _, pt := range list {
+		if pt == except {
+			continue
+		}
+		if err := p.gateway.PushEvent(pt.s.ps, p, "", event, nil); err != nil {
+			log.Warnf("[%s] Error notifying participant %d: %v", Package, pt.id, err)
+		}
+	}
+}
+
+// changed tells the others that a participant changed (joined, set up its
+// PeerConnection, got muted...)
+func (p *Plugin) changed(pt *participant) {
+	pt.mutex.Lock()
+	d := pt.describe()
+	pt.mutex.Unlock()
+	p.broadcast(pt.room, pt, map[string]interface{}{
+		"audiobridge": "event", "room": pt.room.Room, "participants": []interface{}{d},
+	})
+}
+
+// save writes the configuration with the permanent rooms; the caller holds
+// the mutex of the plugin
+func (p *Plugin) save() error {
+	data, err := yaml.Marshal(&p.config)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(p.configPath, Package+".yaml"), data, 0644)
+}
+
+// savePermanent replaces (or removes, if rc is nil) a room in the
+// configuration file
+func (p *Plugin) savePermanent(id uint64, rc *RoomConfig) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	rooms := p.config.Rooms[:0:0]
+	for _, c := range p.config.Rooms {
+		if c.Room != id {
+			rooms = append(rooms, c)
+		}
+	}
+	if rc != nil {
+		rooms = append(rooms, *rc)
+	}
+	p.config.Rooms = rooms
+	if err := p.save(); err != nil {
+		log.Errorf("[%s] Error saving the configuration: %v", Package, err)
+		return fail(ErrorUnknown, "Error saving the configuration: %v", err)
+	}
+	return nil
+}
+
+// manage handles the requests about rooms; admin is set for the ones via
+// the Admin API, which need no secrets
+func (p *Plugin) manage(request string, body json.RawMessage, admin bool) map[string]interface{} {
+	var (
+		response map[string]interface{}
+		err      error
+	)
+	switch request {
+	case "create":
+		response, err = p.create(body, admin)
+	case "edit":
+		response, err = p.edit(body, admin)
+	case "destroy":
+		response, err = p.destroy(body, admin)
+	case "exists":
+		response, err = p.exists(body)
+	case "list":
+		response, err = p.listRooms(body, admin)
+	case "listparticipants":
+		response, err = p.listParticipants(body)
+	case "kick":
+		response, err = p.kick(body, admin)
+	case "mute", "unmute":
+		response, err = p.mute(body, admin, request == "mute")
+	case "enable_recording":
+		response, err = p.enableRecording(body, admin)
+	}
+	if err != nil {
+		log.Warnf("[%s] %v", Package, err)
+		return errorEvent(err)
+	}
+	return response
+}
+
+// authorize checks the secret of a room, which the caller holds the mutex of
+func (r *room) authorize(secret string, admin bool) error {
+	if !admin && r.Secret != "" && secret != r.Secret {
+		return fail(ErrorUnauthorized, "Unauthorized (wrong secret)")
+	}
+	return nil
+}
+
+// authorized returns a room, if the secret is the right one
+func (p *Plugin) authorized(id *uint64, secret string, admin bool) (*room, error) {
+	if id == nil {
+		return nil, missing("room")
+	}
+	rm, err := p.room(*id)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	if err := rm.authorize(secret, admin); err != nil {
+		return nil, err
+	}
+	return rm, nil
+}
+
+func (p *Plugin) create(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	rc := defaultRoom()
+	r := struct {
+		*RoomConfig
+		Admin_key string
+		Permanent bool
+	}{RoomConfig: &rc}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if key := p.config.General.Admin_key; key != "" && !admin && r.Admin_key != key {
+		if r.Admin_key == "" {
+			return nil, missing("admin_key")
+		}
+		return nil, fail(ErrorUnauthorized, "Unauthorized (wrong admin_key)")
+	}
+	rm, err := newRoom(rc)
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	if rc.Room == 0 {
+		for p.rooms[rm.Room] != nil {
+			rm.Room = util.RandomUint64()
+		}
+	} else if p.rooms[rm.Room] != nil {
+		p.mutex.Unlock()
+		return nil, fail(ErrorRoomExists, "Room %d already exists", rm.Room)
+	}
+	p.rooms[rm.Room] = rm
+	p.mutex.Unlock()
+	go p.mix(rm)
+	log.Infof("[%s] Created room %d (%s)", Package, rm.Room, rm.Description)
+	p.notify(nil, map[string]interface{}{"event": "created", "room": rm.Room})
+	if r.Permanent {
+		if err := p.savePermanent(rm.Room, &rm.RoomConfig); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"audiobridge": "created", "room": rm.Room, "permanent": r.Permanent}, nil
+}
+
+func (p *Plugin) edit(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		Room        *uint64 `json:"room"`
+		Secret      string  `json:"secret"`
+		Description *string `json:"new_description"`
+		IsPrivate   *bool   `json:"new_is_private"`
+		NewSecret   *string `json:"new_secret"`
+		Pin         *string `json:"new_pin"`
+		RecordDir   *string `json:"new_record_dir"`
+		Permanent   bool    `json:"permanent"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	rm, err := p.authorized(r.Room, r.Secret, admin)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	if r.Description != nil && *r.Description != "" {
+		rm.Description = *r.Description
+	}
+	if r.IsPrivate != nil {
+		rm.Is_private = *r.IsPrivate
+	}
+	if r.NewSecret != nil {
+		rm.Secret = *r.NewSecret
+	}
+	if r.Pin != nil {
+		rm.Pin = *r.Pin
+	}
+	if r.RecordDir != nil {
+		rm.Record_dir = *r.RecordDir
+	}
+	rc := rm.RoomConfig
+	rm.mutex.Unlock()
+	log.Infof("[%s] Edited room %d", Package, rc.Room)
+	p.notify(nil, map[string]interface{}{"event": "edited", "room": rc.Room})
+	if r.Permanent {
+		if err := p.savePermanent(rc.Room, &rc); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"audiobridge": "edited", "room": rc.Room, "permanent": r.Permanent}, nil
+}
+
+func (p *Plugin) destroy(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		Room      *uint64 `json:"room"`
+		Secret    string  `json:"secret"`
+		Permanent bool    `json:"permanent"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	rm, err := p.authorized(r.Room, r.Secret, admin)
+	if err != nil {
+		return nil, err
+	}
+	rm.stop()
+	rm.mutex.Lock()
+	list := rm.list()
+	rm.mutex.Unlock()
+	p.mutex.Lock()
+	delete(p.rooms, rm.Room)
+	p.mutex.Unlock()
+
+	// everybody out
+	event := map[string]interface{}{"audiobridge": "destroyed", "room": rm.Room}
+	for _, pt := range list {
+		p.gateway.PushEvent(pt.s.ps, p, "", event, nil)
+		p.remove(pt)
+		p.gateway.ClosePC(pt.s.ps)
+	}
+	log.Infof("[%s] Destroyed room %d", Package, rm.Room)
+	p.notify(nil, map[string]interface{}{"event": "destroyed", "room": rm.Room})
+	if r.Permanent {
+		if err := p.savePermanent(rm.Room, nil); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"audiobridge": "destroyed", "room": rm.Room, "permanent": r.Permanent}, nil
+}
+
+func (p *Plugin) exists(body json.RawMessage) (map[string]interface{}, error) {
+	var r struct {
+		Room *uint64 `json:"room"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Room == nil {
+		return nil, missing("room")
+	}
+	_, err := p.room(*r.Room)
+	return map[string]interface{}{"audiobridge": "success", "room": *r.Room, "exists": err == nil}, nil
+}
+
+// listRooms returns the public rooms, and the private ones too for admins
+func (p *Plugin) listRooms(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		AdminKey string `json:"admin_key"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if key := p.config.General.Admin_key; key != "" && r.AdminKey == key {
+		admin = true
+	}
+	p.mutex.Lock()
+	rooms := make([]*room, 0, len(p.rooms))
+	for _, rm := range p.rooms {
+		rooms = append(rooms, rm)
+	}
+	p.mutex.Unlock()
+	list := make([]map[string]interface{}, 0, len(rooms))
+	for _, rm := range rooms {
+		rm.mutex.Lock()
+		if !rm.destroyed && (!rm.Is_private || admin) {
+			d := map[string]interface{}{
+				"room":             rm.Room,
+				"description":      rm.Description,
+				"pin_required":     rm.Pin != "",
+				"sampling_rate":    rm.Sampling_rate,
+				"record":           rm.Record,
+				"num_participants": len(rm.participants),
+			}
+			if rm.recording != "" {
+				d["record_file"] = rm.recording
+			}
+			list = append(list, d)
+		}
+		rm.mutex.Unlock()
+	}
+	return map[string]interface{}{"audiobridge": "success", "list": list}, nil
+}
+
+func (p *Plugin) listParticipants(body json.RawMessage) (map[string]interface{}, error) {
+	var r struct {
+		Room *uint64 `json:"room"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Room == nil {
+		return nil, missing("room")
+	}
+	rm, err := p.room(*r.Room)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	participants := rm.list()
+	rm.mutex.Unlock()
+	list := make([]map[string]interface{}, 0, len(participants))
+	for _, pt := range participants {
+		pt.mutex.Lock()
+		list = append(list, pt.describe())
+		pt.mutex.Unlock()
+	}
+	return map[string]interface{}{"audiobridge": "participants", "room": *r.Room, "participants": list}, nil
+}
+
+// participant returns a participant of a room
+func (r *room) participant(id *uint64) (*participant, error) {
+	if id == nil {
+		return nil, missing("id")
+	}
+	r.mutex.Lock()
+	pt := r.participants[*id]
+	r.mutex.Unlock()
+	if pt == nil {
+		return nil, fail(ErrorNoSuchUser, "No such user %d in room %d", *id, r.Room)
+	}
+	return pt, nil
+}
+
+// kick makes a participant leave, and hangs up its PeerConnection
+func (p *Plugin) kick(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		Room   *uint64 `json:"room"`
+		Secret string  `json:"secret"`
+		ID     *uint64 `json:"id"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	rm, err := p.authorized(r.Room, r.Secret, admin)
+	if err != nil {
+		return nil, err
+	}
+	pt, err := rm.participant(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	p.gateway.PushEvent(pt.s.ps, p, "", map[string]interface{}{
+		"audiobridge": "event", "room": rm.Room, "kicked": pt.id,
+	}, nil)
+	p.leave(pt, map[string]interface{}{"audiobridge": "event", "room": rm.Room, "kicked": pt.id})
+	p.gateway.ClosePC(pt.s.ps)
+	return map[string]interface{}{"audiobridge": "success"}, nil
+}
+
+// mute silences a participant in the mix (or not anymore), whatever it asks
+func (p *Plugin) mute(body json.RawMessage, admin, muted bool) (map[string]interface{}, error) {
+	var r struct {
+		Room   *uint64 `json:"room"`
+		Secret string  `json:"secret"`
+		ID     *uint64 `json:"id"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	rm, err := p.authorized(r.Room, r.Secret, admin)
+	if err != nil {
+		return nil, err
+	}
+	pt, err := rm.participant(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	pt.mutex.Lock()
+	changed := pt.muted != muted
+	pt.muted = muted
+	if muted {
+		pt.pending = pt.pending[:0]
+	}
+	pt.mutex.Unlock()
+	if changed {
+		log.Infof("[%s] Participant %d of room %d %s", Package, pt.id, rm.Room, map[bool]string{true: "muted", false: "unmuted"}[muted])
+		p.changed(pt)
+	}
+	return map[string]interface{}{"audiobridge": "success"}, nil
+}
+
+// enableRecording starts or stops recording the mix of a room
+func (p *Plugin) enableRecording(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		Room       *uint64 `json:"room"`
+		Secret     string  `json:"secret"`
+		Record     *bool   `json:"record"`
+		RecordFile *string `json:"record_file"`
+		RecordDir  *string `json:"record_dir"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Record == nil {
+		return nil, missing("record")
+	}
+	rm, err := p.authorized(r.Room, r.Secret, admin)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	rm.Record = *r.Record
+	if r.RecordFile != nil {
+		rm.Record_file = *r.RecordFile
+	}
+	if r.RecordDir != nil {
+		rm.Record_dir = *r.RecordDir
+	}
+	rm.mutex.Unlock()
+	log.Infof("[%s] Recording of room %d %s", Package, rm.Room, map[bool]string{true: "enabled", false: "disabled"}[*r.Record])
+	return map[string]interface{}{"audiobridge": "success", "record": *r.Record}, nil
+}
@@ -0,0 +1,56 @@
+package audiobridge
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+// wavWriter records the mix of a room as 16 bit mono PCM
+type wavWriter struct {
+	file *os.File
+	path string
+	size uint32
+}
+
+func createWAV(path string, rate int) (*wavWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	// the sizes are filled in when closing
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:], 1) // mono
+	binary.LittleEndian.PutUint32(header[24:], uint32(rate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(rate*2))
+	binary.LittleEndian.PutUint16(header[32:], 2)
+	binary.LittleEndian.PutUint16(header[34:], 16)
+	copy(header[36:], "data")
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &wavWriter{file: file, path: path}, nil
+}
+
+func (w *wavWriter) write(pcm []int16) error {
+	buf := make([]byte, 2*len(pcm))
+	for i, s := range pcm {
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(s))
+	}
+	n, err := w.file.Write(buf)
+	w.size += uint32(n)
+	return err
+}
+
+func (w *wavWriter) close() error {
+	sizes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sizes, 36+w.size)
+	w.file.WriteAt(sizes, 4)
+	binary.LittleEndian.PutUint32(sizes, w.size)
+	w.file.WriteAt(sizes, 40)
+	return w.file.Close()
+}
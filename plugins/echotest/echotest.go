@@ -0,0 +1,634 @@
+package echotest
+
+// The EchoTest plugin sends back to clients whatever they send it: audio,
+// video and data channel messages. Messages can turn each kind of media on
+// and off, cap the bitrate the client sends (REMB), pick the simulcast
+// substream and temporal layer to get back, and record what goes through.
+// Requests and events are the same as janus.plugin.echotest of the original
+// Janus, so the echo test of janus.js works unchanged:
+//
+//	{"audio": true, "video": false, "data": true, "bitrate": 128000,
+//	 "substream": 0, "temporal": 1, "fallback": 250000,
+//	 "audiocodec": "opus", "videocodec": "vp8",
+//	 "record": true, "filename": "/path/to/echotest"}
+//
+// All elements are optional; a JSEP offer gets an answer in the event.
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/record"
+	"github.com/xroger88/go-janus/rtcp"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sdp"
+)
+
+const Package = "janus.plugin.echotest"
+
+// error codes of the events
+const (
+	ErrorNoMessage      = 411
+	ErrorInvalidJSON    = 412
+	ErrorInvalidElement = 413
+	ErrorInvalidSDP     = 414
+	ErrorUnknown        = 499
+)
+
+const (
+	// REMB never goes below this because of slow links
+	minBitrate = 64000
+	// what slow links halve when no bitrate has been set
+	defaultBitrate = 512000
+)
+
+// extensions accepted in answers
+var extensions = []string{
+	sdp.ExtMid, sdp.ExtRid, sdp.ExtRepairedRid, sdp.ExtAudioLevel, sdp.ExtVideoOrientation,
+	sdp.ExtAbsSendTime, sdp.ExtTransportWideCC, sdp.ExtPlayoutDelay,
+}
+
+// Config is the content of janus.plugin.echotest.yaml in the configs folder
+type Config struct {
+	General struct {
+		// Events tells whether to notify the event handlers
+		Events bool
+	}
+}
+
+type Plugin struct {
+	config   Config
+	gateway  plugins.Callbacks
+	messages chan *message
+	done     chan struct{}
+}
+
+// message is a request waiting for the handler
+type message struct {
+	ps          *plugins.PluginSession
+	transaction string
+	body        json.RawMessage
+	jsep        *plugins.JSEP
+}
+
+// request is the body of a message
+type request struct {
+	Audio      *bool   `json:"audio"`
+	Video      *bool   `json:"video"`
+	Data       *bool   `json:"data"`
+	Bitrate    *uint32 `json:"bitrate"`
+	Substream  *int    `json:"substream"`
+	Temporal   *int    `json:"temporal"`
+	Fallback   *uint   `json:"fallback"`
+	Record     *bool   `json:"record"`
+	Filename   *string `json:"filename"`
+	AudioCodec *string `json:"audiocodec"`
+	VideoCodec *string `json:"videocodec"`
+}
+
+// session is the state of a handle attached to the plugin
+type session struct {
+	ps *plugins.PluginSession
+
+	mutex       sync.Mutex
+	audioActive bool
+	videoActive bool
+	dataActive  bool
+	bitrate     uint32
+	slowLinks   int
+	// negotiated codecs, empty until the first offer is answered
+	audioCodec, videoCodec string
+	hasData                bool
+
+	simulcast rtp.Simulcast
+	sim       *rtp.SimulcastContext
+	switching rtp.SwitchingContext
+	vp8       rtp.VP8Context
+
+	recording     bool
+	filename      string
+	arc, vrc, drc *record.Recorder
+	hangingUp     int32
+	destroyed     int32
+}
+
+func init() {
+	plugins.Register(New())
+}
+
+// New returns the EchoTest plugin, to be started with Init
+func New() *Plugin {
+	return &Plugin{
+		messages: make(chan *message, 100),
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *Plugin) Package() string { return Package }
+func (p *Plugin) Name() string    { return "JANUS EchoTest plugin" }
+func (p *Plugin) Description() string {
+	return "This is a trivial EchoTest plugin for Janus, just used to showcase the plugin interface."
+}
+func (p *Plugin) Author() string        { return api.Author }
+func (p *Plugin) Version() int          { return api.Version }
+func (p *Plugin) VersionString() string { return api.VersionString }
+
+// Init reads the configuration and starts the message handler
+func (p *Plugin) Init(gateway plugins.Callbacks, configPath string) error {
+	p.config.General.Events = true
+	if err := config.LoadComponent(configPath, Package, &p.config); err != nil {
+		return err
+	}
+	p.gateway = gateway
+	go p.handler()
+	return nil
+}
+
+func (p *Plugin) Destroy() {
+	select {
+	case <-p.done:
+		return
+	default:
+		close(p.done)
+	}
+	log.Infof("%s destroyed", Package)
+}
+
+func getSession(ps *plugins.PluginSession) *session {
+	s, _ := ps.Plugin.(*session)
+	if s == nil || atomic.LoadInt32(&s.destroyed) == 1 {
+		return nil
+	}
+	return s
+}
+
+func (p *Plugin) CreateSession(ps *plugins.PluginSession) error {
+	s := &session{ps: ps}
+	s.reset()
+	ps.Plugin = s
+	return nil
+}
+
+// reset brings the session back to how it is before any negotiation
+func (s *session) reset() {
+	s.audioActive, s.videoActive, s.dataActive = true, true, true
+	s.bitrate = 0
+	s.slowLinks = 0
+	s.audioCodec, s.videoCodec, s.hasData = "", "", false
+	s.simulcast = rtp.Simulcast{}
+	s.sim = rtp.NewSimulcastContext()
+	s.switching = rtp.SwitchingContext{}
+	s.vp8 = rtp.VP8Context{}
+	s.recording = false
+}
+
+func (p *Plugin) DestroySession(ps *plugins.PluginSession) error {
+	s := getSession(ps)
+	if s == nil {
+		return fmt.Errorf("no session associated with this handle")
+	}
+	atomic.StoreInt32(&s.destroyed, 1)
+	s.mutex.Lock()
+	s.stopRecording()
+	s.mutex.Unlock()
+	return nil
+}
+
+func (p *Plugin) QuerySession(ps *plugins.PluginSession) interface{} {
+	s := getSession(ps)
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	info := map[string]interface{}{
+		"audio_active":   s.audioActive,
+		"video_active":   s.videoActive,
+		"data_active":    s.dataActive,
+		"bitrate":        s.bitrate,
+		"slowlink_count": s.slowLinks,
+		"hangingup":      atomic.LoadInt32(&s.hangingUp),
+		"destroyed":      atomic.LoadInt32(&s.destroyed),
+	}
+	if s.audioCodec != "" {
+		info["audio_codec"] = s.audioCodec
+	}
+	if s.videoCodec != "" {
+		info["video_codec"] = s.videoCodec
+	}
+	if s.simulcast.Enabled() {
+		info["simulcast"] = map[string]interface{}{
+			"substream":        s.sim.Substream,
+			"substream-target": s.sim.SubstreamTarget,
+			"temporal-layer":   s.sim.Temporal,
+			"temporal-target":  s.sim.TemporalTarget,
+		}
+	}
+	if s.recording {
+		recording := map[string]interface{}{}
+		for name, r := range map[string]*record.Recorder{"audio": s.arc, "video": s.vrc, "data": s.drc} {
+			if r != nil {
+				recording[name] = r.Path()
+			}
+		}
+		info["recording"] = recording
+	}
+	return info
+}
+
+// HandleMessage queues the message for the handler, which answers with an event
+func (p *Plugin) HandleMessage(ps *plugins.PluginSession, transaction string, body json.RawMessage, jsep *plugins.JSEP) *plugins.Result {
+	if getSession(ps) == nil {
+		return &plugins.Result{Type: plugins.ResultError, Text: "No session associated with this handle"}
+	}
+	select {
+	case p.messages <- &message{ps: ps, transaction: transaction, body: body, jsep: jsep}:
+	case <-p.done:
+		return &plugins.Result{Type: plugins.ResultError, Text: "Shutting down"}
+	}
+	return &plugins.Result{Type: plugins.ResultOKWait, Text: "I'm taking my time!"}
+}
+
+func (p *Plugin) SetupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil {
+		return
+	}
+	log.Infof("[%s] WebRTC media is now available", Package)
+	atomic.StoreInt32(&s.hangingUp, 0)
+	// whatever is being recorded had better start with a keyframe
+	p.sendPLI(ps)
+}
+
+func (p *Plugin) IncomingRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 || !rtp.IsRTP(packet.Buffer) {
+		return
+	}
+	s.mutex.Lock()
+	if !packet.Video {
+		active, rec := s.audioActive, s.arc
+		s.mutex.Unlock()
+		if !active {
+			return
+		}
+		if rec != nil {
+			rec.Save(packet.Buffer)
+		}
+		p.gateway.RelayRTP(ps, packet)
+		return
+	}
+	if !s.videoActive {
+		s.mutex.Unlock()
+		return
+	}
+	var events []map[string]interface{}
+	needKeyframe := false
+	if s.simulcast.Enabled() {
+		r := s.sim.ProcessRTP(packet.Buffer, &s.simulcast, s.videoCodec)
+		needKeyframe = r.NeedKeyframe
+		if r.SubstreamChanged {
+			s.vp8.Reset()
+			events = append(events, map[string]interface{}{"echotest": "event", "videocodec": s.videoCodec, "substream": s.sim.Substream})
+		}
+		if r.TemporalChanged {
+			events = append(events, map[string]interface{}{"echotest": "event", "videocodec": s.videoCodec, "temporal": s.sim.Temporal})
+		}
+		if !r.Relay {
+			if r.Skipped {
+				s.switching.Skip()
+			}
+			packet = nil
+		} else {
+			// the substreams must look like a single stream
+			s.switching.Update(packet.Buffer, 90000)
+			if s.videoCodec == "vp8" {
+				if payload, err := rtp.Payload(packet.Buffer); err == nil {
+					s.vp8.Update(payload)
+				}
+			}
+		}
+	}
+	rec := s.vrc
+	s.mutex.Unlock()
+
+	for _, event := range events {
+		p.gateway.PushEvent(ps, p, "", event, nil)
+	}
+	if needKeyframe {
+		p.sendPLI(ps)
+	}
+	if packet == nil {
+		return
+	}
+	if rec != nil {
+		rec.Save(packet.Buffer)
+	}
+	p.gateway.RelayRTP(ps, packet)
+}
+
+func (p *Plugin) IncomingRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 {
+		return
+	}
+	s.mutex.Lock()
+	bitrate := s.bitrate
+	s.mutex.Unlock()
+	if bitrate > 0 {
+		rtcp.CapREMB(packet.Buffer, bitrate)
+	}
+	p.gateway.RelayRTCP(ps, packet)
+}
+
+func (p *Plugin) IncomingData(ps *plugins.PluginSession, packet *plugins.DataPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 || len(packet.Buffer) == 0 {
+		return
+	}
+	s.mutex.Lock()
+	active, rec := s.dataActive, s.drc
+	s.mutex.Unlock()
+	if !active {
+		return
+	}
+	if rec != nil {
+		rec.Save(packet.Buffer)
+	}
+	p.gateway.RelayData(ps, packet)
+}
+
+// SlowLink halves the bitrate the client can send, down to a minimum
+func (p *Plugin) SlowLink(ps *plugins.PluginSession, uplink, video bool) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 {
+		return
+	}
+	s.mutex.Lock()
+	s.slowLinks++
+	if !video {
+		s.mutex.Unlock()
+		return
+	}
+	if s.bitrate == 0 {
+		s.bitrate = defaultBitrate
+	}
+	if s.bitrate /= 2; s.bitrate < minBitrate {
+		s.bitrate = minBitrate
+	}
+	bitrate := s.bitrate
+	s.mutex.Unlock()
+	log.Warnf("[%s] Getting a lot of NACKs (slow %s) for video, forcing a lower REMB: %d", Package,
+		map[bool]string{true: "uplink", false: "downlink"}[uplink], bitrate)
+	p.gateway.RelayRTCP(ps, &plugins.RTCPPacket{Video: true, Mindex: -1, Buffer: rtcp.NewREMB(bitrate)})
+	p.gateway.PushEvent(ps, p, "", map[string]interface{}{
+		"echotest": "event",
+		"result":   map[string]interface{}{"status": "slow_link", "bitrate": bitrate},
+	}, nil)
+}
+
+// HangupMedia tells the client we're done, and starts over
+func (p *Plugin) HangupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil || !atomic.CompareAndSwapInt32(&s.hangingUp, 0, 1) {
+		return
+	}
+	log.Infof("[%s] No WebRTC media anymore", Package)
+	p.gateway.PushEvent(ps, p, "", map[string]interface{}{"echotest": "event", "result": "done"}, nil)
+	s.mutex.Lock()
+	s.stopRecording()
+	s.reset()
+	s.mutex.Unlock()
+}
+
+func (p *Plugin) sendPLI(ps *plugins.PluginSession) {
+	p.gateway.RelayRTCP(ps, &plugins.RTCPPacket{Video: true, Mindex: -1, Buffer: rtcp.NewPLI()})
+}
+
+func (p *Plugin) notify(ps *plugins.PluginSession, event map[string]interface{}) {
+	if p.config.General.Events && p.gateway.EventsIsEnabled() {
+		p.gateway.NotifyEvent(p, ps, event)
+	}
+}
+
+// handler processes the messages, one at a time
+func (p *Plugin) handler() {
+	for {
+		select {
+		case m := <-p.messages:
+			p.handle(m)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Plugin) handle(m *message) {
+	s := getSession(m.ps)
+	if s == nil {
+		log.Warnf("[%s] No session associated with this handle", Package)
+		return
+	}
+	result, jsep, code, err := p.process(s, m)
+	var event map[string]interface{}
+	if err != nil {
+		log.Warnf("[%s] %v", Package, err)
+		event = map[string]interface{}{"echotest": "event", "error_code": code, "error": err.Error()}
+		jsep = nil
+	} else {
+		event = map[string]interface{}{"echotest": "event", "result": result}
+	}
+	if err := p.gateway.PushEvent(m.ps, p, m.transaction, event, jsep); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+}
+
+// process applies a request, and returns the answer to the offer, if any
+func (p *Plugin) process(s *session, m *message) (string, *plugins.JSEP, int, error) {
+	var r request
+	if err := plugins.Decode(m.body, &r); err != nil {
+		switch err {
+		case plugins.ErrNoMessage:
+			return "", nil, ErrorNoMessage, err
+		case plugins.ErrInvalidJSON:
+			return "", nil, ErrorInvalidJSON, err
+		}
+		return "", nil, ErrorInvalidElement, err
+	}
+	if r.Substream != nil && (*r.Substream < 0 || *r.Substream > 2) {
+		return "", nil, ErrorInvalidElement, plugins.Invalid("substream", "should be 0, 1 or 2")
+	}
+	if r.Temporal != nil && (*r.Temporal < 0 || *r.Temporal > 2) {
+		return "", nil, ErrorInvalidElement, plugins.Invalid("temporal", "should be 0, 1 or 2")
+	}
+	if r.AudioCodec != nil && !sdp.KnownCodec(sdp.Audio, *r.AudioCodec) {
+		return "", nil, ErrorInvalidElement, plugins.Invalid("audiocodec", "is not a supported audio codec")
+	}
+	if r.VideoCodec != nil && !sdp.KnownCodec(sdp.Video, *r.VideoCodec) {
+		return "", nil, ErrorInvalidElement, plugins.Invalid("videocodec", "is not a supported video codec")
+	}
+
+	var answer *plugins.JSEP
+	if m.jsep != nil {
+		if m.jsep.Type != "offer" {
+			return "", nil, ErrorInvalidSDP, fmt.Errorf("Unexpected %s, the EchoTest only answers offers", m.jsep.Type)
+		}
+		offer, err := sdp.Parse(m.jsep.SDP)
+		if err != nil {
+			return "", nil, ErrorInvalidSDP, fmt.Errorf("Error parsing offer: %v", err)
+		}
+		opts := sdp.AnswerOptions{Extensions: extensions, Simulcast: true}
+		if r.AudioCodec != nil {
+			opts.AudioCodec = *r.AudioCodec
+		}
+		if r.VideoCodec != nil {
+			opts.VideoCodec = *r.VideoCodec
+		}
+		a := sdp.GenerateAnswer(offer, opts)
+		a.Name = "EchoTest"
+		answer = &plugins.JSEP{Type: "answer", SDP: a.String()}
+
+		s.mutex.Lock()
+		s.negotiated(offer, a)
+		s.mutex.Unlock()
+	}
+
+	s.mutex.Lock()
+	keyframe := false
+	if r.Audio != nil {
+		s.audioActive = *r.Audio
+		log.Infof("[%s] Setting audio property: %v", Package, s.audioActive)
+	}
+	if r.Video != nil {
+		if !s.videoActive && *r.Video {
+			// video is back: the client needs a keyframe to show it
+			keyframe = true
+		}
+		s.videoActive = *r.Video
+		log.Infof("[%s] Setting video property: %v", Package, s.videoActive)
+	}
+	if r.Data != nil {
+		s.dataActive = *r.Data
+	}
+	var remb uint32
+	if r.Bitrate != nil {
+		s.bitrate = *r.Bitrate
+		remb = s.bitrate
+		log.Infof("[%s] Setting video bitrate: %d", Package, s.bitrate)
+	}
+	if r.Substream != nil {
+		s.sim.SubstreamTarget = *r.Substream
+		keyframe = true
+	}
+	if r.Temporal != nil {
+		s.sim.TemporalTarget = *r.Temporal
+		keyframe = true
+	}
+	if r.Fallback != nil {
+		s.sim.Fallback = time.Duration(*r.Fallback) * time.Microsecond
+	}
+	if r.Filename != nil {
+		s.filename = *r.Filename
+	}
+	if r.Record != nil {
+		s.recording = *r.Record
+	}
+	started, err := s.updateRecording()
+	keyframe = keyframe || started
+	event := map[string]interface{}{
+		"audio_active": s.audioActive,
+		"video_active": s.videoActive,
+		"data_active":  s.dataActive,
+		"bitrate":      s.bitrate,
+	}
+	if s.simulcast.Enabled() {
+		event["substream"] = s.sim.SubstreamTarget
+		event["temporal"] = s.sim.TemporalTarget
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		return "", nil, ErrorUnknown, fmt.Errorf("Error starting recording: %v", err)
+	}
+
+	if remb > 0 {
+		p.gateway.RelayRTCP(m.ps, &plugins.RTCPPacket{Video: true, Mindex: -1, Buffer: rtcp.NewREMB(remb)})
+	}
+	if keyframe {
+		p.sendPLI(m.ps)
+	}
+	p.notify(m.ps, event)
+	return "ok", answer, 0, nil
+}
+
+// negotiated takes note of what an answer accepted
+func (s *session) negotiated(offer, answer *sdp.SDP) {
+	s.audioCodec, s.videoCodec, s.hasData = "", "", false
+	s.simulcast = rtp.Simulcast{}
+	for i, m := range answer.MLines {
+		if m.Port == 0 {
+			continue
+		}
+		switch m.Type {
+		case sdp.Audio:
+			s.audioCodec = m.FirstCodec()
+		case sdp.Video:
+			s.videoCodec = m.FirstCodec()
+			o := offer.MLines[i]
+			s.simulcast = rtp.Simulcast{SSRCs: o.SimulcastSSRCs(), Rids: o.Rids(), RidExtID: o.ExtmapID(sdp.ExtRid)}
+		case sdp.Application:
+			s.hasData = true
+		}
+	}
+	if s.simulcast.Enabled() {
+		log.Infof("[%s] The offer has simulcast (%d SSRCs, rids %v)", Package, len(s.simulcast.SSRCs), s.simulcast.Rids)
+	}
+	targets := s.sim
+	s.sim = rtp.NewSimulcastContext()
+	s.sim.SubstreamTarget, s.sim.TemporalTarget, s.sim.Fallback = targets.SubstreamTarget, targets.TemporalTarget, targets.Fallback
+}
+
+// updateRecording starts or stops recording as asked, for what has been
+// negotiated so far, and tells whether a video recording just started
+func (s *session) updateRecording() (bool, error) {
+	if !s.recording {
+		s.stopRecording()
+		return false, nil
+	}
+	base := s.filename
+	if base == "" {
+		base = fmt.Sprintf("echotest-%p-%d", s, time.Now().UnixNano()/int64(time.Microsecond))
+	}
+	var err error
+	videoStarted := false
+	if s.audioCodec != "" && s.arc == nil {
+		s.arc, err = record.New("", base+"-audio", record.Audio, s.audioCodec, "")
+	}
+	if err == nil && s.videoCodec != "" && s.vrc == nil {
+		s.vrc, err = record.New("", base+"-video", record.Video, s.videoCodec, "")
+		videoStarted = err == nil
+	}
+	if err == nil && s.hasData && s.drc == nil {
+		s.drc, err = record.New("", base+"-data", record.Data, "text", "")
+	}
+	if err != nil {
+		s.recording = false
+		s.stopRecording()
+	}
+	return videoStarted, err
+}
+
+func (s *session) stopRecording() {
+	for _, r := range []**record.Recorder{&s.arc, &s.vrc, &s.drc} {
+		if *r != nil {
+			(*r).Close()
+			*r = nil
+		}
+	}
+}
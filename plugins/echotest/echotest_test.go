@@ -0,0 +1,262 @@
+package echotest
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/rtp"
+	pion "github.com/pion/webrtc/v3"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/plugins"
+	core "github.com/xroger88/go-janus/session"
+	"github.com/xroger88/go-janus/webrtc"
+)
+
+// testTransport is the client side of the Janus API: the core sends it the
+// responses to requests (which carry a request ID) and events (which don't)
+type testTransport struct {
+	mutex     sync.Mutex
+	responses chan map[string]interface{}
+	events    chan map[string]interface{}
+}
+
+func newTestTransport() *testTransport {
+	return &testTransport{
+		responses: make(chan map[string]interface{}, 16),
+		events:    make(chan map[string]interface{}, 64),
+	}
+}
+
+func (t *testTransport) SendMessage(instance interface{}, requestID interface{}, admin bool, message map[string]interface{}) error {
+	data, _ := json.Marshal(message)
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	if requestID == nil {
+		t.events <- decoded
+	} else {
+		t.responses <- decoded
+	}
+	return nil
+}
+
+func (t *testTransport) SessionCreated(instance interface{}, sessionID uint64)                     {}
+func (t *testTransport) SessionOver(instance interface{}, sessionID uint64, timeout, claimed bool) {}
+func (t *testTransport) SessionClaimed(instance interface{}, sessionID uint64)                     {}
+
+// request sends a request and returns the response
+func (t *testTransport) request(tb testing.TB, request map[string]interface{}) map[string]interface{} {
+	tb.Helper()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	data, _ := json.Marshal(request)
+	api.IncomingRequest(&core.Source{Transport: t, Instance: t}, 1, false, data)
+	select {
+	case response := <-t.responses:
+		return response
+	case <-time.After(10 * time.Second):
+		tb.Fatalf("no response to %s", data)
+		return nil
+	}
+}
+
+// waitFor waits for an event, skipping the ones it isn't
+func (t *testTransport) waitFor(tb testing.TB, what string, is func(map[string]interface{}) bool) map[string]interface{} {
+	tb.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-t.events:
+			if is(event) {
+				return event
+			}
+		case <-timeout:
+			tb.Fatalf("no %s event", what)
+			return nil
+		}
+	}
+}
+
+func janus(kind string) func(map[string]interface{}) bool {
+	return func(event map[string]interface{}) bool { return event["janus"] == kind }
+}
+
+// newBrowser is a PeerConnection the way a browser would do it, only on the
+// loopback interface
+func newBrowser(t *testing.T) *pion.PeerConnection {
+	var s pion.SettingEngine
+	s.SetNetworkTypes([]pion.NetworkType{pion.NetworkTypeUDP4})
+	s.SetIncludeLoopbackCandidate(true)
+	s.SetInterfaceFilter(func(name string) bool { return name == "lo" })
+	s.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	var m pion.MediaEngine
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+	pc, err := pion.NewAPI(pion.WithSettingEngine(s), pion.WithMediaEngine(&m)).NewPeerConnection(pion.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+func TestEchoOverWebRTC(t *testing.T) {
+	config.Conf.Nat.Ice_enforce_list = "lo"
+	if err := webrtc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	p := plugins.Find(Package).(*Plugin)
+	if err := p.Init(api.Callbacks, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Destroy)
+
+	tt := newTestTransport()
+	created := tt.request(t, map[string]interface{}{"janus": "create", "transaction": "c"})
+	sessionID := uint64(created["data"].(map[string]interface{})["id"].(float64))
+	t.Cleanup(func() {
+		if s := core.Find(sessionID); s != nil {
+			s.Destroy(false)
+		}
+	})
+	attached := tt.request(t, map[string]interface{}{"janus": "attach", "transaction": "a", "session_id": sessionID, "plugin": Package})
+	handleID := uint64(attached["data"].(map[string]interface{})["id"].(float64))
+	h := core.Find(sessionID).Handle(handleID)
+
+	// the browser sends audio and has a data channel
+	browser := newBrowser(t)
+	track, err := pion.NewTrackLocalStaticRTP(pion.RTPCodecCapability{MimeType: pion.MimeTypeOpus, ClockRate: 48000, Channels: 2}, "audio", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := browser.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := browser.CreateDataChannel("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, echoed := make(chan struct{}), make(chan string, 1)
+	dc.OnOpen(func() { close(opened) })
+	dc.OnMessage(func(msg pion.DataChannelMessage) { echoed <- string(msg.Data) })
+	var once sync.Once
+	received := make(chan struct{})
+	browser.OnTrack(func(remote *pion.TrackRemote, _ *pion.RTPReceiver) {
+		if _, _, err := remote.ReadRTP(); err == nil {
+			once.Do(func() { close(received) })
+		}
+	})
+	candidates := make(chan *pion.ICECandidate, 16)
+	browser.OnICECandidate(func(c *pion.ICECandidate) { candidates <- c })
+
+	// the offer goes with a request, and the candidates trickle after it
+	offer, err := browser.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := browser.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	message := map[string]interface{}{
+		"janus": "message", "transaction": "m", "session_id": sessionID, "handle_id": handleID,
+		"body": map[string]interface{}{"audio": true, "data": true},
+		"jsep": map[string]interface{}{"type": "offer", "sdp": offer.SDP},
+	}
+	if response := tt.request(t, message); response["janus"] != "ack" {
+		t.Fatalf("got %v, want an ack", response)
+	}
+	for done := false; !done; {
+		select {
+		case c := <-candidates:
+			candidate := map[string]interface{}{"completed": true}
+			if c != nil {
+				candidate = map[string]interface{}{"candidate": c.ToJSON().Candidate, "sdpMid": "0", "sdpMLineIndex": 0}
+			}
+			trickle := map[string]interface{}{"janus": "trickle", "transaction": "t", "session_id": sessionID, "handle_id": handleID, "candidate": candidate}
+			if response := tt.request(t, trickle); response["janus"] != "ack" {
+				t.Fatalf("got %v, want an ack", response)
+			}
+			done = c == nil
+		case <-time.After(10 * time.Second):
+			t.Fatal("the browser didn't finish gathering candidates")
+		}
+	}
+
+	// the answer has what the browser needs to connect
+	event := tt.waitFor(t, "answer", func(event map[string]interface{}) bool { return event["jsep"] != nil })
+	answer := event["jsep"].(map[string]interface{})
+	if err := browser.SetRemoteDescription(pion.SessionDescription{Type: pion.SDPTypeAnswer, SDP: answer["sdp"].(string)}); err != nil {
+		t.Fatalf("the browser can't take the answer: %v\n%s", err, answer["sdp"])
+	}
+	tt.waitFor(t, "webrtcup", janus("webrtcup"))
+	if state := h.State(); state != core.StateMedia {
+		t.Fatalf("got state %s, want %s", state, core.StateMedia)
+	}
+
+	// audio comes back
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for seq := uint16(0); ; seq++ {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				track.WriteRTP(&rtp.Packet{
+					Header:  rtp.Header{Version: 2, SequenceNumber: seq, Timestamp: uint32(seq) * 960},
+					Payload: []byte{0xfc, 0xff, 0xfe},
+				})
+			}
+		}
+	}()
+	tt.waitFor(t, "media", func(event map[string]interface{}) bool {
+		return event["janus"] == "media" && event["type"] == "audio" && event["receiving"] == true
+	})
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no audio back")
+	}
+
+	// and so do data channel messages
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the data channel didn't open")
+	}
+	if err := dc.SendText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case text := <-echoed:
+		if text != "hello" {
+			t.Fatalf("got %q back, want hello", text)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no data back")
+	}
+
+	info := h.PeerConnectionInfo()
+	if info.ICE["state"] != "connected" || info.DTLS["state"] != "connected" || info.DTLS["role"] != "client" || len(info.Media) != 2 {
+		t.Fatalf("got %+v", info)
+	}
+	if in := info.Media[0]["in-stats"].(map[string]interface{}); in["packets"].(uint64) == 0 {
+		t.Fatalf("got audio in-stats %v, want packets", in)
+	}
+
+	// hanging up tears the PeerConnection down
+	hangup := map[string]interface{}{"janus": "hangup", "transaction": "h", "session_id": sessionID, "handle_id": handleID}
+	if response := tt.request(t, hangup); response["janus"] != "success" {
+		t.Fatalf("got %v, want success", response)
+	}
+	tt.waitFor(t, "hangup", janus("hangup"))
+	if info := h.PeerConnectionInfo(); info.ICE["state"] != "none" {
+		t.Fatalf("got %+v after hangup, want no PeerConnection", info)
+	}
+}
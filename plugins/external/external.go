@@ -0,0 +1,451 @@
+// Package external runs plugins as processes of their own, so that they can
+// be written in any language and can't take the gateway down with them.
+// Executables found in the plugins folder are started at load time and
+// asked who they are; each one is then registered as a plugin like the
+// built-in ones, and the calls of the plugin interface are forwarded to
+// the process using the protocol described in protocol.go. When the
+// process dies, the handles attached to it are detached (their state is
+// gone with the process) and the process is started again, waiting a bit
+// longer each time it keeps dying.
+package external
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/plugins"
+)
+
+const (
+	// how long a plugin has to answer a call
+	callTimeout = 10 * time.Second
+	// how long a plugin has to exit when asked to
+	stopTimeout = 5 * time.Second
+)
+
+// how long to wait before restarting a plugin that died, twice as long each
+// time it keeps dying (variables for the tests)
+var (
+	minRestartDelay = time.Second
+	maxRestartDelay = 30 * time.Second
+)
+
+var errNotRunning = errors.New("external plugin not running")
+
+// Plugin is the stand-in for an external plugin
+type Plugin struct {
+	path    string
+	info    info
+	gateway plugins.Callbacks
+	config  string
+	done    chan struct{}
+
+	mutex    sync.Mutex
+	proc     *process
+	sessions map[uint64]*plugins.PluginSession
+	next     uint64
+}
+
+// LoadAll starts the executables in the folder and registers them as
+// plugins, except the ones in the disable list (file or package names)
+func LoadAll(folder, disable string) {
+	folder = strings.TrimSpace(folder)
+	if folder == "" {
+		return
+	}
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		log.Infof("Not loading any external plugin from %s: %v", folder, err)
+		return
+	}
+	for _, f := range files {
+		// Go plugins (.so) are for the loader
+		if !f.Mode().IsRegular() || f.Mode()&0111 == 0 || filepath.Ext(f.Name()) == ".so" || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		if config.Listed(disable, f.Name()) {
+			log.Infof("Not loading external plugin %s: disabled in the configuration", f.Name())
+			continue
+		}
+		path := filepath.Join(folder, f.Name())
+		p, err := load(path)
+		if err != nil {
+			log.Errorf("Error loading external plugin %s: %v", path, err)
+			continue
+		}
+		pkg := p.info.Package
+		switch {
+		case config.Disabled(disable, pkg):
+			log.Infof("Not registering external plugin %s from %s: disabled in the configuration", pkg, path)
+		case plugins.Find(pkg) != nil:
+			log.Errorf("Error loading external plugin %s: %s is already registered", path, pkg)
+		default:
+			plugins.Register(p)
+			log.Infof("Loaded external plugin %s from %s", pkg, path)
+			continue
+		}
+		p.proc.stop(stopTimeout)
+	}
+}
+
+// load starts the plugin and finds out who it is
+func load(path string) (*Plugin, error) {
+	p := &Plugin{
+		path:     path,
+		done:     make(chan struct{}),
+		sessions: make(map[uint64]*plugins.PluginSession),
+	}
+	proc, err := p.start()
+	if err != nil {
+		return nil, err
+	}
+	p.proc = proc
+	return p, nil
+}
+
+// start runs the process and asks for its info, which must not change
+// across restarts
+func (p *Plugin) start() (*process, error) {
+	proc, err := start(p.path, p.incoming)
+	if err != nil {
+		return nil, err
+	}
+	var i info
+	raw, err := proc.call(&message{Type: "info", ProtocolVersion: ProtocolVersion}, callTimeout)
+	if err == nil {
+		err = json.Unmarshal(raw, &i)
+	}
+	switch {
+	case err != nil:
+	case i.ProtocolVersion != ProtocolVersion:
+		err = fmt.Errorf("speaks version %d of the protocol, but this is version %d", i.ProtocolVersion, ProtocolVersion)
+	case i.Package == "":
+		err = errors.New("no package name")
+	case p.info.Package != "" && i.Package != p.info.Package:
+		err = fmt.Errorf("package changed from %s to %s", p.info.Package, i.Package)
+	}
+	if err != nil {
+		proc.kill()
+		<-proc.exited
+		return nil, err
+	}
+	if p.info.Package == "" {
+		p.info = i
+	}
+	return proc, nil
+}
+
+func (p *Plugin) Package() string       { return p.info.Package }
+func (p *Plugin) Name() string          { return p.info.Name }
+func (p *Plugin) Description() string   { return p.info.Description }
+func (p *Plugin) Author() string        { return p.info.Author }
+func (p *Plugin) Version() int          { return p.info.Version }
+func (p *Plugin) VersionString() string { return p.info.VersionString }
+
+// Init initializes the plugin process, and keeps it running from then on
+func (p *Plugin) Init(gateway plugins.Callbacks, configPath string) error {
+	p.mutex.Lock()
+	p.gateway, p.config = gateway, configPath
+	proc := p.proc
+	p.mutex.Unlock()
+	if err := p.initProcess(proc); err != nil {
+		proc.stop(stopTimeout)
+		return err
+	}
+	go p.supervise(proc)
+	return nil
+}
+
+func (p *Plugin) initProcess(proc *process) error {
+	_, err := proc.call(&message{Type: "init", ConfigPath: p.config, EventsEnabled: p.callbacks().EventsIsEnabled()}, callTimeout)
+	return err
+}
+
+// supervise restarts the process whenever it dies, until we're shutting down
+func (p *Plugin) supervise(proc *process) {
+	delay := minRestartDelay
+	for {
+		started := time.Now()
+		select {
+		case <-proc.exited:
+		case <-p.done:
+			return
+		}
+		log.Errorf("External plugin %s (%s) exited: %v", p.info.Package, p.path, proc.err)
+		p.mutex.Lock()
+		p.proc = nil
+		sessions := p.sessions
+		p.sessions = make(map[uint64]*plugins.PluginSession)
+		p.mutex.Unlock()
+		// whatever the handles had in the plugin is gone
+		for _, ps := range sessions {
+			p.callbacks().EndSession(ps)
+		}
+
+		if time.Since(started) > maxRestartDelay {
+			delay = minRestartDelay
+		}
+		for {
+			select {
+			case <-time.After(delay):
+			case <-p.done:
+				return
+			}
+			if delay *= 2; delay > maxRestartDelay {
+				delay = maxRestartDelay
+			}
+			next, err := p.start()
+			if err == nil {
+				if err = p.initProcess(next); err != nil {
+					next.kill()
+				}
+			}
+			if err != nil {
+				log.Errorf("Error restarting external plugin %s: %v, retrying in %v", p.path, err, delay)
+				continue
+			}
+			p.mutex.Lock()
+			p.proc = next
+			p.mutex.Unlock()
+			proc = next
+			log.Infof("External plugin %s restarted", p.info.Package)
+			break
+		}
+	}
+}
+
+// Destroy stops the process
+func (p *Plugin) Destroy() {
+	select {
+	case <-p.done:
+		return
+	default:
+		close(p.done)
+	}
+	if proc := p.process(); proc != nil {
+		proc.stop(stopTimeout)
+	}
+	log.Infof("%s destroyed", p.info.Package)
+}
+
+func (p *Plugin) callbacks() plugins.Callbacks {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.gateway
+}
+
+func (p *Plugin) process() *process {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.proc
+}
+
+func (p *Plugin) call(m *message) (json.RawMessage, error) {
+	proc := p.process()
+	if proc == nil {
+		return nil, errNotRunning
+	}
+	return proc.call(m, callTimeout)
+}
+
+func (p *Plugin) send(m *message) {
+	if proc := p.process(); proc != nil {
+		if err := proc.send(m); err != nil {
+			log.Warnf("Error sending %s to external plugin %s: %v", m.Type, p.info.Package, err)
+		}
+	}
+}
+
+// handle returns the number identifying a handle in the protocol
+func handle(ps *plugins.PluginSession) uint64 {
+	id, _ := ps.Plugin.(uint64)
+	return id
+}
+
+func (p *Plugin) session(id uint64) *plugins.PluginSession {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.sessions[id]
+}
+
+func (p *Plugin) CreateSession(ps *plugins.PluginSession) error {
+	p.mutex.Lock()
+	p.next++
+	id := p.next
+	ps.Plugin = id
+	p.sessions[id] = ps
+	p.mutex.Unlock()
+	if _, err := p.call(&message{Type: "create_session", Handle: id}); err != nil {
+		p.mutex.Lock()
+		delete(p.sessions, id)
+		p.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (p *Plugin) HandleMessage(ps *plugins.PluginSession, transaction string, msg json.RawMessage, jsep *plugins.JSEP) *plugins.Result {
+	raw, err := p.call(&message{Type: "handle_message", Handle: handle(ps), Transaction: transaction, Message: msg, Jsep: jsep})
+	if err != nil {
+		return &plugins.Result{Type: plugins.ResultError, Text: err.Error()}
+	}
+	var r result
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return &plugins.Result{Type: plugins.ResultError, Text: "Invalid result from the plugin"}
+	}
+	switch r.Type {
+	case "ok":
+		var content interface{}
+		if len(r.Content) > 0 && string(r.Content) != "null" {
+			content = r.Content
+		}
+		return &plugins.Result{Type: plugins.ResultOK, Content: content}
+	case "ok_wait":
+		return &plugins.Result{Type: plugins.ResultOKWait, Text: r.Text}
+	}
+	return &plugins.Result{Type: plugins.ResultError, Text: r.Text}
+}
+
+func (p *Plugin) IncomingRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	if proc := p.process(); proc != nil {
+		proc.sendMedia(mediaFrame(frameRTP, handle(ps), packet.Video, packet.Mindex, packet.Buffer))
+	}
+}
+
+func (p *Plugin) IncomingRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {
+	if proc := p.process(); proc != nil {
+		proc.sendMedia(mediaFrame(frameRTCP, handle(ps), packet.Video, packet.Mindex, packet.Buffer))
+	}
+}
+
+func (p *Plugin) IncomingData(ps *plugins.PluginSession, packet *plugins.DataPacket) {
+	if proc := p.process(); proc != nil {
+		proc.sendMedia(dataFrame(handle(ps), packet))
+	}
+}
+
+func (p *Plugin) SetupMedia(ps *plugins.PluginSession) {
+	p.send(&message{Type: "setup_media", Handle: handle(ps)})
+}
+
+func (p *Plugin) SlowLink(ps *plugins.PluginSession, uplink, video bool) {
+	p.send(&message{Type: "slow_link", Handle: handle(ps), Uplink: uplink, Video: video})
+}
+
+func (p *Plugin) HangupMedia(ps *plugins.PluginSession) {
+	p.send(&message{Type: "hangup_media", Handle: handle(ps)})
+}
+
+func (p *Plugin) DestroySession(ps *plugins.PluginSession) error {
+	id := handle(ps)
+	p.mutex.Lock()
+	_, ok := p.sessions[id]
+	delete(p.sessions, id)
+	p.mutex.Unlock()
+	if !ok {
+		// the process it lived in is gone already
+		return nil
+	}
+	_, err := p.call(&message{Type: "destroy_session", Handle: id})
+	return err
+}
+
+func (p *Plugin) QuerySession(ps *plugins.PluginSession) interface{} {
+	raw, err := p.call(&message{Type: "query_session", Handle: handle(ps)})
+	if err != nil || len(raw) == 0 {
+		return nil
+	}
+	return raw
+}
+
+// HandleAdminMessage passes the message_plugin requests of the Admin API
+func (p *Plugin) HandleAdminMessage(msg json.RawMessage) interface{} {
+	raw, err := p.call(&message{Type: "admin_message", Message: msg})
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	return raw
+}
+
+// incoming handles what the process sends, apart from results
+func (p *Plugin) incoming(kind byte, payload []byte) {
+	gateway := p.callbacks()
+	if gateway == nil {
+		log.Warnf("External plugin %s is talking before being initialized", p.info.Package)
+		return
+	}
+	switch kind {
+	case frameControl:
+		var m message
+		json.Unmarshal(payload, &m)
+		p.control(gateway, &m)
+	case frameRTP, frameRTCP:
+		id, video, mindex, packet, err := parseMedia(payload)
+		if err != nil {
+			log.Warnf("External plugin %s: %v", p.info.Package, err)
+			return
+		}
+		ps := p.session(id)
+		if ps == nil {
+			return
+		}
+		if kind == frameRTP {
+			gateway.RelayRTP(ps, &plugins.RTPPacket{Video: video, Mindex: mindex, Buffer: packet})
+		} else {
+			gateway.RelayRTCP(ps, &plugins.RTCPPacket{Video: video, Mindex: mindex, Buffer: packet})
+		}
+	case frameData:
+		id, packet, err := parseData(payload)
+		if err != nil {
+			log.Warnf("External plugin %s: %v", p.info.Package, err)
+			return
+		}
+		if ps := p.session(id); ps != nil {
+			gateway.RelayData(ps, packet)
+		}
+	default:
+		log.Warnf("External plugin %s sent a frame of unknown kind %d", p.info.Package, kind)
+	}
+}
+
+func (p *Plugin) control(gateway plugins.Callbacks, m *message) {
+	var ps *plugins.PluginSession
+	if m.Handle != 0 {
+		if ps = p.session(m.Handle); ps == nil {
+			log.Debugf("External plugin %s sent %s for unknown handle %d", p.info.Package, m.Type, m.Handle)
+			return
+		}
+	}
+	if ps == nil && m.Type != "notify_event" {
+		log.Warnf("External plugin %s sent %s without a handle", p.info.Package, m.Type)
+		return
+	}
+	switch m.Type {
+	case "push_event":
+		var event interface{}
+		if len(m.Message) > 0 {
+			event = m.Message
+		}
+		if err := gateway.PushEvent(ps, p, m.Transaction, event, m.Jsep); err != nil {
+			log.Warnf("External plugin %s: error pushing event: %v", p.info.Package, err)
+		}
+	case "close_pc":
+		gateway.ClosePC(ps)
+	case "end_session":
+		gateway.EndSession(ps)
+	case "notify_event":
+		if len(m.Event) > 0 {
+			gateway.NotifyEvent(p, ps, m.Event)
+		}
+	default:
+		log.Warnf("External plugin %s sent an unknown message: %s", p.info.Package, m.Type)
+	}
+}
@@ -0,0 +1,290 @@
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/plugins"
+)
+
+const testPackage = "janus.plugin.external.test"
+
+// The test binary is also the external plugin: started with
+// EXTERNAL_TEST_PLUGIN set, it runs testPlugin instead of the tests.
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("EXTERNAL_TEST_PLUGIN"); mode != "" {
+		testPlugin(mode)
+		os.Exit(0)
+	}
+	// so that TestRestart doesn't take forever
+	minRestartDelay, maxRestartDelay = 20*time.Millisecond, 10*time.Second
+	os.Exit(m.Run())
+}
+
+// testPlugin answers the gateway on stdin and stdout. In "broken" mode it
+// says it's another plugin. Every start is written down in the file
+// EXTERNAL_TEST_STARTS, if set.
+func testPlugin(mode string) {
+	if starts := os.Getenv("EXTERNAL_TEST_STARTS"); starts != "" {
+		f, _ := os.OpenFile(starts, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		fmt.Fprintln(f, time.Now().UnixNano())
+		f.Close()
+	}
+	var mutex sync.Mutex
+	write := func(f []byte) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		os.Stdout.Write(f)
+	}
+	answer := func(id uint64, result interface{}) {
+		raw, _ := json.Marshal(result)
+		f, _ := controlFrame(&message{Type: "result", ID: id, Result: raw})
+		write(f)
+	}
+	r := bufio.NewReader(os.Stdin)
+	for {
+		kind, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if kind != frameControl {
+			// media goes back where it came from
+			write(frame(kind, payload))
+			continue
+		}
+		var m message
+		json.Unmarshal(payload, &m)
+		switch m.Type {
+		case "info":
+			pkg := testPackage
+			if mode == "broken" {
+				pkg = "janus.plugin.someone.else"
+			}
+			answer(m.ID, info{Package: pkg, Name: "Test", Version: 1, ProtocolVersion: ProtocolVersion})
+		case "init", "create_session", "destroy_session":
+			answer(m.ID, nil)
+		case "query_session":
+			answer(m.ID, map[string]interface{}{"handle": m.Handle})
+		case "destroy":
+			return
+		case "handle_message":
+			var request struct {
+				Request string
+				Delay   int
+			}
+			json.Unmarshal(m.Message, &request)
+			// answered out of order when asked to wait
+			go func(m message) {
+				time.Sleep(time.Duration(request.Delay) * time.Millisecond)
+				switch request.Request {
+				case "echo":
+					answer(m.ID, result{Type: "ok", Content: m.Message})
+				case "twice":
+					answer(m.ID, result{Type: "ok", Content: m.Message})
+					answer(m.ID, result{Type: "error", Text: "answered twice"})
+				case "event":
+					answer(m.ID, result{Type: "ok_wait"})
+					f, _ := controlFrame(&message{Type: "push_event", Handle: m.Handle, Transaction: m.Transaction, Message: m.Message})
+					write(f)
+				case "error":
+					f, _ := controlFrame(&message{Type: "result", ID: m.ID, Error: "no way"})
+					write(f)
+				case "crash":
+					os.Exit(3)
+				case "garbage":
+					write([]byte{0, 0, 0, 0, 1})
+				}
+			}(m)
+		}
+	}
+}
+
+// testGateway stands in for the core: the events of the plugin and the
+// media it relays go to the test
+type testGateway struct {
+	plugins.Callbacks
+	events chan string
+	rtp    chan []byte
+	ended  chan *plugins.PluginSession
+}
+
+func (g *testGateway) PushEvent(ps *plugins.PluginSession, plugin plugins.Plugin, transaction string, message interface{}, jsep *plugins.JSEP) error {
+	data, _ := json.Marshal(message)
+	g.events <- transaction + " " + string(data)
+	return nil
+}
+
+func (g *testGateway) RelayRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	g.rtp <- packet.Buffer
+}
+
+func (g *testGateway) EndSession(ps *plugins.PluginSession) {
+	g.ended <- ps
+}
+
+func (g *testGateway) EventsIsEnabled() bool { return false }
+
+// startPlugin loads the test binary as an external plugin, and attaches a handle to it
+func startPlugin(t *testing.T) (*Plugin, *testGateway, *plugins.PluginSession) {
+	t.Setenv("EXTERNAL_TEST_PLUGIN", "ok")
+	p, err := load(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Package() != testPackage {
+		t.Fatalf("got package %s, want %s", p.Package(), testPackage)
+	}
+	g := &testGateway{events: make(chan string, 10), rtp: make(chan []byte, 10), ended: make(chan *plugins.PluginSession, 10)}
+	if err := p.Init(g, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Destroy)
+	ps := plugins.NewPluginSession(nil)
+	if err := p.CreateSession(ps); err != nil {
+		t.Fatal(err)
+	}
+	return p, g, ps
+}
+
+func TestCalls(t *testing.T) {
+	p, g, ps := startPlugin(t)
+
+	tests := []struct {
+		request string
+		want    plugins.ResultType
+		text    string
+	}{
+		{`{"request":"echo"}`, plugins.ResultOK, ""},
+		{`{"request":"event"}`, plugins.ResultOKWait, ""},
+		{`{"request":"error"}`, plugins.ResultError, "no way"},
+		{`{"request":"twice"}`, plugins.ResultOK, ""},
+	}
+	for _, test := range tests {
+		r := p.HandleMessage(ps, "t", json.RawMessage(test.request), nil)
+		if r.Type != test.want || r.Text != test.text {
+			t.Errorf("%s: got %+v, want type %d", test.request, r, test.want)
+		}
+		if r.Type == plugins.ResultOK && fmt.Sprintf("%s", r.Content) != test.request {
+			t.Errorf("%s: got content %s", test.request, r.Content)
+		}
+	}
+	select {
+	case e := <-g.events:
+		if e != `t {"request":"event"}` {
+			t.Errorf("got event %s", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	if q, _ := p.QuerySession(ps).(json.RawMessage); !strings.Contains(string(q), `"handle":1`) {
+		t.Errorf("got %s querying the session", q)
+	}
+
+	// each result goes to its own call, whatever the order they come in
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			request := fmt.Sprintf(`{"request":"echo","delay":%d}`, (10-i)*10)
+			if r := p.HandleMessage(ps, "t", json.RawMessage(request), nil); fmt.Sprintf("%s", r.Content) != request {
+				t.Errorf("got %s, want %s", r.Content, request)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	p.IncomingRTP(ps, &plugins.RTPPacket{Buffer: []byte{0x80, 96, 1}})
+	select {
+	case packet := <-g.rtp:
+		if string(packet) != string([]byte{0x80, 96, 1}) {
+			t.Errorf("got %v relayed", packet)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no RTP relayed")
+	}
+	if err := p.DestroySession(ps); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// restarted waits for the plugin to run another process than proc
+func restarted(t *testing.T, p *Plugin, proc *process) *process {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(5 * time.Millisecond) {
+		if next := p.process(); next != nil && next != proc {
+			return next
+		}
+	}
+	t.Fatal("the plugin wasn't restarted")
+	return nil
+}
+
+func TestRestart(t *testing.T) {
+	p, g, ps := startPlugin(t)
+
+	tests := []string{"crash", "garbage"}
+	for _, request := range tests {
+		t.Run(request, func(t *testing.T) {
+			proc := p.process()
+			if r := p.HandleMessage(ps, "t", json.RawMessage(`{"request":"`+request+`"}`), nil); r.Type != plugins.ResultError {
+				t.Errorf("got %+v from a dying plugin", r)
+			}
+			// the handles lived in the process
+			select {
+			case ended := <-g.ended:
+				if ended != ps {
+					t.Error("got another handle ended")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the handle wasn't ended")
+			}
+			restarted(t, p, proc)
+			ps = plugins.NewPluginSession(nil)
+			if err := p.CreateSession(ps); err != nil {
+				t.Fatal(err)
+			}
+			if r := p.HandleMessage(ps, "t", json.RawMessage(`{"request":"echo"}`), nil); r.Type != plugins.ResultOK {
+				t.Errorf("got %+v after the restart", r)
+			}
+		})
+	}
+
+	// a plugin that keeps failing is restarted less and less often
+	starts := filepath.Join(t.TempDir(), "starts")
+	t.Setenv("EXTERNAL_TEST_STARTS", starts)
+	t.Setenv("EXTERNAL_TEST_PLUGIN", "broken")
+	proc := p.process()
+	p.HandleMessage(ps, "t", json.RawMessage(`{"request":"crash"}`), nil)
+	var times []int64
+	for start := time.Now(); len(times) < 4; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("got %d restarts", len(times))
+		}
+		data, _ := ioutil.ReadFile(starts)
+		times = times[:0]
+		for _, line := range strings.Fields(string(data)) {
+			var n int64
+			fmt.Sscan(line, &n)
+			times = append(times, n)
+		}
+	}
+	for i := 2; i < len(times); i++ {
+		if before, after := times[i-1]-times[i-2], times[i]-times[i-1]; after < before*3/2 {
+			t.Errorf("restart %d came %v after the previous one, which came %v after its own", i, time.Duration(after), time.Duration(before))
+		}
+	}
+	if p.process() != nil {
+		t.Error("got a plugin claiming to be another one running")
+	}
+	t.Setenv("EXTERNAL_TEST_PLUGIN", "ok")
+	restarted(t, p, proc)
+}
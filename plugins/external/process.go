@@ -0,0 +1,247 @@
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// frames waiting to be written to the plugin
+	maxQueuedControl = 1000
+	maxQueuedMedia   = 1000
+)
+
+var (
+	errExited      = errors.New("plugin process exited")
+	errCallTimeout = errors.New("plugin didn't answer in time")
+	errQueueFull   = errors.New("plugin is not reading its input")
+)
+
+// process is a running instance of an external plugin
+type process struct {
+	path    string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	control chan []byte
+	media   chan []byte
+	exited  chan struct{}
+	err     error // why the process exited, once exited is closed
+
+	// handler gets what the plugin sends, apart from results
+	handler func(kind byte, payload []byte)
+
+	mutex sync.Mutex
+	calls map[uint64]chan *message
+	next  uint64
+}
+
+// start runs the plugin executable and starts talking to it
+func start(path string, handler func(kind byte, payload []byte)) (*process, error) {
+	p := &process{
+		path:    path,
+		cmd:     exec.Command(path),
+		control: make(chan []byte, maxQueuedControl),
+		media:   make(chan []byte, maxQueuedMedia),
+		exited:  make(chan struct{}),
+		handler: handler,
+		calls:   make(map[uint64]chan *message),
+	}
+	var err error
+	if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := p.cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.cmd.Start(); err != nil {
+		return nil, err
+	}
+	log.Infof("Started external plugin %s (pid %d)", path, p.cmd.Process.Pid)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.reader(stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		p.logger(stderr)
+	}()
+	go p.writer()
+	go func() {
+		// Wait must not be called before the pipes are drained
+		wg.Wait()
+		p.err = p.cmd.Wait()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// reader takes the frames the plugin writes, until it goes away
+func (p *process) reader(stdout io.Reader) {
+	r := bufio.NewReader(stdout)
+	for {
+		kind, payload, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("External plugin %s: %v, killing it", p.path, err)
+			}
+			// a plugin we can't understand anymore is as good as dead
+			p.kill()
+			io.Copy(ioutil.Discard, r)
+			return
+		}
+		if kind == frameControl {
+			var m message
+			if err := json.Unmarshal(payload, &m); err != nil {
+				log.Warnf("External plugin %s sent an invalid control message: %v", p.path, err)
+				continue
+			}
+			if m.Type == "result" {
+				p.result(&m)
+				continue
+			}
+		}
+		p.handler(kind, payload)
+	}
+}
+
+// logger copies what the plugin writes to stderr to the log
+func (p *process) logger(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		log.Infof("[%s] %s", p.path, scanner.Text())
+	}
+}
+
+// writer writes the queued frames to the plugin, control ones first
+func (p *process) writer() {
+	defer p.stdin.Close()
+	for {
+		var f []byte
+		select {
+		case f = <-p.control:
+		case <-p.exited:
+			return
+		default:
+			select {
+			case f = <-p.control:
+			case f = <-p.media:
+			case <-p.exited:
+				return
+			}
+		}
+		if _, err := p.stdin.Write(f); err != nil {
+			p.kill()
+			return
+		}
+	}
+}
+
+func (p *process) kill() {
+	if p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+	}
+}
+
+// send queues a control message
+func (p *process) send(m *message) error {
+	f, err := controlFrame(m)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-p.exited:
+		return errExited
+	default:
+	}
+	select {
+	case p.control <- f:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// sendMedia queues a media frame, which is dropped if the plugin is lagging behind
+func (p *process) sendMedia(f []byte) {
+	select {
+	case p.media <- f:
+	default:
+	}
+}
+
+// call sends a control message and waits for its result
+func (p *process) call(m *message, timeout time.Duration) (json.RawMessage, error) {
+	answer := make(chan *message, 1)
+	p.mutex.Lock()
+	p.next++
+	m.ID = p.next
+	p.calls[m.ID] = answer
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		delete(p.calls, m.ID)
+		p.mutex.Unlock()
+	}()
+
+	if err := p.send(m); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-answer:
+		if r.Error != "" {
+			return nil, errors.New(r.Error)
+		}
+		return r.Result, nil
+	case <-p.exited:
+		return nil, errExited
+	case <-timer.C:
+		return nil, fmt.Errorf("%s: %v", m.Type, errCallTimeout)
+	}
+}
+
+func (p *process) result(m *message) {
+	p.mutex.Lock()
+	answer := p.calls[m.ID]
+	p.mutex.Unlock()
+	if answer == nil {
+		log.Debugf("External plugin %s: late or unknown result %d", p.path, m.ID)
+		return
+	}
+	// the answer channel has room for one result: a plugin answering the
+	// same call twice mustn't block the reader
+	select {
+	case answer <- m:
+	default:
+		log.Warnf("External plugin %s: duplicate result %d, dropping it", p.path, m.ID)
+	}
+}
+
+// stop asks the plugin to exit, and kills it if it doesn't in time
+func (p *process) stop(timeout time.Duration) {
+	p.send(&message{Type: "destroy"})
+	select {
+	case <-p.exited:
+	case <-time.After(timeout):
+		log.Warnf("External plugin %s didn't exit, killing it", p.path)
+		p.kill()
+		<-p.exited
+	}
+}
@@ -0,0 +1,211 @@
+package external
+
+// The protocol between the gateway and an external plugin runs over the
+// stdin (gateway to plugin) and stdout (plugin to gateway) of the plugin
+// process; whatever the plugin writes to stderr ends up in the gateway log.
+// Both ways carry frames:
+//
+//	length  uint32, big endian: size of what follows (kind + payload)
+//	kind    uint8
+//	payload
+//
+// Kinds of frames, integers being big endian:
+//
+//	1  control  a JSON object (see below)
+//	2  RTP      handle uint64 | flags uint8 | mindex int16 | RTP packet
+//	3  RTCP     handle uint64 | flags uint8 | mindex int16 | RTCP packet
+//	4  data     handle uint64 | flags uint8 | label length uint16 | label |
+//	            protocol length uint16 | protocol | message
+//
+// For RTP and RTCP, bit 0 of flags means video, and mindex is the m-line
+// (-1 if unknown); for data, bit 0 of flags means binary. Media from the
+// gateway is what the client sent, media from the plugin is relayed to the
+// client. Media frames may be dropped when the other side is not keeping up.
+//
+// Control messages all have a "type". Calls expecting an answer carry an
+// "id", and are answered by a message of type "result" with the same "id",
+// and either a "result" or an "error" (a string). Handles are identified
+// by the "handle" number the gateway picks in create_session.
+//
+// From the gateway (answer expected for the ones with an id):
+//
+//	info               {id, protocol_version}: result is {package, name,
+//	                   description, author, version, version_string,
+//	                   protocol_version}, sent first to find out who the
+//	                   plugin is
+//	init               {id, config_path, events_enabled}
+//	destroy            the gateway is shutting down: the plugin should exit
+//	create_session     {id, handle}
+//	handle_message     {id, handle, transaction, message, jsep}: result is
+//	                   {type: "ok"|"ok_wait"|"error", text, content}, as
+//	                   plugins.Result
+//	setup_media        {handle}
+//	slow_link          {handle, uplink, video}
+//	hangup_media       {handle}
+//	destroy_session    {id, handle}
+//	query_session      {id, handle}: result is anything
+//	admin_message      {id, message}: result is anything
+//
+// From the plugin (none is answered):
+//
+//	push_event         {handle, transaction, message, jsep}
+//	close_pc           {handle}
+//	end_session        {handle}
+//	notify_event       {handle (optional), event}
+//
+// Every message may also carry fields of its own that the other side ignores.
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/xroger88/go-janus/plugins"
+)
+
+// ProtocolVersion is the version of the protocol described above
+const ProtocolVersion = 1
+
+const (
+	frameControl byte = 1
+	frameRTP     byte = 2
+	frameRTCP    byte = 3
+	frameData    byte = 4
+
+	maxFrameSize = 4 << 20
+)
+
+var errFrameTooBig = errors.New("frame too big")
+
+// message is a control message, in either direction
+type message struct {
+	Type string `json:"type"`
+	ID   uint64 `json:"id,omitempty"`
+
+	Handle      uint64          `json:"handle,omitempty"`
+	Transaction string          `json:"transaction,omitempty"`
+	Message     json.RawMessage `json:"message,omitempty"`
+	Jsep        *plugins.JSEP   `json:"jsep,omitempty"`
+	Uplink      bool            `json:"uplink,omitempty"`
+	Video       bool            `json:"video,omitempty"`
+	Event       json.RawMessage `json:"event,omitempty"`
+
+	ProtocolVersion int    `json:"protocol_version,omitempty"`
+	ConfigPath      string `json:"config_path,omitempty"`
+	EventsEnabled   bool   `json:"events_enabled,omitempty"`
+
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// info is the result of an info call
+type info struct {
+	Package         string `json:"package"`
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	Author          string `json:"author"`
+	Version         int    `json:"version"`
+	VersionString   string `json:"version_string"`
+	ProtocolVersion int    `json:"protocol_version"`
+}
+
+// result is the result of a handle_message call
+type result struct {
+	Type    string          `json:"type"`
+	Text    string          `json:"text"`
+	Content json.RawMessage `json:"content"`
+}
+
+func frame(kind byte, payload []byte) []byte {
+	f := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(f, uint32(1+len(payload)))
+	f[4] = kind
+	return append(f, payload...)
+}
+
+func controlFrame(m *message) ([]byte, error) {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return frame(frameControl, payload), nil
+}
+
+func mediaFrame(kind byte, handle uint64, video bool, mindex int, packet []byte) []byte {
+	payload := make([]byte, 11, 11+len(packet))
+	binary.BigEndian.PutUint64(payload, handle)
+	if video {
+		payload[8] = 1
+	}
+	binary.BigEndian.PutUint16(payload[9:], uint16(int16(mindex)))
+	return frame(kind, append(payload, packet...))
+}
+
+func dataFrame(handle uint64, packet *plugins.DataPacket) []byte {
+	payload := make([]byte, 9, 13+len(packet.Label)+len(packet.Protocol)+len(packet.Buffer))
+	binary.BigEndian.PutUint64(payload, handle)
+	if packet.Binary {
+		payload[8] = 1
+	}
+	for _, s := range []string{packet.Label, packet.Protocol} {
+		payload = append(payload, byte(len(s)>>8), byte(len(s)))
+		payload = append(payload, s...)
+	}
+	return frame(frameData, append(payload, packet.Buffer...))
+}
+
+// readFrame reads the next frame, returning its kind and payload
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size == 0 {
+		return 0, nil, errors.New("empty frame")
+	}
+	if size > maxFrameSize {
+		return 0, nil, errFrameTooBig
+	}
+	payload := make([]byte, size-1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[4], payload, nil
+}
+
+// parseMedia parses the payload of an RTP or RTCP frame
+func parseMedia(payload []byte) (handle uint64, video bool, mindex int, packet []byte, err error) {
+	if len(payload) < 11 {
+		return 0, false, 0, nil, fmt.Errorf("media frame too short (%d bytes)", len(payload))
+	}
+	handle = binary.BigEndian.Uint64(payload)
+	video = payload[8]&1 != 0
+	mindex = int(int16(binary.BigEndian.Uint16(payload[9:])))
+	return handle, video, mindex, payload[11:], nil
+}
+
+// parseData parses the payload of a data frame
+func parseData(payload []byte) (uint64, *plugins.DataPacket, error) {
+	if len(payload) < 9 {
+		return 0, nil, fmt.Errorf("data frame too short (%d bytes)", len(payload))
+	}
+	handle := binary.BigEndian.Uint64(payload)
+	packet := &plugins.DataPacket{Binary: payload[8]&1 != 0}
+	rest := payload[9:]
+	for _, s := range []*string{&packet.Label, &packet.Protocol} {
+		if len(rest) < 2 {
+			return 0, nil, errors.New("truncated data frame")
+		}
+		n := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+n {
+			return 0, nil, errors.New("truncated data frame")
+		}
+		*s = string(rest[2 : 2+n])
+		rest = rest[2+n:]
+	}
+	packet.Buffer = rest
+	return handle, packet, nil
+}
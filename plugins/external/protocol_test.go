@@ -0,0 +1,89 @@
+package external
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/xroger88/go-janus/plugins"
+)
+
+func TestFrames(t *testing.T) {
+	control, err := controlFrame(&message{Type: "create_session", ID: 3, Handle: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &plugins.DataPacket{Label: "chat", Protocol: "", Binary: true, Buffer: []byte("hi")}
+	var buf bytes.Buffer
+	buf.Write(control)
+	buf.Write(mediaFrame(frameRTP, 7, true, -1, []byte{0x80, 96}))
+	buf.Write(mediaFrame(frameRTCP, 7, false, 2, []byte{0x81, 201}))
+	buf.Write(dataFrame(7, data))
+
+	kind, payload, err := readFrame(&buf)
+	if err != nil || kind != frameControl || string(payload) != `{"type":"create_session","id":3,"handle":7}` {
+		t.Errorf("got %d %s %v", kind, payload, err)
+	}
+	for _, want := range []struct {
+		kind   byte
+		video  bool
+		mindex int
+		packet []byte
+	}{
+		{frameRTP, true, -1, []byte{0x80, 96}},
+		{frameRTCP, false, 2, []byte{0x81, 201}},
+	} {
+		kind, payload, err := readFrame(&buf)
+		if err != nil || kind != want.kind {
+			t.Fatalf("got frame %d %v, want %d", kind, err, want.kind)
+		}
+		handle, video, mindex, packet, err := parseMedia(payload)
+		if err != nil || handle != 7 || video != want.video || mindex != want.mindex || !bytes.Equal(packet, want.packet) {
+			t.Errorf("got %d %v %d %v %v", handle, video, mindex, packet, err)
+		}
+	}
+	kind, payload, err = readFrame(&buf)
+	if err != nil || kind != frameData {
+		t.Fatalf("got frame %d %v, want data", kind, err)
+	}
+	if handle, packet, err := parseData(payload); err != nil || handle != 7 || !reflect.DeepEqual(packet, data) {
+		t.Errorf("got %d %+v %v", handle, packet, err)
+	}
+	if _, _, err := readFrame(&buf); err != io.EOF {
+		t.Errorf("got %v at the end, want EOF", err)
+	}
+}
+
+func TestBadFrames(t *testing.T) {
+	header := func(size uint32) []byte {
+		b := make([]byte, 5)
+		binary.BigEndian.PutUint32(b, size)
+		return b
+	}
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", header(0)},
+		{"too big", header(maxFrameSize + 1)},
+		{"truncated header", []byte{0, 0}},
+		{"truncated payload", append(header(10), 1, 2, 3)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := readFrame(bytes.NewReader(test.buf)); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+	if _, _, _, _, err := parseMedia(make([]byte, 10)); err == nil {
+		t.Error("got no error parsing a short media frame")
+	}
+	for _, payload := range [][]byte{make([]byte, 8), make([]byte, 10), append(make([]byte, 9), 0, 5, 'a')} {
+		if _, _, err := parseData(payload); err == nil {
+			t.Errorf("got no error parsing %v", payload)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package plugins
+
+// RTPPacket is an RTP packet on a PeerConnection, either received from the
+// client (IncomingRTP) or to be sent to it (RelayRTP)
+type RTPPacket struct {
+	Video bool
+	// Mindex is the m-line the packet belongs to, or -1 to let the core
+	// pick the first one of the right kind
+	Mindex int
+	Buffer []byte
+}
+
+// RTCPPacket is a compound RTCP packet on a PeerConnection
+type RTCPPacket struct {
+	Video  bool
+	Mindex int
+	Buffer []byte
+}
+
+// DataPacket is a message on a data channel of a PeerConnection
+type DataPacket struct {
+	// Label is the data channel, empty for the default one
+	Label    string
+	Protocol string
+	Binary   bool
+	Buffer   []byte
+}
@@ -0,0 +1,91 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Errors of Decode, described the way the plugins of the original Janus do
+var (
+	ErrNoMessage   = errors.New("No message??")
+	ErrInvalidJSON = errors.New("JSON error: not an object")
+)
+
+// ElementError is about an element of a message which is missing, of the
+// wrong type or with a value that makes no sense
+type ElementError struct {
+	Name    string
+	Missing bool
+	// Type is what the element should be, e.g. "a boolean"
+	Type string
+	// Reason, if set, is what's wrong with its value
+	Reason string
+}
+
+func (e *ElementError) Error() string {
+	switch {
+	case e.Missing:
+		return fmt.Sprintf("Missing mandatory element (%s)", e.Name)
+	case e.Reason != "":
+		return fmt.Sprintf("Invalid element (%s %s)", e.Name, e.Reason)
+	}
+	return fmt.Sprintf("Invalid element type (%s should be %s)", e.Name, e.Type)
+}
+
+// Missing returns the error for a mandatory element that isn't there
+func Missing(name string) error {
+	return &ElementError{Name: name, Missing: true}
+}
+
+// Invalid returns the error for an element whose value is wrong, e.g.
+// Invalid("substream", "should be 0, 1 or 2")
+func Invalid(name, reason string) error {
+	return &ElementError{Name: name, Reason: reason}
+}
+
+// Decode parses the body of a message into out, a pointer to a struct whose
+// fields are named after the elements (e.g. Audio *bool `json:"audio"`):
+// type errors are turned into ElementErrors, while checking that mandatory
+// elements are there is up to the caller
+func Decode(message json.RawMessage, out interface{}) error {
+	trimmed := strings.TrimSpace(string(message))
+	if trimmed == "" || trimmed == "null" {
+		return ErrNoMessage
+	}
+	if trimmed[0] != '{' {
+		return ErrInvalidJSON
+	}
+	err := json.Unmarshal(message, out)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &ElementError{Name: strings.ToLower(typeErr.Field), Type: typeName(typeErr.Type)}
+	}
+	if err != nil {
+		return ErrInvalidJSON
+	}
+	return nil
+}
+
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a positive integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
@@ -0,0 +1,206 @@
+package plugins
+
+// Plugins are the part of Janus that implements the actual application
+// logic (echo test, video room, streaming and so on). The core takes care
+// of sessions, handles and the WebRTC stack, and tells the plugin about
+// whatever happens to the handles attached to it using the callbacks below.
+// This mirrors the janus_plugin struct of the original Janus source tree.
+// Each plugin registers itself from the init function of its package, so
+// adding one is just a matter of importing it in main.
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/config"
+)
+
+// PluginSession is what a plugin gets for each handle attached to it.
+// Gateway is owned by the core and must not be touched by the plugin,
+// while Plugin is free for the plugin to store its own per-handle state.
+type PluginSession struct {
+	Gateway interface{}
+	Plugin  interface{}
+	stopped int32
+}
+
+// NewPluginSession returns a plugin session bound to the given core handle
+func NewPluginSession(gateway interface{}) *PluginSession {
+	return &PluginSession{Gateway: gateway}
+}
+
+// Stop marks the plugin session as over, so that late callbacks can be ignored
+func (ps *PluginSession) Stop() {
+	atomic.StoreInt32(&ps.stopped, 1)
+}
+
+// Stopped tells whether the handle behind this plugin session is gone
+func (ps *PluginSession) Stopped() bool {
+	return atomic.LoadInt32(&ps.stopped) == 1
+}
+
+// JSEP is the session description exchanged along with plugin messages and events
+type JSEP struct {
+	Type    string `json:"type"`
+	SDP     string `json:"sdp"`
+	Trickle *bool  `json:"trickle,omitempty"`
+}
+
+// ResultType tells the core how a plugin handled a message
+type ResultType int
+
+const (
+	// ResultError means the plugin couldn't handle the message at all
+	ResultError ResultType = iota
+	// ResultOK means Content is the response, to be sent right away
+	ResultOK
+	// ResultOKWait means the response will come later as an event
+	ResultOKWait
+)
+
+// Result is what HandleMessage returns. Text is the error reason for
+// ResultError, and an optional hint to add to the "ack" for ResultOKWait.
+type Result struct {
+	Type    ResultType
+	Text    string
+	Content interface{}
+}
+
+// Callbacks is what the core offers to plugins
+type Callbacks interface {
+	// PushEvent sends an asynchronous event (and an optional JSEP) to the client
+	// owning the handle; transaction is the one of the request it answers, if any
+	PushEvent(ps *PluginSession, plugin Plugin, transaction string, message interface{}, jsep *JSEP) error
+
+	// RelayRTP, RelayRTCP and RelayData send media to the client on the
+	// PeerConnection of the handle; they're dropped if it isn't up
+	RelayRTP(ps *PluginSession, packet *RTPPacket)
+	RelayRTCP(ps *PluginSession, packet *RTCPPacket)
+	RelayData(ps *PluginSession, packet *DataPacket)
+
+	// ClosePC hangs up the PeerConnection of the handle, which stays attached
+	ClosePC(ps *PluginSession)
+	// EndSession detaches the handle, as if the client asked for it
+	EndSession(ps *PluginSession)
+
+	// EventsIsEnabled tells whether events passed to NotifyEvent go anywhere
+	EventsIsEnabled() bool
+	// NotifyEvent passes an event to the event handlers: ps is the handle
+	// the event is about, or nil for events about the plugin as a whole
+	NotifyEvent(plugin Plugin, ps *PluginSession, event interface{})
+}
+
+// APIVersion is the version of the Plugin interface, bumped whenever it
+// changes: Go plugins built against another version are refused
+const APIVersion = 1
+
+// Plugin is the interface every media plugin implements.
+// Init is called once at startup with the core callbacks and the folder
+// where the plugin can find its configuration, and Destroy at shutdown.
+// HandleMessage is called for each message a client sends to a handle, and
+// the Incoming callbacks for the media it sends on the PeerConnection (from
+// the media path, so they must not block).
+// The lifecycle callbacks follow the state of the handle:
+//
+//	CreateSession  a handle has been attached to the plugin
+//	SetupMedia     the PeerConnection is up (webrtcup)
+//	SlowLink       too many NACKs on a media path
+//	HangupMedia    the PeerConnection went away (hangup)
+//	DestroySession the handle has been detached
+type Plugin interface {
+	// Package is the unique name clients use to attach, e.g. "janus.plugin.echotest"
+	Package() string
+	Name() string
+	Description() string
+	Author() string
+	Version() int
+	VersionString() string
+
+	Init(gateway Callbacks, configPath string) error
+	Destroy()
+
+	HandleMessage(ps *PluginSession, transaction string, message json.RawMessage, jsep *JSEP) *Result
+
+	IncomingRTP(ps *PluginSession, packet *RTPPacket)
+	IncomingRTCP(ps *PluginSession, packet *RTCPPacket)
+	IncomingData(ps *PluginSession, packet *DataPacket)
+
+	CreateSession(ps *PluginSession) error
+	SetupMedia(ps *PluginSession)
+	SlowLink(ps *PluginSession, uplink, video bool)
+	HangupMedia(ps *PluginSession)
+	DestroySession(ps *PluginSession) error
+
+	// QuerySession returns the plugin specific state of a handle, for the Admin API
+	QuerySession(ps *PluginSession) interface{}
+}
+
+// AdminMessageHandler is implemented by plugins that accept requests
+// sent to them via the message_plugin request of the Admin API
+type AdminMessageHandler interface {
+	HandleAdminMessage(message json.RawMessage) interface{}
+}
+
+var (
+	mutex   sync.RWMutex
+	plugins = make(map[string]Plugin)
+)
+
+// Register makes a plugin available to be attached to by its package name
+func Register(p Plugin) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	plugins[p.Package()] = p
+}
+
+// Find returns the plugin registered with the given package name, if any
+func Find(pkg string) Plugin {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return plugins[pkg]
+}
+
+// List returns all the registered plugins
+func List() []Plugin {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	list := make([]Plugin, 0, len(plugins))
+	for _, p := range plugins {
+		list = append(list, p)
+	}
+	return list
+}
+
+func unregister(p Plugin) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(plugins, p.Package())
+}
+
+// InitAll initializes the registered plugins, except the ones in the disable
+// list (e.g. libjanus_echotest.so or janus.plugin.echotest): those and the
+// ones failing to initialize are unregistered so that nobody can attach to them
+func InitAll(disable string, gateway Callbacks, configPath string) {
+	for _, p := range List() {
+		if config.Disabled(disable, p.Package()) {
+			log.Infof("Plugin %s disabled in the configuration, skipping it", p.Package())
+			unregister(p)
+			continue
+		}
+		if err := p.Init(gateway, configPath); err != nil {
+			log.Errorf("Error initializing plugin %s, skipping it: %v", p.Package(), err)
+			unregister(p)
+			continue
+		}
+		log.Infof("Loaded plugin: %s (%s %s)", p.Package(), p.Name(), p.VersionString())
+	}
+}
+
+// DestroyAll tells all the plugins we're shutting down
+func DestroyAll() {
+	for _, p := range List() {
+		p.Destroy()
+	}
+}
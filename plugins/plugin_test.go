@@ -0,0 +1,74 @@
+package plugins
+
+import (
+	"errors"
+	"testing"
+)
+
+// testPlugin only knows its package, and whether Init works
+type testPlugin struct {
+	Plugin
+	pkg     string
+	initErr error
+	inited  bool
+}
+
+func (p *testPlugin) Package() string       { return p.pkg }
+func (p *testPlugin) Name() string          { return p.pkg }
+func (p *testPlugin) VersionString() string { return "0.0.1" }
+
+func (p *testPlugin) Init(gateway Callbacks, configPath string) error {
+	p.inited = true
+	return p.initErr
+}
+
+func TestInitAll(t *testing.T) {
+	tests := []struct {
+		plugin     *testPlugin
+		registered bool
+	}{
+		{&testPlugin{pkg: "janus.plugin.one"}, true},
+		{&testPlugin{pkg: "janus.plugin.two"}, false},
+		{&testPlugin{pkg: "janus.plugin.three"}, false},
+		{&testPlugin{pkg: "janus.plugin.broken", initErr: errors.New("broken")}, false},
+	}
+	for _, test := range tests {
+		Register(test.plugin)
+	}
+	t.Cleanup(func() {
+		for _, test := range tests {
+			unregister(test.plugin)
+		}
+	})
+	// by package name or by the library name of the original Janus
+	InitAll("janus.plugin.two, libjanus_three.so,,", nil, t.TempDir())
+	for _, test := range tests {
+		t.Run(test.plugin.pkg, func(t *testing.T) {
+			if registered := Find(test.plugin.pkg) != nil; registered != test.registered {
+				t.Errorf("got registered %v, want %v", registered, test.registered)
+			}
+			if disabled := !test.registered && test.plugin.initErr == nil; test.plugin.inited == disabled {
+				t.Errorf("got initialized %v", test.plugin.inited)
+			}
+		})
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	first, second := &testPlugin{pkg: "janus.plugin.twice"}, &testPlugin{pkg: "janus.plugin.twice"}
+	Register(first)
+	Register(second)
+	t.Cleanup(func() { unregister(second) })
+	if p := Find("janus.plugin.twice"); p != second {
+		t.Errorf("got %p, want the last one registered %p", p, second)
+	}
+	count := 0
+	for _, p := range List() {
+		if p.Package() == "janus.plugin.twice" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("got %d plugins janus.plugin.twice listed, want 1", count)
+	}
+}
@@ -0,0 +1,967 @@
+package sip
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/sdp"
+	"github.com/xroger88/go-janus/sip"
+)
+
+// dialog is what requests within a dialog need (RFC 3261, section 12)
+type dialog struct {
+	callID string
+	// local and remote are the From and To of our requests, with their tags
+	local, remote *sip.Address
+	target        string
+	routes        []string
+	cseq          int
+	// proxy, if set, is where all the requests go; transport is the one to
+	// use when the target doesn't tell
+	proxy     string
+	transport string
+}
+
+// build creates a request with the headers of the dialog
+func (d *dialog) build(method string, cseq int) *sip.Message {
+	req := &sip.Message{Method: method, URI: d.target}
+	req.Add("Max-Forwards", "70")
+	for _, route := range d.routes {
+		req.Add("Route", route)
+	}
+	req.Add("From", d.local.String())
+	req.Add("To", d.remote.String())
+	req.Add("Call-ID", d.callID)
+	req.Add("CSeq", fmt.Sprintf("%d %s", cseq, method))
+	return req
+}
+
+// request creates the next request of the dialog
+func (d *dialog) request(method string) *sip.Message {
+	d.cseq++
+	return d.build(method, d.cseq)
+}
+
+// nextCSeq numbers the requests sent again after a challenge
+func (d *dialog) nextCSeq() int {
+	d.cseq++
+	return d.cseq
+}
+
+// nextHop returns where the requests of the dialog go, and over what
+func (d *dialog) nextHop() (string, string) {
+	uri, _ := sip.ParseURI(d.target)
+	if len(d.routes) > 0 {
+		if route, err := sip.ParseAddress(d.routes[0]); err == nil {
+			uri = route.URI
+		}
+	}
+	transport := d.transport
+	if uri != nil && uri.Param("transport") != "" {
+		transport = strings.ToUpper(uri.Param("transport"))
+	}
+	if d.proxy != "" {
+		return d.proxy, transport
+	}
+	if uri == nil {
+		return "", transport
+	}
+	return uri.Address(), transport
+}
+
+// established takes the remote tag, target and route set of the dialog
+// from a response to our INVITE
+func (d *dialog) established(res *sip.Message) {
+	if to, err := sip.ParseAddress(res.Get("To")); err == nil && to.Tag() != "" {
+		d.remote = to
+	}
+	if contact, err := sip.ParseAddress(res.Get("Contact")); err == nil {
+		d.target = contact.URI.String()
+	}
+	routes := res.Values("Record-Route")
+	d.routes = d.routes[:0]
+	for i := len(routes) - 1; i >= 0; i-- {
+		d.routes = append(d.routes, routes[i])
+	}
+}
+
+const (
+	// stateCalling is an outgoing call waiting for its final response
+	stateCalling = iota
+	// stateIncoming is an incoming call waiting to be accepted
+	stateIncoming
+	stateActive
+)
+
+// call is the call of a session
+type call struct {
+	dialog
+	state    int
+	outgoing bool
+	// invite is the INVITE being sent, to cancel it, or the one we got, to
+	// answer it
+	invite *sip.Message
+	// offer is the one of the browser (outgoing calls) or the one we made
+	// for it (incoming calls)
+	offer   *sdp.SDP
+	streams []*stream
+	// mlines are the m-lines of the SIP session, so that our SDPs have as
+	// many (the ones without a stream being rejected)
+	mlines             []*sdp.MLine
+	sessionID, version uint64
+	srtp               string
+	// answered tells whether the browser got an answer (early media, for
+	// outgoing calls)
+	answered   bool
+	cancelled  bool
+	hold       bool
+	remoteHold bool
+	reinviting bool
+	// transfer is the REFER the call was placed for, to tell the referrer
+	// how it goes
+	transfer *transfer
+}
+
+// transfer is a REFER we got, waiting for the call it asks for
+type transfer struct {
+	// dialog is where the NOTIFYs about the call go
+	dialog
+	referTo    string
+	referredBy string
+}
+
+func (c *call) status() string {
+	switch c.state {
+	case stateCalling:
+		return "calling"
+	case stateIncoming:
+		return "incoming"
+	}
+	return "incall"
+}
+
+// endCall removes the call of a session, if still there, and closes its
+// media; the caller holds the mutex of the session
+func (s *session) endCall(c *call) bool {
+	if s.call != c {
+		return false
+	}
+	s.call = nil
+	for _, st := range c.streams {
+		st.close()
+	}
+	return true
+}
+
+// callRequest calls a SIP URI with the offer of the browser
+func (p *Plugin) callRequest(s *session, body json.RawMessage, jsep *plugins.JSEP) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		URI     *string `json:"uri"`
+		Srtp    *string `json:"srtp"`
+		ReferID *int    `json:"refer_id"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.URI == nil {
+		return nil, nil, missing("uri")
+	}
+	uri, err := sip.ParseURI(*r.URI)
+	if err != nil {
+		return nil, nil, fail(ErrorInvalidAddress, "Invalid user address %s: %v", *r.URI, err)
+	}
+	srtp := ""
+	if r.Srtp != nil {
+		if *r.Srtp != "sdes_optional" && *r.Srtp != "sdes_mandatory" {
+			return nil, nil, invalid("srtp", "should be sdes_optional or sdes_mandatory")
+		}
+		srtp = *r.Srtp
+	}
+	offer, err := parseJSEP(jsep, "offer")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	a := s.account
+	switch {
+	case a == nil || !s.registered:
+		return nil, nil, fail(ErrorWrongState, "Wrong state (register first)")
+	case s.call != nil:
+		return nil, nil, fail(ErrorWrongState, "Wrong state (already in a call)")
+	}
+	var tr *transfer
+	if r.ReferID != nil {
+		if tr = s.transfers[*r.ReferID]; tr == nil {
+			return nil, nil, fail(ErrorReferError, "No such refer_id %d", *r.ReferID)
+		}
+		delete(s.transfers, *r.ReferID)
+	}
+	c := &call{
+		dialog: dialog{
+			callID:    sip.NewCallID(),
+			local:     &sip.Address{Display: a.identity.Display, URI: a.identity.URI, Params: ";tag=" + sip.NewTag()},
+			remote:    &sip.Address{URI: uri},
+			target:    uri.String(),
+			transport: a.transport,
+		},
+		state:     stateCalling,
+		outgoing:  true,
+		sessionID: uint64(time.Now().Unix()),
+		srtp:      srtp,
+		transfer:  tr,
+	}
+	if a.outbound {
+		c.proxy = a.proxy.Address()
+	}
+	desc, err := c.offerSIP(offer, p.config.General.Local_ip, s.ip)
+	if err != nil {
+		return nil, nil, fail(ErrorIO, "Error preparing the media: %v", err)
+	}
+	req := c.request("INVITE")
+	req.Add("Contact", s.contact(a))
+	req.Add("Allow", allow)
+	if tr != nil && tr.referredBy != "" {
+		req.Add("Referred-By", tr.referredBy)
+	}
+	req.Add("Content-Type", "application/sdp")
+	req.Body = []byte(desc.String())
+	c.invite = req
+	s.call = c
+	for _, st := range c.streams {
+		go p.receive(s, st, false)
+		go p.receive(s, st, true)
+	}
+	log.Infof("[%s] %s is calling %s", Package, a.identity.URI, uri)
+	go p.invite(s, c, req)
+	if tr != nil {
+		go p.notifyTransfer(s, tr, 100, "Trying")
+	}
+	p.notify(s.ps, map[string]interface{}{"event": "calling", "callee": uri.String(), "call_id": c.callID})
+	return map[string]interface{}{"event": "calling", "call_id": c.callID}, nil, nil
+}
+
+// invite sends the INVITE of an outgoing call, and follows it up to the
+// final response
+func (p *Plugin) invite(s *session, c *call, req *sip.Message) {
+	s.mutex.Lock()
+	a := s.account
+	dest, transport := c.nextHop()
+	callee := c.remote.URI.String()
+	s.mutex.Unlock()
+	next := func() int {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return c.nextCSeq()
+	}
+	sent := func(req *sip.Message) {
+		s.mutex.Lock()
+		c.invite = req
+		s.mutex.Unlock()
+	}
+	progress := func(res *sip.Message) {
+		s.mutex.Lock()
+		current := s.call == c && !c.cancelled
+		if current && res.StatusCode > 100 {
+			c.established(res)
+		}
+		s.mutex.Unlock()
+		if !current {
+			return
+		}
+		switch {
+		case res.StatusCode == 100:
+		case res.StatusCode == 180:
+			p.event(s, c.callID, map[string]interface{}{"event": "ringing"}, nil)
+		case len(res.Body) > 0:
+			jsep, err := p.answered(s, c, res)
+			if err != nil {
+				log.Warnf("[%s] Ignoring the early media of %s: %v", Package, callee, err)
+			}
+			if jsep != nil {
+				p.event(s, c.callID, map[string]interface{}{"event": "progress", "username": callee}, jsep)
+			}
+		default:
+			p.event(s, c.callID, map[string]interface{}{"event": "proceeding", "code": res.StatusCode}, nil)
+		}
+	}
+	req, res := p.request(s, a, req, dest, transport, next, sent, progress)
+
+	if res.StatusCode >= 300 {
+		s.mutex.Lock()
+		ended := s.endCall(c)
+		s.mutex.Unlock()
+		log.Infof("[%s] Call to %s failed: %d %s", Package, callee, res.StatusCode, res.Reason)
+		if c.transfer != nil {
+			p.notifyTransfer(s, c.transfer, res.StatusCode, res.Reason)
+		}
+		if ended {
+			p.event(s, c.callID, map[string]interface{}{"event": "hangup", "code": res.StatusCode, "reason": res.Reason}, nil)
+			p.gateway.ClosePC(s.ps)
+		}
+		return
+	}
+
+	// a 2xx: the dialog is up, and needs an ACK
+	s.mutex.Lock()
+	c.established(res)
+	n, _ := req.CSeq()
+	ack := c.build("ACK", n)
+	dest, transport = c.nextHop()
+	stack, current := s.stack, s.call == c && !c.cancelled
+	if current {
+		c.state = stateActive
+	}
+	s.mutex.Unlock()
+	if err := stack.Ack(ack, dest, transport); err != nil {
+		log.Warnf("[%s] Error sending ACK to %s: %v", Package, dest, err)
+	}
+	if c.transfer != nil {
+		p.notifyTransfer(s, c.transfer, res.StatusCode, res.Reason)
+	}
+	if !current {
+		// hung up in the meantime
+		s.mutex.Lock()
+		ended := s.endCall(c)
+		s.mutex.Unlock()
+		p.bye(s, c, nil, nil)
+		if ended {
+			p.event(s, c.callID, map[string]interface{}{"event": "hangup", "code": 487, "reason": sip.StatusText(487)}, nil)
+			p.gateway.ClosePC(s.ps)
+		}
+		return
+	}
+	jsep, err := p.answered(s, c, res)
+	if err != nil {
+		log.Warnf("[%s] Hanging up the call to %s: %v", Package, callee, err)
+		s.mutex.Lock()
+		s.endCall(c)
+		s.mutex.Unlock()
+		p.bye(s, c, nil, nil)
+		code := 488
+		if errorCode(err) == ErrorTooStrict {
+			code = 606
+		}
+		p.event(s, c.callID, map[string]interface{}{"event": "hangup", "code": code, "reason": err.Error()}, nil)
+		p.gateway.ClosePC(s.ps)
+		return
+	}
+	log.Infof("[%s] %s accepted the call", Package, callee)
+	p.event(s, c.callID, map[string]interface{}{"event": "accepted", "username": callee}, jsep)
+}
+
+// answered takes the SIP answer of an outgoing call, in a response, and
+// returns the answer for the browser (nil if it got one already)
+func (p *Plugin) answered(s *session, c *call, res *sip.Message) (*plugins.JSEP, error) {
+	desc, err := sdp.Parse(string(res.Body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SDP: %v", err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c.answered {
+		// early media already set things up, but the peer may have moved
+		c.updated(desc)
+		return nil, nil
+	}
+	answer, err := c.answerWebRTC(desc)
+	if err != nil {
+		return nil, err
+	}
+	c.answered = true
+	return &plugins.JSEP{Type: "answer", SDP: answer.String()}, nil
+}
+
+// bye ends a dialog; then, if not nil, runs once the BYE got its response
+func (p *Plugin) bye(s *session, c *call, wg *sync.WaitGroup, then func()) {
+	s.mutex.Lock()
+	a := s.account
+	req := c.request("BYE")
+	dest, transport := c.nextHop()
+	s.mutex.Unlock()
+	if wg != nil {
+		wg.Add(1)
+	}
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+		_, res := p.request(s, a, req, dest, transport, func() int {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			return c.nextCSeq()
+		}, nil, nil)
+		log.Infof("[%s] BYE to %s: %d %s", Package, c.remote.URI, res.StatusCode, res.Reason)
+		if then != nil {
+			then()
+		}
+	}()
+}
+
+// accept answers an incoming call with the answer of the browser
+func (p *Plugin) accept(s *session, jsep *plugins.JSEP) (map[string]interface{}, *plugins.JSEP, error) {
+	answer, err := parseJSEP(jsep, "answer")
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mutex.Lock()
+	c := s.call
+	if c == nil || c.state != stateIncoming {
+		s.mutex.Unlock()
+		return nil, nil, fail(ErrorWrongState, "Wrong state (no incoming call to accept)")
+	}
+	desc, err := c.answerSIP(answer, s.ip)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, nil, fail(ErrorMissingSDP, "Error answering the call: %v", err)
+	}
+	c.state = stateActive
+	res := sip.NewResponse(c.invite, 200, "", c.local.Tag())
+	res.Add("Contact", s.contact(s.account))
+	res.Add("Allow", allow)
+	res.Add("Content-Type", "application/sdp")
+	res.Body = []byte(desc.String())
+	stack, invite, caller := s.stack, c.invite, c.remote.URI.String()
+	s.mutex.Unlock()
+	if err := p.respond(stack, invite, res); err != nil {
+		return nil, nil, fail(ErrorIO, "Error sending the answer: %v", err)
+	}
+	log.Infof("[%s] Accepted the call from %s", Package, caller)
+	p.notify(s.ps, map[string]interface{}{"event": "accepted", "username": caller, "call_id": c.callID})
+	return map[string]interface{}{"event": "accepted", "username": caller, "call_id": c.callID}, nil, nil
+}
+
+// decline rejects an incoming call
+func (p *Plugin) decline(s *session, body json.RawMessage) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Code *int `json:"code"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, nil, err
+	}
+	code := 486
+	if r.Code != nil {
+		if *r.Code < 300 || *r.Code > 699 {
+			return nil, nil, invalid("code", "should be a SIP error code")
+		}
+		code = *r.Code
+	}
+	s.mutex.Lock()
+	c := s.call
+	if c == nil || c.state != stateIncoming {
+		s.mutex.Unlock()
+		return nil, nil, fail(ErrorWrongState, "Wrong state (no incoming call to decline)")
+	}
+	s.endCall(c)
+	stack := s.stack
+	s.mutex.Unlock()
+	p.respond(stack, c.invite, sip.NewResponse(c.invite, code, "", c.local.Tag()))
+	p.gateway.ClosePC(s.ps)
+	log.Infof("[%s] Declined the call from %s (%d)", Package, c.remote.URI, code)
+	p.notify(s.ps, map[string]interface{}{"event": "declining", "code": code, "call_id": c.callID})
+	return map[string]interface{}{"event": "declining", "code": code, "call_id": c.callID}, nil, nil
+}
+
+func (p *Plugin) hangupRequest(s *session) (map[string]interface{}, *plugins.JSEP, error) {
+	s.mutex.Lock()
+	c := s.call
+	s.mutex.Unlock()
+	if c == nil || !p.hangup(s, nil) {
+		return nil, nil, fail(ErrorWrongState, "Wrong state (not in a call)")
+	}
+	return map[string]interface{}{"event": "hangingup", "call_id": c.callID}, nil, nil
+}
+
+// hangup ends the call of a session, if any, whatever its state: wg, if
+// not nil, waits for the requests it sends
+func (p *Plugin) hangup(s *session, wg *sync.WaitGroup) bool {
+	s.mutex.Lock()
+	c := s.call
+	if c == nil {
+		s.mutex.Unlock()
+		return false
+	}
+	switch c.state {
+	case stateCalling:
+		// the final response to the INVITE ends the call
+		if c.cancelled {
+			s.mutex.Unlock()
+			return true
+		}
+		c.cancelled = true
+		stack, invite := s.stack, c.invite
+		s.mutex.Unlock()
+		log.Infof("[%s] Cancelling the call to %s", Package, c.remote.URI)
+		if wg != nil {
+			wg.Add(1)
+		}
+		go func() {
+			if wg != nil {
+				defer wg.Done()
+			}
+			if responses, err := stack.Cancel(invite); err == nil {
+				for range responses {
+				}
+			}
+		}()
+	case stateIncoming:
+		s.endCall(c)
+		stack := s.stack
+		s.mutex.Unlock()
+		p.respond(stack, c.invite, sip.NewResponse(c.invite, 603, "", c.local.Tag()))
+		p.event(s, c.callID, map[string]interface{}{"event": "hangup", "code": 603, "reason": sip.StatusText(603)}, nil)
+	default:
+		s.endCall(c)
+		s.mutex.Unlock()
+		log.Infof("[%s] Hanging up the call with %s", Package, c.remote.URI)
+		p.bye(s, c, wg, func() {
+			p.event(s, c.callID, map[string]interface{}{"event": "hangup", "code": 200, "reason": "BYE"}, nil)
+		})
+	}
+	p.gateway.ClosePC(s.ps)
+	return true
+}
+
+// holdRequest puts the SIP peer on hold, or resumes the call, with a
+// re-INVITE
+func (p *Plugin) holdRequest(s *session, body json.RawMessage, hold bool) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Direction *string `json:"direction"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, nil, err
+	}
+	dir := sdp.SendRecv
+	if hold {
+		dir = sdp.SendOnly
+		if r.Direction != nil {
+			d, ok := sdp.ParseDirection(*r.Direction)
+			if !ok || d == sdp.SendRecv {
+				return nil, nil, invalid("direction", "should be sendonly, recvonly or inactive")
+			}
+			dir = d
+		}
+	}
+	s.mutex.Lock()
+	c := s.call
+	switch {
+	case c == nil || c.state != stateActive:
+		s.mutex.Unlock()
+		return nil, nil, fail(ErrorWrongState, "Wrong state (not in a call)")
+	case c.reinviting:
+		s.mutex.Unlock()
+		return nil, nil, fail(ErrorWrongState, "Wrong state (already renegotiating)")
+	}
+	c.hold, c.reinviting = hold, true
+	for _, st := range c.streams {
+		st.setDirection(dir)
+	}
+	req := c.request("INVITE")
+	req.Add("Contact", s.contact(s.account))
+	req.Add("Allow", allow)
+	req.Add("Content-Type", "application/sdp")
+	req.Body = []byte(c.sipSDP(s.ip).String())
+	s.mutex.Unlock()
+	go p.reinvite(s, c, req)
+	event := "resuming"
+	if hold {
+		event = "holding"
+	}
+	p.notify(s.ps, map[string]interface{}{"event": event, "call_id": c.callID})
+	return map[string]interface{}{"event": event, "call_id": c.callID}, nil, nil
+}
+
+// reinvite sends a re-INVITE we made, and takes the answer
+func (p *Plugin) reinvite(s *session, c *call, req *sip.Message) {
+	s.mutex.Lock()
+	a := s.account
+	dest, transport := c.nextHop()
+	s.mutex.Unlock()
+	req, res := p.request(s, a, req, dest, transport, func() int {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return c.nextCSeq()
+	}, nil, nil)
+	s.mutex.Lock()
+	c.reinviting = false
+	if res.StatusCode >= 300 {
+		s.mutex.Unlock()
+		log.Warnf("[%s] re-INVITE to %s failed: %d %s", Package, c.remote.URI, res.StatusCode, res.Reason)
+		return
+	}
+	n, _ := req.CSeq()
+	ack := c.build("ACK", n)
+	dest, transport = c.nextHop()
+	if desc, err := sdp.Parse(string(res.Body)); err == nil {
+		c.updated(desc)
+	}
+	stack := s.stack
+	s.mutex.Unlock()
+	stack.Ack(ack, dest, transport)
+}
+
+// dtmfInfo sends a DTMF digit with a SIP INFO
+func (p *Plugin) dtmfInfo(s *session, body json.RawMessage) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Digit    *string `json:"digit"`
+		Duration *int    `json:"duration"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Digit == nil {
+		return nil, nil, missing("digit")
+	}
+	digit := strings.ToUpper(*r.Digit)
+	if len(digit) != 1 || !strings.Contains(dtmfSignals, digit) {
+		return nil, nil, invalid("digit", "should be one of 0-9, *, #, A-D")
+	}
+	duration := 160
+	if r.Duration != nil {
+		if *r.Duration <= 0 {
+			return nil, nil, invalid("duration", "should be positive")
+		}
+		duration = *r.Duration
+	}
+	s.mutex.Lock()
+	c := s.call
+	if c == nil || c.state != stateActive {
+		s.mutex.Unlock()
+		return nil, nil, fail(ErrorWrongState, "Wrong state (not in a call)")
+	}
+	req := c.request("INFO")
+	req.Add("Content-Type", "application/dtmf-relay")
+	req.Body = []byte(fmt.Sprintf("Signal=%s\r\nDuration=%d\r\n", digit, duration))
+	s.mutex.Unlock()
+	go p.inDialog(s, c, req)
+	return map[string]interface{}{"event": "dtmfsent", "call_id": c.callID}, nil, nil
+}
+
+// transferRequest asks the SIP peer to call someone else, with a REFER
+func (p *Plugin) transferRequest(s *session, body json.RawMessage) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		URI *string `json:"uri"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.URI == nil {
+		return nil, nil, missing("uri")
+	}
+	uri, err := sip.ParseURI(*r.URI)
+	if err != nil {
+		return nil, nil, fail(ErrorInvalidAddress, "Invalid user address %s: %v", *r.URI, err)
+	}
+	s.mutex.Lock()
+	c := s.call
+	if c == nil || c.state != stateActive {
+		s.mutex.Unlock()
+		return nil, nil, fail(ErrorWrongState, "Wrong state (not in a call)")
+	}
+	req := c.request("REFER")
+	req.Add("Contact", s.contact(s.account))
+	req.Add("Refer-To", "<"+uri.String()+">")
+	req.Add("Referred-By", "<"+c.local.URI.String()+">")
+	s.mutex.Unlock()
+	go p.inDialog(s, c, req)
+	log.Infof("[%s] Transferring %s to %s", Package, c.remote.URI, uri)
+	return map[string]interface{}{"event": "transferring", "call_id": c.callID}, nil, nil
+}
+
+// inDialog sends a request of a dialog, caring only about failures
+func (p *Plugin) inDialog(s *session, c *call, req *sip.Message) {
+	s.mutex.Lock()
+	a := s.account
+	dest, transport := c.nextHop()
+	s.mutex.Unlock()
+	_, res := p.request(s, a, req, dest, transport, func() int {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return c.nextCSeq()
+	}, nil, nil)
+	if res.StatusCode >= 300 {
+		log.Warnf("[%s] %s to %s failed: %d %s", Package, req.Method, c.remote.URI, res.StatusCode, res.Reason)
+		p.event(s, c.callID, map[string]interface{}{"event": strings.ToLower(req.Method) + "_failed", "code": res.StatusCode, "reason": res.Reason}, nil)
+	}
+}
+
+// notifyTransfer tells the referrer how the call it asked for goes
+func (p *Plugin) notifyTransfer(s *session, tr *transfer, code int, reason string) {
+	s.mutex.Lock()
+	a := s.account
+	req := tr.request("NOTIFY")
+	dest, transport := tr.nextHop()
+	s.mutex.Unlock()
+	req.Add("Event", "refer")
+	if code < 200 {
+		req.Add("Subscription-State", "active;expires=60")
+	} else {
+		req.Add("Subscription-State", "terminated;reason=noresource")
+	}
+	req.Add("Content-Type", "message/sipfrag;version=2.0")
+	req.Body = []byte(fmt.Sprintf("SIP/2.0 %d %s\r\n", code, reason))
+	p.request(s, a, req, dest, transport, func() int {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return tr.nextCSeq()
+	}, nil, nil)
+}
+
+// respond sends a response, with our User-Agent
+func (p *Plugin) respond(stack *sip.Stack, req, res *sip.Message) error {
+	if p.config.General.User_agent != "" {
+		res.Set("User-Agent", p.config.General.User_agent)
+	}
+	return stack.Respond(req, res)
+}
+
+// incoming handles the requests the SIP stack of a session gets
+func (p *Plugin) incoming(s *session, req *sip.Message) {
+	s.mutex.Lock()
+	stack, c := s.stack, s.call
+	inDialog := c != nil && c.callID == req.CallID()
+	s.mutex.Unlock()
+	reply := func(code int) {
+		tag := sip.NewTag()
+		if inDialog {
+			tag = c.local.Tag()
+		}
+		p.respond(stack, req, sip.NewResponse(req, code, "", tag))
+	}
+	switch {
+	case req.Method == "ACK":
+	case req.Method == "OPTIONS":
+		res := sip.NewResponse(req, 200, "", sip.NewTag())
+		res.Add("Allow", allow)
+		res.Add("Accept", "application/sdp")
+		p.respond(stack, req, res)
+	case req.Method == "INVITE" && !inDialog:
+		p.incomingCall(s, req)
+	case req.Method == "CANCEL" && inDialog:
+		s.mutex.Lock()
+		pending := s.call == c && c.state == stateIncoming && c.invite.Branch() == req.Branch()
+		if pending {
+			s.endCall(c)
+		}
+		s.mutex.Unlock()
+		if !pending {
+			reply(481)
+			return
+		}
+		reply(200)
+		p.respond(stack, c.invite, sip.NewResponse(c.invite, 487, "", c.local.Tag()))
+		log.Infof("[%s] %s cancelled the call", Package, c.remote.URI)
+		p.event(s, c.callID, map[string]interface{}{"event": "hangup", "code": 487, "reason": sip.StatusText(487)}, nil)
+		p.gateway.ClosePC(s.ps)
+	case !inDialog:
+		if req.Method == "BYE" || req.Method == "CANCEL" || req.Method == "INFO" || req.Method == "NOTIFY" || req.Method == "REFER" {
+			reply(481)
+		} else {
+			reply(501)
+		}
+	case req.Method == "INVITE":
+		p.reinvited(s, c, req)
+	case req.Method == "BYE":
+		s.mutex.Lock()
+		ended := s.endCall(c)
+		s.mutex.Unlock()
+		reply(200)
+		if ended {
+			log.Infof("[%s] %s hung up", Package, c.remote.URI)
+			p.event(s, c.callID, map[string]interface{}{"event": "hangup", "code": 200, "reason": "BYE"}, nil)
+			p.gateway.ClosePC(s.ps)
+		}
+	case req.Method == "INFO":
+		reply(200)
+		p.event(s, c.callID, map[string]interface{}{
+			"event":       "info",
+			"sender":      c.remote.URI.String(),
+			"displayname": c.remote.Display,
+			"type":        req.Get("Content-Type"),
+			"content":     string(req.Body),
+		}, nil)
+	case req.Method == "NOTIFY":
+		reply(200)
+		p.event(s, c.callID, map[string]interface{}{
+			"event":        "notify",
+			"notify":       req.Get("Event"),
+			"substate":     req.Get("Subscription-State"),
+			"content-type": req.Get("Content-Type"),
+			"content":      string(req.Body),
+		}, nil)
+	case req.Method == "REFER":
+		p.referred(s, c, req)
+	default:
+		reply(501)
+	}
+}
+
+// incomingCall handles an INVITE out of any dialog: a new call, unless
+// there's one already
+func (p *Plugin) incomingCall(s *session, req *sip.Message) {
+	s.mutex.Lock()
+	stack, a, busy := s.stack, s.account, s.call != nil
+	s.mutex.Unlock()
+	from, err1 := sip.ParseAddress(req.Get("From"))
+	to, err2 := sip.ParseAddress(req.Get("To"))
+	contact, err3 := sip.ParseAddress(req.Get("Contact"))
+	switch {
+	case err1 != nil || err2 != nil || err3 != nil:
+		p.respond(stack, req, sip.NewResponse(req, 400, "", sip.NewTag()))
+		return
+	case a == nil:
+		p.respond(stack, req, sip.NewResponse(req, 480, "", sip.NewTag()))
+		return
+	case busy:
+		log.Infof("[%s] Busy, rejecting the call from %s", Package, from.URI)
+		p.respond(stack, req, sip.NewResponse(req, 486, "", sip.NewTag()))
+		p.event(s, "", map[string]interface{}{"event": "missed_call", "caller": from.URI.String(), "displayname": from.Display}, nil)
+		return
+	}
+	offer, err := sdp.Parse(string(req.Body))
+	if err != nil || !strings.HasPrefix(req.Get("Content-Type"), "application/sdp") {
+		log.Warnf("[%s] Rejecting the call from %s, without a valid SDP offer", Package, from.URI)
+		p.respond(stack, req, sip.NewResponse(req, 488, "", sip.NewTag()))
+		return
+	}
+	c := &call{
+		dialog: dialog{
+			callID:    req.CallID(),
+			local:     &sip.Address{Display: to.Display, URI: to.URI, Params: to.Params + ";tag=" + sip.NewTag()},
+			remote:    from,
+			target:    contact.URI.String(),
+			routes:    req.Values("Record-Route"),
+			transport: req.Transport,
+		},
+		state:     stateIncoming,
+		invite:    req,
+		sessionID: uint64(time.Now().Unix()),
+	}
+	if a.outbound {
+		c.proxy = a.proxy.Address()
+	}
+	web, err := c.offerWebRTC(offer, p.config.General.Local_ip)
+	if err != nil {
+		log.Warnf("[%s] Rejecting the call from %s: %v", Package, from.URI, err)
+		p.respond(stack, req, sip.NewResponse(req, 488, "", sip.NewTag()))
+		return
+	}
+	s.mutex.Lock()
+	if s.call != nil {
+		s.mutex.Unlock()
+		for _, st := range c.streams {
+			st.close()
+		}
+		p.respond(stack, req, sip.NewResponse(req, 486, "", sip.NewTag()))
+		return
+	}
+	s.call = c
+	ringing := sip.NewResponse(req, 180, "", c.local.Tag())
+	ringing.Add("Contact", s.contact(a))
+	s.mutex.Unlock()
+	for _, st := range c.streams {
+		go p.receive(s, st, false)
+		go p.receive(s, st, true)
+	}
+	p.respond(stack, req, ringing)
+	log.Infof("[%s] Incoming call from %s", Package, from.URI)
+	event := map[string]interface{}{
+		"event":       "incomingcall",
+		"username":    from.URI.String(),
+		"displayname": from.Display,
+		"callee":      to.URI.String(),
+	}
+	if c.srtp != "" {
+		event["srtp"] = c.srtp
+	}
+	p.event(s, c.callID, event, &plugins.JSEP{Type: "offer", SDP: web.String()})
+}
+
+// reinvited answers a re-INVITE of the SIP peer, e.g. to put us on hold
+func (p *Plugin) reinvited(s *session, c *call, req *sip.Message) {
+	s.mutex.Lock()
+	stack := s.stack
+	if c.state != stateActive || c.reinviting {
+		s.mutex.Unlock()
+		p.respond(stack, req, sip.NewResponse(req, 491, "", c.local.Tag()))
+		return
+	}
+	if contact, err := sip.ParseAddress(req.Get("Contact")); err == nil {
+		c.target = contact.URI.String()
+	}
+	hold := c.remoteHold
+	if len(req.Body) > 0 {
+		desc, err := sdp.Parse(string(req.Body))
+		if err != nil {
+			s.mutex.Unlock()
+			p.respond(stack, req, sip.NewResponse(req, 488, "", c.local.Tag()))
+			return
+		}
+		for i := len(c.mlines); i < len(desc.MLines); i++ {
+			c.mlines = append(c.mlines, desc.MLines[i])
+		}
+		hold = c.updated(desc)
+	}
+	res := sip.NewResponse(req, 200, "", c.local.Tag())
+	res.Add("Contact", s.contact(s.account))
+	res.Add("Allow", allow)
+	res.Add("Content-Type", "application/sdp")
+	res.Body = []byte(c.sipSDP(s.ip).String())
+	changed := hold != c.remoteHold
+	c.remoteHold = hold
+	s.mutex.Unlock()
+	p.respond(stack, req, res)
+	if changed {
+		log.Infof("[%s] %s %s", Package, c.remote.URI, map[bool]string{true: "put us on hold", false: "resumed the call"}[hold])
+		p.event(s, c.callID, map[string]interface{}{"event": map[bool]string{true: "hold", false: "unhold"}[hold]}, nil)
+	}
+}
+
+// referred takes a REFER of the SIP peer: the browser is told about it, and
+// may place the call with the refer_id
+func (p *Plugin) referred(s *session, c *call, req *sip.Message) {
+	s.mutex.Lock()
+	stack := s.stack
+	referTo, err := sip.ParseAddress(req.Get("Refer-To"))
+	if err != nil {
+		s.mutex.Unlock()
+		p.respond(stack, req, sip.NewResponse(req, 400, "Invalid Refer-To", c.local.Tag()))
+		return
+	}
+	s.referID++
+	id := s.referID
+	tr := &transfer{dialog: c.dialog, referTo: referTo.URI.String(), referredBy: req.Get("Referred-By")}
+	// the NOTIFYs must not reuse the CSeqs of the call
+	tr.cseq += 1000
+	tr.routes = append([]string(nil), c.routes...)
+	s.transfers[id] = tr
+	s.mutex.Unlock()
+	p.respond(stack, req, sip.NewResponse(req, 202, "", c.local.Tag()))
+	log.Infof("[%s] %s asks for a transfer to %s", Package, c.remote.URI, tr.referTo)
+	event := map[string]interface{}{"event": "transfer", "refer_id": id, "refer_to": tr.referTo}
+	if tr.referredBy != "" {
+		event["referred_by"] = tr.referredBy
+	}
+	p.event(s, c.callID, event, nil)
+}
+
+// parseJSEP checks that there's a JSEP of the right type, and parses it
+func parseJSEP(jsep *plugins.JSEP, kind string) (*sdp.SDP, error) {
+	if jsep == nil {
+		return nil, fail(ErrorMissingSDP, "Missing SDP")
+	}
+	if jsep.Type != kind {
+		return nil, fail(ErrorMissingSDP, "Unexpected %s, an %s is needed", jsep.Type, kind)
+	}
+	desc, err := sdp.Parse(jsep.SDP)
+	if err != nil {
+		return nil, fail(ErrorMissingSDP, "Error parsing %s: %v", kind, err)
+	}
+	return desc, nil
+}
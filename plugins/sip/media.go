@@ -0,0 +1,681 @@
+package sip
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pion/srtp/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/rtcp"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sdp"
+)
+
+// the only SDES crypto suite we offer and accept
+const cryptoSuite = "AES_CM_128_HMAC_SHA1_80"
+
+// RFC 2833 events, by code
+const dtmfSignals = "0123456789*#ABCD"
+
+const bufferSize = 1500
+
+// codec is a payload type of an m-line, as SDPs write it
+type codec struct {
+	name   string
+	pt     int
+	rtpmap string
+	fmtp   string
+}
+
+// codecsOf returns the codecs of an m-line we can relay, in its order: the
+// ones we know of its type, and telephone-event along with audio
+func codecsOf(m *sdp.MLine) []codec {
+	var list []codec
+	dtmf := false
+	for _, format := range m.Formats {
+		pt, err := strconv.Atoi(format)
+		if err != nil {
+			continue
+		}
+		name := m.CodecName(pt)
+		switch {
+		case name == sdp.DTMF && m.Type == sdp.Audio && !dtmf:
+			dtmf = true
+		case name == "" || !sdp.KnownCodec(m.Type, name):
+			continue
+		}
+		list = append(list, codec{name: name, pt: pt, rtpmap: rtpmapOf(m, pt, name), fmtp: m.Fmtp(pt)})
+	}
+	return list
+}
+
+// rtpmapOf returns the rtpmap of a payload type, which static ones may not have
+func rtpmapOf(m *sdp.MLine, pt int, name string) string {
+	prefix := strconv.Itoa(pt) + " "
+	for _, value := range m.Values("rtpmap") {
+		if strings.HasPrefix(value, prefix) {
+			return value[len(prefix):]
+		}
+	}
+	return sdp.RTPMap(name)
+}
+
+// stream is the audio or the video of a call on the SIP side: RTP (or SRTP)
+// and RTCP on a pair of ports, relayed to and from the PeerConnection
+type stream struct {
+	kind           sdp.MediaType
+	conn, rtcpConn *net.UDPConn
+
+	// mutex protects all that follows
+	mutex sync.Mutex
+	// index is the one of the m-line of the stream in the SIP SDPs
+	index int
+	proto string
+	// codecs are what our SIP SDPs tell: all we can relay when offering,
+	// what was negotiated afterwards
+	codecs []codec
+	// codec is the negotiated one, and pt and dtmf its payload type and
+	// the one of telephone-event (-1 if none) on the SIP side
+	codec    string
+	pt, dtmf int
+	// dir is what we tell the SIP peer we do, remoteDir what it tells us
+	dir, remoteDir     sdp.Direction
+	remote, remoteRTCP *net.UDPAddr
+	toSIP, toWebRTC    map[uint8]uint8
+	// timestamp of the last DTMF event we told about
+	lastEvent uint32
+	hadEvent  bool
+
+	// SDES: our key and salt, and the ones of the SIP peer
+	tag              int
+	key, remoteKey   []byte
+	encrypt, decrypt *srtp.Context
+	decryptKey       []byte
+}
+
+// newStream binds a pair of ports (RTP on an even one, RTCP on the next)
+func newStream(kind sdp.MediaType, host string) (*stream, error) {
+	for i := 0; i < 10; i++ {
+		conn, err := rtp.ListenUDP(host, 0)
+		if err != nil {
+			return nil, err
+		}
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		rtcpConn, err := rtp.ListenUDP(host, port+1)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		return &stream{
+			kind:      kind,
+			conn:      conn,
+			rtcpConn:  rtcpConn,
+			pt:        -1,
+			dtmf:      -1,
+			dir:       sdp.SendRecv,
+			remoteDir: sdp.SendRecv,
+			toSIP:     make(map[uint8]uint8),
+			toWebRTC:  make(map[uint8]uint8),
+		}, nil
+	}
+	return nil, errors.New("no pair of ports available")
+}
+
+func (st *stream) port() int {
+	return st.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func (st *stream) close() {
+	st.conn.Close()
+	st.rtcpConn.Close()
+}
+
+func (st *stream) describe() map[string]interface{} {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	info := map[string]interface{}{
+		"type":       st.kind.String(),
+		"local_port": st.port(),
+		"codec":      st.codec,
+		"direction":  st.dir.String(),
+		"srtp":       st.encrypt != nil,
+	}
+	if st.remote != nil {
+		info["remote"] = st.remote.String()
+	}
+	return info
+}
+
+func (st *stream) setDirection(dir sdp.Direction) {
+	st.mutex.Lock()
+	st.dir = dir
+	st.mutex.Unlock()
+}
+
+// newKey creates our SDES key
+func (st *stream) newKey() {
+	st.key = make([]byte, 30)
+	rand.Read(st.key)
+	st.tag = 1
+}
+
+// cryptoOf returns the tag and the key of the first a=crypto of an m-line
+// with the suite we support, if any
+func cryptoOf(m *sdp.MLine) (int, []byte) {
+	for _, value := range m.Values("crypto") {
+		f := strings.Fields(value)
+		if len(f) < 3 || f[1] != cryptoSuite || !strings.HasPrefix(f[2], "inline:") {
+			continue
+		}
+		encoded := strings.SplitN(strings.TrimPrefix(f[2], "inline:"), "|", 2)[0]
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			key, err = base64.RawStdEncoding.DecodeString(encoded)
+		}
+		if err != nil || len(key) != 30 {
+			continue
+		}
+		tag, _ := strconv.Atoi(f[0])
+		return tag, key
+	}
+	return 0, nil
+}
+
+// secure sets the SRTP contexts up, once the keys of both sides are known
+func (st *stream) secure() error {
+	if st.key == nil || st.remoteKey == nil {
+		st.encrypt, st.decrypt, st.decryptKey = nil, nil, nil
+		return nil
+	}
+	var err error
+	if st.encrypt == nil {
+		if st.encrypt, err = srtp.CreateContext(st.key[:16], st.key[16:], srtp.ProtectionProfileAes128CmHmacSha1_80); err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(st.decryptKey, st.remoteKey) {
+		if st.decrypt, err = srtp.CreateContext(st.remoteKey[:16], st.remoteKey[16:], srtp.ProtectionProfileAes128CmHmacSha1_80); err != nil {
+			return err
+		}
+		st.decryptKey = st.remoteKey
+	}
+	return nil
+}
+
+// setRemote takes where the SIP peer wants the media, what it does with it
+// and its key, from its m-line of the stream
+func (st *stream) setRemote(desc *sdp.SDP, m *sdp.MLine) error {
+	conn := m.Connection
+	if conn == "" {
+		conn = desc.Connection
+	}
+	var ip net.IP
+	if f := strings.Fields(conn); len(f) == 3 {
+		if ip = net.ParseIP(f[2]); ip == nil {
+			if addr, err := net.ResolveIPAddr("ip", f[2]); err == nil {
+				ip = addr.IP
+			}
+		}
+	}
+	if ip == nil || ip.IsUnspecified() || m.Port == 0 {
+		// on hold, the old way
+		st.remote, st.remoteRTCP = nil, nil
+	} else {
+		st.remote = &net.UDPAddr{IP: ip, Port: m.Port}
+		st.remoteRTCP = &net.UDPAddr{IP: ip, Port: m.Port + 1}
+		if a := m.Attribute("rtcp"); a != nil {
+			// a=rtcp:<port> [IN IP4 <address>], if not broken
+			f := strings.Fields(a.Value)
+			if len(f) > 0 {
+				if port, err := strconv.Atoi(f[0]); err == nil {
+					st.remoteRTCP.Port = port
+				}
+			}
+			if len(f) == 4 {
+				if rip := net.ParseIP(f[3]); rip != nil {
+					st.remoteRTCP.IP = rip
+				}
+			}
+		}
+	}
+	st.remoteDir = m.Direction
+	if st.key != nil {
+		_, st.remoteKey = cryptoOf(m)
+	}
+	return st.secure()
+}
+
+// mapPTs takes note of the payload types of the codec and of
+// telephone-event on both sides
+func (st *stream) mapPTs(webPT, sipPT, webDTMF, sipDTMF int) {
+	st.toSIP, st.toWebRTC = make(map[uint8]uint8), make(map[uint8]uint8)
+	st.toSIP[uint8(webPT)], st.toWebRTC[uint8(sipPT)] = uint8(sipPT), uint8(webPT)
+	st.pt, st.dtmf = sipPT, -1
+	if webDTMF >= 0 && sipDTMF >= 0 {
+		st.toSIP[uint8(webDTMF)], st.toWebRTC[uint8(sipDTMF)] = uint8(sipDTMF), uint8(webDTMF)
+		st.dtmf = sipDTMF
+	}
+}
+
+// mline returns the m-line of the stream for our SIP SDPs
+func (st *stream) mline() *sdp.MLine {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	m := &sdp.MLine{Type: st.kind, Port: st.port(), Proto: st.proto, Direction: st.dir}
+	for _, c := range st.codecs {
+		pt := strconv.Itoa(c.pt)
+		m.Formats = append(m.Formats, pt)
+		m.AddAttribute("rtpmap", pt+" "+c.rtpmap)
+		if c.fmtp != "" {
+			m.AddAttribute("fmtp", pt+" "+c.fmtp)
+		}
+	}
+	if st.key != nil {
+		m.AddAttribute("crypto", fmt.Sprintf("%d %s inline:%s", st.tag, cryptoSuite, base64.StdEncoding.EncodeToString(st.key)))
+	}
+	return m
+}
+
+// negotiated narrows the codecs of our SIP SDPs down to the negotiated ones
+func (st *stream) negotiated(m *sdp.MLine) {
+	var kept []codec
+	for _, c := range codecsOf(m) {
+		if c.pt == st.pt || c.pt == st.dtmf {
+			kept = append(kept, c)
+		}
+	}
+	st.codecs = kept
+}
+
+// sending tells whether what the browser sends goes to the SIP peer
+func (st *stream) sending() bool {
+	return st.remote != nil && st.dir.Sends() && st.remoteDir.Receives() && (st.key == nil || st.encrypt != nil)
+}
+
+// sendRTP relays an RTP packet of the browser to the SIP peer
+func (st *stream) sendRTP(packet []byte) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if !st.sending() {
+		return
+	}
+	buf := append([]byte(nil), packet...)
+	if pt, ok := st.toSIP[rtp.PayloadType(buf)]; ok {
+		rtp.SetPayloadType(buf, pt)
+	}
+	if st.encrypt != nil {
+		var err error
+		if buf, err = st.encrypt.EncryptRTP(nil, buf, nil); err != nil {
+			return
+		}
+	}
+	st.conn.WriteToUDP(buf, st.remote)
+}
+
+// sendRTCP relays an RTCP packet of the browser to the SIP peer
+func (st *stream) sendRTCP(packet []byte) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.remoteRTCP == nil || (st.key != nil && st.encrypt == nil) || !rtcp.IsRTCP(packet) {
+		return
+	}
+	buf := append([]byte(nil), packet...)
+	if st.encrypt != nil {
+		var err error
+		if buf, err = st.encrypt.EncryptRTCP(nil, buf, nil); err != nil {
+			return
+		}
+	}
+	st.rtcpConn.WriteToUDP(buf, st.remoteRTCP)
+}
+
+// dtmfEvent is the end of an RFC 2833 event the SIP peer sent
+type dtmfEvent struct {
+	signal   string
+	duration int // in ms
+}
+
+// received turns what the SIP peer sent into what the browser gets (nil if
+// nothing), along with the DTMF event it ends, if any
+func (st *stream) received(data []byte, isRTCP bool) ([]byte, *dtmfEvent) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	var buf []byte
+	var err error
+	switch {
+	case st.key != nil && st.decrypt == nil:
+		return nil, nil
+	case st.decrypt != nil && isRTCP:
+		if buf, err = st.decrypt.DecryptRTCP(nil, data, nil); err != nil {
+			return nil, nil
+		}
+	case st.decrypt != nil:
+		if buf, err = st.decrypt.DecryptRTP(nil, data, nil); err != nil {
+			return nil, nil
+		}
+	default:
+		buf = append([]byte(nil), data...)
+	}
+	if isRTCP {
+		if !rtcp.IsRTCP(buf) {
+			return nil, nil
+		}
+		return buf, nil
+	}
+	if !rtp.IsRTP(buf) {
+		return nil, nil
+	}
+	var event *dtmfEvent
+	pt := rtp.PayloadType(buf)
+	if int(pt) == st.dtmf {
+		// the end of an event comes three times
+		if payload, err := rtp.Payload(buf); err == nil && len(payload) >= 4 && payload[1]&0x80 != 0 &&
+			int(payload[0]) < len(dtmfSignals) && (!st.hadEvent || st.lastEvent != rtp.Timestamp(buf)) {
+			st.hadEvent, st.lastEvent = true, rtp.Timestamp(buf)
+			event = &dtmfEvent{signal: dtmfSignals[payload[0] : payload[0]+1], duration: int(binary.BigEndian.Uint16(payload[2:])) / 8}
+		}
+	}
+	if mapped, ok := st.toWebRTC[pt]; ok {
+		rtp.SetPayloadType(buf, mapped)
+	}
+	return buf, event
+}
+
+// receive relays what the SIP peer sends on a port of a stream to the
+// browser, until the stream is closed
+func (p *Plugin) receive(s *session, st *stream, isRTCP bool) {
+	conn := st.conn
+	if isRTCP {
+		conn = st.rtcpConn
+	}
+	video := st.kind == sdp.Video
+	buf := make([]byte, bufferSize)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		packet, event := st.received(buf[:n], isRTCP)
+		if packet == nil {
+			continue
+		}
+		if isRTCP {
+			p.gateway.RelayRTCP(s.ps, &plugins.RTCPPacket{Video: video, Mindex: -1, Buffer: packet})
+			continue
+		}
+		if event != nil {
+			log.Infof("[%s] Got DTMF %s (%d ms)", Package, event.signal, event.duration)
+			p.event(s, "", map[string]interface{}{"event": "dtmf", "signal": event.signal, "duration": event.duration}, nil)
+		}
+		p.gateway.RelayRTP(s.ps, &plugins.RTPPacket{Video: video, Mindex: -1, Buffer: packet})
+	}
+}
+
+// stream returns the stream of the call of a session the browser media
+// goes to, if any
+func (s *session) stream(video bool) *stream {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c := s.call
+	if c == nil || (c.state != stateActive && !c.answered) {
+		return nil
+	}
+	kind := sdp.Audio
+	if video {
+		kind = sdp.Video
+	}
+	for _, st := range c.streams {
+		if st.kind == kind {
+			return st
+		}
+	}
+	return nil
+}
+
+// sipSDP builds our SDP for the SIP side, a new version of it each time
+func (c *call) sipSDP(ip string) *sdp.SDP {
+	c.version++
+	addr := "IN IP4 " + ip
+	if strings.Contains(ip, ":") {
+		addr = "IN IP6 " + ip
+	}
+	desc := &sdp.SDP{
+		Origin:     sdp.Origin{Username: "-", SessionID: c.sessionID, SessionVersion: c.version, Address: addr},
+		Name:       "Janus",
+		Timing:     "0 0",
+		Connection: addr,
+	}
+	for i, m := range c.mlines {
+		var st *stream
+		for _, candidate := range c.streams {
+			if candidate.index == i {
+				st = candidate
+			}
+		}
+		if st != nil {
+			desc.MLines = append(desc.MLines, st.mline())
+			continue
+		}
+		rejected := &sdp.MLine{Type: m.Type, TypeName: m.TypeName, Port: 0, Proto: m.Proto, Direction: sdp.Inactive}
+		if len(m.Formats) > 0 {
+			rejected.Formats = m.Formats[:1]
+		}
+		desc.MLines = append(desc.MLines, rejected)
+	}
+	return desc
+}
+
+// closeStreams closes the streams of a call, but for the ones to keep
+func (c *call) closeStreams(keep []*stream) {
+	for _, st := range c.streams {
+		kept := false
+		for _, k := range keep {
+			kept = kept || k == st
+		}
+		if !kept {
+			st.close()
+		}
+	}
+	c.streams = keep
+}
+
+// offerSIP prepares the streams of an outgoing call from the offer of the
+// browser, and returns the offer for the SIP side: the first audio and video
+// m-lines, with the codecs we can relay
+func (c *call) offerSIP(web *sdp.SDP, host, ip string) (*sdp.SDP, error) {
+	for _, kind := range []sdp.MediaType{sdp.Audio, sdp.Video} {
+		m := web.MLine(kind)
+		if m == nil || m.Port == 0 {
+			continue
+		}
+		codecs := codecsOf(m)
+		if len(codecs) == 0 || (len(codecs) == 1 && codecs[0].name == sdp.DTMF) {
+			continue
+		}
+		st, err := newStream(kind, host)
+		if err != nil {
+			c.closeStreams(nil)
+			return nil, err
+		}
+		st.index, st.codecs, st.dir = len(c.streams), codecs, m.Direction
+		st.proto = "RTP/AVP"
+		if c.srtp == "sdes_mandatory" {
+			st.proto = "RTP/SAVP"
+		}
+		if c.srtp != "" {
+			st.newKey()
+		}
+		c.streams = append(c.streams, st)
+		c.mlines = append(c.mlines, st.mline())
+	}
+	if len(c.streams) == 0 {
+		return nil, errors.New("no audio or video to offer")
+	}
+	c.offer = web
+	return c.sipSDP(ip), nil
+}
+
+// answerWebRTC takes the SIP answer to an outgoing call, and returns the
+// answer for the browser
+func (c *call) answerWebRTC(desc *sdp.SDP) (*sdp.SDP, error) {
+	opts := sdp.AnswerOptions{NoAudio: true, NoVideo: true, NoData: true}
+	var kept []*stream
+	for _, st := range c.streams {
+		if st.index >= len(desc.MLines) {
+			continue
+		}
+		m, web := desc.MLines[st.index], c.offer.MLine(st.kind)
+		codec := m.FirstCodec()
+		if m.Port == 0 || m.Type != st.kind || codec == "" || web.CodecPT(codec) < 0 {
+			continue
+		}
+		st.mutex.Lock()
+		_, key := cryptoOf(m)
+		if key == nil {
+			if c.srtp == "sdes_mandatory" {
+				st.mutex.Unlock()
+				return nil, fail(ErrorTooStrict, "SRTP is mandatory, but the answer has no usable key")
+			}
+			// plain RTP, then
+			st.key = nil
+		}
+		st.codec = codec
+		st.mapPTs(web.CodecPT(codec), m.CodecPT(codec), web.CodecPT(sdp.DTMF), m.CodecPT(sdp.DTMF))
+		st.negotiated(m)
+		err := st.setRemote(desc, m)
+		st.mutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		switch st.kind {
+		case sdp.Audio:
+			opts.NoAudio, opts.AudioCodec, opts.AudioDirection = false, codec, m.Direction
+			opts.AudioDTMF = st.dtmf >= 0
+		case sdp.Video:
+			opts.NoVideo, opts.VideoCodec, opts.VideoDirection = false, codec, m.Direction
+		}
+		kept = append(kept, st)
+	}
+	if len(kept) == 0 {
+		return nil, errors.New("no audio or video accepted")
+	}
+	c.closeStreams(kept)
+	return sdp.GenerateAnswer(c.offer, opts), nil
+}
+
+// offerWebRTC prepares the streams of an incoming call from the SIP offer,
+// and returns the offer for the browser: the first audio and video m-lines
+// are taken, with the codec the caller likes best
+func (c *call) offerWebRTC(desc *sdp.SDP, host string) (*sdp.SDP, error) {
+	opts := sdp.OfferOptions{}
+	for i, m := range desc.MLines {
+		c.mlines = append(c.mlines, m)
+		if m.Port == 0 || (m.Type != sdp.Audio && m.Type != sdp.Video) || !strings.HasPrefix(m.Proto, "RTP/") {
+			continue
+		}
+		if (m.Type == sdp.Audio && opts.AudioCodec != "") || (m.Type == sdp.Video && opts.VideoCodec != "") {
+			continue
+		}
+		codec := m.FirstCodec()
+		tag, key := cryptoOf(m)
+		secure := strings.Contains(m.Proto, "SAVP")
+		if codec == "" || (secure && key == nil) {
+			continue
+		}
+		st, err := newStream(m.Type, host)
+		if err != nil {
+			c.closeStreams(nil)
+			return nil, err
+		}
+		st.index, st.proto, st.codec = i, m.Proto, codec
+		st.pt = m.CodecPT(codec)
+		if m.Type == sdp.Audio {
+			st.dtmf = m.CodecPT(sdp.DTMF)
+		}
+		st.negotiated(m)
+		if key != nil {
+			st.newKey()
+			st.tag = tag
+			c.srtp = "sdes_optional"
+			if secure {
+				c.srtp = "sdes_mandatory"
+			}
+		}
+		if err := st.setRemote(desc, m); err != nil {
+			st.close()
+			c.closeStreams(nil)
+			return nil, err
+		}
+		c.streams = append(c.streams, st)
+		switch m.Type {
+		case sdp.Audio:
+			opts.AudioCodec, opts.AudioPT, opts.AudioFmtp, opts.AudioDirection = codec, st.pt, m.Fmtp(st.pt), m.Direction
+			opts.AudioDTMF = st.dtmf >= 0
+		case sdp.Video:
+			opts.VideoCodec, opts.VideoPT, opts.VideoFmtp, opts.VideoDirection = codec, st.pt, m.Fmtp(st.pt), m.Direction
+		}
+	}
+	if len(c.streams) == 0 {
+		return nil, errors.New("no audio or video we can relay")
+	}
+	c.offer = sdp.GenerateOffer(opts)
+	return c.offer, nil
+}
+
+// answerSIP takes the answer of the browser to an incoming call, and
+// returns the answer for the SIP side
+func (c *call) answerSIP(web *sdp.SDP, ip string) (*sdp.SDP, error) {
+	var kept []*stream
+	for _, st := range c.streams {
+		m, offered := web.MLine(st.kind), c.offer.MLine(st.kind)
+		if m == nil || m.Port == 0 || m.CodecPT(st.codec) < 0 {
+			continue
+		}
+		st.mutex.Lock()
+		st.mapPTs(m.CodecPT(st.codec), st.pt, m.CodecPT(sdp.DTMF), st.dtmf)
+		if offered.CodecPT(sdp.DTMF) < 0 {
+			st.dtmf = -1
+		}
+		st.dir = m.Direction
+		st.mutex.Unlock()
+		kept = append(kept, st)
+	}
+	if len(kept) == 0 {
+		return nil, errors.New("no audio or video accepted")
+	}
+	c.closeStreams(kept)
+	return c.sipSDP(ip), nil
+}
+
+// updated takes a new SDP of the SIP peer (a re-INVITE, or the answer to
+// ours), and tells whether it puts us on hold
+func (c *call) updated(desc *sdp.SDP) bool {
+	hold := true
+	for _, st := range c.streams {
+		st.mutex.Lock()
+		if st.index < len(desc.MLines) {
+			m := desc.MLines[st.index]
+			if err := st.setRemote(desc, m); err != nil {
+				log.Warnf("[%s] Error updating the SRTP keys: %v", Package, err)
+			}
+			if !c.hold {
+				// we do what the SIP peer lets us do
+				st.dir = m.Direction.Reverse()
+			}
+			if st.remote != nil && m.Direction.Receives() {
+				hold = false
+			}
+		}
+		st.mutex.Unlock()
+	}
+	return hold
+}
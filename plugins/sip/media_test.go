@@ -0,0 +1,43 @@
+package sip
+
+import (
+	"testing"
+
+	"github.com/xroger88/go-janus/sdp"
+)
+
+func TestSetRemote(t *testing.T) {
+	tests := []struct {
+		name       string
+		lines      string // what the audio m-line has after the rtpmap
+		remote     string
+		remoteRTCP string
+	}{
+		{"rtp", "", "10.0.0.1:4000", "10.0.0.1:4001"},
+		{"rtcp port", "a=rtcp:5000\r\n", "10.0.0.1:4000", "10.0.0.1:5000"},
+		{"rtcp address", "a=rtcp:5000 IN IP4 10.0.0.2\r\n", "10.0.0.1:4000", "10.0.0.2:5000"},
+		{"empty rtcp", "a=rtcp:\r\n", "10.0.0.1:4000", "10.0.0.1:4001"},
+		{"bad rtcp port", "a=rtcp:x\r\n", "10.0.0.1:4000", "10.0.0.1:4001"},
+		{"media connection", "c=IN IP4 10.0.0.3\r\n", "10.0.0.3:4000", "10.0.0.3:4001"},
+		{"hold", "c=IN IP4 0.0.0.0\r\n", "<nil>", "<nil>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			desc, err := sdp.Parse("v=0\r\no=- 1 1 IN IP4 10.0.0.1\r\ns=-\r\nc=IN IP4 10.0.0.1\r\nt=0 0\r\n" +
+				"m=audio 4000 RTP/AVP 0\r\n" + test.lines + "a=rtpmap:0 PCMU/8000\r\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := &stream{kind: sdp.Audio}
+			if err := st.setRemote(desc, desc.MLine(sdp.Audio)); err != nil {
+				t.Fatal(err)
+			}
+			if got := st.remote.String(); got != test.remote {
+				t.Errorf("got RTP to %s, want %s", got, test.remote)
+			}
+			if got := st.remoteRTCP.String(); got != test.remoteRTCP {
+				t.Errorf("got RTCP to %s, want %s", got, test.remoteRTCP)
+			}
+		})
+	}
+}
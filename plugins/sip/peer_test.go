@@ -0,0 +1,293 @@
+package sip
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/sdp"
+	"github.com/xroger88/go-janus/sip"
+)
+
+// peer is an in-process SIP registrar and user agent for the plugin to talk
+// to, over UDP or TCP: it challenges REGISTERs with a digest, answers
+// INVITEs with an SDP of its own and passes every request it gets to the
+// test, in order
+type peer struct {
+	stack    *sip.Stack
+	realm    string
+	nonce    string
+	tag      string
+	users    map[string]string // passwords, by user
+	rtp      *net.UDPConn
+	requests chan *sip.Message
+
+	mutex    sync.Mutex
+	bindings map[string]*sip.URI // registered contacts, by user
+	dialog   *peerDialog
+}
+
+// peerDialog is the call of the peer, for the requests it sends in it
+type peerDialog struct {
+	callID        string
+	local, remote string // the From and To of its requests
+	target        *sip.URI
+	transport     string
+	cseq          int
+}
+
+func startPeer(t *testing.T, users map[string]string) *peer {
+	p := &peer{
+		realm:    "test",
+		nonce:    sip.NewCallID(),
+		tag:      sip.NewTag(),
+		users:    users,
+		requests: make(chan *sip.Message, 100),
+		bindings: make(map[string]*sip.URI),
+	}
+	var err error
+	if p.rtp, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.rtp.Close() })
+	// a request may come before Listen returns, e.g. late from an earlier
+	// test that had the port
+	ready := make(chan struct{})
+	if p.stack, err = sip.Listen("127.0.0.1", 0, func(req *sip.Message) {
+		<-ready
+		p.handle(req)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	close(ready)
+	t.Cleanup(p.stack.Close)
+	return p
+}
+
+// uri returns the URI of a user of the peer
+func (p *peer) uri(user, transport string) string {
+	uri := &sip.URI{Scheme: "sip", User: user, Host: "127.0.0.1", Port: p.stack.Port()}
+	if transport == "TCP" {
+		uri.Params = ";transport=tcp"
+	}
+	return uri.String()
+}
+
+func (p *peer) handle(req *sip.Message) {
+	switch req.Method {
+	case "REGISTER":
+		p.register(req)
+	case "INVITE":
+		p.invite(req)
+	case "ACK":
+	case "REFER":
+		p.stack.Respond(req, sip.NewResponse(req, 202, "", p.tag))
+	case "BYE", "INFO", "NOTIFY":
+		p.stack.Respond(req, sip.NewResponse(req, 200, "", p.tag))
+	default:
+		p.stack.Respond(req, sip.NewResponse(req, 501, "", p.tag))
+	}
+	p.requests <- req
+}
+
+// register challenges REGISTERs without valid credentials, and binds the
+// contact of the others
+func (p *peer) register(req *sip.Message) {
+	to, err := sip.ParseAddress(req.Get("To"))
+	if err != nil {
+		p.stack.Respond(req, sip.NewResponse(req, 400, "", p.tag))
+		return
+	}
+	c, err := sip.ParseCredentials(req.Get("Authorization"))
+	if err != nil || c.Nonce != p.nonce {
+		res := sip.NewResponse(req, 401, "", p.tag)
+		res.Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", nonce="%s", qop="auth", algorithm=MD5`, p.realm, p.nonce))
+		p.stack.Respond(req, res)
+		return
+	}
+	password, known := p.users[to.URI.User]
+	if !known || c.Username != to.URI.User || c.Realm != p.realm || !c.Check(req.Method, password) {
+		p.stack.Respond(req, sip.NewResponse(req, 403, "", p.tag))
+		return
+	}
+	contact, err := sip.ParseAddress(req.Get("Contact"))
+	if err != nil {
+		p.stack.Respond(req, sip.NewResponse(req, 400, "", p.tag))
+		return
+	}
+	res := sip.NewResponse(req, 200, "", p.tag)
+	p.mutex.Lock()
+	if req.Get("Expires") == "0" {
+		delete(p.bindings, to.URI.User)
+	} else {
+		p.bindings[to.URI.User] = contact.URI
+		res.Add("Contact", contact.String()+";expires="+req.Get("Expires"))
+	}
+	p.mutex.Unlock()
+	p.stack.Respond(req, res)
+}
+
+// binding returns the contact a user registered, if any
+func (p *peer) binding(user string) *sip.URI {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.bindings[user]
+}
+
+// invite rings and answers a new call, and answers re-INVITEs straight away
+func (p *peer) invite(req *sip.Message) {
+	offer, err := sdp.Parse(string(req.Body))
+	to, err2 := sip.ParseAddress(req.Get("To"))
+	contact, err3 := sip.ParseAddress(req.Get("Contact"))
+	if err != nil || err2 != nil || err3 != nil {
+		p.stack.Respond(req, sip.NewResponse(req, 488, "", p.tag))
+		return
+	}
+	p.mutex.Lock()
+	if to.Tag() == "" {
+		p.dialog = &peerDialog{
+			callID:    req.CallID(),
+			local:     req.Get("To") + ";tag=" + p.tag,
+			remote:    req.Get("From"),
+			transport: req.Transport,
+		}
+	}
+	p.dialog.target = contact.URI
+	p.mutex.Unlock()
+	if to.Tag() == "" {
+		p.stack.Respond(req, sip.NewResponse(req, 180, "", p.tag))
+	}
+	res := sip.NewResponse(req, 200, "", p.tag)
+	res.Add("Contact", "<"+p.uri(to.URI.User, req.Transport)+">")
+	res.Add("Content-Type", "application/sdp")
+	res.Body = []byte(p.sdp(offer))
+	p.stack.Respond(req, res)
+}
+
+// sdp returns the SDP of the peer: PCMU and telephone-event, doing what
+// the offer lets it do
+func (p *peer) sdp(offer *sdp.SDP) string {
+	dir := sdp.SendRecv
+	if offer != nil {
+		if m := offer.MLine(sdp.Audio); m != nil {
+			dir = m.Direction.Reverse()
+		}
+	}
+	return fmt.Sprintf("v=0\r\no=peer 1 1 IN IP4 127.0.0.1\r\ns=-\r\nc=IN IP4 127.0.0.1\r\nt=0 0\r\n"+
+		"m=audio %d RTP/AVP 0 101\r\na=rtpmap:0 PCMU/8000\r\na=rtpmap:101 telephone-event/8000\r\na=%s\r\n",
+		p.rtp.LocalAddr().(*net.UDPAddr).Port, dir)
+}
+
+// call calls a registered user, and returns the final response, once acked
+func (p *peer) call(t *testing.T, user, transport string) <-chan *sip.Message {
+	t.Helper()
+	target := p.binding(user)
+	if target == nil {
+		t.Fatalf("%s isn't registered", user)
+	}
+	d := &peerDialog{
+		callID:    sip.NewCallID(),
+		local:     "<" + p.uri("bob", "") + ">;tag=" + p.tag,
+		remote:    "<" + p.uri(user, "") + ">",
+		target:    target,
+		transport: transport,
+		cseq:      1,
+	}
+	req := &sip.Message{Method: "INVITE", URI: target.String()}
+	req.Add("Max-Forwards", "70")
+	req.Add("From", d.local)
+	req.Add("To", d.remote)
+	req.Add("Call-ID", d.callID)
+	req.Add("CSeq", "1 INVITE")
+	req.Add("Contact", "<"+p.uri("bob", transport)+">")
+	req.Add("Content-Type", "application/sdp")
+	req.Body = []byte(p.sdp(nil))
+	p.mutex.Lock()
+	p.dialog = d
+	p.mutex.Unlock()
+	responses, err := p.stack.Request(req, target.Address(), transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	final := make(chan *sip.Message, 1)
+	go func() {
+		var res *sip.Message
+		for res = range responses {
+		}
+		if res.StatusCode < 300 {
+			p.mutex.Lock()
+			d.remote = res.Get("To")
+			if contact, err := sip.ParseAddress(res.Get("Contact")); err == nil {
+				d.target = contact.URI
+			}
+			ack := &sip.Message{Method: "ACK", URI: d.target.String()}
+			ack.Add("Max-Forwards", "70")
+			ack.Add("From", d.local)
+			ack.Add("To", d.remote)
+			ack.Add("Call-ID", d.callID)
+			ack.Add("CSeq", "1 ACK")
+			p.mutex.Unlock()
+			p.stack.Ack(ack, d.target.Address(), transport)
+		}
+		final <- res
+	}()
+	return final
+}
+
+// request sends a request in the call, and returns the final response
+func (p *peer) request(t *testing.T, method, body string, headers ...string) *sip.Message {
+	t.Helper()
+	p.mutex.Lock()
+	d := p.dialog
+	if d == nil {
+		p.mutex.Unlock()
+		t.Fatalf("no call to send a %s in", method)
+	}
+	d.cseq++
+	req := &sip.Message{Method: method, URI: d.target.String(), Body: []byte(body)}
+	req.Add("Max-Forwards", "70")
+	req.Add("From", d.local)
+	req.Add("To", d.remote)
+	req.Add("Call-ID", d.callID)
+	req.Add("CSeq", strconv.Itoa(d.cseq)+" "+method)
+	dest, transport := d.target.Address(), d.transport
+	p.mutex.Unlock()
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Add(headers[i], headers[i+1])
+	}
+	responses, err := p.stack.Request(req, dest, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res *sip.Message
+	for res = range responses {
+	}
+	if method == "INVITE" && res.StatusCode < 300 {
+		ack := &sip.Message{Method: "ACK", URI: req.URI}
+		for _, name := range []string{"Max-Forwards", "From", "To", "Call-ID"} {
+			ack.Add(name, req.Get(name))
+		}
+		n, _ := req.CSeq()
+		ack.Add("CSeq", strconv.Itoa(n)+" ACK")
+		p.stack.Ack(ack, dest, transport)
+	}
+	return res
+}
+
+// next returns the next request the peer got, which should be a method
+func (p *peer) next(t *testing.T, method string) *sip.Message {
+	t.Helper()
+	select {
+	case req := <-p.requests:
+		if req.Method != method {
+			t.Fatalf("got a %s, want a %s", req.Method, method)
+		}
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s", method)
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package sip
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/sip"
+)
+
+// methods we handle, for Allow headers
+const allow = "INVITE, ACK, CANCEL, BYE, OPTIONS, INFO, REFER, NOTIFY"
+
+// account is what a session registered with
+type account struct {
+	identity *sip.Address
+	authuser string
+	secret   string
+	// proxy is where out of dialog requests go: the proxy if one was given,
+	// the domain of the identity otherwise
+	proxy *sip.URI
+	// outbound tells whether a proxy was given, in which case in dialog
+	// requests go through it as well
+	outbound  bool
+	transport string
+	ttl       int
+	guest     bool
+}
+
+// registerRequest starts the SIP stack of the session, if needed, and
+// registers (unless a guest), the result coming as an event
+func (p *Plugin) registerRequest(s *session, body json.RawMessage) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Username    *string `json:"username"`
+		Secret      *string `json:"secret"`
+		Authuser    *string `json:"authuser"`
+		DisplayName *string `json:"display_name"`
+		Proxy       *string `json:"proxy"`
+		RegisterTTL *int    `json:"register_ttl"`
+		Type        *string `json:"type"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Username == nil {
+		return nil, nil, missing("username")
+	}
+	identity, err := sip.ParseURI(*r.Username)
+	if err != nil {
+		return nil, nil, fail(ErrorInvalidAddress, "Invalid user address %s: %v", *r.Username, err)
+	}
+	a := &account{identity: &sip.Address{URI: identity}, proxy: &sip.URI{Scheme: identity.Scheme, Host: identity.Host, Port: identity.Port}, ttl: p.config.General.Register_ttl}
+	if r.Type != nil {
+		switch *r.Type {
+		case "guest":
+			a.guest = true
+		default:
+			return nil, nil, invalid("type", "only guest is supported")
+		}
+	}
+	if identity.User == "" && !a.guest {
+		return nil, nil, fail(ErrorInvalidAddress, "Invalid user address %s (no user)", *r.Username)
+	}
+	if r.Secret != nil {
+		a.secret = *r.Secret
+	} else if !a.guest {
+		return nil, nil, missing("secret")
+	}
+	a.authuser = identity.User
+	if r.Authuser != nil {
+		a.authuser = *r.Authuser
+	}
+	if r.DisplayName != nil {
+		a.identity.Display = *r.DisplayName
+	}
+	if r.Proxy != nil && *r.Proxy != "" {
+		if a.proxy, err = sip.ParseURI(*r.Proxy); err != nil {
+			return nil, nil, fail(ErrorInvalidAddress, "Invalid proxy address %s: %v", *r.Proxy, err)
+		}
+		a.outbound = true
+	}
+	if r.RegisterTTL != nil {
+		if *r.RegisterTTL <= 0 {
+			return nil, nil, invalid("register_ttl", "should be positive")
+		}
+		a.ttl = *r.RegisterTTL
+	}
+	a.transport = "UDP"
+	if t := a.proxy.Param("transport"); t != "" {
+		a.transport = strings.ToUpper(t)
+	} else if t := identity.Param("transport"); t != "" {
+		a.transport = strings.ToUpper(t)
+	}
+	if a.transport != "UDP" && a.transport != "TCP" {
+		return nil, nil, fail(ErrorInvalidAddress, "Unsupported transport %s", a.transport)
+	}
+
+	s.mutex.Lock()
+	if s.account != nil {
+		s.mutex.Unlock()
+		return nil, nil, fail(ErrorAlreadyRegistered, "Already registered (%s)", s.account.identity.URI)
+	}
+	if s.stack == nil {
+		stack, err := sip.Listen(p.config.General.Local_ip, 0, func(req *sip.Message) { p.incoming(s, req) })
+		if err != nil {
+			s.mutex.Unlock()
+			return nil, nil, fail(ErrorIO, "Error starting the SIP stack: %v", err)
+		}
+		s.stack = stack
+		s.ip = p.config.General.Sdp_ip
+		if s.ip == "" {
+			s.ip = stack.LocalIP(a.proxy.Address())
+		}
+		log.Infof("[%s] SIP stack listening on port %d", Package, stack.Port())
+	}
+	s.account = a
+	s.regCallID, s.regTag, s.regCSeq = sip.NewCallID(), sip.NewTag(), 0
+	if a.guest {
+		s.registered = true
+		s.mutex.Unlock()
+		log.Infof("[%s] %s is a guest, not registering", Package, identity)
+		result := map[string]interface{}{"event": "registered", "username": identity.String(), "register_sent": false}
+		p.notify(s.ps, result)
+		return result, nil, nil
+	}
+	s.mutex.Unlock()
+
+	log.Infof("[%s] Registering %s via %s", Package, identity, a.proxy)
+	go func() {
+		res := p.register(s, a, a.ttl)
+		s.mutex.Lock()
+		if s.account != a {
+			s.mutex.Unlock()
+			return
+		}
+		if res.StatusCode >= 300 {
+			s.account = nil
+			s.mutex.Unlock()
+			log.Warnf("[%s] Registration of %s failed: %d %s", Package, identity, res.StatusCode, res.Reason)
+			p.event(s, "", map[string]interface{}{"event": "registration_failed", "code": res.StatusCode, "reason": res.Reason}, nil)
+			return
+		}
+		s.mutex.Unlock()
+		p.event(s, "", map[string]interface{}{"event": "registered", "username": identity.String(), "register_sent": true}, nil)
+	}()
+	return map[string]interface{}{"event": "registering"}, nil, nil
+}
+
+// unregisterRequest unregisters, the result coming as an event
+func (p *Plugin) unregisterRequest(s *session) (map[string]interface{}, *plugins.JSEP, error) {
+	s.mutex.Lock()
+	a := s.account
+	if a == nil || !s.registered {
+		s.mutex.Unlock()
+		return nil, nil, fail(ErrorWrongState, "Wrong state (not registered)")
+	}
+	s.account, s.registered = nil, false
+	if s.refresh != nil {
+		s.refresh.Stop()
+		s.refresh = nil
+	}
+	s.mutex.Unlock()
+	username := a.identity.URI.String()
+	if a.guest {
+		result := map[string]interface{}{"event": "unregistered", "username": username, "register_sent": false}
+		p.notify(s.ps, result)
+		return result, nil, nil
+	}
+	go func() {
+		res := p.register(s, a, 0)
+		if res.StatusCode >= 300 {
+			log.Warnf("[%s] Unregistration of %s failed: %d %s", Package, username, res.StatusCode, res.Reason)
+		}
+		p.event(s, "", map[string]interface{}{"event": "unregistered", "username": username, "register_sent": true}, nil)
+	}()
+	return map[string]interface{}{"event": "unregistering"}, nil, nil
+}
+
+// register sends a REGISTER for an account (an unregistration if expires
+// is 0), and returns the final response; a success schedules a refresh
+func (p *Plugin) register(s *session, a *account, expires int) *sip.Message {
+	s.mutex.Lock()
+	s.regCSeq++
+	req := &sip.Message{Method: "REGISTER", URI: (&sip.URI{Scheme: a.identity.URI.Scheme, Host: a.identity.URI.Host, Port: a.identity.URI.Port}).String()}
+	req.Add("Max-Forwards", "70")
+	req.Add("From", a.identity.String()+";tag="+s.regTag)
+	req.Add("To", a.identity.String())
+	req.Add("Call-ID", s.regCallID)
+	req.Add("CSeq", fmt.Sprintf("%d REGISTER", s.regCSeq))
+	req.Add("Contact", s.contact(a))
+	req.Add("Expires", strconv.Itoa(expires))
+	req.Add("Allow", allow)
+	s.mutex.Unlock()
+
+	next := func() int {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.regCSeq++
+		return s.regCSeq
+	}
+	_, res := p.request(s, a, req, a.proxy.Address(), a.transport, next, nil, nil)
+	if res.StatusCode >= 300 || expires == 0 {
+		return res
+	}
+	// the registrar may want another expiry
+	granted := expires
+	for _, contact := range res.Values("Contact") {
+		if v := sip.Param(contact, "expires"); v != "" && strings.Contains(contact, ":"+strconv.Itoa(s.stack.Port())) {
+			granted, _ = strconv.Atoi(v)
+		}
+	}
+	if granted == expires {
+		if v, err := strconv.Atoi(res.Get("Expires")); err == nil && v > 0 {
+			granted = v
+		}
+	}
+	if granted <= 0 {
+		granted = expires
+	}
+	s.mutex.Lock()
+	if s.account == a {
+		s.registered = true
+		if s.refresh != nil {
+			s.refresh.Stop()
+		}
+		// refresh a bit before the registration expires
+		s.refresh = time.AfterFunc(time.Duration(granted)*time.Second*9/10, func() { p.refresh(s, a) })
+	}
+	s.mutex.Unlock()
+	return res
+}
+
+// refresh registers again before the registration expires
+func (p *Plugin) refresh(s *session, a *account) {
+	s.mutex.Lock()
+	current := s.account == a && s.registered
+	s.mutex.Unlock()
+	if !current {
+		return
+	}
+	res := p.register(s, a, a.ttl)
+	if res.StatusCode < 300 {
+		return
+	}
+	s.mutex.Lock()
+	if s.account != a {
+		s.mutex.Unlock()
+		return
+	}
+	s.account, s.registered = nil, false
+	s.mutex.Unlock()
+	log.Warnf("[%s] Refreshing the registration of %s failed: %d %s", Package, a.identity.URI, res.StatusCode, res.Reason)
+	p.event(s, "", map[string]interface{}{"event": "registration_failed", "code": res.StatusCode, "reason": res.Reason}, nil)
+}
+
+// contact returns the Contact of the session; the caller holds its mutex
+func (s *session) contact(a *account) string {
+	uri := &sip.URI{Scheme: "sip", User: a.identity.URI.User, Host: s.ip, Port: s.stack.Port()}
+	if a.transport == "TCP" {
+		uri.Params = ";transport=tcp"
+	}
+	return "<" + uri.String() + ">"
+}
+
+// request sends a request until it gets a final response, answering digest
+// challenges with the credentials of the account, and returns the request
+// that got the final response along with it (a 408 or 503 made up by the
+// stack if there was none). next numbers the CSeq of the requests sent again,
+// sent gets each of them (e.g. to cancel it) and progress the provisional
+// responses, both if not nil.
+func (p *Plugin) request(s *session, a *account, req *sip.Message, dest, transport string, next func() int, sent, progress func(*sip.Message)) (*sip.Message, *sip.Message) {
+	s.mutex.Lock()
+	stack := s.stack
+	s.mutex.Unlock()
+	if p.config.General.User_agent != "" {
+		req.Set("User-Agent", p.config.General.User_agent)
+	}
+	challenged := false
+	for {
+		if sent != nil {
+			sent(req)
+		}
+		responses, err := stack.Request(req, dest, transport)
+		if err != nil {
+			log.Warnf("[%s] Error sending %s to %s: %v", Package, req.Method, dest, err)
+			return req, sip.NewResponse(req, 503, err.Error(), "")
+		}
+		var res *sip.Message
+		for res = range responses {
+			if res.StatusCode < 200 && progress != nil {
+				progress(res)
+			}
+		}
+		if (res.StatusCode != 401 && res.StatusCode != 407) || challenged || a == nil || a.secret == "" {
+			return req, res
+		}
+		challenge, authorization := "WWW-Authenticate", "Authorization"
+		if res.StatusCode == 407 {
+			challenge, authorization = "Proxy-Authenticate", "Proxy-Authorization"
+		}
+		c, err := sip.ParseChallenge(res.Get(challenge))
+		if err != nil {
+			log.Warnf("[%s] Can't answer the challenge to %s: %v", Package, req.Method, err)
+			return req, res
+		}
+		challenged = true
+		retry := &sip.Message{Method: req.Method, URI: req.URI, Body: req.Body}
+		for i, h := range req.Headers {
+			switch strings.ToLower(h.Name) {
+			case "via":
+				if i == 0 {
+					// the stack adds a new one
+					continue
+				}
+			case "cseq":
+				h.Value = fmt.Sprintf("%d %s", next(), req.Method)
+			case "authorization", "proxy-authorization":
+				continue
+			}
+			retry.Headers = append(retry.Headers, h)
+		}
+		retry.Add(authorization, c.Authorization(req.Method, req.URI, a.authuser, a.secret, 1))
+		req = retry
+	}
+}
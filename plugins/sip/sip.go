@@ -0,0 +1,467 @@
+package sip
+
+// The SIP plugin is a gateway between WebRTC and SIP: each handle gets a SIP
+// user agent of its own, which registers to a registrar (or not, for guests)
+// and places or gets calls on behalf of the browser. The SDP of the browser is
+// turned into a plain RTP/AVP one (or RTP/SAVP, with SDES keys) for the SIP
+// side and back, and the media goes through the gateway. Requests and events
+// are the ones of janus.plugin.sip of the original Janus, so the siptest demo
+// of janus.js works:
+//
+//	{"request": "register", "username": "sip:alice@example.com",
+//	 "secret": "...", "authuser": "...", "display_name": "...",
+//	 "proxy": "sip:proxy.example.com;transport=tcp", "register_ttl": 3600,
+//	 "type": "guest"}
+//	{"request": "unregister"}
+//	{"request": "call", "uri": "sip:bob@example.com",
+//	 "srtp": "sdes_optional" or "sdes_mandatory", "refer_id": 1} (with an offer)
+//	{"request": "accept"} (with an answer)
+//	{"request": "decline", "code": 486}
+//	{"request": "hangup"}
+//	{"request": "hold", "direction": "sendonly"}
+//	{"request": "unhold"}
+//	{"request": "dtmf_info", "digit": "1", "duration": 160}
+//	{"request": "transfer", "uri": "sip:carol@example.com"}
+//
+// Calls get events as they progress: calling, ringing, proceeding, progress
+// (early media, with an answer), accepted, incomingcall (with an offer),
+// missed_call, hangup, hold and unhold (when the SIP peer puts us on hold or
+// resumes), info, notify, dtmf (RFC 2833 events the SIP peer sends) and
+// transfer (a REFER, to be followed by a call with its refer_id). DTMF goes to
+// the SIP peer either as RFC 2833 events sent by the browser, which are
+// relayed as they are, or as SIP INFO with dtmf_info.
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/rtcp"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sip"
+)
+
+const Package = "janus.plugin.sip"
+
+// error codes of the events
+const (
+	ErrorNoMessage         = 440
+	ErrorInvalidJSON       = 441
+	ErrorInvalidRequest    = 442
+	ErrorMissingElement    = 443
+	ErrorInvalidElement    = 444
+	ErrorAlreadyRegistered = 445
+	ErrorInvalidAddress    = 446
+	ErrorWrongState        = 447
+	ErrorMissingSDP        = 448
+	ErrorSIPStack          = 449
+	ErrorIO                = 450
+	ErrorTooStrict         = 452
+	ErrorReferError        = 455
+	ErrorUnknown           = 499
+)
+
+// Config is the content of janus.plugin.sip.yaml in the configs folder
+type Config struct {
+	General struct {
+		// Local_ip is the address the SIP stacks and the RTP ports are bound
+		// to (all of them if empty)
+		Local_ip string
+		// Sdp_ip is the address to write in the SDPs and Contacts, if not
+		// the one of the stacks (e.g. behind a NAT)
+		Sdp_ip string
+		// User_agent is the User-Agent of what we send
+		User_agent string
+		// Register_ttl is the expiry asked for in REGISTERs, in seconds
+		Register_ttl int
+		// Events tells whether to notify the event handlers
+		Events bool
+	}
+}
+
+type Plugin struct {
+	config   Config
+	gateway  plugins.Callbacks
+	messages chan *message
+	done     chan struct{}
+}
+
+// message is a request waiting for the handler
+type message struct {
+	ps          *plugins.PluginSession
+	transaction string
+	body        json.RawMessage
+	jsep        *plugins.JSEP
+}
+
+// session is the state of a handle attached to the plugin: a SIP user agent
+type session struct {
+	ps *plugins.PluginSession
+
+	// mutex protects all that follows, but for the atomic flags
+	mutex   sync.Mutex
+	stack   *sip.Stack
+	account *account
+	// ip is the address to tell in Contacts and SDPs
+	ip string
+
+	// registration, with its Call-ID and CSeq kept across refreshes
+	registered bool
+	regCallID  string
+	regTag     string
+	regCSeq    int
+	refresh    *time.Timer
+
+	call *call
+	// transfers are the REFERs we got, by refer_id
+	transfers map[int]*transfer
+	referID   int
+
+	hangingUp int32
+	destroyed int32
+}
+
+// requestError is an error along with the code to tell clients about it
+type requestError struct {
+	code int
+	err  error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func fail(code int, format string, args ...interface{}) error {
+	return &requestError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+func errorCode(err error) int {
+	if e, ok := err.(*requestError); ok {
+		return e.code
+	}
+	return ErrorUnknown
+}
+
+// decode parses the body of a request, with the right error codes
+func decode(body json.RawMessage, out interface{}) error {
+	err := plugins.Decode(body, out)
+	switch {
+	case err == nil:
+		return nil
+	case err == plugins.ErrNoMessage:
+		return &requestError{ErrorNoMessage, err}
+	case err == plugins.ErrInvalidJSON:
+		return &requestError{ErrorInvalidJSON, err}
+	}
+	if e, ok := err.(*plugins.ElementError); ok && e.Missing {
+		return &requestError{ErrorMissingElement, err}
+	}
+	return &requestError{ErrorInvalidElement, err}
+}
+
+func missing(name string) error {
+	return &requestError{ErrorMissingElement, plugins.Missing(name)}
+}
+
+func invalid(name, reason string) error {
+	return &requestError{ErrorInvalidElement, plugins.Invalid(name, reason)}
+}
+
+func init() {
+	plugins.Register(New())
+}
+
+// New returns the SIP plugin, to be started with Init
+func New() *Plugin {
+	return &Plugin{
+		messages: make(chan *message, 100),
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *Plugin) Package() string { return Package }
+func (p *Plugin) Name() string    { return "JANUS SIP plugin" }
+func (p *Plugin) Description() string {
+	return "This is a simple SIP plugin for Janus, allowing WebRTC peers to register at a SIP server and call SIP user agents through a Janus instance."
+}
+func (p *Plugin) Author() string        { return api.Author }
+func (p *Plugin) Version() int          { return api.Version }
+func (p *Plugin) VersionString() string { return api.VersionString }
+
+// Init reads the configuration and starts the message handler
+func (p *Plugin) Init(gateway plugins.Callbacks, configPath string) error {
+	p.config.General.Events = true
+	p.config.General.User_agent = "Janus WebRTC Server SIP Plugin " + api.VersionString
+	p.config.General.Register_ttl = 3600
+	if err := config.LoadComponent(configPath, Package, &p.config); err != nil {
+		return err
+	}
+	if p.config.General.Register_ttl <= 0 {
+		p.config.General.Register_ttl = 3600
+	}
+	p.gateway = gateway
+	go p.handler()
+	return nil
+}
+
+func (p *Plugin) Destroy() {
+	select {
+	case <-p.done:
+		return
+	default:
+		close(p.done)
+	}
+	log.Infof("%s destroyed", Package)
+}
+
+func getSession(ps *plugins.PluginSession) *session {
+	s, _ := ps.Plugin.(*session)
+	if s == nil || atomic.LoadInt32(&s.destroyed) == 1 {
+		return nil
+	}
+	return s
+}
+
+func (p *Plugin) CreateSession(ps *plugins.PluginSession) error {
+	ps.Plugin = &session{ps: ps, transfers: make(map[int]*transfer)}
+	return nil
+}
+
+// DestroySession hangs up the call, if any, unregisters and stops the SIP
+// stack of the session
+func (p *Plugin) DestroySession(ps *plugins.PluginSession) error {
+	s := getSession(ps)
+	if s == nil {
+		return fmt.Errorf("no session associated with this handle")
+	}
+	atomic.StoreInt32(&s.destroyed, 1)
+	var wg sync.WaitGroup
+	if p.hangup(s, &wg) {
+		log.Infof("[%s] Hanging up the call of a destroyed session", Package)
+	}
+	s.mutex.Lock()
+	stack, a := s.stack, s.account
+	registered := s.registered && !a.guest
+	s.account, s.registered = nil, false
+	if s.refresh != nil {
+		s.refresh.Stop()
+	}
+	s.mutex.Unlock()
+	if registered {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.register(s, a, 0)
+		}()
+	}
+	if stack != nil {
+		// the stack goes once the last requests are done
+		go func() {
+			wg.Wait()
+			stack.Close()
+		}()
+	}
+	return nil
+}
+
+func (p *Plugin) QuerySession(ps *plugins.PluginSession) interface{} {
+	s := getSession(ps)
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	info := map[string]interface{}{
+		"registration_status": map[bool]string{true: "registered", false: "unregistered"}[s.registered],
+		"hangingup":           atomic.LoadInt32(&s.hangingUp),
+		"destroyed":           atomic.LoadInt32(&s.destroyed),
+	}
+	if s.account != nil {
+		info["username"] = s.account.identity.URI.String()
+		info["display_name"] = s.account.identity.Display
+		info["proxy"] = s.account.proxy.String()
+		info["transport"] = s.account.transport
+		if s.account.guest {
+			info["registration_status"] = "guest"
+		}
+	}
+	if s.stack != nil {
+		info["sip_port"] = s.stack.Port()
+	}
+	if c := s.call; c != nil {
+		info["call_status"] = c.status()
+		info["call_id"] = c.callID
+		info["callee"] = c.remote.URI.String()
+		info["hold"] = c.hold
+		media := []interface{}{}
+		for _, st := range c.streams {
+			media = append(media, st.describe())
+		}
+		info["media"] = media
+	} else {
+		info["call_status"] = "idle"
+	}
+	return info
+}
+
+// HandleMessage queues the message for the handler, which answers with an event
+func (p *Plugin) HandleMessage(ps *plugins.PluginSession, transaction string, body json.RawMessage, jsep *plugins.JSEP) *plugins.Result {
+	if getSession(ps) == nil {
+		return &plugins.Result{Type: plugins.ResultError, Text: "No session associated with this handle"}
+	}
+	select {
+	case p.messages <- &message{ps: ps, transaction: transaction, body: body, jsep: jsep}:
+	case <-p.done:
+		return &plugins.Result{Type: plugins.ResultError, Text: "Shutting down"}
+	}
+	return &plugins.Result{Type: plugins.ResultOKWait}
+}
+
+func (p *Plugin) SetupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil {
+		return
+	}
+	log.Infof("[%s] WebRTC media is now available", Package)
+	atomic.StoreInt32(&s.hangingUp, 0)
+	// SIP peers don't answer PLIs, but the browser had better start with a
+	// keyframe anyway
+	p.gateway.RelayRTCP(ps, &plugins.RTCPPacket{Video: true, Mindex: -1, Buffer: rtcp.NewPLI()})
+}
+
+// IncomingRTP relays what the browser sends to the SIP peer
+func (p *Plugin) IncomingRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 || !rtp.IsRTP(packet.Buffer) {
+		return
+	}
+	if st := s.stream(packet.Video); st != nil {
+		st.sendRTP(packet.Buffer)
+	}
+}
+
+// IncomingRTCP relays the RTCP of the browser to the SIP peer
+func (p *Plugin) IncomingRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 {
+		return
+	}
+	if st := s.stream(packet.Video); st != nil {
+		st.sendRTCP(packet.Buffer)
+	}
+}
+
+// IncomingData is of no use, SIP calls have no data channels
+func (p *Plugin) IncomingData(ps *plugins.PluginSession, packet *plugins.DataPacket) {}
+
+func (p *Plugin) SlowLink(ps *plugins.PluginSession, uplink, video bool) {
+	log.Warnf("[%s] Getting a lot of NACKs (slow %s) for %s", Package,
+		map[bool]string{true: "uplink", false: "downlink"}[uplink], map[bool]string{true: "video", false: "audio"}[video])
+}
+
+// HangupMedia hangs up the call, as the PeerConnection is gone
+func (p *Plugin) HangupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil || !atomic.CompareAndSwapInt32(&s.hangingUp, 0, 1) {
+		return
+	}
+	log.Infof("[%s] No WebRTC media anymore", Package)
+	p.hangup(s, nil)
+}
+
+func (p *Plugin) notify(ps *plugins.PluginSession, event map[string]interface{}) {
+	if p.config.General.Events && p.gateway.EventsIsEnabled() {
+		p.gateway.NotifyEvent(p, ps, event)
+	}
+}
+
+// event pushes an asynchronous event to the client, and to the event
+// handlers; callID, if any, says which call it's about
+func (p *Plugin) event(s *session, callID string, result map[string]interface{}, jsep *plugins.JSEP) {
+	if atomic.LoadInt32(&s.destroyed) == 1 {
+		return
+	}
+	event := map[string]interface{}{"sip": "event", "result": result}
+	if callID != "" {
+		event["call_id"] = callID
+	}
+	if err := p.gateway.PushEvent(s.ps, p, "", event, jsep); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+	p.notify(s.ps, result)
+}
+
+// handler processes the messages, one at a time
+func (p *Plugin) handler() {
+	for {
+		select {
+		case m := <-p.messages:
+			p.handle(m)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Plugin) handle(m *message) {
+	s := getSession(m.ps)
+	if s == nil {
+		log.Warnf("[%s] No session associated with this handle", Package)
+		return
+	}
+	result, jsep, err := p.process(s, m)
+	var event map[string]interface{}
+	if err != nil {
+		log.Warnf("[%s] %v", Package, err)
+		event = map[string]interface{}{"sip": "event", "error_code": errorCode(err), "error": err.Error()}
+		jsep = nil
+	} else {
+		event = map[string]interface{}{"sip": "event", "result": result}
+		if callID, ok := result["call_id"]; ok {
+			delete(result, "call_id")
+			event["call_id"] = callID
+		}
+	}
+	if err := p.gateway.PushEvent(m.ps, p, m.transaction, event, jsep); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+}
+
+// process handles a request, and returns the result of the event along
+// with a JSEP for the user, if any
+func (p *Plugin) process(s *session, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Request *string `json:"request"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Request == nil {
+		return nil, nil, missing("request")
+	}
+	switch *r.Request {
+	case "register":
+		return p.registerRequest(s, m.body)
+	case "unregister":
+		return p.unregisterRequest(s)
+	case "call":
+		return p.callRequest(s, m.body, m.jsep)
+	case "accept":
+		return p.accept(s, m.jsep)
+	case "decline":
+		return p.decline(s, m.body)
+	case "hangup":
+		return p.hangupRequest(s)
+	case "hold", "unhold":
+		return p.holdRequest(s, m.body, *r.Request == "hold")
+	case "dtmf_info":
+		return p.dtmfInfo(s, m.body)
+	case "transfer":
+		return p.transferRequest(s, m.body)
+	}
+	return nil, nil, fail(ErrorInvalidRequest, "Unknown request (%s)", *r.Request)
+}
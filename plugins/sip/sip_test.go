@@ -0,0 +1,433 @@
+package sip
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sdp"
+)
+
+// testGateway stands in for the core: the events of the plugin and the
+// media it relays go to the test
+type testGateway struct {
+	plugins.Callbacks
+	events chan testEvent
+	rtp    chan []byte
+	// pending are the events the test didn't wait for yet
+	pending []testEvent
+}
+
+type testEvent struct {
+	transaction string
+	data        map[string]interface{}
+	jsep        *plugins.JSEP
+}
+
+func (e testEvent) result() map[string]interface{} {
+	result, _ := e.data["result"].(map[string]interface{})
+	return result
+}
+
+func (g *testGateway) PushEvent(ps *plugins.PluginSession, plugin plugins.Plugin, transaction string, message interface{}, jsep *plugins.JSEP) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	e := testEvent{transaction: transaction, jsep: jsep}
+	if err := json.Unmarshal(data, &e.data); err != nil {
+		return err
+	}
+	g.events <- e
+	return nil
+}
+
+func (g *testGateway) RelayRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	select {
+	case g.rtp <- packet.Buffer:
+	default:
+	}
+}
+
+func (g *testGateway) RelayRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {}
+func (g *testGateway) ClosePC(ps *plugins.PluginSession)                               {}
+func (g *testGateway) EventsIsEnabled() bool                                           { return false }
+
+// wait returns the first event with a result of the given kind, keeping
+// the others for later; an error fails the test
+func (g *testGateway) wait(t *testing.T, kind string) testEvent {
+	t.Helper()
+	for i, e := range g.pending {
+		if e.result()["event"] == kind {
+			g.pending = append(g.pending[:i], g.pending[i+1:]...)
+			return e
+		}
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-g.events:
+			if e.data["error"] != nil {
+				t.Fatalf("got %v waiting for %s", e.data, kind)
+			}
+			if e.result()["event"] == kind {
+				return e
+			}
+			g.pending = append(g.pending, e)
+		case <-timeout:
+			t.Fatalf("no %s event (got %v)", kind, g.pending)
+		}
+	}
+}
+
+// testSession is a handle attached to the plugin
+type testSession struct {
+	p  *Plugin
+	g  *testGateway
+	ps *plugins.PluginSession
+}
+
+func startSession(t *testing.T) *testSession {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, Package+".yaml"), []byte("general:\n  local_ip: 127.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &testSession{p: New(), g: &testGateway{events: make(chan testEvent, 100), rtp: make(chan []byte, 100)}}
+	if err := s.p.Init(s.g, dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.p.Destroy)
+	s.ps = plugins.NewPluginSession(nil)
+	if err := s.p.CreateSession(s.ps); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.p.DestroySession(s.ps) })
+	return s
+}
+
+// send passes a request to the plugin, and returns its result
+func (s *testSession) send(t *testing.T, body string, jsep *plugins.JSEP) map[string]interface{} {
+	t.Helper()
+	if r := s.p.HandleMessage(s.ps, "t", json.RawMessage(body), jsep); r.Type != plugins.ResultOKWait {
+		t.Fatalf("got %v sending %s", r, body)
+	}
+	return s.result(t, body)
+}
+
+// result returns the result of the request sent, keeping the events that
+// come before it for later
+func (s *testSession) result(t *testing.T, body string) map[string]interface{} {
+	t.Helper()
+	for {
+		select {
+		case e := <-s.g.events:
+			if e.transaction == "t" {
+				return e.data
+			}
+			s.g.pending = append(s.g.pending, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no result for %s", body)
+		}
+	}
+}
+
+// register registers alice at the peer, with a secret
+func (s *testSession) register(t *testing.T, pr *peer, transport, secret string) {
+	t.Helper()
+	proxy := "sip:127.0.0.1:" + fmt.Sprint(pr.stack.Port()) + ";transport=" + strings.ToLower(transport)
+	r := s.send(t, `{"request":"register","username":"sip:alice@127.0.0.1","secret":"`+secret+`","proxy":"`+proxy+`"}`, nil)
+	if result, _ := r["result"].(map[string]interface{}); result["event"] != "registering" {
+		t.Fatalf("got %v registering", r)
+	}
+	if req := pr.next(t, "REGISTER"); req.Get("Authorization") != "" {
+		t.Errorf("got credentials before the challenge: %s", req.Get("Authorization"))
+	}
+	if req := pr.next(t, "REGISTER"); !strings.HasPrefix(req.Get("Authorization"), "Digest ") {
+		t.Errorf("got %q answering the challenge", req.Get("Authorization"))
+	}
+}
+
+// the offer and answer of the browser
+const webOffer = "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\na=group:BUNDLE 0\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 0 126\r\nc=IN IP4 0.0.0.0\r\na=mid:0\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\na=rtpmap:126 telephone-event/8000\r\na=sendrecv\r\n"
+
+func webAnswer(t *testing.T, jsep *plugins.JSEP) *plugins.JSEP {
+	t.Helper()
+	if jsep == nil || jsep.Type != "offer" {
+		t.Fatalf("got %v, want an offer", jsep)
+	}
+	offer, err := sdp.Parse(jsep.SDP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := sdp.GenerateAnswer(offer, sdp.AnswerOptions{NoVideo: true, NoData: true, AudioDTMF: true})
+	return &plugins.JSEP{Type: "answer", SDP: answer.String()}
+}
+
+// direction returns the direction of the audio of a SIP SDP
+func direction(t *testing.T, body []byte) sdp.Direction {
+	t.Helper()
+	desc, err := sdp.Parse(string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc.MLine(sdp.Audio).Direction
+}
+
+func rtpPacket(pt uint8, seq uint16, timestamp uint32, payload []byte) []byte {
+	buf := make([]byte, rtp.HeaderSize, rtp.HeaderSize+len(payload))
+	buf[0], buf[1] = 0x80, pt
+	binary.BigEndian.PutUint16(buf[2:], seq)
+	binary.BigEndian.PutUint32(buf[4:], timestamp)
+	binary.BigEndian.PutUint32(buf[8:], 0x1234)
+	return append(buf, payload...)
+}
+
+var transports = []string{"UDP", "TCP"}
+
+func TestRegister(t *testing.T) {
+	for _, transport := range transports {
+		t.Run(transport, func(t *testing.T) {
+			pr := startPeer(t, map[string]string{"alice": "secret"})
+
+			s := startSession(t)
+			s.register(t, pr, transport, "secret")
+			e := s.g.wait(t, "registered")
+			if e.result()["username"] != "sip:alice@127.0.0.1" || e.result()["register_sent"] != true {
+				t.Errorf("got %v", e.data)
+			}
+			contact := pr.binding("alice")
+			if contact == nil || contact.Port != s.p.QuerySession(s.ps).(map[string]interface{})["sip_port"] {
+				t.Fatalf("got %v bound", contact)
+			}
+			if got, want := contact.Param("transport") == "tcp", transport == "TCP"; got != want {
+				t.Errorf("got contact %s over %s", contact, transport)
+			}
+
+			r := s.send(t, `{"request":"unregister"}`, nil)
+			if result, _ := r["result"].(map[string]interface{}); result["event"] != "unregistering" {
+				t.Fatalf("got %v unregistering", r)
+			}
+			// challenged again
+			for i := 0; i < 2; i++ {
+				if req := pr.next(t, "REGISTER"); req.Get("Expires") != "0" {
+					t.Errorf("got an expiry of %s unregistering", req.Get("Expires"))
+				}
+			}
+			s.g.wait(t, "unregistered")
+			if contact := pr.binding("alice"); contact != nil {
+				t.Errorf("%s still bound", contact)
+			}
+
+			wrong := startSession(t)
+			wrong.register(t, pr, transport, "wrong")
+			if e := wrong.g.wait(t, "registration_failed"); e.result()["code"] != 403.0 {
+				t.Errorf("got %v with a wrong secret", e.data)
+			}
+		})
+	}
+}
+
+// An outgoing call, and all that can happen during it
+func TestCall(t *testing.T) {
+	for _, transport := range transports {
+		t.Run(transport, func(t *testing.T) {
+			pr := startPeer(t, map[string]string{"alice": "secret"})
+			s := startSession(t)
+			s.register(t, pr, transport, "secret")
+			s.g.wait(t, "registered")
+
+			r := s.send(t, `{"request":"call","uri":"`+pr.uri("bob", "")+`"}`, &plugins.JSEP{Type: "offer", SDP: webOffer})
+			if result, _ := r["result"].(map[string]interface{}); result["event"] != "calling" {
+				t.Fatalf("got %v calling", r)
+			}
+			invite := pr.next(t, "INVITE")
+			pr.next(t, "ACK")
+			s.g.wait(t, "ringing")
+			if e := s.g.wait(t, "accepted"); e.jsep == nil || e.jsep.Type != "answer" {
+				t.Fatalf("got %v accepting", e.jsep)
+			}
+			offer, err := sdp.Parse(string(invite.Body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			port := offer.MLine(sdp.Audio).Port
+
+			t.Run("media", func(t *testing.T) {
+				plugin := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+				pr.rtp.WriteToUDP(rtpPacket(0, 1, 160, []byte("audio")), plugin)
+				select {
+				case packet := <-s.g.rtp:
+					if string(packet[rtp.HeaderSize:]) != "audio" {
+						t.Errorf("got %q relayed", packet)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("nothing relayed to the browser")
+				}
+				// the end of a DTMF event, sent three times, the browser
+				// having 126 for telephone-event
+				for seq := uint16(2); seq < 5; seq++ {
+					pr.rtp.WriteToUDP(rtpPacket(101, seq, 320, []byte{5, 0x80, 0x06, 0x40}), plugin)
+				}
+				if e := s.g.wait(t, "dtmf"); e.result()["signal"] != "5" || e.result()["duration"] != 200.0 {
+					t.Errorf("got %v", e.data)
+				}
+				select {
+				case packet := <-s.g.rtp:
+					if rtp.PayloadType(packet) != 126 {
+						t.Errorf("got telephone-event with payload type %d", rtp.PayloadType(packet))
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("telephone-event not relayed to the browser")
+				}
+
+				s.p.IncomingRTP(s.ps, &plugins.RTPPacket{Buffer: rtpPacket(0, 1, 160, []byte("browser"))})
+				buf := make([]byte, bufferSize)
+				pr.rtp.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, _, err := pr.rtp.ReadFromUDP(buf)
+				if err != nil || string(buf[rtp.HeaderSize:n]) != "browser" {
+					t.Errorf("got %q (%v) from the browser", buf[:n], err)
+				}
+			})
+
+			t.Run("hold", func(t *testing.T) {
+				for _, test := range []struct {
+					request, event string
+					dir            sdp.Direction
+					hold           bool
+				}{
+					{"hold", "holding", sdp.SendOnly, true},
+					{"unhold", "resuming", sdp.SendRecv, false},
+				} {
+					r := s.send(t, `{"request":"`+test.request+`"}`, nil)
+					if result, _ := r["result"].(map[string]interface{}); result["event"] != test.event {
+						t.Fatalf("got %v", r)
+					}
+					if dir := direction(t, pr.next(t, "INVITE").Body); dir != test.dir {
+						t.Errorf("%s: got %s in the re-INVITE, want %s", test.request, dir, test.dir)
+					}
+					pr.next(t, "ACK")
+					if hold := s.p.QuerySession(s.ps).(map[string]interface{})["hold"]; hold != test.hold {
+						t.Errorf("%s: got hold %v", test.request, hold)
+					}
+				}
+			})
+
+			t.Run("remote hold", func(t *testing.T) {
+				for _, test := range []struct {
+					dir, event string
+				}{
+					{"sendonly", "hold"},
+					{"sendrecv", "unhold"},
+				} {
+					held := strings.Replace(pr.sdp(nil), "sendrecv", test.dir, 1)
+					res := pr.request(t, "INVITE", held, "Contact", "<"+pr.uri("bob", transport)+">", "Content-Type", "application/sdp")
+					if res.StatusCode != 200 {
+						t.Fatalf("got %d %s to the re-INVITE", res.StatusCode, res.Reason)
+					}
+					s.g.wait(t, test.event)
+				}
+			})
+
+			t.Run("dtmf", func(t *testing.T) {
+				r := s.send(t, `{"request":"dtmf_info","digit":"7","duration":100}`, nil)
+				if result, _ := r["result"].(map[string]interface{}); result["event"] != "dtmfsent" {
+					t.Fatalf("got %v", r)
+				}
+				info := pr.next(t, "INFO")
+				if info.Get("Content-Type") != "application/dtmf-relay" || string(info.Body) != "Signal=7\r\nDuration=100\r\n" {
+					t.Errorf("got %s %q", info.Get("Content-Type"), info.Body)
+				}
+
+				res := pr.request(t, "INFO", "Signal=3\r\nDuration=160\r\n", "Content-Type", "application/dtmf-relay")
+				if res.StatusCode != 200 {
+					t.Errorf("got %d %s to an INFO", res.StatusCode, res.Reason)
+				}
+				if e := s.g.wait(t, "info"); e.result()["content"] != "Signal=3\r\nDuration=160\r\n" {
+					t.Errorf("got %v", e.data)
+				}
+			})
+
+			t.Run("transfer", func(t *testing.T) {
+				carol := pr.uri("carol", "")
+				r := s.send(t, `{"request":"transfer","uri":"`+carol+`"}`, nil)
+				if result, _ := r["result"].(map[string]interface{}); result["event"] != "transferring" {
+					t.Fatalf("got %v", r)
+				}
+				if refer := pr.next(t, "REFER"); refer.Get("Refer-To") != "<"+carol+">" {
+					t.Errorf("got a REFER to %s", refer.Get("Refer-To"))
+				}
+				res := pr.request(t, "NOTIFY", "SIP/2.0 200 OK\r\n", "Event", "refer", "Subscription-State", "terminated;reason=noresource",
+					"Content-Type", "message/sipfrag;version=2.0")
+				if res.StatusCode != 200 {
+					t.Errorf("got %d %s to a NOTIFY", res.StatusCode, res.Reason)
+				}
+				if e := s.g.wait(t, "notify"); e.result()["notify"] != "refer" || e.result()["content"] != "SIP/2.0 200 OK\r\n" {
+					t.Errorf("got %v", e.data)
+				}
+
+				// the other way round
+				res = pr.request(t, "REFER", "", "Refer-To", "<"+carol+">", "Referred-By", "<"+pr.uri("bob", "")+">")
+				if res.StatusCode != 202 {
+					t.Errorf("got %d %s to a REFER", res.StatusCode, res.Reason)
+				}
+				if e := s.g.wait(t, "transfer"); e.result()["refer_to"] != carol || e.result()["refer_id"] != 1.0 {
+					t.Errorf("got %v", e.data)
+				}
+			})
+
+			if res := pr.request(t, "BYE", ""); res.StatusCode != 200 {
+				t.Errorf("got %d %s to the BYE", res.StatusCode, res.Reason)
+			}
+			if e := s.g.wait(t, "hangup"); e.result()["reason"] != "BYE" {
+				t.Errorf("got %v", e.data)
+			}
+		})
+	}
+}
+
+// An incoming call to the registered contact, accepted then hung up
+func TestIncomingCall(t *testing.T) {
+	for _, transport := range transports {
+		t.Run(transport, func(t *testing.T) {
+			pr := startPeer(t, map[string]string{"alice": "secret"})
+			s := startSession(t)
+			s.register(t, pr, transport, "secret")
+			s.g.wait(t, "registered")
+
+			final := pr.call(t, "alice", transport)
+			e := s.g.wait(t, "incomingcall")
+			if e.result()["username"] != pr.uri("bob", "") {
+				t.Errorf("got %v", e.data)
+			}
+			r := s.send(t, `{"request":"accept"}`, webAnswer(t, e.jsep))
+			if result, _ := r["result"].(map[string]interface{}); result["event"] != "accepted" {
+				t.Fatalf("got %v accepting", r)
+			}
+			select {
+			case res := <-final:
+				if res.StatusCode != 200 || direction(t, res.Body) != sdp.SendRecv {
+					t.Fatalf("got %d %s", res.StatusCode, res.Body)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the call was never answered")
+			}
+
+			r = s.send(t, `{"request":"hangup"}`, nil)
+			if result, _ := r["result"].(map[string]interface{}); result["event"] != "hangingup" {
+				t.Fatalf("got %v hanging up", r)
+			}
+			pr.next(t, "BYE")
+			s.g.wait(t, "hangup")
+		})
+	}
+}
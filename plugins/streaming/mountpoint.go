@@ -0,0 +1,621 @@
+package streaming
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sdp"
+	"github.com/xroger88/go-janus/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// MountpointConfig describes a mountpoint, both in the configuration and in
+// create requests (where elements have the same names)
+type MountpointConfig struct {
+	// ID is the unique id of the mountpoint, a random one if 0
+	ID uint64
+	// Type can only be "rtp" for now
+	Type        string
+	Name        string
+	Description string
+	// Is_private mountpoints don't show up in list requests
+	Is_private bool
+	// Secret is needed to edit and destroy, Pin to watch
+	Secret string
+	Pin    string
+	// Audio is received on Audioport (multicast if Audiomcast is set, via
+	// Audioiface if set), with payload type Audiopt: Audiortpmap and
+	// Audiofmtp go in the offers to viewers
+	Audio       bool
+	Audioport   int
+	Audiomcast  string
+	Audioiface  string
+	Audiopt     int
+	Audiortpmap string
+	Audiofmtp   string
+	// and the same for video
+	Video       bool
+	Videoport   int
+	Videomcast  string
+	Videoiface  string
+	Videopt     int
+	Videortpmap string
+	Videofmtp   string
+	// Data is received as UDP datagrams on Dataport, and sent to viewers
+	// as text data channel messages
+	Data     bool
+	Dataport int
+}
+
+// maximum size of what's received
+const bufferSize = 1500
+
+type mountpoint struct {
+	MountpointConfig
+	audioCodec, videoCodec string
+
+	mutex   sync.Mutex
+	enabled bool
+	// viewers is copied on change, so that relaying needs no lock
+	viewers      []*session
+	conns        []*net.UDPConn
+	lastReceived map[string]time.Time
+	destroyed    bool
+}
+
+// newMountpoint checks the configuration of a mountpoint, and starts
+// receiving media
+func (p *Plugin) newMountpoint(mc MountpointConfig) (*mountpoint, error) {
+	if mc.Type == "" {
+		mc.Type = "rtp"
+	}
+	if mc.Type != "rtp" {
+		return nil, invalid("type", "should be rtp")
+	}
+	if !mc.Audio && !mc.Video && !mc.Data {
+		return nil, fail(ErrorCantCreate, "Can't add 'rtp' stream, no audio, video or data have to be streamed...")
+	}
+	mp := &mountpoint{MountpointConfig: mc, enabled: true, lastReceived: make(map[string]time.Time)}
+	if mc.Audio {
+		if mp.audioCodec = sdp.CodecOf(mc.Audiortpmap); mp.audioCodec == "" || !sdp.KnownCodec(sdp.Audio, mp.audioCodec) {
+			return nil, invalid("audiortpmap", "is not a supported audio codec")
+		}
+		if mc.Audiopt < 0 || mc.Audiopt > 127 {
+			return nil, invalid("audiopt", "should be between 0 and 127")
+		}
+	}
+	if mc.Video {
+		if mp.videoCodec = sdp.CodecOf(mc.Videortpmap); mp.videoCodec == "" || !sdp.KnownCodec(sdp.Video, mp.videoCodec) {
+			return nil, invalid("videortpmap", "is not a supported video codec")
+		}
+		if mc.Videopt < 0 || mc.Videopt > 127 {
+			return nil, invalid("videopt", "should be between 0 and 127")
+		}
+	}
+	if mp.ID == 0 {
+		p.mutex.Lock()
+		for mp.ID == 0 || p.mountpoints[mp.ID] != nil {
+			mp.ID = util.RandomUint64()
+		}
+		p.mutex.Unlock()
+	}
+
+	// bind all the ports first, so that nothing starts if one fails
+	type stream struct {
+		kind       string
+		port       *int
+		mcast, ifc string
+	}
+	var streams []stream
+	if mc.Audio {
+		streams = append(streams, stream{"audio", &mp.Audioport, mc.Audiomcast, mc.Audioiface})
+	}
+	if mc.Video {
+		streams = append(streams, stream{"video", &mp.Videoport, mc.Videomcast, mc.Videoiface})
+	}
+	if mc.Data {
+		streams = append(streams, stream{"data", &mp.Dataport, "", ""})
+	}
+	for _, st := range streams {
+		var (
+			conn *net.UDPConn
+			err  error
+		)
+		if st.mcast != "" {
+			conn, err = rtp.ListenMulticastUDP(st.mcast, *st.port, st.ifc)
+		} else {
+			conn, err = rtp.ListenUDP("", *st.port)
+		}
+		if err != nil {
+			mp.close()
+			return nil, fail(ErrorCantCreate, "Can't bind %s port %d: %v", st.kind, *st.port, err)
+		}
+		*st.port = conn.LocalAddr().(*net.UDPAddr).Port
+		mp.conns = append(mp.conns, conn)
+	}
+	for i, st := range streams {
+		go p.receive(mp, mp.conns[i], st.kind)
+	}
+	log.Infof("[%s] Created mountpoint %d (audio port %d, video port %d, data port %d)", Package, mp.ID, mp.Audioport, mp.Videoport, mp.Dataport)
+	return mp, nil
+}
+
+// close stops receiving media
+func (mp *mountpoint) close() {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	mp.destroyed = true
+	for _, conn := range mp.conns {
+		conn.Close()
+	}
+}
+
+func (mp *mountpoint) addViewer(s *session) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	viewers := make([]*session, 0, len(mp.viewers)+1)
+	mp.viewers = append(append(viewers, mp.viewers...), s)
+}
+
+func (mp *mountpoint) removeViewer(s *session) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	viewers := make([]*session, 0, len(mp.viewers))
+	for _, v := range mp.viewers {
+		if v != s {
+			viewers = append(viewers, v)
+		}
+	}
+	mp.viewers = viewers
+}
+
+func (mp *mountpoint) checkPin(pin string) error {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	if mp.Pin != "" && pin != mp.Pin {
+		return fail(ErrorUnauthorized, "Unauthorized (wrong pin)")
+	}
+	return nil
+}
+
+// authorize checks the secret of a mountpoint, which the caller holds the
+// mutex of
+func (mp *mountpoint) authorize(secret string, admin bool) error {
+	if !admin && mp.Secret != "" && secret != mp.Secret {
+		return fail(ErrorUnauthorized, "Unauthorized (wrong secret)")
+	}
+	return nil
+}
+
+// mountpoint returns a mountpoint which is enabled
+func (p *Plugin) mountpoint(id uint64) (*mountpoint, error) {
+	p.mutex.Lock()
+	mp := p.mountpoints[id]
+	p.mutex.Unlock()
+	if mp == nil {
+		return nil, fail(ErrorNoSuchMountpoint, "No such mountpoint/stream %d", id)
+	}
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	if mp.destroyed {
+		return nil, fail(ErrorNoSuchMountpoint, "No such mountpoint/stream %d", id)
+	}
+	if !mp.enabled {
+		return nil, fail(ErrorUnauthorized, "Mountpoint %d is not enabled", id)
+	}
+	return mp, nil
+}
+
+// receive relays what a socket gets to the viewers, until it's closed
+func (p *Plugin) receive(mp *mountpoint, conn *net.UDPConn, kind string) {
+	buf := make([]byte, bufferSize)
+	video := kind == "video"
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			mp.mutex.Lock()
+			destroyed := mp.destroyed
+			mp.mutex.Unlock()
+			if !destroyed {
+				log.Errorf("[%s] Error receiving %s of mountpoint %d: %v", Package, kind, mp.ID, err)
+			}
+			return
+		}
+		if n == 0 || (kind != "data" && !rtp.IsRTP(buf[:n])) {
+			continue
+		}
+		mp.mutex.Lock()
+		mp.lastReceived[kind] = time.Now()
+		viewers, enabled := mp.viewers, mp.enabled
+		mp.mutex.Unlock()
+		if !enabled {
+			continue
+		}
+		for _, s := range viewers {
+			if kind == "data" {
+				p.relayData(s, mp, buf[:n])
+			} else {
+				p.relayRTP(s, mp, buf[:n], video)
+			}
+		}
+	}
+}
+
+// relayRTP sends a copy of a packet to a viewer, rewritten so that it
+// follows what it got before (e.g. from another mountpoint)
+func (p *Plugin) relayRTP(s *session, mp *mountpoint, packet []byte, video bool) {
+	s.mutex.Lock()
+	if s.mp != mp || !s.started || s.paused || atomic.LoadInt32(&s.hangingUp) == 1 ||
+		(video && s.videoCodec == "") || (!video && s.audioCodec == "") {
+		s.mutex.Unlock()
+		return
+	}
+	buf := append([]byte(nil), packet...)
+	if video {
+		rtp.SetPayloadType(buf, uint8(s.videoPT))
+		s.videoCtx.Update(buf, 90000)
+	} else {
+		rtp.SetPayloadType(buf, uint8(s.audioPT))
+		s.audioCtx.Update(buf, sdp.ClockRate(s.audioCodec))
+	}
+	s.mutex.Unlock()
+	p.gateway.RelayRTP(s.ps, &plugins.RTPPacket{Video: video, Mindex: -1, Buffer: buf})
+}
+
+func (p *Plugin) relayData(s *session, mp *mountpoint, data []byte) {
+	s.mutex.Lock()
+	relay := s.mp == mp && s.started && !s.paused && s.hasData
+	s.mutex.Unlock()
+	if relay {
+		p.gateway.RelayData(s.ps, &plugins.DataPacket{Buffer: append([]byte(nil), data...)})
+	}
+}
+
+// describe is how a mountpoint appears in list and info responses; the
+// caller holds its mutex
+func (mp *mountpoint) describe(details bool) map[string]interface{} {
+	d := map[string]interface{}{
+		"id":          mp.ID,
+		"type":        "live",
+		"description": mp.Description,
+		"enabled":     mp.enabled,
+	}
+	if mp.Name != "" {
+		d["name"] = mp.Name
+	}
+	for _, kind := range []string{"audio", "video", "data"} {
+		if last, ok := mp.lastReceived[kind]; ok {
+			d[kind+"_age_ms"] = time.Since(last).Nanoseconds() / int64(time.Millisecond)
+		}
+	}
+	if !details {
+		return d
+	}
+	d["is_private"] = mp.Is_private
+	d["viewers"] = len(mp.viewers)
+	d["audio"] = mp.Audio
+	d["video"] = mp.Video
+	d["data"] = mp.Data
+	if mp.Audio {
+		d["audiopt"] = mp.Audiopt
+		d["audiortpmap"] = mp.Audiortpmap
+		if mp.Audiofmtp != "" {
+			d["audiofmtp"] = mp.Audiofmtp
+		}
+	}
+	if mp.Video {
+		d["videopt"] = mp.Videopt
+		d["videortpmap"] = mp.Videortpmap
+		if mp.Videofmtp != "" {
+			d["videofmtp"] = mp.Videofmtp
+		}
+	}
+	return d
+}
+
+// ports describes where a mountpoint receives media
+func (mp *mountpoint) ports(d map[string]interface{}) {
+	if mp.Audio {
+		d["audio_port"] = mp.Audioport
+	}
+	if mp.Video {
+		d["video_port"] = mp.Videoport
+	}
+	if mp.Data {
+		d["data_port"] = mp.Dataport
+	}
+}
+
+// savePermanent replaces (or removes, if mc is nil) a mountpoint in the
+// configuration file
+func (p *Plugin) savePermanent(id uint64, mc *MountpointConfig) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	list := p.config.Mountpoints[:0:0]
+	for _, c := range p.config.Mountpoints {
+		if c.ID != id {
+			list = append(list, c)
+		}
+	}
+	if mc != nil {
+		list = append(list, *mc)
+	}
+	p.config.Mountpoints = list
+	data, err := yaml.Marshal(&p.config)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(p.configPath, Package+".yaml"), data, 0644)
+	}
+	if err != nil {
+		log.Errorf("[%s] Error saving the configuration: %v", Package, err)
+		return fail(ErrorUnknown, "Error saving the configuration: %v", err)
+	}
+	return nil
+}
+
+// manage handles the requests about mountpoints; admin is set for the ones
+// via the Admin API, which need no secrets
+func (p *Plugin) manage(request string, body json.RawMessage, admin bool) map[string]interface{} {
+	var (
+		response map[string]interface{}
+		err      error
+	)
+	switch request {
+	case "list":
+		response, err = p.list(body, admin)
+	case "info":
+		response, err = p.info(body, admin)
+	case "create":
+		response, err = p.create(body, admin)
+	case "edit":
+		response, err = p.edit(body, admin)
+	case "destroy":
+		response, err = p.destroy(body, admin)
+	case "enable", "disable":
+		response, err = p.enable(body, admin, request == "enable")
+	}
+	if err != nil {
+		log.Warnf("[%s] %v", Package, err)
+		return errorEvent(err)
+	}
+	return response
+}
+
+// all returns the mountpoints, enabled or not
+func (p *Plugin) all() []*mountpoint {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	list := make([]*mountpoint, 0, len(p.mountpoints))
+	for _, mp := range p.mountpoints {
+		list = append(list, mp)
+	}
+	return list
+}
+
+// find returns a mountpoint, enabled or not, with its mutex held
+func (p *Plugin) find(id *uint64) (*mountpoint, error) {
+	if id == nil {
+		return nil, missing("id")
+	}
+	p.mutex.Lock()
+	mp := p.mountpoints[*id]
+	p.mutex.Unlock()
+	if mp == nil {
+		return nil, fail(ErrorNoSuchMountpoint, "No such mountpoint/stream %d", *id)
+	}
+	mp.mutex.Lock()
+	if mp.destroyed {
+		mp.mutex.Unlock()
+		return nil, fail(ErrorNoSuchMountpoint, "No such mountpoint/stream %d", *id)
+	}
+	return mp, nil
+}
+
+func (p *Plugin) list(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		AdminKey string `json:"admin_key"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if key := p.config.General.Admin_key; key != "" && r.AdminKey == key {
+		admin = true
+	}
+	list := []map[string]interface{}{}
+	for _, mp := range p.all() {
+		mp.mutex.Lock()
+		if !mp.destroyed && (!mp.Is_private || admin) {
+			list = append(list, mp.describe(false))
+		}
+		mp.mutex.Unlock()
+	}
+	return map[string]interface{}{"streaming": "list", "list": list}, nil
+}
+
+// info describes a mountpoint; the ports are only given with the secret
+func (p *Plugin) info(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		ID     *uint64 `json:"id"`
+		Secret string  `json:"secret"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	mp, err := p.find(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer mp.mutex.Unlock()
+	d := mp.describe(true)
+	if admin || (mp.Secret != "" && r.Secret == mp.Secret) {
+		mp.ports(d)
+	}
+	return map[string]interface{}{"streaming": "info", "info": d}, nil
+}
+
+func (p *Plugin) create(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var mc MountpointConfig
+	r := struct {
+		*MountpointConfig
+		Admin_key string
+		Permanent bool
+	}{MountpointConfig: &mc}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if key := p.config.General.Admin_key; key != "" && !admin && r.Admin_key != key {
+		if r.Admin_key == "" {
+			return nil, missing("admin_key")
+		}
+		return nil, fail(ErrorUnauthorized, "Unauthorized (wrong admin_key)")
+	}
+	if mc.ID != 0 {
+		p.mutex.Lock()
+		exists := p.mountpoints[mc.ID] != nil
+		p.mutex.Unlock()
+		if exists {
+			return nil, fail(ErrorCantCreate, "A stream with the provided ID already exists")
+		}
+	}
+	mp, err := p.newMountpoint(mc)
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	if p.mountpoints[mp.ID] != nil {
+		p.mutex.Unlock()
+		mp.close()
+		return nil, fail(ErrorCantCreate, "A stream with the provided ID already exists")
+	}
+	p.mountpoints[mp.ID] = mp
+	p.mutex.Unlock()
+	p.notify(nil, map[string]interface{}{"event": "created", "id": mp.ID})
+	if r.Permanent {
+		if err := p.savePermanent(mp.ID, &mp.MountpointConfig); err != nil {
+			return nil, err
+		}
+	}
+	stream := map[string]interface{}{
+		"id":          mp.ID,
+		"type":        "live",
+		"description": mp.Description,
+		"is_private":  mp.Is_private,
+	}
+	mp.ports(stream)
+	return map[string]interface{}{"streaming": "created", "created": mp.Name, "permanent": r.Permanent, "stream": stream}, nil
+}
+
+func (p *Plugin) edit(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		ID          *uint64 `json:"id"`
+		Secret      string  `json:"secret"`
+		Description *string `json:"new_description"`
+		NewSecret   *string `json:"new_secret"`
+		Pin         *string `json:"new_pin"`
+		IsPrivate   *bool   `json:"new_is_private"`
+		Permanent   bool    `json:"permanent"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	mp, err := p.find(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := mp.authorize(r.Secret, admin); err != nil {
+		mp.mutex.Unlock()
+		return nil, err
+	}
+	if r.Description != nil && *r.Description != "" {
+		mp.Description = *r.Description
+	}
+	if r.NewSecret != nil {
+		mp.Secret = *r.NewSecret
+	}
+	if r.Pin != nil {
+		mp.Pin = *r.Pin
+	}
+	if r.IsPrivate != nil {
+		mp.Is_private = *r.IsPrivate
+	}
+	mc := mp.MountpointConfig
+	mp.mutex.Unlock()
+	p.notify(nil, map[string]interface{}{"event": "edited", "id": mc.ID})
+	if r.Permanent {
+		if err := p.savePermanent(mc.ID, &mc); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"streaming": "edited", "id": mc.ID, "permanent": r.Permanent}, nil
+}
+
+// destroy stops a mountpoint, and hangs up its viewers
+func (p *Plugin) destroy(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		ID        *uint64 `json:"id"`
+		Secret    string  `json:"secret"`
+		Permanent bool    `json:"permanent"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	mp, err := p.find(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := mp.authorize(r.Secret, admin); err != nil {
+		mp.mutex.Unlock()
+		return nil, err
+	}
+	viewers := mp.viewers
+	mp.mutex.Unlock()
+	mp.close()
+	p.mutex.Lock()
+	delete(p.mountpoints, mp.ID)
+	p.mutex.Unlock()
+	for _, s := range viewers {
+		s.unwatch()
+		p.gateway.PushEvent(s.ps, p, "", map[string]interface{}{
+			"streaming": "event",
+			"result":    map[string]interface{}{"status": "stopped"},
+		}, nil)
+		p.gateway.ClosePC(s.ps)
+	}
+	log.Infof("[%s] Destroyed mountpoint %d", Package, mp.ID)
+	p.notify(nil, map[string]interface{}{"event": "destroyed", "id": mp.ID})
+	if r.Permanent {
+		if err := p.savePermanent(mp.ID, nil); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"streaming": "destroyed", "destroyed": mp.ID}, nil
+}
+
+// enable lets viewers watch a mountpoint again, or not anymore
+func (p *Plugin) enable(body json.RawMessage, admin, enabled bool) (map[string]interface{}, error) {
+	var r struct {
+		ID     *uint64 `json:"id"`
+		Secret string  `json:"secret"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	mp, err := p.find(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer mp.mutex.Unlock()
+	if err := mp.authorize(r.Secret, admin); err != nil {
+		return nil, err
+	}
+	mp.enabled = enabled
+	log.Infof("[%s] Mountpoint %d %s", Package, mp.ID, map[bool]string{true: "enabled", false: "disabled"}[enabled])
+	return map[string]interface{}{"streaming": "ok"}, nil
+}
@@ -0,0 +1,549 @@
+package streaming
+
+// The Streaming plugin relays live media to WebRTC viewers: each mountpoint
+// receives plain RTP (audio and video) and data (plain UDP datagrams) on
+// its own ports, e.g. from GStreamer or FFmpeg, and sends it to whoever
+// watches it. Requests and events are the ones of janus.plugin.streaming of
+// the original Janus, for RTP mountpoints, so the streaming demo of janus.js
+// works unchanged.
+//
+// Mountpoints are managed with synchronous requests, answered right away:
+//
+//	{"request": "list"}
+//	{"request": "info", "id": 1, "secret": "..."}
+//	{"request": "create", "type": "rtp", "id": 1, "name": "...", "description": "...",
+//	 "secret": "...", "pin": "...", "is_private": false,
+//	 "audio": true, "audioport": 5002, "audiomcast": "232.3.4.5", "audioiface": "eth0",
+//	 "audiopt": 111, "audiortpmap": "opus/48000/2", "audiofmtp": "...",
+//	 "video": true, "videoport": 5004, ..., "data": true, "dataport": 5006,
+//	 "permanent": false}
+//	{"request": "edit", "id": 1, "secret": "...", "new_description": "...", ...}
+//	{"request": "destroy", "id": 1, "secret": "...", "permanent": false}
+//	{"request": "enable" or "disable", "id": 1, "secret": "..."}
+//
+// Viewers use asynchronous requests, answered with events:
+//
+//	{"request": "watch", "id": 1, "pin": "...", "offer_audio": true, "offer_video": true, "offer_data": true}
+//	{"request": "start"} (with the answer to the offer of watch)
+//	{"request": "pause"}, {"request": "switch", "id": 2}, {"request": "stop"}
+//
+// A port of 0 picks a free one from the rtp_port_range of conf.yaml.
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sdp"
+)
+
+const Package = "janus.plugin.streaming"
+
+// error codes of the events and responses
+const (
+	ErrorNoMessage        = 450
+	ErrorInvalidJSON      = 451
+	ErrorInvalidRequest   = 452
+	ErrorMissingElement   = 453
+	ErrorInvalidElement   = 454
+	ErrorNoSuchMountpoint = 455
+	ErrorCantCreate       = 456
+	ErrorUnauthorized     = 457
+	ErrorCantSwitch       = 458
+	ErrorInvalidState     = 460
+	ErrorUnknown          = 470
+)
+
+// Config is the content of janus.plugin.streaming.yaml in the configs folder
+type Config struct {
+	General struct {
+		// Admin_key, if set, must be provided to create mountpoints
+		Admin_key string
+		// Events tells whether to notify the event handlers
+		Events bool
+	}
+	// Mountpoints are created at startup; permanent mountpoints created or
+	// changed via the API are saved here too
+	Mountpoints []MountpointConfig
+}
+
+type Plugin struct {
+	config     Config
+	configPath string
+	gateway    plugins.Callbacks
+	messages   chan *message
+	done       chan struct{}
+
+	// mutex protects mountpoints, and the mountpoints of the configuration
+	mutex       sync.Mutex
+	mountpoints map[uint64]*mountpoint
+}
+
+// message is an asynchronous request waiting for the handler
+type message struct {
+	ps          *plugins.PluginSession
+	transaction string
+	request     string
+	body        json.RawMessage
+	jsep        *plugins.JSEP
+}
+
+// session is the state of a handle attached to the plugin, i.e. a viewer
+type session struct {
+	ps *plugins.PluginSession
+
+	mutex sync.Mutex
+	// mp is the mountpoint being watched, nil if none
+	mp *mountpoint
+	// codecs offered, with their payload types
+	audioCodec, videoCodec string
+	audioPT, videoPT       int
+	hasData                bool
+	// started once the answer arrived, and not paused
+	started, paused    bool
+	audioCtx, videoCtx rtp.SwitchingContext
+	hangingUp          int32
+	destroyed          int32
+}
+
+// requestError is an error along with the code to tell clients about it
+type requestError struct {
+	code int
+	err  error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func fail(code int, format string, args ...interface{}) error {
+	return &requestError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// errorEvent describes an error the way clients expect it
+func errorEvent(err error) map[string]interface{} {
+	code := ErrorUnknown
+	if e, ok := err.(*requestError); ok {
+		code = e.code
+	}
+	return map[string]interface{}{"streaming": "event", "error_code": code, "error": err.Error()}
+}
+
+// decode parses the body of a request, with the right error codes
+func decode(body json.RawMessage, out interface{}) error {
+	err := plugins.Decode(body, out)
+	switch {
+	case err == nil:
+		return nil
+	case err == plugins.ErrNoMessage:
+		return &requestError{ErrorNoMessage, err}
+	case err == plugins.ErrInvalidJSON:
+		return &requestError{ErrorInvalidJSON, err}
+	}
+	if e, ok := err.(*plugins.ElementError); ok && e.Missing {
+		return &requestError{ErrorMissingElement, err}
+	}
+	return &requestError{ErrorInvalidElement, err}
+}
+
+func missing(name string) error {
+	return &requestError{ErrorMissingElement, plugins.Missing(name)}
+}
+
+func invalid(name, reason string) error {
+	return &requestError{ErrorInvalidElement, plugins.Invalid(name, reason)}
+}
+
+func init() {
+	plugins.Register(New())
+}
+
+// New returns the Streaming plugin, to be started with Init
+func New() *Plugin {
+	return &Plugin{
+		messages:    make(chan *message, 100),
+		done:        make(chan struct{}),
+		mountpoints: make(map[uint64]*mountpoint),
+	}
+}
+
+func (p *Plugin) Package() string { return Package }
+func (p *Plugin) Name() string    { return "JANUS Streaming plugin" }
+func (p *Plugin) Description() string {
+	return "This is a streaming plugin for Janus, allowing WebRTC peers to watch/listen to pre-recorded files or media generated by another tool."
+}
+func (p *Plugin) Author() string        { return api.Author }
+func (p *Plugin) Version() int          { return api.Version }
+func (p *Plugin) VersionString() string { return api.VersionString }
+
+// Init reads the configuration, creates the mountpoints it lists and starts
+// the message handler
+func (p *Plugin) Init(gateway plugins.Callbacks, configPath string) error {
+	p.config.General.Events = true
+	if err := config.LoadComponent(configPath, Package, &p.config); err != nil {
+		return err
+	}
+	p.configPath = configPath
+	p.gateway = gateway
+	for _, mc := range p.config.Mountpoints {
+		if p.mountpoints[mc.ID] != nil && mc.ID != 0 {
+			log.Warnf("[%s] Skipping mountpoint %d: defined twice", Package, mc.ID)
+			continue
+		}
+		mp, err := p.newMountpoint(mc)
+		if err != nil {
+			log.Warnf("[%s] Skipping mountpoint %d: %v", Package, mc.ID, err)
+			continue
+		}
+		p.mountpoints[mp.ID] = mp
+	}
+	go p.handler()
+	return nil
+}
+
+func (p *Plugin) Destroy() {
+	select {
+	case <-p.done:
+		return
+	default:
+		close(p.done)
+	}
+	p.mutex.Lock()
+	for id, mp := range p.mountpoints {
+		mp.close()
+		delete(p.mountpoints, id)
+	}
+	p.mutex.Unlock()
+	log.Infof("%s destroyed", Package)
+}
+
+func getSession(ps *plugins.PluginSession) *session {
+	s, _ := ps.Plugin.(*session)
+	if s == nil || atomic.LoadInt32(&s.destroyed) == 1 {
+		return nil
+	}
+	return s
+}
+
+func (p *Plugin) CreateSession(ps *plugins.PluginSession) error {
+	ps.Plugin = &session{ps: ps}
+	return nil
+}
+
+func (p *Plugin) DestroySession(ps *plugins.PluginSession) error {
+	s := getSession(ps)
+	if s == nil {
+		return fmt.Errorf("no session associated with this handle")
+	}
+	atomic.StoreInt32(&s.destroyed, 1)
+	s.unwatch()
+	return nil
+}
+
+func (p *Plugin) QuerySession(ps *plugins.PluginSession) interface{} {
+	s := getSession(ps)
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	info := map[string]interface{}{
+		"started":   s.started,
+		"paused":    s.paused,
+		"hangingup": atomic.LoadInt32(&s.hangingUp),
+		"destroyed": atomic.LoadInt32(&s.destroyed),
+	}
+	state := "idle"
+	if s.mp != nil {
+		info["mountpoint_id"] = s.mp.ID
+		if s.mp.Name != "" {
+			info["mountpoint_name"] = s.mp.Name
+		}
+		state = "preparing"
+		if s.started {
+			state = map[bool]string{true: "paused", false: "playing"}[s.paused]
+		}
+	}
+	info["state"] = state
+	return info
+}
+
+// unwatch stops getting the media of the mountpoint
+func (s *session) unwatch() {
+	s.mutex.Lock()
+	mp := s.mp
+	s.mp = nil
+	s.started, s.paused = false, false
+	s.mutex.Unlock()
+	if mp != nil {
+		mp.removeViewer(s)
+	}
+}
+
+// HandleMessage answers the requests about mountpoints right away, and
+// queues the ones of viewers for the handler
+func (p *Plugin) HandleMessage(ps *plugins.PluginSession, transaction string, body json.RawMessage, jsep *plugins.JSEP) *plugins.Result {
+	if getSession(ps) == nil {
+		return &plugins.Result{Type: plugins.ResultError, Text: "No session associated with this handle"}
+	}
+	var r struct {
+		Request *string `json:"request"`
+	}
+	if err := decode(body, &r); err != nil {
+		return &plugins.Result{Type: plugins.ResultOK, Content: errorEvent(err)}
+	}
+	if r.Request == nil {
+		return &plugins.Result{Type: plugins.ResultOK, Content: errorEvent(missing("request"))}
+	}
+	switch *r.Request {
+	case "list", "info", "create", "edit", "destroy", "enable", "disable":
+		return &plugins.Result{Type: plugins.ResultOK, Content: p.manage(*r.Request, body, false)}
+	case "watch", "start", "pause", "switch", "stop":
+	default:
+		err := fail(ErrorInvalidRequest, "Unknown request '%s'", *r.Request)
+		return &plugins.Result{Type: plugins.ResultOK, Content: errorEvent(err)}
+	}
+	select {
+	case p.messages <- &message{ps: ps, transaction: transaction, request: *r.Request, body: body, jsep: jsep}:
+	case <-p.done:
+		return &plugins.Result{Type: plugins.ResultError, Text: "Shutting down"}
+	}
+	return &plugins.Result{Type: plugins.ResultOKWait}
+}
+
+// HandleAdminMessage accepts the requests about mountpoints via the Admin API
+func (p *Plugin) HandleAdminMessage(body json.RawMessage) interface{} {
+	var r struct {
+		Request *string `json:"request"`
+	}
+	if err := decode(body, &r); err != nil {
+		return errorEvent(err)
+	}
+	if r.Request == nil {
+		return errorEvent(missing("request"))
+	}
+	switch *r.Request {
+	case "list", "info", "create", "edit", "destroy", "enable", "disable":
+		return p.manage(*r.Request, body, true)
+	}
+	return errorEvent(fail(ErrorInvalidRequest, "Unknown request '%s'", *r.Request))
+}
+
+func (p *Plugin) SetupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil {
+		return
+	}
+	log.Infof("[%s] WebRTC media is now available", Package)
+	atomic.StoreInt32(&s.hangingUp, 0)
+	p.gateway.PushEvent(ps, p, "", map[string]interface{}{
+		"streaming": "event",
+		"result":    map[string]interface{}{"status": "started"},
+	}, nil)
+}
+
+// viewers only receive: whatever they send is ignored
+func (p *Plugin) IncomingRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket)   {}
+func (p *Plugin) IncomingRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {}
+func (p *Plugin) IncomingData(ps *plugins.PluginSession, packet *plugins.DataPacket) {}
+func (p *Plugin) SlowLink(ps *plugins.PluginSession, uplink, video bool)             {}
+
+func (p *Plugin) HangupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil || !atomic.CompareAndSwapInt32(&s.hangingUp, 0, 1) {
+		return
+	}
+	log.Infof("[%s] No WebRTC media anymore", Package)
+	s.unwatch()
+}
+
+func (p *Plugin) notify(ps *plugins.PluginSession, event map[string]interface{}) {
+	if p.config.General.Events && p.gateway.EventsIsEnabled() {
+		p.gateway.NotifyEvent(p, ps, event)
+	}
+}
+
+// handler processes the requests of viewers, one at a time
+func (p *Plugin) handler() {
+	for {
+		select {
+		case m := <-p.messages:
+			p.handle(m)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Plugin) handle(m *message) {
+	s := getSession(m.ps)
+	if s == nil {
+		log.Warnf("[%s] No session associated with this handle", Package)
+		return
+	}
+	var (
+		result map[string]interface{}
+		jsep   *plugins.JSEP
+		err    error
+	)
+	switch m.request {
+	case "watch":
+		result, jsep, err = p.watch(s, m)
+	case "start":
+		result, err = p.start(s, m)
+	case "pause":
+		s.mutex.Lock()
+		if s.mp == nil || !s.started {
+			err = fail(ErrorInvalidState, "Can't pause: not watching anything")
+		} else {
+			s.paused = true
+		}
+		s.mutex.Unlock()
+		result = map[string]interface{}{"status": "pausing"}
+	case "switch":
+		result, err = p.switchMountpoint(s, m)
+	case "stop":
+		s.unwatch()
+		p.gateway.ClosePC(s.ps)
+		result = map[string]interface{}{"status": "stopping"}
+	}
+	if err != nil {
+		log.Warnf("[%s] %v", Package, err)
+		p.gateway.PushEvent(m.ps, p, m.transaction, errorEvent(err), nil)
+		return
+	}
+	event := map[string]interface{}{"streaming": "event", "result": result}
+	if err := p.gateway.PushEvent(m.ps, p, m.transaction, event, jsep); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+}
+
+// watch sends the viewer an offer with the media of a mountpoint
+func (p *Plugin) watch(s *session, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		ID         *uint64 `json:"id"`
+		Pin        string  `json:"pin"`
+		OfferAudio *bool   `json:"offer_audio"`
+		OfferVideo *bool   `json:"offer_video"`
+		OfferData  *bool   `json:"offer_data"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.ID == nil {
+		return nil, nil, missing("id")
+	}
+	mp, err := p.mountpoint(*r.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := mp.checkPin(r.Pin); err != nil {
+		return nil, nil, err
+	}
+	s.mutex.Lock()
+	watching := s.mp != nil
+	s.mutex.Unlock()
+	if watching {
+		return nil, nil, fail(ErrorInvalidState, "Already watching a stream")
+	}
+
+	opts := sdp.OfferOptions{
+		Name:           mp.Name,
+		AudioDirection: sdp.SendOnly,
+		VideoDirection: sdp.SendOnly,
+		Extensions:     []string{sdp.ExtMid, sdp.ExtAbsSendTime, sdp.ExtPlayoutDelay},
+	}
+	if opts.Name == "" {
+		opts.Name = fmt.Sprintf("Mountpoint %d", mp.ID)
+	}
+	s.mutex.Lock()
+	s.audioCodec, s.videoCodec, s.hasData = "", "", false
+	if mp.audioCodec != "" && (r.OfferAudio == nil || *r.OfferAudio) {
+		s.audioCodec, s.audioPT = mp.audioCodec, mp.Audiopt
+		opts.AudioCodec, opts.AudioPT, opts.AudioFmtp = mp.audioCodec, mp.Audiopt, mp.Audiofmtp
+	}
+	if mp.videoCodec != "" && (r.OfferVideo == nil || *r.OfferVideo) {
+		s.videoCodec, s.videoPT = mp.videoCodec, mp.Videopt
+		opts.VideoCodec, opts.VideoPT, opts.VideoFmtp = mp.videoCodec, mp.Videopt, mp.Videofmtp
+	}
+	s.hasData = mp.Data && (r.OfferData == nil || *r.OfferData)
+	opts.Data = s.hasData
+	s.mp = mp
+	s.started, s.paused = false, false
+	s.audioCtx, s.videoCtx = rtp.SwitchingContext{}, rtp.SwitchingContext{}
+	s.mutex.Unlock()
+	mp.addViewer(s)
+	log.Infof("[%s] Preparing mountpoint %d for a new viewer", Package, mp.ID)
+	p.notify(s.ps, map[string]interface{}{"status": "preparing", "id": mp.ID})
+
+	offer := sdp.GenerateOffer(opts)
+	return map[string]interface{}{"status": "preparing"}, &plugins.JSEP{Type: "offer", SDP: offer.String()}, nil
+}
+
+// start begins relaying media, once the viewer answered
+func (p *Plugin) start(s *session, m *message) (map[string]interface{}, error) {
+	if m.jsep != nil {
+		if m.jsep.Type != "answer" {
+			return nil, fail(ErrorInvalidState, "Unexpected %s, viewers send answers", m.jsep.Type)
+		}
+		if _, err := sdp.Parse(m.jsep.SDP); err != nil {
+			return nil, fail(ErrorInvalidElement, "Error parsing answer: %v", err)
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.mp == nil {
+		return nil, fail(ErrorInvalidState, "Can't start: not watching anything")
+	}
+	s.started, s.paused = true, false
+	p.notify(s.ps, map[string]interface{}{"status": "starting", "id": s.mp.ID})
+	return map[string]interface{}{"status": "starting"}, nil
+}
+
+// switchMountpoint moves a viewer to another mountpoint with the same
+// codecs, without any renegotiation
+func (p *Plugin) switchMountpoint(s *session, m *message) (map[string]interface{}, error) {
+	var r struct {
+		ID  *uint64 `json:"id"`
+		Pin string  `json:"pin"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, err
+	}
+	if r.ID == nil {
+		return nil, missing("id")
+	}
+	mp, err := p.mountpoint(*r.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := mp.checkPin(r.Pin); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	old := s.mp
+	switch {
+	case old == nil || !s.started:
+		s.mutex.Unlock()
+		return nil, fail(ErrorInvalidState, "Can't switch: not watching anything yet")
+	case (s.audioCodec != "" && s.audioCodec != mp.audioCodec) || (s.videoCodec != "" && s.videoCodec != mp.videoCodec):
+		s.mutex.Unlock()
+		return nil, fail(ErrorCantSwitch, "Can't switch to mountpoint %d: different codecs", mp.ID)
+	}
+	s.mp = mp
+	// the packets of the new mountpoint must follow the ones of the old one
+	s.audioCtx.Reset()
+	s.videoCtx.Reset()
+	s.mutex.Unlock()
+	if old != mp {
+		old.removeViewer(s)
+		mp.addViewer(s)
+	}
+	log.Infof("[%s] Switched viewer from mountpoint %d to %d", Package, old.ID, mp.ID)
+	p.notify(s.ps, map[string]interface{}{"status": "switching", "id": mp.ID})
+	return map[string]interface{}{"switched": "ok", "id": mp.ID}, nil
+}
@@ -0,0 +1,299 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/plugins"
+)
+
+// testGateway stands in for the core: the events of the plugin go to the
+// channel each handle has in its Gateway, the RTP it relays to rtp
+type testGateway struct {
+	plugins.Callbacks
+	rtp chan *plugins.RTPPacket
+}
+
+type testEvent struct {
+	transaction string
+	data        map[string]interface{}
+	jsep        *plugins.JSEP
+}
+
+func (g *testGateway) PushEvent(ps *plugins.PluginSession, plugin plugins.Plugin, transaction string, message interface{}, jsep *plugins.JSEP) error {
+	e := testEvent{transaction: transaction, jsep: jsep}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &e.data); err != nil {
+		return err
+	}
+	ps.Gateway.(chan testEvent) <- e
+	return nil
+}
+
+func (g *testGateway) RelayRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	select {
+	case g.rtp <- packet:
+	default:
+	}
+}
+
+func (g *testGateway) RelayRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {}
+func (g *testGateway) RelayData(ps *plugins.PluginSession, packet *plugins.DataPacket) {}
+func (g *testGateway) ClosePC(ps *plugins.PluginSession)                               {}
+func (g *testGateway) EventsIsEnabled() bool                                           { return false }
+
+func startPlugin(t *testing.T) (*Plugin, *testGateway) {
+	p := New()
+	g := &testGateway{rtp: make(chan *plugins.RTPPacket, 10)}
+	if err := p.Init(g, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Destroy)
+	return p, g
+}
+
+// attach creates a handle, whose events can be taken with next
+func attach(t *testing.T, p *Plugin) *plugins.PluginSession {
+	ps := plugins.NewPluginSession(make(chan testEvent, 100))
+	if err := p.CreateSession(ps); err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+func next(t *testing.T, ps *plugins.PluginSession) testEvent {
+	t.Helper()
+	select {
+	case e := <-ps.Gateway.(chan testEvent):
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return testEvent{}
+}
+
+// request sends a synchronous request, and returns the response
+func request(t *testing.T, p *Plugin, ps *plugins.PluginSession, body string) map[string]interface{} {
+	t.Helper()
+	r := p.HandleMessage(ps, "", json.RawMessage(body), nil)
+	if r.Type != plugins.ResultOK {
+		t.Fatalf("got %+v sending %s", r, body)
+	}
+	data, _ := json.Marshal(r.Content)
+	var response map[string]interface{}
+	json.Unmarshal(data, &response)
+	return response
+}
+
+// send sends an asynchronous request, and returns the event answering it
+func send(t *testing.T, p *Plugin, ps *plugins.PluginSession, body string, jsep *plugins.JSEP) testEvent {
+	t.Helper()
+	if r := p.HandleMessage(ps, "t", json.RawMessage(body), jsep); r.Type != plugins.ResultOKWait {
+		t.Fatalf("got %+v sending %s", r, body)
+	}
+	for {
+		if e := next(t, ps); e.transaction == "t" {
+			return e
+		}
+	}
+}
+
+func code(m map[string]interface{}) int {
+	c, _ := m["error_code"].(float64)
+	return int(c)
+}
+
+// status is that of the result of an event, if any
+func status(e testEvent) string {
+	result, _ := e.data["result"].(map[string]interface{})
+	s, _ := result["status"].(string)
+	return s
+}
+
+const answer = "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\nc=IN IP4 0.0.0.0\r\na=mid:audio\r\na=rtpmap:111 opus/48000/2\r\na=recvonly\r\n"
+
+// create adds an audio mountpoint, and returns the port it listens on
+func create(t *testing.T, p *Plugin, ps *plugins.PluginSession, id int, rtpmap string) int {
+	t.Helper()
+	r := request(t, p, ps, fmt.Sprintf(`{"request":"create","id":%d,"secret":"s","audio":true,"audioport":0,"audiopt":111,"audiortpmap":%q}`, id, rtpmap))
+	if r["streaming"] != "created" {
+		t.Fatalf("got %v creating mountpoint %d", r, id)
+	}
+	stream, _ := r["stream"].(map[string]interface{})
+	port, _ := stream["audio_port"].(float64)
+	if port == 0 {
+		t.Fatalf("got no audio port in %v", r)
+	}
+	return int(port)
+}
+
+func TestMountpoints(t *testing.T) {
+	p, _ := startPlugin(t)
+	ps := attach(t, p)
+	create(t, p, ps, 1234, "opus/48000/2")
+	tests := []struct {
+		name string
+		body string
+		want string // the streaming element of the response
+		code int
+	}{
+		{"create again", `{"request":"create","id":1234,"audio":true,"audiopt":111,"audiortpmap":"opus/48000/2"}`, "", ErrorCantCreate},
+		{"no media", `{"request":"create","id":5}`, "", ErrorCantCreate},
+		{"invalid codec", `{"request":"create","audio":true,"audiopt":111,"audiortpmap":"nope/8000"}`, "", ErrorInvalidElement},
+		{"list", `{"request":"list"}`, "list", 0},
+		{"info", `{"request":"info","id":1234}`, "info", 0},
+		{"no such mountpoint", `{"request":"info","id":42}`, "", ErrorNoSuchMountpoint},
+		{"missing id", `{"request":"info"}`, "", ErrorMissingElement},
+		{"edit without the secret", `{"request":"edit","id":1234,"new_description":"x"}`, "", ErrorUnauthorized},
+		{"edit", `{"request":"edit","id":1234,"secret":"s","new_description":"x"}`, "edited", 0},
+		{"disable without the secret", `{"request":"disable","id":1234}`, "", ErrorUnauthorized},
+		{"unknown request", `{"request":"dance"}`, "", ErrorInvalidRequest},
+		{"no request", `{}`, "", ErrorMissingElement},
+		{"invalid request", `{"request":5}`, "", ErrorInvalidElement},
+		{"destroy without the secret", `{"request":"destroy","id":1234}`, "", ErrorUnauthorized},
+		{"destroy", `{"request":"destroy","id":1234,"secret":"s"}`, "destroyed", 0},
+		{"destroy again", `{"request":"destroy","id":1234,"secret":"s"}`, "", ErrorNoSuchMountpoint},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := request(t, p, ps, test.body)
+			if code(r) != test.code {
+				t.Fatalf("got %v, want error code %d", r, test.code)
+			}
+			if test.want != "" && r["streaming"] != test.want {
+				t.Errorf("got %v, want %s", r, test.want)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	p, g := startPlugin(t)
+	admin := attach(t, p)
+	port := create(t, p, admin, 1, "opus/48000/2")
+	create(t, p, admin, 2, "opus/48000/2")
+	create(t, p, admin, 3, "PCMU/8000")
+	request(t, p, admin, `{"request":"edit","id":2,"secret":"s","new_pin":"1234"}`)
+
+	viewer := attach(t, p)
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"missing id", `{"request":"watch"}`, ErrorMissingElement},
+		{"no such mountpoint", `{"request":"watch","id":42}`, ErrorNoSuchMountpoint},
+		{"wrong pin", `{"request":"watch","id":2,"pin":"0000"}`, ErrorUnauthorized},
+		{"start before watching", `{"request":"start"}`, ErrorInvalidState},
+		{"pause before watching", `{"request":"pause"}`, ErrorInvalidState},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if e := send(t, p, viewer, test.body, nil); code(e.data) != test.code {
+				t.Errorf("got %v, want error code %d", e.data, test.code)
+			}
+		})
+	}
+
+	e := send(t, p, viewer, `{"request":"watch","id":1}`, nil)
+	if status(e) != "preparing" || e.jsep == nil || e.jsep.Type != "offer" {
+		t.Fatalf("got %v %+v watching", e.data, e.jsep)
+	}
+	if e := send(t, p, viewer, `{"request":"watch","id":1}`, nil); code(e.data) != ErrorInvalidState {
+		t.Errorf("got %v watching twice", e.data)
+	}
+	if e := send(t, p, viewer, `{"request":"switch","id":2,"pin":"1234"}`, nil); code(e.data) != ErrorInvalidState {
+		t.Errorf("got %v switching before starting", e.data)
+	}
+	if e := send(t, p, viewer, `{"request":"start"}`, &plugins.JSEP{Type: "offer", SDP: answer}); code(e.data) != ErrorInvalidState {
+		t.Errorf("got %v starting with an offer", e.data)
+	}
+	if e := send(t, p, viewer, `{"request":"start"}`, &plugins.JSEP{Type: "answer", SDP: answer}); status(e) != "starting" {
+		t.Fatalf("got %v starting", e.data)
+	}
+	p.SetupMedia(viewer)
+	if e := next(t, viewer); status(e) != "started" {
+		t.Errorf("got %v once the PeerConnection is up", e.data)
+	}
+
+	// what the mountpoint receives goes to the viewer, with the payload type it negotiated
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	packet := []byte{0x80, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 7, 1, 2, 3}
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case relayed := <-g.rtp:
+		if relayed.Video || relayed.Buffer[1]&0x7f != 111 {
+			t.Errorf("got %+v relayed", relayed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing relayed")
+	}
+
+	if e := send(t, p, viewer, `{"request":"switch","id":3}`, nil); code(e.data) != ErrorCantSwitch {
+		t.Errorf("got %v switching to another codec", e.data)
+	}
+	if e := send(t, p, viewer, `{"request":"switch","id":2,"pin":"1234"}`, nil); e.data["result"].(map[string]interface{})["switched"] != "ok" {
+		t.Errorf("got %v switching", e.data)
+	}
+	if e := send(t, p, viewer, `{"request":"pause"}`, nil); status(e) != "pausing" {
+		t.Errorf("got %v pausing", e.data)
+	}
+	if e := send(t, p, viewer, `{"request":"stop"}`, nil); status(e) != "stopping" {
+		t.Errorf("got %v stopping", e.data)
+	}
+
+	// a disabled mountpoint can't be watched
+	if r := request(t, p, admin, `{"request":"disable","id":1,"secret":"s"}`); r["streaming"] != "ok" {
+		t.Fatalf("got %v disabling", r)
+	}
+	if e := send(t, p, viewer, `{"request":"watch","id":1}`, nil); code(e.data) != ErrorUnauthorized {
+		t.Errorf("got %v watching a disabled mountpoint", e.data)
+	}
+	request(t, p, admin, `{"request":"enable","id":1,"secret":"s"}`)
+	if e := send(t, p, viewer, `{"request":"watch","id":1}`, nil); status(e) != "preparing" {
+		t.Errorf("got %v watching an enabled mountpoint again", e.data)
+	}
+	if err := p.DestroySession(viewer); err != nil {
+		t.Fatal(err)
+	}
+	if r := p.HandleMessage(viewer, "t", json.RawMessage(`{"request":"watch","id":1}`), nil); r.Type != plugins.ResultError {
+		t.Errorf("got %+v after destroying the handle", r)
+	}
+}
+
+func TestDestroyMountpoint(t *testing.T) {
+	p, _ := startPlugin(t)
+	admin := attach(t, p)
+	create(t, p, admin, 1, "opus/48000/2")
+	viewer := attach(t, p)
+	if e := send(t, p, viewer, `{"request":"watch","id":1}`, nil); status(e) != "preparing" {
+		t.Fatalf("got %v watching", e.data)
+	}
+
+	// the viewers are told
+	if r := request(t, p, admin, `{"request":"destroy","id":1,"secret":"s"}`); r["streaming"] != "destroyed" {
+		t.Fatalf("got %v destroying", r)
+	}
+	if e := next(t, viewer); status(e) != "stopped" {
+		t.Errorf("got %v, want the viewer stopped", e.data)
+	}
+	if e := send(t, p, viewer, `{"request":"start"}`, nil); code(e.data) != ErrorInvalidState {
+		t.Errorf("got %v starting a destroyed mountpoint", e.data)
+	}
+	if r := request(t, p, admin, `{"request":"list"}`); len(r["list"].([]interface{})) != 0 {
+		t.Errorf("got %v listing", r)
+	}
+}
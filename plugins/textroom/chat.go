@@ -0,0 +1,245 @@
+package textroom
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// participant is a session in a room
+type participant struct {
+	s        *session
+	room     *room
+	username string
+	display  string
+}
+
+func (pt *participant) describe() map[string]interface{} {
+	d := map[string]interface{}{"username": pt.username}
+	if pt.display != "" {
+		d["display"] = pt.display
+	}
+	return d
+}
+
+// joined returns what a session is in a room as, or an error
+func (s *session) joined(id *uint64) (*participant, error) {
+	if id == nil {
+		return nil, missing("room")
+	}
+	s.mutex.Lock()
+	pt := s.rooms[*id]
+	s.mutex.Unlock()
+	if pt == nil {
+		return nil, fail(ErrorNotInRoom, "Not in room %d", *id)
+	}
+	return pt, nil
+}
+
+// join adds a session to a room, and returns the history of the room along
+// with the response, to be sent after it
+func (p *Plugin) join(s *session, body json.RawMessage) (map[string]interface{}, []map[string]interface{}, error) {
+	var r struct {
+		Room     *uint64 `json:"room"`
+		Username *string `json:"username"`
+		Display  string  `json:"display"`
+		Pin      string  `json:"pin"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Room == nil {
+		return nil, nil, missing("room")
+	}
+	if r.Username == nil {
+		return nil, nil, missing("username")
+	}
+	if *r.Username == "" {
+		return nil, nil, invalid("username", "should not be empty")
+	}
+	rm, err := p.room(*r.Room)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mutex.Lock()
+	in := s.rooms[rm.Room] != nil
+	s.mutex.Unlock()
+	if in {
+		return nil, nil, fail(ErrorAlreadyInRoom, "Already in room %d", rm.Room)
+	}
+	pt := &participant{s: s, room: rm, username: *r.Username, display: r.Display}
+	rm.mutex.Lock()
+	switch {
+	case rm.destroyed:
+		err = fail(ErrorNoSuchRoom, "No such room (%d)", rm.Room)
+	case rm.Pin != "" && r.Pin != rm.Pin:
+		err = fail(ErrorUnauthorized, "Unauthorized (wrong pin)")
+	case rm.participants[pt.username] != nil:
+		err = fail(ErrorUsernameExists, "Username '%s' already taken", pt.username)
+	}
+	if err != nil {
+		rm.mutex.Unlock()
+		return nil, nil, err
+	}
+	others := rm.list()
+	rm.participants[pt.username] = pt
+	history := rm.history
+	rm.mutex.Unlock()
+	s.mutex.Lock()
+	s.rooms[rm.Room] = pt
+	s.mutex.Unlock()
+
+	log.Infof("[%s] %s joined room %d", Package, pt.username, rm.Room)
+	event := map[string]interface{}{"textroom": "join", "room": rm.Room, "username": pt.username}
+	if pt.display != "" {
+		event["display"] = pt.display
+	}
+	list := make([]map[string]interface{}, 0, len(others))
+	for _, other := range others {
+		p.send(other.s, event)
+		list = append(list, other.describe())
+	}
+	p.notify(s.ps, event)
+	return map[string]interface{}{"textroom": "success", "participants": list}, history, nil
+}
+
+func (p *Plugin) leaveRequest(s *session, body json.RawMessage) (map[string]interface{}, error) {
+	var r struct {
+		Room *uint64 `json:"room"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	pt, err := s.joined(r.Room)
+	if err != nil {
+		return nil, err
+	}
+	p.leave(pt, nil)
+	return map[string]interface{}{"textroom": "success"}, nil
+}
+
+// leave removes a participant from its room, and tells the others with
+// event ("leave" by default)
+func (p *Plugin) leave(pt *participant, event map[string]interface{}) {
+	rm := pt.room
+	rm.mutex.Lock()
+	in := rm.participants[pt.username] == pt
+	if in {
+		delete(rm.participants, pt.username)
+	}
+	list := rm.list()
+	rm.mutex.Unlock()
+	pt.s.mutex.Lock()
+	if pt.s.rooms[rm.Room] == pt {
+		delete(pt.s.rooms, rm.Room)
+	}
+	pt.s.mutex.Unlock()
+	if !in {
+		return
+	}
+	log.Infof("[%s] %s left room %d", Package, pt.username, rm.Room)
+	if event == nil {
+		event = map[string]interface{}{"textroom": "leave", "room": rm.Room, "username": pt.username}
+	}
+	for _, other := range list {
+		p.send(other.s, event)
+	}
+	p.notify(pt.s.ps, map[string]interface{}{"textroom": "leave", "room": rm.Room, "username": pt.username})
+}
+
+// leaveAll leaves all the rooms a session is in
+func (p *Plugin) leaveAll(s *session) {
+	s.mutex.Lock()
+	list := make([]*participant, 0, len(s.rooms))
+	for _, pt := range s.rooms {
+		list = append(list, pt)
+	}
+	s.mutex.Unlock()
+	for _, pt := range list {
+		p.leave(pt, nil)
+	}
+}
+
+// message sends a message to a room, or whispers it to some of its
+// participants
+func (p *Plugin) message(s *session, body json.RawMessage) (map[string]interface{}, error) {
+	var r struct {
+		Room *uint64  `json:"room"`
+		Text *string  `json:"text"`
+		To   *string  `json:"to"`
+		Tos  []string `json:"tos"`
+		Ack  *bool    `json:"ack"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Text == nil {
+		return nil, missing("text")
+	}
+	pt, err := s.joined(r.Room)
+	if err != nil {
+		return nil, err
+	}
+	rm := pt.room
+	var to []string
+	if r.To != nil {
+		to = append(to, *r.To)
+	}
+	to = append(to, r.Tos...)
+	whisper := len(to) > 0
+	msg := map[string]interface{}{
+		"textroom": "message",
+		"room":     rm.Room,
+		"from":     pt.username,
+		"date":     now(),
+		"text":     *r.Text,
+		"whisper":  whisper,
+	}
+
+	rm.mutex.Lock()
+	var list []*participant
+	if whisper {
+		for _, username := range to {
+			other := rm.participants[username]
+			if other == nil {
+				rm.mutex.Unlock()
+				return nil, fail(ErrorNoSuchUser, "No such user %s in room %d", username, rm.Room)
+			}
+			list = append(list, other)
+		}
+	} else {
+		list = rm.list()
+		rm.remember(msg)
+	}
+	post := rm.Post
+	rm.mutex.Unlock()
+	for _, other := range list {
+		p.send(other.s, msg)
+	}
+	if post != "" {
+		go p.post(post, msg)
+	}
+	if r.Ack != nil && !*r.Ack {
+		return nil, nil
+	}
+	return map[string]interface{}{"textroom": "success"}, nil
+}
+
+// post forwards a message to the HTTP endpoint of its room
+func (p *Plugin) post(url string, msg map[string]interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	resp, err := p.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Warnf("[%s] Error posting message to %s: %v", Package, url, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Warnf("[%s] Error posting message to %s: %s", Package, url, resp.Status)
+	}
+}
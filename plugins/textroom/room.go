@@ -0,0 +1,433 @@
+package textroom
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RoomConfig describes a room, both in the configuration and in create
+// requests (where elements have the same names)
+type RoomConfig struct {
+	// Room is the unique id of the room, a random one if 0
+	Room        uint64
+	Description string
+	// Is_private rooms don't show up in list requests
+	Is_private bool
+	// Secret is needed to edit, destroy, kick and make announcements, Pin
+	// to join
+	Secret string
+	Pin    string
+	// History is how many of the last messages new participants get
+	History int
+	// Post is the HTTP URL messages are posted to, if any
+	Post string
+}
+
+type room struct {
+	mutex sync.Mutex
+	RoomConfig
+	// participants by username
+	participants map[string]*participant
+	// history are the last messages, the oldest first
+	history   []map[string]interface{}
+	destroyed bool
+}
+
+func newRoom(rc RoomConfig) (*room, error) {
+	if rc.History < 0 {
+		return nil, invalid("history", "should not be negative")
+	}
+	if rc.Room == 0 {
+		rc.Room = util.RandomUint64()
+	}
+	return &room{RoomConfig: rc, participants: make(map[string]*participant)}, nil
+}
+
+// room returns a room which hasn't been destroyed
+func (p *Plugin) room(id uint64) (*room, error) {
+	p.mutex.Lock()
+	r := p.rooms[id]
+	p.mutex.Unlock()
+	if r == nil {
+		return nil, fail(ErrorNoSuchRoom, "No such room (%d)", id)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.destroyed {
+		return nil, fail(ErrorNoSuchRoom, "No such room (%d)", id)
+	}
+	return r, nil
+}
+
+// save writes the configuration with the permanent rooms; the caller holds
+// the mutex of the plugin
+func (p *Plugin) save() error {
+	data, err := yaml.Marshal(&p.config)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(p.configPath, Package+".yaml"), data, 0644)
+}
+
+// savePermanent replaces (or removes, if rc is nil) a room in the
+// configuration file
+func (p *Plugin) savePermanent(id uint64, rc *RoomConfig) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	rooms := p.config.Rooms[:0:0]
+	for _, c := range p.config.Rooms {
+		if c.Room != id {
+			rooms = append(rooms, c)
+		}
+	}
+	if rc != nil {
+		rooms = append(rooms, *rc)
+	}
+	p.config.Rooms = rooms
+	if err := p.save(); err != nil {
+		log.Errorf("[%s] Error saving the configuration: %v", Package, err)
+		return fail(ErrorUnknown, "Error saving the configuration: %v", err)
+	}
+	return nil
+}
+
+// manage handles the requests about rooms, which come either from the Janus
+// API or from the data channel of s; admin is set for the ones via the
+// Admin API, which need no secrets (s is nil then)
+func (p *Plugin) manage(s *session, request string, body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	switch request {
+	case "create":
+		return p.create(body, admin)
+	case "edit":
+		return p.edit(body, admin)
+	case "destroy":
+		return p.destroy(body, admin)
+	case "exists":
+		return p.exists(body)
+	case "list":
+		return p.list(body, admin)
+	case "listparticipants":
+		return p.listParticipants(body)
+	case "kick":
+		return p.kick(body, admin)
+	case "announcement":
+		return p.announcement(body, admin)
+	}
+	return nil, fail(ErrorInvalidRequest, "Unknown request '%s'", request)
+}
+
+// authorize checks the secret of a room, which the caller holds the mutex of
+func (r *room) authorize(secret string, admin bool) error {
+	if !admin && r.Secret != "" && secret != r.Secret {
+		return fail(ErrorUnauthorized, "Unauthorized (wrong secret)")
+	}
+	return nil
+}
+
+// authorized returns a room, if the secret is the right one
+func (p *Plugin) authorized(id *uint64, secret string, admin bool) (*room, error) {
+	if id == nil {
+		return nil, missing("room")
+	}
+	rm, err := p.room(*id)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	if err := rm.authorize(secret, admin); err != nil {
+		return nil, err
+	}
+	return rm, nil
+}
+
+func (p *Plugin) create(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var rc RoomConfig
+	r := struct {
+		*RoomConfig
+		Admin_key string
+		Permanent bool
+	}{RoomConfig: &rc}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if key := p.config.General.Admin_key; key != "" && !admin && r.Admin_key != key {
+		if r.Admin_key == "" {
+			return nil, missing("admin_key")
+		}
+		return nil, fail(ErrorUnauthorized, "Unauthorized (wrong admin_key)")
+	}
+	rm, err := newRoom(rc)
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	if rc.Room == 0 {
+		for p.rooms[rm.Room] != nil {
+			rm.Room = util.RandomUint64()
+		}
+	} else if p.rooms[rm.Room] != nil {
+		p.mutex.Unlock()
+		return nil, fail(ErrorRoomExists, "Room %d already exists", rm.Room)
+	}
+	p.rooms[rm.Room] = rm
+	p.mutex.Unlock()
+	log.Infof("[%s] Created room %d (%s)", Package, rm.Room, rm.Description)
+	p.notify(nil, map[string]interface{}{"event": "created", "room": rm.Room})
+	if r.Permanent {
+		if err := p.savePermanent(rm.Room, &rm.RoomConfig); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"textroom": "success", "room": rm.Room, "permanent": r.Permanent}, nil
+}
+
+func (p *Plugin) edit(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		Room        *uint64 `json:"room"`
+		Secret      string  `json:"secret"`
+		Description *string `json:"new_description"`
+		IsPrivate   *bool   `json:"new_is_private"`
+		NewSecret   *string `json:"new_secret"`
+		Pin         *string `json:"new_pin"`
+		Post        *string `json:"new_post"`
+		Permanent   bool    `json:"permanent"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	rm, err := p.authorized(r.Room, r.Secret, admin)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	if r.Description != nil && *r.Description != "" {
+		rm.Description = *r.Description
+	}
+	if r.IsPrivate != nil {
+		rm.Is_private = *r.IsPrivate
+	}
+	if r.NewSecret != nil {
+		rm.Secret = *r.NewSecret
+	}
+	if r.Pin != nil {
+		rm.Pin = *r.Pin
+	}
+	if r.Post != nil {
+		rm.Post = *r.Post
+	}
+	rc := rm.RoomConfig
+	rm.mutex.Unlock()
+	log.Infof("[%s] Edited room %d", Package, rc.Room)
+	p.notify(nil, map[string]interface{}{"event": "edited", "room": rc.Room})
+	if r.Permanent {
+		if err := p.savePermanent(rc.Room, &rc); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"textroom": "success", "room": rc.Room, "permanent": r.Permanent}, nil
+}
+
+// destroy tells the participants a room is gone, and removes it
+func (p *Plugin) destroy(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		Room      *uint64 `json:"room"`
+		Secret    string  `json:"secret"`
+		Permanent bool    `json:"permanent"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	rm, err := p.authorized(r.Room, r.Secret, admin)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	rm.destroyed = true
+	list := rm.list()
+	rm.participants = make(map[string]*participant)
+	rm.mutex.Unlock()
+	p.mutex.Lock()
+	delete(p.rooms, rm.Room)
+	p.mutex.Unlock()
+
+	event := map[string]interface{}{"textroom": "destroyed", "room": rm.Room}
+	for _, pt := range list {
+		pt.s.mutex.Lock()
+		delete(pt.s.rooms, rm.Room)
+		pt.s.mutex.Unlock()
+		p.send(pt.s, event)
+	}
+	log.Infof("[%s] Destroyed room %d", Package, rm.Room)
+	p.notify(nil, map[string]interface{}{"event": "destroyed", "room": rm.Room})
+	if r.Permanent {
+		if err := p.savePermanent(rm.Room, nil); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"textroom": "success", "room": rm.Room, "permanent": r.Permanent}, nil
+}
+
+func (p *Plugin) exists(body json.RawMessage) (map[string]interface{}, error) {
+	var r struct {
+		Room *uint64 `json:"room"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Room == nil {
+		return nil, missing("room")
+	}
+	_, err := p.room(*r.Room)
+	return map[string]interface{}{"textroom": "success", "room": *r.Room, "exists": err == nil}, nil
+}
+
+// list returns the public rooms, and the private ones too for admins
+func (p *Plugin) list(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		AdminKey string `json:"admin_key"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if key := p.config.General.Admin_key; key != "" && r.AdminKey == key {
+		admin = true
+	}
+	p.mutex.Lock()
+	rooms := make([]*room, 0, len(p.rooms))
+	for _, rm := range p.rooms {
+		rooms = append(rooms, rm)
+	}
+	p.mutex.Unlock()
+	list := make([]map[string]interface{}, 0, len(rooms))
+	for _, rm := range rooms {
+		rm.mutex.Lock()
+		if !rm.destroyed && (!rm.Is_private || admin) {
+			list = append(list, map[string]interface{}{
+				"room":             rm.Room,
+				"description":      rm.Description,
+				"pin_required":     rm.Pin != "",
+				"history":          rm.History,
+				"num_participants": len(rm.participants),
+			})
+		}
+		rm.mutex.Unlock()
+	}
+	return map[string]interface{}{"textroom": "success", "list": list}, nil
+}
+
+func (p *Plugin) listParticipants(body json.RawMessage) (map[string]interface{}, error) {
+	var r struct {
+		Room *uint64 `json:"room"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Room == nil {
+		return nil, missing("room")
+	}
+	rm, err := p.room(*r.Room)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	list := make([]map[string]interface{}, 0, len(rm.participants))
+	for _, pt := range rm.participants {
+		list = append(list, pt.describe())
+	}
+	rm.mutex.Unlock()
+	return map[string]interface{}{"textroom": "success", "room": *r.Room, "participants": list}, nil
+}
+
+// kick makes a participant leave a room
+func (p *Plugin) kick(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		Room     *uint64 `json:"room"`
+		Secret   string  `json:"secret"`
+		Username *string `json:"username"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Username == nil {
+		return nil, missing("username")
+	}
+	rm, err := p.authorized(r.Room, r.Secret, admin)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	pt := rm.participants[*r.Username]
+	rm.mutex.Unlock()
+	if pt == nil {
+		return nil, fail(ErrorNoSuchUser, "No such user %s in room %d", *r.Username, rm.Room)
+	}
+	event := map[string]interface{}{"textroom": "kicked", "room": rm.Room, "username": pt.username}
+	p.send(pt.s, event)
+	p.leave(pt, event)
+	return map[string]interface{}{"textroom": "success"}, nil
+}
+
+// announcement sends a message to everybody in a room, on behalf of nobody
+func (p *Plugin) announcement(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		Room   *uint64 `json:"room"`
+		Secret string  `json:"secret"`
+		Text   *string `json:"text"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Text == nil {
+		return nil, missing("text")
+	}
+	rm, err := p.authorized(r.Room, r.Secret, admin)
+	if err != nil {
+		return nil, err
+	}
+	msg := map[string]interface{}{"textroom": "announcement", "room": rm.Room, "date": now(), "text": *r.Text}
+	rm.mutex.Lock()
+	rm.remember(msg)
+	list, post := rm.list(), rm.Post
+	rm.mutex.Unlock()
+	for _, pt := range list {
+		p.send(pt.s, msg)
+	}
+	if post != "" {
+		go p.post(post, msg)
+	}
+	return map[string]interface{}{"textroom": "success"}, nil
+}
+
+// remember adds a message to the history of a room, which the caller holds
+// the mutex of
+func (r *room) remember(msg map[string]interface{}) {
+	if r.History <= 0 {
+		return
+	}
+	r.history = append(r.history, msg)
+	if len(r.history) > r.History {
+		r.history = append(r.history[:0:0], r.history[len(r.history)-r.History:]...)
+	}
+}
+
+// list returns the participants of a room; the caller holds its mutex
+func (r *room) list() []*participant {
+	list := make([]*participant, 0, len(r.participants))
+	for _, pt := range r.participants {
+		list = append(list, pt)
+	}
+	return list
+}
+
+// now is the date of messages, as in the original Janus
+func now() string {
+	return time.Now().Format("2006-01-02T15:04:05-0700")
+}
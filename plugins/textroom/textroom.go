@@ -0,0 +1,488 @@
+package textroom
+
+// The TextRoom plugin is a chat over data channels: once the PeerConnection
+// is up (with data channels only), clients join rooms, send public messages
+// and whispers, and get what the others send, all as JSON text messages on
+// the data channel. Requests and events are the ones of janus.plugin.textroom
+// of the original Janus, so the textroom demo of janus.js works unchanged.
+//
+// Over the Janus API, setup gets an offer, to be answered with ack:
+//
+//	{"request": "setup"}, {"request": "ack"} (with the answer), {"request": "restart"}
+//
+// and rooms are managed with synchronous requests, answered right away:
+//
+//	{"request": "create", "room": 1234, "description": "...", "secret": "...",
+//	 "pin": "...", "is_private": false, "history": 0, "post": "http://...",
+//	 "permanent": false}
+//	{"request": "edit", "room": 1234, "secret": "...", "new_description": "...", ...}
+//	{"request": "destroy", "room": 1234, "secret": "...", "permanent": false}
+//	{"request": "exists", "room": 1234}
+//	{"request": "list"}
+//	{"request": "listparticipants", "room": 1234}
+//	{"request": "kick", "room": 1234, "secret": "...", "username": "..."}
+//	{"request": "announcement", "room": 1234, "secret": "...", "text": "..."}
+//
+// On the data channel, requests are named by "textroom", with a transaction
+// to match the response:
+//
+//	{"textroom": "join", "transaction": "...", "room": 1234, "username": "...",
+//	 "display": "...", "pin": "..."}
+//	{"textroom": "message", "transaction": "...", "room": 1234, "text": "...",
+//	 "to": "..." or "tos": ["...", ...] (whispers), "ack": true}
+//	{"textroom": "leave", "transaction": "...", "room": 1234}
+//
+// along with all the synchronous requests above. Messages of rooms with a
+// post URL are also sent there, as HTTP POSTs.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/sdp"
+)
+
+const Package = "janus.plugin.textroom"
+
+// error codes of the events and responses
+const (
+	ErrorNoMessage      = 411
+	ErrorInvalidJSON    = 412
+	ErrorMissingElement = 413
+	ErrorInvalidElement = 414
+	ErrorInvalidRequest = 415
+	ErrorAlreadySetup   = 416
+	ErrorNoSuchRoom     = 417
+	ErrorRoomExists     = 418
+	ErrorUnauthorized   = 419
+	ErrorUsernameExists = 420
+	ErrorAlreadyInRoom  = 421
+	ErrorNotInRoom      = 422
+	ErrorNoSuchUser     = 423
+	ErrorUnknown        = 499
+)
+
+// Config is the content of janus.plugin.textroom.yaml in the configs folder
+type Config struct {
+	General struct {
+		// Admin_key, if set, must be provided to create rooms
+		Admin_key string
+		// Events tells whether to notify the event handlers
+		Events bool
+	}
+	// Rooms are created at startup; permanent rooms created or changed
+	// via the API are saved here too
+	Rooms []RoomConfig
+}
+
+type Plugin struct {
+	config     Config
+	configPath string
+	gateway    plugins.Callbacks
+	messages   chan *message
+	done       chan struct{}
+	// client posts the messages of rooms with a post URL
+	client *http.Client
+
+	// mutex protects rooms, and the rooms of the configuration
+	mutex sync.Mutex
+	rooms map[uint64]*room
+}
+
+// message is an asynchronous request waiting for the handler
+type message struct {
+	ps          *plugins.PluginSession
+	transaction string
+	request     string
+	body        json.RawMessage
+	jsep        *plugins.JSEP
+}
+
+// session is the state of a handle attached to the plugin, which can be in
+// several rooms at once
+type session struct {
+	ps *plugins.PluginSession
+
+	mutex sync.Mutex
+	// offered is set once setup sent an offer
+	offered   bool
+	rooms     map[uint64]*participant
+	hangingUp int32
+	destroyed int32
+}
+
+// requestError is an error along with the code to tell clients about it
+type requestError struct {
+	code int
+	err  error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func fail(code int, format string, args ...interface{}) error {
+	return &requestError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+func errorCode(err error) int {
+	if e, ok := err.(*requestError); ok {
+		return e.code
+	}
+	return ErrorUnknown
+}
+
+// errorEvent describes an error the way clients expect it via the Janus API
+func errorEvent(err error) map[string]interface{} {
+	return map[string]interface{}{"textroom": "event", "error_code": errorCode(err), "error": err.Error()}
+}
+
+// decode parses the body of a request, with the right error codes
+func decode(body json.RawMessage, out interface{}) error {
+	err := plugins.Decode(body, out)
+	switch {
+	case err == nil:
+		return nil
+	case err == plugins.ErrNoMessage:
+		return &requestError{ErrorNoMessage, err}
+	case err == plugins.ErrInvalidJSON:
+		return &requestError{ErrorInvalidJSON, err}
+	}
+	if e, ok := err.(*plugins.ElementError); ok && e.Missing {
+		return &requestError{ErrorMissingElement, err}
+	}
+	return &requestError{ErrorInvalidElement, err}
+}
+
+func missing(name string) error {
+	return &requestError{ErrorMissingElement, plugins.Missing(name)}
+}
+
+func invalid(name, reason string) error {
+	return &requestError{ErrorInvalidElement, plugins.Invalid(name, reason)}
+}
+
+func init() {
+	plugins.Register(New())
+}
+
+// New returns the TextRoom plugin, to be started with Init
+func New() *Plugin {
+	return &Plugin{
+		messages: make(chan *message, 100),
+		done:     make(chan struct{}),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		rooms:    make(map[uint64]*room),
+	}
+}
+
+func (p *Plugin) Package() string { return Package }
+func (p *Plugin) Name() string    { return "JANUS TextRoom plugin" }
+func (p *Plugin) Description() string {
+	return "This is a plugin implementing a text-only room for Janus, using DataChannels."
+}
+func (p *Plugin) Author() string        { return api.Author }
+func (p *Plugin) Version() int          { return api.Version }
+func (p *Plugin) VersionString() string { return api.VersionString }
+
+// Init reads the configuration, creates the rooms it lists and starts the
+// message handler
+func (p *Plugin) Init(gateway plugins.Callbacks, configPath string) error {
+	p.config.General.Events = true
+	if err := config.LoadComponent(configPath, Package, &p.config); err != nil {
+		return err
+	}
+	p.configPath = configPath
+	p.gateway = gateway
+	for _, rc := range p.config.Rooms {
+		r, err := newRoom(rc)
+		if err != nil {
+			log.Warnf("[%s] Skipping room %d: %v", Package, rc.Room, err)
+			continue
+		}
+		if _, ok := p.rooms[r.Room]; ok {
+			log.Warnf("[%s] Skipping room %d: defined twice", Package, rc.Room)
+			continue
+		}
+		p.rooms[r.Room] = r
+		log.Infof("[%s] Created room %d (%s)", Package, r.Room, r.Description)
+	}
+	go p.handler()
+	return nil
+}
+
+func (p *Plugin) Destroy() {
+	select {
+	case <-p.done:
+		return
+	default:
+		close(p.done)
+	}
+	p.mutex.Lock()
+	for id, r := range p.rooms {
+		r.mutex.Lock()
+		r.destroyed = true
+		r.mutex.Unlock()
+		delete(p.rooms, id)
+	}
+	p.mutex.Unlock()
+	log.Infof("%s destroyed", Package)
+}
+
+func getSession(ps *plugins.PluginSession) *session {
+	s, _ := ps.Plugin.(*session)
+	if s == nil || atomic.LoadInt32(&s.destroyed) == 1 {
+		return nil
+	}
+	return s
+}
+
+func (p *Plugin) CreateSession(ps *plugins.PluginSession) error {
+	ps.Plugin = &session{ps: ps, rooms: make(map[uint64]*participant)}
+	return nil
+}
+
+// DestroySession leaves all the rooms the session is in
+func (p *Plugin) DestroySession(ps *plugins.PluginSession) error {
+	s := getSession(ps)
+	if s == nil {
+		return fmt.Errorf("no session associated with this handle")
+	}
+	atomic.StoreInt32(&s.destroyed, 1)
+	p.leaveAll(s)
+	return nil
+}
+
+func (p *Plugin) QuerySession(ps *plugins.PluginSession) interface{} {
+	s := getSession(ps)
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	rooms := make(map[string]interface{}, len(s.rooms))
+	for id, pt := range s.rooms {
+		rooms[fmt.Sprint(id)] = pt.username
+	}
+	offered := s.offered
+	s.mutex.Unlock()
+	return map[string]interface{}{
+		"setup":     offered,
+		"rooms":     rooms,
+		"hangingup": atomic.LoadInt32(&s.hangingUp),
+		"destroyed": atomic.LoadInt32(&s.destroyed),
+	}
+}
+
+// HandleMessage answers the requests about rooms right away, and queues the
+// ones about the PeerConnection for the handler
+func (p *Plugin) HandleMessage(ps *plugins.PluginSession, transaction string, body json.RawMessage, jsep *plugins.JSEP) *plugins.Result {
+	s := getSession(ps)
+	if s == nil {
+		return &plugins.Result{Type: plugins.ResultError, Text: "No session associated with this handle"}
+	}
+	var r struct {
+		Request *string `json:"request"`
+	}
+	if err := decode(body, &r); err != nil {
+		return &plugins.Result{Type: plugins.ResultOK, Content: errorEvent(err)}
+	}
+	if r.Request == nil {
+		return &plugins.Result{Type: plugins.ResultOK, Content: errorEvent(missing("request"))}
+	}
+	switch *r.Request {
+	case "setup", "ack", "restart":
+	default:
+		response, err := p.manage(s, *r.Request, body, false)
+		if err != nil {
+			log.Warnf("[%s] %v", Package, err)
+			response = errorEvent(err)
+		}
+		return &plugins.Result{Type: plugins.ResultOK, Content: response}
+	}
+	select {
+	case p.messages <- &message{ps: ps, transaction: transaction, request: *r.Request, body: body, jsep: jsep}:
+	case <-p.done:
+		return &plugins.Result{Type: plugins.ResultError, Text: "Shutting down"}
+	}
+	return &plugins.Result{Type: plugins.ResultOKWait}
+}
+
+// HandleAdminMessage accepts the requests about rooms via the Admin API
+func (p *Plugin) HandleAdminMessage(body json.RawMessage) interface{} {
+	var r struct {
+		Request *string `json:"request"`
+	}
+	if err := decode(body, &r); err != nil {
+		return errorEvent(err)
+	}
+	if r.Request == nil {
+		return errorEvent(missing("request"))
+	}
+	response, err := p.manage(nil, *r.Request, body, true)
+	if err != nil {
+		log.Warnf("[%s] %v", Package, err)
+		return errorEvent(err)
+	}
+	return response
+}
+
+func (p *Plugin) SetupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil {
+		return
+	}
+	log.Infof("[%s] WebRTC media is now available", Package)
+	atomic.StoreInt32(&s.hangingUp, 0)
+}
+
+// there's nothing but data channels
+func (p *Plugin) IncomingRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket)   {}
+func (p *Plugin) IncomingRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {}
+func (p *Plugin) SlowLink(ps *plugins.PluginSession, uplink, video bool)             {}
+
+// IncomingData handles the requests sent on the data channel
+func (p *Plugin) IncomingData(ps *plugins.PluginSession, packet *plugins.DataPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 || len(packet.Buffer) == 0 {
+		return
+	}
+	var r struct {
+		Request     *string `json:"textroom"`
+		Transaction *string `json:"transaction"`
+	}
+	err := decode(packet.Buffer, &r)
+	switch {
+	case err != nil:
+	case r.Request == nil:
+		err = missing("textroom")
+	case r.Transaction == nil:
+		err = missing("transaction")
+	}
+	var response map[string]interface{}
+	var history []map[string]interface{}
+	if err == nil {
+		response, history, err = p.incoming(s, *r.Request, packet.Buffer)
+	}
+	if err != nil {
+		log.Warnf("[%s] %v", Package, err)
+		response = map[string]interface{}{"textroom": "error", "error_code": errorCode(err), "error": err.Error()}
+	}
+	if response == nil {
+		return
+	}
+	if r.Transaction != nil {
+		response["transaction"] = *r.Transaction
+	}
+	p.send(s, response)
+	// the history of a room comes after the response to the join
+	for _, msg := range history {
+		p.send(s, msg)
+	}
+}
+
+// incoming handles a request of the data channel: chat requests, or the
+// ones about rooms; joins also return the history of the room
+func (p *Plugin) incoming(s *session, request string, body json.RawMessage) (map[string]interface{}, []map[string]interface{}, error) {
+	var response map[string]interface{}
+	var err error
+	switch request {
+	case "join":
+		return p.join(s, body)
+	case "leave":
+		response, err = p.leaveRequest(s, body)
+	case "message":
+		response, err = p.message(s, body)
+	default:
+		response, err = p.manage(s, request, body, false)
+	}
+	return response, nil, err
+}
+
+// send sends a JSON message on the data channel of a session
+func (p *Plugin) send(s *session, msg map[string]interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Errorf("[%s] Error encoding message: %v", Package, err)
+		return
+	}
+	p.gateway.RelayData(s.ps, &plugins.DataPacket{Buffer: data})
+}
+
+// HangupMedia leaves all the rooms, as the data channel is gone
+func (p *Plugin) HangupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil || !atomic.CompareAndSwapInt32(&s.hangingUp, 0, 1) {
+		return
+	}
+	log.Infof("[%s] No WebRTC media anymore", Package)
+	p.leaveAll(s)
+	s.mutex.Lock()
+	s.offered = false
+	s.mutex.Unlock()
+}
+
+func (p *Plugin) notify(ps *plugins.PluginSession, event map[string]interface{}) {
+	if p.config.General.Events && p.gateway.EventsIsEnabled() {
+		p.gateway.NotifyEvent(p, ps, event)
+	}
+}
+
+// handler processes the setup requests, one at a time
+func (p *Plugin) handler() {
+	for {
+		select {
+		case m := <-p.messages:
+			p.handle(m)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Plugin) handle(m *message) {
+	s := getSession(m.ps)
+	if s == nil {
+		log.Warnf("[%s] No session associated with this handle", Package)
+		return
+	}
+	var (
+		jsep *plugins.JSEP
+		err  error
+	)
+	switch m.request {
+	case "setup", "restart":
+		s.mutex.Lock()
+		offered := s.offered
+		s.offered = true
+		s.mutex.Unlock()
+		if offered && m.request == "setup" {
+			err = fail(ErrorAlreadySetup, "PeerConnection already setup")
+			break
+		}
+		offer := sdp.GenerateOffer(sdp.OfferOptions{Name: "TextRoom", Data: true})
+		jsep = &plugins.JSEP{Type: "offer", SDP: offer.String()}
+	case "ack":
+		if m.jsep == nil {
+			break
+		}
+		if m.jsep.Type != "answer" {
+			err = fail(ErrorInvalidElement, "Unexpected %s, clients send answers", m.jsep.Type)
+		} else if _, e := sdp.Parse(m.jsep.SDP); e != nil {
+			err = fail(ErrorInvalidElement, "Error parsing answer: %v", e)
+		}
+	}
+	event := map[string]interface{}{"textroom": "event", "result": "ok"}
+	if err != nil {
+		log.Warnf("[%s] %v", Package, err)
+		event = errorEvent(err)
+	}
+	if err := p.gateway.PushEvent(m.ps, p, m.transaction, event, jsep); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+}
@@ -0,0 +1,321 @@
+package textroom
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/plugins"
+)
+
+// testGateway stands in for the core: the events of the plugin and what it
+// sends on the data channel go to the testHandle each handle has in its
+// Gateway
+type testGateway struct {
+	plugins.Callbacks
+}
+
+type testHandle struct {
+	events chan testEvent
+	data   chan map[string]interface{}
+}
+
+type testEvent struct {
+	transaction string
+	data        map[string]interface{}
+	jsep        *plugins.JSEP
+}
+
+func (g *testGateway) PushEvent(ps *plugins.PluginSession, plugin plugins.Plugin, transaction string, message interface{}, jsep *plugins.JSEP) error {
+	e := testEvent{transaction: transaction, jsep: jsep}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &e.data); err != nil {
+		return err
+	}
+	ps.Gateway.(*testHandle).events <- e
+	return nil
+}
+
+func (g *testGateway) RelayData(ps *plugins.PluginSession, packet *plugins.DataPacket) {
+	var m map[string]interface{}
+	json.Unmarshal(packet.Buffer, &m)
+	ps.Gateway.(*testHandle).data <- m
+}
+
+func (g *testGateway) RelayRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket)   {}
+func (g *testGateway) RelayRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {}
+func (g *testGateway) ClosePC(ps *plugins.PluginSession)                               {}
+func (g *testGateway) EventsIsEnabled() bool                                           { return false }
+
+func startPlugin(t *testing.T) *Plugin {
+	p := New()
+	if err := p.Init(&testGateway{}, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Destroy)
+	return p
+}
+
+// attach creates a handle, whose events can be taken with next and what it
+// gets on the data channel with received
+func attach(t *testing.T, p *Plugin) *plugins.PluginSession {
+	ps := plugins.NewPluginSession(&testHandle{events: make(chan testEvent, 100), data: make(chan map[string]interface{}, 100)})
+	if err := p.CreateSession(ps); err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+func next(t *testing.T, ps *plugins.PluginSession) testEvent {
+	t.Helper()
+	select {
+	case e := <-ps.Gateway.(*testHandle).events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return testEvent{}
+}
+
+func received(t *testing.T, ps *plugins.PluginSession) map[string]interface{} {
+	t.Helper()
+	select {
+	case m := <-ps.Gateway.(*testHandle).data:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received on the data channel")
+	}
+	return nil
+}
+
+// request sends a synchronous request, and returns the response
+func request(t *testing.T, p *Plugin, ps *plugins.PluginSession, body string) map[string]interface{} {
+	t.Helper()
+	r := p.HandleMessage(ps, "", json.RawMessage(body), nil)
+	if r.Type != plugins.ResultOK {
+		t.Fatalf("got %+v sending %s", r, body)
+	}
+	data, _ := json.Marshal(r.Content)
+	var response map[string]interface{}
+	json.Unmarshal(data, &response)
+	return response
+}
+
+// send sends an asynchronous request, and returns the event answering it
+func send(t *testing.T, p *Plugin, ps *plugins.PluginSession, body string, jsep *plugins.JSEP) testEvent {
+	t.Helper()
+	if r := p.HandleMessage(ps, "t", json.RawMessage(body), jsep); r.Type != plugins.ResultOKWait {
+		t.Fatalf("got %+v sending %s", r, body)
+	}
+	for {
+		if e := next(t, ps); e.transaction == "t" {
+			return e
+		}
+	}
+}
+
+// chat sends a request on the data channel, and returns the response to it
+func chat(t *testing.T, p *Plugin, ps *plugins.PluginSession, body string) map[string]interface{} {
+	t.Helper()
+	p.IncomingData(ps, &plugins.DataPacket{Buffer: []byte(body)})
+	for {
+		if m := received(t, ps); m["transaction"] == "t" {
+			return m
+		}
+	}
+}
+
+func code(m map[string]interface{}) int {
+	c, _ := m["error_code"].(float64)
+	return int(c)
+}
+
+const answer = "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n" +
+	"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\nc=IN IP4 0.0.0.0\r\na=mid:0\r\na=sctp-port:5000\r\n"
+
+func TestRooms(t *testing.T) {
+	p := startPlugin(t)
+	ps := attach(t, p)
+	tests := []struct {
+		name string
+		body string
+		want string // the textroom element of the response
+		code int
+	}{
+		{"create", `{"request":"create","room":1234,"secret":"s","description":"test"}`, "success", 0},
+		{"create again", `{"request":"create","room":1234}`, "", ErrorRoomExists},
+		{"negative history", `{"request":"create","history":-1}`, "", ErrorInvalidElement},
+		{"exists", `{"request":"exists","room":1234}`, "success", 0},
+		{"list", `{"request":"list"}`, "success", 0},
+		{"edit without the secret", `{"request":"edit","room":1234,"new_description":"x"}`, "", ErrorUnauthorized},
+		{"edit", `{"request":"edit","room":1234,"secret":"s","new_description":"x"}`, "success", 0},
+		{"listparticipants", `{"request":"listparticipants","room":1234}`, "success", 0},
+		{"no such room", `{"request":"listparticipants","room":42}`, "", ErrorNoSuchRoom},
+		{"missing room", `{"request":"destroy"}`, "", ErrorMissingElement},
+		{"kick nobody", `{"request":"kick","room":1234,"secret":"s","username":"bob"}`, "", ErrorNoSuchUser},
+		{"announcement without text", `{"request":"announcement","room":1234,"secret":"s"}`, "", ErrorMissingElement},
+		{"announcement", `{"request":"announcement","room":1234,"secret":"s","text":"hi"}`, "success", 0},
+		{"unknown request", `{"request":"dance"}`, "", ErrorInvalidRequest},
+		{"no request", `{}`, "", ErrorMissingElement},
+		{"invalid request", `{"request":5}`, "", ErrorInvalidElement},
+		{"destroy", `{"request":"destroy","room":1234,"secret":"s"}`, "success", 0},
+		{"destroy again", `{"request":"destroy","room":1234,"secret":"s"}`, "", ErrorNoSuchRoom},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := request(t, p, ps, test.body)
+			if code(r) != test.code {
+				t.Fatalf("got %v, want error code %d", r, test.code)
+			}
+			if test.want != "" && r["textroom"] != test.want {
+				t.Errorf("got %v, want %s", r, test.want)
+			}
+		})
+	}
+}
+
+func TestSetup(t *testing.T) {
+	p := startPlugin(t)
+	ps := attach(t, p)
+	e := send(t, p, ps, `{"request":"setup"}`, nil)
+	if e.data["result"] != "ok" || e.jsep == nil || e.jsep.Type != "offer" {
+		t.Fatalf("got %v %+v setting up", e.data, e.jsep)
+	}
+	if e := send(t, p, ps, `{"request":"setup"}`, nil); code(e.data) != ErrorAlreadySetup {
+		t.Errorf("got %v setting up twice", e.data)
+	}
+	if e := send(t, p, ps, `{"request":"ack"}`, &plugins.JSEP{Type: "offer", SDP: answer}); code(e.data) != ErrorInvalidElement {
+		t.Errorf("got %v acking with an offer", e.data)
+	}
+	if e := send(t, p, ps, `{"request":"ack"}`, &plugins.JSEP{Type: "answer", SDP: answer}); e.data["result"] != "ok" {
+		t.Errorf("got %v acking", e.data)
+	}
+	if e := send(t, p, ps, `{"request":"restart"}`, nil); e.jsep == nil || e.jsep.Type != "offer" {
+		t.Errorf("got %v %+v restarting", e.data, e.jsep)
+	}
+}
+
+func TestChat(t *testing.T) {
+	p := startPlugin(t)
+	admin := attach(t, p)
+	request(t, p, admin, `{"request":"create","room":1,"secret":"s","pin":"1234","history":1}`)
+	request(t, p, admin, `{"request":"announcement","room":1,"secret":"s","text":"welcome"}`)
+
+	alice := attach(t, p)
+	if r := chat(t, p, alice, `{"textroom":"join","transaction":"t","room":1,"username":"alice","pin":"1234"}`); r["textroom"] != "success" {
+		t.Fatalf("got %v joining", r)
+	}
+	// the history comes after the response
+	if m := received(t, alice); m["text"] != "welcome" {
+		t.Errorf("got %v, want the history", m)
+	}
+
+	bob := attach(t, p)
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"missing transaction", `{"textroom":"join","room":1}`, ErrorMissingElement},
+		{"missing room", `{"textroom":"join","transaction":"t","username":"bob"}`, ErrorMissingElement},
+		{"missing username", `{"textroom":"join","transaction":"t","room":1}`, ErrorMissingElement},
+		{"empty username", `{"textroom":"join","transaction":"t","room":1,"username":""}`, ErrorInvalidElement},
+		{"no such room", `{"textroom":"join","transaction":"t","room":42,"username":"bob"}`, ErrorNoSuchRoom},
+		{"wrong pin", `{"textroom":"join","transaction":"t","room":1,"username":"bob","pin":"0000"}`, ErrorUnauthorized},
+		{"username taken", `{"textroom":"join","transaction":"t","room":1,"username":"alice","pin":"1234"}`, ErrorUsernameExists},
+		{"message before joining", `{"textroom":"message","transaction":"t","room":1,"text":"hi"}`, ErrorNotInRoom},
+		{"leave before joining", `{"textroom":"leave","transaction":"t","room":1}`, ErrorNotInRoom},
+		{"unknown request", `{"textroom":"dance","transaction":"t"}`, ErrorInvalidRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p.IncomingData(bob, &plugins.DataPacket{Buffer: []byte(test.body)})
+			if m := received(t, bob); m["textroom"] != "error" || code(m) != test.code {
+				t.Errorf("got %v, want error code %d", m, test.code)
+			}
+		})
+	}
+
+	r := chat(t, p, bob, `{"textroom":"join","transaction":"t","room":1,"username":"bob","display":"Bob","pin":"1234"}`)
+	if list, _ := r["participants"].([]interface{}); len(list) != 1 || list[0].(map[string]interface{})["username"] != "alice" {
+		t.Errorf("got %v, want alice in the room", r)
+	}
+	received(t, bob) // the history
+	if m := received(t, alice); m["textroom"] != "join" || m["username"] != "bob" {
+		t.Errorf("got %v, want bob joining", m)
+	}
+	if r := chat(t, p, bob, `{"textroom":"join","transaction":"t","room":1,"username":"bobby","pin":"1234"}`); code(r) != ErrorAlreadyInRoom {
+		t.Errorf("got %v joining twice", r)
+	}
+
+	// messages go to everybody (the sender too, before the response),
+	// whispers only to who they're for
+	p.IncomingData(alice, &plugins.DataPacket{Buffer: []byte(`{"textroom":"message","transaction":"t","room":1,"text":"hello"}`)})
+	if m := received(t, alice); m["text"] != "hello" {
+		t.Errorf("got %v, want the message", m)
+	}
+	if r := received(t, alice); r["textroom"] != "success" {
+		t.Errorf("got %v sending a message", r)
+	}
+	if m := received(t, bob); m["text"] != "hello" || m["from"] != "alice" || m["whisper"] != false {
+		t.Errorf("got %v, want the message", m)
+	}
+	if r := chat(t, p, alice, `{"textroom":"message","transaction":"t","room":1,"text":"psst","to":"carol"}`); code(r) != ErrorNoSuchUser {
+		t.Errorf("got %v whispering to nobody", r)
+	}
+	chat(t, p, alice, `{"textroom":"message","transaction":"t","room":1,"text":"psst","to":"bob"}`)
+	if m := received(t, bob); m["text"] != "psst" || m["whisper"] != true {
+		t.Errorf("got %v, want the whisper", m)
+	}
+	if r := request(t, p, admin, `{"request":"listparticipants","room":1}`); len(r["participants"].([]interface{})) != 2 {
+		t.Errorf("got %v listing", r)
+	}
+
+	if r := request(t, p, admin, `{"request":"kick","room":1,"username":"bob"}`); code(r) != ErrorUnauthorized {
+		t.Errorf("got %v kicking without the secret", r)
+	}
+	request(t, p, admin, `{"request":"kick","room":1,"secret":"s","username":"bob"}`)
+	if m := received(t, bob); m["textroom"] != "kicked" {
+		t.Errorf("got %v, want bob kicked", m)
+	}
+	if m := received(t, alice); m["textroom"] != "kicked" || m["username"] != "bob" {
+		t.Errorf("got %v, want bob kicked", m)
+	}
+	if r := chat(t, p, alice, `{"textroom":"leave","transaction":"t","room":1}`); r["textroom"] != "success" {
+		t.Errorf("got %v leaving", r)
+	}
+
+	// destroying the handle leaves the rooms too
+	chat(t, p, alice, `{"textroom":"join","transaction":"t","room":1,"username":"alice","pin":"1234"}`)
+	if err := p.DestroySession(alice); err != nil {
+		t.Fatal(err)
+	}
+	if r := request(t, p, admin, `{"request":"listparticipants","room":1}`); len(r["participants"].([]interface{})) != 0 {
+		t.Errorf("got %v once the handle was destroyed", r)
+	}
+	if r := p.HandleMessage(alice, "t", json.RawMessage(`{"request":"list"}`), nil); r.Type != plugins.ResultError {
+		t.Errorf("got %+v after destroying the handle", r)
+	}
+}
+
+func TestDestroyRoom(t *testing.T) {
+	p := startPlugin(t)
+	admin := attach(t, p)
+	request(t, p, admin, `{"request":"create","room":1,"secret":"s"}`)
+	alice := attach(t, p)
+	chat(t, p, alice, `{"textroom":"join","transaction":"t","room":1,"username":"alice"}`)
+
+	// the participants are told, and are out
+	if r := request(t, p, admin, `{"request":"destroy","room":1,"secret":"s"}`); r["textroom"] != "success" {
+		t.Fatalf("got %v destroying", r)
+	}
+	if m := received(t, alice); m["textroom"] != "destroyed" {
+		t.Errorf("got %v, want the room destroyed", m)
+	}
+	if r := chat(t, p, alice, `{"textroom":"message","transaction":"t","room":1,"text":"hi"}`); code(r) != ErrorNotInRoom {
+		t.Errorf("got %v sending to a destroyed room", r)
+	}
+}
@@ -0,0 +1,453 @@
+package videocall
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/record"
+	"github.com/xroger88/go-janus/rtcp"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sdp"
+)
+
+// setCall changes the call of a session; the caller holds the mutex of the
+// plugin
+func (s *session) setCall(peer *session, incoming bool) {
+	s.mutex.Lock()
+	s.peer, s.incoming = peer, incoming
+	s.mutex.Unlock()
+}
+
+func (p *Plugin) list() map[string]interface{} {
+	p.mutex.Lock()
+	list := make([]string, 0, len(p.users))
+	for username := range p.users {
+		list = append(list, username)
+	}
+	p.mutex.Unlock()
+	sort.Strings(list)
+	return map[string]interface{}{"list": list}
+}
+
+func (p *Plugin) register(s *session, body json.RawMessage) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Username *string `json:"username"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Username == nil {
+		return nil, nil, missing("username")
+	}
+	if *r.Username == "" {
+		return nil, nil, invalid("username", "should not be empty")
+	}
+	p.mutex.Lock()
+	switch {
+	case s.username != "":
+		p.mutex.Unlock()
+		return nil, nil, fail(ErrorAlreadyRegistered, "Already registered (%s)", s.username)
+	case p.users[*r.Username] != nil:
+		p.mutex.Unlock()
+		return nil, nil, fail(ErrorUsernameTaken, "Username '%s' already taken", *r.Username)
+	}
+	s.mutex.Lock()
+	s.username = *r.Username
+	s.mutex.Unlock()
+	p.users[s.username] = s
+	p.mutex.Unlock()
+	log.Infof("[%s] Registered %s", Package, *r.Username)
+	p.notify(s.ps, map[string]interface{}{"event": "registered", "username": *r.Username})
+	return map[string]interface{}{"event": "registered", "username": *r.Username}, nil, nil
+}
+
+// call sends the offer of a user to another one, unless busy
+func (p *Plugin) call(s *session, body json.RawMessage, jsep *plugins.JSEP) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Username *string `json:"username"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Username == nil {
+		return nil, nil, missing("username")
+	}
+	offer, err := parseJSEP(jsep, "offer")
+	if err != nil {
+		return nil, nil, err
+	}
+	p.mutex.Lock()
+	peer := p.users[*r.Username]
+	switch {
+	case s.username == "":
+		err = fail(ErrorRegisterFirst, "Register a username first")
+	case *r.Username == s.username:
+		err = fail(ErrorUseEchoTest, "You can't call yourself... use the EchoTest for that")
+	case s.peer != nil:
+		err = fail(ErrorAlreadyInCall, "Already in a call")
+	case peer == nil:
+		err = fail(ErrorNoSuchUsername, "Username '%s' doesn't exist", *r.Username)
+	}
+	if err != nil {
+		p.mutex.Unlock()
+		return nil, nil, err
+	}
+	username := s.username
+	if peer.peer != nil {
+		p.mutex.Unlock()
+		log.Infof("[%s] %s is busy", Package, peer.username)
+		p.gateway.ClosePC(s.ps)
+		return map[string]interface{}{"event": "hangup", "username": username, "reason": "User busy"}, nil, nil
+	}
+	s.setCall(peer, false)
+	peer.setCall(s, true)
+	p.mutex.Unlock()
+
+	s.mutex.Lock()
+	s.simulcast = simulcastOf(offer)
+	s.mutex.Unlock()
+	log.Infof("[%s] %s is calling %s", Package, username, peer.username)
+	event := map[string]interface{}{"videocall": "event", "result": map[string]interface{}{"event": "incomingcall", "username": username}}
+	if err := p.gateway.PushEvent(peer.ps, p, "", event, relayed(jsep, offer)); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+	p.notify(s.ps, map[string]interface{}{"event": "calling", "username": username, "peer": peer.username})
+	return map[string]interface{}{"event": "calling"}, nil, nil
+}
+
+// accept sends the answer of a user to the caller
+func (p *Plugin) accept(s *session, jsep *plugins.JSEP) (map[string]interface{}, *plugins.JSEP, error) {
+	p.mutex.Lock()
+	peer, incoming, username := s.peer, s.incoming, s.username
+	p.mutex.Unlock()
+	switch {
+	case username == "":
+		return nil, nil, fail(ErrorRegisterFirst, "Register a username first")
+	case peer == nil || !incoming:
+		return nil, nil, fail(ErrorNoCall, "No incoming call to accept")
+	}
+	answer, err := parseJSEP(jsep, "answer")
+	if err != nil {
+		return nil, nil, err
+	}
+	p.mutex.Lock()
+	if s.peer != peer {
+		p.mutex.Unlock()
+		return nil, nil, fail(ErrorNoCall, "No incoming call to accept")
+	}
+	s.setCall(peer, false)
+	p.mutex.Unlock()
+
+	s.mutex.Lock()
+	s.simulcast = simulcastOf(answer)
+	s.mutex.Unlock()
+	p.negotiated(s, answer)
+	p.negotiated(peer, answer)
+	log.Infof("[%s] %s is accepting a call from %s", Package, username, peer.username)
+	event := map[string]interface{}{"videocall": "event", "result": map[string]interface{}{"event": "accepted", "username": username}}
+	if err := p.gateway.PushEvent(peer.ps, p, "", event, relayed(jsep, answer)); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+	p.notify(s.ps, map[string]interface{}{"event": "accepted", "username": username, "peer": peer.username})
+	return map[string]interface{}{"event": "accepted", "username": peer.username}, nil, nil
+}
+
+// set changes what a user sends and gets, and relays a renegotiation to
+// its peer
+func (p *Plugin) set(s *session, body json.RawMessage, jsep *plugins.JSEP) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Audio     *bool   `json:"audio"`
+		Video     *bool   `json:"video"`
+		Bitrate   *uint32 `json:"bitrate"`
+		Record    *bool   `json:"record"`
+		Filename  *string `json:"filename"`
+		Substream *int    `json:"substream"`
+		Temporal  *int    `json:"temporal"`
+		Fallback  *uint   `json:"fallback"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Substream != nil && (*r.Substream < 0 || *r.Substream > 2) {
+		return nil, nil, invalid("substream", "should be 0, 1 or 2")
+	}
+	if r.Temporal != nil && (*r.Temporal < 0 || *r.Temporal > 2) {
+		return nil, nil, invalid("temporal", "should be 0, 1 or 2")
+	}
+	p.mutex.Lock()
+	peer, incoming := s.peer, s.incoming
+	p.mutex.Unlock()
+	var desc *sdp.SDP
+	if jsep != nil {
+		if peer == nil || incoming {
+			return nil, nil, fail(ErrorNoCall, "No call to renegotiate")
+		}
+		var err error
+		if desc, err = parseJSEP(jsep, jsep.Type); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	s.mutex.Lock()
+	keyframe, peerKeyframe := false, false
+	if r.Audio != nil {
+		s.audioActive = *r.Audio
+		log.Infof("[%s] Setting audio property: %v", Package, s.audioActive)
+	}
+	if r.Video != nil {
+		if !s.videoActive && *r.Video {
+			// video is back: the peer needs a keyframe to show it
+			keyframe = true
+		}
+		s.videoActive = *r.Video
+		log.Infof("[%s] Setting video property: %v", Package, s.videoActive)
+	}
+	var remb uint32
+	if r.Bitrate != nil {
+		s.bitrate = *r.Bitrate
+		remb = s.bitrate
+		log.Infof("[%s] Setting video bitrate: %d", Package, s.bitrate)
+	}
+	if r.Substream != nil {
+		s.sim.SubstreamTarget = *r.Substream
+		peerKeyframe = true
+	}
+	if r.Temporal != nil {
+		s.sim.TemporalTarget = *r.Temporal
+		peerKeyframe = true
+	}
+	if r.Fallback != nil {
+		s.sim.Fallback = time.Duration(*r.Fallback) * time.Microsecond
+	}
+	if desc != nil {
+		s.simulcast = simulcastOf(desc)
+	}
+	if r.Filename != nil {
+		s.filename = *r.Filename
+	}
+	if r.Record != nil {
+		s.recording = *r.Record
+	}
+	s.mutex.Unlock()
+	if desc != nil && jsep.Type == "answer" {
+		p.negotiated(s, desc)
+		p.negotiated(peer, desc)
+	}
+	started, err := p.updateRecording(s)
+	if err != nil {
+		return nil, nil, fail(ErrorUnknown, "Error starting recording: %v", err)
+	}
+	keyframe = keyframe || started
+
+	if peer != nil && !incoming {
+		if remb > 0 {
+			// that's what the peer sends us
+			p.gateway.RelayRTCP(peer.ps, &plugins.RTCPPacket{Video: true, Mindex: -1, Buffer: rtcp.NewREMB(remb)})
+		}
+		if peerKeyframe {
+			p.sendPLI(peer.ps)
+		}
+	}
+	if keyframe {
+		p.sendPLI(s.ps)
+	}
+	if desc != nil {
+		event := map[string]interface{}{"videocall": "event", "result": map[string]interface{}{"event": "update"}}
+		if err := p.gateway.PushEvent(peer.ps, p, "", event, relayed(jsep, desc)); err != nil {
+			log.Warnf("[%s] Error pushing event: %v", Package, err)
+		}
+	}
+	s.mutex.Lock()
+	event := map[string]interface{}{
+		"event":        "set",
+		"username":     s.username,
+		"audio_active": s.audioActive,
+		"video_active": s.videoActive,
+		"bitrate":      s.bitrate,
+		"record":       s.recording,
+	}
+	s.mutex.Unlock()
+	p.notify(s.ps, event)
+	return map[string]interface{}{"event": "set"}, nil, nil
+}
+
+// hangupRequest ends the call of a user, or rejects an incoming one
+func (p *Plugin) hangupRequest(s *session, body json.RawMessage) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Reason *string `json:"reason"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, nil, err
+	}
+	reason, peerReason := "Explicit hangup", "Remote hangup"
+	if r.Reason != nil {
+		reason, peerReason = *r.Reason, *r.Reason
+	}
+	if p.hangup(s, peerReason) == nil {
+		log.Warnf("[%s] No call to hang up", Package)
+	}
+	p.gateway.ClosePC(s.ps)
+	p.mutex.Lock()
+	username := s.username
+	p.mutex.Unlock()
+	return map[string]interface{}{"event": "hangup", "username": username, "reason": reason}, nil, nil
+}
+
+// hangup ends the call of a session, if any: the peer gets a hangup event
+// with reason, and its PeerConnection is closed. It returns the peer.
+func (p *Plugin) hangup(s *session, reason string) *session {
+	p.mutex.Lock()
+	peer, username := s.peer, s.username
+	if peer == nil {
+		p.mutex.Unlock()
+		return nil
+	}
+	s.setCall(nil, false)
+	if peer.peer == s {
+		peer.setCall(nil, false)
+	}
+	p.mutex.Unlock()
+	log.Infof("[%s] %s is hanging up the call with %s (%s)", Package, username, peer.username, reason)
+
+	for _, ss := range []*session{s, peer} {
+		ss.mutex.Lock()
+		ss.stopRecording()
+		ss.recording = false
+		ss.mutex.Unlock()
+	}
+	event := map[string]interface{}{"videocall": "event", "result": map[string]interface{}{"event": "hangup", "username": username, "reason": reason}}
+	if err := p.gateway.PushEvent(peer.ps, p, "", event, nil); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+	p.gateway.ClosePC(peer.ps)
+	p.notify(s.ps, map[string]interface{}{"event": "hangup", "username": username, "peer": peer.username, "reason": reason})
+	return peer
+}
+
+// parseJSEP checks that there's a JSEP of the right type, and parses it
+func parseJSEP(jsep *plugins.JSEP, kind string) (*sdp.SDP, error) {
+	if jsep == nil {
+		return nil, fail(ErrorMissingSDP, "Missing SDP")
+	}
+	if jsep.Type != kind || (kind != "offer" && kind != "answer") {
+		return nil, fail(ErrorInvalidSDP, "Unexpected %s, an %s is needed", jsep.Type, kind)
+	}
+	desc, err := sdp.Parse(jsep.SDP)
+	if err != nil {
+		return nil, fail(ErrorInvalidSDP, "Error parsing %s: %v", kind, err)
+	}
+	return desc, nil
+}
+
+// relayed returns a JSEP for the peer: simulcast stops at the plugin, which
+// relays a single substream
+func relayed(jsep *plugins.JSEP, desc *sdp.SDP) *plugins.JSEP {
+	for _, m := range desc.MLines {
+		if m.Type != sdp.Video {
+			continue
+		}
+		m.RemoveAttributes("rid")
+		m.RemoveAttributes("simulcast")
+		kept := m.Attributes[:0]
+		for _, a := range m.Attributes {
+			if a.Name != "ssrc-group" || !strings.HasPrefix(a.Value, "SIM ") {
+				kept = append(kept, a)
+			}
+		}
+		m.Attributes = kept
+	}
+	return &plugins.JSEP{Type: jsep.Type, SDP: desc.String(), Trickle: jsep.Trickle}
+}
+
+// simulcastOf returns the simulcast video a description sends, if any
+func simulcastOf(desc *sdp.SDP) rtp.Simulcast {
+	m := desc.MLine(sdp.Video)
+	if m == nil || m.Disabled() {
+		return rtp.Simulcast{}
+	}
+	return rtp.Simulcast{SSRCs: m.SimulcastSSRCs(), Rids: m.Rids(), RidExtID: m.ExtmapID(sdp.ExtRid)}
+}
+
+// negotiated takes note of the codecs of the call, from the answer, and
+// starts recording if asked to
+func (p *Plugin) negotiated(s *session, answer *sdp.SDP) {
+	s.mutex.Lock()
+	s.audioCodec, s.videoCodec, s.hasData = "", "", false
+	for _, m := range answer.MLines {
+		if m.Port == 0 {
+			continue
+		}
+		switch m.Type {
+		case sdp.Audio:
+			if s.audioCodec == "" {
+				s.audioCodec = m.FirstCodec()
+			}
+		case sdp.Video:
+			if s.videoCodec == "" {
+				s.videoCodec = m.FirstCodec()
+			}
+		case sdp.Application:
+			s.hasData = true
+		}
+	}
+	s.mutex.Unlock()
+	started, err := p.updateRecording(s)
+	if err != nil {
+		log.Errorf("[%s] Error starting recording: %v", Package, err)
+	}
+	if started {
+		p.sendPLI(s.ps)
+	}
+}
+
+// updateRecording starts or stops recording what a user sends, as asked,
+// and tells whether a video recording just started
+func (p *Plugin) updateRecording(s *session) (bool, error) {
+	p.mutex.Lock()
+	username, peer := s.username, ""
+	if s.peer != nil {
+		peer = s.peer.username
+	}
+	p.mutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.recording {
+		s.stopRecording()
+		return false, nil
+	}
+	base := s.filename
+	if base == "" {
+		base = fmt.Sprintf("videocall-%s-%s-%d", username, peer, time.Now().Unix())
+	}
+	var err error
+	videoStarted := false
+	if s.audioCodec != "" && s.arc == nil {
+		s.arc, err = record.New("", base+"-audio", record.Audio, s.audioCodec, "")
+	}
+	if err == nil && s.videoCodec != "" && s.vrc == nil {
+		s.vrc, err = record.New("", base+"-video", record.Video, s.videoCodec, "")
+		videoStarted = err == nil
+	}
+	if err == nil && s.hasData && s.drc == nil {
+		s.drc, err = record.New("", base+"-data", record.Data, "text", "")
+	}
+	if err != nil {
+		s.recording = false
+		s.stopRecording()
+	}
+	return videoStarted, err
+}
+
+func (s *session) stopRecording() {
+	for _, r := range []**record.Recorder{&s.arc, &s.vrc, &s.drc} {
+		if *r != nil {
+			(*r).Close()
+			*r = nil
+		}
+	}
+}
@@ -0,0 +1,544 @@
+package videocall
+
+// The VideoCall plugin puts two users in a 1:1 call: each registers a
+// username, one calls the other with an offer, and the other accepts with
+// an answer. The SDPs are relayed between the two peers as they are (but for
+// simulcast, which only goes as far as the plugin), and so is the media, going
+// through the gateway. Requests and events are the ones of
+// janus.plugin.videocall of the original Janus, so the videocall demo of
+// janus.js works unchanged:
+//
+//	{"request": "list"}
+//	{"request": "register", "username": "..."}
+//	{"request": "call", "username": "..."} (with an offer)
+//	{"request": "accept"} (with an answer)
+//	{"request": "set", "audio": true, "video": true, "bitrate": 128000,
+//	 "record": true, "filename": "/path/to/recording",
+//	 "substream": 0, "temporal": 1, "fallback": 250000} (with a JSEP to renegotiate)
+//	{"request": "hangup", "reason": "..."}
+//
+// audio and video tell whether what the user sends gets to the peer, bitrate
+// caps what the peer sends (REMB), and substream and temporal pick what to get
+// of the simulcast video of the peer. Recordings are of what the user sends.
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/record"
+	"github.com/xroger88/go-janus/rtcp"
+	"github.com/xroger88/go-janus/rtp"
+)
+
+const Package = "janus.plugin.videocall"
+
+// error codes of the events
+const (
+	ErrorNoMessage         = 470
+	ErrorInvalidJSON       = 471
+	ErrorInvalidRequest    = 472
+	ErrorRegisterFirst     = 473
+	ErrorInvalidElement    = 474
+	ErrorMissingElement    = 475
+	ErrorUsernameTaken     = 476
+	ErrorAlreadyRegistered = 477
+	ErrorNoSuchUsername    = 478
+	ErrorUseEchoTest       = 479
+	ErrorAlreadyInCall     = 480
+	ErrorNoCall            = 481
+	ErrorMissingSDP        = 482
+	ErrorInvalidSDP        = 483
+	ErrorUnknown           = 499
+)
+
+// Config is the content of janus.plugin.videocall.yaml in the configs folder
+type Config struct {
+	General struct {
+		// Events tells whether to notify the event handlers
+		Events bool
+	}
+}
+
+type Plugin struct {
+	config   Config
+	gateway  plugins.Callbacks
+	messages chan *message
+	done     chan struct{}
+
+	// mutex protects users, and the username and the call of the sessions
+	mutex sync.Mutex
+	users map[string]*session
+}
+
+// message is a request waiting for the handler
+type message struct {
+	ps          *plugins.PluginSession
+	transaction string
+	body        json.RawMessage
+	jsep        *plugins.JSEP
+}
+
+// session is the state of a handle attached to the plugin
+type session struct {
+	ps *plugins.PluginSession
+
+	mutex sync.Mutex
+	// username, peer and incoming are only changed with the mutex of the
+	// plugin held as well, so either is enough to read them
+	username string
+	peer     *session
+	// incoming is set while a call to the session waits to be accepted
+	incoming bool
+
+	audioActive bool
+	videoActive bool
+	bitrate     uint32
+	slowLinks   int
+	// negotiated codecs, empty until the call is accepted
+	audioCodec, videoCodec string
+	hasData                bool
+
+	// simulcast is what the session sends; sim, switching and vp8 are
+	// about the simulcast video it gets from its peer
+	simulcast rtp.Simulcast
+	sim       *rtp.SimulcastContext
+	switching rtp.SwitchingContext
+	vp8       rtp.VP8Context
+
+	recording     bool
+	filename      string
+	arc, vrc, drc *record.Recorder
+	hangingUp     int32
+	destroyed     int32
+}
+
+// requestError is an error along with the code to tell clients about it
+type requestError struct {
+	code int
+	err  error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func fail(code int, format string, args ...interface{}) error {
+	return &requestError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+func errorCode(err error) int {
+	if e, ok := err.(*requestError); ok {
+		return e.code
+	}
+	return ErrorUnknown
+}
+
+// decode parses the body of a request, with the right error codes
+func decode(body json.RawMessage, out interface{}) error {
+	err := plugins.Decode(body, out)
+	switch {
+	case err == nil:
+		return nil
+	case err == plugins.ErrNoMessage:
+		return &requestError{ErrorNoMessage, err}
+	case err == plugins.ErrInvalidJSON:
+		return &requestError{ErrorInvalidJSON, err}
+	}
+	if e, ok := err.(*plugins.ElementError); ok && e.Missing {
+		return &requestError{ErrorMissingElement, err}
+	}
+	return &requestError{ErrorInvalidElement, err}
+}
+
+func missing(name string) error {
+	return &requestError{ErrorMissingElement, plugins.Missing(name)}
+}
+
+func invalid(name, reason string) error {
+	return &requestError{ErrorInvalidElement, plugins.Invalid(name, reason)}
+}
+
+func init() {
+	plugins.Register(New())
+}
+
+// New returns the VideoCall plugin, to be started with Init
+func New() *Plugin {
+	return &Plugin{
+		messages: make(chan *message, 100),
+		done:     make(chan struct{}),
+		users:    make(map[string]*session),
+	}
+}
+
+func (p *Plugin) Package() string { return Package }
+func (p *Plugin) Name() string    { return "JANUS VideoCall plugin" }
+func (p *Plugin) Description() string {
+	return "This is a simple video call plugin for Janus, allowing two WebRTC peers to call each other through a server."
+}
+func (p *Plugin) Author() string        { return api.Author }
+func (p *Plugin) Version() int          { return api.Version }
+func (p *Plugin) VersionString() string { return api.VersionString }
+
+// Init reads the configuration and starts the message handler
+func (p *Plugin) Init(gateway plugins.Callbacks, configPath string) error {
+	p.config.General.Events = true
+	if err := config.LoadComponent(configPath, Package, &p.config); err != nil {
+		return err
+	}
+	p.gateway = gateway
+	go p.handler()
+	return nil
+}
+
+func (p *Plugin) Destroy() {
+	select {
+	case <-p.done:
+		return
+	default:
+		close(p.done)
+	}
+	log.Infof("%s destroyed", Package)
+}
+
+func getSession(ps *plugins.PluginSession) *session {
+	s, _ := ps.Plugin.(*session)
+	if s == nil || atomic.LoadInt32(&s.destroyed) == 1 {
+		return nil
+	}
+	return s
+}
+
+func (p *Plugin) CreateSession(ps *plugins.PluginSession) error {
+	s := &session{ps: ps}
+	s.reset()
+	ps.Plugin = s
+	return nil
+}
+
+// reset brings the media of the session back to how it is before any call
+func (s *session) reset() {
+	s.stopRecording()
+	s.audioActive, s.videoActive = true, true
+	s.bitrate = 0
+	s.slowLinks = 0
+	s.audioCodec, s.videoCodec, s.hasData = "", "", false
+	s.simulcast = rtp.Simulcast{}
+	s.sim = rtp.NewSimulcastContext()
+	s.switching = rtp.SwitchingContext{}
+	s.vp8 = rtp.VP8Context{}
+	s.recording = false
+}
+
+// DestroySession hangs up the call, if any, and frees the username
+func (p *Plugin) DestroySession(ps *plugins.PluginSession) error {
+	s := getSession(ps)
+	if s == nil {
+		return fmt.Errorf("no session associated with this handle")
+	}
+	atomic.StoreInt32(&s.destroyed, 1)
+	p.hangup(s, "Remote hangup")
+	p.mutex.Lock()
+	if s.username != "" && p.users[s.username] == s {
+		delete(p.users, s.username)
+	}
+	p.mutex.Unlock()
+	s.mutex.Lock()
+	s.stopRecording()
+	s.mutex.Unlock()
+	return nil
+}
+
+func (p *Plugin) QuerySession(ps *plugins.PluginSession) interface{} {
+	s := getSession(ps)
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	info := map[string]interface{}{
+		"username":       s.username,
+		"incall":         s.peer != nil,
+		"audio_active":   s.audioActive,
+		"video_active":   s.videoActive,
+		"bitrate":        s.bitrate,
+		"slowlink_count": s.slowLinks,
+		"hangingup":      atomic.LoadInt32(&s.hangingUp),
+		"destroyed":      atomic.LoadInt32(&s.destroyed),
+	}
+	if s.peer != nil {
+		info["peer"] = s.peer.username
+	}
+	if s.audioCodec != "" {
+		info["audio_codec"] = s.audioCodec
+	}
+	if s.videoCodec != "" {
+		info["video_codec"] = s.videoCodec
+	}
+	if s.sim != nil && s.peer != nil {
+		info["simulcast"] = map[string]interface{}{
+			"substream":        s.sim.Substream,
+			"substream-target": s.sim.SubstreamTarget,
+			"temporal-layer":   s.sim.Temporal,
+			"temporal-target":  s.sim.TemporalTarget,
+		}
+	}
+	if s.recording {
+		recording := map[string]interface{}{}
+		for name, r := range map[string]*record.Recorder{"audio": s.arc, "video": s.vrc, "data": s.drc} {
+			if r != nil {
+				recording[name] = r.Path()
+			}
+		}
+		info["recording"] = recording
+	}
+	return info
+}
+
+// HandleMessage queues the message for the handler, which answers with an event
+func (p *Plugin) HandleMessage(ps *plugins.PluginSession, transaction string, body json.RawMessage, jsep *plugins.JSEP) *plugins.Result {
+	if getSession(ps) == nil {
+		return &plugins.Result{Type: plugins.ResultError, Text: "No session associated with this handle"}
+	}
+	select {
+	case p.messages <- &message{ps: ps, transaction: transaction, body: body, jsep: jsep}:
+	case <-p.done:
+		return &plugins.Result{Type: plugins.ResultError, Text: "Shutting down"}
+	}
+	return &plugins.Result{Type: plugins.ResultOKWait}
+}
+
+func (p *Plugin) SetupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil {
+		return
+	}
+	log.Infof("[%s] WebRTC media is now available", Package)
+	atomic.StoreInt32(&s.hangingUp, 0)
+	// the peer (and a recording) had better start with a keyframe
+	p.sendPLI(ps)
+}
+
+// IncomingRTP relays what a user sends to its peer
+func (p *Plugin) IncomingRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 || !rtp.IsRTP(packet.Buffer) {
+		return
+	}
+	s.mutex.Lock()
+	peer := s.peer
+	if peer == nil || s.incoming {
+		s.mutex.Unlock()
+		return
+	}
+	if !packet.Video {
+		active, rec := s.audioActive, s.arc
+		s.mutex.Unlock()
+		if !active {
+			return
+		}
+		if rec != nil {
+			rec.Save(packet.Buffer)
+		}
+		p.gateway.RelayRTP(peer.ps, &plugins.RTPPacket{Mindex: -1, Buffer: packet.Buffer})
+		return
+	}
+	active, rec, simulcast, codec := s.videoActive, s.vrc, s.simulcast, s.videoCodec
+	s.mutex.Unlock()
+	if !active {
+		return
+	}
+
+	if simulcast.Enabled() {
+		// the peer picks the substream it gets
+		var events []map[string]interface{}
+		peer.mutex.Lock()
+		r := peer.sim.ProcessRTP(packet.Buffer, &simulcast, codec)
+		if r.SubstreamChanged {
+			peer.vp8.Reset()
+			events = append(events, map[string]interface{}{"videocall": "event", "result": map[string]interface{}{"event": "simulcast", "videocodec": codec, "substream": peer.sim.Substream}})
+		}
+		if r.TemporalChanged {
+			events = append(events, map[string]interface{}{"videocall": "event", "result": map[string]interface{}{"event": "simulcast", "videocodec": codec, "temporal": peer.sim.Temporal}})
+		}
+		if r.Relay {
+			// the substreams must look like a single stream
+			peer.switching.Update(packet.Buffer, 90000)
+			if codec == "vp8" {
+				if payload, err := rtp.Payload(packet.Buffer); err == nil {
+					peer.vp8.Update(payload)
+				}
+			}
+		} else if r.Skipped {
+			peer.switching.Skip()
+		}
+		peer.mutex.Unlock()
+
+		for _, event := range events {
+			p.gateway.PushEvent(peer.ps, p, "", event, nil)
+		}
+		if r.NeedKeyframe {
+			p.sendPLI(ps)
+		}
+		if !r.Relay {
+			return
+		}
+	}
+	if rec != nil {
+		rec.Save(packet.Buffer)
+	}
+	p.gateway.RelayRTP(peer.ps, &plugins.RTPPacket{Video: true, Mindex: -1, Buffer: packet.Buffer})
+}
+
+// IncomingRTCP relays the feedback of a user to its peer, with the REMB
+// capped to the bitrate the user asked for
+func (p *Plugin) IncomingRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 {
+		return
+	}
+	s.mutex.Lock()
+	peer, bitrate := s.peer, s.bitrate
+	if s.incoming {
+		peer = nil
+	}
+	s.mutex.Unlock()
+	if peer == nil {
+		return
+	}
+	if bitrate > 0 {
+		rtcp.CapREMB(packet.Buffer, bitrate)
+	}
+	p.gateway.RelayRTCP(peer.ps, &plugins.RTCPPacket{Video: packet.Video, Mindex: -1, Buffer: packet.Buffer})
+}
+
+func (p *Plugin) IncomingData(ps *plugins.PluginSession, packet *plugins.DataPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 || len(packet.Buffer) == 0 {
+		return
+	}
+	s.mutex.Lock()
+	peer, rec := s.peer, s.drc
+	if s.incoming {
+		peer = nil
+	}
+	s.mutex.Unlock()
+	if peer == nil {
+		return
+	}
+	if rec != nil {
+		rec.Save(packet.Buffer)
+	}
+	p.gateway.RelayData(peer.ps, packet)
+}
+
+// SlowLink tells the user about it, for the application to lower the bitrate
+func (p *Plugin) SlowLink(ps *plugins.PluginSession, uplink, video bool) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 {
+		return
+	}
+	s.mutex.Lock()
+	s.slowLinks++
+	bitrate := s.bitrate
+	s.mutex.Unlock()
+	log.Warnf("[%s] Getting a lot of NACKs (slow %s) for %s", Package,
+		map[bool]string{true: "uplink", false: "downlink"}[uplink], map[bool]string{true: "video", false: "audio"}[video])
+	p.gateway.PushEvent(ps, p, "", map[string]interface{}{
+		"videocall": "event",
+		"result": map[string]interface{}{
+			"event":           "slow_link",
+			"media":           map[bool]string{true: "video", false: "audio"}[video],
+			"uplink":          uplink,
+			"current-bitrate": bitrate,
+		},
+	}, nil)
+}
+
+// HangupMedia ends the call, as the PeerConnection is gone
+func (p *Plugin) HangupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil || !atomic.CompareAndSwapInt32(&s.hangingUp, 0, 1) {
+		return
+	}
+	log.Infof("[%s] No WebRTC media anymore", Package)
+	p.hangup(s, "Remote WebRTC hangup")
+	s.mutex.Lock()
+	s.reset()
+	s.mutex.Unlock()
+}
+
+func (p *Plugin) sendPLI(ps *plugins.PluginSession) {
+	p.gateway.RelayRTCP(ps, &plugins.RTCPPacket{Video: true, Mindex: -1, Buffer: rtcp.NewPLI()})
+}
+
+func (p *Plugin) notify(ps *plugins.PluginSession, event map[string]interface{}) {
+	if p.config.General.Events && p.gateway.EventsIsEnabled() {
+		p.gateway.NotifyEvent(p, ps, event)
+	}
+}
+
+// handler processes the messages, one at a time
+func (p *Plugin) handler() {
+	for {
+		select {
+		case m := <-p.messages:
+			p.handle(m)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Plugin) handle(m *message) {
+	s := getSession(m.ps)
+	if s == nil {
+		log.Warnf("[%s] No session associated with this handle", Package)
+		return
+	}
+	result, jsep, err := p.process(s, m)
+	var event map[string]interface{}
+	if err != nil {
+		log.Warnf("[%s] %v", Package, err)
+		event = map[string]interface{}{"videocall": "event", "error_code": errorCode(err), "error": err.Error()}
+		jsep = nil
+	} else {
+		event = map[string]interface{}{"videocall": "event", "result": result}
+	}
+	if err := p.gateway.PushEvent(m.ps, p, m.transaction, event, jsep); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+}
+
+// process handles a request, and returns the result of the event along
+// with a JSEP for the user, if any
+func (p *Plugin) process(s *session, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Request *string `json:"request"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Request == nil {
+		return nil, nil, missing("request")
+	}
+	switch *r.Request {
+	case "list":
+		return p.list(), nil, nil
+	case "register":
+		return p.register(s, m.body)
+	case "call":
+		return p.call(s, m.body, m.jsep)
+	case "accept":
+		return p.accept(s, m.jsep)
+	case "set":
+		return p.set(s, m.body, m.jsep)
+	case "hangup":
+		return p.hangupRequest(s, m.body)
+	}
+	return nil, nil, fail(ErrorInvalidRequest, "Unknown request (%s)", *r.Request)
+}
@@ -0,0 +1,248 @@
+package videocall
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/plugins"
+)
+
+// testGateway stands in for the core: the events of the plugin go to the
+// channel each handle has in its Gateway, the RTP it relays to rtp
+type testGateway struct {
+	plugins.Callbacks
+	rtp chan relayedPacket
+}
+
+type testEvent struct {
+	transaction string
+	data        map[string]interface{}
+	jsep        *plugins.JSEP
+}
+
+type relayedPacket struct {
+	ps     *plugins.PluginSession
+	packet *plugins.RTPPacket
+}
+
+func (g *testGateway) PushEvent(ps *plugins.PluginSession, plugin plugins.Plugin, transaction string, message interface{}, jsep *plugins.JSEP) error {
+	e := testEvent{transaction: transaction, jsep: jsep}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &e.data); err != nil {
+		return err
+	}
+	ps.Gateway.(chan testEvent) <- e
+	return nil
+}
+
+func (g *testGateway) RelayRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	g.rtp <- relayedPacket{ps, packet}
+}
+
+func (g *testGateway) RelayRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {}
+func (g *testGateway) RelayData(ps *plugins.PluginSession, packet *plugins.DataPacket) {}
+func (g *testGateway) ClosePC(ps *plugins.PluginSession)                               {}
+func (g *testGateway) EventsIsEnabled() bool                                           { return false }
+
+func startPlugin(t *testing.T) (*Plugin, *testGateway) {
+	p := New()
+	g := &testGateway{rtp: make(chan relayedPacket, 10)}
+	if err := p.Init(g, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Destroy)
+	return p, g
+}
+
+// attach creates a handle, whose events can be taken with next
+func attach(t *testing.T, p *Plugin) *plugins.PluginSession {
+	ps := plugins.NewPluginSession(make(chan testEvent, 100))
+	if err := p.CreateSession(ps); err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+func next(t *testing.T, ps *plugins.PluginSession) testEvent {
+	t.Helper()
+	select {
+	case e := <-ps.Gateway.(chan testEvent):
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return testEvent{}
+}
+
+// send sends a request, and returns the event answering it
+func send(t *testing.T, p *Plugin, ps *plugins.PluginSession, body string, jsep *plugins.JSEP) testEvent {
+	t.Helper()
+	if r := p.HandleMessage(ps, "t", json.RawMessage(body), jsep); r.Type != plugins.ResultOKWait {
+		t.Fatalf("got %+v sending %s", r, body)
+	}
+	for {
+		if e := next(t, ps); e.transaction == "t" {
+			return e
+		}
+	}
+}
+
+func code(m map[string]interface{}) int {
+	c, _ := m["error_code"].(float64)
+	return int(c)
+}
+
+// result is the result element of an event
+func result(e testEvent) map[string]interface{} {
+	r, _ := e.data["result"].(map[string]interface{})
+	return r
+}
+
+const offer = "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\na=group:BUNDLE 0 1\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\nc=IN IP4 0.0.0.0\r\na=mid:0\r\na=rtpmap:111 opus/48000/2\r\na=sendrecv\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96\r\nc=IN IP4 0.0.0.0\r\na=mid:1\r\na=rtpmap:96 VP8/90000\r\na=sendrecv\r\n"
+
+func TestRequests(t *testing.T) {
+	p, _ := startPlugin(t)
+	alice := attach(t, p)
+	tests := []struct {
+		name string
+		body string
+		jsep *plugins.JSEP
+		code int
+	}{
+		{"no request", `{}`, nil, ErrorMissingElement},
+		{"invalid request", `{"request":5}`, nil, ErrorInvalidElement},
+		{"unknown request", `{"request":"dance"}`, nil, ErrorInvalidRequest},
+		{"missing username", `{"request":"register"}`, nil, ErrorMissingElement},
+		{"empty username", `{"request":"register","username":""}`, nil, ErrorInvalidElement},
+		{"call before registering", `{"request":"call","username":"bob"}`, &plugins.JSEP{Type: "offer", SDP: offer}, ErrorRegisterFirst},
+		{"accept before registering", `{"request":"accept"}`, nil, ErrorRegisterFirst},
+		{"invalid substream", `{"request":"set","substream":3}`, nil, ErrorInvalidElement},
+		{"renegotiate without a call", `{"request":"set"}`, &plugins.JSEP{Type: "offer", SDP: offer}, ErrorNoCall},
+		{"register", `{"request":"register","username":"alice"}`, nil, 0},
+		{"register again", `{"request":"register","username":"alice2"}`, nil, ErrorAlreadyRegistered},
+		{"call without an offer", `{"request":"call","username":"bob"}`, nil, ErrorMissingSDP},
+		{"call with an answer", `{"request":"call","username":"bob"}`, &plugins.JSEP{Type: "answer", SDP: offer}, ErrorInvalidSDP},
+		{"call yourself", `{"request":"call","username":"alice"}`, &plugins.JSEP{Type: "offer", SDP: offer}, ErrorUseEchoTest},
+		{"call nobody", `{"request":"call","username":"bob"}`, &plugins.JSEP{Type: "offer", SDP: offer}, ErrorNoSuchUsername},
+		{"accept no call", `{"request":"accept"}`, &plugins.JSEP{Type: "answer", SDP: offer}, ErrorNoCall},
+		{"hang up no call", `{"request":"hangup"}`, nil, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if e := send(t, p, alice, test.body, test.jsep); code(e.data) != test.code {
+				t.Errorf("got %v, want error code %d", e.data, test.code)
+			}
+		})
+	}
+
+	bob := attach(t, p)
+	if e := send(t, p, bob, `{"request":"register","username":"alice"}`, nil); code(e.data) != ErrorUsernameTaken {
+		t.Errorf("got %v registering a taken username", e.data)
+	}
+	send(t, p, bob, `{"request":"register","username":"bob"}`, nil)
+	if e := send(t, p, bob, `{"request":"list"}`, nil); !reflect.DeepEqual(result(e)["list"], []interface{}{"alice", "bob"}) {
+		t.Errorf("got %v listing", e.data)
+	}
+
+	// destroying the handle frees the username
+	if err := p.DestroySession(bob); err != nil {
+		t.Fatal(err)
+	}
+	if e := send(t, p, alice, `{"request":"list"}`, nil); !reflect.DeepEqual(result(e)["list"], []interface{}{"alice"}) {
+		t.Errorf("got %v once bob's handle was destroyed", e.data)
+	}
+	if r := p.HandleMessage(bob, "t", json.RawMessage(`{"request":"list"}`), nil); r.Type != plugins.ResultError {
+		t.Errorf("got %+v after destroying the handle", r)
+	}
+}
+
+func TestCall(t *testing.T) {
+	p, g := startPlugin(t)
+	alice, bob, carol := attach(t, p), attach(t, p), attach(t, p)
+	send(t, p, alice, `{"request":"register","username":"alice"}`, nil)
+	send(t, p, bob, `{"request":"register","username":"bob"}`, nil)
+	send(t, p, carol, `{"request":"register","username":"carol"}`, nil)
+
+	if e := send(t, p, alice, `{"request":"call","username":"bob"}`, &plugins.JSEP{Type: "offer", SDP: offer}); result(e)["event"] != "calling" {
+		t.Fatalf("got %v calling", e.data)
+	}
+	e := next(t, bob)
+	if result(e)["event"] != "incomingcall" || result(e)["username"] != "alice" || e.jsep == nil || e.jsep.Type != "offer" {
+		t.Fatalf("got %v %+v, want the incoming call", e.data, e.jsep)
+	}
+	if e := send(t, p, carol, `{"request":"call","username":"bob"}`, &plugins.JSEP{Type: "offer", SDP: offer}); result(e)["reason"] != "User busy" {
+		t.Errorf("got %v calling somebody busy", e.data)
+	}
+	if e := send(t, p, alice, `{"request":"call","username":"carol"}`, &plugins.JSEP{Type: "offer", SDP: offer}); code(e.data) != ErrorAlreadyInCall {
+		t.Errorf("got %v calling while in a call", e.data)
+	}
+	if e := send(t, p, bob, `{"request":"accept"}`, nil); code(e.data) != ErrorMissingSDP {
+		t.Errorf("got %v accepting without an answer", e.data)
+	}
+
+	// what the callee sends goes nowhere until it accepts
+	packet := []byte{0x80, 111, 0, 1, 0, 0, 0, 1, 0, 0, 0, 7, 1, 2, 3}
+	p.IncomingRTP(bob, &plugins.RTPPacket{Mindex: -1, Buffer: packet})
+	select {
+	case r := <-g.rtp:
+		t.Errorf("got %+v relayed before the call was accepted", r)
+	default:
+	}
+
+	if e := send(t, p, bob, `{"request":"accept"}`, &plugins.JSEP{Type: "answer", SDP: offer}); result(e)["event"] != "accepted" {
+		t.Fatalf("got %v accepting", e.data)
+	}
+	if e := next(t, alice); result(e)["event"] != "accepted" || e.jsep == nil || e.jsep.Type != "answer" {
+		t.Errorf("got %v %+v, want the call accepted", e.data, e.jsep)
+	}
+
+	// what one sends goes to the other, unless disabled
+	p.IncomingRTP(alice, &plugins.RTPPacket{Mindex: -1, Buffer: packet})
+	select {
+	case r := <-g.rtp:
+		if r.ps != bob || r.packet.Video {
+			t.Errorf("got %+v relayed", r)
+		}
+	default:
+		t.Error("nothing relayed")
+	}
+	if e := send(t, p, alice, `{"request":"set","audio":false}`, nil); result(e)["event"] != "set" {
+		t.Errorf("got %v setting", e.data)
+	}
+	p.IncomingRTP(alice, &plugins.RTPPacket{Mindex: -1, Buffer: packet})
+	select {
+	case r := <-g.rtp:
+		t.Errorf("got %+v relayed with the audio disabled", r)
+	default:
+	}
+
+	if e := send(t, p, alice, `{"request":"hangup"}`, nil); result(e)["event"] != "hangup" {
+		t.Errorf("got %v hanging up", e.data)
+	}
+	if e := next(t, bob); result(e)["event"] != "hangup" || result(e)["reason"] != "Remote hangup" {
+		t.Errorf("got %v, want the call hung up", e.data)
+	}
+	if e := send(t, p, bob, `{"request":"accept"}`, &plugins.JSEP{Type: "answer", SDP: offer}); code(e.data) != ErrorNoCall {
+		t.Errorf("got %v accepting a call hung up", e.data)
+	}
+
+	// and a call that's over leaves both free for another one
+	if e := send(t, p, carol, `{"request":"call","username":"bob"}`, &plugins.JSEP{Type: "offer", SDP: offer}); result(e)["event"] != "calling" {
+		t.Errorf("got %v calling again", e.data)
+	}
+	if err := p.DestroySession(carol); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if e := next(t, bob); result(e)["event"] == "hangup" {
+			break
+		}
+	}
+}
@@ -0,0 +1,654 @@
+package videoroom
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/record"
+	"github.com/xroger88/go-janus/rtcp"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sdp"
+	"github.com/xroger88/go-janus/util"
+)
+
+const (
+	// publishers aren't asked for keyframes more often than this
+	pliInterval = 500 * time.Millisecond
+	// the REMB is sent again every now and then, in case it got lost
+	rembInterval = 5 * time.Second
+)
+
+// publisher is a participant of a room, who may or may not publish media
+type publisher struct {
+	s         *session
+	room      *room
+	id        uint64
+	privateID uint32
+
+	mutex   sync.Mutex
+	display string
+	// offered is set once an offer has been answered, active once the
+	// PeerConnection is up too
+	offered, active bool
+	// negotiated codecs, with their payload type and fmtp
+	audioCodec, videoCodec string
+	audioPT, videoPT       int
+	audioFmtp, videoFmtp   string
+	hasData                bool
+	simulcast              rtp.Simulcast
+
+	audioActive, videoActive, dataActive bool
+	bitrate                              uint32
+	subscribers                          []*subscriber
+
+	recording     bool
+	filename      string
+	arc, vrc, drc *record.Recorder
+	lastPLI       time.Time
+	lastREMB      time.Time
+}
+
+// describe is how a publisher appears in publishers events; the caller
+// holds its mutex
+func (pub *publisher) describe() map[string]interface{} {
+	d := map[string]interface{}{"id": pub.id}
+	if pub.display != "" {
+		d["display"] = pub.display
+	}
+	if pub.audioCodec != "" {
+		d["audio_codec"] = pub.audioCodec
+	}
+	if pub.videoCodec != "" {
+		d["video_codec"] = pub.videoCodec
+	}
+	if pub.simulcast.Enabled() {
+		d["simulcast"] = true
+	}
+	return d
+}
+
+func (pub *publisher) query(info map[string]interface{}) {
+	pub.mutex.Lock()
+	defer pub.mutex.Unlock()
+	info["room"] = pub.room.Room
+	info["id"] = pub.id
+	info["private_id"] = pub.privateID
+	if pub.display != "" {
+		info["display"] = pub.display
+	}
+	info["media"] = map[string]interface{}{
+		"audio": pub.audioCodec != "",
+		"video": pub.videoCodec != "",
+		"data":  pub.hasData,
+	}
+	info["audio_active"] = pub.audioActive
+	info["video_active"] = pub.videoActive
+	info["data_active"] = pub.dataActive
+	info["bitrate"] = pub.bitrate
+	info["subscribers"] = len(pub.subscribers)
+	if pub.audioCodec != "" {
+		info["audio_codec"] = pub.audioCodec
+	}
+	if pub.videoCodec != "" {
+		info["video_codec"] = pub.videoCodec
+	}
+	if pub.recording {
+		recording := map[string]interface{}{}
+		for name, r := range map[string]*record.Recorder{"audio": pub.arc, "video": pub.vrc, "data": pub.drc} {
+			if r != nil {
+				recording[name] = r.Path()
+			}
+		}
+		info["recording"] = recording
+	}
+}
+
+// joinPublisher adds a participant to a room
+func (p *Plugin) joinPublisher(s *session, rm *room, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		ID      *uint64 `json:"id"`
+		Display string  `json:"display"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.ID != nil && *r.ID == 0 {
+		return nil, nil, invalid("id", "should not be 0")
+	}
+	rm.mutex.Lock()
+	if rm.destroyed {
+		rm.mutex.Unlock()
+		return nil, nil, fail(ErrorNoSuchRoom, "No such room (%d)", rm.Room)
+	}
+	id := util.RandomUint64()
+	if r.ID != nil {
+		if rm.participants[*r.ID] != nil {
+			rm.mutex.Unlock()
+			return nil, nil, fail(ErrorIDExists, "User ID %d already exists", *r.ID)
+		}
+		id = *r.ID
+	}
+	for rm.participants[id] != nil {
+		id = util.RandomUint64()
+	}
+	pvt := uint32(util.RandomUint64())
+	for pvt == 0 || rm.privateIDs[pvt] != nil {
+		pvt = uint32(util.RandomUint64())
+	}
+	pub := &publisher{
+		s:           s,
+		room:        rm,
+		id:          id,
+		privateID:   pvt,
+		display:     r.Display,
+		audioActive: true,
+		videoActive: true,
+		dataActive:  true,
+	}
+	rm.participants[id] = pub
+	rm.privateIDs[pvt] = pub
+	var publishers []map[string]interface{}
+	for _, other := range rm.publishing(pub) {
+		other.mutex.Lock()
+		publishers = append(publishers, other.describe())
+		other.mutex.Unlock()
+	}
+	description, notifyJoining := rm.Description, rm.Notify_joining
+	rm.mutex.Unlock()
+
+	s.mutex.Lock()
+	s.participant = pub
+	s.mutex.Unlock()
+	log.Infof("[%s] Participant %d joined room %d", Package, id, rm.Room)
+	if notifyJoining {
+		joining := map[string]interface{}{"id": id}
+		if r.Display != "" {
+			joining["display"] = r.Display
+		}
+		p.broadcast(rm, pub, map[string]interface{}{"videoroom": "event", "room": rm.Room, "joining": joining})
+	}
+	p.notify(s.ps, map[string]interface{}{"event": "joined", "room": rm.Room, "id": id, "private_id": pvt, "display": r.Display})
+
+	if publishers == nil {
+		publishers = []map[string]interface{}{}
+	}
+	event := map[string]interface{}{
+		"videoroom":   "joined",
+		"room":        rm.Room,
+		"description": description,
+		"id":          id,
+		"private_id":  pvt,
+		"publishers":  publishers,
+	}
+	if m.request != "joinandconfigure" {
+		return event, nil, nil
+	}
+	configured, jsep, err := p.configure(pub, m)
+	if err != nil {
+		p.leave(pub, nil)
+		return nil, nil, err
+	}
+	for _, k := range []string{"audio_codec", "video_codec"} {
+		if v, ok := configured[k]; ok {
+			event[k] = v
+		}
+	}
+	return event, jsep, nil
+}
+
+func (p *Plugin) publisherRequest(pub *publisher, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	if _, err := p.room(pub.room.Room); err != nil {
+		return nil, nil, err
+	}
+	switch m.request {
+	case "configure", "publish":
+		return p.configure(pub, m)
+	case "unpublish":
+		pub.mutex.Lock()
+		offered := pub.offered
+		pub.mutex.Unlock()
+		if !offered {
+			return nil, nil, fail(ErrorNotPublished, "Can't unpublish, not published")
+		}
+		p.unpublished(pub, true)
+		p.gateway.ClosePC(pub.s.ps)
+		return map[string]interface{}{"videoroom": "event", "room": pub.room.Room, "unpublished": "ok"}, nil, nil
+	case "leave":
+		p.leave(pub, nil)
+		return map[string]interface{}{"videoroom": "event", "room": pub.room.Room, "leaving": "ok"}, nil, nil
+	}
+	return nil, nil, fail(ErrorInvalidRequest, "Unknown request '%s' for a publisher", m.request)
+}
+
+// configure changes what a publisher sends, and answers its offer if any
+func (p *Plugin) configure(pub *publisher, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Audio    *bool   `json:"audio"`
+		Video    *bool   `json:"video"`
+		Data     *bool   `json:"data"`
+		Bitrate  *uint32 `json:"bitrate"`
+		Keyframe bool    `json:"keyframe"`
+		Record   *bool   `json:"record"`
+		Filename *string `json:"filename"`
+		Display  *string `json:"display"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, nil, err
+	}
+	rm := pub.room
+	pub.mutex.Lock()
+	offered := pub.offered
+	pub.mutex.Unlock()
+	if m.request == "publish" && offered {
+		return nil, nil, fail(ErrorAlreadyPublished, "Can't publish, already published")
+	}
+
+	rm.mutex.Lock()
+	roomBitrate, bitrateCap, roomRecord := rm.Bitrate, rm.Bitrate_cap, rm.Record
+	publishers := rm.Publishers
+	others := 0
+	for _, other := range rm.participants {
+		if other != pub {
+			other.mutex.Lock()
+			if other.offered {
+				others++
+			}
+			other.mutex.Unlock()
+		}
+	}
+	audioCodecs, videoCodecs := rm.audioCodecs, rm.videoCodecs
+	rm.mutex.Unlock()
+
+	var answer *plugins.JSEP
+	if m.jsep != nil {
+		if m.jsep.Type != "offer" {
+			return nil, nil, fail(ErrorInvalidSDPType, "Unexpected %s, publishers send offers", m.jsep.Type)
+		}
+		if !offered && others >= publishers {
+			return nil, nil, fail(ErrorPublishersFull, "Maximum number of publishers (%d) already reached", publishers)
+		}
+		offer, err := sdp.Parse(m.jsep.SDP)
+		if err != nil {
+			return nil, nil, fail(ErrorInvalidSDP, "Error parsing offer: %v", err)
+		}
+		// publishers only send, and only with the codecs of the room
+		opts := sdp.AnswerOptions{
+			AudioDirection: sdp.RecvOnly,
+			VideoDirection: sdp.RecvOnly,
+			Extensions:     extensions,
+			Simulcast:      true,
+		}
+		opts.AudioCodec = pick(offer.MLine(sdp.Audio), audioCodecs)
+		opts.NoAudio = opts.AudioCodec == ""
+		opts.VideoCodec = pick(offer.MLine(sdp.Video), videoCodecs)
+		opts.NoVideo = opts.VideoCodec == ""
+		a := sdp.GenerateAnswer(offer, opts)
+		a.Name = "VideoRoom " + strconv.FormatUint(rm.Room, 10)
+		answer = &plugins.JSEP{Type: "answer", SDP: a.String()}
+		pub.mutex.Lock()
+		pub.negotiated(offer, a)
+		pub.mutex.Unlock()
+	}
+
+	pub.mutex.Lock()
+	keyframe := r.Keyframe
+	if r.Audio != nil {
+		pub.audioActive = *r.Audio
+	}
+	if r.Video != nil {
+		keyframe = keyframe || (*r.Video && !pub.videoActive)
+		pub.videoActive = *r.Video
+	}
+	if r.Data != nil {
+		pub.dataActive = *r.Data
+	}
+	var remb uint32
+	if r.Bitrate != nil {
+		pub.bitrate = *r.Bitrate
+		if bitrateCap && roomBitrate > 0 && (pub.bitrate == 0 || pub.bitrate > roomBitrate) {
+			pub.bitrate = roomBitrate
+		}
+		remb = pub.bitrate
+		if remb == 0 {
+			remb = roomBitrate
+		}
+		log.Infof("[%s] Setting video bitrate of %d: %d", Package, pub.id, pub.bitrate)
+	}
+	var displayChanged map[string]interface{}
+	if r.Display != nil && *r.Display != pub.display {
+		pub.display = *r.Display
+		if pub.active {
+			displayChanged = map[string]interface{}{"videoroom": "event", "room": rm.Room, "id": pub.id, "display": pub.display}
+		}
+	}
+	if r.Filename != nil {
+		pub.filename = *r.Filename
+	}
+	if r.Record != nil {
+		pub.recording = *r.Record
+	} else if answer != nil && !offered {
+		pub.recording = roomRecord
+	}
+	started, err := pub.updateRecording()
+	keyframe = keyframe || started
+	event := map[string]interface{}{"videoroom": "event", "room": rm.Room, "configured": "ok"}
+	if answer != nil {
+		if pub.audioCodec != "" {
+			event["audio_codec"] = pub.audioCodec
+		}
+		if pub.videoCodec != "" {
+			event["video_codec"] = pub.videoCodec
+		}
+	}
+	pub.mutex.Unlock()
+	if err != nil {
+		return nil, nil, fail(ErrorUnknown, "Error starting recording: %v", err)
+	}
+
+	if remb > 0 {
+		p.sendREMB(pub, remb)
+	}
+	if keyframe {
+		p.sendPLI(pub)
+	}
+	if displayChanged != nil {
+		p.broadcast(rm, pub, displayChanged)
+	}
+	p.notify(pub.s.ps, map[string]interface{}{"event": "configured", "room": rm.Room, "id": pub.id})
+	return event, answer, nil
+}
+
+// pick returns the first codec of a list that an m-line has, or ""
+func pick(m *sdp.MLine, codecs []string) string {
+	if m == nil || m.Port == 0 {
+		return ""
+	}
+	for _, codec := range codecs {
+		if m.CodecPT(codec) >= 0 {
+			return codec
+		}
+	}
+	return ""
+}
+
+// negotiated takes note of what an answer accepted; the caller holds the
+// mutex of the publisher
+func (pub *publisher) negotiated(offer, answer *sdp.SDP) {
+	pub.offered = true
+	pub.audioCodec, pub.videoCodec, pub.hasData = "", "", false
+	pub.simulcast = rtp.Simulcast{}
+	for i, m := range answer.MLines {
+		if m.Port == 0 {
+			continue
+		}
+		switch m.Type {
+		case sdp.Audio:
+			pub.audioCodec = m.FirstCodec()
+			pub.audioPT = m.CodecPT(pub.audioCodec)
+			pub.audioFmtp = m.Fmtp(pub.audioPT)
+		case sdp.Video:
+			pub.videoCodec = m.FirstCodec()
+			pub.videoPT = m.CodecPT(pub.videoCodec)
+			pub.videoFmtp = m.Fmtp(pub.videoPT)
+			o := offer.MLines[i]
+			pub.simulcast = rtp.Simulcast{SSRCs: o.SimulcastSSRCs(), Rids: o.Rids(), RidExtID: o.ExtmapID(sdp.ExtRid)}
+		case sdp.Application:
+			pub.hasData = true
+		}
+	}
+	log.Infof("[%s] Publisher %d negotiated audio %q, video %q, data %v", Package, pub.id, pub.audioCodec, pub.videoCodec, pub.hasData)
+}
+
+// published tells the room about a publisher whose PeerConnection is up
+func (p *Plugin) published(pub *publisher) {
+	pub.mutex.Lock()
+	if !pub.offered || pub.active {
+		pub.mutex.Unlock()
+		return
+	}
+	pub.active = true
+	info := pub.describe()
+	remb := pub.bitrate
+	pub.mutex.Unlock()
+	rm := pub.room
+	rm.mutex.Lock()
+	if remb == 0 {
+		remb = rm.Bitrate
+	}
+	rm.mutex.Unlock()
+
+	p.broadcast(rm, pub, map[string]interface{}{
+		"videoroom":  "event",
+		"room":       rm.Room,
+		"publishers": []map[string]interface{}{info},
+	})
+	if remb > 0 {
+		p.sendREMB(pub, remb)
+	}
+	p.notify(pub.s.ps, map[string]interface{}{"event": "published", "room": rm.Room, "id": pub.id})
+}
+
+// unpublished forgets about the media of a publisher, and hangs up its
+// subscribers; the room is told about it if tell is set
+func (p *Plugin) unpublished(pub *publisher, tell bool) {
+	pub.mutex.Lock()
+	if !pub.offered {
+		pub.mutex.Unlock()
+		return
+	}
+	wasActive := pub.active
+	pub.offered, pub.active = false, false
+	pub.audioCodec, pub.videoCodec, pub.hasData = "", "", false
+	pub.simulcast = rtp.Simulcast{}
+	pub.bitrate = 0
+	pub.recording = false
+	pub.stopRecording()
+	subscribers := pub.subscribers
+	pub.subscribers = nil
+	pub.mutex.Unlock()
+
+	for _, sub := range subscribers {
+		sub.mutex.Lock()
+		if sub.feed == pub {
+			sub.feed = nil
+		}
+		sub.mutex.Unlock()
+		p.gateway.ClosePC(sub.s.ps)
+	}
+	rm := pub.room
+	if tell && wasActive {
+		p.broadcast(rm, pub, map[string]interface{}{"videoroom": "event", "room": rm.Room, "unpublished": pub.id})
+	}
+	log.Infof("[%s] Publisher %d unpublished in room %d", Package, pub.id, rm.Room)
+	p.notify(pub.s.ps, map[string]interface{}{"event": "unpublished", "room": rm.Room, "id": pub.id})
+}
+
+// remove takes a participant out of its room, and tells whether it was there
+func (p *Plugin) remove(pub *publisher) bool {
+	rm := pub.room
+	rm.mutex.Lock()
+	there := rm.participants[pub.id] == pub
+	if there {
+		delete(rm.participants, pub.id)
+		delete(rm.privateIDs, pub.privateID)
+	}
+	rm.mutex.Unlock()
+	if !there {
+		return false
+	}
+	p.unpublished(pub, false)
+	pub.s.mutex.Lock()
+	if pub.s.participant == pub {
+		pub.s.participant = nil
+	}
+	pub.s.mutex.Unlock()
+	return true
+}
+
+// leave makes a participant leave its room, and tells the others with event
+// ("leaving" if nil)
+func (p *Plugin) leave(pub *publisher, event map[string]interface{}) {
+	if !p.remove(pub) {
+		return
+	}
+	rm := pub.room
+	if event == nil {
+		event = map[string]interface{}{"videoroom": "event", "room": rm.Room, "leaving": pub.id}
+	}
+	p.broadcast(rm, nil, event)
+	p.gateway.ClosePC(pub.s.ps)
+	log.Infof("[%s] Participant %d left room %d", Package, pub.id, rm.Room)
+	p.notify(pub.s.ps, map[string]interface{}{"event": "leaving", "room": rm.Room, "id": pub.id})
+}
+
+// updateRecording starts or stops recording as asked, for what has been
+// negotiated so far, and tells whether a video recording just started; the
+// caller holds the mutex of the publisher
+func (pub *publisher) updateRecording() (bool, error) {
+	if !pub.recording || !pub.offered {
+		pub.stopRecording()
+		return false, nil
+	}
+	pub.room.mutex.Lock()
+	dir := pub.room.Rec_dir
+	pub.room.mutex.Unlock()
+	base := pub.filename
+	if base == "" {
+		base = fmt.Sprintf("videoroom-%d-user-%d-%d", pub.room.Room, pub.id, time.Now().UnixNano()/int64(time.Microsecond))
+	}
+	var err error
+	videoStarted := false
+	if pub.audioCodec != "" && pub.arc == nil {
+		pub.arc, err = record.New(dir, base+"-audio", record.Audio, pub.audioCodec, pub.audioFmtp)
+	}
+	if err == nil && pub.videoCodec != "" && pub.vrc == nil {
+		pub.vrc, err = record.New(dir, base+"-video", record.Video, pub.videoCodec, pub.videoFmtp)
+		videoStarted = err == nil
+	}
+	if err == nil && pub.hasData && pub.drc == nil {
+		pub.drc, err = record.New(dir, base+"-data", record.Data, "text", "")
+	}
+	if err != nil {
+		pub.recording = false
+		pub.stopRecording()
+	}
+	return videoStarted, err
+}
+
+func (pub *publisher) stopRecording() {
+	for _, r := range []**record.Recorder{&pub.arc, &pub.vrc, &pub.drc} {
+		if *r != nil {
+			(*r).Close()
+			*r = nil
+		}
+	}
+}
+
+// sendPLI asks a publisher for a keyframe, unless it's just been asked
+func (p *Plugin) sendPLI(pub *publisher) {
+	pub.mutex.Lock()
+	now := time.Now()
+	if now.Sub(pub.lastPLI) < pliInterval {
+		pub.mutex.Unlock()
+		return
+	}
+	pub.lastPLI = now
+	pub.mutex.Unlock()
+	p.gateway.RelayRTCP(pub.s.ps, &plugins.RTCPPacket{Video: true, Mindex: -1, Buffer: rtcp.NewPLI()})
+}
+
+func (p *Plugin) sendREMB(pub *publisher, bitrate uint32) {
+	pub.mutex.Lock()
+	pub.lastREMB = time.Now()
+	pub.mutex.Unlock()
+	p.gateway.RelayRTCP(pub.s.ps, &plugins.RTCPPacket{Video: true, Mindex: -1, Buffer: rtcp.NewREMB(bitrate)})
+}
+
+// publisherRTP records what a publisher sends, and relays it to its subscribers
+func (p *Plugin) publisherRTP(pub *publisher, packet *plugins.RTPPacket) {
+	if !rtp.IsRTP(packet.Buffer) {
+		return
+	}
+	rm := pub.room
+	rm.mutex.Lock()
+	fir := time.Duration(rm.Fir_freq) * time.Second
+	rm.mutex.Unlock()
+	pub.mutex.Lock()
+	if !pub.offered {
+		pub.mutex.Unlock()
+		return
+	}
+	active, rec, codec := pub.audioActive, pub.arc, pub.audioCodec
+	if packet.Video {
+		active, rec, codec = pub.videoActive, pub.vrc, pub.videoCodec
+	}
+	simulcast := pub.simulcast
+	subscribers := pub.subscribers
+	keyframe := packet.Video && fir > 0 && time.Since(pub.lastPLI) >= fir
+	pub.mutex.Unlock()
+	if !active {
+		return
+	}
+	if keyframe {
+		p.sendPLI(pub)
+	}
+	// of simulcast videos, the lowest substream is recorded
+	if rec != nil && (!packet.Video || !simulcast.Enabled() ||
+		(len(simulcast.SSRCs) > 0 && rtp.SSRC(packet.Buffer) == simulcast.SSRCs[0])) {
+		rec.Save(packet.Buffer)
+	}
+	for _, sub := range subscribers {
+		p.relayRTP(sub, pub, packet, codec, &simulcast)
+	}
+}
+
+// publisherRTCP keeps reminding publishers of their bitrate: keyframe
+// requests come from subscribers instead
+func (p *Plugin) publisherRTCP(pub *publisher, packet *plugins.RTCPPacket) {
+	pub.mutex.Lock()
+	due := pub.active && time.Since(pub.lastREMB) >= rembInterval
+	remb := pub.bitrate
+	pub.mutex.Unlock()
+	if !due {
+		return
+	}
+	if remb == 0 {
+		pub.room.mutex.Lock()
+		remb = pub.room.Bitrate
+		pub.room.mutex.Unlock()
+	}
+	if remb > 0 {
+		p.sendREMB(pub, remb)
+	}
+}
+
+func (p *Plugin) publisherData(pub *publisher, packet *plugins.DataPacket) {
+	pub.mutex.Lock()
+	active, rec, subscribers := pub.dataActive && pub.hasData, pub.drc, pub.subscribers
+	pub.mutex.Unlock()
+	if !active {
+		return
+	}
+	if rec != nil {
+		rec.Save(packet.Buffer)
+	}
+	for _, sub := range subscribers {
+		p.relayData(sub, pub, packet)
+	}
+}
+
+// publisherSlowLink tells the publisher it's losing packets
+func (p *Plugin) publisherSlowLink(pub *publisher) {
+	pub.mutex.Lock()
+	bitrate := pub.bitrate
+	pub.mutex.Unlock()
+	log.Warnf("[%s] Publisher %d is getting a lot of NACKs", Package, pub.id)
+	p.gateway.PushEvent(pub.s.ps, p, "", map[string]interface{}{
+		"videoroom":       "slow_link",
+		"current-bitrate": bitrate,
+	}, nil)
+}
@@ -0,0 +1,524 @@
+package videoroom
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/sdp"
+	"github.com/xroger88/go-janus/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RoomConfig describes a room, both in the configuration and in create
+// requests (where elements have the same names)
+type RoomConfig struct {
+	// Room is the unique id of the room, a random one if 0
+	Room        uint64
+	Description string
+	// Is_private rooms don't show up in list requests
+	Is_private bool
+	// Secret is needed to edit, destroy and kick, Pin to join
+	Secret string
+	Pin    string
+	// Require_pvtid only lets subscribers with the private_id of a
+	// publisher in the room subscribe
+	Require_pvtid bool
+	// Publishers is how many participants can publish at the same time
+	Publishers int
+	// Bitrate is the REMB sent to publishers (0 for no limit); with
+	// Bitrate_cap, publishers can't ask for more via configure
+	Bitrate     uint32
+	Bitrate_cap bool
+	// Fir_freq is how often (in seconds) to ask publishers for keyframes
+	Fir_freq int
+	// Audiocodec and Videocodec are the codecs publishers may use, in
+	// order of preference, e.g. "opus,pcmu" and "vp8,h264"
+	Audiocodec string
+	Videocodec string
+	// Record publishers to Rec_dir
+	Record  bool
+	Rec_dir string
+	// Notify_joining tells participants about new ones, not just publishers
+	Notify_joining bool
+}
+
+// defaultRoom is what create requests start from
+func defaultRoom() RoomConfig {
+	return RoomConfig{Publishers: 3, Audiocodec: "opus", Videocodec: "vp8"}
+}
+
+type room struct {
+	mutex sync.Mutex
+	RoomConfig
+	audioCodecs, videoCodecs []string
+	// participants are the publishers, whether they publish or not
+	participants map[uint64]*publisher
+	privateIDs   map[uint32]*publisher
+	destroyed    bool
+}
+
+// parseCodecs checks a comma separated list of codecs
+func parseCodecs(t sdp.MediaType, list, name string) ([]string, error) {
+	var codecs []string
+	for _, c := range strings.Split(list, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		if !sdp.KnownCodec(t, c) {
+			return nil, invalid(name, "has an unsupported codec ("+c+")")
+		}
+		codecs = append(codecs, c)
+	}
+	if len(codecs) == 0 {
+		return nil, invalid(name, "has no codec")
+	}
+	return codecs, nil
+}
+
+func newRoom(rc RoomConfig) (*room, error) {
+	if rc.Publishers < 1 {
+		return nil, invalid("publishers", "should be at least 1")
+	}
+	if rc.Fir_freq < 0 {
+		return nil, invalid("fir_freq", "should not be negative")
+	}
+	if rc.Audiocodec == "" {
+		rc.Audiocodec = "opus"
+	}
+	if rc.Videocodec == "" {
+		rc.Videocodec = "vp8"
+	}
+	audio, err := parseCodecs(sdp.Audio, rc.Audiocodec, "audiocodec")
+	if err != nil {
+		return nil, err
+	}
+	video, err := parseCodecs(sdp.Video, rc.Videocodec, "videocodec")
+	if err != nil {
+		return nil, err
+	}
+	if rc.Room == 0 {
+		rc.Room = util.RandomUint64()
+	}
+	return &room{
+		RoomConfig:   rc,
+		audioCodecs:  audio,
+		videoCodecs:  video,
+		participants: make(map[uint64]*publisher),
+		privateIDs:   make(map[uint32]*publisher),
+	}, nil
+}
+
+// room returns a room which hasn't been destroyed
+func (p *Plugin) room(id uint64) (*room, error) {
+	p.mutex.Lock()
+	r := p.rooms[id]
+	p.mutex.Unlock()
+	if r == nil {
+		return nil, fail(ErrorNoSuchRoom, "No such room (%d)", id)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.destroyed {
+		return nil, fail(ErrorNoSuchRoom, "No such room (%d)", id)
+	}
+	return r, nil
+}
+
+// publishing returns the participants publishing, other than except;
+// the caller holds the mutex of the room
+func (r *room) publishing(except *publisher) []*publisher {
+	var list []*publisher
+	for _, pub := range r.participants {
+		if pub == except {
+			continue
+		}
+		pub.mutex.Lock()
+		active := pub.active
+		pub.mutex.Unlock()
+		if active {
+			list = append(list, pub)
+		}
+	}
+	return list
+}
+
+// broadcast sends an event to the participants of a room, but except
+func (p *Plugin) broadcast(r *room, except *publisher, event map[string]interface{}) {
+	r.mutex.Lock()
+	var list []*publisher
+	for _, pub := range r.participants {
+		if pub != except {
+			list = append(list, pub)
+		}
+	}
+	r.mutex.Unlock()
+	for _, pub := range list {
+		if err := p.gateway.PushEvent(pub.s.ps, p, "", event, nil); err != nil {
+			log.Warnf("[%s] Error notifying participant %d: %v", Package, pub.id, err)
+		}
+	}
+}
+
+// save writes the configuration with the permanent rooms; the caller holds
+// the mutex of the plugin
+func (p *Plugin) save() error {
+	data, err := yaml.Marshal(&p.config)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(p.configPath, Package+".yaml"), data, 0644)
+}
+
+// savePermanent replaces (or removes, if rc is nil) a room in the
+// configuration file
+func (p *Plugin) savePermanent(id uint64, rc *RoomConfig) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	rooms := p.config.Rooms[:0:0]
+	for _, c := range p.config.Rooms {
+		if c.Room != id {
+			rooms = append(rooms, c)
+		}
+	}
+	if rc != nil {
+		rooms = append(rooms, *rc)
+	}
+	p.config.Rooms = rooms
+	if err := p.save(); err != nil {
+		log.Errorf("[%s] Error saving the configuration: %v", Package, err)
+		return fail(ErrorUnknown, "Error saving the configuration: %v", err)
+	}
+	return nil
+}
+
+// manage handles the requests about rooms; admin is set for the ones via
+// the Admin API, which need no secrets
+func (p *Plugin) manage(request string, body json.RawMessage, admin bool) map[string]interface{} {
+	var (
+		response map[string]interface{}
+		err      error
+	)
+	switch request {
+	case "create":
+		response, err = p.create(body, admin)
+	case "edit":
+		response, err = p.edit(body, admin)
+	case "destroy":
+		response, err = p.destroy(body, admin)
+	case "exists":
+		response, err = p.exists(body)
+	case "list":
+		response, err = p.list(body, admin)
+	case "listparticipants":
+		response, err = p.listParticipants(body)
+	case "kick":
+		response, err = p.kick(body, admin)
+	}
+	if err != nil {
+		log.Warnf("[%s] %v", Package, err)
+		return errorEvent(err)
+	}
+	return response
+}
+
+// authorize checks the secret of a room, which the caller holds the mutex of
+func (r *room) authorize(secret string, admin bool) error {
+	if !admin && r.Secret != "" && secret != r.Secret {
+		return fail(ErrorUnauthorized, "Unauthorized (wrong secret)")
+	}
+	return nil
+}
+
+func (p *Plugin) create(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	rc := defaultRoom()
+	r := struct {
+		*RoomConfig
+		Admin_key string
+		Permanent bool
+	}{RoomConfig: &rc}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if key := p.config.General.Admin_key; key != "" && !admin && r.Admin_key != key {
+		if r.Admin_key == "" {
+			return nil, missing("admin_key")
+		}
+		return nil, fail(ErrorUnauthorized, "Unauthorized (wrong admin_key)")
+	}
+	rm, err := newRoom(rc)
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	if rc.Room == 0 {
+		for p.rooms[rm.Room] != nil {
+			rm.Room = util.RandomUint64()
+		}
+	} else if p.rooms[rm.Room] != nil {
+		p.mutex.Unlock()
+		return nil, fail(ErrorRoomExists, "Room %d already exists", rm.Room)
+	}
+	p.rooms[rm.Room] = rm
+	p.mutex.Unlock()
+	log.Infof("[%s] Created room %d (%s)", Package, rm.Room, rm.Description)
+	p.notify(nil, map[string]interface{}{"event": "created", "room": rm.Room})
+	if r.Permanent {
+		if err := p.savePermanent(rm.Room, &rm.RoomConfig); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"videoroom": "created", "room": rm.Room, "permanent": r.Permanent}, nil
+}
+
+func (p *Plugin) edit(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		Room          *uint64 `json:"room"`
+		Secret        string  `json:"secret"`
+		Description   *string `json:"new_description"`
+		IsPrivate     *bool   `json:"new_is_private"`
+		NewSecret     *string `json:"new_secret"`
+		Pin           *string `json:"new_pin"`
+		RequirePvtID  *bool   `json:"new_require_pvtid"`
+		Publishers    *int    `json:"new_publishers"`
+		Bitrate       *uint32 `json:"new_bitrate"`
+		FirFreq       *int    `json:"new_fir_freq"`
+		RecDir        *string `json:"new_rec_dir"`
+		NotifyJoining *bool   `json:"new_notify_joining"`
+		Permanent     bool    `json:"permanent"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Room == nil {
+		return nil, missing("room")
+	}
+	if r.Publishers != nil && *r.Publishers < 1 {
+		return nil, invalid("new_publishers", "should be at least 1")
+	}
+	if r.FirFreq != nil && *r.FirFreq < 0 {
+		return nil, invalid("new_fir_freq", "should not be negative")
+	}
+	rm, err := p.room(*r.Room)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	if err := rm.authorize(r.Secret, admin); err != nil {
+		rm.mutex.Unlock()
+		return nil, err
+	}
+	if r.Description != nil && *r.Description != "" {
+		rm.Description = *r.Description
+	}
+	if r.IsPrivate != nil {
+		rm.Is_private = *r.IsPrivate
+	}
+	if r.NewSecret != nil {
+		rm.Secret = *r.NewSecret
+	}
+	if r.Pin != nil {
+		rm.Pin = *r.Pin
+	}
+	if r.RequirePvtID != nil {
+		rm.Require_pvtid = *r.RequirePvtID
+	}
+	if r.Publishers != nil {
+		rm.Publishers = *r.Publishers
+	}
+	if r.Bitrate != nil {
+		rm.Bitrate = *r.Bitrate
+	}
+	if r.FirFreq != nil {
+		rm.Fir_freq = *r.FirFreq
+	}
+	if r.RecDir != nil {
+		rm.Rec_dir = *r.RecDir
+	}
+	if r.NotifyJoining != nil {
+		rm.Notify_joining = *r.NotifyJoining
+	}
+	rc := rm.RoomConfig
+	rm.mutex.Unlock()
+	log.Infof("[%s] Edited room %d", Package, rc.Room)
+	p.notify(nil, map[string]interface{}{"event": "edited", "room": rc.Room})
+	if r.Permanent {
+		if err := p.savePermanent(rc.Room, &rc); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"videoroom": "edited", "room": rc.Room, "permanent": r.Permanent}, nil
+}
+
+func (p *Plugin) destroy(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		Room      *uint64 `json:"room"`
+		Secret    string  `json:"secret"`
+		Permanent bool    `json:"permanent"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Room == nil {
+		return nil, missing("room")
+	}
+	rm, err := p.room(*r.Room)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	if err := rm.authorize(r.Secret, admin); err != nil {
+		rm.mutex.Unlock()
+		return nil, err
+	}
+	rm.destroyed = true
+	var list []*publisher
+	for _, pub := range rm.participants {
+		list = append(list, pub)
+	}
+	rm.mutex.Unlock()
+	p.mutex.Lock()
+	delete(p.rooms, rm.Room)
+	p.mutex.Unlock()
+
+	// everybody out
+	event := map[string]interface{}{"videoroom": "destroyed", "room": rm.Room}
+	for _, pub := range list {
+		p.gateway.PushEvent(pub.s.ps, p, "", event, nil)
+		p.remove(pub)
+		p.gateway.ClosePC(pub.s.ps)
+	}
+	log.Infof("[%s] Destroyed room %d", Package, rm.Room)
+	p.notify(nil, map[string]interface{}{"event": "destroyed", "room": rm.Room})
+	if r.Permanent {
+		if err := p.savePermanent(rm.Room, nil); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"videoroom": "destroyed", "room": rm.Room, "permanent": r.Permanent}, nil
+}
+
+func (p *Plugin) exists(body json.RawMessage) (map[string]interface{}, error) {
+	var r struct {
+		Room *uint64 `json:"room"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Room == nil {
+		return nil, missing("room")
+	}
+	_, err := p.room(*r.Room)
+	return map[string]interface{}{"videoroom": "success", "room": *r.Room, "exists": err == nil}, nil
+}
+
+// list returns the public rooms, and the private ones too for admins
+func (p *Plugin) list(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		AdminKey string `json:"admin_key"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if key := p.config.General.Admin_key; key != "" && r.AdminKey == key {
+		admin = true
+	}
+	p.mutex.Lock()
+	rooms := make([]*room, 0, len(p.rooms))
+	for _, rm := range p.rooms {
+		rooms = append(rooms, rm)
+	}
+	p.mutex.Unlock()
+	list := make([]map[string]interface{}, 0, len(rooms))
+	for _, rm := range rooms {
+		rm.mutex.Lock()
+		if !rm.destroyed && (!rm.Is_private || admin) {
+			list = append(list, map[string]interface{}{
+				"room":             rm.Room,
+				"description":      rm.Description,
+				"pin_required":     rm.Pin != "",
+				"max_publishers":   rm.Publishers,
+				"bitrate":          rm.Bitrate,
+				"bitrate_cap":      rm.Bitrate_cap,
+				"fir_freq":         rm.Fir_freq,
+				"audiocodec":       strings.Join(rm.audioCodecs, ","),
+				"videocodec":       strings.Join(rm.videoCodecs, ","),
+				"record":           rm.Record,
+				"notify_joining":   rm.Notify_joining,
+				"num_participants": len(rm.participants),
+			})
+		}
+		rm.mutex.Unlock()
+	}
+	return map[string]interface{}{"videoroom": "success", "list": list}, nil
+}
+
+func (p *Plugin) listParticipants(body json.RawMessage) (map[string]interface{}, error) {
+	var r struct {
+		Room *uint64 `json:"room"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Room == nil {
+		return nil, missing("room")
+	}
+	rm, err := p.room(*r.Room)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	list := make([]map[string]interface{}, 0, len(rm.participants))
+	for _, pub := range rm.participants {
+		pub.mutex.Lock()
+		participant := map[string]interface{}{"id": pub.id, "publisher": pub.active}
+		if pub.display != "" {
+			participant["display"] = pub.display
+		}
+		pub.mutex.Unlock()
+		list = append(list, participant)
+	}
+	rm.mutex.Unlock()
+	return map[string]interface{}{"videoroom": "participants", "room": *r.Room, "participants": list}, nil
+}
+
+// kick makes a participant leave, and hangs up its PeerConnection
+func (p *Plugin) kick(body json.RawMessage, admin bool) (map[string]interface{}, error) {
+	var r struct {
+		Room   *uint64 `json:"room"`
+		Secret string  `json:"secret"`
+		ID     *uint64 `json:"id"`
+	}
+	if err := decode(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Room == nil {
+		return nil, missing("room")
+	}
+	if r.ID == nil {
+		return nil, missing("id")
+	}
+	rm, err := p.room(*r.Room)
+	if err != nil {
+		return nil, err
+	}
+	rm.mutex.Lock()
+	if err := rm.authorize(r.Secret, admin); err != nil {
+		rm.mutex.Unlock()
+		return nil, err
+	}
+	pub := rm.participants[*r.ID]
+	rm.mutex.Unlock()
+	if pub == nil {
+		return nil, fail(ErrorNoSuchFeed, "No such user %d in room %d", *r.ID, rm.Room)
+	}
+	p.gateway.PushEvent(pub.s.ps, p, "", map[string]interface{}{
+		"videoroom": "event", "room": rm.Room, "leaving": "ok", "reason": "kicked",
+	}, nil)
+	p.leave(pub, map[string]interface{}{"videoroom": "event", "room": rm.Room, "kicked": pub.id})
+	return map[string]interface{}{"videoroom": "success"}, nil
+}
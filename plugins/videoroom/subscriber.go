@@ -0,0 +1,438 @@
+package videoroom
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/rtcp"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sdp"
+)
+
+// subscriber gets the media of a publisher (its feed) on its own handle
+type subscriber struct {
+	s         *session
+	room      *room
+	privateID uint32
+
+	mutex sync.Mutex
+	// feed is nil once the publisher is gone
+	feed *publisher
+	// what the offer has, with the payload types of the codecs
+	audioCodec, videoCodec string
+	audioPT, videoPT       int
+	hasData                bool
+	// what is relayed
+	audioActive, videoActive, dataActive bool
+	// started once the answer arrived, and not paused
+	started, paused bool
+
+	sim                *rtp.SimulcastContext
+	audioCtx, videoCtx rtp.SwitchingContext
+	vp8                rtp.VP8Context
+}
+
+func (sub *subscriber) query(info map[string]interface{}) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	info["room"] = sub.room.Room
+	if sub.privateID != 0 {
+		info["private_id"] = sub.privateID
+	}
+	if sub.feed != nil {
+		info["feed"] = sub.feed.id
+	}
+	info["audio_active"] = sub.audioActive
+	info["video_active"] = sub.videoActive
+	info["data_active"] = sub.dataActive
+	info["started"] = sub.started
+	info["paused"] = sub.paused
+	if sub.feed != nil {
+		sub.feed.mutex.Lock()
+		simulcast := sub.feed.simulcast.Enabled()
+		sub.feed.mutex.Unlock()
+		if simulcast {
+			info["simulcast"] = map[string]interface{}{
+				"substream":        sub.sim.Substream,
+				"substream-target": sub.sim.SubstreamTarget,
+				"temporal-layer":   sub.sim.Temporal,
+				"temporal-target":  sub.sim.TemporalTarget,
+			}
+		}
+	}
+}
+
+// subscribe adds a subscriber to the ones of a publisher
+func (pub *publisher) subscribe(sub *subscriber) {
+	pub.mutex.Lock()
+	defer pub.mutex.Unlock()
+	// copied, so that the media path can use the slice without locking
+	subscribers := make([]*subscriber, 0, len(pub.subscribers)+1)
+	pub.subscribers = append(append(subscribers, pub.subscribers...), sub)
+}
+
+func (pub *publisher) unsubscribe(sub *subscriber) {
+	pub.mutex.Lock()
+	defer pub.mutex.Unlock()
+	subscribers := make([]*subscriber, 0, len(pub.subscribers))
+	for _, other := range pub.subscribers {
+		if other != sub {
+			subscribers = append(subscribers, other)
+		}
+	}
+	pub.subscribers = subscribers
+}
+
+// detach stops getting the media of the feed
+func (sub *subscriber) detach() {
+	sub.mutex.Lock()
+	pub := sub.feed
+	sub.feed = nil
+	sub.started = false
+	sub.mutex.Unlock()
+	if pub != nil {
+		pub.unsubscribe(sub)
+	}
+}
+
+// joinSubscriber subscribes to a publisher, and sends an offer with its media
+func (p *Plugin) joinSubscriber(s *session, rm *room, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Feed       *uint64 `json:"feed"`
+		PrivateID  uint32  `json:"private_id"`
+		Audio      *bool   `json:"audio"`
+		Video      *bool   `json:"video"`
+		Data       *bool   `json:"data"`
+		OfferAudio *bool   `json:"offer_audio"`
+		OfferVideo *bool   `json:"offer_video"`
+		OfferData  *bool   `json:"offer_data"`
+		Substream  *int    `json:"substream"`
+		Temporal   *int    `json:"temporal"`
+		Fallback   *uint   `json:"fallback"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Feed == nil {
+		return nil, nil, missing("feed")
+	}
+	if err := checkLayers(r.Substream, r.Temporal); err != nil {
+		return nil, nil, err
+	}
+	rm.mutex.Lock()
+	pub := rm.participants[*r.Feed]
+	owner := rm.privateIDs[r.PrivateID]
+	requirePvtID := rm.Require_pvtid
+	rm.mutex.Unlock()
+	if requirePvtID && owner == nil {
+		return nil, nil, fail(ErrorUnauthorized, "Unauthorized (this room requires a valid private_id)")
+	}
+	if pub == nil {
+		return nil, nil, fail(ErrorNoSuchFeed, "No such feed (%d)", *r.Feed)
+	}
+
+	sub := &subscriber{
+		s:           s,
+		room:        rm,
+		privateID:   r.PrivateID,
+		feed:        pub,
+		audioActive: r.Audio == nil || *r.Audio,
+		videoActive: r.Video == nil || *r.Video,
+		dataActive:  r.Data == nil || *r.Data,
+		sim:         rtp.NewSimulcastContext(),
+	}
+	if r.Substream != nil {
+		sub.sim.SubstreamTarget = *r.Substream
+	}
+	if r.Temporal != nil {
+		sub.sim.TemporalTarget = *r.Temporal
+	}
+	if r.Fallback != nil {
+		sub.sim.Fallback = time.Duration(*r.Fallback) * time.Microsecond
+	}
+	opts := sdp.OfferOptions{
+		Name:           "VideoRoom " + strconv.FormatUint(rm.Room, 10),
+		AudioDirection: sdp.SendOnly,
+		VideoDirection: sdp.SendOnly,
+		Extensions:     []string{sdp.ExtMid, sdp.ExtAbsSendTime, sdp.ExtTransportWideCC, sdp.ExtPlayoutDelay},
+	}
+	pub.mutex.Lock()
+	if !pub.offered {
+		pub.mutex.Unlock()
+		return nil, nil, fail(ErrorNoSuchFeed, "No such feed (%d)", *r.Feed)
+	}
+	if pub.audioCodec != "" && (r.OfferAudio == nil || *r.OfferAudio) {
+		sub.audioCodec, sub.audioPT = pub.audioCodec, pub.audioPT
+		opts.AudioCodec, opts.AudioPT, opts.AudioFmtp = pub.audioCodec, pub.audioPT, pub.audioFmtp
+	}
+	if pub.videoCodec != "" && (r.OfferVideo == nil || *r.OfferVideo) {
+		sub.videoCodec, sub.videoPT = pub.videoCodec, pub.videoPT
+		opts.VideoCodec, opts.VideoPT, opts.VideoFmtp = pub.videoCodec, pub.videoPT, pub.videoFmtp
+	}
+	sub.hasData = pub.hasData && (r.OfferData == nil || *r.OfferData)
+	opts.Data = sub.hasData
+	display := pub.display
+	pub.mutex.Unlock()
+	pub.subscribe(sub)
+
+	s.mutex.Lock()
+	s.participant = sub
+	s.mutex.Unlock()
+	log.Infof("[%s] Subscribed to feed %d in room %d", Package, pub.id, rm.Room)
+	p.notify(s.ps, map[string]interface{}{"event": "subscribing", "room": rm.Room, "feed": pub.id, "private_id": r.PrivateID})
+
+	event := map[string]interface{}{"videoroom": "attached", "room": rm.Room, "id": pub.id}
+	if display != "" {
+		event["display"] = display
+	}
+	offer := sdp.GenerateOffer(opts)
+	return event, &plugins.JSEP{Type: "offer", SDP: offer.String()}, nil
+}
+
+func checkLayers(substream, temporal *int) error {
+	if substream != nil && (*substream < 0 || *substream > 2) {
+		return invalid("substream", "should be 0, 1 or 2")
+	}
+	if temporal != nil && (*temporal < 0 || *temporal > 2) {
+		return invalid("temporal", "should be 0, 1 or 2")
+	}
+	return nil
+}
+
+func (p *Plugin) subscriberRequest(sub *subscriber, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	rm := sub.room
+	if _, err := p.room(rm.Room); err != nil {
+		return nil, nil, err
+	}
+	switch m.request {
+	case "start":
+		if m.jsep != nil {
+			if m.jsep.Type != "answer" {
+				return nil, nil, fail(ErrorInvalidSDPType, "Unexpected %s, subscribers send answers", m.jsep.Type)
+			}
+			if _, err := sdp.Parse(m.jsep.SDP); err != nil {
+				return nil, nil, fail(ErrorInvalidSDP, "Error parsing answer: %v", err)
+			}
+		}
+		sub.mutex.Lock()
+		if sub.feed == nil {
+			sub.mutex.Unlock()
+			return nil, nil, fail(ErrorNoSuchFeed, "The feed is gone")
+		}
+		sub.started, sub.paused = true, false
+		sub.mutex.Unlock()
+		sub.requestKeyframe(p)
+		return map[string]interface{}{"videoroom": "event", "room": rm.Room, "started": "ok"}, nil, nil
+	case "pause":
+		sub.mutex.Lock()
+		sub.paused = true
+		sub.mutex.Unlock()
+		return map[string]interface{}{"videoroom": "event", "room": rm.Room, "paused": "ok"}, nil, nil
+	case "configure":
+		return p.configureSubscriber(sub, m)
+	case "switch":
+		return p.switchFeed(sub, m)
+	case "leave":
+		sub.detach()
+		sub.s.mutex.Lock()
+		sub.s.participant = nil
+		sub.s.mutex.Unlock()
+		p.gateway.ClosePC(sub.s.ps)
+		return map[string]interface{}{"videoroom": "event", "room": rm.Room, "left": "ok"}, nil, nil
+	}
+	return nil, nil, fail(ErrorInvalidRequest, "Unknown request '%s' for a subscriber", m.request)
+}
+
+// configureSubscriber changes what a subscriber gets
+func (p *Plugin) configureSubscriber(sub *subscriber, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Audio     *bool `json:"audio"`
+		Video     *bool `json:"video"`
+		Data      *bool `json:"data"`
+		Substream *int  `json:"substream"`
+		Temporal  *int  `json:"temporal"`
+		Fallback  *uint `json:"fallback"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, nil, err
+	}
+	if err := checkLayers(r.Substream, r.Temporal); err != nil {
+		return nil, nil, err
+	}
+	sub.mutex.Lock()
+	keyframe := false
+	if r.Audio != nil {
+		sub.audioActive = *r.Audio
+	}
+	if r.Video != nil {
+		keyframe = *r.Video && !sub.videoActive
+		sub.videoActive = *r.Video
+	}
+	if r.Data != nil {
+		sub.dataActive = *r.Data
+	}
+	if r.Substream != nil && *r.Substream != sub.sim.SubstreamTarget {
+		sub.sim.SubstreamTarget = *r.Substream
+		keyframe = true
+	}
+	if r.Temporal != nil && *r.Temporal != sub.sim.TemporalTarget {
+		sub.sim.TemporalTarget = *r.Temporal
+		keyframe = true
+	}
+	if r.Fallback != nil {
+		sub.sim.Fallback = time.Duration(*r.Fallback) * time.Microsecond
+	}
+	sub.mutex.Unlock()
+	if keyframe {
+		sub.requestKeyframe(p)
+	}
+	return map[string]interface{}{"videoroom": "event", "room": sub.room.Room, "configured": "ok"}, nil, nil
+}
+
+// switchFeed moves a subscriber to another publisher, without any
+// renegotiation: the new one must use the same codecs
+func (p *Plugin) switchFeed(sub *subscriber, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Feed *uint64 `json:"feed"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Feed == nil {
+		return nil, nil, missing("feed")
+	}
+	rm := sub.room
+	rm.mutex.Lock()
+	pub := rm.participants[*r.Feed]
+	rm.mutex.Unlock()
+	if pub == nil {
+		return nil, nil, fail(ErrorNoSuchFeed, "No such feed (%d)", *r.Feed)
+	}
+	pub.mutex.Lock()
+	offered, audioCodec, videoCodec, display := pub.offered, pub.audioCodec, pub.videoCodec, pub.display
+	pub.mutex.Unlock()
+	if !offered {
+		return nil, nil, fail(ErrorNoSuchFeed, "No such feed (%d)", *r.Feed)
+	}
+	sub.mutex.Lock()
+	if (sub.audioCodec != "" && sub.audioCodec != audioCodec) || (sub.videoCodec != "" && sub.videoCodec != videoCodec) {
+		sub.mutex.Unlock()
+		return nil, nil, invalid("feed", "uses other codecs than the current one")
+	}
+	old := sub.feed
+	if old == pub {
+		sub.mutex.Unlock()
+		return map[string]interface{}{"videoroom": "event", "room": rm.Room, "switched": "ok", "id": pub.id}, nil, nil
+	}
+	sub.feed = pub
+	// the packets of the new feed must follow the ones of the old one
+	sub.audioCtx.Reset()
+	sub.videoCtx.Reset()
+	sub.vp8.Reset()
+	targets := sub.sim
+	sub.sim = rtp.NewSimulcastContext()
+	sub.sim.SubstreamTarget, sub.sim.TemporalTarget, sub.sim.Fallback = targets.SubstreamTarget, targets.TemporalTarget, targets.Fallback
+	sub.mutex.Unlock()
+	if old != nil {
+		old.unsubscribe(sub)
+	}
+	pub.subscribe(sub)
+	sub.requestKeyframe(p)
+	log.Infof("[%s] Switched subscriber to feed %d in room %d", Package, pub.id, rm.Room)
+
+	event := map[string]interface{}{"videoroom": "event", "room": rm.Room, "switched": "ok", "id": pub.id}
+	if display != "" {
+		event["display"] = display
+	}
+	return event, nil, nil
+}
+
+// requestKeyframe asks the feed of a subscriber for a keyframe
+func (sub *subscriber) requestKeyframe(p *Plugin) {
+	sub.mutex.Lock()
+	pub := sub.feed
+	sub.mutex.Unlock()
+	if pub != nil {
+		p.sendPLI(pub)
+	}
+}
+
+// subscriberRTCP passes the keyframe requests of subscribers to their feed
+func (p *Plugin) subscriberRTCP(sub *subscriber, packet *plugins.RTCPPacket) {
+	if packet.Video && rtcp.HasKeyframeRequest(packet.Buffer) {
+		sub.requestKeyframe(p)
+	}
+}
+
+// relayRTP sends a packet of pub to one of its subscribers, picking the
+// simulcast substream it wants and keeping sequence numbers and timestamps
+// continuous across feed switches
+func (p *Plugin) relayRTP(sub *subscriber, pub *publisher, packet *plugins.RTPPacket, codec string, simulcast *rtp.Simulcast) {
+	sub.mutex.Lock()
+	if sub.feed != pub || !sub.started || sub.paused ||
+		(packet.Video && (!sub.videoActive || sub.videoCodec == "")) ||
+		(!packet.Video && (!sub.audioActive || sub.audioCodec == "")) {
+		sub.mutex.Unlock()
+		return
+	}
+	var events []map[string]interface{}
+	needKeyframe := false
+	relay := true
+	if packet.Video && simulcast.Enabled() {
+		r := sub.sim.ProcessRTP(packet.Buffer, simulcast, codec)
+		needKeyframe = r.NeedKeyframe
+		if r.SubstreamChanged {
+			sub.vp8.Reset()
+			events = append(events, map[string]interface{}{"videoroom": "event", "room": sub.room.Room, "substream": sub.sim.Substream})
+		}
+		if r.TemporalChanged {
+			events = append(events, map[string]interface{}{"videoroom": "event", "room": sub.room.Room, "temporal": sub.sim.Temporal})
+		}
+		if !r.Relay {
+			if r.Skipped {
+				sub.videoCtx.Skip()
+			}
+			relay = false
+		}
+	}
+	var buf []byte
+	if relay {
+		// each subscriber gets its own copy, rewritten for it
+		buf = append([]byte(nil), packet.Buffer...)
+		if packet.Video {
+			rtp.SetPayloadType(buf, uint8(sub.videoPT))
+			sub.videoCtx.Update(buf, 90000)
+			if simulcast.Enabled() && codec == "vp8" {
+				if payload, err := rtp.Payload(buf); err == nil {
+					sub.vp8.Update(payload)
+				}
+			}
+		} else {
+			rtp.SetPayloadType(buf, uint8(sub.audioPT))
+			sub.audioCtx.Update(buf, sdp.ClockRate(codec))
+		}
+	}
+	sub.mutex.Unlock()
+
+	for _, event := range events {
+		p.gateway.PushEvent(sub.s.ps, p, "", event, nil)
+	}
+	if needKeyframe {
+		p.sendPLI(pub)
+	}
+	if buf != nil {
+		p.gateway.RelayRTP(sub.s.ps, &plugins.RTPPacket{Video: packet.Video, Mindex: -1, Buffer: buf})
+	}
+}
+
+func (p *Plugin) relayData(sub *subscriber, pub *publisher, packet *plugins.DataPacket) {
+	sub.mutex.Lock()
+	relay := sub.feed == pub && sub.started && !sub.paused && sub.hasData && sub.dataActive
+	sub.mutex.Unlock()
+	if relay {
+		p.gateway.RelayData(sub.s.ps, packet)
+	}
+}
@@ -0,0 +1,528 @@
+package videoroom
+
+// The VideoRoom plugin is an SFU: in each room, participants join as
+// publishers and send their media to the plugin, which relays it to
+// whoever subscribes to their feed, on a separate handle. Requests and
+// events are the ones of janus.plugin.videoroom of the original Janus, so
+// the videoroom demo of janus.js works unchanged.
+//
+// Rooms are managed with synchronous requests, answered right away:
+//
+//	{"request": "create", "room": 1234, "description": "...", "secret": "...",
+//	 "pin": "...", "is_private": false, "require_pvtid": false,
+//	 "publishers": 3, "bitrate": 128000, "bitrate_cap": false, "fir_freq": 10,
+//	 "audiocodec": "opus,pcmu", "videocodec": "vp8,h264",
+//	 "record": false, "rec_dir": "...", "notify_joining": false, "permanent": false}
+//	{"request": "edit", "room": 1234, "secret": "...", "new_description": "...", ...}
+//	{"request": "destroy", "room": 1234, "secret": "...", "permanent": false}
+//	{"request": "exists", "room": 1234}
+//	{"request": "list"}
+//	{"request": "listparticipants", "room": 1234}
+//	{"request": "kick", "room": 1234, "secret": "...", "id": 5678}
+//
+// Participants use asynchronous requests, answered with events:
+//
+//	{"request": "join", "ptype": "publisher", "room": 1234, "id": 5678, "display": "...", "pin": "..."}
+//	{"request": "join", "ptype": "subscriber", "room": 1234, "feed": 5678, "private_id": 42}
+//	{"request": "joinandconfigure", ...} (join as a publisher, then configure)
+//	{"request": "publish" or "configure", "audio": true, "video": true, "data": true,
+//	 "bitrate": 128000, "keyframe": true, "record": false, "filename": "...", "display": "..."}
+//	{"request": "unpublish"}
+//	{"request": "start"}, {"request": "pause"}, {"request": "switch", "feed": 91011}
+//	{"request": "configure", "audio": true, "video": true, "data": true,
+//	 "substream": 2, "temporal": 2, "fallback": 250000} (subscribers)
+//	{"request": "leave"}
+//
+// Publishers send an offer with publish (or configure) and get an answer;
+// subscribers get an offer when they join, and send the answer with start.
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/sdp"
+)
+
+const Package = "janus.plugin.videoroom"
+
+// error codes of the events and responses
+const (
+	ErrorNoMessage        = 421
+	ErrorInvalidJSON      = 422
+	ErrorInvalidRequest   = 423
+	ErrorJoinFirst        = 424
+	ErrorAlreadyJoined    = 425
+	ErrorNoSuchRoom       = 426
+	ErrorRoomExists       = 427
+	ErrorNoSuchFeed       = 428
+	ErrorMissingElement   = 429
+	ErrorInvalidElement   = 430
+	ErrorInvalidSDPType   = 431
+	ErrorPublishersFull   = 432
+	ErrorUnauthorized     = 433
+	ErrorAlreadyPublished = 434
+	ErrorNotPublished     = 435
+	ErrorIDExists         = 436
+	ErrorInvalidSDP       = 437
+	ErrorUnknown          = 499
+)
+
+// extensions accepted from publishers and offered to subscribers
+var extensions = []string{
+	sdp.ExtMid, sdp.ExtRid, sdp.ExtRepairedRid, sdp.ExtAudioLevel, sdp.ExtVideoOrientation,
+	sdp.ExtAbsSendTime, sdp.ExtTransportWideCC, sdp.ExtPlayoutDelay,
+}
+
+// Config is the content of janus.plugin.videoroom.yaml in the configs folder
+type Config struct {
+	General struct {
+		// Admin_key, if set, must be provided to create rooms
+		Admin_key string
+		// Events tells whether to notify the event handlers
+		Events bool
+	}
+	// Rooms are created at startup; permanent rooms created or changed
+	// via the API are saved here too
+	Rooms []RoomConfig
+}
+
+type Plugin struct {
+	config     Config
+	configPath string
+	gateway    plugins.Callbacks
+	messages   chan *message
+	done       chan struct{}
+
+	// mutex protects rooms, and the rooms of the configuration
+	mutex sync.Mutex
+	rooms map[uint64]*room
+}
+
+// message is an asynchronous request waiting for the handler
+type message struct {
+	ps          *plugins.PluginSession
+	transaction string
+	request     string
+	body        json.RawMessage
+	jsep        *plugins.JSEP
+}
+
+// session is the state of a handle attached to the plugin, which joins a
+// room either as a publisher or as a subscriber
+type session struct {
+	ps *plugins.PluginSession
+
+	mutex sync.Mutex
+	// participant is a *publisher or a *subscriber, nil until joined
+	participant interface{}
+	hangingUp   int32
+	destroyed   int32
+}
+
+// requestError is an error along with the code to tell clients about it
+type requestError struct {
+	code int
+	err  error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func fail(code int, format string, args ...interface{}) error {
+	return &requestError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// errorEvent describes an error the way clients expect it
+func errorEvent(err error) map[string]interface{} {
+	code := ErrorUnknown
+	if e, ok := err.(*requestError); ok {
+		code = e.code
+	}
+	return map[string]interface{}{"videoroom": "event", "error_code": code, "error": err.Error()}
+}
+
+// decode parses the body of a request, with the right error codes
+func decode(body json.RawMessage, out interface{}) error {
+	err := plugins.Decode(body, out)
+	switch {
+	case err == nil:
+		return nil
+	case err == plugins.ErrNoMessage:
+		return &requestError{ErrorNoMessage, err}
+	case err == plugins.ErrInvalidJSON:
+		return &requestError{ErrorInvalidJSON, err}
+	}
+	if e, ok := err.(*plugins.ElementError); ok && e.Missing {
+		return &requestError{ErrorMissingElement, err}
+	}
+	return &requestError{ErrorInvalidElement, err}
+}
+
+func missing(name string) error {
+	return &requestError{ErrorMissingElement, plugins.Missing(name)}
+}
+
+func invalid(name, reason string) error {
+	return &requestError{ErrorInvalidElement, plugins.Invalid(name, reason)}
+}
+
+func init() {
+	plugins.Register(New())
+}
+
+// New returns the VideoRoom plugin, to be started with Init
+func New() *Plugin {
+	return &Plugin{
+		messages: make(chan *message, 100),
+		done:     make(chan struct{}),
+		rooms:    make(map[uint64]*room),
+	}
+}
+
+func (p *Plugin) Package() string { return Package }
+func (p *Plugin) Name() string    { return "JANUS VideoRoom plugin" }
+func (p *Plugin) Description() string {
+	return "This is a plugin implementing a videoconferencing SFU (Selective Forwarding Unit) for Janus, that is an audio/video router."
+}
+func (p *Plugin) Author() string        { return api.Author }
+func (p *Plugin) Version() int          { return api.Version }
+func (p *Plugin) VersionString() string { return api.VersionString }
+
+// Init reads the configuration, creates the rooms it lists and starts the
+// message handler
+func (p *Plugin) Init(gateway plugins.Callbacks, configPath string) error {
+	p.config.General.Events = true
+	if err := config.LoadComponent(configPath, Package, &p.config); err != nil {
+		return err
+	}
+	p.configPath = configPath
+	p.gateway = gateway
+	for _, rc := range p.config.Rooms {
+		r, err := newRoom(rc)
+		if err != nil {
+			log.Warnf("[%s] Skipping room %d: %v", Package, rc.Room, err)
+			continue
+		}
+		if _, ok := p.rooms[r.Room]; ok {
+			log.Warnf("[%s] Skipping room %d: defined twice", Package, rc.Room)
+			continue
+		}
+		p.rooms[r.Room] = r
+		log.Infof("[%s] Created room %d (%s)", Package, r.Room, r.Description)
+	}
+	go p.handler()
+	return nil
+}
+
+func (p *Plugin) Destroy() {
+	select {
+	case <-p.done:
+		return
+	default:
+		close(p.done)
+	}
+	p.mutex.Lock()
+	for id, r := range p.rooms {
+		r.mutex.Lock()
+		r.destroyed = true
+		r.mutex.Unlock()
+		delete(p.rooms, id)
+	}
+	p.mutex.Unlock()
+	log.Infof("%s destroyed", Package)
+}
+
+func getSession(ps *plugins.PluginSession) *session {
+	s, _ := ps.Plugin.(*session)
+	if s == nil || atomic.LoadInt32(&s.destroyed) == 1 {
+		return nil
+	}
+	return s
+}
+
+// publisher and subscriber return what the session joined as, or nil
+func (s *session) publisher() *publisher {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pub, _ := s.participant.(*publisher)
+	return pub
+}
+
+func (s *session) subscriber() *subscriber {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sub, _ := s.participant.(*subscriber)
+	return sub
+}
+
+func (p *Plugin) CreateSession(ps *plugins.PluginSession) error {
+	ps.Plugin = &session{ps: ps}
+	return nil
+}
+
+// DestroySession makes the participant leave, if it didn't yet
+func (p *Plugin) DestroySession(ps *plugins.PluginSession) error {
+	s := getSession(ps)
+	if s == nil {
+		return fmt.Errorf("no session associated with this handle")
+	}
+	atomic.StoreInt32(&s.destroyed, 1)
+	if pub := s.publisher(); pub != nil {
+		p.leave(pub, nil)
+	} else if sub := s.subscriber(); sub != nil {
+		sub.detach()
+	}
+	s.mutex.Lock()
+	s.participant = nil
+	s.mutex.Unlock()
+	return nil
+}
+
+func (p *Plugin) QuerySession(ps *plugins.PluginSession) interface{} {
+	s := getSession(ps)
+	if s == nil {
+		return nil
+	}
+	info := map[string]interface{}{
+		"hangingup": atomic.LoadInt32(&s.hangingUp),
+		"destroyed": atomic.LoadInt32(&s.destroyed),
+	}
+	if pub := s.publisher(); pub != nil {
+		info["type"] = "publisher"
+		pub.query(info)
+	} else if sub := s.subscriber(); sub != nil {
+		info["type"] = "subscriber"
+		sub.query(info)
+	} else {
+		info["type"] = "none"
+	}
+	return info
+}
+
+// HandleMessage answers the requests about rooms right away, and queues the
+// ones of participants for the handler
+func (p *Plugin) HandleMessage(ps *plugins.PluginSession, transaction string, body json.RawMessage, jsep *plugins.JSEP) *plugins.Result {
+	if getSession(ps) == nil {
+		return &plugins.Result{Type: plugins.ResultError, Text: "No session associated with this handle"}
+	}
+	var r struct {
+		Request *string `json:"request"`
+	}
+	if err := decode(body, &r); err != nil {
+		return &plugins.Result{Type: plugins.ResultOK, Content: errorEvent(err)}
+	}
+	if r.Request == nil {
+		return &plugins.Result{Type: plugins.ResultOK, Content: errorEvent(missing("request"))}
+	}
+	switch *r.Request {
+	case "create", "edit", "destroy", "exists", "list", "listparticipants", "kick":
+		return &plugins.Result{Type: plugins.ResultOK, Content: p.manage(*r.Request, body, false)}
+	case "join", "joinandconfigure", "configure", "publish", "unpublish", "start", "pause", "switch", "leave":
+	default:
+		err := fail(ErrorInvalidRequest, "Unknown request '%s'", *r.Request)
+		return &plugins.Result{Type: plugins.ResultOK, Content: errorEvent(err)}
+	}
+	select {
+	case p.messages <- &message{ps: ps, transaction: transaction, request: *r.Request, body: body, jsep: jsep}:
+	case <-p.done:
+		return &plugins.Result{Type: plugins.ResultError, Text: "Shutting down"}
+	}
+	return &plugins.Result{Type: plugins.ResultOKWait}
+}
+
+// HandleAdminMessage accepts the requests about rooms via the Admin API
+func (p *Plugin) HandleAdminMessage(body json.RawMessage) interface{} {
+	var r struct {
+		Request *string `json:"request"`
+	}
+	if err := decode(body, &r); err != nil {
+		return errorEvent(err)
+	}
+	if r.Request == nil {
+		return errorEvent(missing("request"))
+	}
+	switch *r.Request {
+	case "create", "edit", "destroy", "exists", "list", "listparticipants", "kick":
+		return p.manage(*r.Request, body, true)
+	}
+	return errorEvent(fail(ErrorInvalidRequest, "Unknown request '%s'", *r.Request))
+}
+
+func (p *Plugin) SetupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil {
+		return
+	}
+	log.Infof("[%s] WebRTC media is now available", Package)
+	atomic.StoreInt32(&s.hangingUp, 0)
+	if pub := s.publisher(); pub != nil {
+		p.published(pub)
+	} else if sub := s.subscriber(); sub != nil {
+		sub.requestKeyframe(p)
+	}
+}
+
+func (p *Plugin) IncomingRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 {
+		return
+	}
+	if pub := s.publisher(); pub != nil {
+		p.publisherRTP(pub, packet)
+	}
+}
+
+func (p *Plugin) IncomingRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 {
+		return
+	}
+	if pub := s.publisher(); pub != nil {
+		p.publisherRTCP(pub, packet)
+	} else if sub := s.subscriber(); sub != nil {
+		p.subscriberRTCP(sub, packet)
+	}
+}
+
+func (p *Plugin) IncomingData(ps *plugins.PluginSession, packet *plugins.DataPacket) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 || len(packet.Buffer) == 0 {
+		return
+	}
+	if pub := s.publisher(); pub != nil {
+		p.publisherData(pub, packet)
+	}
+}
+
+// SlowLink asks slow publishers for less (subscribers get what publishers
+// send, so there's nothing to do for them but pick a lower substream)
+func (p *Plugin) SlowLink(ps *plugins.PluginSession, uplink, video bool) {
+	s := getSession(ps)
+	if s == nil || atomic.LoadInt32(&s.hangingUp) == 1 || !video {
+		return
+	}
+	if pub := s.publisher(); pub != nil && uplink {
+		p.publisherSlowLink(pub)
+	}
+}
+
+// HangupMedia unpublishes publishers and detaches subscribers from their feed
+func (p *Plugin) HangupMedia(ps *plugins.PluginSession) {
+	s := getSession(ps)
+	if s == nil || !atomic.CompareAndSwapInt32(&s.hangingUp, 0, 1) {
+		return
+	}
+	log.Infof("[%s] No WebRTC media anymore", Package)
+	if pub := s.publisher(); pub != nil {
+		p.unpublished(pub, true)
+	} else if sub := s.subscriber(); sub != nil {
+		sub.detach()
+	}
+}
+
+func (p *Plugin) notify(ps *plugins.PluginSession, event map[string]interface{}) {
+	if p.config.General.Events && p.gateway.EventsIsEnabled() {
+		p.gateway.NotifyEvent(p, ps, event)
+	}
+}
+
+// handler processes the requests of participants, one at a time
+func (p *Plugin) handler() {
+	for {
+		select {
+		case m := <-p.messages:
+			p.handle(m)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Plugin) handle(m *message) {
+	s := getSession(m.ps)
+	if s == nil {
+		log.Warnf("[%s] No session associated with this handle", Package)
+		return
+	}
+	if m.jsep != nil && m.jsep.Type != "offer" && m.jsep.Type != "answer" {
+		p.pushError(m, fail(ErrorInvalidSDPType, "Unsupported SDP type '%s'", m.jsep.Type))
+		return
+	}
+	var (
+		event map[string]interface{}
+		jsep  *plugins.JSEP
+		err   error
+	)
+	pub, sub := s.publisher(), s.subscriber()
+	switch {
+	case m.request == "join" || m.request == "joinandconfigure":
+		if pub != nil || sub != nil {
+			err = fail(ErrorAlreadyJoined, "Already in as a %s on this handle", map[bool]string{true: "publisher", false: "subscriber"}[pub != nil])
+			break
+		}
+		event, jsep, err = p.join(s, m)
+	case pub != nil:
+		event, jsep, err = p.publisherRequest(pub, m)
+	case sub != nil:
+		event, jsep, err = p.subscriberRequest(sub, m)
+	default:
+		err = fail(ErrorJoinFirst, "Invalid request on unconfigured participant")
+	}
+	if err != nil {
+		p.pushError(m, err)
+		return
+	}
+	if err := p.gateway.PushEvent(m.ps, p, m.transaction, event, jsep); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+}
+
+func (p *Plugin) pushError(m *message, err error) {
+	log.Warnf("[%s] %v", Package, err)
+	if err := p.gateway.PushEvent(m.ps, p, m.transaction, errorEvent(err), nil); err != nil {
+		log.Warnf("[%s] Error pushing event: %v", Package, err)
+	}
+}
+
+// join adds the session to a room, as a publisher or a subscriber
+func (p *Plugin) join(s *session, m *message) (map[string]interface{}, *plugins.JSEP, error) {
+	var r struct {
+		Ptype *string `json:"ptype"`
+		Room  *uint64 `json:"room"`
+		Pin   string  `json:"pin"`
+	}
+	if err := decode(m.body, &r); err != nil {
+		return nil, nil, err
+	}
+	if r.Ptype == nil {
+		return nil, nil, missing("ptype")
+	}
+	if r.Room == nil {
+		return nil, nil, missing("room")
+	}
+	rm, err := p.room(*r.Room)
+	if err != nil {
+		return nil, nil, err
+	}
+	rm.mutex.Lock()
+	pin := rm.Pin
+	rm.mutex.Unlock()
+	if pin != "" && r.Pin != pin {
+		return nil, nil, fail(ErrorUnauthorized, "Unauthorized (wrong pin)")
+	}
+	switch *r.Ptype {
+	case "publisher":
+		return p.joinPublisher(s, rm, m)
+	case "subscriber", "listener":
+		if m.request == "joinandconfigure" {
+			return nil, nil, invalid("ptype", "should be publisher for joinandconfigure")
+		}
+		return p.joinSubscriber(s, rm, m)
+	}
+	return nil, nil, invalid("ptype", "should be publisher or subscriber")
+}
@@ -0,0 +1,270 @@
+package videoroom
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/plugins"
+)
+
+// testGateway stands in for the core: the events of the plugin go to the
+// channel each handle has in its Gateway
+type testGateway struct {
+	plugins.Callbacks
+}
+
+type testEvent struct {
+	transaction string
+	data        map[string]interface{}
+	jsep        *plugins.JSEP
+}
+
+func (g *testGateway) PushEvent(ps *plugins.PluginSession, plugin plugins.Plugin, transaction string, message interface{}, jsep *plugins.JSEP) error {
+	e := testEvent{transaction: transaction, jsep: jsep}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &e.data); err != nil {
+		return err
+	}
+	ps.Gateway.(chan testEvent) <- e
+	return nil
+}
+
+func (g *testGateway) RelayRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket)   {}
+func (g *testGateway) RelayRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {}
+func (g *testGateway) RelayData(ps *plugins.PluginSession, packet *plugins.DataPacket) {}
+func (g *testGateway) ClosePC(ps *plugins.PluginSession)                               {}
+func (g *testGateway) EventsIsEnabled() bool                                           { return false }
+
+func startPlugin(t *testing.T) *Plugin {
+	p := New()
+	if err := p.Init(&testGateway{}, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Destroy)
+	return p
+}
+
+// attach creates a handle, whose events can be taken with next
+func attach(t *testing.T, p *Plugin) *plugins.PluginSession {
+	ps := plugins.NewPluginSession(make(chan testEvent, 100))
+	if err := p.CreateSession(ps); err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+func next(t *testing.T, ps *plugins.PluginSession) testEvent {
+	t.Helper()
+	select {
+	case e := <-ps.Gateway.(chan testEvent):
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return testEvent{}
+}
+
+// request sends a synchronous request, and returns the response
+func request(t *testing.T, p *Plugin, ps *plugins.PluginSession, body string) map[string]interface{} {
+	t.Helper()
+	r := p.HandleMessage(ps, "", json.RawMessage(body), nil)
+	if r.Type != plugins.ResultOK {
+		t.Fatalf("got %+v sending %s", r, body)
+	}
+	data, _ := json.Marshal(r.Content)
+	var response map[string]interface{}
+	json.Unmarshal(data, &response)
+	return response
+}
+
+// send sends an asynchronous request, and returns the event answering it
+func send(t *testing.T, p *Plugin, ps *plugins.PluginSession, body string, jsep *plugins.JSEP) testEvent {
+	t.Helper()
+	if r := p.HandleMessage(ps, "t", json.RawMessage(body), jsep); r.Type != plugins.ResultOKWait {
+		t.Fatalf("got %+v sending %s", r, body)
+	}
+	for {
+		if e := next(t, ps); e.transaction == "t" {
+			return e
+		}
+	}
+}
+
+func code(m map[string]interface{}) int {
+	c, _ := m["error_code"].(float64)
+	return int(c)
+}
+
+const offer = "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\na=group:BUNDLE 0 1\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\nc=IN IP4 0.0.0.0\r\na=mid:0\r\na=rtpmap:111 opus/48000/2\r\na=sendrecv\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96\r\nc=IN IP4 0.0.0.0\r\na=mid:1\r\na=rtpmap:96 VP8/90000\r\na=sendrecv\r\n"
+
+func TestRooms(t *testing.T) {
+	p := startPlugin(t)
+	ps := attach(t, p)
+	tests := []struct {
+		name string
+		body string
+		want string // the videoroom element of the response
+		code int
+	}{
+		{"create", `{"request":"create","room":1234,"secret":"s","description":"test"}`, "created", 0},
+		{"create again", `{"request":"create","room":1234}`, "", ErrorRoomExists},
+		{"invalid codec", `{"request":"create","videocodec":"nope"}`, "", ErrorInvalidElement},
+		{"exists", `{"request":"exists","room":1234}`, "success", 0},
+		{"list", `{"request":"list"}`, "success", 0},
+		{"edit without the secret", `{"request":"edit","room":1234,"new_description":"x"}`, "", ErrorUnauthorized},
+		{"edit", `{"request":"edit","room":1234,"secret":"s","new_description":"x"}`, "edited", 0},
+		{"no such room", `{"request":"listparticipants","room":42}`, "", ErrorNoSuchRoom},
+		{"missing room", `{"request":"destroy"}`, "", ErrorMissingElement},
+		{"kick nobody", `{"request":"kick","room":1234,"secret":"s","id":5}`, "", ErrorNoSuchFeed},
+		{"unknown request", `{"request":"dance"}`, "", ErrorInvalidRequest},
+		{"no request", `{}`, "", ErrorMissingElement},
+		{"invalid request", `{"request":5}`, "", ErrorInvalidElement},
+		{"destroy", `{"request":"destroy","room":1234,"secret":"s"}`, "destroyed", 0},
+		{"destroy again", `{"request":"destroy","room":1234,"secret":"s"}`, "", ErrorNoSuchRoom},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := request(t, p, ps, test.body)
+			if code(r) != test.code {
+				t.Fatalf("got %v, want error code %d", r, test.code)
+			}
+			if test.want != "" && r["videoroom"] != test.want {
+				t.Errorf("got %v, want %s", r, test.want)
+			}
+		})
+	}
+	if r := p.HandleMessage(ps, "", json.RawMessage(`{"request":`), nil); r.Type != plugins.ResultOK {
+		t.Errorf("got %+v for invalid JSON", r)
+	}
+}
+
+func TestJoinAndLeave(t *testing.T) {
+	p := startPlugin(t)
+	admin := attach(t, p)
+	request(t, p, admin, `{"request":"create","room":1,"pin":"pin","notify_joining":true,"publishers":1}`)
+
+	alice, bob, carol := attach(t, p), attach(t, p), attach(t, p)
+	errors := []struct {
+		name string
+		ps   *plugins.PluginSession
+		body string
+		jsep *plugins.JSEP
+		code int
+	}{
+		{"not joined", alice, `{"request":"configure"}`, nil, ErrorJoinFirst},
+		{"wrong pin", alice, `{"request":"join","ptype":"publisher","room":1}`, nil, ErrorUnauthorized},
+		{"no such room", alice, `{"request":"join","ptype":"publisher","room":2}`, nil, ErrorNoSuchRoom},
+		{"no ptype", alice, `{"request":"join","room":1,"pin":"pin"}`, nil, ErrorMissingElement},
+		{"bad jsep", alice, `{"request":"join","ptype":"publisher","room":1,"pin":"pin"}`, &plugins.JSEP{Type: "pranswer"}, ErrorInvalidSDPType},
+	}
+	for _, test := range errors {
+		t.Run(test.name, func(t *testing.T) {
+			if e := send(t, p, test.ps, test.body, test.jsep); code(e.data) != test.code {
+				t.Errorf("got %v, want error code %d", e.data, test.code)
+			}
+		})
+	}
+
+	if e := send(t, p, alice, `{"request":"join","ptype":"publisher","room":1,"pin":"pin","id":7,"display":"Alice"}`, nil); e.data["videoroom"] != "joined" || e.data["id"] != 7.0 {
+		t.Fatalf("got %v joining", e.data)
+	}
+	if e := send(t, p, alice, `{"request":"join","ptype":"publisher","room":1,"pin":"pin"}`, nil); code(e.data) != ErrorAlreadyJoined {
+		t.Errorf("got %v joining twice", e.data)
+	}
+	if e := send(t, p, bob, `{"request":"join","ptype":"publisher","room":1,"pin":"pin","id":7}`, nil); code(e.data) != ErrorIDExists {
+		t.Errorf("got %v joining with a taken id", e.data)
+	}
+	if e := send(t, p, carol, `{"request":"join","ptype":"subscriber","room":1,"pin":"pin","feed":7}`, nil); code(e.data) != ErrorNoSuchFeed {
+		t.Errorf("got %v subscribing to a feed not published", e.data)
+	}
+	if e := send(t, p, alice, `{"request":"unpublish"}`, nil); code(e.data) != ErrorNotPublished {
+		t.Errorf("got %v unpublishing before publishing", e.data)
+	}
+	if e := send(t, p, alice, `{"request":"publish"}`, &plugins.JSEP{Type: "answer", SDP: offer}); code(e.data) != ErrorInvalidSDPType {
+		t.Errorf("got %v publishing an answer", e.data)
+	}
+	if e := send(t, p, alice, `{"request":"publish"}`, &plugins.JSEP{Type: "offer", SDP: "garbage"}); code(e.data) != ErrorInvalidSDP {
+		t.Errorf("got %v publishing garbage", e.data)
+	}
+	e := send(t, p, alice, `{"request":"publish"}`, &plugins.JSEP{Type: "offer", SDP: offer})
+	if e.data["configured"] != "ok" || e.jsep == nil || e.jsep.Type != "answer" || e.data["video_codec"] != "vp8" {
+		t.Fatalf("got %v %v publishing", e.data, e.jsep)
+	}
+	if e := send(t, p, alice, `{"request":"publish"}`, &plugins.JSEP{Type: "offer", SDP: offer}); code(e.data) != ErrorAlreadyPublished {
+		t.Errorf("got %v publishing twice", e.data)
+	}
+	// the others only hear about it once the PeerConnection is up
+	p.SetupMedia(alice)
+
+	// bob is told about alice, and alice about bob
+	e = send(t, p, bob, `{"request":"join","ptype":"publisher","room":1,"pin":"pin","id":8}`, nil)
+	if publishers, _ := e.data["publishers"].([]interface{}); len(publishers) != 1 {
+		t.Errorf("got %v, want alice publishing", e.data)
+	}
+	if e := next(t, alice); e.data["joining"] == nil {
+		t.Errorf("got %v, want bob joining", e.data)
+	}
+	if e := send(t, p, bob, `{"request":"publish"}`, &plugins.JSEP{Type: "offer", SDP: offer}); code(e.data) != ErrorPublishersFull {
+		t.Errorf("got %v publishing in a full room", e.data)
+	}
+
+	e = send(t, p, carol, `{"request":"join","ptype":"subscriber","room":1,"pin":"pin","feed":7}`, nil)
+	if e.data["videoroom"] != "attached" || e.jsep == nil || e.jsep.Type != "offer" {
+		t.Fatalf("got %v %v subscribing", e.data, e.jsep)
+	}
+	if e := send(t, p, carol, `{"request":"start"}`, &plugins.JSEP{Type: "offer", SDP: offer}); code(e.data) != ErrorInvalidSDPType {
+		t.Errorf("got %v starting with an offer", e.data)
+	}
+	if e := send(t, p, carol, `{"request":"configure","substream":3}`, nil); code(e.data) != ErrorInvalidElement {
+		t.Errorf("got %v asking for substream 3", e.data)
+	}
+
+	if participants := request(t, p, admin, `{"request":"listparticipants","room":1}`)["participants"].([]interface{}); len(participants) != 2 {
+		t.Errorf("got participants %v, want alice and bob", participants)
+	}
+	if e := send(t, p, bob, `{"request":"leave"}`, nil); e.data["leaving"] != "ok" {
+		t.Errorf("got %v leaving", e.data)
+	}
+	if e := next(t, alice); e.data["leaving"] != 8.0 {
+		t.Errorf("got %v, want bob leaving", e.data)
+	}
+	// the handle going away is leaving too
+	if err := p.DestroySession(alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DestroySession(alice); err == nil {
+		t.Error("got a handle destroyed twice")
+	}
+	if participants := request(t, p, admin, `{"request":"listparticipants","room":1}`)["participants"].([]interface{}); len(participants) != 0 {
+		t.Errorf("got participants %v after everybody left", participants)
+	}
+	if e := send(t, p, carol, `{"request":"start"}`, nil); code(e.data) != ErrorNoSuchFeed {
+		t.Errorf("got %v starting a feed that's gone", e.data)
+	}
+}
+
+func TestDestroyRoom(t *testing.T) {
+	p := startPlugin(t)
+	admin, alice := attach(t, p), attach(t, p)
+	request(t, p, admin, `{"request":"create","room":1,"secret":"s"}`)
+	send(t, p, alice, `{"request":"join","ptype":"publisher","room":1}`, nil)
+	if r := request(t, p, admin, `{"request":"destroy","room":1}`); code(r) != ErrorUnauthorized {
+		t.Errorf("got %v destroying without the secret", r)
+	}
+	request(t, p, admin, `{"request":"destroy","room":1,"secret":"s"}`)
+	if e := next(t, alice); e.data["videoroom"] != "destroyed" {
+		t.Errorf("got %v, want the room destroyed", e.data)
+	}
+	if e := send(t, p, alice, `{"request":"configure"}`, nil); code(e.data) != ErrorJoinFirst && code(e.data) != ErrorNoSuchRoom {
+		t.Errorf("got %v configuring in a destroyed room", e.data)
+	}
+	if r := request(t, p, admin, `{"request":"exists","room":1}`); r["exists"] != false {
+		t.Errorf("got %v, want the room gone", r)
+	}
+}
@@ -0,0 +1,166 @@
+package record
+
+// Recordings of RTP and data channel traffic, in the .mjr format of the
+// original Janus, so that its janus-pp-rec post-processor can turn them into
+// playable files. A recording is a single audio, video or data stream:
+//
+//	"MJR00002"
+//	info length (uint16) | info (JSON: t = a/v/d, c = codec, s = created, u = first frame, µs)
+//	for each frame:
+//	"MEET" | ms since the first frame (uint32) | length (uint16) | frame
+//
+// Data frames are prefixed with the time they were received (int64, µs).
+// While recording, the file name gets the recordings_tmp_ext extension of
+// conf.yaml, if any, which is removed when the recording is closed.
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/config"
+)
+
+// Kind is what a recording is made of
+type Kind int
+
+const (
+	Audio Kind = iota
+	Video
+	Data
+)
+
+var kindCodes = [...]string{Audio: "a", Video: "v", Data: "d"}
+
+// ErrClosed is returned when saving frames to a closed recording
+var ErrClosed = errors.New("recording closed")
+
+// Recorder writes a recording
+type Recorder struct {
+	Kind  Kind
+	Codec string
+	Fmtp  string
+
+	mutex   sync.Mutex
+	path    string // final path of the file
+	file    *os.File
+	created time.Time
+	started time.Time // first frame, when the header gets written
+	closed  bool
+}
+
+// New creates a recording in dir (if not empty) named filename, to which
+// the .mjr extension is added if missing. codec is e.g. "opus" or "vp8",
+// and "text" or "binary" for data.
+func New(dir, filename string, kind Kind, codec, fmtp string) (*Recorder, error) {
+	if filename == "" {
+		return nil, errors.New("no file name")
+	}
+	if !strings.HasSuffix(filename, ".mjr") {
+		filename += ".mjr"
+	}
+	path := filename
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		path = filepath.Join(dir, filename)
+	}
+	file, err := os.OpenFile(tmpPath(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Recording %s (%s) to %s", kindCodes[kind], codec, path)
+	return &Recorder{Kind: kind, Codec: codec, Fmtp: fmtp, path: path, file: file, created: time.Now()}, nil
+}
+
+func tmpPath(path string) string {
+	if ext := strings.TrimPrefix(config.Conf.General.Recordings_tmp_ext, "."); ext != "" {
+		return path + "." + ext
+	}
+	return path
+}
+
+// Path returns where the recording ends up
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+func (r *Recorder) writeHeader() error {
+	info := map[string]interface{}{
+		"t": kindCodes[r.Kind],
+		"c": r.Codec,
+		"s": r.created.UnixNano() / int64(time.Microsecond),
+		"u": r.started.UnixNano() / int64(time.Microsecond),
+	}
+	if r.Fmtp != "" {
+		info["f"] = r.Fmtp
+	}
+	data, _ := json.Marshal(info)
+	header := make([]byte, 10, 10+len(data))
+	copy(header, "MJR00002")
+	binary.BigEndian.PutUint16(header[8:], uint16(len(data)))
+	_, err := r.file.Write(append(header, data...))
+	return err
+}
+
+// Save adds a frame: an RTP packet, or a data channel message
+func (r *Recorder) Save(frame []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	now := time.Now()
+	if r.started.IsZero() {
+		r.started = now
+		if err := r.writeHeader(); err != nil {
+			return err
+		}
+	}
+	size := len(frame)
+	if r.Kind == Data {
+		size += 8
+	}
+	if size > 0xffff {
+		return fmt.Errorf("frame too big (%d bytes)", len(frame))
+	}
+	header := make([]byte, 10, 18+len(frame))
+	copy(header, "MEET")
+	binary.BigEndian.PutUint32(header[4:], uint32(now.Sub(r.started)/time.Millisecond))
+	binary.BigEndian.PutUint16(header[8:], uint16(size))
+	if r.Kind == Data {
+		var when [8]byte
+		binary.BigEndian.PutUint64(when[:], uint64(now.UnixNano()/int64(time.Microsecond)))
+		header = append(header, when[:]...)
+	}
+	_, err := r.file.Write(append(header, frame...))
+	return err
+}
+
+// Close ends the recording, and gives the file its final name
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	if tmp := tmpPath(r.path); tmp != r.path {
+		if err := os.Rename(tmp, r.path); err != nil {
+			return err
+		}
+	}
+	log.Infof("Closed recording %s", r.path)
+	return nil
+}
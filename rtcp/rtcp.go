@@ -0,0 +1,236 @@
+package rtcp
+
+// RTCP helpers for plugins, much like the rtcp.c utilities of the original
+// Janus: finding keyframe requests and bitrate estimations in the compound
+// packets clients send, and building the feedback plugins send to them.
+// Like for RTP, SSRCs are the business of the WebRTC stack: the packets
+// built here have them set to 0, and the stack fills them in when sending.
+
+import "encoding/binary"
+
+// packet types
+const (
+	TypeSR    = 200
+	TypeRR    = 201
+	TypeSDES  = 202
+	TypeBYE   = 203
+	TypeAPP   = 204
+	TypeRTPFB = 205
+	TypePSFB  = 206
+)
+
+// feedback message types of payload-specific feedback (PSFB)
+const (
+	fmtPLI  = 1
+	fmtFIR  = 4
+	fmtREMB = 15
+)
+
+// IsRTCP tells whether a packet looks like RTCP
+func IsRTCP(buf []byte) bool {
+	return len(buf) >= 4 && buf[0]>>6 == 2 && buf[1] >= 192 && buf[1] <= 223
+}
+
+// each calls f for each packet of a compound packet, until it returns false
+func each(buf []byte, f func(pt, count int, packet []byte) bool) {
+	for len(buf) >= 4 && buf[0]>>6 == 2 {
+		size := 4 * (int(binary.BigEndian.Uint16(buf[2:])) + 1)
+		if size > len(buf) {
+			return
+		}
+		if !f(int(buf[1]), int(buf[0]&0x1f), buf[:size]) {
+			return
+		}
+		buf = buf[size:]
+	}
+}
+
+// HasPLI tells whether there's a picture loss indication
+func HasPLI(buf []byte) bool {
+	return hasFeedback(buf, fmtPLI)
+}
+
+// HasFIR tells whether there's a full intra request
+func HasFIR(buf []byte) bool {
+	return hasFeedback(buf, fmtFIR)
+}
+
+// HasKeyframeRequest tells whether there's a PLI or a FIR
+func HasKeyframeRequest(buf []byte) bool {
+	return HasPLI(buf) || HasFIR(buf)
+}
+
+func hasFeedback(buf []byte, format int) bool {
+	found := false
+	each(buf, func(pt, count int, packet []byte) bool {
+		found = pt == TypePSFB && count == format
+		return !found
+	})
+	return found
+}
+
+// rembAt returns where the REMB of a PSFB packet is, or -1
+func rembAt(packet []byte) int {
+	if len(packet) < 20 || string(packet[12:16]) != "REMB" {
+		return -1
+	}
+	return 16
+}
+
+// REMB returns the bitrate of the first REMB, or 0 if there's none
+func REMB(buf []byte) uint32 {
+	var bitrate uint32
+	each(buf, func(pt, count int, packet []byte) bool {
+		if pt != TypePSFB || count != fmtREMB {
+			return true
+		}
+		if i := rembAt(packet); i >= 0 {
+			exp := uint(packet[i+1] >> 2)
+			mantissa := uint32(packet[i+1]&0x03)<<16 | uint32(packet[i+2])<<8 | uint32(packet[i+3])
+			bitrate = mantissa << exp
+			return false
+		}
+		return true
+	})
+	return bitrate
+}
+
+// CapREMB lowers the bitrate of the REMBs to max, if they ask for more
+func CapREMB(buf []byte, max uint32) {
+	each(buf, func(pt, count int, packet []byte) bool {
+		if pt == TypePSFB && count == fmtREMB {
+			if i := rembAt(packet); i >= 0 {
+				exp := uint(packet[i+1] >> 2)
+				mantissa := uint32(packet[i+1]&0x03)<<16 | uint32(packet[i+2])<<8 | uint32(packet[i+3])
+				if mantissa<<exp > max {
+					putBitrate(packet[i+1:], max)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// putBitrate writes a bitrate as 6 bits of exponent and 18 of mantissa
+func putBitrate(b []byte, bitrate uint32) {
+	var exp uint
+	for bitrate >= 1<<18 {
+		bitrate >>= 1
+		exp++
+	}
+	b[0] = byte(exp)<<2 | byte(bitrate>>16)&0x03
+	b[1] = byte(bitrate >> 8)
+	b[2] = byte(bitrate)
+}
+
+// NewPLI returns a picture loss indication
+func NewPLI() []byte {
+	p := make([]byte, 12)
+	p[0] = 0x80 | fmtPLI
+	p[1] = TypePSFB
+	binary.BigEndian.PutUint16(p[2:], 2)
+	return p
+}
+
+// NewFIR returns a full intra request: seq must be increased for each new
+// request
+func NewFIR(seq uint8) []byte {
+	p := make([]byte, 20)
+	p[0] = 0x80 | fmtFIR
+	p[1] = TypePSFB
+	binary.BigEndian.PutUint16(p[2:], 4)
+	p[16] = seq
+	return p
+}
+
+// NewREMB returns a receiver estimated maximum bitrate message, telling the
+// client not to send more than bitrate (in bits per second)
+func NewREMB(bitrate uint32) []byte {
+	p := make([]byte, 24)
+	p[0] = 0x80 | fmtREMB
+	p[1] = TypePSFB
+	binary.BigEndian.PutUint16(p[2:], 5)
+	copy(p[12:], "REMB")
+	p[16] = 1 // one SSRC
+	putBitrate(p[17:], bitrate)
+	return p
+}
+
+// SenderSSRC returns the SSRC of whoever sent a compound packet
+func SenderSSRC(buf []byte) uint32 {
+	if len(buf) < 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(buf[4:])
+}
+
+// MediaSSRC returns the SSRC of the first stream a compound packet is
+// about (in a report block or a feedback message), or 0
+func MediaSSRC(buf []byte) uint32 {
+	var ssrc uint32
+	each(buf, func(pt, count int, packet []byte) bool {
+		switch {
+		case pt == TypeSR && count > 0 && len(packet) >= 32:
+			ssrc = binary.BigEndian.Uint32(packet[28:])
+		case pt == TypeRR && count > 0 && len(packet) >= 12:
+			ssrc = binary.BigEndian.Uint32(packet[8:])
+		case pt == TypePSFB && count == fmtFIR && len(packet) >= 16:
+			ssrc = binary.BigEndian.Uint32(packet[12:])
+		case pt == TypePSFB && count == fmtREMB:
+			if i := rembAt(packet); i >= 0 && packet[i] > 0 && len(packet) >= i+8 {
+				ssrc = binary.BigEndian.Uint32(packet[i+4:])
+			}
+		case (pt == TypeRTPFB || pt == TypePSFB) && len(packet) >= 12:
+			ssrc = binary.BigEndian.Uint32(packet[8:])
+		}
+		return ssrc == 0
+	})
+	return ssrc
+}
+
+// FixSSRC sets the SSRCs of a compound packet about to be sent: sender is
+// the SSRC of the stream the packet goes with, and media the one of the
+// stream the reports and the feedback are about
+func FixSSRC(buf []byte, sender, media uint32) {
+	each(buf, func(pt, count int, packet []byte) bool {
+		if len(packet) < 8 {
+			return true
+		}
+		switch pt {
+		case TypeSR, TypeRR:
+			binary.BigEndian.PutUint32(packet[4:], sender)
+			blocks := 8
+			if pt == TypeSR {
+				blocks = 28
+			}
+			for i := 0; i < count && blocks+24*(i+1) <= len(packet); i++ {
+				binary.BigEndian.PutUint32(packet[blocks+24*i:], media)
+			}
+		case TypeSDES, TypeBYE:
+			// the first chunk (or SSRC) is the one of the sender
+			if count > 0 {
+				binary.BigEndian.PutUint32(packet[4:], sender)
+			}
+		case TypeRTPFB, TypePSFB:
+			if len(packet) < 12 {
+				return true
+			}
+			binary.BigEndian.PutUint32(packet[4:], sender)
+			switch {
+			case pt == TypePSFB && count == fmtFIR:
+				for i := 12; i+8 <= len(packet); i += 8 {
+					binary.BigEndian.PutUint32(packet[i:], media)
+				}
+			case pt == TypePSFB && count == fmtREMB:
+				if i := rembAt(packet); i >= 0 {
+					for j := 0; j < int(packet[i]) && i+8+4*j <= len(packet); j++ {
+						binary.BigEndian.PutUint32(packet[i+4+4*j:], media)
+					}
+				}
+			default:
+				binary.BigEndian.PutUint32(packet[8:], media)
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,130 @@
+package rtcp
+
+import (
+	"testing"
+
+	pion "github.com/pion/rtcp"
+)
+
+func marshal(t *testing.T, packets ...pion.Packet) []byte {
+	t.Helper()
+	buf, err := pion.Marshal(packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestFeedback(t *testing.T) {
+	rr := &pion.ReceiverReport{SSRC: 1, Reports: []pion.ReceptionReport{{SSRC: 2}}}
+	tests := []struct {
+		name     string
+		buf      []byte
+		pli, fir bool
+		remb     uint32
+		media    uint32
+	}{
+		{"report", marshal(t, rr), false, false, 0, 2},
+		{"pli", marshal(t, rr, &pion.PictureLossIndication{SenderSSRC: 1, MediaSSRC: 3}), true, false, 0, 2},
+		{"fir alone", marshal(t, &pion.FullIntraRequest{SenderSSRC: 1, FIR: []pion.FIREntry{{SSRC: 4, SequenceNumber: 1}}}), false, true, 0, 4},
+		{"remb", marshal(t, &pion.ReceiverEstimatedMaximumBitrate{SenderSSRC: 1, Bitrate: 1500000, SSRCs: []uint32{5}}), false, false, 1500000, 5},
+		{"nack", marshal(t, &pion.TransportLayerNack{SenderSSRC: 1, MediaSSRC: 6, Nacks: []pion.NackPair{{PacketID: 1}}}), false, false, 0, 6},
+		{"truncated", marshal(t, rr, &pion.PictureLossIndication{})[:40], false, false, 0, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !IsRTCP(test.buf) {
+				t.Fatal("not RTCP")
+			}
+			if HasPLI(test.buf) != test.pli || HasFIR(test.buf) != test.fir || HasKeyframeRequest(test.buf) != (test.pli || test.fir) {
+				t.Errorf("got pli %v fir %v, want %v %v", HasPLI(test.buf), HasFIR(test.buf), test.pli, test.fir)
+			}
+			// REMB bitrates only keep 18 bits of mantissa
+			if remb := REMB(test.buf); remb > test.remb || test.remb-remb > test.remb>>17 {
+				t.Errorf("got REMB %d, want %d", remb, test.remb)
+			}
+			if ssrc := MediaSSRC(test.buf); ssrc != test.media {
+				t.Errorf("got media SSRC %d, want %d", ssrc, test.media)
+			}
+			if ssrc := SenderSSRC(test.buf); ssrc != 1 {
+				t.Errorf("got sender SSRC %d, want 1", ssrc)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for name, buf := range map[string][]byte{"pli": NewPLI(), "fir": NewFIR(3), "remb": NewREMB(300000)} {
+		packets, err := pion.Unmarshal(buf)
+		if err != nil || len(packets) != 1 {
+			t.Fatalf("%s: got %v, %v", name, packets, err)
+		}
+		switch p := packets[0].(type) {
+		case *pion.PictureLossIndication:
+			if name != "pli" {
+				t.Errorf("%s: got a PLI", name)
+			}
+		case *pion.FullIntraRequest:
+			if name != "fir" || len(p.FIR) != 1 || p.FIR[0].SequenceNumber != 3 {
+				t.Errorf("%s: got %v", name, p)
+			}
+		case *pion.ReceiverEstimatedMaximumBitrate:
+			if name != "remb" || p.Bitrate != 300000 || len(p.SSRCs) != 1 {
+				t.Errorf("%s: got %v", name, p)
+			}
+		default:
+			t.Errorf("%s: got %T", name, p)
+		}
+	}
+}
+
+func TestCapREMB(t *testing.T) {
+	buf := marshal(t, &pion.ReceiverReport{SSRC: 1}, &pion.ReceiverEstimatedMaximumBitrate{Bitrate: 2000000, SSRCs: []uint32{5}})
+	CapREMB(buf, 500000)
+	if remb := REMB(buf); remb != 500000 {
+		t.Errorf("got %d, want it capped to 500000", remb)
+	}
+	CapREMB(buf, 1000000)
+	if remb := REMB(buf); remb != 500000 {
+		t.Errorf("got %d, want it left alone under the cap", remb)
+	}
+}
+
+func TestFixSSRC(t *testing.T) {
+	buf := marshal(t,
+		&pion.SenderReport{SSRC: 9, Reports: []pion.ReceptionReport{{SSRC: 9}}},
+		&pion.SourceDescription{Chunks: []pion.SourceDescriptionChunk{{Source: 9, Items: []pion.SourceDescriptionItem{{Type: pion.SDESCNAME, Text: "x"}}}}},
+		&pion.PictureLossIndication{SenderSSRC: 9, MediaSSRC: 9},
+		&pion.FullIntraRequest{SenderSSRC: 9, FIR: []pion.FIREntry{{SSRC: 9}}},
+		&pion.ReceiverEstimatedMaximumBitrate{SenderSSRC: 9, Bitrate: 100000, SSRCs: []uint32{9, 9}},
+	)
+	FixSSRC(buf, 1, 2)
+	packets, err := pion.Unmarshal(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range packets {
+		switch p := p.(type) {
+		case *pion.SenderReport:
+			if p.SSRC != 1 || p.Reports[0].SSRC != 2 {
+				t.Errorf("got %v", p)
+			}
+		case *pion.SourceDescription:
+			if p.Chunks[0].Source != 1 {
+				t.Errorf("got %v", p)
+			}
+		case *pion.PictureLossIndication:
+			if p.SenderSSRC != 1 || p.MediaSSRC != 2 {
+				t.Errorf("got %v", p)
+			}
+		case *pion.FullIntraRequest:
+			if p.SenderSSRC != 1 || p.FIR[0].SSRC != 2 {
+				t.Errorf("got %v", p)
+			}
+		case *pion.ReceiverEstimatedMaximumBitrate:
+			if p.SenderSSRC != 1 || p.SSRCs[0] != 2 || p.SSRCs[1] != 2 {
+				t.Errorf("got %v", p)
+			}
+		}
+	}
+}
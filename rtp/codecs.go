@@ -0,0 +1,183 @@
+package rtp
+
+// Payload formats of the video codecs, as much as we need to find keyframes
+// and temporal layers, and to rewrite VP8 picture IDs.
+
+// IsKeyframe tells whether an RTP payload starts a keyframe, for the video
+// codecs we know ("vp8", "vp9", "h264"); it's false for any other codec
+func IsKeyframe(codec string, payload []byte) bool {
+	switch codec {
+	case "vp8":
+		return vp8IsKeyframe(payload)
+	case "vp9":
+		return vp9IsKeyframe(payload)
+	case "h264":
+		return h264IsKeyframe(payload)
+	}
+	return false
+}
+
+// VP8Descriptor is the VP8 payload descriptor (RFC 7741)
+type VP8Descriptor struct {
+	Start     bool // S: start of a partition
+	Partition int  // PID
+	// PictureID is -1 when missing, and has 7 or 15 bits (LongPictureID)
+	PictureID     int
+	LongPictureID bool
+	TL0PicIdx     int // -1 when missing
+	TID           int // -1 when missing
+	LayerSync     bool
+	// Size of the descriptor, i.e. where the VP8 payload starts
+	Size int
+}
+
+// ParseVP8 parses the payload descriptor of a VP8 RTP payload
+func ParseVP8(payload []byte) (*VP8Descriptor, bool) {
+	if len(payload) < 1 {
+		return nil, false
+	}
+	d := &VP8Descriptor{
+		Start:     payload[0]&0x10 != 0,
+		Partition: int(payload[0] & 0x07),
+		PictureID: -1,
+		TL0PicIdx: -1,
+		TID:       -1,
+		Size:      1,
+	}
+	if payload[0]&0x80 == 0 {
+		return d, true
+	}
+	if len(payload) < 2 {
+		return nil, false
+	}
+	x := payload[1]
+	i := 2
+	if x&0x80 != 0 {
+		if len(payload) < i+1 {
+			return nil, false
+		}
+		if payload[i]&0x80 != 0 {
+			if len(payload) < i+2 {
+				return nil, false
+			}
+			d.PictureID = int(payload[i]&0x7f)<<8 | int(payload[i+1])
+			d.LongPictureID = true
+			i += 2
+		} else {
+			d.PictureID = int(payload[i])
+			i++
+		}
+	}
+	if x&0x40 != 0 {
+		if len(payload) < i+1 {
+			return nil, false
+		}
+		d.TL0PicIdx = int(payload[i])
+		i++
+	}
+	if x&0x30 != 0 {
+		if len(payload) < i+1 {
+			return nil, false
+		}
+		if x&0x20 != 0 {
+			d.TID = int(payload[i] >> 6)
+			d.LayerSync = payload[i]&0x20 != 0
+		}
+		i++
+	}
+	d.Size = i
+	return d, len(payload) >= i
+}
+
+func vp8IsKeyframe(payload []byte) bool {
+	d, ok := ParseVP8(payload)
+	if !ok || !d.Start || d.Partition != 0 || len(payload) <= d.Size {
+		return false
+	}
+	// the P bit of the VP8 payload header is 0 for keyframes
+	return payload[d.Size]&0x01 == 0
+}
+
+func vp9IsKeyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	// not inter-picture predicted (P = 0), and beginning of a frame (B = 1)
+	return payload[0]&0x40 == 0 && payload[0]&0x08 != 0
+}
+
+func h264IsKeyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	isKey := func(nal byte) bool {
+		t := nal & 0x1f
+		return t == 5 || t == 7
+	}
+	switch payload[0] & 0x1f {
+	case 24: // STAP-A
+		for i := 1; i+2 < len(payload); {
+			size := int(payload[i])<<8 | int(payload[i+1])
+			if isKey(payload[i+2]) {
+				return true
+			}
+			i += 2 + size
+		}
+		return false
+	case 28, 29: // FU-A, FU-B
+		return len(payload) >= 2 && payload[1]&0x80 != 0 && isKey(payload[1])
+	}
+	return isKey(payload[0])
+}
+
+// VP8Context keeps the picture IDs and TL0PICIDX of relayed VP8 continuous
+// across simulcast substream switches, like SwitchingContext does for the
+// sequence numbers
+type VP8Context struct {
+	started    bool
+	picOffset  int
+	tl0Offset  int
+	lastPicID  int
+	lastTL0    int
+	switchNext bool
+}
+
+// Reset makes the next packet continue from the last one relayed
+func (c *VP8Context) Reset() {
+	c.switchNext = true
+}
+
+// Update rewrites the descriptor of a VP8 payload in place
+func (c *VP8Context) Update(payload []byte) {
+	d, ok := ParseVP8(payload)
+	if !ok || d.PictureID < 0 {
+		return
+	}
+	mod := 1 << 7
+	if d.LongPictureID {
+		mod = 1 << 15
+	}
+	if c.switchNext && c.started {
+		c.picOffset = c.lastPicID + 1 - d.PictureID
+		if d.TL0PicIdx >= 0 {
+			c.tl0Offset = c.lastTL0 + 1 - d.TL0PicIdx
+		}
+	}
+	c.started, c.switchNext = true, false
+	picID := ((d.PictureID+c.picOffset)%mod + mod) % mod
+	c.lastPicID = picID
+	i := 2
+	if d.LongPictureID {
+		payload[i] = 0x80 | byte(picID>>8)
+		payload[i+1] = byte(picID)
+		i += 2
+	} else {
+		payload[i] = byte(picID)
+		i++
+	}
+	if d.TL0PicIdx >= 0 {
+		tl0 := ((d.TL0PicIdx+c.tl0Offset)%256 + 256) % 256
+		c.lastTL0 = tl0
+		payload[i] = byte(tl0)
+	}
+}
@@ -0,0 +1,80 @@
+package rtp
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/xroger88/go-janus/config"
+)
+
+// PortRange returns the ports plain RTP can use, as set by rtp_port_range
+// in the media section of conf.yaml (any unprivileged port if unset)
+func PortRange() (min, max int) {
+	min, max = 1024, 65535
+	f := strings.SplitN(config.Conf.Media.Rtp_port_range, "-", 2)
+	if len(f) != 2 {
+		return
+	}
+	lo, err1 := strconv.Atoi(strings.TrimSpace(f[0]))
+	hi, err2 := strconv.Atoi(strings.TrimSpace(f[1]))
+	if err1 != nil || err2 != nil || lo < 1 || hi > 65535 || lo > hi {
+		return
+	}
+	return lo, hi
+}
+
+// ListenUDP binds a socket to host:port for plain RTP (host may be empty
+// for any address); port 0 picks a free even port of the PortRange
+func ListenUDP(host string, port int) (*net.UDPConn, error) {
+	ip := net.ParseIP(host)
+	if host != "" && ip == nil {
+		return nil, fmt.Errorf("invalid address %s", host)
+	}
+	if port != 0 {
+		return net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+	}
+	min, max := PortRange()
+	// even ports only, starting from a random one
+	first := (min + 1) &^ 1
+	count := (max-first)/2 + 1
+	if count <= 0 {
+		return nil, fmt.Errorf("no port available in %d-%d", min, max)
+	}
+	start := rand.Intn(count)
+	for i := 0; i < count; i++ {
+		p := first + 2*((start+i)%count)
+		if conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: p}); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("no port available in %d-%d", min, max)
+}
+
+// ListenMulticastUDP joins group on port, via the network interface named
+// iface (or the default one if empty); port 0 picks one of the PortRange
+func ListenMulticastUDP(group string, port int, iface string) (*net.UDPConn, error) {
+	ip := net.ParseIP(group)
+	if ip == nil || !ip.IsMulticast() {
+		return nil, fmt.Errorf("invalid multicast group %s", group)
+	}
+	var ifi *net.Interface
+	if iface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(iface); err != nil {
+			return nil, err
+		}
+	}
+	if port == 0 {
+		// any free port will do, as long as it's in the range
+		conn, err := ListenUDP("", 0)
+		if err != nil {
+			return nil, err
+		}
+		port = conn.LocalAddr().(*net.UDPAddr).Port
+		conn.Close()
+	}
+	return net.ListenMulticastUDP("udp", ifi, &net.UDPAddr{IP: ip, Port: port})
+}
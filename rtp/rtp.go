@@ -0,0 +1,151 @@
+package rtp
+
+// RTP helpers for plugins, much like the rtp.c utilities of the original
+// Janus: they work in place on the packets plugins get from (and relay to)
+// the WebRTC stack, which have already been decrypted. There's no need to
+// care about SSRCs when relaying: the WebRTC stack sends the packets with
+// the SSRCs it negotiated for the PeerConnection.
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// HeaderSize is the size of the fixed part of the RTP header
+const HeaderSize = 12
+
+var errTooShort = errors.New("RTP packet too short")
+
+// IsRTP tells whether a packet looks like RTP (and not RTCP)
+func IsRTP(buf []byte) bool {
+	if len(buf) < HeaderSize || buf[0]>>6 != 2 {
+		return false
+	}
+	// RTCP packet types 192-223 would be payload types 64-95 with the marker bit
+	pt := buf[1] & 0x7f
+	return pt < 64 || pt > 95
+}
+
+func PayloadType(buf []byte) uint8 { return buf[1] & 0x7f }
+func Marker(buf []byte) bool       { return buf[1]&0x80 != 0 }
+func Seq(buf []byte) uint16        { return binary.BigEndian.Uint16(buf[2:]) }
+func Timestamp(buf []byte) uint32  { return binary.BigEndian.Uint32(buf[4:]) }
+func SSRC(buf []byte) uint32       { return binary.BigEndian.Uint32(buf[8:]) }
+
+func SetPayloadType(buf []byte, pt uint8) { buf[1] = buf[1]&0x80 | pt&0x7f }
+func SetSeq(buf []byte, seq uint16)       { binary.BigEndian.PutUint16(buf[2:], seq) }
+func SetTimestamp(buf []byte, ts uint32)  { binary.BigEndian.PutUint32(buf[4:], ts) }
+func SetSSRC(buf []byte, ssrc uint32)     { binary.BigEndian.PutUint32(buf[8:], ssrc) }
+
+func SetMarker(buf []byte, marker bool) {
+	if marker {
+		buf[1] |= 0x80
+	} else {
+		buf[1] &^= 0x80
+	}
+}
+
+// headerLength returns the size of the whole header, CSRCs and extensions included
+func headerLength(buf []byte) (int, error) {
+	if len(buf) < HeaderSize {
+		return 0, errTooShort
+	}
+	n := HeaderSize + 4*int(buf[0]&0x0f)
+	if buf[0]&0x10 != 0 {
+		if len(buf) < n+4 {
+			return 0, errTooShort
+		}
+		n += 4 + 4*int(binary.BigEndian.Uint16(buf[n+2:]))
+	}
+	if len(buf) < n {
+		return 0, errTooShort
+	}
+	return n, nil
+}
+
+// Payload returns the payload of the packet, without the padding
+func Payload(buf []byte) ([]byte, error) {
+	n, err := headerLength(buf)
+	if err != nil {
+		return nil, err
+	}
+	end := len(buf)
+	if buf[0]&0x20 != 0 && end > n {
+		if padding := int(buf[end-1]); padding <= end-n {
+			end -= padding
+		}
+	}
+	return buf[n:end], nil
+}
+
+// Extension returns the value of an RFC 8285 header extension (one-byte or
+// two-byte headers), or nil if the packet doesn't have it
+func Extension(buf []byte, id int) []byte {
+	if id <= 0 || len(buf) < HeaderSize || buf[0]&0x10 == 0 {
+		return nil
+	}
+	start := HeaderSize + 4*int(buf[0]&0x0f)
+	if len(buf) < start+4 {
+		return nil
+	}
+	profile := binary.BigEndian.Uint16(buf[start:])
+	end := start + 4 + 4*int(binary.BigEndian.Uint16(buf[start+2:]))
+	if end > len(buf) {
+		return nil
+	}
+	ext := buf[start+4 : end]
+	switch {
+	case profile == 0xBEDE:
+		for i := 0; i < len(ext); {
+			if ext[i] == 0 {
+				// padding
+				i++
+				continue
+			}
+			extID, size := int(ext[i]>>4), int(ext[i]&0x0f)+1
+			if extID == 15 || i+1+size > len(ext) {
+				return nil
+			}
+			if extID == id {
+				return ext[i+1 : i+1+size]
+			}
+			i += 1 + size
+		}
+	case profile&0xfff0 == 0x1000:
+		for i := 0; i+1 < len(ext); {
+			if ext[i] == 0 {
+				i++
+				continue
+			}
+			extID, size := int(ext[i]), int(ext[i+1])
+			if i+2+size > len(ext) {
+				return nil
+			}
+			if extID == id {
+				return ext[i+2 : i+2+size]
+			}
+			i += 2 + size
+		}
+	}
+	return nil
+}
+
+// Rid returns the value of the rid (or repaired rid) extension, if any
+func Rid(buf []byte, id int) string {
+	return string(Extension(buf, id))
+}
+
+// AudioLevel returns the level (0 is the loudest, 127 silence) and the voice
+// activity flag of the ssrc-audio-level extension, if the packet has one
+func AudioLevel(buf []byte, id int) (level int, voice bool, ok bool) {
+	ext := Extension(buf, id)
+	if len(ext) < 1 {
+		return 0, false, false
+	}
+	return int(ext[0] & 0x7f), ext[0]&0x80 != 0, true
+}
+
+// newer tells whether a sequence number comes after another one, wrapping around
+func newer(seq, than uint16) bool {
+	return seq != than && seq-than < 0x8000
+}
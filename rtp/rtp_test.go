@@ -0,0 +1,310 @@
+package rtp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/config"
+)
+
+// packet builds an RTP packet, with a one-byte header extension if ext isn't nil
+func packet(pt uint8, seq uint16, ts, ssrc uint32, ext map[int][]byte, payload ...byte) []byte {
+	buf := make([]byte, HeaderSize)
+	buf[0] = 0x80
+	SetPayloadType(buf, pt)
+	SetSeq(buf, seq)
+	SetTimestamp(buf, ts)
+	SetSSRC(buf, ssrc)
+	if ext != nil {
+		buf[0] |= 0x10
+		var elements []byte
+		for id := 1; id < 15; id++ {
+			if value, ok := ext[id]; ok {
+				elements = append(elements, byte(id<<4|(len(value)-1)))
+				elements = append(elements, value...)
+			}
+		}
+		for len(elements)%4 != 0 {
+			elements = append(elements, 0)
+		}
+		buf = append(buf, 0xBE, 0xDE, 0, byte(len(elements)/4))
+		buf = append(buf, elements...)
+	}
+	return append(buf, payload...)
+}
+
+func TestHeader(t *testing.T) {
+	buf := packet(111, 65535, 4000000000, 0xdeadbeef, nil, 1, 2, 3)
+	if !IsRTP(buf) {
+		t.Fatal("not RTP")
+	}
+	if PayloadType(buf) != 111 || Seq(buf) != 65535 || Timestamp(buf) != 4000000000 || SSRC(buf) != 0xdeadbeef || Marker(buf) {
+		t.Errorf("got pt %d seq %d ts %d ssrc %x marker %v", PayloadType(buf), Seq(buf), Timestamp(buf), SSRC(buf), Marker(buf))
+	}
+	SetMarker(buf, true)
+	SetPayloadType(buf, 96)
+	if !Marker(buf) || PayloadType(buf) != 96 {
+		t.Error("the marker and the payload type should be independent")
+	}
+	SetMarker(buf, false)
+	if Marker(buf) || PayloadType(buf) != 96 {
+		t.Error("the marker and the payload type should be independent")
+	}
+	// a receiver report would be payload type 73 with the marker
+	if rr := []byte{0x80, 201, 0, 7, 0, 0, 0, 1, 0, 0, 0, 0}; IsRTP(rr) {
+		t.Error("RTCP taken for RTP")
+	}
+	if IsRTP([]byte{0x80, 111}) {
+		t.Error("short packet taken for RTP")
+	}
+}
+
+func TestPayload(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		want []byte
+		err  bool
+	}{
+		{"plain", packet(96, 1, 1, 1, nil, 1, 2, 3), []byte{1, 2, 3}, false},
+		{"extension", packet(96, 1, 1, 1, map[int][]byte{1: {0xff}}, 4, 5), []byte{4, 5}, false},
+		{"padding", append(func() []byte { b := packet(96, 1, 1, 1, nil, 6); b[0] |= 0x20; return b }(), 0, 0, 3), []byte{6}, false},
+		{"csrc", append(func() []byte { b := packet(96, 1, 1, 1, nil); b[0] |= 1; return b }(), 0, 0, 0, 9, 7), []byte{7}, false},
+		{"truncated csrc", func() []byte { b := packet(96, 1, 1, 1, nil); b[0] |= 2; return b }(), nil, true},
+		{"too short", []byte{0x80, 96, 0}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Payload(test.buf)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v", err)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExtension(t *testing.T) {
+	buf := packet(96, 1, 1, 1, map[int][]byte{1: {0x80 | 30}, 3: []byte("hi")})
+	if got := Rid(buf, 3); got != "hi" {
+		t.Errorf("got rid %q, want hi", got)
+	}
+	if level, voice, ok := AudioLevel(buf, 1); !ok || !voice || level != 30 {
+		t.Errorf("got level %d voice %v ok %v", level, voice, ok)
+	}
+	if ext := Extension(buf, 2); ext != nil {
+		t.Errorf("got %v for a missing extension", ext)
+	}
+	if _, _, ok := AudioLevel(packet(96, 1, 1, 1, nil), 1); ok {
+		t.Error("got a level without extensions")
+	}
+	// two-byte headers
+	two := packet(96, 1, 1, 1, nil)
+	two[0] |= 0x10
+	two = append(two, 0x10, 0x00, 0, 2, 5, 3, 'a', 'b', 'c', 0, 0, 0)
+	if got := Rid(two, 5); got != "abc" {
+		t.Errorf("got rid %q from two-byte headers, want abc", got)
+	}
+}
+
+func TestSwitchingContext(t *testing.T) {
+	var c SwitchingContext
+	relay := func(seq uint16, ts, ssrc uint32) (uint16, uint32) {
+		buf := packet(96, seq, ts, ssrc, nil)
+		c.Update(buf, 90000)
+		return Seq(buf), Timestamp(buf)
+	}
+	if seq, ts := relay(100, 1000, 1); seq != 100 || ts != 1000 {
+		t.Errorf("got %d/%d, want the first packet untouched", seq, ts)
+	}
+	relay(101, 4000, 1)
+	// another source carries on from the last packet
+	seq, ts := relay(5000, 9000, 2)
+	if seq != 102 || ts <= 4000 {
+		t.Errorf("got %d/%d after a switch, want 102 and a timestamp after 4000", seq, ts)
+	}
+	if seq, _ := relay(5001, 12000, 2); seq != 103 {
+		t.Errorf("got %d, want 103", seq)
+	}
+	// a skipped packet leaves no gap
+	c.Skip()
+	if seq, _ := relay(5003, 15000, 2); seq != 104 {
+		t.Errorf("got %d after a skip, want 104", seq)
+	}
+	// a reset switches even with the same SSRC
+	c.Reset()
+	if seq, _ := relay(1, 20000, 2); seq != 105 {
+		t.Errorf("got %d after a reset, want 105", seq)
+	}
+}
+
+// vp8 builds a VP8 payload with a 15 bits picture ID, TL0PICIDX and TID
+func vp8(start, keyframe bool, picID, tl0, tid int, sync bool) []byte {
+	b := []byte{0x80, 0xe0, 0x80 | byte(picID>>8), byte(picID), byte(tl0), byte(tid << 6)}
+	if start {
+		b[0] |= 0x10
+	}
+	if sync {
+		b[5] |= 0x20
+	}
+	if keyframe {
+		return append(b, 0x00)
+	}
+	return append(b, 0x01)
+}
+
+func TestVP8(t *testing.T) {
+	d, ok := ParseVP8(vp8(true, true, 300, 7, 2, true))
+	if !ok || !d.Start || d.PictureID != 300 || !d.LongPictureID || d.TL0PicIdx != 7 || d.TID != 2 || !d.LayerSync || d.Size != 6 {
+		t.Fatalf("got %+v", d)
+	}
+	if _, ok := ParseVP8([]byte{0x80}); ok {
+		t.Error("parsed a truncated descriptor")
+	}
+	if !IsKeyframe("vp8", vp8(true, true, 1, 0, 0, false)) || IsKeyframe("vp8", vp8(true, false, 1, 0, 0, false)) || IsKeyframe("vp8", vp8(false, true, 1, 0, 0, false)) {
+		t.Error("got the wrong VP8 keyframes")
+	}
+
+	var c VP8Context
+	first := vp8(true, true, 300, 7, 0, false)
+	c.Update(first)
+	c.Reset()
+	next := vp8(true, true, 32767, 200, 0, false)
+	c.Update(next)
+	if d, _ := ParseVP8(next); d.PictureID != 301 || d.TL0PicIdx != 8 {
+		t.Errorf("got picture %d tl0 %d after a switch, want 301 and 8", d.PictureID, d.TL0PicIdx)
+	}
+}
+
+func TestKeyframes(t *testing.T) {
+	tests := []struct {
+		codec   string
+		payload []byte
+		want    bool
+	}{
+		{"vp9", []byte{0x08}, true},
+		{"vp9", []byte{0x48}, false},
+		{"h264", []byte{0x65}, true},                            // IDR
+		{"h264", []byte{0x41}, false},                           // non-IDR slice
+		{"h264", []byte{0x78, 0, 2, 0x09, 0, 0, 1, 0x67}, true}, // STAP-A with an SPS
+		{"h264", []byte{0x7c, 0x85}, true},                      // FU-A start of an IDR
+		{"h264", []byte{0x7c, 0x05}, false},                     // FU-A continuation
+		{"opus", []byte{0xff}, false},
+	}
+	for _, test := range tests {
+		if got := IsKeyframe(test.codec, test.payload); got != test.want {
+			t.Errorf("%s %x: got %v, want %v", test.codec, test.payload, got, test.want)
+		}
+	}
+}
+
+func TestSimulcast(t *testing.T) {
+	s := &Simulcast{SSRCs: []uint32{10, 20, 30}}
+	if !s.Enabled() || (&Simulcast{SSRCs: []uint32{10}}).Enabled() {
+		t.Error("got the wrong Enabled")
+	}
+	c := NewSimulcastContext()
+	process := func(ssrc uint32, keyframe bool) SimulcastResult {
+		return c.ProcessRTP(packet(96, 1, 1, ssrc, nil, vp8(true, keyframe, 1, 0, 0, false)...), s, "vp8")
+	}
+	// nothing until a keyframe
+	if r := process(10, false); r.Relay || !r.NeedKeyframe {
+		t.Errorf("got %+v, want a keyframe request", r)
+	}
+	if r := process(10, true); !r.Relay || !r.SubstreamChanged || c.Substream != 0 {
+		t.Errorf("got %+v on substream %d", r, c.Substream)
+	}
+	// the highest one comes, and is switched to at its keyframe
+	process(30, false)
+	if r := process(30, true); !r.Relay || c.Substream != 2 {
+		t.Errorf("got %+v on substream %d, want 2", r, c.Substream)
+	}
+	if r := process(10, true); r.Relay {
+		t.Error("relayed the low substream along with the high one")
+	}
+	// a lower target, and a high substream that stops, go back down
+	c.SubstreamTarget = 1
+	c.lastReceived[2] = time.Now().Add(-time.Second)
+	if r := process(20, true); !r.Relay || c.Substream != 1 {
+		t.Errorf("got %+v on substream %d, want 1", r, c.Substream)
+	}
+	// unknown SSRCs are ignored
+	if r := process(40, true); r.Relay {
+		t.Error("relayed an unknown SSRC")
+	}
+}
+
+func TestSimulcastRids(t *testing.T) {
+	s := &Simulcast{Rids: []string{"l", "h"}, RidExtID: 4}
+	c := NewSimulcastContext()
+	buf := packet(96, 1, 1, 55, map[int][]byte{4: []byte("h")}, vp8(true, true, 1, 0, 0, false)...)
+	if r := c.ProcessRTP(buf, s, "vp8"); !r.Relay || c.Substream != 1 {
+		t.Fatalf("got %+v on substream %d, want 1", r, c.Substream)
+	}
+	// later packets have no rid, only the SSRC it came with
+	buf = packet(96, 2, 1, 55, nil, vp8(true, false, 2, 0, 0, false)...)
+	if r := c.ProcessRTP(buf, s, "vp8"); !r.Relay {
+		t.Errorf("got %+v without the rid", r)
+	}
+}
+
+func TestTemporalLayers(t *testing.T) {
+	s := &Simulcast{SSRCs: []uint32{10, 20}}
+	c := NewSimulcastContext()
+	c.TemporalTarget = 0
+	process := func(keyframe bool, tid int) SimulcastResult {
+		return c.ProcessRTP(packet(96, 1, 1, 10, nil, vp8(true, keyframe, 1, 0, tid, false)...), s, "vp8")
+	}
+	if r := process(true, 0); !r.Relay {
+		t.Fatalf("got %+v", r)
+	}
+	if r := process(false, 1); r.Relay || !r.Skipped {
+		t.Errorf("got %+v for a layer above the target, want it skipped", r)
+	}
+	c.TemporalTarget = 2
+	if r := process(false, 2); r.Relay {
+		t.Errorf("got %+v, want to wait for a layer sync", r)
+	}
+	r := c.ProcessRTP(packet(96, 1, 1, 10, nil, vp8(true, false, 1, 0, 2, true)...), s, "vp8")
+	if !r.Relay || !r.TemporalChanged || c.Temporal != 2 {
+		t.Errorf("got %+v with temporal %d, want 2", r, c.Temporal)
+	}
+}
+
+func TestPortRange(t *testing.T) {
+	defer func(r string) { config.Conf.Media.Rtp_port_range = r }(config.Conf.Media.Rtp_port_range)
+	tests := []struct {
+		value    string
+		min, max int
+	}{
+		{"", 1024, 65535},
+		{"20000-20010", 20000, 20010},
+		{" 20000 - 20010 ", 20000, 20010},
+		{"20010-20000", 1024, 65535},
+		{"0-100", 1024, 65535},
+		{"x-y", 1024, 65535},
+	}
+	for _, test := range tests {
+		config.Conf.Media.Rtp_port_range = test.value
+		if min, max := PortRange(); min != test.min || max != test.max {
+			t.Errorf("%q: got %d-%d, want %d-%d", test.value, min, max, test.min, test.max)
+		}
+	}
+
+	config.Conf.Media.Rtp_port_range = "40000-40099"
+	conn, err := ListenUDP("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if p := conn.LocalAddr().(*net.UDPAddr).Port; p < 40000 || p > 40099 || p%2 != 0 {
+		t.Errorf("got port %d, want an even one in the range", p)
+	}
+	if _, err := ListenUDP("nowhere", 0); err == nil {
+		t.Error("got no error for an invalid address")
+	}
+}
@@ -0,0 +1,149 @@
+package rtp
+
+import "time"
+
+// DefaultFallback is how long the substream being relayed can go without
+// packets before a lower one is used instead
+const DefaultFallback = 250 * time.Millisecond
+
+// keyframes aren't asked for more often than this
+const keyframeRequestInterval = time.Second
+
+// Simulcast tells which substreams a simulcast video is made of, as found
+// in the SDP: either SSRCs or rids (along with the id of the rid extension),
+// from the lowest to the highest quality
+type Simulcast struct {
+	SSRCs    []uint32
+	Rids     []string
+	RidExtID int
+}
+
+// Enabled tells whether there's any simulcast at all
+func (s *Simulcast) Enabled() bool {
+	return len(s.SSRCs) > 1 || len(s.Rids) > 1
+}
+
+// SimulcastContext picks which substream of a simulcast video to relay, and
+// for VP8 which temporal layers, switching substreams at keyframes only
+type SimulcastContext struct {
+	// Substream and Temporal are what's being relayed, -1 before the first keyframe
+	Substream, Temporal int
+	// SubstreamTarget and TemporalTarget are what the receiver would like
+	SubstreamTarget, TemporalTarget int
+	Fallback                        time.Duration
+
+	ridSSRCs          [3]uint32
+	lastReceived      [3]time.Time
+	keyframeRequested time.Time
+}
+
+// SimulcastResult is what ProcessRTP decided about a packet
+type SimulcastResult struct {
+	Relay            bool
+	SubstreamChanged bool
+	TemporalChanged  bool
+	// Skipped means the packet belongs to the substream being relayed, but
+	// is dropped anyway (see SwitchingContext.Skip)
+	Skipped bool
+	// NeedKeyframe asks the plugin to send a PLI to the sender, so that
+	// the switch to another substream can happen
+	NeedKeyframe bool
+}
+
+// NewSimulcastContext returns a context aiming at the highest quality
+func NewSimulcastContext() *SimulcastContext {
+	return &SimulcastContext{Substream: -1, Temporal: -1, SubstreamTarget: 2, TemporalTarget: 2, Fallback: DefaultFallback}
+}
+
+// substream returns the substream a packet belongs to, or -1
+func (c *SimulcastContext) substream(buf []byte, s *Simulcast) int {
+	ssrc := SSRC(buf)
+	for i, known := range s.SSRCs {
+		if known == ssrc && i < len(c.ridSSRCs) {
+			return i
+		}
+	}
+	if len(s.Rids) == 0 {
+		return -1
+	}
+	// rids are only in the first packets, so remember their SSRC
+	for i, known := range c.ridSSRCs {
+		if known == ssrc && known != 0 {
+			return i
+		}
+	}
+	if rid := Rid(buf, s.RidExtID); rid != "" {
+		for i, known := range s.Rids {
+			if known == rid && i < len(c.ridSSRCs) {
+				c.ridSSRCs[i] = ssrc
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// ProcessRTP looks at a packet of the simulcast video of codec, and tells
+// whether to relay it
+func (c *SimulcastContext) ProcessRTP(buf []byte, s *Simulcast, codec string) SimulcastResult {
+	var r SimulcastResult
+	sub := c.substream(buf, s)
+	if sub < 0 {
+		return r
+	}
+	now := time.Now()
+	c.lastReceived[sub] = now
+	payload, err := Payload(buf)
+	if err != nil {
+		return r
+	}
+	keyframe := IsKeyframe(codec, payload)
+
+	// aim lower if the target doesn't come
+	target := c.SubstreamTarget
+	if target > 2 {
+		target = 2
+	}
+	for target > 0 && now.Sub(c.lastReceived[target]) > c.Fallback {
+		target--
+	}
+	if sub != c.Substream {
+		closer := c.Substream < 0 || (sub > c.Substream && sub <= target) || (sub < c.Substream && sub >= target)
+		if !closer {
+			return r
+		}
+		if !keyframe {
+			if now.Sub(c.keyframeRequested) > keyframeRequestInterval {
+				c.keyframeRequested = now
+				r.NeedKeyframe = true
+			}
+			return r
+		}
+		c.Substream = sub
+		r.SubstreamChanged = true
+		if c.Temporal != c.TemporalTarget {
+			c.Temporal = c.TemporalTarget
+			r.TemporalChanged = true
+		}
+	}
+
+	if codec == "vp8" {
+		if d, ok := ParseVP8(payload); ok && d.TID >= 0 {
+			switch {
+			case c.Temporal > c.TemporalTarget:
+				// going down can happen any time
+				c.Temporal = c.TemporalTarget
+				r.TemporalChanged = true
+			case c.Temporal < c.TemporalTarget && (keyframe || d.LayerSync) && d.TID > c.Temporal && d.TID <= c.TemporalTarget:
+				c.Temporal = d.TID
+				r.TemporalChanged = true
+			}
+			if d.TID > c.Temporal {
+				r.Skipped = true
+				return r
+			}
+		}
+	}
+	r.Relay = true
+	return r
+}
@@ -0,0 +1,63 @@
+package rtp
+
+import "time"
+
+// SwitchingContext keeps the sequence numbers and timestamps of what a
+// plugin relays continuous, when the source of the packets changes (a new
+// SSRC, a different simulcast substream, another publisher): receivers
+// would otherwise see jumps and drop packets. The zero value is ready to use.
+type SwitchingContext struct {
+	started    bool
+	ssrc       uint32 // SSRC of the source being relayed
+	seqOffset  uint16
+	tsOffset   uint32
+	lastSeq    uint16 // highest sequence number sent
+	lastTS     uint32 // timestamp sent with it
+	lastTime   time.Time
+	switchNext bool
+}
+
+// Reset makes the next packet start a new source, even with the same SSRC
+func (c *SwitchingContext) Reset() {
+	c.switchNext = true
+}
+
+// Skip accounts for a packet of the source that isn't relayed (e.g. a
+// temporal layer being dropped), so that receivers don't see a gap
+func (c *SwitchingContext) Skip() {
+	if c.started {
+		c.seqOffset--
+	}
+}
+
+// Update rewrites the sequence number and the timestamp of a packet;
+// rate is the clock rate of the media (e.g. 48000 for opus, 90000 for video)
+func (c *SwitchingContext) Update(buf []byte, rate int) {
+	if len(buf) < HeaderSize {
+		return
+	}
+	seq, ts, ssrc := Seq(buf), Timestamp(buf), SSRC(buf)
+	now := time.Now()
+	switch {
+	case !c.started:
+		c.started = true
+		c.lastSeq, c.lastTS = seq-1, ts
+	case ssrc != c.ssrc || c.switchNext:
+		// carry on from where the previous source stopped, as if the time
+		// since its last packet had passed for the new one as well
+		step := uint32(now.Sub(c.lastTime).Seconds() * float64(rate))
+		if step == 0 {
+			step = 1
+		}
+		c.seqOffset = c.lastSeq + 1 - seq
+		c.tsOffset = c.lastTS + step - ts
+	}
+	c.ssrc, c.switchNext = ssrc, false
+	seq += c.seqOffset
+	ts += c.tsOffset
+	SetSeq(buf, seq)
+	SetTimestamp(buf, ts)
+	if newer(seq, c.lastSeq) {
+		c.lastSeq, c.lastTS, c.lastTime = seq, ts, now
+	}
+}
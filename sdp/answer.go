@@ -0,0 +1,180 @@
+package sdp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// AnswerOptions tell GenerateAnswer what a plugin accepts. The zero value
+// accepts audio, video and data in both directions, with the codecs we
+// prefer and no RTP header extension.
+type AnswerOptions struct {
+	NoAudio, NoVideo, NoData bool
+	// what the plugin wants to do with the media
+	AudioDirection, VideoDirection Direction
+	// codecs to use, if the offer has them, instead of the preferred ones
+	AudioCodec, VideoCodec string
+	// AudioDTMF accepts telephone-event along with the audio codec
+	AudioDTMF bool
+	// Extensions are the URIs of the RTP header extensions to accept
+	Extensions []string
+	// Simulcast accepts the rids the offer wants to send video with
+	Simulcast bool
+}
+
+// answerDirection is what we can do given what the other side offers
+func answerDirection(offered, wanted Direction) Direction {
+	switch offered {
+	case SendOnly:
+		if wanted.Receives() {
+			return RecvOnly
+		}
+	case RecvOnly:
+		if wanted.Sends() {
+			return SendOnly
+		}
+	case SendRecv:
+		return wanted
+	}
+	return Inactive
+}
+
+// GenerateAnswer answers an offer: only the first m-line of each type can be
+// accepted, the others are rejected. For audio and video, a single codec is
+// picked (plus telephone-event, if asked); an m-line without any codec we can
+// use is rejected.
+func GenerateAnswer(offer *SDP, opts AnswerOptions) *SDP {
+	answer := &SDP{
+		Origin: Origin{
+			Username:       "-",
+			SessionID:      offer.Origin.SessionID,
+			SessionVersion: offer.Origin.SessionVersion,
+			Address:        "IN IP4 127.0.0.1",
+		},
+		Name:   offer.Name,
+		Timing: "0 0",
+	}
+	if answer.Name == "" {
+		answer.Name = "-"
+	}
+	seen := make(map[MediaType]bool)
+	var bundle []string
+	for _, o := range offer.MLines {
+		a := &MLine{Type: o.Type, TypeName: o.TypeName, Port: 9, Proto: o.Proto, Direction: Inactive}
+		if mid := o.Mid(); mid != "" {
+			a.AddAttribute("mid", mid)
+		}
+		accepted := false
+		if !seen[o.Type] && o.Port != 0 {
+			switch o.Type {
+			case Audio:
+				accepted = !opts.NoAudio && a.answerMedia(o, opts.AudioCodec, opts.AudioDirection, opts)
+			case Video:
+				accepted = !opts.NoVideo && a.answerMedia(o, opts.VideoCodec, opts.VideoDirection, opts)
+			case Application:
+				accepted = !opts.NoData && a.answerData(o)
+			}
+		}
+		if accepted {
+			seen[o.Type] = true
+			if mid := o.Mid(); mid != "" {
+				bundle = append(bundle, mid)
+			}
+		} else {
+			a.Port = 0
+			a.Direction = Inactive
+			if len(a.Formats) == 0 && len(o.Formats) > 0 {
+				a.Formats = o.Formats[:1]
+			}
+		}
+		answer.MLines = append(answer.MLines, a)
+	}
+	if len(bundle) > 0 && offer.Attribute("group") != nil {
+		answer.Attributes = append(answer.Attributes, &Attribute{Name: "group", Value: "BUNDLE " + strings.Join(bundle, " ")})
+	}
+	if offer.Attribute("msid-semantic") != nil {
+		answer.Attributes = append(answer.Attributes, &Attribute{Name: "msid-semantic", Value: " WMS *"})
+	}
+	return answer
+}
+
+// answerMedia fills in an audio or video m-line of an answer
+func (a *MLine) answerMedia(o *MLine, codec string, wanted Direction, opts AnswerOptions) bool {
+	if codec == "" || o.CodecPT(codec) < 0 {
+		codec = o.PreferredCodec()
+	}
+	if codec == "" {
+		return false
+	}
+	pt := o.CodecPT(codec)
+	a.addCodec(o, pt)
+	if a.Type == Audio && opts.AudioDTMF {
+		if dtmf := o.dtmfPT(pt); dtmf >= 0 {
+			a.addCodec(o, dtmf)
+		}
+	}
+	a.Direction = answerDirection(o.Direction, wanted)
+	a.AddAttribute("rtcp-mux", "")
+	for _, value := range o.Values("extmap") {
+		f := strings.Fields(value)
+		if len(f) >= 2 && contains(opts.Extensions, f[1]) {
+			a.AddAttribute("extmap", strings.SplitN(f[0], "/", 2)[0]+" "+f[1])
+		}
+	}
+	if a.Type == Video && opts.Simulcast && a.Direction.Receives() {
+		if rids := o.Values("rid"); len(rids) > 0 {
+			for _, value := range rids {
+				if f := strings.Fields(value); len(f) >= 2 && f[1] == "send" {
+					a.AddAttribute("rid", f[0]+" recv")
+				}
+			}
+			if s := o.Attribute("simulcast"); s != nil && strings.HasPrefix(s.Value, "send ") {
+				a.AddAttribute("simulcast", "recv "+strings.TrimPrefix(s.Value, "send "))
+			}
+		}
+	}
+	return true
+}
+
+// dtmfPT returns the payload type of telephone-event with the same clock
+// rate as the audio codec, or -1
+func (m *MLine) dtmfPT(codecPT int) int {
+	rate := "8000"
+	if f := strings.Split(m.ptValue("rtpmap", codecPT), "/"); len(f) >= 2 {
+		rate = f[1]
+	}
+	for _, format := range m.Formats {
+		pt, err := strconv.Atoi(format)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(m.ptValue("rtpmap", pt), DTMF+"/"+rate) {
+			return pt
+		}
+	}
+	return -1
+}
+
+// answerData fills in a data channels m-line of an answer
+func (a *MLine) answerData(o *MLine) bool {
+	if !strings.Contains(o.Proto, "SCTP") {
+		return false
+	}
+	a.Formats = o.Formats
+	a.Direction = SendRecv
+	for _, name := range []string{"sctp-port", "max-message-size", "sctpmap"} {
+		for _, value := range o.Values(name) {
+			a.AddAttribute(name, value)
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
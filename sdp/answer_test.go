@@ -0,0 +1,205 @@
+package sdp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateAnswer(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  AnswerOptions
+		ports []int    // of the audio, video and data m-lines
+		pts   []string // of the audio and video m-lines
+		dirs  []Direction
+		group string
+	}{
+		{
+			name:  "everything",
+			ports: []int{9, 9, 9},
+			pts:   []string{"111", "96"},
+			dirs:  []Direction{SendRecv, RecvOnly},
+			group: "BUNDLE 0 1 2",
+		},
+		{
+			name:  "no video",
+			opts:  AnswerOptions{NoVideo: true},
+			ports: []int{9, 0, 9},
+			pts:   []string{"111", "96"},
+			dirs:  []Direction{SendRecv, Inactive},
+			group: "BUNDLE 0 2",
+		},
+		{
+			name:  "codecs asked for",
+			opts:  AnswerOptions{AudioCodec: "pcma", VideoCodec: "h264", AudioDTMF: true},
+			ports: []int{9, 9, 9},
+			pts:   []string{"8", "107"},
+			dirs:  []Direction{SendRecv, RecvOnly},
+			group: "BUNDLE 0 1 2",
+		},
+		{
+			name:  "codec not offered",
+			opts:  AnswerOptions{VideoCodec: "av1"},
+			ports: []int{9, 9, 9},
+			pts:   []string{"111", "96"},
+			dirs:  []Direction{SendRecv, RecvOnly},
+			group: "BUNDLE 0 1 2",
+		},
+		{
+			name:  "send only",
+			opts:  AnswerOptions{AudioDirection: SendOnly, VideoDirection: SendOnly},
+			ports: []int{9, 9, 9},
+			pts:   []string{"111", "96"},
+			dirs:  []Direction{SendOnly, Inactive},
+			group: "BUNDLE 0 1 2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			answer := GenerateAnswer(parse(t, chrome), test.opts)
+			if len(answer.MLines) != 3 {
+				t.Fatalf("got %d m-lines, want 3", len(answer.MLines))
+			}
+			for i, m := range answer.MLines {
+				if m.Port != test.ports[i] {
+					t.Errorf("m-line %d: got port %d, want %d", i, m.Port, test.ports[i])
+				}
+				if i < 2 && m.Formats[0] != test.pts[i] {
+					t.Errorf("m-line %d: got formats %v, want %s first", i, m.Formats, test.pts[i])
+				}
+				if i < 2 && m.Direction != test.dirs[i] {
+					t.Errorf("m-line %d: got %s, want %s", i, m.Direction, test.dirs[i])
+				}
+			}
+			if a := answer.Attribute("group"); a == nil || a.Value != test.group {
+				t.Errorf("got group %v, want %q", a, test.group)
+			}
+			// what we answer must parse back
+			if _, err := Parse(answer.String()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAnswerDTMF(t *testing.T) {
+	answer := GenerateAnswer(parse(t, chrome), AnswerOptions{AudioDTMF: true})
+	// telephone-event is only taken with the clock rate of the codec
+	if got := answer.MLines[0].Formats; !reflect.DeepEqual(got, []string{"111", "126"}) {
+		t.Errorf("got formats %v, want opus and telephone-event", got)
+	}
+	answer = GenerateAnswer(parse(t, chrome), AnswerOptions{AudioCodec: "pcmu", AudioDTMF: true})
+	if got := answer.MLines[0].Formats; !reflect.DeepEqual(got, []string{"0"}) {
+		t.Errorf("got formats %v, want pcmu alone", got)
+	}
+}
+
+func TestAnswerExtensionsAndSimulcast(t *testing.T) {
+	answer := GenerateAnswer(parse(t, chrome), AnswerOptions{Extensions: []string{ExtMid}, Simulcast: true})
+	audio, video := answer.MLines[0], answer.MLines[1]
+	if got := audio.Values("extmap"); !reflect.DeepEqual(got, []string{"2 " + ExtMid}) {
+		t.Errorf("got extmaps %v, want the mid one without its direction", got)
+	}
+	if got := video.Values("rid"); !reflect.DeepEqual(got, []string{"h recv", "m recv", "l recv"}) {
+		t.Errorf("got rids %v", got)
+	}
+	if a := video.Attribute("simulcast"); a == nil || a.Value != "recv h;m;~l" {
+		t.Errorf("got simulcast %v", a)
+	}
+	if got := video.Values("rtcp-fb"); !reflect.DeepEqual(got, []string{"96 nack", "96 nack pli"}) {
+		t.Errorf("got rtcp-fb %v, want the ones of the codec", got)
+	}
+}
+
+func TestAnswerRejectsDuplicates(t *testing.T) {
+	offer := parse(t, "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"+
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=mid:a\r\na=rtpmap:111 opus/48000/2\r\n"+
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=mid:b\r\na=rtpmap:111 opus/48000/2\r\n"+
+		"m=video 9 UDP/TLS/RTP/SAVPF 98\r\na=mid:c\r\na=rtpmap:98 foo/90000\r\n")
+	answer := GenerateAnswer(offer, AnswerOptions{})
+	for i, want := range []int{9, 0, 0} {
+		if port := answer.MLines[i].Port; port != want {
+			t.Errorf("m-line %d: got port %d, want %d", i, port, want)
+		}
+	}
+	// rejected m-lines still need a format
+	if formats := answer.MLines[2].Formats; len(formats) != 1 {
+		t.Errorf("got formats %v", formats)
+	}
+}
+
+func TestGenerateOffer(t *testing.T) {
+	offer := GenerateOffer(OfferOptions{
+		AudioCodec:     "opus",
+		VideoCodec:     "h264",
+		VideoPT:        100,
+		AudioDirection: SendOnly,
+		VideoDirection: SendOnly,
+		AudioDTMF:      true,
+		Data:           true,
+		Extensions:     []string{ExtMid, ExtAudioLevel, ExtVideoOrientation},
+	})
+	offer = parse(t, offer.String())
+	if len(offer.MLines) != 3 {
+		t.Fatalf("got %d m-lines, want 3", len(offer.MLines))
+	}
+	audio, video, data := offer.MLines[0], offer.MLines[1], offer.MLines[2]
+	if a := offer.Attribute("group"); a == nil || a.Value != "BUNDLE audio video data" {
+		t.Errorf("got group %v", a)
+	}
+	if audio.CodecPT("opus") != 111 || audio.CodecPT(DTMF) != 126 || audio.Fmtp(111) != "useinbandfec=1" {
+		t.Errorf("got audio %v %v", audio.Formats, audio.Attributes)
+	}
+	if video.CodecPT("h264") != 100 || !strings.Contains(video.Fmtp(100), "packetization-mode=1") {
+		t.Errorf("got video %v %v", video.Formats, video.Attributes)
+	}
+	if audio.Direction != SendOnly || video.Direction != SendOnly {
+		t.Errorf("got directions %s %s", audio.Direction, video.Direction)
+	}
+	// extensions keep their ids, and each m-line only has the ones for its media
+	if audio.ExtmapID(ExtAudioLevel) != 2 || audio.ExtmapID(ExtVideoOrientation) != 0 {
+		t.Errorf("got audio extmaps %v", audio.Values("extmap"))
+	}
+	if video.ExtmapID(ExtVideoOrientation) != 3 || video.ExtmapID(ExtAudioLevel) != 0 || video.ExtmapID(ExtMid) != 1 {
+		t.Errorf("got video extmaps %v", video.Values("extmap"))
+	}
+	if data.Type != Application || data.Attribute("sctp-port") == nil {
+		t.Errorf("got data %+v", data)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	tests := []struct {
+		codec  string
+		pt     int
+		rtpmap string
+		rate   int
+	}{
+		{"opus", 111, "opus/48000/2", 48000},
+		{"PCMU", 0, "PCMU/8000", 8000},
+		{"vp8", 96, "VP8/90000", 90000},
+		{"g722", 9, "G722/8000", 8000},
+		{"unknown", -1, "", 90000},
+	}
+	for _, test := range tests {
+		if pt := DefaultPT(test.codec); pt != test.pt {
+			t.Errorf("%s: got payload type %d, want %d", test.codec, pt, test.pt)
+		}
+		if rtpmap := RTPMap(test.codec); rtpmap != test.rtpmap {
+			t.Errorf("%s: got rtpmap %q, want %q", test.codec, rtpmap, test.rtpmap)
+		}
+		if rate := ClockRate(test.codec); rate != test.rate {
+			t.Errorf("%s: got clock rate %d, want %d", test.codec, rate, test.rate)
+		}
+	}
+	if codec := CodecOf("opus/48000"); codec != "opus" {
+		t.Errorf("got %q for opus without its channels", codec)
+	}
+	if codec := CodecOf("vp8/90000"); codec != "vp8" {
+		t.Errorf("got %q for a lowercase vp8", codec)
+	}
+	if !KnownCodec(Video, "av1") || KnownCodec(Audio, "vp8") {
+		t.Error("got the wrong known codecs")
+	}
+}
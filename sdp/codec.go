@@ -0,0 +1,184 @@
+package sdp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Codecs are named as in the original Janus (e.g. "opus", "pcmu", "vp8"),
+// and matched against the rtpmap attributes of the m-lines.
+
+// AudioCodecs and VideoCodecs are the codecs we know, in order of preference
+var (
+	AudioCodecs = []string{"opus", "pcmu", "pcma", "g722", "isac16", "isac32", "l16-48", "l16"}
+	VideoCodecs = []string{"vp8", "vp9", "h264", "av1", "h265"}
+)
+
+// DTMF is the pseudo-codec of RFC 2833/4733 events
+const DTMF = "telephone-event"
+
+var rtpmaps = map[string]string{
+	"opus":   "opus/48000/2",
+	"pcmu":   "PCMU/8000",
+	"pcma":   "PCMA/8000",
+	"g722":   "G722/8000",
+	"isac16": "ISAC/16000",
+	"isac32": "ISAC/32000",
+	"l16-48": "L16/48000",
+	"l16":    "L16/16000",
+	"vp8":    "VP8/90000",
+	"vp9":    "VP9/90000",
+	"h264":   "H264/90000",
+	"av1":    "AV1/90000",
+	"h265":   "H265/90000",
+	DTMF:     "telephone-event/8000",
+}
+
+// static payload types, which may come without an rtpmap
+var staticPTs = map[string]int{"pcmu": 0, "pcma": 8, "g722": 9}
+
+// payload types used in offers, unless told otherwise
+var defaultPTs = map[string]int{
+	"opus": 111, "pcmu": 0, "pcma": 8, "g722": 9, "isac16": 103, "isac32": 104, "l16-48": 105, "l16": 106,
+	"vp8": 96, "vp9": 101, "h264": 107, "av1": 45, "h265": 49,
+	DTMF: 126,
+}
+
+// DefaultPT returns the payload type offers use for a codec, or -1
+func DefaultPT(codec string) int {
+	if pt, ok := defaultPTs[strings.ToLower(codec)]; ok {
+		return pt
+	}
+	return -1
+}
+
+// RTPMap returns the rtpmap encoding of a codec, e.g. "opus/48000/2"
+func RTPMap(codec string) string {
+	return rtpmaps[strings.ToLower(codec)]
+}
+
+// ClockRate returns the clock rate of a codec, from its rtpmap (90000 if
+// it's not one we know)
+func ClockRate(codec string) int {
+	if f := strings.Split(RTPMap(codec), "/"); len(f) >= 2 {
+		if rate, err := strconv.Atoi(f[1]); err == nil {
+			return rate
+		}
+	}
+	return 90000
+}
+
+// CodecOf returns the codec of an rtpmap encoding, e.g. "vp8" for
+// "VP8/90000", or "" if it's not one we know
+func CodecOf(rtpmap string) string {
+	for _, list := range [][]string{AudioCodecs, VideoCodecs} {
+		for _, codec := range list {
+			if matches(rtpmaps[codec], rtpmap) {
+				return codec
+			}
+		}
+	}
+	return ""
+}
+
+// KnownCodec tells whether a codec of a type is one we know
+func KnownCodec(t MediaType, codec string) bool {
+	list := AudioCodecs
+	if t == Video {
+		list = VideoCodecs
+	}
+	for _, c := range list {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// matches tells whether an rtpmap encoding is the one of a codec: the
+// number of channels is optional, apart from opus
+func matches(encoding, rtpmap string) bool {
+	encoding, rtpmap = strings.ToLower(encoding), strings.ToLower(rtpmap)
+	return encoding == rtpmap || strings.HasPrefix(rtpmap, encoding+"/") || strings.HasPrefix(encoding, rtpmap+"/")
+}
+
+// CodecPT returns the first payload type the m-line uses for a codec, or -1
+func (m *MLine) CodecPT(codec string) int {
+	encoding := RTPMap(codec)
+	if encoding == "" {
+		return -1
+	}
+	for _, format := range m.Formats {
+		pt, err := strconv.Atoi(format)
+		if err != nil {
+			continue
+		}
+		if rtpmap := m.ptValue("rtpmap", pt); rtpmap != "" {
+			if matches(encoding, rtpmap) {
+				return pt
+			}
+		} else if static, ok := staticPTs[strings.ToLower(codec)]; ok && static == pt {
+			return pt
+		}
+	}
+	return -1
+}
+
+// CodecName returns the name of the codec of a payload type, or ""
+func (m *MLine) CodecName(pt int) string {
+	rtpmap := m.ptValue("rtpmap", pt)
+	for codec, encoding := range rtpmaps {
+		if rtpmap != "" && matches(encoding, rtpmap) {
+			return codec
+		}
+		if rtpmap == "" {
+			if static, ok := staticPTs[codec]; ok && static == pt {
+				return codec
+			}
+		}
+	}
+	return ""
+}
+
+// PreferredCodec returns the codec we like best among the ones of the m-line
+func (m *MLine) PreferredCodec() string {
+	list := AudioCodecs
+	if m.Type == Video {
+		list = VideoCodecs
+	}
+	for _, codec := range list {
+		if m.CodecPT(codec) >= 0 {
+			return codec
+		}
+	}
+	return ""
+}
+
+// FirstCodec returns the first codec of the m-line that we know, in the
+// order of the m-line, i.e. the one the other side likes best
+func (m *MLine) FirstCodec() string {
+	for _, format := range m.Formats {
+		pt, err := strconv.Atoi(format)
+		if err != nil {
+			continue
+		}
+		if codec := m.CodecName(pt); codec != "" && KnownCodec(m.Type, codec) {
+			return codec
+		}
+	}
+	return ""
+}
+
+// addCodec adds a payload type to the m-line, with its rtpmap, fmtp and
+// rtcp-fb attributes taken from another m-line (e.g. the offer)
+func (m *MLine) addCodec(from *MLine, pt int) {
+	m.Formats = append(m.Formats, strconv.Itoa(pt))
+	prefix := strconv.Itoa(pt) + " "
+	for _, name := range []string{"rtpmap", "fmtp", "rtcp-fb"} {
+		for _, a := range from.Attributes {
+			if a.Name == name && strings.HasPrefix(a.Value, prefix) {
+				m.AddAttribute(a.Name, a.Value)
+			}
+		}
+	}
+}
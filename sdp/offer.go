@@ -0,0 +1,112 @@
+package sdp
+
+import (
+	"strconv"
+	"time"
+)
+
+// OfferOptions tell GenerateOffer what to offer. There's an audio (video)
+// m-line only if AudioCodec (VideoCodec) is set.
+type OfferOptions struct {
+	Name                 string
+	AudioCodec           string
+	VideoCodec           string
+	AudioPT, VideoPT     int // 0 for DefaultPT
+	AudioFmtp, VideoFmtp string
+	// what the plugin wants to do with the media
+	AudioDirection, VideoDirection Direction
+	// AudioDTMF offers telephone-event along with the audio codec
+	AudioDTMF bool
+	Data      bool
+	// Extensions are the URIs of the RTP header extensions to offer, which
+	// get ids from 1 on in this order
+	Extensions []string
+}
+
+// default fmtp of the codecs that need one
+var defaultFmtps = map[string]string{
+	"opus": "useinbandfec=1",
+	"h264": "profile-level-id=42e01f;packetization-mode=1",
+}
+
+// GenerateOffer builds an offer with audio, video and data m-lines (in
+// this order, with mids "audio", "video" and "data"), as asked
+func GenerateOffer(opts OfferOptions) *SDP {
+	now := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+	offer := &SDP{
+		Origin: Origin{Username: "-", SessionID: now, SessionVersion: 1, Address: "IN IP4 127.0.0.1"},
+		Name:   opts.Name,
+		Timing: "0 0",
+	}
+	if offer.Name == "" {
+		offer.Name = "-"
+	}
+	var bundle []string
+	if opts.AudioCodec != "" {
+		m := offerMedia(Audio, "audio", opts.AudioCodec, opts.AudioPT, opts.AudioFmtp, opts.AudioDirection, opts.Extensions)
+		if opts.AudioDTMF {
+			dtmf := strconv.Itoa(defaultPTs[DTMF])
+			m.Formats = append(m.Formats, dtmf)
+			m.AddAttribute("rtpmap", dtmf+" "+rtpmaps[DTMF])
+		}
+		offer.MLines = append(offer.MLines, m)
+		bundle = append(bundle, "audio")
+	}
+	if opts.VideoCodec != "" {
+		m := offerMedia(Video, "video", opts.VideoCodec, opts.VideoPT, opts.VideoFmtp, opts.VideoDirection, opts.Extensions)
+		offer.MLines = append(offer.MLines, m)
+		bundle = append(bundle, "video")
+	}
+	if opts.Data {
+		m := &MLine{Type: Application, Port: 9, Proto: "UDP/DTLS/SCTP", Formats: []string{"webrtc-datachannel"}}
+		m.AddAttribute("mid", "data")
+		m.AddAttribute("sctp-port", "5000")
+		offer.MLines = append(offer.MLines, m)
+		bundle = append(bundle, "data")
+	}
+	if len(bundle) > 0 {
+		group := "BUNDLE"
+		for _, mid := range bundle {
+			group += " " + mid
+		}
+		offer.Attributes = append(offer.Attributes, &Attribute{Name: "group", Value: group})
+	}
+	offer.Attributes = append(offer.Attributes, &Attribute{Name: "msid-semantic", Value: " WMS janus"})
+	return offer
+}
+
+func offerMedia(t MediaType, mid, codec string, pt int, fmtp string, dir Direction, extensions []string) *MLine {
+	if pt <= 0 {
+		pt = DefaultPT(codec)
+	}
+	if fmtp == "" {
+		fmtp = defaultFmtps[codec]
+	}
+	p := strconv.Itoa(pt)
+	m := &MLine{Type: t, Port: 9, Proto: "UDP/TLS/RTP/SAVPF", Formats: []string{p}, Direction: dir}
+	m.AddAttribute("mid", mid)
+	m.AddAttribute("rtcp-mux", "")
+	m.AddAttribute("rtpmap", p+" "+RTPMap(codec))
+	if fmtp != "" {
+		m.AddAttribute("fmtp", p+" "+fmtp)
+	}
+	twcc := contains(extensions, ExtTransportWideCC)
+	if t == Video {
+		for _, fb := range []string{"ccm fir", "nack", "nack pli", "goog-remb"} {
+			m.AddAttribute("rtcp-fb", p+" "+fb)
+		}
+	}
+	if twcc {
+		m.AddAttribute("rtcp-fb", p+" transport-cc")
+	}
+	for i, uri := range extensions {
+		if t == Audio && (uri == ExtVideoOrientation || uri == ExtRid || uri == ExtRepairedRid) {
+			continue
+		}
+		if t == Video && uri == ExtAudioLevel {
+			continue
+		}
+		m.AddAttribute("extmap", strconv.Itoa(i+1)+" "+uri)
+	}
+	return m
+}
@@ -0,0 +1,475 @@
+package sdp
+
+// A minimal SDP parser and writer, enough for plugins to look at the offers
+// they get and to build their answers and offers, much like the sdp-utils of
+// the original Janus. Plugins only deal with the media part: the WebRTC stack
+// takes care of the ICE and DTLS attributes (candidates, ufrag, fingerprint)
+// of whatever the plugins send to their clients.
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MediaType is the kind of an m-line
+type MediaType int
+
+const (
+	Audio MediaType = iota
+	Video
+	Application
+	Other
+)
+
+var mediaTypeNames = [...]string{Audio: "audio", Video: "video", Application: "application", Other: "other"}
+
+func (t MediaType) String() string {
+	if t < 0 || int(t) >= len(mediaTypeNames) {
+		return "other"
+	}
+	return mediaTypeNames[t]
+}
+
+func parseMediaType(s string) MediaType {
+	for t, name := range mediaTypeNames {
+		if name == s && MediaType(t) != Other {
+			return MediaType(t)
+		}
+	}
+	return Other
+}
+
+// URIs of the RTP header extensions, as in a=extmap
+const (
+	ExtMid                  = "urn:ietf:params:rtp-hdrext:sdes:mid"
+	ExtRid                  = "urn:ietf:params:rtp-hdrext:sdes:rtp-stream-id"
+	ExtRepairedRid          = "urn:ietf:params:rtp-hdrext:sdes:repaired-rtp-stream-id"
+	ExtAudioLevel           = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+	ExtVideoOrientation     = "urn:3gpp:video-orientation"
+	ExtAbsSendTime          = "http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time"
+	ExtTransportWideCC      = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+	ExtPlayoutDelay         = "http://www.webrtc.org/experiments/rtp-hdrext/playout-delay"
+	ExtDependencyDescriptor = "https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension"
+)
+
+// Direction is the direction of an m-line, from the point of view of
+// whoever wrote the SDP
+type Direction int
+
+const (
+	SendRecv Direction = iota
+	SendOnly
+	RecvOnly
+	Inactive
+)
+
+var directionNames = [...]string{SendRecv: "sendrecv", SendOnly: "sendonly", RecvOnly: "recvonly", Inactive: "inactive"}
+
+func (d Direction) String() string {
+	if d < 0 || int(d) >= len(directionNames) {
+		return "inactive"
+	}
+	return directionNames[d]
+}
+
+// ParseDirection parses the name of a direction, e.g. "sendonly"
+func ParseDirection(s string) (Direction, bool) {
+	for d, name := range directionNames {
+		if name == s {
+			return Direction(d), true
+		}
+	}
+	return Inactive, false
+}
+
+// Sends and Receives tell what the direction allows
+func (d Direction) Sends() bool    { return d == SendRecv || d == SendOnly }
+func (d Direction) Receives() bool { return d == SendRecv || d == RecvOnly }
+
+// Reverse returns the direction as seen by the other side
+func (d Direction) Reverse() Direction {
+	switch d {
+	case SendOnly:
+		return RecvOnly
+	case RecvOnly:
+		return SendOnly
+	}
+	return d
+}
+
+// Attribute is an a= line: "a=name:value", or "a=name" when there's no value
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+func (a *Attribute) String() string {
+	if a.Value == "" {
+		return "a=" + a.Name
+	}
+	return "a=" + a.Name + ":" + a.Value
+}
+
+// MLine is a media description. Direction attributes are not in
+// Attributes, they're parsed into Direction.
+type MLine struct {
+	Type MediaType
+	// TypeName is the media as written in the m-line, for the Other ones
+	TypeName   string
+	Port       int
+	Proto      string
+	Formats    []string
+	Connection string   // c= line, without "c="
+	Bandwidth  []string // b= lines, without "b="
+	Direction  Direction
+	Attributes []*Attribute
+}
+
+// Origin is the o= line
+type Origin struct {
+	Username       string
+	SessionID      uint64
+	SessionVersion uint64
+	// Address is the rest of the line, e.g. "IN IP4 127.0.0.1"
+	Address string
+}
+
+// SDP is a session description
+type SDP struct {
+	Version    int
+	Origin     Origin
+	Name       string
+	Timing     string // t= line, without "t="
+	Connection string
+	Bandwidth  []string
+	Attributes []*Attribute
+	MLines     []*MLine
+}
+
+// Parse parses a session description
+func Parse(text string) (*SDP, error) {
+	s := &SDP{Timing: "0 0"}
+	var m *MLine
+	sessionDirection := SendRecv
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[1] != '=' {
+			return nil, fmt.Errorf("invalid line %d: %q", n+1, line)
+		}
+		value := line[2:]
+		switch line[0] {
+		case 'v':
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version: %q", value)
+			}
+			s.Version = v
+		case 'o':
+			f := strings.SplitN(value, " ", 4)
+			if len(f) < 4 {
+				return nil, fmt.Errorf("invalid origin: %q", value)
+			}
+			s.Origin.Username = f[0]
+			s.Origin.SessionID, _ = strconv.ParseUint(f[1], 10, 64)
+			s.Origin.SessionVersion, _ = strconv.ParseUint(f[2], 10, 64)
+			s.Origin.Address = f[3]
+		case 's':
+			s.Name = value
+		case 't':
+			s.Timing = value
+		case 'c':
+			if m != nil {
+				m.Connection = value
+			} else {
+				s.Connection = value
+			}
+		case 'b':
+			if m != nil {
+				m.Bandwidth = append(m.Bandwidth, value)
+			} else {
+				s.Bandwidth = append(s.Bandwidth, value)
+			}
+		case 'm':
+			f := strings.Fields(value)
+			if len(f) < 3 {
+				return nil, fmt.Errorf("invalid m-line: %q", value)
+			}
+			port, err := strconv.Atoi(strings.SplitN(f[1], "/", 2)[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid port in m-line: %q", value)
+			}
+			m = &MLine{
+				Type:      parseMediaType(f[0]),
+				TypeName:  f[0],
+				Port:      port,
+				Proto:     f[2],
+				Formats:   f[3:],
+				Direction: sessionDirection,
+			}
+			s.MLines = append(s.MLines, m)
+		case 'a':
+			a := &Attribute{Name: value}
+			if i := strings.IndexByte(value, ':'); i >= 0 {
+				a.Name, a.Value = value[:i], value[i+1:]
+			}
+			if d, ok := ParseDirection(a.Name); ok && a.Value == "" {
+				if m != nil {
+					m.Direction = d
+				} else {
+					sessionDirection = d
+				}
+				continue
+			}
+			if m != nil {
+				m.Attributes = append(m.Attributes, a)
+			} else {
+				s.Attributes = append(s.Attributes, a)
+			}
+		default:
+			// i=, u=, e=, p=, z=, k=, r=: nothing we care about
+		}
+	}
+	if len(s.MLines) == 0 {
+		return nil, errors.New("no m-lines")
+	}
+	return s, nil
+}
+
+// String writes the session description, with CRLF line endings
+func (s *SDP) String() string {
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString("\r\n")
+	}
+	line("v=%d", s.Version)
+	line("o=%s %d %d %s", s.Origin.Username, s.Origin.SessionID, s.Origin.SessionVersion, s.Origin.Address)
+	line("s=%s", s.Name)
+	// c= and b= come before t= (RFC 4566), or strict parsers refuse the SDP
+	if s.Connection != "" {
+		line("c=%s", s.Connection)
+	}
+	for _, bw := range s.Bandwidth {
+		line("b=%s", bw)
+	}
+	line("t=%s", s.Timing)
+	for _, a := range s.Attributes {
+		line("%s", a)
+	}
+	for _, m := range s.MLines {
+		formats := m.Formats
+		if len(formats) == 0 {
+			// there must be at least one
+			formats = []string{"0"}
+		}
+		line("m=%s %d %s %s", m.name(), m.Port, m.Proto, strings.Join(formats, " "))
+		if m.Connection != "" {
+			line("c=%s", m.Connection)
+		}
+		for _, bw := range m.Bandwidth {
+			line("b=%s", bw)
+		}
+		if m.Type != Application {
+			line("a=%s", m.Direction)
+		}
+		for _, a := range m.Attributes {
+			line("%s", a)
+		}
+	}
+	return b.String()
+}
+
+func (m *MLine) name() string {
+	if m.TypeName != "" {
+		return m.TypeName
+	}
+	return m.Type.String()
+}
+
+// MLine returns the first m-line of a type, or nil
+func (s *SDP) MLine(t MediaType) *MLine {
+	for _, m := range s.MLines {
+		if m.Type == t {
+			return m
+		}
+	}
+	return nil
+}
+
+// Index returns the position of an m-line (its mindex), or -1
+func (s *SDP) Index(m *MLine) int {
+	for i, ml := range s.MLines {
+		if ml == m {
+			return i
+		}
+	}
+	return -1
+}
+
+// Attribute returns the first session attribute with a name, or nil
+func (s *SDP) Attribute(name string) *Attribute {
+	return findAttribute(s.Attributes, name)
+}
+
+func findAttribute(attributes []*Attribute, name string) *Attribute {
+	for _, a := range attributes {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// Disabled tells whether the m-line has been rejected or is inactive
+func (m *MLine) Disabled() bool {
+	return m.Port == 0 || m.Direction == Inactive
+}
+
+// Attribute returns the first attribute with a name, or nil
+func (m *MLine) Attribute(name string) *Attribute {
+	return findAttribute(m.Attributes, name)
+}
+
+// Values returns the values of all the attributes with a name
+func (m *MLine) Values(name string) []string {
+	var values []string
+	for _, a := range m.Attributes {
+		if a.Name == name {
+			values = append(values, a.Value)
+		}
+	}
+	return values
+}
+
+// AddAttribute appends an attribute
+func (m *MLine) AddAttribute(name, value string) {
+	m.Attributes = append(m.Attributes, &Attribute{Name: name, Value: value})
+}
+
+// RemoveAttributes removes all the attributes with a name
+func (m *MLine) RemoveAttributes(name string) {
+	kept := m.Attributes[:0]
+	for _, a := range m.Attributes {
+		if a.Name != name {
+			kept = append(kept, a)
+		}
+	}
+	m.Attributes = kept
+}
+
+// Mid returns the a=mid of the m-line, if any
+func (m *MLine) Mid() string {
+	if a := m.Attribute("mid"); a != nil {
+		return a.Value
+	}
+	return ""
+}
+
+// ptValue returns the value of the attribute with a name for a payload
+// type, e.g. the fmtp, i.e. what follows the payload type in the attribute
+func (m *MLine) ptValue(name string, pt int) string {
+	prefix := strconv.Itoa(pt) + " "
+	for _, a := range m.Attributes {
+		if a.Name == name && strings.HasPrefix(a.Value, prefix) {
+			return a.Value[len(prefix):]
+		}
+	}
+	return ""
+}
+
+// Fmtp returns the format parameters of a payload type, if any
+func (m *MLine) Fmtp(pt int) string {
+	return m.ptValue("fmtp", pt)
+}
+
+// HasFormat tells whether a payload type is among the formats of the m-line
+func (m *MLine) HasFormat(pt int) bool {
+	f := strconv.Itoa(pt)
+	for _, format := range m.Formats {
+		if format == f {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtmapID returns the id the m-line uses for an RTP header extension, or 0
+func (m *MLine) ExtmapID(uri string) int {
+	for _, value := range m.Values("extmap") {
+		f := strings.Fields(value)
+		if len(f) >= 2 && f[1] == uri {
+			id, _ := strconv.Atoi(strings.SplitN(f[0], "/", 2)[0])
+			return id
+		}
+	}
+	return 0
+}
+
+// SimulcastSSRCs returns the SSRCs of the a=ssrc-group:SIM of the m-line,
+// from the lowest to the highest quality
+func (m *MLine) SimulcastSSRCs() []uint32 {
+	for _, value := range m.Values("ssrc-group") {
+		f := strings.Fields(value)
+		if len(f) < 2 || f[0] != "SIM" {
+			continue
+		}
+		var ssrcs []uint32
+		for _, s := range f[1:] {
+			if ssrc, err := strconv.ParseUint(s, 10, 32); err == nil {
+				ssrcs = append(ssrcs, uint32(ssrc))
+			}
+		}
+		return ssrcs
+	}
+	return nil
+}
+
+// SSRCs returns the SSRCs of the a=ssrc attributes of the m-line, in order
+func (m *MLine) SSRCs() []uint32 {
+	var ssrcs []uint32
+	for _, value := range m.Values("ssrc") {
+		ssrc, err := strconv.ParseUint(strings.SplitN(value, " ", 2)[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		known := false
+		for _, s := range ssrcs {
+			known = known || s == uint32(ssrc)
+		}
+		if !known {
+			ssrcs = append(ssrcs, uint32(ssrc))
+		}
+	}
+	return ssrcs
+}
+
+// Rids returns the rids the m-line sends with simulcast, from the lowest to
+// the highest quality: like browsers do, the a=simulcast attribute is
+// expected to list them from the highest to the lowest
+func (m *MLine) Rids() []string {
+	var rids []string
+	for _, value := range m.Values("rid") {
+		f := strings.Fields(value)
+		if len(f) >= 2 && f[1] == "send" {
+			rids = append(rids, f[0])
+		}
+	}
+	if a := m.Attribute("simulcast"); a != nil {
+		f := strings.Fields(a.Value)
+		if len(f) >= 2 && f[0] == "send" {
+			var ordered []string
+			for _, alternatives := range strings.Split(f[1], ";") {
+				// we only care about the first of the alternatives, and not about paused ones
+				rid := strings.TrimPrefix(strings.SplitN(alternatives, ",", 2)[0], "~")
+				ordered = append([]string{rid}, ordered...)
+			}
+			return ordered
+		}
+	}
+	return rids
+}
@@ -0,0 +1,172 @@
+package sdp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chrome is what a browser offers, trimmed down
+const chrome = "v=0\r\no=- 4611731400430051336 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n" +
+	"a=group:BUNDLE 0 1 2\r\na=msid-semantic: WMS stream\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111 0 8 126\r\nc=IN IP4 0.0.0.0\r\n" +
+	"a=ice-ufrag:abcd\r\na=mid:0\r\na=extmap:1 urn:ietf:params:rtp-hdrext:ssrc-audio-level\r\n" +
+	"a=extmap:2/sendonly urn:ietf:params:rtp-hdrext:sdes:mid\r\na=sendrecv\r\na=rtcp-mux\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\na=fmtp:111 minptime=10;useinbandfec=1\r\na=rtpmap:126 telephone-event/48000\r\n" +
+	"a=ssrc:1111 cname:x\r\na=ssrc:1111 msid:stream audio\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97 107\r\nc=IN IP4 0.0.0.0\r\nb=AS:1000\r\n" +
+	"a=mid:1\r\na=sendonly\r\na=rtcp-mux\r\na=rtpmap:96 VP8/90000\r\na=rtcp-fb:96 nack\r\na=rtcp-fb:96 nack pli\r\n" +
+	"a=rtpmap:97 rtx/90000\r\na=fmtp:97 apt=96\r\na=rtpmap:107 H264/90000\r\na=fmtp:107 profile-level-id=42e01f\r\n" +
+	"a=rid:h send\r\na=rid:m send\r\na=rid:l send\r\na=simulcast:send h;m;~l\r\n" +
+	"a=ssrc-group:SIM 1 2 3\r\na=ssrc:1 cname:x\r\na=ssrc:2 cname:x\r\na=ssrc:1 msid:stream video\r\n" +
+	"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\nc=IN IP4 0.0.0.0\r\na=mid:2\r\na=sctp-port:5000\r\na=max-message-size:262144\r\n"
+
+func parse(t *testing.T, text string) *SDP {
+	t.Helper()
+	s, err := Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	s := parse(t, chrome)
+	if s.Origin.SessionID != 4611731400430051336 || s.Origin.SessionVersion != 2 || s.Origin.Address != "IN IP4 127.0.0.1" {
+		t.Errorf("got origin %+v", s.Origin)
+	}
+	if len(s.MLines) != 3 {
+		t.Fatalf("got %d m-lines, want 3", len(s.MLines))
+	}
+	audio, video, data := s.MLines[0], s.MLines[1], s.MLines[2]
+	if audio.Type != Audio || video.Type != Video || data.Type != Application {
+		t.Errorf("got types %s %s %s", audio.Type, video.Type, data.Type)
+	}
+	if audio.Direction != SendRecv || video.Direction != SendOnly {
+		t.Errorf("got directions %s %s", audio.Direction, video.Direction)
+	}
+	if audio.Attribute("sendrecv") != nil {
+		t.Error("direction attributes shouldn't be in the attributes")
+	}
+	if got := video.Bandwidth; !reflect.DeepEqual(got, []string{"AS:1000"}) {
+		t.Errorf("got bandwidth %v", got)
+	}
+	if mid := video.Mid(); mid != "1" {
+		t.Errorf("got mid %q, want 1", mid)
+	}
+	if pt := audio.CodecPT("opus"); pt != 111 {
+		t.Errorf("got opus on %d, want 111", pt)
+	}
+	if pt := audio.CodecPT("pcmu"); pt != 0 {
+		t.Errorf("got the static pcmu on %d, want 0", pt)
+	}
+	if fmtp := audio.Fmtp(111); fmtp != "minptime=10;useinbandfec=1" {
+		t.Errorf("got fmtp %q", fmtp)
+	}
+	if id := audio.ExtmapID(ExtMid); id != 2 {
+		t.Errorf("got the mid extension on %d, want 2", id)
+	}
+	if id := video.ExtmapID(ExtMid); id != 0 {
+		t.Errorf("got the mid extension on %d for video, want none", id)
+	}
+	if got := audio.SSRCs(); !reflect.DeepEqual(got, []uint32{1111}) {
+		t.Errorf("got ssrcs %v", got)
+	}
+	if got := video.SimulcastSSRCs(); !reflect.DeepEqual(got, []uint32{1, 2, 3}) {
+		t.Errorf("got simulcast ssrcs %v", got)
+	}
+	if got := video.Rids(); !reflect.DeepEqual(got, []string{"l", "m", "h"}) {
+		t.Errorf("got rids %v, want the lowest quality first", got)
+	}
+	if codec := video.FirstCodec(); codec != "vp8" {
+		t.Errorf("got first codec %q, want vp8", codec)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"empty", ""},
+		{"not sdp", "hello"},
+		{"bad version", "v=x\r\n"},
+		{"bad origin", "v=0\r\no=-\r\n"},
+		{"no m-lines", "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"},
+		{"short m-line", "v=0\r\nm=audio 9\r\n"},
+		{"bad port", "v=0\r\nm=audio x RTP/AVP 0\r\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Parse(test.text); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	s := parse(t, chrome)
+	again := parse(t, s.String())
+	if !reflect.DeepEqual(s, again) {
+		t.Errorf("got %+v after a round trip, want %+v", again, s)
+	}
+	text := s.String()
+	if !strings.HasSuffix(text, "\r\n") || strings.Contains(strings.Replace(text, "\r\n", "", -1), "\n") {
+		t.Error("lines should end with CRLF")
+	}
+	// data channels have no direction
+	if strings.Contains(text[strings.Index(text, "m=application"):], "a=sendrecv") {
+		t.Error("got a direction on the data channels m-line")
+	}
+}
+
+func TestSessionConnection(t *testing.T) {
+	s := parse(t, "v=0\r\no=- 1 1 IN IP4 10.0.0.1\r\ns=-\r\nt=0 0\r\nc=IN IP4 10.0.0.1\r\nb=AS:64\r\nm=audio 4000 RTP/AVP 0\r\n")
+	// strict parsers want c= and b= before t=
+	if text := s.String(); !strings.Contains(text, "s=-\r\nc=IN IP4 10.0.0.1\r\nb=AS:64\r\nt=0 0\r\n") {
+		t.Errorf("got %q", text)
+	}
+}
+
+func TestSessionDirection(t *testing.T) {
+	s := parse(t, "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\na=recvonly\r\nm=audio 9 RTP/AVP 0\r\nm=video 9 RTP/AVP 96\r\na=inactive\r\n")
+	if d := s.MLines[0].Direction; d != RecvOnly {
+		t.Errorf("got %s, want the session recvonly", d)
+	}
+	if d := s.MLines[1].Direction; d != Inactive || !s.MLines[1].Disabled() {
+		t.Errorf("got %s, want inactive", d)
+	}
+}
+
+func TestDirection(t *testing.T) {
+	tests := []struct {
+		d               Direction
+		sends, receives bool
+		reverse         Direction
+	}{
+		{SendRecv, true, true, SendRecv},
+		{SendOnly, true, false, RecvOnly},
+		{RecvOnly, false, true, SendOnly},
+		{Inactive, false, false, Inactive},
+	}
+	for _, test := range tests {
+		if test.d.Sends() != test.sends || test.d.Receives() != test.receives || test.d.Reverse() != test.reverse {
+			t.Errorf("%s: got sends %v receives %v reverse %s", test.d, test.d.Sends(), test.d.Receives(), test.d.Reverse())
+		}
+		if d, ok := ParseDirection(test.d.String()); !ok || d != test.d {
+			t.Errorf("%s doesn't parse back", test.d)
+		}
+	}
+}
+
+func TestRemoveAttributes(t *testing.T) {
+	m := &MLine{}
+	m.AddAttribute("candidate", "1")
+	m.AddAttribute("mid", "0")
+	m.AddAttribute("candidate", "2")
+	m.RemoveAttributes("candidate")
+	if len(m.Attributes) != 1 || m.Attributes[0].Name != "mid" {
+		t.Errorf("got %v", m.Attributes)
+	}
+}
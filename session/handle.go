@@ -0,0 +1,231 @@
+package session
+
+// A handle is the link between a session and a plugin, and carries (at
+// most) one PeerConnection at a time. The WebRTC stack drives the handle
+// through its states, and the handle takes care of telling both the
+// plugin (callbacks) and the client (events) about what happened:
+//
+//	attached -> negotiating -> ice -> dtls -> media   ("webrtcup", SetupMedia)
+//	any of negotiating..media -> hangup               ("hangup", HangupMedia)
+//	hangup -> negotiating                             (a new PeerConnection)
+//	any -> detached                                   ("detached", DestroySession)
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+)
+
+type Handle struct {
+	ID            uint64
+	Session       *Session
+	OpaqueID      string
+	Plugin        plugins.Plugin
+	PluginSession *plugins.PluginSession
+	Created       time.Time
+
+	loop         *loop
+	mutex        sync.Mutex
+	state        State
+	hangupReason string
+	receiving    map[string]bool
+	trickles     []json.RawMessage
+	pc           PeerConnection
+}
+
+// State returns the current state of the handle
+func (h *Handle) State() State {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.state
+}
+
+// HangupReason returns why the last PeerConnection of the handle went away
+func (h *Handle) HangupReason() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.hangupReason
+}
+
+// LoopIndex returns the static loop the handle runs on, or -1 if it has its own
+func (h *Handle) LoopIndex() int {
+	return h.loop.index
+}
+
+// SetState moves the handle to the next state of the PeerConnection setup.
+// Getting to StateMedia notifies the plugin and sends a "webrtcup" event.
+func (h *Handle) SetState(next State) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.state == next {
+		return nil
+	}
+	if !h.state.canMoveTo(next) {
+		log.Warnf("[%d] Can't move from state %s to %s", h.ID, h.state, next)
+		return ErrInvalidState
+	}
+	log.Debugf("[%d] State %s -> %s", h.ID, h.state, next)
+	h.state = next
+	switch next {
+	case StateNegotiating:
+		h.hangupReason = ""
+		h.receiving = make(map[string]bool)
+	case StateMedia:
+		h.loop.push(func() {
+			if !h.PluginSession.Stopped() {
+				h.Plugin.SetupMedia(h.PluginSession)
+			}
+			h.notify("webrtcup", nil)
+		})
+	}
+	return nil
+}
+
+// Hangup tears down the PeerConnection, if any: the plugin is told via
+// HangupMedia and the client gets a "hangup" event with the reason.
+// The handle stays attached and can negotiate a new PeerConnection.
+func (h *Handle) Hangup(reason string) {
+	h.mutex.Lock()
+	if !h.state.active() {
+		h.mutex.Unlock()
+		return
+	}
+	log.Infof("[%d] Hanging up PeerConnection: %s", h.ID, reason)
+	h.state = StateHangup
+	h.hangupReason = reason
+	h.mutex.Unlock()
+	if pc := h.takePeerConnection(); pc != nil {
+		pc.Close()
+	}
+
+	h.loop.push(func() {
+		if !h.PluginSession.Stopped() {
+			h.Plugin.HangupMedia(h.PluginSession)
+		}
+		h.notify("hangup", map[string]interface{}{"reason": reason})
+	})
+}
+
+// Media tells the client whether we're receiving audio or video on the PeerConnection
+func (h *Handle) Media(kind string, receiving bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.state != StateMedia || h.receiving[kind] == receiving {
+		return
+	}
+	h.receiving[kind] = receiving
+	log.Infof("[%d] %s receiving %s", h.ID, map[bool]string{true: "Started", false: "Stopped"}[receiving], kind)
+	h.loop.push(func() {
+		h.notify("media", map[string]interface{}{"type": kind, "receiving": receiving})
+	})
+}
+
+// SlowLink reports a media path losing too many packets: uplink means
+// the losses are on what we receive from the client
+func (h *Handle) SlowLink(uplink, video bool, lost int) {
+	if h.State() != StateMedia {
+		return
+	}
+	h.loop.push(func() {
+		if !h.PluginSession.Stopped() {
+			h.Plugin.SlowLink(h.PluginSession, uplink, video)
+		}
+		h.notify("slowlink", map[string]interface{}{
+			"media":  map[bool]string{true: "video", false: "audio"}[video],
+			"uplink": uplink,
+			"lost":   lost,
+		})
+	})
+}
+
+// Detach hangs up the PeerConnection, if any, and detaches the handle from
+// its plugin and session. The client gets a "detached" event. The plugin
+// is told on the loop of the handle, once what's running there is done, and
+// Detach waits for it: it must not be called from the loop itself (i.e.
+// from plugin callbacks).
+func (h *Handle) Detach() error {
+	h.mutex.Lock()
+	if h.state == StateDetached {
+		h.mutex.Unlock()
+		return ErrHandleDetached
+	}
+	active := h.state.active()
+	h.state = StateDetached
+	if active {
+		h.hangupReason = "Detach"
+	}
+	h.mutex.Unlock()
+	if pc := h.takePeerConnection(); pc != nil {
+		pc.Close()
+	}
+
+	h.Session.removeHandle(h.ID)
+	// from now on the loop won't call the plugin anymore, other than for this
+	h.PluginSession.Stop()
+	done := make(chan error, 1)
+	h.loop.push(func() {
+		if active {
+			h.Plugin.HangupMedia(h.PluginSession)
+		}
+		done <- h.Plugin.DestroySession(h.PluginSession)
+		if active {
+			h.notify("hangup", map[string]interface{}{"reason": "Detach"})
+		}
+		h.notify("detached", nil)
+	})
+	err := <-done
+	if err != nil {
+		log.Warnf("[%d] Error destroying plugin session: %v", h.ID, err)
+	}
+	h.loop.release()
+	log.Infof("[%d] Handle detached from plugin %s", h.ID, h.Plugin.Package())
+	return err
+}
+
+// MaxPendingTrickles is how many candidates a handle queues before the
+// WebRTC stack takes them: a client can't make us hold on to more than that
+const MaxPendingTrickles = 100
+
+// Trickle queues candidates sent by the client until the WebRTC stack takes
+// them. Candidates that don't fit in the queue are all rejected.
+func (h *Handle) Trickle(candidates ...json.RawMessage) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.trickles)+len(candidates) > MaxPendingTrickles {
+		log.Warnf("[%d] Too many pending candidates, rejecting %d", h.ID, len(candidates))
+		return ErrTooManyTrickles
+	}
+	h.trickles = append(h.trickles, candidates...)
+	return nil
+}
+
+// TakeTrickles returns the queued candidates and empties the queue
+func (h *Handle) TakeTrickles() []json.RawMessage {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	trickles := h.trickles
+	h.trickles = nil
+	return trickles
+}
+
+// PendingTrickles returns how many candidates are waiting in the queue
+func (h *Handle) PendingTrickles() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.trickles)
+}
+
+func (h *Handle) notify(kind string, fields map[string]interface{}) {
+	event := map[string]interface{}{
+		"janus":      kind,
+		"session_id": h.Session.ID,
+		"sender":     h.ID,
+	}
+	for k, v := range fields {
+		event[k] = v
+	}
+	h.Session.Notify(event)
+}
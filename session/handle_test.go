@@ -0,0 +1,201 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/plugins"
+)
+
+// testPlugin is just enough of a plugin to attach handles to
+type testPlugin struct {
+	plugins.Plugin
+}
+
+func (testPlugin) Package() string                                { return "janus.plugin.test" }
+func (testPlugin) CreateSession(ps *plugins.PluginSession) error  { return nil }
+func (testPlugin) DestroySession(ps *plugins.PluginSession) error { return nil }
+func (testPlugin) HangupMedia(ps *plugins.PluginSession)          {}
+
+func newTestHandle(t *testing.T) *Handle {
+	s, err := New(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Destroy(false) })
+	h, err := s.Attach(testPlugin{}, "", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func candidates(n int) []json.RawMessage {
+	list := make([]json.RawMessage, n)
+	for i := range list {
+		list[i] = json.RawMessage(fmt.Sprintf(`{"candidate":"candidate:%d 1 udp 2122260223 192.0.2.1 %d typ host"}`, i, 50000+i))
+	}
+	return list
+}
+
+func TestTrickleQueueIsBounded(t *testing.T) {
+	tests := []struct {
+		name    string
+		batches []int
+		err     []error
+		pending int
+	}{
+		{"one at a time", []int{1, 1, 1}, []error{nil, nil, nil}, 3},
+		{"up to the limit", []int{MaxPendingTrickles}, []error{nil}, MaxPendingTrickles},
+		{"over the limit at once", []int{MaxPendingTrickles + 1}, []error{ErrTooManyTrickles}, 0},
+		{"over the limit later", []int{MaxPendingTrickles - 1, 2, 1}, []error{nil, ErrTooManyTrickles, nil}, MaxPendingTrickles},
+		{"full", []int{MaxPendingTrickles, 1}, []error{nil, ErrTooManyTrickles}, MaxPendingTrickles},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newTestHandle(t)
+			for i, n := range test.batches {
+				if err := h.Trickle(candidates(n)...); err != test.err[i] {
+					t.Errorf("batch %d of %d candidates: got error %v, want %v", i, n, err, test.err[i])
+				}
+			}
+			if pending := h.PendingTrickles(); pending != test.pending {
+				t.Errorf("got %d pending candidates, want %d", pending, test.pending)
+			}
+		})
+	}
+}
+
+func TestTakeTricklesMakesRoom(t *testing.T) {
+	h := newTestHandle(t)
+	if err := h.Trickle(candidates(MaxPendingTrickles)...); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Trickle(candidates(1)...); err != ErrTooManyTrickles {
+		t.Fatalf("got error %v on a full queue, want %v", err, ErrTooManyTrickles)
+	}
+	if taken := h.TakeTrickles(); len(taken) != MaxPendingTrickles {
+		t.Fatalf("took %d candidates, want %d", len(taken), MaxPendingTrickles)
+	}
+	if err := h.Trickle(candidates(1)...); err != nil {
+		t.Fatalf("got error %v once the queue was emptied", err)
+	}
+}
+
+// slowPlugin takes its time in SetupMedia, and tells whether DestroySession
+// ever ran while another callback was running
+type slowPlugin struct {
+	testPlugin
+	release     chan struct{}
+	setup       chan struct{}
+	busy        int32
+	overlapping int32
+	created     int32
+	destroyed   int32
+}
+
+func (p *slowPlugin) CreateSession(ps *plugins.PluginSession) error {
+	atomic.AddInt32(&p.created, 1)
+	return nil
+}
+
+func (p *slowPlugin) SetupMedia(ps *plugins.PluginSession) {
+	atomic.StoreInt32(&p.busy, 1)
+	close(p.setup)
+	<-p.release
+	atomic.StoreInt32(&p.busy, 0)
+}
+
+func (p *slowPlugin) HangupMedia(ps *plugins.PluginSession) {
+	if atomic.LoadInt32(&p.busy) == 1 {
+		atomic.StoreInt32(&p.overlapping, 1)
+	}
+}
+
+func (p *slowPlugin) DestroySession(ps *plugins.PluginSession) error {
+	if atomic.LoadInt32(&p.busy) == 1 {
+		atomic.StoreInt32(&p.overlapping, 1)
+	}
+	atomic.AddInt32(&p.destroyed, 1)
+	return nil
+}
+
+func TestDetachWaitsForTheLoop(t *testing.T) {
+	p := &slowPlugin{release: make(chan struct{}), setup: make(chan struct{})}
+	s, err := New(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Destroy(false) })
+	h, err := s.Attach(p, "", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []State{StateNegotiating, StateICE, StateDTLS, StateMedia} {
+		if err := h.SetState(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-p.setup
+
+	detached := make(chan error)
+	go func() { detached <- h.Detach() }()
+	select {
+	case <-detached:
+		t.Fatal("Detach returned while SetupMedia was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(p.release)
+	if err := <-detached; err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&p.overlapping) == 1 {
+		t.Error("the plugin was torn down while SetupMedia was running")
+	}
+	if atomic.LoadInt32(&p.destroyed) != 1 {
+		t.Error("DestroySession wasn't called by the time Detach returned")
+	}
+	if err := h.Detach(); err != ErrHandleDetached {
+		t.Errorf("got %v detaching twice, want %v", err, ErrHandleDetached)
+	}
+}
+
+func TestAttachRacingDestroy(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		p := &slowPlugin{}
+		s, err := New(nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.Attach(p, "", -1); err != nil && err != ErrSessionDestroyed {
+					t.Error(err)
+				}
+			}()
+		}
+		s.Destroy(false)
+		wg.Wait()
+		if handles := s.Handles(); len(handles) != 0 {
+			t.Fatalf("got %d handles left in a destroyed session", len(handles))
+		}
+		if created, destroyed := atomic.LoadInt32(&p.created), atomic.LoadInt32(&p.destroyed); created != destroyed {
+			t.Fatalf("got %d plugin sessions created and %d destroyed", created, destroyed)
+		}
+	}
+	s, err := New(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Destroy(false)
+	if _, err := s.Attach(testPlugin{}, "", -1); err != ErrSessionDestroyed {
+		t.Errorf("got %v attaching to a destroyed session, want %v", err, ErrSessionDestroyed)
+	}
+}
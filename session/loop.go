@@ -0,0 +1,121 @@
+package session
+
+// Every handle has a loop where the plugin callbacks and the events about
+// the handle are run one after the other, so that a plugin never sees
+// SetupMedia and HangupMedia for the same handle at the same time and
+// clients get the events in the right order. By default each handle gets
+// its own loop, but a fixed number of static loops can be configured to
+// be shared by all handles, like the event_loops setting of Janus.
+
+import (
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type loop struct {
+	index   int
+	handles int32
+
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	queue  []func()
+	closed bool
+}
+
+func newLoop(index int) *loop {
+	l := &loop{index: index}
+	l.cond = sync.NewCond(&l.mutex)
+	go l.run()
+	return l
+}
+
+func (l *loop) run() {
+	for {
+		l.mutex.Lock()
+		for len(l.queue) == 0 && !l.closed {
+			l.cond.Wait()
+		}
+		if len(l.queue) == 0 {
+			l.mutex.Unlock()
+			return
+		}
+		task := l.queue[0]
+		l.queue[0] = nil
+		l.queue = l.queue[1:]
+		l.mutex.Unlock()
+
+		task()
+	}
+}
+
+// push queues a task; it never blocks, and tasks pushed after close are dropped
+func (l *loop) push(task func()) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return
+	}
+	l.queue = append(l.queue, task)
+	l.cond.Signal()
+}
+
+// close lets the loop run what is already queued and then stop
+func (l *loop) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = true
+	l.cond.Signal()
+}
+
+var (
+	staticLoops    []*loop
+	allowLoopIndex bool
+	nextStaticLoop uint32
+)
+
+// InitLoops starts count static loops shared by all handles (0 means a loop per handle).
+// If allowIndex is set, clients can choose the loop with loop_index when attaching.
+func InitLoops(count int, allowIndex bool) {
+	for i := 0; i < count; i++ {
+		staticLoops = append(staticLoops, newLoop(i))
+	}
+	allowLoopIndex = allowIndex
+	if count > 0 {
+		log.Infof("Using %d static event loops (loop indication %v)", count, allowIndex)
+	}
+}
+
+// pickLoop returns the loop a new handle should use: index is the loop the
+// client asked for, or -1 to let us pick one
+func pickLoop(index int) (*loop, error) {
+	if len(staticLoops) == 0 {
+		if index >= 0 {
+			return nil, ErrLoopNotAllowed
+		}
+		return newLoop(-1), nil
+	}
+	if index >= 0 {
+		if !allowLoopIndex {
+			return nil, ErrLoopNotAllowed
+		}
+		if index >= len(staticLoops) {
+			return nil, ErrInvalidLoop
+		}
+	} else {
+		index = int(atomic.AddUint32(&nextStaticLoop, 1)-1) % len(staticLoops)
+	}
+	l := staticLoops[index]
+	atomic.AddInt32(&l.handles, 1)
+	return l, nil
+}
+
+// release tells the loop a handle that was using it is gone
+func (l *loop) release() {
+	if l.index < 0 {
+		l.close()
+		return
+	}
+	atomic.AddInt32(&l.handles, -1)
+}
@@ -0,0 +1,138 @@
+package session
+
+// Media goes straight between the WebRTC stack and the plugin, without
+// going through the event loop of the handle: packets received on the
+// PeerConnection are passed to the plugin, and the plugin relays packets
+// to the client through the PeerConnection the stack set on the handle.
+// Nothing flows unless the PeerConnection is up (StateMedia).
+
+import (
+	"github.com/xroger88/go-janus/plugins"
+)
+
+// PeerConnection is what the WebRTC stack provides for each handle with a
+// PeerConnection, to send media to the client
+type PeerConnection interface {
+	SendRTP(packet *plugins.RTPPacket) error
+	SendRTCP(packet *plugins.RTCPPacket) error
+	SendData(packet *plugins.DataPacket) error
+	// Info describes the PeerConnection, for handle_info in the Admin API
+	Info() *PeerConnectionInfo
+	// Close tears the PeerConnection down, e.g. when the handle hangs up
+	Close()
+}
+
+// PeerConnectionInfo is the "webrtc" section of handle_info, with the
+// same elements as in the original Janus:
+//
+//	ICE   "state", "local-candidates", "remote-candidates", "selected-pair"
+//	DTLS  "role", "state", "fingerprint", "remote-fingerprint", "srtp-profile"
+//	Media one for each m-line: "mid", "type", "ssrc", and the RTP/RTCP
+//	      counters of each direction ("in-stats" and "out-stats")
+type PeerConnectionInfo struct {
+	ICE   map[string]interface{}   `json:"ice"`
+	DTLS  map[string]interface{}   `json:"dtls"`
+	Media []map[string]interface{} `json:"media"`
+}
+
+// NoPeerConnectionInfo is what handle_info shows while a handle has no PeerConnection
+func NoPeerConnectionInfo() *PeerConnectionInfo {
+	return &PeerConnectionInfo{
+		ICE:   map[string]interface{}{"state": "none"},
+		DTLS:  map[string]interface{}{"state": "none"},
+		Media: []map[string]interface{}{},
+	}
+}
+
+// SetPeerConnection binds the PeerConnection the WebRTC stack created for
+// the handle, closing the previous one if any
+func (h *Handle) SetPeerConnection(pc PeerConnection) {
+	h.mutex.Lock()
+	old := h.pc
+	h.pc = pc
+	h.mutex.Unlock()
+	if old != nil && old != pc {
+		old.Close()
+	}
+}
+
+// PeerConnection returns the PeerConnection of the handle, if it's up
+func (h *Handle) PeerConnection() PeerConnection {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.state != StateMedia {
+		return nil
+	}
+	return h.pc
+}
+
+// PeerConnectionInfo describes the PeerConnection of the handle, even
+// while it's still being set up
+func (h *Handle) PeerConnectionInfo() *PeerConnectionInfo {
+	h.mutex.Lock()
+	pc := h.pc
+	h.mutex.Unlock()
+	if pc == nil {
+		return NoPeerConnectionInfo()
+	}
+	return pc.Info()
+}
+
+// takePeerConnection unbinds the PeerConnection, for the caller to close it
+func (h *Handle) takePeerConnection() PeerConnection {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	pc := h.pc
+	h.pc = nil
+	return pc
+}
+
+// receivingMedia tells whether media from the client can go to the plugin
+func (h *Handle) receivingMedia() bool {
+	return h.State() == StateMedia && !h.PluginSession.Stopped()
+}
+
+// IncomingRTP passes an RTP packet from the client to the plugin
+func (h *Handle) IncomingRTP(packet *plugins.RTPPacket) {
+	if h.receivingMedia() {
+		h.Plugin.IncomingRTP(h.PluginSession, packet)
+	}
+}
+
+// IncomingRTCP passes an RTCP packet from the client to the plugin
+func (h *Handle) IncomingRTCP(packet *plugins.RTCPPacket) {
+	if h.receivingMedia() {
+		h.Plugin.IncomingRTCP(h.PluginSession, packet)
+	}
+}
+
+// IncomingData passes a data channel message from the client to the plugin
+func (h *Handle) IncomingData(packet *plugins.DataPacket) {
+	if h.receivingMedia() {
+		h.Plugin.IncomingData(h.PluginSession, packet)
+	}
+}
+
+// RelayRTP sends an RTP packet from the plugin to the client
+func (h *Handle) RelayRTP(packet *plugins.RTPPacket) error {
+	if pc := h.PeerConnection(); pc != nil {
+		return pc.SendRTP(packet)
+	}
+	return nil
+}
+
+// RelayRTCP sends an RTCP packet from the plugin to the client
+func (h *Handle) RelayRTCP(packet *plugins.RTCPPacket) error {
+	if pc := h.PeerConnection(); pc != nil {
+		return pc.SendRTCP(packet)
+	}
+	return nil
+}
+
+// RelayData sends a data channel message from the plugin to the client
+func (h *Handle) RelayData(packet *plugins.DataPacket) error {
+	if pc := h.PeerConnection(); pc != nil {
+		return pc.SendData(packet)
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package session
+
+// A session is what a client creates first: it owns the handles the client
+// attaches to plugins, and it is bound to the transport instance it was
+// created through, which is where all the events about it are sent.
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/util"
+)
+
+var (
+	ErrSessionDestroyed = errors.New("session has been destroyed")
+	ErrSessionConflict  = errors.New("session ID already in use")
+	ErrHandleDetached   = errors.New("handle has been detached")
+	ErrLoopNotAllowed   = errors.New("loop_index can't be used: static event loops are disabled or loop indication is not allowed")
+	ErrInvalidLoop      = errors.New("invalid loop_index")
+	ErrInvalidState     = errors.New("invalid state transition")
+	ErrTooManyTrickles  = errors.New("too many pending candidates")
+)
+
+// Transport is what the core needs from a transport: a way to send back
+// responses and events, and to be told about the sessions it owns.
+// instance identifies the connection (or whatever the transport uses),
+// and requestID is the one the transport passed along with the request.
+type Transport interface {
+	SendMessage(instance interface{}, requestID interface{}, admin bool, message map[string]interface{}) error
+	SessionCreated(instance interface{}, sessionID uint64)
+	// SessionOver is called when a session goes away, either because it
+	// timed out or because it has been claimed by another transport instance
+	SessionOver(instance interface{}, sessionID uint64, timeout, claimed bool)
+	SessionClaimed(instance interface{}, sessionID uint64)
+}
+
+// Source is the transport instance a session belongs to
+type Source struct {
+	Transport Transport
+	Instance  interface{}
+}
+
+// Same tells whether two sources are the same transport instance
+func (src *Source) Same(other *Source) bool {
+	return src != nil && other != nil && src.Transport == other.Transport && src.Instance == other.Instance
+}
+
+type Session struct {
+	ID      uint64
+	Created time.Time
+
+	mutex     sync.RWMutex
+	source    *Source
+	handles   map[uint64]*Handle
+	activity  int64 // unix nanoseconds of the last request, accessed atomically
+	destroyed int32
+	goneAt    time.Time // when the transport went away, if it did
+}
+
+var (
+	sessionsMutex sync.RWMutex
+	sessions      = make(map[uint64]*Session)
+)
+
+// New creates a session owned by the given transport instance.
+// The ID is random, unless the client asked for a specific one.
+func New(source *Source, id uint64) (*Session, error) {
+	s := &Session{
+		ID:      id,
+		Created: time.Now(),
+		source:  source,
+		handles: make(map[uint64]*Handle),
+	}
+	s.Touch()
+
+	sessionsMutex.Lock()
+	if id != 0 {
+		if _, found := sessions[id]; found {
+			sessionsMutex.Unlock()
+			return nil, ErrSessionConflict
+		}
+	} else {
+		for {
+			s.ID = util.RandomUint64()
+			if _, found := sessions[s.ID]; !found {
+				break
+			}
+		}
+	}
+	sessions[s.ID] = s
+	sessionsMutex.Unlock()
+
+	log.Infof("Created new session: %d", s.ID)
+	if source != nil && source.Transport != nil {
+		source.Transport.SessionCreated(source.Instance, s.ID)
+	}
+	return s, nil
+}
+
+// Find returns the session with the given ID, or nil
+func Find(id uint64) *Session {
+	sessionsMutex.RLock()
+	defer sessionsMutex.RUnlock()
+	return sessions[id]
+}
+
+// List returns all the sessions currently alive
+func List() []*Session {
+	sessionsMutex.RLock()
+	defer sessionsMutex.RUnlock()
+	list := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		list = append(list, s)
+	}
+	return list
+}
+
+// Touch records some activity on the session
+func (s *Session) Touch() {
+	atomic.StoreInt64(&s.activity, time.Now().UnixNano())
+}
+
+// LastActivity returns when the session was last used
+func (s *Session) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.activity))
+}
+
+// Destroyed tells whether the session is gone
+func (s *Session) Destroyed() bool {
+	return atomic.LoadInt32(&s.destroyed) == 1
+}
+
+// Source returns the transport instance the session belongs to
+func (s *Session) Source() *Source {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.source
+}
+
+// Claim moves the session to another transport instance, e.g. after a
+// client reconnected: the previous owner, if any, is told it lost it
+func (s *Session) Claim(source *Source) error {
+	if s.Destroyed() {
+		return ErrSessionDestroyed
+	}
+	s.mutex.Lock()
+	old := s.source
+	s.source = source
+	s.goneAt = time.Time{}
+	s.mutex.Unlock()
+
+	if old.Same(source) {
+		return nil
+	}
+	if old != nil && old.Transport != nil {
+		old.Transport.SessionOver(old.Instance, s.ID, false, true)
+	}
+	if source != nil && source.Transport != nil {
+		source.Transport.SessionClaimed(source.Instance, s.ID)
+	}
+	log.Infof("Session %d has been claimed", s.ID)
+	return nil
+}
+
+// Handle returns the handle with the given ID, or nil
+func (s *Session) Handle(id uint64) *Handle {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.handles[id]
+}
+
+// Handles returns all the handles of the session
+func (s *Session) Handles() []*Handle {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	list := make([]*Handle, 0, len(s.handles))
+	for _, h := range s.handles {
+		list = append(list, h)
+	}
+	return list
+}
+
+// Attach creates a new handle attached to the plugin. loopIndex is the
+// static loop the client asked for, or -1 if it didn't.
+func (s *Session) Attach(p plugins.Plugin, opaqueID string, loopIndex int) (*Handle, error) {
+	if s.Destroyed() {
+		return nil, ErrSessionDestroyed
+	}
+	l, err := pickLoop(loopIndex)
+	if err != nil {
+		return nil, err
+	}
+	h := &Handle{
+		Session:  s,
+		OpaqueID: opaqueID,
+		Plugin:   p,
+		Created:  time.Now(),
+		loop:     l,
+		state:    StateAttached,
+	}
+	h.PluginSession = plugins.NewPluginSession(h)
+	if err := p.CreateSession(h.PluginSession); err != nil {
+		h.PluginSession.Stop()
+		l.release()
+		return nil, err
+	}
+
+	// the session may have been destroyed meanwhile: Destroy only detaches
+	// the handles it finds, so a handle added after that would be left over
+	s.mutex.Lock()
+	if s.Destroyed() {
+		s.mutex.Unlock()
+		h.PluginSession.Stop()
+		p.DestroySession(h.PluginSession)
+		l.release()
+		return nil, ErrSessionDestroyed
+	}
+	for {
+		h.ID = util.RandomUint64()
+		if _, found := s.handles[h.ID]; !found {
+			break
+		}
+	}
+	s.handles[h.ID] = h
+	s.mutex.Unlock()
+
+	log.Infof("[%d] Handle attached to plugin %s (session %d, loop %d)", h.ID, p.Package(), s.ID, l.index)
+	return h, nil
+}
+
+func (s *Session) removeHandle(id uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.handles, id)
+}
+
+// Destroy detaches all the handles and removes the session. The transport
+// owning it is told the session is over (timeout says whether that's why).
+func (s *Session) Destroy(timeout bool) error {
+	// under the mutex, so that Attach doesn't add handles after we took them
+	s.mutex.Lock()
+	destroying := atomic.CompareAndSwapInt32(&s.destroyed, 0, 1)
+	s.mutex.Unlock()
+	if !destroying {
+		return ErrSessionDestroyed
+	}
+	sessionsMutex.Lock()
+	delete(sessions, s.ID)
+	sessionsMutex.Unlock()
+
+	for _, h := range s.Handles() {
+		h.Detach()
+	}
+	if source := s.Source(); source != nil && source.Transport != nil {
+		source.Transport.SessionOver(source.Instance, s.ID, timeout, false)
+	}
+	log.Infof("Destroyed session: %d", s.ID)
+	return nil
+}
+
+// TransportGone is called when a transport instance goes away (e.g. a
+// WebSocket connection is closed): the sessions it owned are destroyed,
+// unless a reclaim timeout is configured, in which case they are kept
+// around for a while to give the client a chance to claim them back
+func TransportGone(source *Source) {
+	for _, s := range List() {
+		s.orphan(source)
+	}
+}
+
+// Orphan cuts a session off the transport instance it belongs to, as if
+// the instance went away: e.g. when the client is too slow to take its events
+func (s *Session) Orphan() {
+	s.orphan(s.Source())
+}
+
+func (s *Session) orphan(source *Source) {
+	s.mutex.Lock()
+	owned := s.source.Same(source)
+	if owned {
+		s.source = nil
+		s.goneAt = time.Now()
+	}
+	s.mutex.Unlock()
+	if !owned {
+		return
+	}
+	if ReclaimTimeout() == 0 {
+		log.Infof("Transport gone, destroying session %d", s.ID)
+		s.Destroy(false)
+	} else {
+		log.Infof("Transport gone, session %d can be reclaimed for %v", s.ID, ReclaimTimeout())
+	}
+}
+
+// Notify sends an event to the transport instance the session belongs to
+func (s *Session) Notify(event map[string]interface{}) {
+	source := s.Source()
+	if source == nil || source.Transport == nil {
+		log.Debugf("Session %d has no transport, dropping %v event", s.ID, event["janus"])
+		return
+	}
+	if err := source.Transport.SendMessage(source.Instance, nil, false, event); err != nil {
+		log.Warnf("Error sending %v event for session %d: %v", event["janus"], s.ID, err)
+	}
+}
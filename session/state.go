@@ -0,0 +1,65 @@
+package session
+
+import "fmt"
+
+// State is where a handle is in the life of its PeerConnection
+type State int
+
+const (
+	// StateAttached means the handle is attached to a plugin and no PeerConnection exists yet
+	StateAttached State = iota
+	// StateNegotiating means an offer/answer exchange is in progress
+	StateNegotiating
+	// StateICE means ICE connectivity checks are running
+	StateICE
+	// StateDTLS means ICE succeeded and the DTLS handshake is running
+	StateDTLS
+	// StateMedia means the PeerConnection is up and media can flow
+	StateMedia
+	// StateHangup means the PeerConnection went away; a new negotiation can start over
+	StateHangup
+	// StateDetached means the handle is gone for good
+	StateDetached
+)
+
+var stateNames = [...]string{
+	StateAttached:    "attached",
+	StateNegotiating: "negotiating",
+	StateICE:         "ice",
+	StateDTLS:        "dtls",
+	StateMedia:       "media",
+	StateHangup:      "hangup",
+	StateDetached:    "detached",
+}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
+// transitions lists the states each state can move to, apart from
+// StateHangup and StateDetached which are handled by Hangup and Detach
+var transitions = map[State][]State{
+	StateAttached:    {StateNegotiating},
+	StateNegotiating: {StateICE},
+	StateICE:         {StateDTLS},
+	StateDTLS:        {StateMedia},
+	StateMedia:       {},
+	StateHangup:      {StateNegotiating},
+}
+
+func (s State) canMoveTo(next State) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// active tells whether a PeerConnection exists (or is being set up) in this state
+func (s State) active() bool {
+	return s >= StateNegotiating && s <= StateMedia
+}
@@ -0,0 +1,64 @@
+package session
+
+// The watchdog gets rid of sessions nobody is using anymore: sessions that
+// received no request for longer than the session timeout, and sessions
+// whose transport went away and were not reclaimed in time.
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	timeout int64 // time.Duration, accessed atomically
+	reclaim int64 // time.Duration, accessed atomically
+)
+
+// Timeout returns how long a session can stay idle (0 means forever)
+func Timeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&timeout))
+}
+
+// SetTimeout changes how long a session can stay idle (0 means forever)
+func SetTimeout(d time.Duration) {
+	atomic.StoreInt64(&timeout, int64(d))
+}
+
+// ReclaimTimeout returns how long a session survives its transport going away
+func ReclaimTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&reclaim))
+}
+
+// StartWatchdog sets the timeouts and starts checking sessions periodically
+func StartWatchdog(sessionTimeout, reclaimTimeout time.Duration) {
+	SetTimeout(sessionTimeout)
+	atomic.StoreInt64(&reclaim, int64(reclaimTimeout))
+	go func() {
+		for range time.Tick(2 * time.Second) {
+			checkSessions()
+		}
+	}()
+}
+
+func checkSessions() {
+	now := time.Now()
+	idle, reclaim := Timeout(), ReclaimTimeout()
+	for _, s := range List() {
+		s.mutex.RLock()
+		source, goneAt := s.source, s.goneAt
+		s.mutex.RUnlock()
+
+		if source == nil && !goneAt.IsZero() && now.Sub(goneAt) >= reclaim {
+			log.Infof("Session %d was not reclaimed in time, destroying it", s.ID)
+			s.Destroy(false)
+			continue
+		}
+		if idle > 0 && now.Sub(s.LastActivity()) >= idle {
+			log.Infof("Timeout expired for session %d", s.ID)
+			s.Notify(map[string]interface{}{"janus": "timeout", "session_id": s.ID})
+			s.Destroy(true)
+		}
+	}
+}
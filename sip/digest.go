@@ -0,0 +1,151 @@
+package sip
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Challenge is a digest challenge (RFC 2617), from the WWW-Authenticate or
+// Proxy-Authenticate header of a 401 or 407
+type Challenge struct {
+	Realm     string
+	Nonce     string
+	Opaque    string
+	Algorithm string
+	// Qop is "auth" if the server supports it, "" otherwise
+	Qop   string
+	Stale bool
+}
+
+// ParseChallenge parses a WWW-Authenticate or Proxy-Authenticate value
+func ParseChallenge(value string) (*Challenge, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 7 || !strings.EqualFold(value[:7], "Digest ") {
+		return nil, errors.New("not a digest challenge")
+	}
+	c := &Challenge{}
+	for name, v := range authParams(value[7:]) {
+		switch name {
+		case "realm":
+			c.Realm = v
+		case "nonce":
+			c.Nonce = v
+		case "opaque":
+			c.Opaque = v
+		case "algorithm":
+			c.Algorithm = v
+		case "qop":
+			for _, q := range strings.Split(v, ",") {
+				if strings.TrimSpace(q) == "auth" {
+					c.Qop = "auth"
+				}
+			}
+		case "stale":
+			c.Stale = strings.EqualFold(v, "true")
+		}
+	}
+	if c.Nonce == "" {
+		return nil, errors.New("digest challenge without nonce")
+	}
+	if c.Algorithm != "" && !strings.EqualFold(c.Algorithm, "MD5") {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", c.Algorithm)
+	}
+	return c, nil
+}
+
+// authParams parses the name=value (or name="value") list of a challenge
+func authParams(s string) map[string]string {
+	params := make(map[string]string)
+	for _, p := range splitParams(s) {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.Trim(strings.TrimSpace(kv[1]), "\"")
+		}
+	}
+	return params
+}
+
+// splitParams splits on commas outside quotes
+func splitParams(s string) []string {
+	var list []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				list = append(list, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(list, s[start:])
+}
+
+func md5hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authorization answers the challenge for a request, nc being how many
+// times the nonce has been used so far (from 1 on)
+func (c *Challenge) Authorization(method, uri, user, password string, nc int) string {
+	ha1 := md5hex(user + ":" + c.Realm + ":" + password)
+	ha2 := md5hex(method + ":" + uri)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Digest username=\"%s\", realm=\"%s\", nonce=\"%s\", uri=\"%s\"", user, c.Realm, c.Nonce, uri)
+	if c.Qop == "auth" {
+		var r [8]byte
+		rand.Read(r[:])
+		cnonce := hex.EncodeToString(r[:])
+		count := fmt.Sprintf("%08x", nc)
+		response := md5hex(ha1 + ":" + c.Nonce + ":" + count + ":" + cnonce + ":auth:" + ha2)
+		fmt.Fprintf(&b, ", response=\"%s\", cnonce=\"%s\", nc=%s, qop=auth", response, cnonce, count)
+	} else {
+		fmt.Fprintf(&b, ", response=\"%s\"", md5hex(ha1+":"+c.Nonce+":"+ha2))
+	}
+	b.WriteString(", algorithm=MD5")
+	if c.Opaque != "" {
+		fmt.Fprintf(&b, ", opaque=\"%s\"", c.Opaque)
+	}
+	return b.String()
+}
+
+// Credentials are what an Authorization header tells, for registrars
+// checking it
+type Credentials struct {
+	Username, Realm, Nonce, URI, Response string
+	Cnonce, Nc, Qop                       string
+}
+
+// ParseCredentials parses an Authorization or Proxy-Authorization value
+func ParseCredentials(value string) (*Credentials, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 7 || !strings.EqualFold(value[:7], "Digest ") {
+		return nil, errors.New("not digest credentials")
+	}
+	p := authParams(value[7:])
+	c := &Credentials{
+		Username: p["username"], Realm: p["realm"], Nonce: p["nonce"], URI: p["uri"],
+		Response: p["response"], Cnonce: p["cnonce"], Nc: p["nc"], Qop: p["qop"],
+	}
+	if c.Username == "" || c.Nonce == "" || c.Response == "" {
+		return nil, errors.New("incomplete digest credentials")
+	}
+	return c, nil
+}
+
+// Check tells whether the credentials were computed with password
+func (c *Credentials) Check(method, password string) bool {
+	ha1 := md5hex(c.Username + ":" + c.Realm + ":" + password)
+	ha2 := md5hex(method + ":" + c.URI)
+	if c.Qop == "auth" {
+		return c.Response == md5hex(ha1+":"+c.Nonce+":"+c.Nc+":"+c.Cnonce+":auth:"+ha2)
+	}
+	return c.Response == md5hex(ha1+":"+c.Nonce+":"+ha2)
+}
@@ -0,0 +1,322 @@
+package sip
+
+// A minimal SIP stack (RFC 3261), enough for the SIP plugin to register to
+// a registrar and to place and receive calls, much like what the original
+// Janus gets from Sofia-SIP: parsing and writing messages, digest
+// authentication, UDP and TCP transports, and client transactions with their
+// retransmissions. Dialogs are left to the users of the stack.
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Header is a header field, as written in the message
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Message is a SIP request (Method is set) or response
+type Message struct {
+	// Method and URI of requests
+	Method string
+	URI    string
+	// StatusCode and Reason of responses
+	StatusCode int
+	Reason     string
+
+	Headers []Header
+	Body    []byte
+
+	// Source and Transport tell where received messages come from
+	Source    string
+	Transport string
+}
+
+// compact forms of the header names
+var compact = map[string]string{
+	"i": "call-id", "m": "contact", "e": "content-encoding", "l": "content-length",
+	"c": "content-type", "f": "from", "s": "subject", "k": "supported", "t": "to",
+	"v": "via", "r": "refer-to", "b": "referred-by",
+}
+
+func canonical(name string) string {
+	name = strings.ToLower(name)
+	if long, ok := compact[name]; ok {
+		return long
+	}
+	return name
+}
+
+// IsRequest tells whether the message is a request
+func (m *Message) IsRequest() bool {
+	return m.Method != ""
+}
+
+// Get returns the first value of a header, or ""
+func (m *Message) Get(name string) string {
+	name = canonical(name)
+	for _, h := range m.Headers {
+		if canonical(h.Name) == name {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// Values returns all the values of a header, splitting the comma separated
+// lists of headers like Via, Contact or Route
+func (m *Message) Values(name string) []string {
+	name = canonical(name)
+	var values []string
+	for _, h := range m.Headers {
+		if canonical(h.Name) != name {
+			continue
+		}
+		switch name {
+		case "via", "contact", "route", "record-route", "allow", "supported":
+			values = append(values, splitList(h.Value)...)
+		default:
+			values = append(values, h.Value)
+		}
+	}
+	return values
+}
+
+// splitList splits a comma separated list, but not within quotes or <>
+func splitList(value string) []string {
+	var list []string
+	quoted, bracket, start := false, false, 0
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"' && !bracket:
+			quoted = !quoted
+		case c == '<' && !quoted:
+			bracket = true
+		case c == '>' && !quoted:
+			bracket = false
+		case c == ',' && !quoted && !bracket:
+			list = append(list, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(list, strings.TrimSpace(value[start:]))
+}
+
+// Add appends a header
+func (m *Message) Add(name, value string) {
+	m.Headers = append(m.Headers, Header{name, value})
+}
+
+// Set replaces a header, adding it at the end if missing
+func (m *Message) Set(name, value string) {
+	m.Del(name)
+	m.Add(name, value)
+}
+
+// Del removes all the values of a header
+func (m *Message) Del(name string) {
+	name = canonical(name)
+	kept := m.Headers[:0]
+	for _, h := range m.Headers {
+		if canonical(h.Name) != name {
+			kept = append(kept, h)
+		}
+	}
+	m.Headers = kept
+}
+
+// CallID returns the Call-ID of the message
+func (m *Message) CallID() string {
+	return m.Get("Call-ID")
+}
+
+// CSeq returns the sequence number and the method of the CSeq header
+func (m *Message) CSeq() (int, string) {
+	f := strings.Fields(m.Get("CSeq"))
+	if len(f) != 2 {
+		return 0, ""
+	}
+	n, _ := strconv.Atoi(f[0])
+	return n, f[1]
+}
+
+// Branch returns the branch of the top Via
+func (m *Message) Branch() string {
+	if via := m.Values("Via"); len(via) > 0 {
+		return Param(via[0], "branch")
+	}
+	return ""
+}
+
+// Bytes writes the message, with the right Content-Length
+func (m *Message) Bytes() []byte {
+	var b bytes.Buffer
+	if m.IsRequest() {
+		fmt.Fprintf(&b, "%s %s SIP/2.0\r\n", m.Method, m.URI)
+	} else {
+		fmt.Fprintf(&b, "SIP/2.0 %d %s\r\n", m.StatusCode, m.Reason)
+	}
+	for _, h := range m.Headers {
+		if canonical(h.Name) != "content-length" {
+			fmt.Fprintf(&b, "%s: %s\r\n", h.Name, h.Value)
+		}
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(m.Body))
+	b.Write(m.Body)
+	return b.Bytes()
+}
+
+func (m *Message) String() string {
+	return string(m.Bytes())
+}
+
+// Parse parses a message received as a datagram, where Content-Length is
+// optional
+func Parse(data []byte) (*Message, error) {
+	return readMessage(bufio.NewReader(bytes.NewReader(data)), false)
+}
+
+// ReadMessage reads a message from a stream, which needs Content-Length
+// to tell where the body ends
+func ReadMessage(r *bufio.Reader) (*Message, error) {
+	return readMessage(r, true)
+}
+
+func readMessage(r *bufio.Reader, stream bool) (*Message, error) {
+	var line string
+	var err error
+	// keep-alives (CRLFs) may come between messages
+	for line == "" {
+		if line, err = readLine(r); err != nil {
+			return nil, err
+		}
+	}
+	m := &Message{}
+	f := strings.SplitN(line, " ", 3)
+	if len(f) < 3 {
+		return nil, fmt.Errorf("invalid start line: %q", line)
+	}
+	if f[0] == "SIP/2.0" {
+		if m.StatusCode, err = strconv.Atoi(f[1]); err != nil || m.StatusCode < 100 || m.StatusCode > 699 {
+			return nil, fmt.Errorf("invalid status line: %q", line)
+		}
+		m.Reason = f[2]
+	} else {
+		if f[2] != "SIP/2.0" {
+			return nil, fmt.Errorf("invalid request line: %q", line)
+		}
+		m.Method, m.URI = f[0], f[1]
+	}
+	for {
+		if line, err = readLine(r); err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(m.Headers) > 0 {
+			// folded line
+			m.Headers[len(m.Headers)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header: %q", line)
+		}
+		m.Add(strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]))
+	}
+	if m.Get("Call-ID") == "" || m.Get("CSeq") == "" || m.Get("From") == "" || m.Get("To") == "" {
+		return nil, errors.New("missing mandatory headers")
+	}
+	length := -1
+	if l := m.Get("Content-Length"); l != "" {
+		if length, err = strconv.Atoi(l); err != nil || length < 0 {
+			return nil, fmt.Errorf("invalid Content-Length: %q", l)
+		}
+	}
+	if length < 0 {
+		if stream {
+			return nil, errors.New("missing Content-Length")
+		}
+		m.Body, _ = io.ReadAll(r)
+		return m, nil
+	}
+	m.Body = make([]byte, length)
+	if _, err := io.ReadFull(r, m.Body); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Param returns a parameter of a header value (e.g. the tag of a From, or
+// the branch of a Via), "" if missing, not looking into <>
+func Param(value, name string) string {
+	if i := strings.LastIndexByte(value, '>'); i >= 0 {
+		value = value[i+1:]
+	}
+	for _, p := range strings.Split(value, ";")[1:] {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if strings.EqualFold(kv[0], name) {
+			if len(kv) == 2 {
+				return strings.Trim(kv[1], "\"")
+			}
+			return kv[0]
+		}
+	}
+	return ""
+}
+
+// NewResponse creates a response to a request, copying the headers that
+// go along (a tag is added to To if not empty and missing)
+func NewResponse(req *Message, code int, reason, tag string) *Message {
+	if reason == "" {
+		reason = StatusText(code)
+	}
+	res := &Message{StatusCode: code, Reason: reason}
+	for _, h := range req.Headers {
+		switch canonical(h.Name) {
+		case "via", "from", "call-id", "cseq", "record-route":
+			res.Add(h.Name, h.Value)
+		case "to":
+			value := h.Value
+			if tag != "" && Param(value, "tag") == "" {
+				value += ";tag=" + tag
+			}
+			res.Add(h.Name, value)
+		}
+	}
+	return res
+}
+
+var statusTexts = map[int]string{
+	100: "Trying", 180: "Ringing", 181: "Call Is Being Forwarded", 182: "Queued", 183: "Session Progress",
+	200: "OK", 202: "Accepted",
+	400: "Bad Request", 401: "Unauthorized", 403: "Forbidden", 404: "Not Found", 405: "Method Not Allowed",
+	407: "Proxy Authentication Required", 408: "Request Timeout", 480: "Temporarily Unavailable",
+	481: "Call/Transaction Does Not Exist", 486: "Busy Here", 487: "Request Terminated",
+	488: "Not Acceptable Here", 491: "Request Pending",
+	500: "Server Internal Error", 501: "Not Implemented", 503: "Service Unavailable", 603: "Decline",
+}
+
+// StatusText returns the usual reason phrase of a status code
+func StatusText(code int) string {
+	if text, ok := statusTexts[code]; ok {
+		return text
+	}
+	return "Unknown"
+}
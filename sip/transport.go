@@ -0,0 +1,571 @@
+package sip
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// timers of RFC 3261
+const (
+	T1 = 500 * time.Millisecond
+	T2 = 4 * time.Second
+	// transactions time out after 64*T1
+	transactionTimeout = 64 * T1
+)
+
+// Handler gets the requests a stack receives, one at a time, but for the
+// retransmissions and the ACKs of non-2xx responses, which the stack takes
+// care of. It answers them with Respond.
+type Handler func(req *Message)
+
+// Stack sends and receives SIP messages over UDP, and TCP on the same port
+type Stack struct {
+	host    string
+	udp     *net.UDPConn
+	tcp     net.Listener
+	handler Handler
+	queue   chan *Message
+	done    chan struct{}
+
+	mutex sync.Mutex
+	conns map[string]*tcpConn
+	// client transactions, by branch and method
+	clients map[string]*clientTx
+	// last responses of server transactions, by branch and method
+	servers map[string]*Message
+	// ACKs sent for 2xx responses, and 2xx responses waiting for an ACK,
+	// by Call-ID and CSeq
+	acks    map[string]*outgoing
+	pending map[string]chan struct{}
+	closed  bool
+}
+
+// outgoing is a message along with where it goes
+type outgoing struct {
+	m         *Message
+	dest      string
+	transport string
+}
+
+type tcpConn struct {
+	net.Conn
+	mutex sync.Mutex
+}
+
+type clientTx struct {
+	outgoing
+	responses chan *Message
+	// provisional is closed by the first response, final by the final one
+	provisional chan struct{}
+	final       chan struct{}
+	once        sync.Once
+}
+
+// Listen starts a stack on host (all addresses if empty) and port (a
+// random one if 0), handing the requests it gets to handler
+func Listen(host string, port int, handler Handler) (*Stack, error) {
+	ip := net.ParseIP(host)
+	if host != "" && ip == nil {
+		return nil, fmt.Errorf("invalid address %s", host)
+	}
+	var udp *net.UDPConn
+	var tcp net.Listener
+	var err error
+	// with a random port, try a few until TCP can have the same one
+	for i := 0; i < 10; i++ {
+		if udp, err = net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port}); err != nil {
+			return nil, err
+		}
+		tcp, err = net.ListenTCP("tcp", &net.TCPAddr{IP: ip, Port: udp.LocalAddr().(*net.UDPAddr).Port})
+		if err == nil {
+			break
+		}
+		udp.Close()
+		if port != 0 {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &Stack{
+		host:    host,
+		udp:     udp,
+		tcp:     tcp,
+		handler: handler,
+		queue:   make(chan *Message, 100),
+		done:    make(chan struct{}),
+		conns:   make(map[string]*tcpConn),
+		clients: make(map[string]*clientTx),
+		servers: make(map[string]*Message),
+		acks:    make(map[string]*outgoing),
+		pending: make(map[string]chan struct{}),
+	}
+	go s.readUDP()
+	go s.acceptTCP()
+	go s.handle()
+	return s, nil
+}
+
+// Port returns the port the stack listens on
+func (s *Stack) Port() int {
+	return s.udp.LocalAddr().(*net.UDPAddr).Port
+}
+
+// LocalIP returns the address of the stack as seen from dest (host:port)
+func (s *Stack) LocalIP(dest string) string {
+	if s.host != "" && !net.ParseIP(s.host).IsUnspecified() {
+		return s.host
+	}
+	if c, err := net.Dial("udp", dest); err == nil {
+		defer c.Close()
+		return c.LocalAddr().(*net.UDPAddr).IP.String()
+	}
+	return "127.0.0.1"
+}
+
+// Close stops the stack: pending transactions get no more responses
+func (s *Stack) Close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	conns := s.conns
+	s.conns = make(map[string]*tcpConn)
+	s.mutex.Unlock()
+	s.udp.Close()
+	s.tcp.Close()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+func (s *Stack) readUDP() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.udp.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			log.Warnf("SIP: error reading from UDP: %v", err)
+			continue
+		}
+		if n <= 4 {
+			// keep-alive
+			continue
+		}
+		m, err := Parse(buf[:n])
+		if err != nil {
+			log.Warnf("SIP: invalid message from %s: %v", addr, err)
+			continue
+		}
+		m.Source, m.Transport = addr.String(), "UDP"
+		s.received(m)
+	}
+}
+
+func (s *Stack) acceptTCP() {
+	for {
+		c, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		s.addConn(c)
+	}
+}
+
+func (s *Stack) addConn(c net.Conn) *tcpConn {
+	tc := &tcpConn{Conn: c}
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		c.Close()
+		return nil
+	}
+	s.conns[c.RemoteAddr().String()] = tc
+	s.mutex.Unlock()
+	go s.readTCP(tc)
+	return tc
+}
+
+func (s *Stack) readTCP(c *tcpConn) {
+	defer func() {
+		c.Close()
+		s.mutex.Lock()
+		if s.conns[c.RemoteAddr().String()] == c {
+			delete(s.conns, c.RemoteAddr().String())
+		}
+		s.mutex.Unlock()
+	}()
+	r := bufio.NewReader(c)
+	for {
+		m, err := ReadMessage(r)
+		if err != nil {
+			return
+		}
+		m.Source, m.Transport = c.RemoteAddr().String(), "TCP"
+		s.received(m)
+	}
+}
+
+// Send sends a message to dest (host:port), over transport (UDP or TCP)
+func (s *Stack) Send(m *Message, dest, transport string) error {
+	data := m.Bytes()
+	if !strings.EqualFold(transport, "TCP") {
+		addr, err := net.ResolveUDPAddr("udp", dest)
+		if err != nil {
+			return err
+		}
+		_, err = s.udp.WriteToUDP(data, addr)
+		return err
+	}
+	s.mutex.Lock()
+	c := s.conns[dest]
+	s.mutex.Unlock()
+	if c == nil {
+		conn, err := net.DialTimeout("tcp", dest, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		if c = s.addConn(conn); c == nil {
+			return errors.New("stack closed")
+		}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.Write(data)
+	return err
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewTag returns a random tag for From or To
+func NewTag() string {
+	return randomHex(6)
+}
+
+// NewCallID returns a random Call-ID
+func NewCallID() string {
+	return randomHex(12)
+}
+
+// Via returns a new top Via for requests to dest, with a new branch
+func (s *Stack) Via(dest, transport string) string {
+	return fmt.Sprintf("SIP/2.0/%s %s;branch=z9hG4bK%s;rport",
+		strings.ToUpper(transport), net.JoinHostPort(s.LocalIP(dest), strconv.Itoa(s.Port())), randomHex(8))
+}
+
+// Request starts a client transaction: a Via is added to req, which is sent
+// to dest over transport (retransmitted over UDP), and the responses come
+// through the channel, which is closed after the final one. Timeouts and
+// transport errors get a 408 or a 503 made up by the stack.
+func (s *Stack) Request(req *Message, dest, transport string) (<-chan *Message, error) {
+	if strings.EqualFold(transport, "TCP") {
+		transport = "TCP"
+	} else {
+		transport = "UDP"
+	}
+	if req.Method != "CANCEL" {
+		req.Headers = append([]Header{{"Via", s.Via(dest, transport)}}, req.Headers...)
+	}
+	tx := &clientTx{
+		outgoing:    outgoing{m: req, dest: dest, transport: transport},
+		responses:   make(chan *Message, 10),
+		provisional: make(chan struct{}),
+		final:       make(chan struct{}),
+	}
+	key := req.Branch() + " " + req.Method
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil, errors.New("stack closed")
+	}
+	s.clients[key] = tx
+	s.mutex.Unlock()
+	if err := s.Send(req, dest, transport); err != nil {
+		s.mutex.Lock()
+		delete(s.clients, key)
+		s.mutex.Unlock()
+		return nil, err
+	}
+	go s.run(tx, key)
+	return tx.responses, nil
+}
+
+// run retransmits a request until it gets a response, and times it out
+func (s *Stack) run(tx *clientTx, key string) {
+	timeout := time.NewTimer(transactionTimeout)
+	defer timeout.Stop()
+	interval := T1
+	retransmit := time.NewTimer(interval)
+	defer retransmit.Stop()
+	if tx.transport == "TCP" {
+		retransmit.Stop()
+	}
+	provisional := tx.provisional
+	for {
+		select {
+		case <-tx.final:
+			// keep absorbing retransmitted responses for a while
+			time.AfterFunc(transactionTimeout, func() {
+				s.mutex.Lock()
+				if s.clients[key] == tx {
+					delete(s.clients, key)
+				}
+				s.mutex.Unlock()
+			})
+			return
+		case <-provisional:
+			provisional = nil
+			if tx.m.Method == "INVITE" {
+				retransmit.Stop()
+			}
+		case <-retransmit.C:
+			s.Send(tx.m, tx.dest, tx.transport)
+			if interval *= 2; interval > T2 || (provisional == nil && tx.m.Method != "INVITE") {
+				interval = T2
+			}
+			retransmit.Reset(interval)
+		case <-timeout.C:
+			log.Warnf("SIP: %s to %s timed out", tx.m.Method, tx.dest)
+			s.respond(tx, NewResponse(tx.m, 408, "", ""))
+		case <-s.done:
+			s.respond(tx, NewResponse(tx.m, 503, "Stack closed", ""))
+		}
+	}
+}
+
+// respond passes a response to the user of a transaction
+func (s *Stack) respond(tx *clientTx, res *Message) {
+	if res.StatusCode < 200 {
+		tx.once.Do(func() { close(tx.provisional) })
+		select {
+		case <-tx.final:
+		default:
+			select {
+			case tx.responses <- res:
+			default:
+			}
+		}
+		return
+	}
+	select {
+	case <-tx.final:
+		if tx.m.Method == "INVITE" && res.StatusCode >= 300 {
+			// our ACK got lost
+			s.Send(s.ack(tx.m, res), tx.dest, tx.transport)
+		}
+		return
+	default:
+	}
+	tx.once.Do(func() { close(tx.provisional) })
+	if tx.m.Method == "INVITE" && res.StatusCode >= 300 {
+		s.Send(s.ack(tx.m, res), tx.dest, tx.transport)
+	}
+	tx.responses <- res
+	close(tx.final)
+	close(tx.responses)
+}
+
+// ack builds the ACK of a non-2xx response to an INVITE, which is part of
+// the transaction
+func (s *Stack) ack(invite, res *Message) *Message {
+	n, _ := invite.CSeq()
+	ack := &Message{Method: "ACK", URI: invite.URI}
+	ack.Add("Via", invite.Values("Via")[0])
+	for _, h := range invite.Headers {
+		switch canonical(h.Name) {
+		case "from", "call-id", "route", "max-forwards":
+			ack.Add(h.Name, h.Value)
+		}
+	}
+	ack.Add("To", res.Get("To"))
+	ack.Add("CSeq", fmt.Sprintf("%d ACK", n))
+	return ack
+}
+
+// Cancel cancels an INVITE sent with Request, which should then get a 487
+func (s *Stack) Cancel(invite *Message) (<-chan *Message, error) {
+	s.mutex.Lock()
+	tx := s.clients[invite.Branch()+" INVITE"]
+	s.mutex.Unlock()
+	if tx == nil {
+		return nil, errors.New("no such transaction")
+	}
+	n, _ := invite.CSeq()
+	cancel := &Message{Method: "CANCEL", URI: invite.URI}
+	for _, h := range invite.Headers {
+		switch canonical(h.Name) {
+		case "via", "from", "to", "call-id", "route", "max-forwards":
+			cancel.Add(h.Name, h.Value)
+		}
+	}
+	cancel.Add("CSeq", fmt.Sprintf("%d CANCEL", n))
+	return s.Request(cancel, tx.dest, tx.transport)
+}
+
+// Ack sends the ACK of a 2xx response, and sends it again if the response
+// is retransmitted
+func (s *Stack) Ack(ack *Message, dest, transport string) error {
+	ack.Headers = append([]Header{{"Via", s.Via(dest, transport)}}, ack.Headers...)
+	n, _ := ack.CSeq()
+	key := ack.CallID() + " " + strconv.Itoa(n)
+	s.mutex.Lock()
+	s.acks[key] = &outgoing{ack, dest, transport}
+	s.mutex.Unlock()
+	time.AfterFunc(transactionTimeout, func() {
+		s.mutex.Lock()
+		delete(s.acks, key)
+		s.mutex.Unlock()
+	})
+	return s.Send(ack, dest, transport)
+}
+
+// Respond sends a response to a request received by the stack; final
+// responses to INVITEs are retransmitted over UDP until the ACK comes
+func (s *Stack) Respond(req, res *Message) error {
+	key := req.Branch() + " " + req.Method
+	s.mutex.Lock()
+	s.servers[key] = res
+	s.mutex.Unlock()
+	if res.StatusCode >= 200 {
+		time.AfterFunc(transactionTimeout, func() {
+			s.mutex.Lock()
+			if s.servers[key] == res {
+				delete(s.servers, key)
+			}
+			s.mutex.Unlock()
+		})
+	}
+	if err := s.Send(res, req.Source, req.Transport); err != nil {
+		return err
+	}
+	if req.Method == "INVITE" && res.StatusCode >= 200 && req.Transport == "UDP" {
+		n, _ := req.CSeq()
+		acked := make(chan struct{})
+		ackKey := req.CallID() + " " + strconv.Itoa(n)
+		s.mutex.Lock()
+		s.pending[ackKey] = acked
+		s.mutex.Unlock()
+		go s.retransmit(res, req.Source, ackKey, acked)
+	}
+	return nil
+}
+
+// retransmit sends a final response to an INVITE again until it's acked
+func (s *Stack) retransmit(res *Message, dest, key string, acked chan struct{}) {
+	defer func() {
+		s.mutex.Lock()
+		if s.pending[key] == acked {
+			delete(s.pending, key)
+		}
+		s.mutex.Unlock()
+	}()
+	timeout := time.After(transactionTimeout)
+	for interval := T1; ; {
+		select {
+		case <-acked:
+			return
+		case <-s.done:
+			return
+		case <-timeout:
+			log.Warnf("SIP: no ACK for the %d response of %s", res.StatusCode, key)
+			return
+		case <-time.After(interval):
+			s.Send(res, dest, "UDP")
+			if interval *= 2; interval > T2 {
+				interval = T2
+			}
+		}
+	}
+}
+
+// received dispatches a message: responses to their transaction, requests
+// to the handler (unless they're retransmissions)
+func (s *Stack) received(m *Message) {
+	if !m.IsRequest() {
+		_, method := m.CSeq()
+		s.mutex.Lock()
+		tx := s.clients[m.Branch()+" "+method]
+		var ack *outgoing
+		if tx == nil && method == "INVITE" && m.StatusCode < 300 {
+			n, _ := m.CSeq()
+			ack = s.acks[m.CallID()+" "+strconv.Itoa(n)]
+		}
+		s.mutex.Unlock()
+		switch {
+		case tx != nil:
+			s.respond(tx, m)
+		case ack != nil:
+			s.Send(ack.m, ack.dest, ack.transport)
+		}
+		return
+	}
+	if m.Method == "ACK" {
+		n, _ := m.CSeq()
+		key := m.CallID() + " " + strconv.Itoa(n)
+		s.mutex.Lock()
+		acked := s.pending[key]
+		delete(s.pending, key)
+		last := s.servers[m.Branch()+" INVITE"]
+		s.mutex.Unlock()
+		if acked != nil {
+			close(acked)
+		}
+		if last != nil && last.StatusCode >= 300 {
+			// the ACK of a failure is part of the transaction
+			return
+		}
+	} else {
+		key := m.Branch() + " " + m.Method
+		s.mutex.Lock()
+		last, seen := s.servers[key]
+		if !seen {
+			// no response yet, but the request is being handled
+			s.servers[key] = nil
+		}
+		s.mutex.Unlock()
+		if seen {
+			if last != nil {
+				s.Send(last, m.Source, m.Transport)
+			}
+			return
+		}
+	}
+	select {
+	case s.queue <- m:
+	case <-s.done:
+	}
+}
+
+// handle hands the requests to the handler, one at a time
+func (s *Stack) handle() {
+	for {
+		select {
+		case m := <-s.queue:
+			s.handler(m)
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package sip
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// URI is a sip: or sips: URI; Params keeps the parameters as written,
+// with their leading ';'
+type URI struct {
+	Scheme string
+	User   string
+	Host   string
+	Port   int // 0 if not set
+	Params string
+}
+
+// ParseURI parses a SIP URI, ignoring its headers (what follows '?')
+func ParseURI(s string) (*URI, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid SIP URI %q", s)
+	}
+	u := &URI{Scheme: strings.ToLower(s[:i])}
+	if u.Scheme != "sip" && u.Scheme != "sips" {
+		return nil, fmt.Errorf("invalid SIP URI %q (not sip: or sips:)", s)
+	}
+	rest := s[i+1:]
+	if i := strings.IndexByte(rest, '?'); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, ';'); i >= 0 {
+		rest, u.Params = rest[:i], rest[i:]
+	}
+	if i := strings.LastIndexByte(rest, '@'); i >= 0 {
+		u.User, rest = rest[:i], rest[i+1:]
+		// no passwords in URIs
+		if j := strings.IndexByte(u.User, ':'); j >= 0 {
+			u.User = u.User[:j]
+		}
+	}
+	host, port, err := net.SplitHostPort(rest)
+	if err != nil {
+		host = strings.Trim(rest, "[]")
+	} else if u.Port, err = strconv.Atoi(port); err != nil || u.Port <= 0 || u.Port > 65535 {
+		return nil, fmt.Errorf("invalid port in SIP URI %q", s)
+	}
+	if host == "" {
+		return nil, fmt.Errorf("invalid SIP URI %q (no host)", s)
+	}
+	u.Host = host
+	return u, nil
+}
+
+func (u *URI) String() string {
+	s := u.Scheme + ":"
+	if u.User != "" {
+		s += u.User + "@"
+	}
+	host := u.Host
+	if strings.IndexByte(host, ':') >= 0 {
+		host = "[" + host + "]"
+	}
+	s += host
+	if u.Port != 0 {
+		s += ":" + strconv.Itoa(u.Port)
+	}
+	return s + u.Params
+}
+
+// Param returns a parameter of the URI, e.g. transport
+func (u *URI) Param(name string) string {
+	return Param(u.Params, name)
+}
+
+// Address returns host:port, with the default port if not set
+func (u *URI) Address() string {
+	port := u.Port
+	if port == 0 {
+		port = 5060
+		if u.Scheme == "sips" {
+			port = 5061
+		}
+	}
+	return net.JoinHostPort(u.Host, strconv.Itoa(port))
+}
+
+// Address is a name-addr, as in From, To or Contact: Params are the header
+// parameters (e.g. the tag), with their leading ';'
+type Address struct {
+	Display string
+	URI     *URI
+	Params  string
+}
+
+// ParseAddress parses a header value like "Bob" <sip:bob@example.com>;tag=1
+func ParseAddress(s string) (*Address, error) {
+	s = strings.TrimSpace(s)
+	a := &Address{}
+	if i := strings.IndexByte(s, '<'); i >= 0 {
+		j := strings.IndexByte(s[i:], '>')
+		if j < 0 {
+			return nil, fmt.Errorf("invalid address %q", s)
+		}
+		a.Display = strings.Trim(strings.TrimSpace(s[:i]), "\"")
+		uri, err := ParseURI(s[i+1 : i+j])
+		if err != nil {
+			return nil, err
+		}
+		a.URI, a.Params = uri, strings.TrimSpace(s[i+j+1:])
+		return a, nil
+	}
+	// without <>, the parameters belong to the header
+	uri := s
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		uri, a.Params = s[:i], s[i:]
+	}
+	u, err := ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	a.URI = u
+	return a, nil
+}
+
+func (a *Address) String() string {
+	s := "<" + a.URI.String() + ">" + a.Params
+	if a.Display != "" {
+		s = strconv.Quote(a.Display) + " " + s
+	}
+	return s
+}
+
+// Tag returns the tag of the address, if any
+func (a *Address) Tag() string {
+	return Param(a.Params, "tag")
+}
@@ -0,0 +1,262 @@
+package grpcapi
+
+// gRPC transport for the Janus API and the Admin API, as described in
+// pb/janus.proto: typed requests are turned into their JSON counterparts
+// and passed to the core, and the JSON responses are turned into Envelopes.
+// Events are queued per session and streamed by the Events call, which also
+// keeps the session alive while the stream is open. A session has one event
+// stream at a time, as it has one queue.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/session"
+	"github.com/xroger88/go-janus/transports"
+	"github.com/xroger88/go-janus/transports/grpcapi/pb"
+	"github.com/xroger88/go-janus/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const Package = "janus.transport.grpc"
+
+// Config is the content of janus.transport.grpc.yaml in the configs folder
+type Config struct {
+	General struct {
+		Enabled   bool
+		Port      int
+		Interface string
+		Secure    bool
+	}
+	Admin struct {
+		Admin_enabled   bool
+		Admin_port      int
+		Admin_interface string
+		Admin_secure    bool
+		Admin_client_ca string
+	}
+	Certificates config.TLS
+}
+
+type Transport struct {
+	config  Config
+	source  *session.Source
+	servers []*grpc.Server
+	done    chan struct{}
+
+	*transports.SessionQueues
+
+	mutex   sync.Mutex
+	streams map[uint64]bool // sessions with an open event stream
+}
+
+func init() {
+	transports.Register(New())
+}
+
+// New returns the gRPC transport, to be started with Init
+func New() *Transport {
+	t := &Transport{
+		done:          make(chan struct{}),
+		SessionQueues: transports.NewSessionQueues(Package),
+		streams:       make(map[uint64]bool),
+	}
+	t.source = &session.Source{Transport: t}
+	return t
+}
+
+func (t *Transport) Package() string { return Package }
+func (t *Transport) Name() string    { return "JANUS gRPC transport plugin" }
+func (t *Transport) Description() string {
+	return "This transport plugin adds gRPC support to the Janus API."
+}
+func (t *Transport) Author() string        { return api.Author }
+func (t *Transport) Version() int          { return api.Version }
+func (t *Transport) VersionString() string { return api.VersionString }
+
+func (t *Transport) IsJanusAPIEnabled() bool { return t.config.General.Enabled }
+func (t *Transport) IsAdminAPIEnabled() bool { return t.config.Admin.Admin_enabled }
+
+// Init reads the configuration and starts the gRPC servers
+func (t *Transport) Init(configPath string) error {
+	c := &t.config
+	c.General.Port = 9188
+	c.Admin.Admin_port = 7198
+	if err := config.LoadComponent(configPath, Package, c); err != nil {
+		return err
+	}
+	if !c.General.Enabled && !c.Admin.Admin_enabled {
+		return transports.ErrNotEnabled
+	}
+	if c.General.Enabled && c.Admin.Admin_enabled && c.General.Port == c.Admin.Admin_port && c.General.Interface == c.Admin.Admin_interface {
+		// one server can have both services, but not two TLS settings
+		if c.General.Secure != c.Admin.Admin_secure {
+			return fmt.Errorf("the Janus and Admin APIs share port %d, so they must be both secure or not", c.General.Port)
+		}
+		return t.serve(c.General.Interface, c.General.Port, c.General.Secure, c.Admin.Admin_client_ca, true, true)
+	}
+	if c.General.Enabled {
+		if err := t.serve(c.General.Interface, c.General.Port, c.General.Secure, "", true, false); err != nil {
+			return err
+		}
+	}
+	if c.Admin.Admin_enabled {
+		if err := t.serve(c.Admin.Admin_interface, c.Admin.Admin_port, c.Admin.Admin_secure, c.Admin.Admin_client_ca, false, true); err != nil {
+			t.Destroy()
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Transport) serve(ip string, port int, secure bool, clientCA string, janus, admin bool) error {
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+	var options []grpc.ServerOption
+	if secure {
+		tlsConfig, err := t.config.Certificates.ServerConfig(clientCA)
+		if err != nil {
+			return err
+		}
+		tlsConfig.NextProtos = []string{"h2"}
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer(options...)
+	if janus {
+		pb.RegisterJanusServer(server, &janusServer{t: t})
+	}
+	if admin {
+		pb.RegisterAdminServer(server, &adminServer{t: t})
+	}
+	t.servers = append(t.servers, server)
+	go server.Serve(listener)
+	log.Infof("gRPC server started (%s, Janus API: %v, Admin API: %v, TLS: %v)", addr, janus, admin, secure)
+	return nil
+}
+
+// Destroy ends the event streams and stops the servers
+func (t *Transport) Destroy() {
+	select {
+	case <-t.done:
+		return
+	default:
+		close(t.done)
+	}
+	for _, server := range t.servers {
+		server.GracefulStop()
+	}
+	log.Infof("%s destroyed", Package)
+}
+
+// SendMessage gets responses and events from the core: responses go back to
+// the call waiting for them, events to the queue of their session
+func (t *Transport) SendMessage(instance interface{}, requestID interface{}, admin bool, message map[string]interface{}) error {
+	if response, ok := requestID.(chan map[string]interface{}); ok {
+		response <- message
+		return nil
+	}
+	return t.Push(message)
+}
+
+// subscribe marks the session as having an event stream, unless it already has one
+func (t *Transport) subscribe(sessionID uint64) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.streams[sessionID] {
+		return false
+	}
+	t.streams[sessionID] = true
+	return true
+}
+
+func (t *Transport) unsubscribe(sessionID uint64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.streams, sessionID)
+}
+
+// request passes a request to the core and returns the response
+func (t *Transport) request(admin bool, data []byte) *pb.Envelope {
+	response := make(chan map[string]interface{}, 1)
+	api.IncomingRequest(t.source, response, admin, data)
+	select {
+	case message := <-response:
+		return envelope(message)
+	default:
+		return envelope(api.ErrorResponse(0, "", api.ErrorUnknown, "No response from the core"))
+	}
+}
+
+// envelope turns a response or an event into its protobuf counterpart
+func envelope(message map[string]interface{}) *pb.Envelope {
+	data, _ := json.Marshal(message)
+	var m struct {
+		Janus       string          `json:"janus"`
+		Transaction string          `json:"transaction"`
+		SessionID   uint64          `json:"session_id"`
+		Sender      uint64          `json:"sender"`
+		Data        json.RawMessage `json:"data"`
+		Hint        string          `json:"hint"`
+		Reason      string          `json:"reason"`
+		Sessions    []uint64        `json:"sessions"`
+		Handles     []uint64        `json:"handles"`
+		Error       *struct {
+			Code   int32  `json:"code"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+		Plugindata *struct {
+			Plugin string          `json:"plugin"`
+			Data   json.RawMessage `json:"data"`
+		} `json:"plugindata"`
+		Jsep *struct {
+			Type    string `json:"type"`
+			SDP     string `json:"sdp"`
+			Trickle *bool  `json:"trickle"`
+		} `json:"jsep"`
+	}
+	json.Unmarshal(data, &m)
+	e := &pb.Envelope{
+		Janus:       m.Janus,
+		Transaction: m.Transaction,
+		SessionId:   m.SessionID,
+		Sender:      m.Sender,
+		Hint:        m.Hint,
+		Reason:      m.Reason,
+		Sessions:    m.Sessions,
+		Handles:     m.Handles,
+		Json:        string(data),
+	}
+	var created struct {
+		ID uint64 `json:"id"`
+	}
+	if json.Unmarshal(m.Data, &created) == nil {
+		e.Id = created.ID
+	}
+	if m.Error != nil {
+		e.Error = &pb.Error{Code: m.Error.Code, Reason: m.Error.Reason}
+	}
+	if m.Jsep != nil {
+		e.Jsep = &pb.Jsep{Type: m.Jsep.Type, Sdp: m.Jsep.SDP, Trickle: m.Jsep.Trickle}
+	}
+	if m.Plugindata != nil {
+		e.Plugindata = &pb.PluginData{Plugin: m.Plugindata.Plugin, Data: string(m.Plugindata.Data)}
+	}
+	return e
+}
+
+func transaction(t string) string {
+	if t == "" {
+		return strconv.FormatUint(util.RandomUint64(), 36)
+	}
+	return t
+}
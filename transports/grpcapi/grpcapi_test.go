@@ -0,0 +1,94 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/transports/grpcapi/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// startTransport runs the Janus API on a free local port and returns a client
+func startTransport(t *testing.T) (*Transport, pb.JanusClient) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	dir := t.TempDir()
+	conf := fmt.Sprintf("general:\n  enabled: yes\n  port: %d\n  interface: 127.0.0.1\n", port)
+	if err := ioutil.WriteFile(filepath.Join(dir, Package+".yaml"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr := New()
+	if err := tr.Init(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tr.Destroy)
+	conn, err := grpc.NewClient(fmt.Sprintf("127.0.0.1:%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return tr, pb.NewJanusClient(conn)
+}
+
+// waitStream waits for the session to have an event stream, or not to
+func waitStream(t *testing.T, tr *Transport, sessionID uint64, open bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		tr.mutex.Lock()
+		subscribed := tr.streams[sessionID]
+		tr.mutex.Unlock()
+		if subscribed == open {
+			return
+		}
+	}
+	t.Fatalf("the event stream of session %d never got open=%v", sessionID, open)
+}
+
+// A session has one event stream at a time, and another one can be opened
+// once it's gone
+func TestOneEventStreamPerSession(t *testing.T) {
+	tr, client := startTransport(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	created, err := client.Create(ctx, &pb.CreateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Id == 0 {
+		t.Fatalf("create failed: %v", created)
+	}
+	defer client.Destroy(ctx, &pb.SessionRequest{SessionId: created.Id})
+
+	first, cancelFirst := context.WithCancel(ctx)
+	if _, err := client.Events(first, &pb.SessionRequest{SessionId: created.Id}); err != nil {
+		t.Fatal(err)
+	}
+	waitStream(t, tr, created.Id, true)
+
+	second, err := client.Events(ctx, &pb.SessionRequest{SessionId: created.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Recv(); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("got %v opening a second stream, want %v", err, codes.AlreadyExists)
+	}
+
+	cancelFirst()
+	waitStream(t, tr, created.Id, false)
+	if _, err := client.Events(ctx, &pb.SessionRequest{SessionId: created.Id}); err != nil {
+		t.Fatal(err)
+	}
+	waitStream(t, tr, created.Id, true)
+}
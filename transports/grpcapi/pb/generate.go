@@ -0,0 +1,6 @@
+package pb
+
+// The code of this package is generated from janus.proto, with protoc and
+// the protoc-gen-go and protoc-gen-go-grpc plugins
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative janus.proto
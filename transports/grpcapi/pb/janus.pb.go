@@ -0,0 +1,1412 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: janus.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Auth carries the api_secret and token, when required
+type Auth struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Apisecret     string                 `protobuf:"bytes,1,opt,name=apisecret,proto3" json:"apisecret,omitempty"`
+	Token         string                 `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Auth) Reset() {
+	*x = Auth{}
+	mi := &file_janus_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Auth) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Auth) ProtoMessage() {}
+
+func (x *Auth) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Auth.ProtoReflect.Descriptor instead.
+func (*Auth) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Auth) GetApisecret() string {
+	if x != nil {
+		return x.Apisecret
+	}
+	return ""
+}
+
+func (x *Auth) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type InfoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transaction   string                 `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InfoRequest) Reset() {
+	*x = InfoRequest{}
+	mi := &file_janus_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InfoRequest) ProtoMessage() {}
+
+func (x *InfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InfoRequest.ProtoReflect.Descriptor instead.
+func (*InfoRequest) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InfoRequest) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+type CreateRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Transaction string                 `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	// id is the session ID to use, if not to be picked by the server
+	Id            uint64 `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+	Auth          *Auth  `protobuf:"bytes,3,opt,name=auth,proto3" json:"auth,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateRequest) Reset() {
+	*x = CreateRequest{}
+	mi := &file_janus_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateRequest) ProtoMessage() {}
+
+func (x *CreateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateRequest.ProtoReflect.Descriptor instead.
+func (*CreateRequest) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CreateRequest) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+func (x *CreateRequest) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *CreateRequest) GetAuth() *Auth {
+	if x != nil {
+		return x.Auth
+	}
+	return nil
+}
+
+type SessionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transaction   string                 `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	SessionId     uint64                 `protobuf:"varint,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Auth          *Auth                  `protobuf:"bytes,3,opt,name=auth,proto3" json:"auth,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SessionRequest) Reset() {
+	*x = SessionRequest{}
+	mi := &file_janus_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionRequest) ProtoMessage() {}
+
+func (x *SessionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionRequest.ProtoReflect.Descriptor instead.
+func (*SessionRequest) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SessionRequest) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+func (x *SessionRequest) GetSessionId() uint64 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+func (x *SessionRequest) GetAuth() *Auth {
+	if x != nil {
+		return x.Auth
+	}
+	return nil
+}
+
+type AttachRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transaction   string                 `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	SessionId     uint64                 `protobuf:"varint,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Plugin        string                 `protobuf:"bytes,3,opt,name=plugin,proto3" json:"plugin,omitempty"`
+	OpaqueId      string                 `protobuf:"bytes,4,opt,name=opaque_id,json=opaqueId,proto3" json:"opaque_id,omitempty"`
+	LoopIndex     *int32                 `protobuf:"varint,5,opt,name=loop_index,json=loopIndex,proto3,oneof" json:"loop_index,omitempty"`
+	Auth          *Auth                  `protobuf:"bytes,6,opt,name=auth,proto3" json:"auth,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AttachRequest) Reset() {
+	*x = AttachRequest{}
+	mi := &file_janus_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AttachRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AttachRequest) ProtoMessage() {}
+
+func (x *AttachRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AttachRequest.ProtoReflect.Descriptor instead.
+func (*AttachRequest) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AttachRequest) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+func (x *AttachRequest) GetSessionId() uint64 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+func (x *AttachRequest) GetPlugin() string {
+	if x != nil {
+		return x.Plugin
+	}
+	return ""
+}
+
+func (x *AttachRequest) GetOpaqueId() string {
+	if x != nil {
+		return x.OpaqueId
+	}
+	return ""
+}
+
+func (x *AttachRequest) GetLoopIndex() int32 {
+	if x != nil && x.LoopIndex != nil {
+		return *x.LoopIndex
+	}
+	return 0
+}
+
+func (x *AttachRequest) GetAuth() *Auth {
+	if x != nil {
+		return x.Auth
+	}
+	return nil
+}
+
+type HandleRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transaction   string                 `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	SessionId     uint64                 `protobuf:"varint,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	HandleId      uint64                 `protobuf:"varint,3,opt,name=handle_id,json=handleId,proto3" json:"handle_id,omitempty"`
+	Auth          *Auth                  `protobuf:"bytes,4,opt,name=auth,proto3" json:"auth,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HandleRequest) Reset() {
+	*x = HandleRequest{}
+	mi := &file_janus_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HandleRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HandleRequest) ProtoMessage() {}
+
+func (x *HandleRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HandleRequest.ProtoReflect.Descriptor instead.
+func (*HandleRequest) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *HandleRequest) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+func (x *HandleRequest) GetSessionId() uint64 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+func (x *HandleRequest) GetHandleId() uint64 {
+	if x != nil {
+		return x.HandleId
+	}
+	return 0
+}
+
+func (x *HandleRequest) GetAuth() *Auth {
+	if x != nil {
+		return x.Auth
+	}
+	return nil
+}
+
+type Jsep struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Sdp           string                 `protobuf:"bytes,2,opt,name=sdp,proto3" json:"sdp,omitempty"`
+	Trickle       *bool                  `protobuf:"varint,3,opt,name=trickle,proto3,oneof" json:"trickle,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Jsep) Reset() {
+	*x = Jsep{}
+	mi := &file_janus_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Jsep) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Jsep) ProtoMessage() {}
+
+func (x *Jsep) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Jsep.ProtoReflect.Descriptor instead.
+func (*Jsep) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Jsep) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Jsep) GetSdp() string {
+	if x != nil {
+		return x.Sdp
+	}
+	return ""
+}
+
+func (x *Jsep) GetTrickle() bool {
+	if x != nil && x.Trickle != nil {
+		return *x.Trickle
+	}
+	return false
+}
+
+type MessageRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Transaction string                 `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	SessionId   uint64                 `protobuf:"varint,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	HandleId    uint64                 `protobuf:"varint,3,opt,name=handle_id,json=handleId,proto3" json:"handle_id,omitempty"`
+	// body is the message for the plugin, as JSON
+	Body          string `protobuf:"bytes,4,opt,name=body,proto3" json:"body,omitempty"`
+	Jsep          *Jsep  `protobuf:"bytes,5,opt,name=jsep,proto3" json:"jsep,omitempty"`
+	Auth          *Auth  `protobuf:"bytes,6,opt,name=auth,proto3" json:"auth,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MessageRequest) Reset() {
+	*x = MessageRequest{}
+	mi := &file_janus_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MessageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MessageRequest) ProtoMessage() {}
+
+func (x *MessageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MessageRequest.ProtoReflect.Descriptor instead.
+func (*MessageRequest) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *MessageRequest) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+func (x *MessageRequest) GetSessionId() uint64 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+func (x *MessageRequest) GetHandleId() uint64 {
+	if x != nil {
+		return x.HandleId
+	}
+	return 0
+}
+
+func (x *MessageRequest) GetBody() string {
+	if x != nil {
+		return x.Body
+	}
+	return ""
+}
+
+func (x *MessageRequest) GetJsep() *Jsep {
+	if x != nil {
+		return x.Jsep
+	}
+	return nil
+}
+
+func (x *MessageRequest) GetAuth() *Auth {
+	if x != nil {
+		return x.Auth
+	}
+	return nil
+}
+
+type Candidate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SdpMid        string                 `protobuf:"bytes,1,opt,name=sdp_mid,json=sdpMid,proto3" json:"sdp_mid,omitempty"`
+	SdpMlineIndex int32                  `protobuf:"varint,2,opt,name=sdp_mline_index,json=sdpMlineIndex,proto3" json:"sdp_mline_index,omitempty"`
+	Candidate     string                 `protobuf:"bytes,3,opt,name=candidate,proto3" json:"candidate,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Candidate) Reset() {
+	*x = Candidate{}
+	mi := &file_janus_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Candidate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Candidate) ProtoMessage() {}
+
+func (x *Candidate) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Candidate.ProtoReflect.Descriptor instead.
+func (*Candidate) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Candidate) GetSdpMid() string {
+	if x != nil {
+		return x.SdpMid
+	}
+	return ""
+}
+
+func (x *Candidate) GetSdpMlineIndex() int32 {
+	if x != nil {
+		return x.SdpMlineIndex
+	}
+	return 0
+}
+
+func (x *Candidate) GetCandidate() string {
+	if x != nil {
+		return x.Candidate
+	}
+	return ""
+}
+
+type TrickleRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Transaction string                 `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	SessionId   uint64                 `protobuf:"varint,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	HandleId    uint64                 `protobuf:"varint,3,opt,name=handle_id,json=handleId,proto3" json:"handle_id,omitempty"`
+	// one of candidate, candidates or completed
+	Candidate     *Candidate   `protobuf:"bytes,4,opt,name=candidate,proto3" json:"candidate,omitempty"`
+	Candidates    []*Candidate `protobuf:"bytes,5,rep,name=candidates,proto3" json:"candidates,omitempty"`
+	Completed     bool         `protobuf:"varint,6,opt,name=completed,proto3" json:"completed,omitempty"`
+	Auth          *Auth        `protobuf:"bytes,7,opt,name=auth,proto3" json:"auth,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TrickleRequest) Reset() {
+	*x = TrickleRequest{}
+	mi := &file_janus_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrickleRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrickleRequest) ProtoMessage() {}
+
+func (x *TrickleRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrickleRequest.ProtoReflect.Descriptor instead.
+func (*TrickleRequest) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *TrickleRequest) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+func (x *TrickleRequest) GetSessionId() uint64 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+func (x *TrickleRequest) GetHandleId() uint64 {
+	if x != nil {
+		return x.HandleId
+	}
+	return 0
+}
+
+func (x *TrickleRequest) GetCandidate() *Candidate {
+	if x != nil {
+		return x.Candidate
+	}
+	return nil
+}
+
+func (x *TrickleRequest) GetCandidates() []*Candidate {
+	if x != nil {
+		return x.Candidates
+	}
+	return nil
+}
+
+func (x *TrickleRequest) GetCompleted() bool {
+	if x != nil {
+		return x.Completed
+	}
+	return false
+}
+
+func (x *TrickleRequest) GetAuth() *Auth {
+	if x != nil {
+		return x.Auth
+	}
+	return nil
+}
+
+type RawRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Json          string                 `protobuf:"bytes,1,opt,name=json,proto3" json:"json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RawRequest) Reset() {
+	*x = RawRequest{}
+	mi := &file_janus_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RawRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RawRequest) ProtoMessage() {}
+
+func (x *RawRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RawRequest.ProtoReflect.Descriptor instead.
+func (*RawRequest) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *RawRequest) GetJson() string {
+	if x != nil {
+		return x.Json
+	}
+	return ""
+}
+
+type AdminRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transaction   string                 `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	AdminSecret   string                 `protobuf:"bytes,2,opt,name=admin_secret,json=adminSecret,proto3" json:"admin_secret,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AdminRequest) Reset() {
+	*x = AdminRequest{}
+	mi := &file_janus_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AdminRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AdminRequest) ProtoMessage() {}
+
+func (x *AdminRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AdminRequest.ProtoReflect.Descriptor instead.
+func (*AdminRequest) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *AdminRequest) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+func (x *AdminRequest) GetAdminSecret() string {
+	if x != nil {
+		return x.AdminSecret
+	}
+	return ""
+}
+
+type AdminSessionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transaction   string                 `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	AdminSecret   string                 `protobuf:"bytes,2,opt,name=admin_secret,json=adminSecret,proto3" json:"admin_secret,omitempty"`
+	SessionId     uint64                 `protobuf:"varint,3,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AdminSessionRequest) Reset() {
+	*x = AdminSessionRequest{}
+	mi := &file_janus_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AdminSessionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AdminSessionRequest) ProtoMessage() {}
+
+func (x *AdminSessionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AdminSessionRequest.ProtoReflect.Descriptor instead.
+func (*AdminSessionRequest) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *AdminSessionRequest) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+func (x *AdminSessionRequest) GetAdminSecret() string {
+	if x != nil {
+		return x.AdminSecret
+	}
+	return ""
+}
+
+func (x *AdminSessionRequest) GetSessionId() uint64 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+type AdminHandleRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transaction   string                 `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	AdminSecret   string                 `protobuf:"bytes,2,opt,name=admin_secret,json=adminSecret,proto3" json:"admin_secret,omitempty"`
+	SessionId     uint64                 `protobuf:"varint,3,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	HandleId      uint64                 `protobuf:"varint,4,opt,name=handle_id,json=handleId,proto3" json:"handle_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AdminHandleRequest) Reset() {
+	*x = AdminHandleRequest{}
+	mi := &file_janus_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AdminHandleRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AdminHandleRequest) ProtoMessage() {}
+
+func (x *AdminHandleRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AdminHandleRequest.ProtoReflect.Descriptor instead.
+func (*AdminHandleRequest) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *AdminHandleRequest) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+func (x *AdminHandleRequest) GetAdminSecret() string {
+	if x != nil {
+		return x.AdminSecret
+	}
+	return ""
+}
+
+func (x *AdminHandleRequest) GetSessionId() uint64 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+func (x *AdminHandleRequest) GetHandleId() uint64 {
+	if x != nil {
+		return x.HandleId
+	}
+	return 0
+}
+
+type Error struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          int32                  `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Error) Reset() {
+	*x = Error{}
+	mi := &file_janus_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Error) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Error) ProtoMessage() {}
+
+func (x *Error) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Error.ProtoReflect.Descriptor instead.
+func (*Error) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *Error) GetCode() int32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *Error) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type PluginData struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Plugin string                 `protobuf:"bytes,1,opt,name=plugin,proto3" json:"plugin,omitempty"`
+	// data is what the plugin sent, as JSON
+	Data          string `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PluginData) Reset() {
+	*x = PluginData{}
+	mi := &file_janus_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PluginData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PluginData) ProtoMessage() {}
+
+func (x *PluginData) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PluginData.ProtoReflect.Descriptor instead.
+func (*PluginData) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *PluginData) GetPlugin() string {
+	if x != nil {
+		return x.Plugin
+	}
+	return ""
+}
+
+func (x *PluginData) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
+
+// Envelope is a response or an event
+type Envelope struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// janus is the kind of message: success, error, ack, event, webrtcup...
+	Janus       string `protobuf:"bytes,1,opt,name=janus,proto3" json:"janus,omitempty"`
+	Transaction string `protobuf:"bytes,2,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	SessionId   uint64 `protobuf:"varint,3,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Sender      uint64 `protobuf:"varint,4,opt,name=sender,proto3" json:"sender,omitempty"`
+	// id is the ID of the session or handle created, for create and attach
+	Id         uint64      `protobuf:"varint,5,opt,name=id,proto3" json:"id,omitempty"`
+	Error      *Error      `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	Plugindata *PluginData `protobuf:"bytes,7,opt,name=plugindata,proto3" json:"plugindata,omitempty"`
+	Jsep       *Jsep       `protobuf:"bytes,8,opt,name=jsep,proto3" json:"jsep,omitempty"`
+	Hint       string      `protobuf:"bytes,9,opt,name=hint,proto3" json:"hint,omitempty"`
+	Reason     string      `protobuf:"bytes,10,opt,name=reason,proto3" json:"reason,omitempty"`
+	// sessions and handles are listed by list_sessions and list_handles
+	Sessions []uint64 `protobuf:"varint,11,rep,packed,name=sessions,proto3" json:"sessions,omitempty"`
+	Handles  []uint64 `protobuf:"varint,12,rep,packed,name=handles,proto3" json:"handles,omitempty"`
+	// json is the whole message as JSON, for whatever is not mapped above
+	Json          string `protobuf:"bytes,15,opt,name=json,proto3" json:"json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Envelope) Reset() {
+	*x = Envelope{}
+	mi := &file_janus_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Envelope) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Envelope) ProtoMessage() {}
+
+func (x *Envelope) ProtoReflect() protoreflect.Message {
+	mi := &file_janus_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Envelope.ProtoReflect.Descriptor instead.
+func (*Envelope) Descriptor() ([]byte, []int) {
+	return file_janus_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *Envelope) GetJanus() string {
+	if x != nil {
+		return x.Janus
+	}
+	return ""
+}
+
+func (x *Envelope) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+func (x *Envelope) GetSessionId() uint64 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+func (x *Envelope) GetSender() uint64 {
+	if x != nil {
+		return x.Sender
+	}
+	return 0
+}
+
+func (x *Envelope) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Envelope) GetError() *Error {
+	if x != nil {
+		return x.Error
+	}
+	return nil
+}
+
+func (x *Envelope) GetPlugindata() *PluginData {
+	if x != nil {
+		return x.Plugindata
+	}
+	return nil
+}
+
+func (x *Envelope) GetJsep() *Jsep {
+	if x != nil {
+		return x.Jsep
+	}
+	return nil
+}
+
+func (x *Envelope) GetHint() string {
+	if x != nil {
+		return x.Hint
+	}
+	return ""
+}
+
+func (x *Envelope) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Envelope) GetSessions() []uint64 {
+	if x != nil {
+		return x.Sessions
+	}
+	return nil
+}
+
+func (x *Envelope) GetHandles() []uint64 {
+	if x != nil {
+		return x.Handles
+	}
+	return nil
+}
+
+func (x *Envelope) GetJson() string {
+	if x != nil {
+		return x.Json
+	}
+	return ""
+}
+
+var File_janus_proto protoreflect.FileDescriptor
+
+const file_janus_proto_rawDesc = "" +
+	"\n" +
+	"\vjanus.proto\x12\bjanus.v1\":\n" +
+	"\x04Auth\x12\x1c\n" +
+	"\tapisecret\x18\x01 \x01(\tR\tapisecret\x12\x14\n" +
+	"\x05token\x18\x02 \x01(\tR\x05token\"/\n" +
+	"\vInfoRequest\x12 \n" +
+	"\vtransaction\x18\x01 \x01(\tR\vtransaction\"e\n" +
+	"\rCreateRequest\x12 \n" +
+	"\vtransaction\x18\x01 \x01(\tR\vtransaction\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\x04R\x02id\x12\"\n" +
+	"\x04auth\x18\x03 \x01(\v2\x0e.janus.v1.AuthR\x04auth\"u\n" +
+	"\x0eSessionRequest\x12 \n" +
+	"\vtransaction\x18\x01 \x01(\tR\vtransaction\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x02 \x01(\x04R\tsessionId\x12\"\n" +
+	"\x04auth\x18\x03 \x01(\v2\x0e.janus.v1.AuthR\x04auth\"\xdc\x01\n" +
+	"\rAttachRequest\x12 \n" +
+	"\vtransaction\x18\x01 \x01(\tR\vtransaction\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x02 \x01(\x04R\tsessionId\x12\x16\n" +
+	"\x06plugin\x18\x03 \x01(\tR\x06plugin\x12\x1b\n" +
+	"\topaque_id\x18\x04 \x01(\tR\bopaqueId\x12\"\n" +
+	"\n" +
+	"loop_index\x18\x05 \x01(\x05H\x00R\tloopIndex\x88\x01\x01\x12\"\n" +
+	"\x04auth\x18\x06 \x01(\v2\x0e.janus.v1.AuthR\x04authB\r\n" +
+	"\v_loop_index\"\x91\x01\n" +
+	"\rHandleRequest\x12 \n" +
+	"\vtransaction\x18\x01 \x01(\tR\vtransaction\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x02 \x01(\x04R\tsessionId\x12\x1b\n" +
+	"\thandle_id\x18\x03 \x01(\x04R\bhandleId\x12\"\n" +
+	"\x04auth\x18\x04 \x01(\v2\x0e.janus.v1.AuthR\x04auth\"W\n" +
+	"\x04Jsep\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x10\n" +
+	"\x03sdp\x18\x02 \x01(\tR\x03sdp\x12\x1d\n" +
+	"\atrickle\x18\x03 \x01(\bH\x00R\atrickle\x88\x01\x01B\n" +
+	"\n" +
+	"\b_trickle\"\xca\x01\n" +
+	"\x0eMessageRequest\x12 \n" +
+	"\vtransaction\x18\x01 \x01(\tR\vtransaction\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x02 \x01(\x04R\tsessionId\x12\x1b\n" +
+	"\thandle_id\x18\x03 \x01(\x04R\bhandleId\x12\x12\n" +
+	"\x04body\x18\x04 \x01(\tR\x04body\x12\"\n" +
+	"\x04jsep\x18\x05 \x01(\v2\x0e.janus.v1.JsepR\x04jsep\x12\"\n" +
+	"\x04auth\x18\x06 \x01(\v2\x0e.janus.v1.AuthR\x04auth\"j\n" +
+	"\tCandidate\x12\x17\n" +
+	"\asdp_mid\x18\x01 \x01(\tR\x06sdpMid\x12&\n" +
+	"\x0fsdp_mline_index\x18\x02 \x01(\x05R\rsdpMlineIndex\x12\x1c\n" +
+	"\tcandidate\x18\x03 \x01(\tR\tcandidate\"\x98\x02\n" +
+	"\x0eTrickleRequest\x12 \n" +
+	"\vtransaction\x18\x01 \x01(\tR\vtransaction\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x02 \x01(\x04R\tsessionId\x12\x1b\n" +
+	"\thandle_id\x18\x03 \x01(\x04R\bhandleId\x121\n" +
+	"\tcandidate\x18\x04 \x01(\v2\x13.janus.v1.CandidateR\tcandidate\x123\n" +
+	"\n" +
+	"candidates\x18\x05 \x03(\v2\x13.janus.v1.CandidateR\n" +
+	"candidates\x12\x1c\n" +
+	"\tcompleted\x18\x06 \x01(\bR\tcompleted\x12\"\n" +
+	"\x04auth\x18\a \x01(\v2\x0e.janus.v1.AuthR\x04auth\" \n" +
+	"\n" +
+	"RawRequest\x12\x12\n" +
+	"\x04json\x18\x01 \x01(\tR\x04json\"S\n" +
+	"\fAdminRequest\x12 \n" +
+	"\vtransaction\x18\x01 \x01(\tR\vtransaction\x12!\n" +
+	"\fadmin_secret\x18\x02 \x01(\tR\vadminSecret\"y\n" +
+	"\x13AdminSessionRequest\x12 \n" +
+	"\vtransaction\x18\x01 \x01(\tR\vtransaction\x12!\n" +
+	"\fadmin_secret\x18\x02 \x01(\tR\vadminSecret\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x03 \x01(\x04R\tsessionId\"\x95\x01\n" +
+	"\x12AdminHandleRequest\x12 \n" +
+	"\vtransaction\x18\x01 \x01(\tR\vtransaction\x12!\n" +
+	"\fadmin_secret\x18\x02 \x01(\tR\vadminSecret\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x03 \x01(\x04R\tsessionId\x12\x1b\n" +
+	"\thandle_id\x18\x04 \x01(\x04R\bhandleId\"3\n" +
+	"\x05Error\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\"8\n" +
+	"\n" +
+	"PluginData\x12\x16\n" +
+	"\x06plugin\x18\x01 \x01(\tR\x06plugin\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\tR\x04data\"\x80\x03\n" +
+	"\bEnvelope\x12\x14\n" +
+	"\x05janus\x18\x01 \x01(\tR\x05janus\x12 \n" +
+	"\vtransaction\x18\x02 \x01(\tR\vtransaction\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x03 \x01(\x04R\tsessionId\x12\x16\n" +
+	"\x06sender\x18\x04 \x01(\x04R\x06sender\x12\x0e\n" +
+	"\x02id\x18\x05 \x01(\x04R\x02id\x12%\n" +
+	"\x05error\x18\x06 \x01(\v2\x0f.janus.v1.ErrorR\x05error\x124\n" +
+	"\n" +
+	"plugindata\x18\a \x01(\v2\x14.janus.v1.PluginDataR\n" +
+	"plugindata\x12\"\n" +
+	"\x04jsep\x18\b \x01(\v2\x0e.janus.v1.JsepR\x04jsep\x12\x12\n" +
+	"\x04hint\x18\t \x01(\tR\x04hint\x12\x16\n" +
+	"\x06reason\x18\n" +
+	" \x01(\tR\x06reason\x12\x1a\n" +
+	"\bsessions\x18\v \x03(\x04R\bsessions\x12\x18\n" +
+	"\ahandles\x18\f \x03(\x04R\ahandles\x12\x12\n" +
+	"\x04json\x18\x0f \x01(\tR\x04json2\xd5\x05\n" +
+	"\x05Janus\x121\n" +
+	"\x04Info\x12\x15.janus.v1.InfoRequest\x1a\x12.janus.v1.Envelope\x121\n" +
+	"\x04Ping\x12\x15.janus.v1.InfoRequest\x1a\x12.janus.v1.Envelope\x125\n" +
+	"\x06Create\x12\x17.janus.v1.CreateRequest\x1a\x12.janus.v1.Envelope\x125\n" +
+	"\x05Claim\x12\x18.janus.v1.SessionRequest\x1a\x12.janus.v1.Envelope\x129\n" +
+	"\tKeepalive\x12\x18.janus.v1.SessionRequest\x1a\x12.janus.v1.Envelope\x127\n" +
+	"\aDestroy\x12\x18.janus.v1.SessionRequest\x1a\x12.janus.v1.Envelope\x125\n" +
+	"\x06Attach\x12\x17.janus.v1.AttachRequest\x1a\x12.janus.v1.Envelope\x127\n" +
+	"\aMessage\x12\x18.janus.v1.MessageRequest\x1a\x12.janus.v1.Envelope\x127\n" +
+	"\aTrickle\x12\x18.janus.v1.TrickleRequest\x1a\x12.janus.v1.Envelope\x125\n" +
+	"\x06Detach\x12\x17.janus.v1.HandleRequest\x1a\x12.janus.v1.Envelope\x125\n" +
+	"\x06Hangup\x12\x17.janus.v1.HandleRequest\x1a\x12.janus.v1.Envelope\x123\n" +
+	"\aRequest\x12\x14.janus.v1.RawRequest\x1a\x12.janus.v1.Envelope\x128\n" +
+	"\x06Events\x12\x18.janus.v1.SessionRequest\x1a\x12.janus.v1.Envelope0\x012\xa7\x03\n" +
+	"\x05Admin\x122\n" +
+	"\x04Info\x12\x16.janus.v1.AdminRequest\x1a\x12.janus.v1.Envelope\x122\n" +
+	"\x04Ping\x12\x16.janus.v1.AdminRequest\x1a\x12.janus.v1.Envelope\x12:\n" +
+	"\fListSessions\x12\x16.janus.v1.AdminRequest\x1a\x12.janus.v1.Envelope\x12@\n" +
+	"\vListHandles\x12\x1d.janus.v1.AdminSessionRequest\x1a\x12.janus.v1.Envelope\x12>\n" +
+	"\n" +
+	"HandleInfo\x12\x1c.janus.v1.AdminHandleRequest\x1a\x12.janus.v1.Envelope\x12C\n" +
+	"\x0eDestroySession\x12\x1d.janus.v1.AdminSessionRequest\x1a\x12.janus.v1.Envelope\x123\n" +
+	"\aRequest\x12\x14.janus.v1.RawRequest\x1a\x12.janus.v1.EnvelopeB4Z2github.com/xroger88/go-janus/transports/grpcapi/pbb\x06proto3"
+
+var (
+	file_janus_proto_rawDescOnce sync.Once
+	file_janus_proto_rawDescData []byte
+)
+
+func file_janus_proto_rawDescGZIP() []byte {
+	file_janus_proto_rawDescOnce.Do(func() {
+		file_janus_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_janus_proto_rawDesc), len(file_janus_proto_rawDesc)))
+	})
+	return file_janus_proto_rawDescData
+}
+
+var file_janus_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
+var file_janus_proto_goTypes = []any{
+	(*Auth)(nil),                // 0: janus.v1.Auth
+	(*InfoRequest)(nil),         // 1: janus.v1.InfoRequest
+	(*CreateRequest)(nil),       // 2: janus.v1.CreateRequest
+	(*SessionRequest)(nil),      // 3: janus.v1.SessionRequest
+	(*AttachRequest)(nil),       // 4: janus.v1.AttachRequest
+	(*HandleRequest)(nil),       // 5: janus.v1.HandleRequest
+	(*Jsep)(nil),                // 6: janus.v1.Jsep
+	(*MessageRequest)(nil),      // 7: janus.v1.MessageRequest
+	(*Candidate)(nil),           // 8: janus.v1.Candidate
+	(*TrickleRequest)(nil),      // 9: janus.v1.TrickleRequest
+	(*RawRequest)(nil),          // 10: janus.v1.RawRequest
+	(*AdminRequest)(nil),        // 11: janus.v1.AdminRequest
+	(*AdminSessionRequest)(nil), // 12: janus.v1.AdminSessionRequest
+	(*AdminHandleRequest)(nil),  // 13: janus.v1.AdminHandleRequest
+	(*Error)(nil),               // 14: janus.v1.Error
+	(*PluginData)(nil),          // 15: janus.v1.PluginData
+	(*Envelope)(nil),            // 16: janus.v1.Envelope
+}
+var file_janus_proto_depIdxs = []int32{
+	0,  // 0: janus.v1.CreateRequest.auth:type_name -> janus.v1.Auth
+	0,  // 1: janus.v1.SessionRequest.auth:type_name -> janus.v1.Auth
+	0,  // 2: janus.v1.AttachRequest.auth:type_name -> janus.v1.Auth
+	0,  // 3: janus.v1.HandleRequest.auth:type_name -> janus.v1.Auth
+	6,  // 4: janus.v1.MessageRequest.jsep:type_name -> janus.v1.Jsep
+	0,  // 5: janus.v1.MessageRequest.auth:type_name -> janus.v1.Auth
+	8,  // 6: janus.v1.TrickleRequest.candidate:type_name -> janus.v1.Candidate
+	8,  // 7: janus.v1.TrickleRequest.candidates:type_name -> janus.v1.Candidate
+	0,  // 8: janus.v1.TrickleRequest.auth:type_name -> janus.v1.Auth
+	14, // 9: janus.v1.Envelope.error:type_name -> janus.v1.Error
+	15, // 10: janus.v1.Envelope.plugindata:type_name -> janus.v1.PluginData
+	6,  // 11: janus.v1.Envelope.jsep:type_name -> janus.v1.Jsep
+	1,  // 12: janus.v1.Janus.Info:input_type -> janus.v1.InfoRequest
+	1,  // 13: janus.v1.Janus.Ping:input_type -> janus.v1.InfoRequest
+	2,  // 14: janus.v1.Janus.Create:input_type -> janus.v1.CreateRequest
+	3,  // 15: janus.v1.Janus.Claim:input_type -> janus.v1.SessionRequest
+	3,  // 16: janus.v1.Janus.Keepalive:input_type -> janus.v1.SessionRequest
+	3,  // 17: janus.v1.Janus.Destroy:input_type -> janus.v1.SessionRequest
+	4,  // 18: janus.v1.Janus.Attach:input_type -> janus.v1.AttachRequest
+	7,  // 19: janus.v1.Janus.Message:input_type -> janus.v1.MessageRequest
+	9,  // 20: janus.v1.Janus.Trickle:input_type -> janus.v1.TrickleRequest
+	5,  // 21: janus.v1.Janus.Detach:input_type -> janus.v1.HandleRequest
+	5,  // 22: janus.v1.Janus.Hangup:input_type -> janus.v1.HandleRequest
+	10, // 23: janus.v1.Janus.Request:input_type -> janus.v1.RawRequest
+	3,  // 24: janus.v1.Janus.Events:input_type -> janus.v1.SessionRequest
+	11, // 25: janus.v1.Admin.Info:input_type -> janus.v1.AdminRequest
+	11, // 26: janus.v1.Admin.Ping:input_type -> janus.v1.AdminRequest
+	11, // 27: janus.v1.Admin.ListSessions:input_type -> janus.v1.AdminRequest
+	12, // 28: janus.v1.Admin.ListHandles:input_type -> janus.v1.AdminSessionRequest
+	13, // 29: janus.v1.Admin.HandleInfo:input_type -> janus.v1.AdminHandleRequest
+	12, // 30: janus.v1.Admin.DestroySession:input_type -> janus.v1.AdminSessionRequest
+	10, // 31: janus.v1.Admin.Request:input_type -> janus.v1.RawRequest
+	16, // 32: janus.v1.Janus.Info:output_type -> janus.v1.Envelope
+	16, // 33: janus.v1.Janus.Ping:output_type -> janus.v1.Envelope
+	16, // 34: janus.v1.Janus.Create:output_type -> janus.v1.Envelope
+	16, // 35: janus.v1.Janus.Claim:output_type -> janus.v1.Envelope
+	16, // 36: janus.v1.Janus.Keepalive:output_type -> janus.v1.Envelope
+	16, // 37: janus.v1.Janus.Destroy:output_type -> janus.v1.Envelope
+	16, // 38: janus.v1.Janus.Attach:output_type -> janus.v1.Envelope
+	16, // 39: janus.v1.Janus.Message:output_type -> janus.v1.Envelope
+	16, // 40: janus.v1.Janus.Trickle:output_type -> janus.v1.Envelope
+	16, // 41: janus.v1.Janus.Detach:output_type -> janus.v1.Envelope
+	16, // 42: janus.v1.Janus.Hangup:output_type -> janus.v1.Envelope
+	16, // 43: janus.v1.Janus.Request:output_type -> janus.v1.Envelope
+	16, // 44: janus.v1.Janus.Events:output_type -> janus.v1.Envelope
+	16, // 45: janus.v1.Admin.Info:output_type -> janus.v1.Envelope
+	16, // 46: janus.v1.Admin.Ping:output_type -> janus.v1.Envelope
+	16, // 47: janus.v1.Admin.ListSessions:output_type -> janus.v1.Envelope
+	16, // 48: janus.v1.Admin.ListHandles:output_type -> janus.v1.Envelope
+	16, // 49: janus.v1.Admin.HandleInfo:output_type -> janus.v1.Envelope
+	16, // 50: janus.v1.Admin.DestroySession:output_type -> janus.v1.Envelope
+	16, // 51: janus.v1.Admin.Request:output_type -> janus.v1.Envelope
+	32, // [32:52] is the sub-list for method output_type
+	12, // [12:32] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
+}
+
+func init() { file_janus_proto_init() }
+func file_janus_proto_init() {
+	if File_janus_proto != nil {
+		return
+	}
+	file_janus_proto_msgTypes[4].OneofWrappers = []any{}
+	file_janus_proto_msgTypes[6].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_janus_proto_rawDesc), len(file_janus_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   17,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_janus_proto_goTypes,
+		DependencyIndexes: file_janus_proto_depIdxs,
+		MessageInfos:      file_janus_proto_msgTypes,
+	}.Build()
+	File_janus_proto = out.File
+	file_janus_proto_goTypes = nil
+	file_janus_proto_depIdxs = nil
+}
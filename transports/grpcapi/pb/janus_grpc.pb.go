@@ -0,0 +1,925 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: janus.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Janus_Info_FullMethodName      = "/janus.v1.Janus/Info"
+	Janus_Ping_FullMethodName      = "/janus.v1.Janus/Ping"
+	Janus_Create_FullMethodName    = "/janus.v1.Janus/Create"
+	Janus_Claim_FullMethodName     = "/janus.v1.Janus/Claim"
+	Janus_Keepalive_FullMethodName = "/janus.v1.Janus/Keepalive"
+	Janus_Destroy_FullMethodName   = "/janus.v1.Janus/Destroy"
+	Janus_Attach_FullMethodName    = "/janus.v1.Janus/Attach"
+	Janus_Message_FullMethodName   = "/janus.v1.Janus/Message"
+	Janus_Trickle_FullMethodName   = "/janus.v1.Janus/Trickle"
+	Janus_Detach_FullMethodName    = "/janus.v1.Janus/Detach"
+	Janus_Hangup_FullMethodName    = "/janus.v1.Janus/Hangup"
+	Janus_Request_FullMethodName   = "/janus.v1.Janus/Request"
+	Janus_Events_FullMethodName    = "/janus.v1.Janus/Events"
+)
+
+// JanusClient is the client API for Janus service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Janus is the Janus API
+type JanusClient interface {
+	Info(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*Envelope, error)
+	Ping(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*Envelope, error)
+	Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*Envelope, error)
+	Claim(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Envelope, error)
+	Keepalive(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Envelope, error)
+	Destroy(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Envelope, error)
+	Attach(ctx context.Context, in *AttachRequest, opts ...grpc.CallOption) (*Envelope, error)
+	Message(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*Envelope, error)
+	Trickle(ctx context.Context, in *TrickleRequest, opts ...grpc.CallOption) (*Envelope, error)
+	Detach(ctx context.Context, in *HandleRequest, opts ...grpc.CallOption) (*Envelope, error)
+	Hangup(ctx context.Context, in *HandleRequest, opts ...grpc.CallOption) (*Envelope, error)
+	// Request takes any request of the Janus API as JSON
+	Request(ctx context.Context, in *RawRequest, opts ...grpc.CallOption) (*Envelope, error)
+	// Events streams the events of a session, keeping it alive meanwhile
+	Events(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Envelope], error)
+}
+
+type janusClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewJanusClient(cc grpc.ClientConnInterface) JanusClient {
+	return &janusClient{cc}
+}
+
+func (c *janusClient) Info(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Info_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Ping(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Ping_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Create_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Claim(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Claim_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Keepalive(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Keepalive_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Destroy(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Destroy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Attach(ctx context.Context, in *AttachRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Attach_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Message(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Message_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Trickle(ctx context.Context, in *TrickleRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Trickle_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Detach(ctx context.Context, in *HandleRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Detach_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Hangup(ctx context.Context, in *HandleRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Hangup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Request(ctx context.Context, in *RawRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Janus_Request_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *janusClient) Events(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Envelope], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Janus_ServiceDesc.Streams[0], Janus_Events_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SessionRequest, Envelope]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Janus_EventsClient = grpc.ServerStreamingClient[Envelope]
+
+// JanusServer is the server API for Janus service.
+// All implementations must embed UnimplementedJanusServer
+// for forward compatibility.
+//
+// Janus is the Janus API
+type JanusServer interface {
+	Info(context.Context, *InfoRequest) (*Envelope, error)
+	Ping(context.Context, *InfoRequest) (*Envelope, error)
+	Create(context.Context, *CreateRequest) (*Envelope, error)
+	Claim(context.Context, *SessionRequest) (*Envelope, error)
+	Keepalive(context.Context, *SessionRequest) (*Envelope, error)
+	Destroy(context.Context, *SessionRequest) (*Envelope, error)
+	Attach(context.Context, *AttachRequest) (*Envelope, error)
+	Message(context.Context, *MessageRequest) (*Envelope, error)
+	Trickle(context.Context, *TrickleRequest) (*Envelope, error)
+	Detach(context.Context, *HandleRequest) (*Envelope, error)
+	Hangup(context.Context, *HandleRequest) (*Envelope, error)
+	// Request takes any request of the Janus API as JSON
+	Request(context.Context, *RawRequest) (*Envelope, error)
+	// Events streams the events of a session, keeping it alive meanwhile
+	Events(*SessionRequest, grpc.ServerStreamingServer[Envelope]) error
+	mustEmbedUnimplementedJanusServer()
+}
+
+// UnimplementedJanusServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedJanusServer struct{}
+
+func (UnimplementedJanusServer) Info(context.Context, *InfoRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Info not implemented")
+}
+func (UnimplementedJanusServer) Ping(context.Context, *InfoRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Ping not implemented")
+}
+func (UnimplementedJanusServer) Create(context.Context, *CreateRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Create not implemented")
+}
+func (UnimplementedJanusServer) Claim(context.Context, *SessionRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Claim not implemented")
+}
+func (UnimplementedJanusServer) Keepalive(context.Context, *SessionRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Keepalive not implemented")
+}
+func (UnimplementedJanusServer) Destroy(context.Context, *SessionRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Destroy not implemented")
+}
+func (UnimplementedJanusServer) Attach(context.Context, *AttachRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Attach not implemented")
+}
+func (UnimplementedJanusServer) Message(context.Context, *MessageRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Message not implemented")
+}
+func (UnimplementedJanusServer) Trickle(context.Context, *TrickleRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Trickle not implemented")
+}
+func (UnimplementedJanusServer) Detach(context.Context, *HandleRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Detach not implemented")
+}
+func (UnimplementedJanusServer) Hangup(context.Context, *HandleRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Hangup not implemented")
+}
+func (UnimplementedJanusServer) Request(context.Context, *RawRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Request not implemented")
+}
+func (UnimplementedJanusServer) Events(*SessionRequest, grpc.ServerStreamingServer[Envelope]) error {
+	return status.Error(codes.Unimplemented, "method Events not implemented")
+}
+func (UnimplementedJanusServer) mustEmbedUnimplementedJanusServer() {}
+func (UnimplementedJanusServer) testEmbeddedByValue()               {}
+
+// UnsafeJanusServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to JanusServer will
+// result in compilation errors.
+type UnsafeJanusServer interface {
+	mustEmbedUnimplementedJanusServer()
+}
+
+func RegisterJanusServer(s grpc.ServiceRegistrar, srv JanusServer) {
+	// If the following call panics, it indicates UnimplementedJanusServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Janus_ServiceDesc, srv)
+}
+
+func _Janus_Info_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Info(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Info_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Info(ctx, req.(*InfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Ping_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Ping(ctx, req.(*InfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Create_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Create(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Create_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Create(ctx, req.(*CreateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Claim_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Claim(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Claim_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Claim(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Keepalive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Keepalive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Keepalive_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Keepalive(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Destroy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Destroy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Destroy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Destroy(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Attach_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AttachRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Attach(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Attach_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Attach(ctx, req.(*AttachRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Message_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Message(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Message_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Message(ctx, req.(*MessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Trickle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TrickleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Trickle(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Trickle_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Trickle(ctx, req.(*TrickleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Detach_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HandleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Detach(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Detach_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Detach(ctx, req.(*HandleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Hangup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HandleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Hangup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Hangup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Hangup(ctx, req.(*HandleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Request_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RawRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JanusServer).Request(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Janus_Request_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JanusServer).Request(ctx, req.(*RawRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Janus_Events_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SessionRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(JanusServer).Events(m, &grpc.GenericServerStream[SessionRequest, Envelope]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Janus_EventsServer = grpc.ServerStreamingServer[Envelope]
+
+// Janus_ServiceDesc is the grpc.ServiceDesc for Janus service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Janus_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "janus.v1.Janus",
+	HandlerType: (*JanusServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Info",
+			Handler:    _Janus_Info_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _Janus_Ping_Handler,
+		},
+		{
+			MethodName: "Create",
+			Handler:    _Janus_Create_Handler,
+		},
+		{
+			MethodName: "Claim",
+			Handler:    _Janus_Claim_Handler,
+		},
+		{
+			MethodName: "Keepalive",
+			Handler:    _Janus_Keepalive_Handler,
+		},
+		{
+			MethodName: "Destroy",
+			Handler:    _Janus_Destroy_Handler,
+		},
+		{
+			MethodName: "Attach",
+			Handler:    _Janus_Attach_Handler,
+		},
+		{
+			MethodName: "Message",
+			Handler:    _Janus_Message_Handler,
+		},
+		{
+			MethodName: "Trickle",
+			Handler:    _Janus_Trickle_Handler,
+		},
+		{
+			MethodName: "Detach",
+			Handler:    _Janus_Detach_Handler,
+		},
+		{
+			MethodName: "Hangup",
+			Handler:    _Janus_Hangup_Handler,
+		},
+		{
+			MethodName: "Request",
+			Handler:    _Janus_Request_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Events",
+			Handler:       _Janus_Events_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "janus.proto",
+}
+
+const (
+	Admin_Info_FullMethodName           = "/janus.v1.Admin/Info"
+	Admin_Ping_FullMethodName           = "/janus.v1.Admin/Ping"
+	Admin_ListSessions_FullMethodName   = "/janus.v1.Admin/ListSessions"
+	Admin_ListHandles_FullMethodName    = "/janus.v1.Admin/ListHandles"
+	Admin_HandleInfo_FullMethodName     = "/janus.v1.Admin/HandleInfo"
+	Admin_DestroySession_FullMethodName = "/janus.v1.Admin/DestroySession"
+	Admin_Request_FullMethodName        = "/janus.v1.Admin/Request"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Admin is the Admin API
+type AdminClient interface {
+	Info(ctx context.Context, in *AdminRequest, opts ...grpc.CallOption) (*Envelope, error)
+	Ping(ctx context.Context, in *AdminRequest, opts ...grpc.CallOption) (*Envelope, error)
+	ListSessions(ctx context.Context, in *AdminRequest, opts ...grpc.CallOption) (*Envelope, error)
+	ListHandles(ctx context.Context, in *AdminSessionRequest, opts ...grpc.CallOption) (*Envelope, error)
+	HandleInfo(ctx context.Context, in *AdminHandleRequest, opts ...grpc.CallOption) (*Envelope, error)
+	DestroySession(ctx context.Context, in *AdminSessionRequest, opts ...grpc.CallOption) (*Envelope, error)
+	// Request takes any request of the Admin API as JSON
+	Request(ctx context.Context, in *RawRequest, opts ...grpc.CallOption) (*Envelope, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) Info(ctx context.Context, in *AdminRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Admin_Info_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Ping(ctx context.Context, in *AdminRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Admin_Ping_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListSessions(ctx context.Context, in *AdminRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Admin_ListSessions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListHandles(ctx context.Context, in *AdminSessionRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Admin_ListHandles_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) HandleInfo(ctx context.Context, in *AdminHandleRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Admin_HandleInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DestroySession(ctx context.Context, in *AdminSessionRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Admin_DestroySession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Request(ctx context.Context, in *RawRequest, opts ...grpc.CallOption) (*Envelope, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Envelope)
+	err := c.cc.Invoke(ctx, Admin_Request_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility.
+//
+// Admin is the Admin API
+type AdminServer interface {
+	Info(context.Context, *AdminRequest) (*Envelope, error)
+	Ping(context.Context, *AdminRequest) (*Envelope, error)
+	ListSessions(context.Context, *AdminRequest) (*Envelope, error)
+	ListHandles(context.Context, *AdminSessionRequest) (*Envelope, error)
+	HandleInfo(context.Context, *AdminHandleRequest) (*Envelope, error)
+	DestroySession(context.Context, *AdminSessionRequest) (*Envelope, error)
+	// Request takes any request of the Admin API as JSON
+	Request(context.Context, *RawRequest) (*Envelope, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAdminServer struct{}
+
+func (UnimplementedAdminServer) Info(context.Context, *AdminRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Info not implemented")
+}
+func (UnimplementedAdminServer) Ping(context.Context, *AdminRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Ping not implemented")
+}
+func (UnimplementedAdminServer) ListSessions(context.Context, *AdminRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedAdminServer) ListHandles(context.Context, *AdminSessionRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListHandles not implemented")
+}
+func (UnimplementedAdminServer) HandleInfo(context.Context, *AdminHandleRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method HandleInfo not implemented")
+}
+func (UnimplementedAdminServer) DestroySession(context.Context, *AdminSessionRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method DestroySession not implemented")
+}
+func (UnimplementedAdminServer) Request(context.Context, *RawRequest) (*Envelope, error) {
+	return nil, status.Error(codes.Unimplemented, "method Request not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+func (UnimplementedAdminServer) testEmbeddedByValue()               {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	// If the following call panics, it indicates UnimplementedAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_Info_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Info(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Info_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Info(ctx, req.(*AdminRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Ping_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Ping(ctx, req.(*AdminRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListSessions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListSessions(ctx, req.(*AdminRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListHandles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListHandles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListHandles_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListHandles(ctx, req.(*AdminSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_HandleInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminHandleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).HandleInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_HandleInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).HandleInfo(ctx, req.(*AdminHandleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DestroySession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DestroySession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_DestroySession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DestroySession(ctx, req.(*AdminSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Request_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RawRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Request(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Request_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Request(ctx, req.(*RawRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "janus.v1.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Info",
+			Handler:    _Admin_Info_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _Admin_Ping_Handler,
+		},
+		{
+			MethodName: "ListSessions",
+			Handler:    _Admin_ListSessions_Handler,
+		},
+		{
+			MethodName: "ListHandles",
+			Handler:    _Admin_ListHandles_Handler,
+		},
+		{
+			MethodName: "HandleInfo",
+			Handler:    _Admin_HandleInfo_Handler,
+		},
+		{
+			MethodName: "DestroySession",
+			Handler:    _Admin_DestroySession_Handler,
+		},
+		{
+			MethodName: "Request",
+			Handler:    _Admin_Request_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "janus.proto",
+}
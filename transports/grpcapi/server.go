@@ -0,0 +1,262 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/transports/grpcapi/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// how often an event stream keeps its session alive
+	streamKeepalive = 15 * time.Second
+	// events sent at once, at most
+	maxEvents = 100
+)
+
+// request is a request of the Janus API (or of the Admin API) being built
+type request map[string]interface{}
+
+func newRequest(janus, t string) request {
+	return request{"janus": janus, "transaction": transaction(t)}
+}
+
+// set adds an element, unless it has its zero value
+func (r request) set(name string, value interface{}) request {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return r
+		}
+	case uint64:
+		if v == 0 {
+			return r
+		}
+	}
+	r[name] = value
+	return r
+}
+
+func (r request) auth(a *pb.Auth) request {
+	if a != nil {
+		r.set("apisecret", a.Apisecret).set("token", a.Token)
+	}
+	return r
+}
+
+func (t *Transport) send(admin bool, r request) (*pb.Envelope, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return t.request(admin, data), nil
+}
+
+func (t *Transport) sendRaw(admin bool, r *pb.RawRequest) (*pb.Envelope, error) {
+	if !json.Valid([]byte(r.Json)) {
+		return nil, status.Error(codes.InvalidArgument, "invalid JSON")
+	}
+	return t.request(admin, []byte(r.Json)), nil
+}
+
+func candidate(c *pb.Candidate) map[string]interface{} {
+	return map[string]interface{}{
+		"sdpMid":        c.SdpMid,
+		"sdpMLineIndex": c.SdpMlineIndex,
+		"candidate":     c.Candidate,
+	}
+}
+
+// janusServer implements the Janus service
+type janusServer struct {
+	pb.UnimplementedJanusServer
+	t *Transport
+}
+
+func (s *janusServer) Info(ctx context.Context, r *pb.InfoRequest) (*pb.Envelope, error) {
+	return s.t.send(false, newRequest("info", r.Transaction))
+}
+
+func (s *janusServer) Ping(ctx context.Context, r *pb.InfoRequest) (*pb.Envelope, error) {
+	return s.t.send(false, newRequest("ping", r.Transaction))
+}
+
+func (s *janusServer) Create(ctx context.Context, r *pb.CreateRequest) (*pb.Envelope, error) {
+	return s.t.send(false, newRequest("create", r.Transaction).set("id", r.Id).auth(r.Auth))
+}
+
+func (s *janusServer) sessionRequest(janus string, r *pb.SessionRequest) (*pb.Envelope, error) {
+	return s.t.send(false, newRequest(janus, r.Transaction).set("session_id", r.SessionId).auth(r.Auth))
+}
+
+func (s *janusServer) Claim(ctx context.Context, r *pb.SessionRequest) (*pb.Envelope, error) {
+	return s.sessionRequest("claim", r)
+}
+
+func (s *janusServer) Keepalive(ctx context.Context, r *pb.SessionRequest) (*pb.Envelope, error) {
+	return s.sessionRequest("keepalive", r)
+}
+
+func (s *janusServer) Destroy(ctx context.Context, r *pb.SessionRequest) (*pb.Envelope, error) {
+	return s.sessionRequest("destroy", r)
+}
+
+func (s *janusServer) Attach(ctx context.Context, r *pb.AttachRequest) (*pb.Envelope, error) {
+	req := newRequest("attach", r.Transaction).set("session_id", r.SessionId).
+		set("plugin", r.Plugin).set("opaque_id", r.OpaqueId).auth(r.Auth)
+	if r.LoopIndex != nil {
+		req["loop_index"] = *r.LoopIndex
+	}
+	return s.t.send(false, req)
+}
+
+func (s *janusServer) handleRequest(janus string, r *pb.HandleRequest) (*pb.Envelope, error) {
+	return s.t.send(false, newRequest(janus, r.Transaction).set("session_id", r.SessionId).
+		set("handle_id", r.HandleId).auth(r.Auth))
+}
+
+func (s *janusServer) Detach(ctx context.Context, r *pb.HandleRequest) (*pb.Envelope, error) {
+	return s.handleRequest("detach", r)
+}
+
+func (s *janusServer) Hangup(ctx context.Context, r *pb.HandleRequest) (*pb.Envelope, error) {
+	return s.handleRequest("hangup", r)
+}
+
+func (s *janusServer) Message(ctx context.Context, r *pb.MessageRequest) (*pb.Envelope, error) {
+	req := newRequest("message", r.Transaction).set("session_id", r.SessionId).
+		set("handle_id", r.HandleId).auth(r.Auth)
+	if r.Body != "" {
+		if !json.Valid([]byte(r.Body)) {
+			return nil, status.Error(codes.InvalidArgument, "invalid JSON body")
+		}
+		req["body"] = json.RawMessage(r.Body)
+	}
+	if r.Jsep != nil {
+		jsep := map[string]interface{}{"type": r.Jsep.Type, "sdp": r.Jsep.Sdp}
+		if r.Jsep.Trickle != nil {
+			jsep["trickle"] = *r.Jsep.Trickle
+		}
+		req["jsep"] = jsep
+	}
+	return s.t.send(false, req)
+}
+
+func (s *janusServer) Trickle(ctx context.Context, r *pb.TrickleRequest) (*pb.Envelope, error) {
+	req := newRequest("trickle", r.Transaction).set("session_id", r.SessionId).
+		set("handle_id", r.HandleId).auth(r.Auth)
+	switch {
+	case r.Completed:
+		req["candidate"] = map[string]interface{}{"completed": true}
+	case r.Candidate != nil:
+		req["candidate"] = candidate(r.Candidate)
+	case len(r.Candidates) > 0:
+		candidates := make([]interface{}, len(r.Candidates))
+		for i, c := range r.Candidates {
+			candidates[i] = candidate(c)
+		}
+		req["candidates"] = candidates
+	}
+	return s.t.send(false, req)
+}
+
+func (s *janusServer) Request(ctx context.Context, r *pb.RawRequest) (*pb.Envelope, error) {
+	return s.t.sendRaw(false, r)
+}
+
+// Events streams the events of a session until the session goes away, or
+// the client or the server do. Each keepalive checks that the session
+// still exists and that the client can use it. Streams taking turns on the
+// queue would each miss some events: a second stream for the same session
+// is refused while the first one is open.
+func (s *janusServer) Events(r *pb.SessionRequest, stream pb.Janus_EventsServer) error {
+	keepalive := func() error {
+		response, err := s.sessionRequest("keepalive", r)
+		if err != nil {
+			return err
+		}
+		if response.Janus == "error" {
+			return stream.Send(response)
+		}
+		return nil
+	}
+	if err := keepalive(); err != nil {
+		return err
+	}
+	q := s.t.Queue(r.SessionId)
+	if q == nil {
+		return stream.Send(envelope(api.ErrorResponse(r.SessionId, r.Transaction, api.ErrorSessionNotFound, "")))
+	}
+	if !s.t.subscribe(r.SessionId) {
+		return status.Errorf(codes.AlreadyExists, "session %d already has an event stream", r.SessionId)
+	}
+	defer s.t.unsubscribe(r.SessionId)
+	for {
+		events := q.Wait(stream.Context(), s.t.done, maxEvents, streamKeepalive)
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.t.done:
+			return nil
+		default:
+		}
+		if len(events) == 0 {
+			if s.t.Queue(r.SessionId) != q {
+				// the session is over and there's nothing left to send
+				return nil
+			}
+			if err := keepalive(); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, event := range events {
+			if err := stream.Send(envelope(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// adminServer implements the Admin service
+type adminServer struct {
+	pb.UnimplementedAdminServer
+	t *Transport
+}
+
+func adminRequest(janus, t, secret string) request {
+	return newRequest(janus, t).set("admin_secret", secret)
+}
+
+func (s *adminServer) Info(ctx context.Context, r *pb.AdminRequest) (*pb.Envelope, error) {
+	return s.t.send(true, adminRequest("info", r.Transaction, r.AdminSecret))
+}
+
+func (s *adminServer) Ping(ctx context.Context, r *pb.AdminRequest) (*pb.Envelope, error) {
+	return s.t.send(true, adminRequest("ping", r.Transaction, r.AdminSecret))
+}
+
+func (s *adminServer) ListSessions(ctx context.Context, r *pb.AdminRequest) (*pb.Envelope, error) {
+	return s.t.send(true, adminRequest("list_sessions", r.Transaction, r.AdminSecret))
+}
+
+func (s *adminServer) ListHandles(ctx context.Context, r *pb.AdminSessionRequest) (*pb.Envelope, error) {
+	return s.t.send(true, adminRequest("list_handles", r.Transaction, r.AdminSecret).set("session_id", r.SessionId))
+}
+
+func (s *adminServer) HandleInfo(ctx context.Context, r *pb.AdminHandleRequest) (*pb.Envelope, error) {
+	return s.t.send(true, adminRequest("handle_info", r.Transaction, r.AdminSecret).
+		set("session_id", r.SessionId).set("handle_id", r.HandleId))
+}
+
+func (s *adminServer) DestroySession(ctx context.Context, r *pb.AdminSessionRequest) (*pb.Envelope, error) {
+	return s.t.send(true, adminRequest("destroy_session", r.Transaction, r.AdminSecret).set("session_id", r.SessionId))
+}
+
+func (s *adminServer) Request(ctx context.Context, r *pb.RawRequest) (*pb.Envelope, error) {
+	return s.t.sendRaw(true, r)
+}
@@ -0,0 +1,64 @@
+package transports
+
+// What happens to the queues of the transports is reported to the event
+// handlers, as transport events, and counted in Prometheus metrics, which
+// transports can expose with MetricsHandler.
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/xroger88/go-janus/events"
+)
+
+var (
+	coalescedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "janus",
+		Subsystem: "transport",
+		Name:      "coalesced_events_total",
+		Help:      "Low priority events replaced by a newer one while still queued.",
+	}, []string{"transport"})
+	droppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "janus",
+		Subsystem: "transport",
+		Name:      "dropped_events_total",
+		Help:      "Events dropped because the client was too slow to take them.",
+	}, []string{"transport"})
+	overflows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "janus",
+		Subsystem: "transport",
+		Name:      "queue_overflows_total",
+		Help:      "Clients disconnected because their queue overflowed.",
+	}, []string{"transport"})
+)
+
+func init() {
+	prometheus.MustRegister(coalescedEvents, droppedEvents, overflows)
+}
+
+// Dropped counts events a transport had to drop on its own, e.g. because a
+// write timed out
+func Dropped(transport string, n int) {
+	droppedEvents.WithLabelValues(transport).Add(float64(n))
+}
+
+// Notify tells the event handlers about something that happened to a client
+// of a transport, identified by id
+func Notify(transport, id string, data map[string]interface{}) {
+	events.Notify(map[string]interface{}{
+		"type":      events.TypeTransport,
+		"timestamp": time.Now().UnixNano() / int64(time.Microsecond),
+		"event": map[string]interface{}{
+			"transport": transport,
+			"id":        id,
+			"data":      data,
+		},
+	})
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
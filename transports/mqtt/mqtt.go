@@ -0,0 +1,252 @@
+package mqtt
+
+// MQTT transport for the Janus API, same as janus.transport.mqtt: requests
+// are received on a topic and responses and events are published on another
+// one, and the Admin API, if enabled, works the same on topics of its own.
+// Both MQTT 3.1.1 and MQTT 5 are supported: with MQTT 5, requests carrying a
+// response topic get their response there, along with their correlation
+// data, while events always go to the publish topic.
+// Events are queued and published by a transports.Publisher.
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/session"
+	"github.com/xroger88/go-janus/transports"
+	"github.com/xroger88/go-janus/util"
+)
+
+const (
+	Package = "janus.transport.mqtt"
+
+	connectTimeout = 10 * time.Second
+	// how long a publication can take before we give up on it, e.g. while
+	// the client is reconnecting
+	publishTimeout = 10 * time.Second
+	// requests waiting to be handled, before we stop reading from the broker
+	maxPendingRequests = 1000
+)
+
+// Config is the content of janus.transport.mqtt.yaml in the configs folder
+type Config struct {
+	General struct {
+		Enabled             bool
+		Url                 string
+		Mqtt_version        string
+		Client_id           string
+		Keep_alive_interval int
+		Cleansession        bool
+		Username            string
+		Password            string
+		Subscribe_topic     string
+		Subscribe_qos       int
+		Publish_topic       string
+		Publish_qos         int
+	}
+	Admin struct {
+		Admin_enabled         bool
+		Admin_subscribe_topic string
+		Admin_subscribe_qos   int
+		Admin_publish_topic   string
+		Admin_publish_qos     int
+	}
+	Tls config.ClientTLS
+}
+
+// reply is where an MQTT 5 request wants its response
+type reply struct {
+	topic       string
+	correlation []byte
+}
+
+type request struct {
+	admin bool
+	data  []byte
+	reply *reply
+}
+
+// broker hides the differences between the MQTT 3.1.1 and MQTT 5 clients
+type broker interface {
+	// connect connects to the broker, and subscribes to the topics (with
+	// their QoS) every time it does, passing what's received to handler
+	connect(topics map[string]byte, handler func(topic string, payload []byte, r *reply)) error
+	publish(topic string, qos byte, payload []byte, r *reply) error
+	disconnect()
+}
+
+var errPublishTimeout = errors.New("timed out publishing to the MQTT broker")
+
+type Transport struct {
+	config   Config
+	source   *session.Source
+	broker   broker
+	requests chan request
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	*transports.Publisher
+}
+
+// New returns the MQTT transport, to be started with Init
+func New() *Transport {
+	t := &Transport{
+		requests: make(chan request, maxPendingRequests),
+		done:     make(chan struct{}),
+	}
+	t.source = &session.Source{Transport: t}
+	return t
+}
+
+func init() {
+	transports.Register(New())
+}
+
+func (t *Transport) Package() string { return Package }
+func (t *Transport) Name() string    { return "JANUS MQTT transport plugin" }
+func (t *Transport) Description() string {
+	return "This transport plugin adds MQTT support to the Janus API."
+}
+func (t *Transport) Author() string        { return api.Author }
+func (t *Transport) Version() int          { return api.Version }
+func (t *Transport) VersionString() string { return api.VersionString }
+
+func (t *Transport) IsJanusAPIEnabled() bool { return t.config.General.Enabled }
+func (t *Transport) IsAdminAPIEnabled() bool { return t.config.Admin.Admin_enabled }
+
+// Init reads the configuration and connects to the broker
+func (t *Transport) Init(configPath string) error {
+	c := &t.config
+	c.General.Url = "tcp://localhost:1883"
+	c.General.Mqtt_version = "3.1.1"
+	c.General.Keep_alive_interval = 20
+	c.General.Cleansession = true
+	c.General.Subscribe_topic = "to-janus"
+	c.General.Subscribe_qos = 1
+	c.General.Publish_topic = "from-janus"
+	c.General.Publish_qos = 1
+	c.Admin.Admin_subscribe_topic = "to-janus-admin"
+	c.Admin.Admin_subscribe_qos = 1
+	c.Admin.Admin_publish_topic = "from-janus-admin"
+	c.Admin.Admin_publish_qos = 1
+	c.Tls.Verify_peer = true
+	if err := config.LoadComponent(configPath, Package, c); err != nil {
+		return err
+	}
+	if !c.General.Enabled && !c.Admin.Admin_enabled {
+		return transports.ErrNotEnabled
+	}
+	for _, qos := range []int{c.General.Subscribe_qos, c.General.Publish_qos, c.Admin.Admin_subscribe_qos, c.Admin.Admin_publish_qos} {
+		if qos < 0 || qos > 2 {
+			return errors.New("invalid QoS " + strconv.Itoa(qos) + ", must be 0, 1 or 2")
+		}
+	}
+	if c.General.Client_id == "" {
+		c.General.Client_id = "go-janus-" + strconv.FormatUint(util.RandomUint64(), 36)
+	}
+	tlsConfig, err := c.Tls.ClientConfig()
+	if err != nil {
+		return err
+	}
+	switch c.General.Mqtt_version {
+	case "3.1.1", "3":
+		t.broker = newBrokerV3(c, tlsConfig)
+	case "5", "5.0":
+		if t.broker, err = newBrokerV5(c, tlsConfig); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unsupported MQTT version " + c.General.Mqtt_version + " (3.1.1 or 5)")
+	}
+
+	topics := make(map[string]byte)
+	if c.General.Enabled {
+		topics[c.General.Subscribe_topic] = byte(c.General.Subscribe_qos)
+	}
+	if c.Admin.Admin_enabled {
+		topics[c.Admin.Admin_subscribe_topic] = byte(c.Admin.Admin_subscribe_qos)
+	}
+	if err := t.broker.connect(topics, t.incoming); err != nil {
+		return err
+	}
+	t.Publisher = transports.NewPublisher(Package, c.General.Client_id, func(event map[string]interface{}) error {
+		return t.publish(nil, false, event)
+	})
+	t.wg.Add(2)
+	go t.worker()
+	go func() {
+		defer t.wg.Done()
+		t.Publisher.Run(t.done)
+	}()
+	log.Infof("MQTT %s client connecting to %s as %s", c.General.Mqtt_version, c.General.Url, c.General.Client_id)
+	return nil
+}
+
+// Destroy stops handling requests and disconnects from the broker
+func (t *Transport) Destroy() {
+	select {
+	case <-t.done:
+		return
+	default:
+		close(t.done)
+	}
+	if t.broker != nil {
+		t.wg.Wait()
+		t.broker.disconnect()
+	}
+	log.Infof("%s destroyed", Package)
+}
+
+// incoming is called by the MQTT client for each message received: requests
+// are handled one at a time and in order by the worker, as the client may
+// not like being blocked while we publish the response
+func (t *Transport) incoming(topic string, payload []byte, r *reply) {
+	admin := t.config.Admin.Admin_enabled && topic == t.config.Admin.Admin_subscribe_topic
+	select {
+	case t.requests <- request{admin: admin, data: payload, reply: r}:
+	case <-t.done:
+	}
+}
+
+func (t *Transport) worker() {
+	defer t.wg.Done()
+	for {
+		select {
+		case r := <-t.requests:
+			log.Debugf("MQTT request: %s", r.data)
+			api.IncomingRequest(t.source, r.reply, r.admin, r.data)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// SendMessage publishes responses right away, while events are queued
+func (t *Transport) SendMessage(instance interface{}, requestID interface{}, admin bool, message map[string]interface{}) error {
+	// a request without a reply still gets a (nil) *reply, events get nothing
+	if r, ok := requestID.(*reply); ok || admin {
+		return t.publish(r, admin, message)
+	}
+	return t.Push(message)
+}
+
+func (t *Transport) publish(r *reply, admin bool, message map[string]interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	topic, qos := t.config.General.Publish_topic, t.config.General.Publish_qos
+	if admin {
+		topic, qos = t.config.Admin.Admin_publish_topic, t.config.Admin.Admin_publish_qos
+	}
+	if r != nil && r.topic != "" {
+		topic = r.topic
+	}
+	return t.broker.publish(topic, byte(qos), payload, r)
+}
@@ -0,0 +1,260 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/plugins"
+)
+
+// testPlugin acks messages and answers them with an event
+type testPlugin struct {
+	plugins.Plugin
+}
+
+const testPackage = "janus.plugin.mqtttest"
+
+func (testPlugin) Package() string                                { return testPackage }
+func (testPlugin) Name() string                                   { return "MQTT test" }
+func (testPlugin) CreateSession(ps *plugins.PluginSession) error  { return nil }
+func (testPlugin) DestroySession(ps *plugins.PluginSession) error { return nil }
+func (testPlugin) HangupMedia(ps *plugins.PluginSession)          {}
+
+func (p testPlugin) HandleMessage(ps *plugins.PluginSession, transaction string, message json.RawMessage, jsep *plugins.JSEP) *plugins.Result {
+	go api.Callbacks.PushEvent(ps, p, transaction, map[string]interface{}{"echo": message}, nil)
+	return &plugins.Result{Type: plugins.ResultOKWait}
+}
+
+func init() {
+	plugins.Register(testPlugin{})
+}
+
+// message is something the transport published
+type message struct {
+	topic       string
+	correlation []byte
+	data        map[string]interface{}
+}
+
+// testBroker is an in-process broker, with a client of ours listening to
+// what the transport publishes
+type testBroker struct {
+	server   *mochi.Server
+	url      string
+	messages chan message
+}
+
+func startBroker(t *testing.T) *testBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+	server := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: address})); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	b := &testBroker{server: server, url: "tcp://" + address, messages: make(chan message, 100)}
+	for i, topic := range []string{"from-janus", "from-janus-admin", "replies"} {
+		err := server.Subscribe(topic, i+1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+			m := message{topic: pk.TopicName, correlation: pk.Properties.CorrelationData}
+			if err := json.Unmarshal(pk.Payload, &m.data); err != nil {
+				t.Errorf("%s: %v", pk.Payload, err)
+			}
+			b.messages <- m
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+func startTransport(t *testing.T, b *testBroker, version string) *Transport {
+	dir := t.TempDir()
+	conf := fmt.Sprintf("general:\n  enabled: yes\n  url: %s\n  mqtt_version: \"%s\"\n  client_id: janus\n"+
+		"admin:\n  admin_enabled: yes\n", b.url, version)
+	if err := ioutil.WriteFile(filepath.Join(dir, Package+".yaml"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr := New()
+	if err := tr.Init(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tr.Destroy)
+	return tr
+}
+
+// publish sends a request, with MQTT 5 properties if given
+func (b *testBroker) publish(t *testing.T, topic string, request string, properties packets.Properties) {
+	t.Helper()
+	client := b.server.NewClient(nil, "local", "requester", true)
+	client.Properties.ProtocolVersion = 5
+	err := b.server.InjectPacket(client, packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1},
+		TopicName:   topic,
+		Payload:     []byte(request),
+		PacketID:    1,
+		Properties:  properties,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next returns the next message the transport published
+func (b *testBroker) next(t *testing.T) message {
+	t.Helper()
+	select {
+	case m := <-b.messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing published")
+	}
+	return message{}
+}
+
+// request sends a request and returns its response
+func (b *testBroker) request(t *testing.T, topic string, request string) map[string]interface{} {
+	t.Helper()
+	b.publish(t, topic, request, packets.Properties{})
+	return b.next(t).data
+}
+
+// waitReady pings the transport until it's subscribed to the requests
+func (b *testBroker) waitReady(t *testing.T) {
+	t.Helper()
+	for deadline := time.Now().Add(15 * time.Second); time.Now().Before(deadline); {
+		b.publish(t, "to-janus", `{"janus":"ping","transaction":"ready"}`, packets.Properties{})
+		select {
+		case m := <-b.messages:
+			if m.data["janus"] == "pong" {
+				// a ping that got lost earlier may still be answered late
+				time.Sleep(100 * time.Millisecond)
+				for len(b.messages) > 0 {
+					<-b.messages
+				}
+				return
+			}
+			t.Fatalf("got %v waiting for a pong", m.data)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	t.Fatal("the transport never subscribed")
+}
+
+func TestMQTT(t *testing.T) {
+	for _, version := range []string{"3.1.1", "5"} {
+		t.Run(version, func(t *testing.T) {
+			b := startBroker(t)
+			startTransport(t, b, version)
+			b.waitReady(t)
+
+			t.Run("requests", func(t *testing.T) {
+				created := b.request(t, "to-janus", `{"janus":"create","transaction":"c"}`)
+				data, _ := created["data"].(map[string]interface{})
+				sessionID, _ := data["id"].(float64)
+				if created["janus"] != "success" || sessionID == 0 {
+					t.Fatalf("got %v creating a session", created)
+				}
+				session := strconv.FormatUint(uint64(sessionID), 10)
+				attached := b.request(t, "to-janus", `{"janus":"attach","transaction":"a","session_id":`+session+`,"plugin":"`+testPackage+`"}`)
+				data, _ = attached["data"].(map[string]interface{})
+				handleID, _ := data["id"].(float64)
+				if attached["janus"] != "success" || handleID == 0 {
+					t.Fatalf("got %v attaching", attached)
+				}
+
+				// the ack, and the event that went through the queue
+				b.publish(t, "to-janus", `{"janus":"message","transaction":"m","session_id":`+session+`,"handle_id":`+
+					strconv.FormatUint(uint64(handleID), 10)+`,"body":{"hello":"there"}}`, packets.Properties{})
+				got := map[interface{}]message{}
+				for i := 0; i < 2; i++ {
+					m := b.next(t)
+					got[m.data["janus"]] = m
+				}
+				if ack := got["ack"]; ack.topic != "from-janus" || ack.data["transaction"] != "m" {
+					t.Errorf("got %v, want the ack", got)
+				}
+				event := got["event"]
+				plugindata, _ := event.data["plugindata"].(map[string]interface{})
+				if event.topic != "from-janus" || event.data["transaction"] != "m" || plugindata["plugin"] != testPackage {
+					t.Errorf("got %v, want the event", got)
+				}
+
+				// the response, and the event about the handle, in any order
+				b.publish(t, "to-janus", `{"janus":"destroy","transaction":"d","session_id":`+session+`}`, packets.Properties{})
+				got = map[interface{}]message{}
+				for i := 0; i < 2; i++ {
+					m := b.next(t)
+					got[m.data["janus"]] = m
+				}
+				if _, ok := got["success"]; !ok {
+					t.Errorf("got %v destroying the session, want success", got)
+				}
+				if _, ok := got["detached"]; !ok {
+					t.Errorf("got %v destroying the session, want the handle detached", got)
+				}
+			})
+
+			t.Run("admin", func(t *testing.T) {
+				b.publish(t, "to-janus-admin", `{"janus":"ping","transaction":"p"}`, packets.Properties{})
+				if m := b.next(t); m.topic != "from-janus-admin" || m.data["janus"] != "pong" {
+					t.Errorf("got %s %v, want a pong on the admin topic", m.topic, m.data)
+				}
+			})
+
+			if version == "5" {
+				t.Run("response topic", func(t *testing.T) {
+					b.publish(t, "to-janus", `{"janus":"ping","transaction":"r"}`, packets.Properties{ResponseTopic: "replies", CorrelationData: []byte("42")})
+					if m := b.next(t); m.topic != "replies" || string(m.correlation) != "42" || m.data["janus"] != "pong" {
+						t.Errorf("got %s (%q) %v, want a pong on the response topic", m.topic, m.correlation, m.data)
+					}
+				})
+			}
+
+			t.Run("reconnect", func(t *testing.T) {
+				client, ok := b.server.Clients.Get("janus")
+				if !ok {
+					t.Fatal("the transport isn't connected")
+				}
+				client.Stop(io.EOF)
+				for deadline := time.Now().Add(15 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+					if c, ok := b.server.Clients.Get("janus"); ok && c != client && !c.Closed() {
+						break
+					}
+					if time.Now().After(deadline) {
+						t.Fatal("the transport never reconnected")
+					}
+				}
+				// subscribed again, with a clean session
+				b.waitReady(t)
+			})
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// brokerV3 is an MQTT 3.1.1 client
+type brokerV3 struct {
+	options *paho.ClientOptions
+	client  paho.Client
+}
+
+func newBrokerV3(c *Config, tlsConfig *tls.Config) *brokerV3 {
+	options := paho.NewClientOptions().
+		AddBroker(c.General.Url).
+		SetClientID(c.General.Client_id).
+		SetProtocolVersion(4).
+		SetKeepAlive(time.Duration(c.General.Keep_alive_interval) * time.Second).
+		SetCleanSession(c.General.Cleansession).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Warnf("MQTT connection lost, reconnecting: %v", err)
+		})
+	if c.General.Username != "" {
+		options.SetUsername(c.General.Username).SetPassword(c.General.Password)
+	}
+	if tlsConfig != nil {
+		options.SetTLSConfig(tlsConfig)
+	}
+	return &brokerV3{options: options}
+}
+
+func (b *brokerV3) connect(topics map[string]byte, handler func(topic string, payload []byte, r *reply)) error {
+	// subscribing again on every connection, as a clean session forgets about it
+	b.options.SetOnConnectHandler(func(client paho.Client) {
+		log.Infof("MQTT client connected")
+		for topic, qos := range topics {
+			token := client.Subscribe(topic, qos, func(_ paho.Client, m paho.Message) {
+				handler(m.Topic(), m.Payload(), nil)
+			})
+			go func(topic string) {
+				if token.Wait(); token.Error() != nil {
+					log.Errorf("Error subscribing to %s: %v", topic, token.Error())
+				}
+			}(topic)
+		}
+	})
+	b.client = paho.NewClient(b.options)
+	token := b.client.Connect()
+	// the client keeps trying in the background, so a broker that's not up yet is not fatal
+	if !token.WaitTimeout(connectTimeout) {
+		log.Warnf("MQTT broker not reachable yet, still trying")
+		return nil
+	}
+	return token.Error()
+}
+
+func (b *brokerV3) publish(topic string, qos byte, payload []byte, r *reply) error {
+	token := b.client.Publish(topic, qos, false, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return errPublishTimeout
+	}
+	return token.Error()
+}
+
+func (b *brokerV3) disconnect() {
+	if b.client != nil {
+		b.client.Disconnect(250)
+	}
+}
@@ -0,0 +1,108 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	log "github.com/sirupsen/logrus"
+)
+
+// brokerV5 is an MQTT 5 client
+type brokerV5 struct {
+	config  autopaho.ClientConfig
+	manager *autopaho.ConnectionManager
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newBrokerV5(c *Config, tlsConfig *tls.Config) (*brokerV5, error) {
+	server, err := url.Parse(c.General.Url)
+	if err != nil {
+		return nil, err
+	}
+	b := &brokerV5{}
+	b.config = autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{server},
+		TlsCfg:                        tlsConfig,
+		KeepAlive:                     uint16(c.General.Keep_alive_interval),
+		CleanStartOnInitialConnection: c.General.Cleansession,
+		ConnectTimeout:                connectTimeout,
+		OnConnectError: func(err error) {
+			log.Warnf("MQTT connection failed, retrying: %v", err)
+		},
+		ClientConfig: paho.ClientConfig{ClientID: c.General.Client_id},
+	}
+	if c.General.Username != "" {
+		b.config.ConnectUsername = c.General.Username
+		b.config.ConnectPassword = []byte(c.General.Password)
+	}
+	return b, nil
+}
+
+func (b *brokerV5) connect(topics map[string]byte, handler func(topic string, payload []byte, r *reply)) error {
+	subscribe := &paho.Subscribe{}
+	for topic, qos := range topics {
+		subscribe.Subscriptions = append(subscribe.Subscriptions, paho.SubscribeOptions{Topic: topic, QoS: qos})
+	}
+	b.config.OnConnectionUp = func(manager *autopaho.ConnectionManager, _ *paho.Connack) {
+		log.Infof("MQTT client connected")
+		// this must not block, and a clean start forgets the subscriptions
+		go func() {
+			if _, err := manager.Subscribe(b.ctx, subscribe); err != nil {
+				log.Errorf("Error subscribing to the MQTT topics: %v", err)
+			}
+		}()
+	}
+	b.config.OnPublishReceived = []func(paho.PublishReceived) (bool, error){
+		func(received paho.PublishReceived) (bool, error) {
+			p := received.Packet
+			var r *reply
+			if p.Properties != nil && p.Properties.ResponseTopic != "" {
+				r = &reply{topic: p.Properties.ResponseTopic, correlation: p.Properties.CorrelationData}
+			}
+			handler(p.Topic, p.Payload, r)
+			return true, nil
+		},
+	}
+
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	manager, err := autopaho.NewConnection(b.ctx, b.config)
+	if err != nil {
+		b.cancel()
+		return err
+	}
+	b.manager = manager
+	// the client keeps trying in the background, so a broker that's not up yet is not fatal
+	ctx, cancel := context.WithTimeout(b.ctx, connectTimeout)
+	defer cancel()
+	if err := manager.AwaitConnection(ctx); err != nil {
+		log.Warnf("MQTT broker not reachable yet, still trying")
+	}
+	return nil
+}
+
+func (b *brokerV5) publish(topic string, qos byte, payload []byte, r *reply) error {
+	p := &paho.Publish{Topic: topic, QoS: qos, Payload: payload}
+	if r != nil && r.correlation != nil {
+		p.Properties = &paho.PublishProperties{CorrelationData: r.correlation}
+	}
+	ctx, cancel := context.WithTimeout(b.ctx, publishTimeout)
+	defer cancel()
+	if _, err := b.manager.Publish(ctx, p); err != context.DeadlineExceeded {
+		return err
+	}
+	return errPublishTimeout
+}
+
+func (b *brokerV5) disconnect() {
+	if b.manager == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	b.manager.Disconnect(ctx)
+	b.cancel()
+}
@@ -0,0 +1,441 @@
+package pfunix
+
+// Unix sockets transport for the Janus API, same as janus.transport.pfunix,
+// meant for backends running on the same host as the gateway. Each request
+// and each response or event is a single packet, and two kinds of sockets
+// are supported:
+//
+//	SOCK_SEQPACKET  connected clients, whose sessions go away with them
+//	                (unless they can be reclaimed)
+//	SOCK_DGRAM      clients bind a socket of their own to get replies, and
+//	                are told apart by its path; sessions have to be kept
+//	                alive with keepalives, as there's no connection to watch
+//
+// Connected clients get their events through a queue, and are disconnected
+// if they can't keep up; datagrams that can't be sent in time are dropped.
+//
+// The Admin API, if enabled, works the same on a socket of its own.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/session"
+	"github.com/xroger88/go-janus/transports"
+)
+
+const (
+	Package = "janus.transport.pfunix"
+
+	// packets bigger than this are truncated
+	maxMessageSize = 256 * 1024
+	// how long a write to a connected client can take
+	writeTimeout = 10 * time.Second
+	// how long a datagram can wait for room at its destination
+	datagramTimeout = 100 * time.Millisecond
+)
+
+// Config is the content of janus.transport.pfunix.yaml in the configs folder
+type Config struct {
+	General struct {
+		Enabled     bool
+		Path        string
+		Type        string
+		Permissions string
+		Group       string
+	}
+	Admin struct {
+		Admin_enabled     bool
+		Admin_path        string
+		Admin_type        string
+		Admin_permissions string
+		Admin_group       string
+	}
+}
+
+type Transport struct {
+	config Config
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	mutex   sync.Mutex
+	sockets []*socket
+	clients map[*client]bool
+	peers   map[string]*client
+}
+
+// socket is a listening socket, for either the Janus or the Admin API
+type socket struct {
+	path     string
+	admin    bool
+	listener *net.UnixListener // SOCK_SEQPACKET
+	conn     *net.UnixConn     // SOCK_DGRAM
+
+	// the clients of a SOCK_DGRAM socket all write on its conn, and the
+	// write deadline is a property of the conn: one write at a time
+	mutex sync.Mutex
+}
+
+// New returns the Unix sockets transport, to be started with Init
+func New() *Transport {
+	return &Transport{
+		done:    make(chan struct{}),
+		clients: make(map[*client]bool),
+		peers:   make(map[string]*client),
+	}
+}
+
+func init() {
+	transports.Register(New())
+}
+
+func (t *Transport) Package() string { return Package }
+func (t *Transport) Name() string    { return "JANUS Unix Sockets transport plugin" }
+func (t *Transport) Description() string {
+	return "This transport plugin adds Unix Sockets support to the Janus API."
+}
+func (t *Transport) Author() string        { return api.Author }
+func (t *Transport) Version() int          { return api.Version }
+func (t *Transport) VersionString() string { return api.VersionString }
+
+func (t *Transport) IsJanusAPIEnabled() bool { return t.config.General.Enabled }
+func (t *Transport) IsAdminAPIEnabled() bool { return t.config.Admin.Admin_enabled }
+
+// Init reads the configuration and creates the sockets
+func (t *Transport) Init(configPath string) error {
+	c := &t.config
+	c.General.Type = "SOCK_SEQPACKET"
+	c.Admin.Admin_type = "SOCK_SEQPACKET"
+	if err := config.LoadComponent(configPath, Package, c); err != nil {
+		return err
+	}
+	if !c.General.Enabled && !c.Admin.Admin_enabled {
+		return transports.ErrNotEnabled
+	}
+	if c.General.Enabled {
+		if err := t.listen(c.General.Path, c.General.Type, c.General.Permissions, c.General.Group, false); err != nil {
+			t.Destroy()
+			return err
+		}
+	}
+	if c.Admin.Admin_enabled {
+		if err := t.listen(c.Admin.Admin_path, c.Admin.Admin_type, c.Admin.Admin_permissions, c.Admin.Admin_group, true); err != nil {
+			t.Destroy()
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Transport) listen(path, kind, permissions, group string, admin bool) error {
+	if path == "" {
+		return errors.New("missing socket path")
+	}
+	// a socket left behind by a previous run would make us fail
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	s := &socket{path: path, admin: admin}
+	addr := &net.UnixAddr{Name: path}
+	var err error
+	switch strings.ToUpper(kind) {
+	case "SOCK_SEQPACKET":
+		addr.Net = "unixpacket"
+		s.listener, err = net.ListenUnix(addr.Net, addr)
+	case "SOCK_DGRAM":
+		addr.Net = "unixgram"
+		s.conn, err = net.ListenUnixgram(addr.Net, addr)
+	default:
+		return fmt.Errorf("unsupported socket type %q (SOCK_SEQPACKET or SOCK_DGRAM)", kind)
+	}
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	t.sockets = append(t.sockets, s)
+	t.mutex.Unlock()
+	if err := setPermissions(path, permissions, group); err != nil {
+		return err
+	}
+
+	t.wg.Add(1)
+	if s.listener != nil {
+		go t.accept(s)
+	} else {
+		go t.receive(s)
+	}
+	log.Infof("Unix socket listening (%s, %s)", path, kind)
+	return nil
+}
+
+// setPermissions sets the mode (e.g. 0660) and the group of the socket file
+func setPermissions(path, permissions, group string) error {
+	if permissions != "" {
+		mode, err := strconv.ParseUint(permissions, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid permissions %q: %v", permissions, err)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			return err
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gid, _ := strconv.Atoi(g.Gid)
+		if err := os.Chown(path, -1, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Destroy closes the sockets and the connections, and removes the socket files
+func (t *Transport) Destroy() {
+	select {
+	case <-t.done:
+		return
+	default:
+		close(t.done)
+	}
+	t.mutex.Lock()
+	for _, s := range t.sockets {
+		if s.listener != nil {
+			s.listener.Close()
+		} else {
+			s.conn.Close()
+		}
+		os.Remove(s.path)
+	}
+	for c := range t.clients {
+		c.conn.Close()
+	}
+	t.mutex.Unlock()
+	t.wg.Wait()
+	log.Infof("%s destroyed", Package)
+}
+
+func (t *Transport) SendMessage(instance interface{}, requestID interface{}, admin bool, message map[string]interface{}) error {
+	c, ok := instance.(*client)
+	if !ok {
+		return errors.New("invalid Unix socket client")
+	}
+	return c.send(message)
+}
+
+func (t *Transport) SessionCreated(instance interface{}, sessionID uint64) {
+	if c, ok := instance.(*client); ok {
+		t.own(c, sessionID, true)
+	}
+}
+
+func (t *Transport) SessionOver(instance interface{}, sessionID uint64, timeout, claimed bool) {
+	if c, ok := instance.(*client); ok {
+		t.own(c, sessionID, false)
+	}
+}
+
+func (t *Transport) SessionClaimed(instance interface{}, sessionID uint64) {
+	if c, ok := instance.(*client); ok {
+		t.own(c, sessionID, true)
+	}
+}
+
+// own keeps track of the sessions of a client: datagram clients are only
+// remembered as long as they have sessions, since we can't know when they're gone
+func (t *Transport) own(c *client, sessionID uint64, owned bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if owned {
+		c.sessions[sessionID] = true
+	} else {
+		delete(c.sessions, sessionID)
+	}
+	if c.peer == nil {
+		return
+	}
+	if len(c.sessions) > 0 {
+		t.peers[c.peer.Name] = c
+	} else if t.peers[c.peer.Name] == c {
+		delete(t.peers, c.peer.Name)
+	}
+}
+
+// accept takes the connections of a SOCK_SEQPACKET socket
+func (t *Transport) accept(s *socket) {
+	defer t.wg.Done()
+	for {
+		conn, err := s.listener.AcceptUnix()
+		if err != nil {
+			select {
+			case <-t.done:
+			default:
+				log.Errorf("Error accepting connections on %s: %v", s.path, err)
+			}
+			return
+		}
+		c := newClient(conn, nil, s.admin, &sync.Mutex{})
+		c.source = &session.Source{Transport: t, Instance: c}
+		c.queue = transports.NewQueue(Package, fmt.Sprintf("%p", c), 0)
+		c.closed = make(chan struct{})
+		t.mutex.Lock()
+		t.clients[c] = true
+		t.mutex.Unlock()
+		t.wg.Add(2)
+		go t.serve(c)
+		go t.writer(c)
+	}
+}
+
+// serve handles the requests of a SOCK_SEQPACKET client until it goes away
+func (t *Transport) serve(c *client) {
+	defer t.wg.Done()
+	log.Infof("Unix socket client connected (%s)", c)
+	buffer := make([]byte, maxMessageSize)
+	for {
+		n, err := c.conn.Read(buffer)
+		if err != nil || n == 0 {
+			break
+		}
+		t.request(c, buffer[:n])
+	}
+	c.conn.Close()
+	close(c.closed)
+	t.mutex.Lock()
+	delete(t.clients, c)
+	t.mutex.Unlock()
+	session.TransportGone(c.source)
+	log.Infof("Unix socket client disconnected (%s)", c)
+}
+
+// writer sends the queued messages to a SOCK_SEQPACKET client
+func (t *Transport) writer(c *client) {
+	defer t.wg.Done()
+	for {
+		messages := c.queue.Wait(context.Background(), c.closed, 100, time.Hour)
+		select {
+		case <-c.closed:
+			return
+		default:
+		}
+		for _, message := range messages {
+			if err := c.write(message, writeTimeout); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// receive handles the datagrams sent to a SOCK_DGRAM socket
+func (t *Transport) receive(s *socket) {
+	defer t.wg.Done()
+	buffer := make([]byte, maxMessageSize)
+	for {
+		n, peer, err := s.conn.ReadFromUnix(buffer)
+		if err != nil {
+			select {
+			case <-t.done:
+			default:
+				log.Errorf("Error receiving on %s: %v", s.path, err)
+			}
+			return
+		}
+		if peer == nil || peer.Name == "" {
+			log.Warnf("Ignoring a request from an unbound socket on %s, nowhere to reply to", s.path)
+			continue
+		}
+		t.mutex.Lock()
+		c := t.peers[peer.Name]
+		t.mutex.Unlock()
+		if c == nil || c.admin != s.admin {
+			c = newClient(s.conn, peer, s.admin, &s.mutex)
+			c.source = &session.Source{Transport: t, Instance: c}
+		}
+		t.request(c, buffer[:n])
+	}
+}
+
+func (t *Transport) request(c *client, data []byte) {
+	log.Debugf("Unix socket request: %s", data)
+	api.IncomingRequest(c.source, nil, c.admin, data)
+}
+
+// client is a connected SOCK_SEQPACKET peer, or the bound socket of a SOCK_DGRAM one
+type client struct {
+	conn     *net.UnixConn
+	peer     *net.UnixAddr
+	admin    bool
+	source   *session.Source
+	sessions map[uint64]bool
+
+	// SOCK_SEQPACKET only
+	queue  *transports.Queue
+	closed chan struct{}
+
+	// taken for writing: the client's own, or the one of the SOCK_DGRAM socket
+	mutex *sync.Mutex
+}
+
+func newClient(conn *net.UnixConn, peer *net.UnixAddr, admin bool, mutex *sync.Mutex) *client {
+	return &client{conn: conn, peer: peer, admin: admin, sessions: make(map[uint64]bool), mutex: mutex}
+}
+
+// String names the client in logs: the path of its socket if it has one,
+// which connected clients usually don't
+func (c *client) String() string {
+	if c.peer != nil {
+		return c.peer.Name
+	}
+	if addr, ok := c.conn.RemoteAddr().(*net.UnixAddr); ok && addr != nil && addr.Name != "" && addr.Name != "@" {
+		return addr.Name
+	}
+	return fmt.Sprintf("%p", c)
+}
+
+func (c *client) send(message map[string]interface{}) error {
+	if c.queue == nil {
+		err := c.write(message, datagramTimeout)
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			log.Warnf("Unix socket client %s is not reading, dropping %v message", c, message["janus"])
+			transports.Dropped(Package, 1)
+		}
+		return err
+	}
+	if err := c.queue.Push(message); err != nil {
+		log.Warnf("Unix socket client %s: %v, closing the connection", c, err)
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *client) write(message map[string]interface{}, timeout time.Duration) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if c.peer != nil {
+		_, err = c.conn.WriteToUnix(data, c.peer)
+	} else {
+		_, err = c.conn.Write(data)
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package pfunix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTransport runs the transport with a Janus API socket of the given type
+func startTransport(t *testing.T, kind string) string {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "janusapi")
+	conf := fmt.Sprintf("general:\n  enabled: yes\n  path: %s\n  type: %s\n", path, kind)
+	if err := ioutil.WriteFile(filepath.Join(dir, Package+".yaml"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr := New()
+	if err := tr.Init(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tr.Destroy)
+	return path
+}
+
+// ping sends a ping on conn (to path, for datagrams) and checks the pong
+func ping(t *testing.T, conn *net.UnixConn, path string, transaction string) error {
+	request := []byte(`{"janus":"ping","transaction":"` + transaction + `"}`)
+	var err error
+	if path != "" {
+		_, err = conn.WriteToUnix(request, &net.UnixAddr{Name: path, Net: "unixgram"})
+	} else {
+		_, err = conn.Write(request)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 4096)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return err
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(buffer[:n], &response); err != nil {
+		return err
+	}
+	if response["janus"] != "pong" || response["transaction"] != transaction {
+		return fmt.Errorf("got %s, want the pong of %s", buffer[:n], transaction)
+	}
+	return nil
+}
+
+func TestSeqpacket(t *testing.T) {
+	path := startTransport(t, "SOCK_SEQPACKET")
+	conn, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 10; i++ {
+		if err := ping(t, conn, "", fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Datagram clients all get their replies through the same socket: each
+// must get its own, however many are talking at the same time
+func TestDatagramClients(t *testing.T) {
+	path := startTransport(t, "SOCK_DGRAM")
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		local := &net.UnixAddr{Name: filepath.Join(dir, fmt.Sprint("client", i)), Net: "unixgram"}
+		conn, err := net.ListenUnixgram("unixgram", local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := ping(t, conn, path, fmt.Sprintf("%d-%d", i, j)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,84 @@
+package transports
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Publisher queues the events of a transport that talks to a broker, and
+// publishes them from a goroutine of its own, so that a slow broker doesn't
+// hold up the handles: if it can't keep up, the queue overflows and the
+// session whose event didn't fit is cut off from the transport, like that
+// of a client that went away. Sessions are shared by all the clients of the
+// broker, so there's nothing to track about them: embedded in the
+// transport, the Publisher takes care of that too.
+type Publisher struct {
+	transport string
+	id        string // of the client, in the events about the queue
+	publish   func(event map[string]interface{}) error
+
+	mutex  sync.Mutex
+	events *Queue
+}
+
+// NewPublisher returns a publisher for a transport, which publishes an event with publish
+func NewPublisher(transport, id string, publish func(event map[string]interface{}) error) *Publisher {
+	return &Publisher{transport: transport, id: id, publish: publish, events: NewQueue(transport, id, 0)}
+}
+
+func (p *Publisher) queue() *Queue {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.events
+}
+
+// Run publishes the queued events until done is closed: after an overflow,
+// what's left in the old queue goes out before the events queued since
+func (p *Publisher) Run(done <-chan struct{}) {
+	q := p.queue()
+	for {
+		events := q.Wait(context.Background(), done, 100, time.Hour)
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if len(events) == 0 {
+			q = p.queue()
+			continue
+		}
+		for _, event := range events {
+			if err := p.publish(event); err != nil {
+				log.Warnf("Couldn't publish %v event: %v", event["janus"], err)
+				Dropped(p.transport, 1)
+			}
+		}
+	}
+}
+
+// Push queues an event. When the queue overflows it's replaced by a new one,
+// and the session of the event can only be claimed back (if the reclaim
+// timeout allows it) or destroyed.
+func (p *Publisher) Push(event map[string]interface{}) error {
+	p.mutex.Lock()
+	err := p.events.Push(event)
+	if err != nil {
+		p.events = NewQueue(p.transport, p.id, 0)
+	}
+	p.mutex.Unlock()
+	if err != nil {
+		sessionID, _ := event["session_id"].(uint64)
+		log.Warnf("Too many events queued for the %s broker, it's too slow for session %d", p.transport, sessionID)
+		orphan(sessionID)
+	}
+	return err
+}
+
+func (p *Publisher) SessionCreated(instance interface{}, sessionID uint64) {}
+
+func (p *Publisher) SessionOver(instance interface{}, sessionID uint64, timeout, claimed bool) {}
+
+func (p *Publisher) SessionClaimed(instance interface{}, sessionID uint64) {}
@@ -0,0 +1,83 @@
+package transports
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/session"
+)
+
+func TestPublisher(t *testing.T) {
+	smallQueues(t, 2)
+	var mutex sync.Mutex
+	var published []map[string]interface{}
+	publishing, blocked := make(chan struct{}, 1), make(chan struct{})
+	p := NewPublisher("test", "client", func(event map[string]interface{}) error {
+		select {
+		case publishing <- struct{}{}:
+		default:
+		}
+		<-blocked
+		mutex.Lock()
+		defer mutex.Unlock()
+		published = append(published, event)
+		return nil
+	})
+	source := &session.Source{Transport: pollingTransport{NewSessionQueues("test")}}
+	slow, err := session.New(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := session.New(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Destroy(false) })
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		p.Run(done)
+		close(stopped)
+	}()
+	// the first one is taken by the publisher, which is stuck publishing it
+	p.Push(map[string]interface{}{"janus": "event", "session_id": other.ID, "n": 0})
+	<-publishing
+	for i := 1; i <= 2; i++ {
+		if err := p.Push(map[string]interface{}{"janus": "event", "session_id": other.ID, "n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Push(map[string]interface{}{"janus": "event", "session_id": slow.ID, "n": 3}); err != ErrQueueFull {
+		t.Fatalf("got error %v, want %v", err, ErrQueueFull)
+	}
+	gone(t, slow)
+	if other.Destroyed() {
+		t.Error("got another session cut off")
+	}
+	if err := p.Push(map[string]interface{}{"janus": "event", "session_id": other.ID, "n": 4}); err != nil {
+		t.Fatalf("got error %v after an overflow", err)
+	}
+
+	// what was queued before the overflow still goes out, first
+	close(blocked)
+	want := []string{"event0", "event1", "event2", "event4"}
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		mutex.Lock()
+		n := len(published)
+		mutex.Unlock()
+		if n >= len(want) {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("got %d events published, want %d", n, len(want))
+		}
+	}
+	close(done)
+	<-stopped
+	if got := numbers(published); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v published, want %v", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xroger88/go-janus/config"
+)
+
+// DefaultMaxQueuedEvents is the limit of a queue when transports.max_queued_events isn't set
+const DefaultMaxQueuedEvents = 1000
+
+// ErrQueueFull is returned when an event can't be queued: the client is too
+// slow to keep up, and the transport should get rid of it
+var ErrQueueFull = errors.New("too many queued events")
+
+// Queue holds the events for a client until it takes them, e.g. with a long
+// poll, or until a writer sends them. Queues are bounded: low priority events
+// (media and slowlink) only keep their latest value, and are dropped when the
+// queue is half full, to leave room for the others; when there's no room left
+// at all, the queue overflows and gets closed.
+type Queue struct {
+	transport string // package of the transport, for metrics and events
+	id        string // who the events are for, in the events about the queue
+	max       int
+
+	mutex    sync.Mutex
+	events   []map[string]interface{}
+	closed   bool
+	overflow bool
+	slow     bool // whether low priority events are being dropped
+	dropped  int
+	signal   chan struct{}
+}
+
+// NewQueue returns an empty queue: max is the most events it can hold, or
+// 0 for transports.max_queued_events
+func NewQueue(transport, id string, max int) *Queue {
+	if max <= 0 {
+		max = config.Conf.Transports.Max_queued_events
+	}
+	if max <= 0 {
+		max = DefaultMaxQueuedEvents
+	}
+	return &Queue{transport: transport, id: id, max: max, signal: make(chan struct{}, 1)}
+}
+
+func (q *Queue) wake() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// lowPriority tells whether an event can be coalesced with or dropped in
+// favour of others: the key identifies what it's about, so that only the
+// latest one about the same thing is kept
+func lowPriority(event map[string]interface{}) (string, bool) {
+	switch kind := event["janus"]; kind {
+	case "media", "slowlink":
+		return fmt.Sprint(kind, "/", event["sender"], "/", event["type"], "/", event["mid"], "/", event["media"], "/", event["uplink"]), true
+	}
+	return "", false
+}
+
+// Push adds an event to the queue. It returns ErrQueueFull when the queue
+// overflows (and for any event pushed after that), in which case the client
+// should be disconnected. Events pushed after Close are dropped, as nobody
+// would take them.
+func (q *Queue) Push(event map[string]interface{}) error {
+	key, low := lowPriority(event)
+	q.mutex.Lock()
+	if q.overflow {
+		q.mutex.Unlock()
+		return ErrQueueFull
+	}
+	if q.closed {
+		q.mutex.Unlock()
+		droppedEvents.WithLabelValues(q.transport).Inc()
+		return nil
+	}
+	if low {
+		for i, e := range q.events {
+			if k, ok := lowPriority(e); ok && k == key {
+				q.events[i] = event
+				q.mutex.Unlock()
+				coalescedEvents.WithLabelValues(q.transport).Inc()
+				return nil
+			}
+		}
+	}
+	var slow, overflow bool
+	if low && len(q.events) >= q.max/2 {
+		slow = q.drop()
+	} else {
+		if len(q.events) >= q.max {
+			// make room by dropping the oldest low priority event, if any
+			if i := q.oldestLowPriority(); i >= 0 {
+				q.events = append(q.events[:i], q.events[i+1:]...)
+				slow = q.drop()
+			} else {
+				q.overflow, q.closed, overflow = true, true, true
+			}
+		}
+		if !overflow {
+			q.events = append(q.events, event)
+		}
+	}
+	queued, dropped := len(q.events), q.dropped
+	q.mutex.Unlock()
+	q.wake()
+
+	if slow {
+		Notify(q.transport, q.id, map[string]interface{}{"event": "slow", "queued": queued, "max": q.max})
+	}
+	if overflow {
+		overflows.WithLabelValues(q.transport).Inc()
+		Notify(q.transport, q.id, map[string]interface{}{"event": "overflow", "queued": queued, "dropped": dropped})
+		return ErrQueueFull
+	}
+	return nil
+}
+
+func (q *Queue) oldestLowPriority() int {
+	for i, e := range q.events {
+		if _, ok := lowPriority(e); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// drop counts a dropped event, and tells whether the client just became slow
+func (q *Queue) drop() bool {
+	q.dropped++
+	droppedEvents.WithLabelValues(q.transport).Inc()
+	if q.slow {
+		return false
+	}
+	q.slow = true
+	return true
+}
+
+// Close wakes up the pollers, which get whatever is left and then nothing more
+func (q *Queue) Close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	q.wake()
+}
+
+// pop takes up to max events, and tells whether the queue is closed
+func (q *Queue) pop(max int) ([]map[string]interface{}, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	n := len(q.events)
+	if n > max {
+		n = max
+	}
+	events := make([]map[string]interface{}, n)
+	copy(events, q.events)
+	q.events = q.events[n:]
+	if len(q.events) > 0 || q.closed {
+		// someone else may be waiting too
+		q.wake()
+	} else {
+		// the client caught up
+		q.slow = false
+	}
+	return events, q.closed
+}
+
+// Wait returns up to max events, waiting for them until the timeout expires,
+// the client goes away or the transport is shutting down
+func (q *Queue) Wait(ctx context.Context, done <-chan struct{}, max int, timeout time.Duration) []map[string]interface{} {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		events, closed := q.pop(max)
+		if len(events) > 0 || closed {
+			return events
+		}
+		select {
+		case <-q.signal:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			return nil
+		}
+	}
+}
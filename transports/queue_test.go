@@ -0,0 +1,199 @@
+package transports
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func event(kind string, n int) map[string]interface{} {
+	return map[string]interface{}{"janus": kind, "sender": 1, "n": n}
+}
+
+func media(n int, kind string) map[string]interface{} {
+	return map[string]interface{}{"janus": "media", "sender": 1, "type": kind, "receiving": true, "n": n}
+}
+
+// numbers tells which events the queue has, in order
+func numbers(events []map[string]interface{}) []string {
+	var n []string
+	for _, e := range events {
+		n = append(n, fmt.Sprint(e["janus"], e["n"]))
+	}
+	return n
+}
+
+func value(t *testing.T, counter *prometheus.CounterVec, transport string) float64 {
+	var m dto.Metric
+	if err := counter.WithLabelValues(transport).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestQueue(t *testing.T) {
+	tests := []struct {
+		name      string
+		events    []map[string]interface{}
+		want      []string
+		err       int // the first push to fail, or -1
+		dropped   float64
+		coalesced float64
+	}{
+		{
+			name:   "in order",
+			events: []map[string]interface{}{event("event", 0), event("webrtcup", 1), event("hangup", 2)},
+			want:   []string{"event0", "webrtcup1", "hangup2"},
+			err:    -1,
+		},
+		{
+			name:   "bounded",
+			events: []map[string]interface{}{event("event", 0), event("event", 1), event("event", 2), event("event", 3), event("event", 4), event("event", 5)},
+			want:   []string{"event0", "event1", "event2", "event3"},
+			err:    4,
+		},
+		{
+			name:      "latest media event only",
+			events:    []map[string]interface{}{media(0, "audio"), event("event", 1), media(2, "audio")},
+			want:      []string{"media2", "event1"},
+			err:       -1,
+			coalesced: 1,
+		},
+		{
+			name:   "media events about different things",
+			events: []map[string]interface{}{media(0, "audio"), media(1, "video")},
+			want:   []string{"media0", "media1"},
+			err:    -1,
+		},
+		{
+			name:    "low priority dropped when half full",
+			events:  []map[string]interface{}{event("event", 0), event("event", 1), media(2, "audio"), event("event", 3)},
+			want:    []string{"event0", "event1", "event3"},
+			err:     -1,
+			dropped: 1,
+		},
+		{
+			name:    "low priority dropped to make room",
+			events:  []map[string]interface{}{media(0, "audio"), event("event", 1), event("event", 2), event("event", 3), event("event", 4)},
+			want:    []string{"event1", "event2", "event3", "event4"},
+			err:     -1,
+			dropped: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// a transport of its own, for metrics of its own
+			transport := "test." + t.Name()
+			dropped, coalesced, overflowed := value(t, droppedEvents, transport), value(t, coalescedEvents, transport), value(t, overflows, transport)
+			q := NewQueue(transport, "client", 4)
+			failed := -1
+			for i, e := range test.events {
+				err := q.Push(e)
+				if err != nil && failed < 0 {
+					failed = i
+				}
+				if failed >= 0 && err != ErrQueueFull {
+					t.Fatalf("push %d after an overflow: got error %v, want %v", i, err, ErrQueueFull)
+				}
+			}
+			if failed != test.err {
+				t.Errorf("push %d failed, want %d", failed, test.err)
+			}
+			got := numbers(q.Wait(context.Background(), nil, 10, time.Second))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got events %v, want %v", got, test.want)
+			}
+			if v := value(t, droppedEvents, transport) - dropped; v != test.dropped {
+				t.Errorf("got %v dropped events, want %v", v, test.dropped)
+			}
+			if v := value(t, coalescedEvents, transport) - coalesced; v != test.coalesced {
+				t.Errorf("got %v coalesced events, want %v", v, test.coalesced)
+			}
+			want := 0.0
+			if test.err >= 0 {
+				want = 1
+			}
+			if v := value(t, overflows, transport) - overflowed; v != want {
+				t.Errorf("got %v overflows, want %v", v, want)
+			}
+		})
+	}
+}
+
+// An overflowing queue is closed: what's left can still be taken, but
+// there's no waiting for more
+func TestQueueOverflowCloses(t *testing.T) {
+	q := NewQueue("test", "client", 1)
+	q.Push(event("event", 0))
+	if err := q.Push(event("event", 1)); err != ErrQueueFull {
+		t.Fatalf("got error %v, want %v", err, ErrQueueFull)
+	}
+	if got := numbers(q.Wait(context.Background(), nil, 10, time.Second)); !reflect.DeepEqual(got, []string{"event0"}) {
+		t.Errorf("got events %v, want the one queued before the overflow", got)
+	}
+	start := time.Now()
+	if events := q.Wait(context.Background(), nil, 10, time.Second); len(events) != 0 || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("got %v after %v waiting on a closed queue", events, time.Since(start))
+	}
+}
+
+func TestQueueWait(t *testing.T) {
+	q := NewQueue("test", "client", 0)
+	if q.max != DefaultMaxQueuedEvents {
+		t.Errorf("got a limit of %d, want %d", q.max, DefaultMaxQueuedEvents)
+	}
+	if events := q.Wait(context.Background(), nil, 10, 10*time.Millisecond); events != nil {
+		t.Errorf("got %v from an empty queue", events)
+	}
+
+	got := make(chan []map[string]interface{})
+	go func() { got <- q.Wait(context.Background(), nil, 2, time.Minute) }()
+	time.Sleep(10 * time.Millisecond)
+	q.Push(event("event", 0))
+	if events := numbers(<-got); !reflect.DeepEqual(events, []string{"event0"}) {
+		t.Errorf("got %v, want the event pushed while waiting", events)
+	}
+
+	for i := 1; i <= 3; i++ {
+		q.Push(event("event", i))
+	}
+	if events := numbers(q.Wait(context.Background(), nil, 2, time.Minute)); !reflect.DeepEqual(events, []string{"event1", "event2"}) {
+		t.Errorf("got %v, want no more than 2 events", events)
+	}
+	q.Wait(context.Background(), nil, 2, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { got <- q.Wait(ctx, nil, 2, time.Minute) }()
+	cancel()
+	if events := <-got; events != nil {
+		t.Errorf("got %v from a cancelled wait", events)
+	}
+	done := make(chan struct{})
+	go func() { got <- q.Wait(context.Background(), done, 2, time.Minute) }()
+	close(done)
+	if events := <-got; events != nil {
+		t.Errorf("got %v while shutting down", events)
+	}
+	go func() { got <- q.Wait(context.Background(), nil, 2, time.Minute) }()
+	q.Close()
+	if events := <-got; len(events) != 0 {
+		t.Errorf("got %v from a closed queue", events)
+	}
+}
+
+func TestQueuePushAfterClose(t *testing.T) {
+	q := NewQueue("test", "client", 0)
+	q.Push(event("event", 0))
+	q.Close()
+	if err := q.Push(event("event", 1)); err != nil {
+		t.Fatalf("got error %v pushing to a closed queue", err)
+	}
+	if got := numbers(q.Wait(context.Background(), nil, 10, time.Second)); !reflect.DeepEqual(got, []string{"event0"}) {
+		t.Errorf("got events %v, want the one pushed before closing", got)
+	}
+}
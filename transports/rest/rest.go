@@ -0,0 +1,373 @@
+package rest
+
+// HTTP REST transport for the Janus API, same as janus.transport.http:
+//
+//	POST /janus                      requests with no session (create, info, ...)
+//	POST /janus/<session>            session requests (attach, keepalive, destroy, ...)
+//	POST /janus/<session>/<handle>   handle requests (message, trickle, detach, ...)
+//	GET  /janus/<session>            long poll for events (maxev and rid parameters)
+//	GET  /janus/info                 server info
+//	GET  /admin/metrics              Prometheus metrics (if admin_metrics is set)
+//
+// The response to a POST is the body of its HTTP response, while events are
+// queued per session and returned to long polls. A long poll also counts as
+// a keepalive for the session, so polling clients never time out.
+// The Admin API, if enabled, works the same on its own base path (and port).
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/cmdflag"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/session"
+	"github.com/xroger88/go-janus/transports"
+	"github.com/xroger88/go-janus/transports/web"
+	"github.com/xroger88/go-janus/util"
+)
+
+const (
+	Package         = "janus.transport.http"
+	longPollTimeout = 30 * time.Second
+	maxRequestSize  = 1 << 20
+)
+
+// Config is the content of janus.transport.http.yaml in the configs folder
+type Config struct {
+	General struct {
+		Base_path string
+		Http      bool
+		Port      int
+		Interface string
+
+		Https            bool
+		Secure_port      int
+		Secure_interface string
+	}
+	Admin struct {
+		Admin_base_path string
+		Admin_http      bool
+		Admin_port      int
+		Admin_interface string
+
+		Admin_https            bool
+		Admin_secure_port      int
+		Admin_secure_interface string
+		Admin_client_ca        string
+
+		Admin_metrics bool
+	}
+	Certificates config.TLS
+	Cors         web.CORS
+	Acl          web.ACL
+}
+
+type Transport struct {
+	config  Config
+	source  *session.Source
+	filter  *web.Filter
+	servers []*http.Server
+	done    chan struct{}
+
+	*transports.SessionQueues
+}
+
+// New returns the HTTP transport, to be started with Init
+func New() *Transport {
+	t := &Transport{
+		done:          make(chan struct{}),
+		SessionQueues: transports.NewSessionQueues(Package),
+	}
+	t.source = &session.Source{Transport: t}
+	return t
+}
+
+func init() {
+	transports.Register(New())
+}
+
+func (t *Transport) Package() string { return Package }
+func (t *Transport) Name() string    { return "JANUS REST (HTTP/HTTPS) transport plugin" }
+func (t *Transport) Description() string {
+	return "This transport plugin adds REST (HTTP/HTTPS) support to the Janus API."
+}
+func (t *Transport) Author() string        { return api.Author }
+func (t *Transport) Version() int          { return api.Version }
+func (t *Transport) VersionString() string { return api.VersionString }
+
+func (t *Transport) IsJanusAPIEnabled() bool {
+	return t.config.General.Http || t.config.General.Https
+}
+func (t *Transport) IsAdminAPIEnabled() bool {
+	return t.config.Admin.Admin_http || t.config.Admin.Admin_https
+}
+
+// Init reads the configuration and starts the web servers
+func (t *Transport) Init(configPath string) error {
+	c := &t.config
+	c.General.Base_path = "/janus"
+	c.General.Http = true
+	c.Admin.Admin_base_path = "/admin"
+	c.General.Secure_port = 8089
+	c.Admin.Admin_port = 7088
+	c.Admin.Admin_secure_port = 7889
+	if err := config.LoadComponent(configPath, Package, c); err != nil {
+		return err
+	}
+	// the port given on the command line wins over the configuration file
+	if c.General.Port == 0 || cmdflag.Flags.Http_port != cmdflag.DEF_HTTP_PORT {
+		c.General.Port = int(cmdflag.Flags.Http_port)
+	}
+	if !c.General.Http && !c.General.Https && !c.Admin.Admin_http && !c.Admin.Admin_https {
+		return transports.ErrNotEnabled
+	}
+	var err error
+	if t.filter, err = c.Acl.Compile(); err != nil {
+		return err
+	}
+
+	// the APIs may share the same port, in which case they share the server too
+	type server struct {
+		mux    *http.ServeMux
+		secure bool
+		admin  bool
+	}
+	servers := make(map[string]*server)
+	endpoint := func(ip string, port int, basePath string, admin, secure bool) error {
+		addr := net.JoinHostPort(ip, strconv.Itoa(port))
+		s := servers[addr]
+		if s == nil {
+			s = &server{mux: http.NewServeMux(), secure: secure}
+			servers[addr] = s
+		} else if s.secure != secure {
+			return fmt.Errorf("can't serve both HTTP and HTTPS on %s", addr)
+		}
+		s.admin = s.admin || admin
+		basePath = "/" + strings.Trim(basePath, "/")
+		s.mux.Handle(basePath, t.handler(basePath, admin))
+		s.mux.Handle(basePath+"/", t.handler(basePath, admin))
+		return nil
+	}
+	var errs []error
+	if c.General.Http {
+		errs = append(errs, endpoint(c.General.Interface, c.General.Port, c.General.Base_path, false, false))
+	}
+	if c.General.Https {
+		errs = append(errs, endpoint(c.General.Secure_interface, c.General.Secure_port, c.General.Base_path, false, true))
+	}
+	if c.Admin.Admin_http {
+		errs = append(errs, endpoint(c.Admin.Admin_interface, c.Admin.Admin_port, c.Admin.Admin_base_path, true, false))
+	}
+	if c.Admin.Admin_https {
+		errs = append(errs, endpoint(c.Admin.Admin_secure_interface, c.Admin.Admin_secure_port, c.Admin.Admin_base_path, true, true))
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	for addr, s := range servers {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Destroy()
+			return err
+		}
+		server := &http.Server{Handler: s.mux}
+		scheme := "HTTP"
+		if s.secure {
+			// client certificates are only asked for on the Admin API servers
+			clientCA := ""
+			if s.admin {
+				clientCA = c.Admin.Admin_client_ca
+			}
+			if server.TLSConfig, err = c.Certificates.ServerConfig(clientCA); err != nil {
+				listener.Close()
+				t.Destroy()
+				return err
+			}
+			listener = tls.NewListener(listener, server.TLSConfig)
+			scheme = "HTTPS"
+		}
+		t.servers = append(t.servers, server)
+		go server.Serve(listener)
+		log.Infof("%s webserver started (%s)", scheme, addr)
+	}
+	return nil
+}
+
+// Destroy closes the pending long polls and stops the web servers
+func (t *Transport) Destroy() {
+	select {
+	case <-t.done:
+		return
+	default:
+		close(t.done)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, server := range t.servers {
+		server.Shutdown(ctx)
+	}
+	log.Infof("%s destroyed", Package)
+}
+
+// SendMessage gets responses and events from the core: responses go back to
+// the HTTP request waiting for them, events to the queue of their session
+func (t *Transport) SendMessage(instance interface{}, requestID interface{}, admin bool, message map[string]interface{}) error {
+	if response, ok := requestID.(chan map[string]interface{}); ok {
+		response <- message
+		return nil
+	}
+	return t.Push(message)
+}
+
+// request passes a request to the core and returns the response
+func (t *Transport) request(admin bool, data []byte) map[string]interface{} {
+	response := make(chan map[string]interface{}, 1)
+	api.IncomingRequest(t.source, response, admin, data)
+	select {
+	case message := <-response:
+		return message
+	default:
+		return api.ErrorResponse(0, "", api.ErrorUnknown, "No response from the core")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "   ")
+	enc.Encode(v)
+}
+
+func (t *Transport) handler(basePath string, admin bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := t.filter.ClientIP(r)
+		if !t.filter.Allowed(ip, admin) {
+			log.Warnf("[%s] %s %s refused by the ACL", ip, r.Method, r.URL.Path)
+			writeJSON(w, http.StatusForbidden, api.ErrorResponse(0, "", api.ErrorUnauthorized, "Address not allowed"))
+			return
+		}
+		if t.config.Cors.Handle(w, r) {
+			return
+		}
+		log.Debugf("[%s] %s %s", ip, r.Method, r.URL.Path)
+
+		// /<base path>[/<session>[/<handle>]]
+		var path []string
+		for _, p := range strings.Split(strings.TrimPrefix(r.URL.Path, basePath), "/") {
+			if p != "" {
+				path = append(path, p)
+			}
+		}
+		if len(path) == 1 && path[0] == "info" && r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, t.request(admin, []byte(`{"janus":"info","transaction":"`+transaction()+`"}`)))
+			return
+		}
+		if admin && t.config.Admin.Admin_metrics && len(path) == 1 && path[0] == "metrics" && r.Method == http.MethodGet {
+			transports.MetricsHandler().ServeHTTP(w, r)
+			return
+		}
+		var ids [2]uint64
+		for i, p := range path {
+			id, err := strconv.ParseUint(p, 10, 64)
+			if i >= len(ids) || err != nil || id == 0 {
+				writeJSON(w, http.StatusNotFound, api.ErrorResponse(0, "", api.ErrorInvalidRequestPath, "Invalid path"))
+				return
+			}
+			ids[i] = id
+		}
+		sessionID, handleID := ids[0], ids[1]
+
+		switch r.Method {
+		case http.MethodGet:
+			if admin || sessionID == 0 || handleID != 0 {
+				writeJSON(w, http.StatusMethodNotAllowed, api.ErrorResponse(0, "", api.ErrorInvalidRequestPath, "GET is only allowed on sessions, to poll for events"))
+				return
+			}
+			t.longPoll(w, r, sessionID)
+		case http.MethodPost:
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+			if err != nil {
+				writeJSON(w, http.StatusRequestEntityTooLarge, api.ErrorResponse(0, "", api.ErrorTransportSpecific, err.Error()))
+				return
+			}
+			writeJSON(w, http.StatusOK, t.request(admin, inject(body, sessionID, handleID)))
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, api.ErrorResponse(0, "", api.ErrorTransportSpecific, "Unsupported method "+r.Method))
+		}
+	}
+}
+
+// inject adds the session and handle IDs taken from the path to the request
+func inject(body []byte, sessionID, handleID uint64) []byte {
+	if sessionID == 0 {
+		return body
+	}
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(body, &root); err != nil || root == nil {
+		// let the core complain about it
+		return body
+	}
+	root["session_id"] = json.RawMessage(strconv.FormatUint(sessionID, 10))
+	if handleID != 0 {
+		root["handle_id"] = json.RawMessage(strconv.FormatUint(handleID, 10))
+	}
+	data, _ := json.Marshal(root)
+	return data
+}
+
+func transaction() string {
+	return strconv.FormatUint(util.RandomUint64(), 36)
+}
+
+func (t *Transport) longPoll(w http.ResponseWriter, r *http.Request, sessionID uint64) {
+	query := r.URL.Query()
+	maxev := 1
+	if n, err := strconv.Atoi(query.Get("maxev")); err == nil && n > 1 {
+		maxev = n
+	}
+
+	// polling keeps the session alive, and checks it exists and the client can use it
+	keepalive := map[string]interface{}{
+		"janus":       "keepalive",
+		"session_id":  sessionID,
+		"transaction": transaction(),
+	}
+	for _, key := range []string{"apisecret", "token"} {
+		if value := query.Get(key); value != "" {
+			keepalive[key] = value
+		}
+	}
+	data, _ := json.Marshal(keepalive)
+	if response := t.request(false, data); response["janus"] == "error" {
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+	q := t.Queue(sessionID)
+	if q == nil {
+		writeJSON(w, http.StatusOK, api.ErrorResponse(sessionID, "", api.ErrorSessionNotFound, fmt.Sprintf("No such session %d", sessionID)))
+		return
+	}
+
+	events := q.Wait(r.Context(), t.done, maxev, longPollTimeout)
+	if len(events) == 0 {
+		events = append(events, map[string]interface{}{"janus": "keepalive"})
+	}
+	if maxev == 1 {
+		writeJSON(w, http.StatusOK, events[0])
+	} else {
+		writeJSON(w, http.StatusOK, events)
+	}
+}
@@ -0,0 +1,150 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/api"
+)
+
+// startTransport serves the Janus API of a transport on a test server
+func startTransport(t *testing.T) (*Transport, *httptest.Server) {
+	tr := New()
+	var err error
+	if tr.filter, err = tr.config.Acl.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(tr.handler("/janus", false))
+	t.Cleanup(func() {
+		// the pending long polls end first
+		tr.Destroy()
+		server.Close()
+	})
+	return tr, server
+}
+
+func do(t *testing.T, method, url, body string) (int, interface{}) {
+	t.Helper()
+	r, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var v interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, v
+}
+
+// message is a response or an event that's a JSON object
+func message(t *testing.T, v interface{}) map[string]interface{} {
+	t.Helper()
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		t.Fatalf("got %v, want an object", v)
+	}
+	return m
+}
+
+func code(m map[string]interface{}) int {
+	e, _ := m["error"].(map[string]interface{})
+	c, _ := e["code"].(float64)
+	return int(c)
+}
+
+func TestLongPoll(t *testing.T) {
+	tr, server := startTransport(t)
+	_, v := do(t, http.MethodPost, server.URL+"/janus", `{"janus":"create","transaction":"t"}`)
+	data, _ := message(t, v)["data"].(map[string]interface{})
+	id, _ := data["id"].(float64)
+	if id == 0 {
+		t.Fatalf("got %v creating a session", v)
+	}
+	sessionID := uint64(id)
+	url := fmt.Sprintf("%s/janus/%d", server.URL, sessionID)
+	event := func(n int) map[string]interface{} {
+		return map[string]interface{}{"janus": "event", "session_id": sessionID, "n": n}
+	}
+
+	// what's queued goes to the polls, maxev at a time
+	for i := 0; i < 3; i++ {
+		if err := tr.Push(event(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, v = do(t, http.MethodGet, url+"?maxev=2", "")
+	if events, _ := v.([]interface{}); len(events) != 2 || message(t, events[0])["n"] != 0.0 || message(t, events[1])["n"] != 1.0 {
+		t.Errorf("got %v, want the first two events", v)
+	}
+	if _, v := do(t, http.MethodGet, url, ""); message(t, v)["n"] != 2.0 {
+		t.Errorf("got %v, want the last event", v)
+	}
+
+	// a poll waits for what comes next
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		tr.Push(event(3))
+	}()
+	if _, v := do(t, http.MethodGet, url, ""); message(t, v)["n"] != 3.0 {
+		t.Errorf("got %v, want the event pushed while polling", v)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		status int
+		janus  string
+		code   int
+	}{
+		{"no such session", http.MethodGet, server.URL + "/janus/42", http.StatusOK, "error", api.ErrorSessionNotFound},
+		{"poll a handle", http.MethodGet, url + "/1", http.StatusMethodNotAllowed, "error", api.ErrorInvalidRequestPath},
+		{"invalid path", http.MethodGet, server.URL + "/janus/abc", http.StatusNotFound, "error", api.ErrorInvalidRequestPath},
+		{"unsupported method", http.MethodPut, url, http.StatusMethodNotAllowed, "error", api.ErrorTransportSpecific},
+		{"keepalive", http.MethodPost, url, http.StatusOK, "ack", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, v := do(t, test.method, test.url, `{"janus":"keepalive","transaction":"t"}`)
+			m := message(t, v)
+			if status != test.status || m["janus"] != test.janus || code(m) != test.code {
+				t.Errorf("got %d %v, want %d %s %d", status, m, test.status, test.janus, test.code)
+			}
+		})
+	}
+
+	// polling a session that's gone is an error, not a wait
+	if _, v := do(t, http.MethodPost, url, `{"janus":"destroy","transaction":"t"}`); message(t, v)["janus"] != "success" {
+		t.Fatalf("got %v destroying the session", v)
+	}
+	if _, v := do(t, http.MethodGet, url, ""); code(message(t, v)) != api.ErrorSessionNotFound {
+		t.Errorf("got %v polling a destroyed session", v)
+	}
+}
+
+func TestLongPollShutdown(t *testing.T) {
+	tr, server := startTransport(t)
+	_, v := do(t, http.MethodPost, server.URL+"/janus", `{"janus":"create","transaction":"t"}`)
+	data, _ := message(t, v)["data"].(map[string]interface{})
+	url := fmt.Sprintf("%s/janus/%.0f", server.URL, data["id"])
+	t.Cleanup(func() { do(t, http.MethodPost, url, `{"janus":"destroy","transaction":"t"}`) })
+
+	// a poll pending when the transport goes away gets a keepalive
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		tr.Destroy()
+	}()
+	if _, v := do(t, http.MethodGet, url, ""); message(t, v)["janus"] != "keepalive" {
+		t.Errorf("got %v when shutting down", v)
+	}
+}
@@ -0,0 +1,92 @@
+package transports
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/session"
+)
+
+// SessionQueues keeps a queue of events for each session created through a
+// transport whose clients come and take them, e.g. with long polls or event
+// streams. Embedded in the transport, it tracks the sessions for it.
+type SessionQueues struct {
+	transport string
+
+	mutex  sync.Mutex
+	queues map[uint64]*Queue
+}
+
+// NewSessionQueues returns the queues of the sessions of a transport
+func NewSessionQueues(transport string) *SessionQueues {
+	return &SessionQueues{transport: transport, queues: make(map[uint64]*Queue)}
+}
+
+// Queue returns the queue of a session, or nil if it has none
+func (s *SessionQueues) Queue(sessionID uint64) *Queue {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.queues[sessionID]
+}
+
+// Push queues an event for its session
+func (s *SessionQueues) Push(event map[string]interface{}) error {
+	sessionID, _ := event["session_id"].(uint64)
+	q := s.Queue(sessionID)
+	if q == nil {
+		return fmt.Errorf("no such session %d", sessionID)
+	}
+	if err := q.Push(event); err != nil {
+		s.overflow(sessionID, q)
+		return err
+	}
+	return nil
+}
+
+// overflow gets rid of a session whose client can't keep up with its events:
+// it's cut off from the transport, and can only be claimed back (if the
+// reclaim timeout allows it) or destroyed
+func (s *SessionQueues) overflow(sessionID uint64, q *Queue) {
+	s.mutex.Lock()
+	if s.queues[sessionID] == q {
+		delete(s.queues, sessionID)
+	}
+	s.mutex.Unlock()
+	log.Warnf("Too many events queued for session %d, the client is too slow", sessionID)
+	orphan(sessionID)
+}
+
+// orphan cuts a session off its transport, asynchronously: we may be in the
+// event loop of one of its handles, which detaching them waits for
+func orphan(sessionID uint64) {
+	if s := session.Find(sessionID); s != nil {
+		go s.Orphan()
+	}
+}
+
+func (s *SessionQueues) SessionCreated(instance interface{}, sessionID uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.queues[sessionID] = NewQueue(s.transport, strconv.FormatUint(sessionID, 10), 0)
+}
+
+func (s *SessionQueues) SessionOver(instance interface{}, sessionID uint64, timeout, claimed bool) {
+	s.mutex.Lock()
+	q := s.queues[sessionID]
+	delete(s.queues, sessionID)
+	s.mutex.Unlock()
+	if q != nil {
+		// whoever is polling still gets what's queued, e.g. the timeout event
+		q.Close()
+	}
+}
+
+func (s *SessionQueues) SessionClaimed(instance interface{}, sessionID uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.queues[sessionID] == nil {
+		s.queues[sessionID] = NewQueue(s.transport, strconv.FormatUint(sessionID, 10), 0)
+	}
+}
@@ -0,0 +1,93 @@
+package transports
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/session"
+)
+
+// pollingTransport is what a transport with SessionQueues looks like to the sessions
+type pollingTransport struct {
+	*SessionQueues
+}
+
+func (t pollingTransport) SendMessage(instance interface{}, requestID interface{}, admin bool, message map[string]interface{}) error {
+	return t.Push(message)
+}
+
+// smallQueues makes the queues created during the test hold max events
+func smallQueues(t *testing.T, max int) {
+	old := config.Conf.Transports.Max_queued_events
+	config.Conf.Transports.Max_queued_events = max
+	t.Cleanup(func() { config.Conf.Transports.Max_queued_events = old })
+}
+
+// gone waits for a session to be destroyed
+func gone(t *testing.T, s *session.Session) {
+	t.Helper()
+	for start := time.Now(); !s.Destroyed(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("session %d still there", s.ID)
+		}
+	}
+}
+
+func TestSessionQueues(t *testing.T) {
+	smallQueues(t, 2)
+	queues := NewSessionQueues("test")
+	source := &session.Source{Transport: pollingTransport{queues}}
+	s, err := session.New(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := session.New(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Destroy(false) })
+	if queues.Queue(s.ID) == nil || queues.Queue(other.ID) == nil {
+		t.Fatal("got no queue for a new session")
+	}
+	if err := queues.Push(map[string]interface{}{"janus": "event", "session_id": uint64(42)}); err == nil {
+		t.Error("got an event queued for an unknown session")
+	}
+
+	q := queues.Queue(s.ID)
+	for i := 0; i < 2; i++ {
+		if err := queues.Push(map[string]interface{}{"janus": "event", "session_id": s.ID, "n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := queues.Push(map[string]interface{}{"janus": "event", "session_id": s.ID}); err != ErrQueueFull {
+		t.Fatalf("got error %v, want %v", err, ErrQueueFull)
+	}
+	// the slow client loses its session, not the others
+	gone(t, s)
+	if queues.Queue(s.ID) != nil {
+		t.Error("got the queue of an overflowed session kept")
+	}
+	if queues.Queue(other.ID) == nil || other.Destroyed() {
+		t.Error("got another session cut off")
+	}
+	if got := numbers(q.Wait(context.Background(), nil, 10, time.Second)); len(got) != 2 {
+		t.Errorf("got events %v, want the ones queued before the overflow", got)
+	}
+
+	// claiming keeps the queue, and what it has
+	queues.Push(map[string]interface{}{"janus": "event", "session_id": other.ID, "n": 0})
+	q = queues.Queue(other.ID)
+	queues.SessionClaimed(nil, other.ID)
+	if queues.Queue(other.ID) != q {
+		t.Error("got a new queue for a claimed session")
+	}
+	queues.SessionOver(nil, other.ID, true, false)
+	if queues.Queue(other.ID) != nil {
+		t.Error("got the queue of a session that's over kept")
+	}
+	if got := numbers(q.Wait(context.Background(), nil, 10, time.Second)); len(got) != 1 {
+		t.Errorf("got events %v, want what was queued when the session was over", got)
+	}
+}
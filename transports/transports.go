@@ -0,0 +1,122 @@
+package transports
+
+// Transports carry the Janus API (and the Admin API) between clients and
+// the core, mirroring the janus_transport struct of the original Janus
+// source tree. Each transport registers itself from the init function of
+// its package, so adding one is just a matter of importing it in main; the
+// core then starts all of them, except the ones disabled in the
+// configuration.
+
+import (
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+)
+
+// ErrNotEnabled is returned by Init when the configuration enables neither API
+var ErrNotEnabled = errors.New("neither the Janus API nor the Admin API are enabled")
+
+// APIVersion is the version of the Transport interface, bumped whenever it
+// changes: Go plugins built against another version are refused
+const APIVersion = 1
+
+// Transport is the interface every transport implements
+type Transport interface {
+	Package() string
+	Name() string
+	Description() string
+	Author() string
+	Version() int
+	VersionString() string
+
+	Init(configPath string) error
+	Destroy()
+
+	// IsJanusAPIEnabled and IsAdminAPIEnabled tell which APIs the transport
+	// carries, according to its configuration
+	IsJanusAPIEnabled() bool
+	IsAdminAPIEnabled() bool
+
+	// SendMessage delivers a response or an event to a client
+	SendMessage(instance interface{}, requestID interface{}, admin bool, message map[string]interface{}) error
+	// SessionCreated, SessionOver and SessionClaimed tell the transport what
+	// happens to the sessions created (or claimed) through it
+	SessionCreated(instance interface{}, sessionID uint64)
+	SessionOver(instance interface{}, sessionID uint64, timeout, claimed bool)
+	SessionClaimed(instance interface{}, sessionID uint64)
+}
+
+var (
+	mutex      sync.RWMutex
+	transports = make(map[string]Transport)
+	started    []Transport
+)
+
+// Register makes a transport available
+func Register(t Transport) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	transports[t.Package()] = t
+}
+
+// Find returns the transport registered with the given package name, if any
+func Find(pkg string) Transport {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return transports[pkg]
+}
+
+// List returns all the registered transports
+func List() []Transport {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	list := make([]Transport, 0, len(transports))
+	for _, t := range transports {
+		list = append(list, t)
+	}
+	return list
+}
+
+// InitAll starts the registered transports, except the ones in the disable
+// list (e.g. libjanus_rabbitmq.so or janus.transport.rabbitmq)
+func InitAll(disable, configPath string) {
+	count := 0
+	for _, t := range List() {
+		if config.Disabled(disable, t.Package()) {
+			log.Infof("Transport %s disabled in the configuration, skipping it", t.Package())
+			continue
+		}
+		if err := t.Init(configPath); err != nil {
+			if err == ErrNotEnabled {
+				log.Infof("Transport %s not enabled in its configuration, skipping it", t.Package())
+			} else {
+				log.Errorf("Error initializing transport %s, skipping it: %v", t.Package(), err)
+			}
+			continue
+		}
+		count++
+		mutex.Lock()
+		started = append(started, t)
+		mutex.Unlock()
+		api.TransportStarted(t)
+		log.Infof("Loaded transport: %s (%s %s, Janus API: %v, Admin API: %v)", t.Package(), t.Name(), t.VersionString(),
+			t.IsJanusAPIEnabled(), t.IsAdminAPIEnabled())
+	}
+	if count == 0 {
+		log.Warnln("No transport started, nobody will be able to talk to us")
+	}
+}
+
+// DestroyAll stops the transports that were started
+func DestroyAll() {
+	mutex.Lock()
+	list := started
+	started = nil
+	mutex.Unlock()
+	for _, t := range list {
+		t.Destroy()
+	}
+}
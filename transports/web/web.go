@@ -0,0 +1,237 @@
+package web
+
+// Settings shared by the transports built on HTTP (REST and WebSockets):
+// CORS, access control lists for the Janus and Admin APIs, and finding out
+// who a client really is when we sit behind trusted proxies, so that the
+// access control lists and the logs use the address of the client rather
+// than the one of the proxy.
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORS is the cors section of the configuration of a transport
+type CORS struct {
+	Allow_origin      []string // origins allowed to talk to us, or * for any (the default)
+	Allow_credentials bool
+	Max_age           int // seconds browsers may cache the result of a preflight request
+}
+
+func (c *CORS) anyOrigin() bool {
+	if len(c.Allow_origin) == 0 {
+		return true
+	}
+	for _, o := range c.Allow_origin {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedOrigin tells whether a request with the given Origin header is
+// allowed: requests without one don't come from browsers, so they always are
+func (c *CORS) AllowedOrigin(origin string) bool {
+	if origin == "" || c.anyOrigin() {
+		return true
+	}
+	for _, o := range c.Allow_origin {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle sets the CORS headers of the response, answers preflight requests
+// and rejects requests from origins that are not allowed. It returns true if
+// the response has been written already.
+func (c *CORS) Handle(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if !c.AllowedOrigin(origin) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return true
+	}
+	h := w.Header()
+	if origin == "" || (c.anyOrigin() && !c.Allow_credentials) {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		// credentials can't be used with *, so the origin is echoed back
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	}
+	if c.Allow_credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if r.Method != http.MethodOptions {
+		return false
+	}
+	h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	if c.Max_age > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(c.Max_age))
+	}
+	w.WriteHeader(http.StatusOK)
+	return true
+}
+
+// ACL is the acl section of the configuration of a transport: addresses or
+// CIDR blocks allowed to use (or kept out of) each API, the proxies we
+// trust to tell us the address of the client, and the header they tell it
+// in (X-Forwarded-For if not set)
+type ACL struct {
+	Allow           []string
+	Deny            []string
+	Admin_allow     []string
+	Admin_deny      []string
+	Trusted_proxies []string
+	Proxy_header    string
+}
+
+// DefaultProxyHeader is the header trusted proxies write, unless told otherwise
+const DefaultProxyHeader = "X-Forwarded-For"
+
+// Filter is an ACL ready to be used
+type Filter struct {
+	allow, deny           []*net.IPNet
+	adminAllow, adminDeny []*net.IPNet
+	proxies               []*net.IPNet
+	proxyHeader           string
+}
+
+func parseNets(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or network %q", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile checks the ACL and turns it into a Filter
+func (a *ACL) Compile() (*Filter, error) {
+	f := &Filter{}
+	var err error
+	for _, l := range []struct {
+		nets *[]*net.IPNet
+		list []string
+	}{
+		{&f.allow, a.Allow},
+		{&f.deny, a.Deny},
+		{&f.adminAllow, a.Admin_allow},
+		{&f.adminDeny, a.Admin_deny},
+		{&f.proxies, a.Trusted_proxies},
+	} {
+		if *l.nets, err = parseNets(l.list); err != nil {
+			return nil, err
+		}
+	}
+	f.proxyHeader = http.CanonicalHeaderKey(strings.TrimSpace(a.Proxy_header))
+	if f.proxyHeader == "" {
+		f.proxyHeader = DefaultProxyHeader
+	}
+	return f, nil
+}
+
+// Allowed tells whether a client can use the Janus API, or the Admin API:
+// denied addresses never can, and if there's an allow list, only the
+// addresses in it can
+func (f *Filter) Allowed(ip net.IP, admin bool) bool {
+	allow, deny := f.allow, f.deny
+	if admin {
+		allow, deny = f.adminAllow, f.adminDeny
+	}
+	if ip == nil || contains(deny, ip) {
+		return false
+	}
+	return len(allow) == 0 || contains(allow, ip)
+}
+
+// ClientIP returns the address of the client of a request: when the request
+// comes from a trusted proxy, the header the proxies write is walked back
+// from the last hop until an address we don't trust is found. Any other
+// header is ignored, as the client could have written it: a proxy only
+// appends to the header it knows about, and passes the others along.
+func (f *Filter) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(f.proxies, ip) {
+		return ip
+	}
+	hops := forwarded(r, f.proxyHeader)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHop(hops[i])
+		if hop == nil {
+			// obfuscated or garbage: we can't go any further
+			break
+		}
+		ip = hop
+		if !contains(f.proxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// forwarded returns the addresses of the hops a request went through, as
+// found in header: the for= parameters of Forwarded (RFC 7239), or the
+// comma separated addresses of X-Forwarded-For and the like
+func forwarded(r *http.Request, header string) []string {
+	var hops []string
+	for _, value := range r.Header.Values(header) {
+		for _, element := range strings.Split(value, ",") {
+			if header != "Forwarded" {
+				hops = append(hops, strings.TrimSpace(element))
+				continue
+			}
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+					hops = append(hops, strings.Trim(pair[4:], `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses an address as found in Forwarded and X-Forwarded-For,
+// which may come with a port and, for IPv6, within brackets
+func parseHop(hop string) net.IP {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
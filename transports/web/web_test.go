@@ -0,0 +1,158 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		acl    ACL
+		header string
+		err    bool
+	}{
+		{"empty", ACL{}, "X-Forwarded-For", false},
+		{"addresses and networks", ACL{Allow: []string{"10.0.0.1", " 192.168.0.0/16 ", ""}, Deny: []string{"::1", "2001:db8::/32"}}, "X-Forwarded-For", false},
+		{"proxy header", ACL{Proxy_header: " forwarded "}, "Forwarded", false},
+		{"invalid address", ACL{Admin_allow: []string{"localhost"}}, "", true},
+		{"invalid network", ACL{Trusted_proxies: []string{"10.0.0.0/33"}}, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := test.acl.Compile()
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v", err)
+			}
+			if err == nil && f.proxyHeader != test.header {
+				t.Errorf("got proxy header %q, want %q", f.proxyHeader, test.header)
+			}
+		})
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	f, err := (&ACL{
+		Allow:       []string{"10.0.0.0/8"},
+		Deny:        []string{"10.0.0.13"},
+		Admin_allow: []string{"127.0.0.1"},
+	}).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip    string
+		admin bool
+		want  bool
+	}{
+		{"10.1.2.3", false, true},
+		{"10.0.0.13", false, false},
+		{"192.168.1.1", false, false},
+		{"127.0.0.1", false, false},
+		{"127.0.0.1", true, true},
+		{"10.1.2.3", true, false},
+		{"", false, false},
+	}
+	for _, test := range tests {
+		if got := f.Allowed(net.ParseIP(test.ip), test.admin); got != test.want {
+			t.Errorf("%q (admin %v): got %v, want %v", test.ip, test.admin, got, test.want)
+		}
+	}
+	// without lists, anyone can
+	open, _ := (&ACL{}).Compile()
+	if !open.Allowed(net.ParseIP("192.0.2.1"), false) || !open.Allowed(net.ParseIP("192.0.2.1"), true) {
+		t.Error("got an empty ACL refusing a client")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string // the proxy header of the ACL
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{"direct", "", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted peer", "", "192.0.2.1:1234", map[string][]string{"X-Forwarded-For": {"10.9.9.9"}}, "192.0.2.1"},
+		{"trusted proxy", "", "127.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"192.0.2.7"}}, "192.0.2.7"},
+		{"trusted proxy without the header", "", "127.0.0.1:1234", nil, "127.0.0.1"},
+		{"spoofed first hop", "", "127.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"10.9.9.9, 192.0.2.7"}}, "192.0.2.7"},
+		{"chain of trusted proxies", "", "127.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"10.9.9.9, 192.0.2.7", "10.0.0.2"}}, "192.0.2.7"},
+		{"garbage hop", "", "127.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"192.0.2.7, unknown"}}, "127.0.0.1"},
+		{"other header ignored", "", "127.0.0.1:1234", map[string][]string{"Forwarded": {"for=10.9.9.9"}, "X-Forwarded-For": {"192.0.2.7"}}, "192.0.2.7"},
+		{"forwarded", "Forwarded", "127.0.0.1:1234", map[string][]string{"Forwarded": {`for=10.9.9.9, for="[2001:db8::1]:4711";proto=https`}}, "2001:db8::1"},
+		{"forwarded ignores x-forwarded-for", "Forwarded", "127.0.0.1:1234", map[string][]string{"Forwarded": {"for=192.0.2.7"}, "X-Forwarded-For": {"10.9.9.9"}}, "192.0.2.7"},
+		{"forwarded missing", "Forwarded", "127.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"10.9.9.9"}}, "127.0.0.1"},
+		{"obfuscated", "Forwarded", "127.0.0.1:1234", map[string][]string{"Forwarded": {"for=192.0.2.7, for=_hidden"}}, "127.0.0.1"},
+		{"custom header", "X-Real-IP", "127.0.0.1:1234", map[string][]string{"X-Real-Ip": {"192.0.2.7"}, "X-Forwarded-For": {"10.9.9.9"}}, "192.0.2.7"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := (&ACL{Trusted_proxies: []string{"127.0.0.1", "10.0.0.0/24"}, Proxy_header: test.header}).Compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/janus", nil)
+			r.RemoteAddr = test.remote
+			for name, values := range test.headers {
+				for _, v := range values {
+					r.Header.Add(name, v)
+				}
+			}
+			if got := f.ClientIP(r); got.String() != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		cors        CORS
+		method      string
+		origin      string
+		handled     bool
+		status      int
+		allowOrigin string
+		credentials bool
+	}{
+		{"any origin", CORS{}, http.MethodPost, "https://example.com", false, http.StatusOK, "*", false},
+		{"no origin", CORS{Allow_origin: []string{"https://example.com"}}, http.MethodPost, "", false, http.StatusOK, "*", false},
+		{"allowed origin", CORS{Allow_origin: []string{"https://example.com/"}}, http.MethodPost, "https://EXAMPLE.com", false, http.StatusOK, "https://EXAMPLE.com", false},
+		{"refused origin", CORS{Allow_origin: []string{"https://example.com"}}, http.MethodPost, "https://evil.com", true, http.StatusForbidden, "", false},
+		{"credentials", CORS{Allow_origin: []string{"*"}, Allow_credentials: true}, http.MethodGet, "https://example.com", false, http.StatusOK, "https://example.com", true},
+		{"preflight", CORS{Max_age: 600}, http.MethodOptions, "https://example.com", true, http.StatusOK, "*", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/janus", nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			r.Header.Set("Access-Control-Request-Headers", "content-type")
+			w := httptest.NewRecorder()
+			if handled := test.cors.Handle(w, r); handled != test.handled {
+				t.Fatalf("got handled %v, want %v", handled, test.handled)
+			}
+			if w.Code != test.status {
+				t.Errorf("got status %d, want %d", w.Code, test.status)
+			}
+			h := w.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, test.allowOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials") == "true"; got != test.credentials {
+				t.Errorf("got credentials %v, want %v", got, test.credentials)
+			}
+			if test.method == http.MethodOptions {
+				if h.Get("Access-Control-Allow-Methods") == "" || h.Get("Access-Control-Allow-Headers") != "content-type" || h.Get("Access-Control-Max-Age") != "600" {
+					t.Errorf("got preflight headers %v", h)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/session"
+	"github.com/xroger88/go-janus/transports"
+)
+
+// frames sent in a row, at most, before checking whether a ping is due
+const maxFrames = 100
+
+// client is a WebSocket connection, and the transport instance of its sessions
+type client struct {
+	transport *Transport
+	conn      *websocket.Conn
+	admin     bool
+	remote    string
+	source    *session.Source
+
+	queue     *transports.Queue
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mutex    sync.Mutex
+	sessions map[uint64]bool
+}
+
+func newClient(t *Transport, conn *websocket.Conn, remote string, admin bool) *client {
+	c := &client{
+		transport: t,
+		conn:      conn,
+		admin:     admin,
+		remote:    remote,
+		queue:     transports.NewQueue(Package, remote, t.config.General.Max_queued_frames),
+		closed:    make(chan struct{}),
+		sessions:  make(map[uint64]bool),
+	}
+	c.source = &session.Source{Transport: t, Instance: c}
+	return c
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+func (c *client) own(sessionID uint64, owned bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if owned {
+		c.sessions[sessionID] = true
+	} else {
+		delete(c.sessions, sessionID)
+	}
+}
+
+func (c *client) owns(sessionID uint64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.sessions[sessionID]
+}
+
+// send queues a message for the writer: a client not reading fast enough
+// to keep the queue from overflowing is dropped
+func (c *client) send(message map[string]interface{}) error {
+	select {
+	case <-c.closed:
+		return errors.New("WebSocket connection closed")
+	default:
+	}
+	if err := c.queue.Push(message); err != nil {
+		log.Warnf("[%s] %v, closing the WebSocket connection", c.remote, err)
+		c.close()
+		return err
+	}
+	return nil
+}
+
+func (c *client) pingInterval() time.Duration {
+	return time.Duration(c.transport.config.General.Ping_interval) * time.Second
+}
+
+// reader handles the requests of the client, until the connection breaks
+func (c *client) reader() {
+	defer c.close()
+	c.conn.SetReadLimit(c.transport.config.General.Max_message_size)
+	if interval := c.pingInterval(); interval > 0 {
+		// a client missing two pongs in a row is gone
+		c.conn.SetReadDeadline(time.Now().Add(2 * interval))
+		c.conn.SetPongHandler(func(string) error {
+			return c.conn.SetReadDeadline(time.Now().Add(2 * interval))
+		})
+	}
+	for {
+		kind, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Warnf("[%s] WebSocket error: %v", c.remote, err)
+			}
+			return
+		}
+		if kind != websocket.TextMessage {
+			continue
+		}
+		log.Debugf("[%s] WebSocket request: %s", c.remote, data)
+		// only the owner of a session can use it, others have to claim it first
+		if sessionID, request := requestSession(data); !c.admin && sessionID != 0 && request != "claim" && !c.owns(sessionID) {
+			var transaction struct {
+				Transaction string `json:"transaction"`
+			}
+			json.Unmarshal(data, &transaction)
+			c.send(api.ErrorResponse(sessionID, transaction.Transaction, api.ErrorSessionNotFound, fmt.Sprintf("No such session %d", sessionID)))
+			continue
+		}
+		api.IncomingRequest(c.source, nil, c.admin, data)
+	}
+}
+
+// writer sends the queued messages and the pings to the client
+func (c *client) writer() {
+	defer c.close()
+	interval := c.pingInterval()
+	lastPing := time.Now()
+	for {
+		wait := time.Hour
+		if interval > 0 {
+			wait = interval - time.Since(lastPing)
+		}
+		messages := c.queue.Wait(context.Background(), c.closed, maxFrames, wait)
+		select {
+		case <-c.closed:
+			return
+		default:
+		}
+		for _, message := range messages {
+			data, err := json.MarshalIndent(message, "", "   ")
+			if err != nil {
+				log.Errorf("[%s] Error encoding a message: %v", c.remote, err)
+				continue
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		}
+		if interval > 0 && time.Since(lastPing) >= interval {
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return
+			}
+			lastPing = time.Now()
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package websockets
+
+// WebSockets transport for the Janus API, same as janus.transport.websockets.
+// Clients must negotiate the janus-protocol subprotocol (janus-admin-protocol
+// for the Admin API), and then send requests and get responses and events
+// as text frames. Each connection is a transport instance of its own: the
+// sessions created (or claimed) on it belong to it, requests for sessions
+// belonging to other connections are rejected, and when the connection goes
+// away so do its sessions (unless they can be reclaimed).
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/api"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/session"
+	"github.com/xroger88/go-janus/transports"
+	"github.com/xroger88/go-janus/transports/web"
+)
+
+const (
+	Package = "janus.transport.websockets"
+
+	janusProtocol = "janus-protocol"
+	adminProtocol = "janus-admin-protocol"
+
+	writeTimeout = 10 * time.Second
+)
+
+// Config is the content of janus.transport.websockets.yaml in the configs folder
+type Config struct {
+	General struct {
+		Ws                bool
+		Ws_port           int
+		Ws_interface      string
+		Ws_path           string
+		Max_message_size  int64
+		Max_queued_frames int
+		Ping_interval     int
+
+		Wss           bool
+		Wss_port      int
+		Wss_interface string
+	}
+	Admin struct {
+		Admin_ws           bool
+		Admin_ws_port      int
+		Admin_ws_interface string
+		Admin_ws_path      string
+
+		Admin_wss           bool
+		Admin_wss_port      int
+		Admin_wss_interface string
+		Admin_client_ca     string
+	}
+	Certificates config.TLS
+	Cors         web.CORS
+	Acl          web.ACL
+}
+
+type Transport struct {
+	config  Config
+	filter  *web.Filter
+	servers []*http.Server
+	done    chan struct{}
+
+	mutex   sync.Mutex
+	clients map[*client]bool
+}
+
+// New returns the WebSockets transport, to be started with Init
+func New() *Transport {
+	return &Transport{
+		done:    make(chan struct{}),
+		clients: make(map[*client]bool),
+	}
+}
+
+func init() {
+	transports.Register(New())
+}
+
+func (t *Transport) Package() string { return Package }
+func (t *Transport) Name() string    { return "JANUS WebSockets transport plugin" }
+func (t *Transport) Description() string {
+	return "This transport plugin adds WebSockets support to the Janus API."
+}
+func (t *Transport) Author() string        { return api.Author }
+func (t *Transport) Version() int          { return api.Version }
+func (t *Transport) VersionString() string { return api.VersionString }
+
+func (t *Transport) IsJanusAPIEnabled() bool {
+	return t.config.General.Ws || t.config.General.Wss
+}
+func (t *Transport) IsAdminAPIEnabled() bool {
+	return t.config.Admin.Admin_ws || t.config.Admin.Admin_wss
+}
+
+// Init reads the configuration and starts the WebSocket servers
+func (t *Transport) Init(configPath string) error {
+	c := &t.config
+	c.General.Ws = true
+	c.General.Ws_port = 8188
+	c.General.Ws_path = "/"
+	c.General.Max_message_size = 256 * 1024
+	c.General.Ping_interval = 30
+	c.General.Wss_port = 8989
+	c.Admin.Admin_ws_port = 7188
+	c.Admin.Admin_wss_port = 7989
+	c.Admin.Admin_ws_path = "/"
+	if err := config.LoadComponent(configPath, Package, c); err != nil {
+		return err
+	}
+	if !c.General.Ws && !c.General.Wss && !c.Admin.Admin_ws && !c.Admin.Admin_wss {
+		return transports.ErrNotEnabled
+	}
+	var err error
+	if t.filter, err = c.Acl.Compile(); err != nil {
+		return err
+	}
+
+	type server struct {
+		mux    *http.ServeMux
+		secure bool
+		admin  bool
+	}
+	servers := make(map[string]*server)
+	endpoint := func(ip string, port int, path string, admin, secure bool) error {
+		addr := net.JoinHostPort(ip, strconv.Itoa(port))
+		s := servers[addr]
+		if s == nil {
+			s = &server{mux: http.NewServeMux(), secure: secure}
+			servers[addr] = s
+		} else if s.secure != secure {
+			return fmt.Errorf("can't serve both WS and WSS on %s", addr)
+		}
+		s.admin = s.admin || admin
+		s.mux.Handle(path, t.handler(admin))
+		return nil
+	}
+	var errs []error
+	if c.General.Ws {
+		errs = append(errs, endpoint(c.General.Ws_interface, c.General.Ws_port, c.General.Ws_path, false, false))
+	}
+	if c.General.Wss {
+		errs = append(errs, endpoint(c.General.Wss_interface, c.General.Wss_port, c.General.Ws_path, false, true))
+	}
+	if c.Admin.Admin_ws {
+		errs = append(errs, endpoint(c.Admin.Admin_ws_interface, c.Admin.Admin_ws_port, c.Admin.Admin_ws_path, true, false))
+	}
+	if c.Admin.Admin_wss {
+		errs = append(errs, endpoint(c.Admin.Admin_wss_interface, c.Admin.Admin_wss_port, c.Admin.Admin_ws_path, true, true))
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	for addr, s := range servers {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Destroy()
+			return err
+		}
+		server := &http.Server{Handler: s.mux}
+		scheme := "WebSockets"
+		if s.secure {
+			clientCA := ""
+			if s.admin {
+				clientCA = c.Admin.Admin_client_ca
+			}
+			if server.TLSConfig, err = c.Certificates.ServerConfig(clientCA); err != nil {
+				listener.Close()
+				t.Destroy()
+				return err
+			}
+			listener = tls.NewListener(listener, server.TLSConfig)
+			scheme = "Secure WebSockets"
+		}
+		t.servers = append(t.servers, server)
+		go server.Serve(listener)
+		log.Infof("%s server started (%s)", scheme, addr)
+	}
+	return nil
+}
+
+// Destroy closes all the connections and stops the servers
+func (t *Transport) Destroy() {
+	select {
+	case <-t.done:
+		return
+	default:
+		close(t.done)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, server := range t.servers {
+		server.Shutdown(ctx)
+	}
+	t.mutex.Lock()
+	for c := range t.clients {
+		c.close()
+	}
+	t.mutex.Unlock()
+	log.Infof("%s destroyed", Package)
+}
+
+func (t *Transport) SendMessage(instance interface{}, requestID interface{}, admin bool, message map[string]interface{}) error {
+	c, ok := instance.(*client)
+	if !ok {
+		return errors.New("invalid WebSocket client")
+	}
+	return c.send(message)
+}
+
+func (t *Transport) SessionCreated(instance interface{}, sessionID uint64) {
+	if c, ok := instance.(*client); ok {
+		c.own(sessionID, true)
+	}
+}
+
+func (t *Transport) SessionOver(instance interface{}, sessionID uint64, timeout, claimed bool) {
+	if c, ok := instance.(*client); ok {
+		c.own(sessionID, false)
+	}
+}
+
+func (t *Transport) SessionClaimed(instance interface{}, sessionID uint64) {
+	if c, ok := instance.(*client); ok {
+		c.own(sessionID, true)
+	}
+}
+
+func (t *Transport) handler(admin bool) http.HandlerFunc {
+	protocol := janusProtocol
+	if admin {
+		protocol = adminProtocol
+	}
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{protocol},
+		CheckOrigin: func(r *http.Request) bool {
+			return t.config.Cors.AllowedOrigin(r.Header.Get("Origin"))
+		},
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := t.filter.ClientIP(r)
+		if !t.filter.Allowed(ip, admin) {
+			log.Warnf("[%s] WebSocket connection refused by the ACL", ip)
+			http.Error(w, "Address not allowed", http.StatusForbidden)
+			return
+		}
+		// clients asking for some other subprotocol are not for us
+		if requested := websocket.Subprotocols(r); len(requested) > 0 {
+			found := false
+			for _, p := range requested {
+				found = found || p == protocol
+			}
+			if !found {
+				http.Error(w, "Unsupported WebSocket subprotocol, expected "+protocol, http.StatusBadRequest)
+				return
+			}
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Warnf("WebSocket upgrade failed: %v", err)
+			return
+		}
+		c := newClient(t, conn, ip.String(), admin)
+		t.mutex.Lock()
+		t.clients[c] = true
+		t.mutex.Unlock()
+		log.Infof("[%s] WebSocket connection opened (%s)", c.remote, protocol)
+
+		go c.writer()
+		c.reader()
+
+		t.mutex.Lock()
+		delete(t.clients, c)
+		t.mutex.Unlock()
+		session.TransportGone(c.source)
+		log.Infof("[%s] WebSocket connection closed", c.remote)
+	}
+}
+
+// requestSession returns the session_id and the request name of a request, if any
+func requestSession(data []byte) (uint64, string) {
+	var request struct {
+		Janus     string `json:"janus"`
+		SessionID uint64 `json:"session_id"`
+	}
+	json.Unmarshal(data, &request)
+	return request.SessionID, request.Janus
+}
@@ -0,0 +1,146 @@
+package websockets
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xroger88/go-janus/api"
+)
+
+// startTransport serves the Janus API of a transport on a test server, and
+// returns its WebSocket URL
+func startTransport(t *testing.T, pingInterval int) (*Transport, string) {
+	tr := New()
+	tr.config.General.Max_message_size = 256 * 1024
+	tr.config.General.Ping_interval = pingInterval
+	var err error
+	if tr.filter, err = tr.config.Acl.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(tr.handler(false))
+	t.Cleanup(func() {
+		tr.Destroy()
+		server.Close()
+	})
+	return tr, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	dialer := websocket.Dialer{Subprotocols: []string{janusProtocol}}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// request sends a request, and returns what comes next
+func request(t *testing.T, conn *websocket.Conn, body string) map[string]interface{} {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var m map[string]interface{}
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func code(m map[string]interface{}) int {
+	e, _ := m["error"].(map[string]interface{})
+	c, _ := e["code"].(float64)
+	return int(c)
+}
+
+func (t *Transport) connections() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.clients)
+}
+
+func TestOwnership(t *testing.T) {
+	_, url := startTransport(t, 0)
+	alice, bob := dial(t, url), dial(t, url)
+	m := request(t, alice, `{"janus":"create","transaction":"t"}`)
+	data, _ := m["data"].(map[string]interface{})
+	id, _ := data["id"].(float64)
+	if id == 0 {
+		t.Fatalf("got %v creating a session", m)
+	}
+	keepalive := fmt.Sprintf(`{"janus":"keepalive","transaction":"t","session_id":%.0f}`, id)
+
+	// a session is only for the connection it belongs to, until claimed
+	steps := []struct {
+		name  string
+		conn  *websocket.Conn
+		body  string
+		janus string
+		code  int
+	}{
+		{"owner", alice, keepalive, "ack", 0},
+		{"other connection", bob, keepalive, "error", api.ErrorSessionNotFound},
+		{"claim", bob, fmt.Sprintf(`{"janus":"claim","transaction":"t","session_id":%.0f}`, id), "success", 0},
+		{"new owner", bob, keepalive, "ack", 0},
+		{"old owner", alice, keepalive, "error", api.ErrorSessionNotFound},
+		{"destroy", bob, fmt.Sprintf(`{"janus":"destroy","transaction":"t","session_id":%.0f}`, id), "success", 0},
+	}
+	for _, step := range steps {
+		if m := request(t, step.conn, step.body); m["janus"] != step.janus || code(m) != step.code {
+			t.Errorf("%s: got %v, want %s %d", step.name, m, step.janus, step.code)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	tr, url := startTransport(t, 1)
+	// pongs only go back while reading: the idle client doesn't read
+	dial(t, url)
+	active := dial(t, url)
+	messages := make(chan map[string]interface{}, 10)
+	go func() {
+		for {
+			var m map[string]interface{}
+			if err := active.ReadJSON(&m); err != nil {
+				close(messages)
+				return
+			}
+			messages <- m
+		}
+	}()
+
+	// the idle client goes away after missing two pings
+	start := time.Now()
+	for tr.connections() > 1 {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("got %d connections, want the idle one closed", tr.connections())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("got the idle client closed after %v, before missing any ping", d)
+	}
+
+	// while the one answering stays
+	time.Sleep(1500 * time.Millisecond)
+	if err := active.WriteMessage(websocket.TextMessage, []byte(`{"janus":"info","transaction":"t"}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m, ok := <-messages:
+		if !ok || m["janus"] != "server_info" {
+			t.Errorf("got %v, want the server info", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no response")
+	}
+	if n := tr.connections(); n != 1 {
+		t.Errorf("got %d connections, want 1", n)
+	}
+}
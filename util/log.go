@@ -3,6 +3,7 @@ package util
 import (
 	"fmt"
 	"os"
+	"sync/atomic"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -44,6 +45,38 @@ func LogInit(disable_stdout bool, logfile string) {
 	}
 }
 
+// Janus debug levels go from 0 (no logs) to 7 (everything): these are the
+// logrus levels they map to, as logrus has less of them
+var debugLevels = []log.Level{
+	log.PanicLevel, // 0 none
+	log.FatalLevel, // 1 fatal
+	log.ErrorLevel, // 2 error
+	log.WarnLevel,  // 3 warning
+	log.InfoLevel,  // 4 info
+	log.DebugLevel, // 5 verbose
+	log.DebugLevel, // 6 huge
+	log.DebugLevel, // 7 debug
+}
+
+// debugLevel is changed by the Admin API while others are logging
+var debugLevel int32 = 4
+
+// SetDebugLevel sets the log level using the Janus debug levels (0-7)
+func SetDebugLevel(level int) {
+	if level < 0 {
+		level = 0
+	} else if level >= len(debugLevels) {
+		level = len(debugLevels) - 1
+	}
+	atomic.StoreInt32(&debugLevel, int32(level))
+	log.SetLevel(debugLevels[level])
+}
+
+// DebugLevel returns the current Janus debug level (0-7)
+func DebugLevel() int {
+	return int(atomic.LoadInt32(&debugLevel))
+}
+
 func test() {
 	log.WithFields(log.Fields{
 		"animal": "walrus",
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// maxSafeID keeps identifiers within what JavaScript can represent
+// exactly (2^53), since janus.js parses them as plain numbers
+const maxSafeID = uint64(1) << 53
+
+// RandomUint64 returns a random non-zero identifier safe to use in the Janus API
+func RandomUint64() uint64 {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		if id := binary.BigEndian.Uint64(b[:]) % maxSafeID; id != 0 {
+			return id
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package webrtc
+
+// Data channels: SCTP over DTLS (RFC 8261), with the channels the client
+// opens accepted as they come, and the ones the plugin sends on opened
+// when it first does.
+
+import (
+	"io"
+
+	"github.com/pion/datachannel"
+	"github.com/pion/dtls/v2"
+	"github.com/pion/sctp"
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/sdp"
+)
+
+// serveData sets SCTP up and accepts the channels of the client, until
+// the association goes away
+func (pc *PeerConnection) serveData(dconn *dtls.Conn) {
+	association, err := sctp.Client(sctp.Config{
+		NetConn:       dconn,
+		LoggerFactory: loggerFactory{},
+	})
+	if err != nil {
+		pc.fail("DTLS alert", err)
+		return
+	}
+	pc.mutex.Lock()
+	select {
+	case <-pc.closed:
+		pc.mutex.Unlock()
+		association.Close()
+		return
+	default:
+	}
+	pc.sctp = association
+	// the DTLS client opens the even streams, the server the odd ones (RFC 8832)
+	if pc.setup == setupActive {
+		pc.nextStreamID = 0
+	} else {
+		pc.nextStreamID = 1
+	}
+	pc.mutex.Unlock()
+	log.Infof("[%d] SCTP association up", pc.handle.ID)
+
+	for {
+		pc.mutex.Lock()
+		existing := append([]*datachannel.DataChannel{}, pc.channels...)
+		pc.mutex.Unlock()
+		channel, err := datachannel.Accept(association, &datachannel.Config{LoggerFactory: loggerFactory{}}, existing...)
+		if err != nil {
+			if err != io.EOF {
+				log.Debugf("[%d] SCTP: %v", pc.handle.ID, err)
+			}
+			pc.fail("DTLS alert", nil)
+			return
+		}
+		pc.addChannel(channel)
+	}
+}
+
+func (pc *PeerConnection) addChannel(channel *datachannel.DataChannel) {
+	pc.mutex.Lock()
+	pc.channels = append(pc.channels, channel)
+	pc.mutex.Unlock()
+	log.Infof("[%d] Data channel %q open", pc.handle.ID, channel.Config.Label)
+	go pc.readChannel(channel)
+}
+
+// readChannel passes what comes on a data channel to the plugin
+func (pc *PeerConnection) readChannel(channel *datachannel.DataChannel) {
+	buf := make([]byte, receiveMTU*8)
+	for {
+		n, isString, err := channel.ReadDataChannel(buf)
+		if err != nil {
+			return
+		}
+		pc.mutex.Lock()
+		for _, m := range pc.media {
+			if m.kind == sdp.Application {
+				m.in.packets++
+				m.in.bytes += uint64(n)
+			}
+		}
+		pc.mutex.Unlock()
+		pc.handle.IncomingData(&plugins.DataPacket{
+			Label:    channel.Config.Label,
+			Protocol: channel.Config.Protocol,
+			Binary:   !isString,
+			Buffer:   append([]byte(nil), buf[:n]...),
+		})
+	}
+}
+
+// SendData sends a message of the plugin on a data channel, opening it if
+// the client hasn't: an empty label is the first channel there is
+func (pc *PeerConnection) SendData(packet *plugins.DataPacket) error {
+	pc.mutex.Lock()
+	association := pc.sctp
+	var channel *datachannel.DataChannel
+	for _, c := range pc.channels {
+		if channel == nil && (packet.Label == "" || c.Config.Label == packet.Label) {
+			channel = c
+		}
+	}
+	id := pc.nextStreamID
+	if channel == nil && association != nil {
+		pc.nextStreamID += 2
+	}
+	pc.mutex.Unlock()
+	if association == nil {
+		return errNotConnected
+	}
+	if channel == nil {
+		var err error
+		channel, err = datachannel.Dial(association, id, &datachannel.Config{
+			ChannelType:   datachannel.ChannelTypeReliable,
+			Label:         packet.Label,
+			Protocol:      packet.Protocol,
+			LoggerFactory: loggerFactory{},
+		})
+		if err != nil {
+			return err
+		}
+		pc.addChannel(channel)
+	}
+	if _, err := channel.WriteDataChannel(packet.Buffer, !packet.Binary); err != nil {
+		return err
+	}
+	pc.mutex.Lock()
+	for _, m := range pc.media {
+		if m.kind == sdp.Application {
+			m.out.packets++
+			m.out.bytes += uint64(len(packet.Buffer))
+		}
+	}
+	pc.mutex.Unlock()
+	return nil
+}
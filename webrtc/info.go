@@ -0,0 +1,83 @@
+package webrtc
+
+// What handle_info shows of a PeerConnection, with the same elements as
+// the original Janus
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pion/ice/v2"
+	"github.com/xroger88/go-janus/sdp"
+	"github.com/xroger88/go-janus/session"
+)
+
+// Info describes the PeerConnection for handle_info
+func (pc *PeerConnection) Info() *session.PeerConnectionInfo {
+	pair, _ := pc.agent.GetSelectedCandidatePair()
+	s := load()
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	info := &session.PeerConnectionInfo{
+		ICE: map[string]interface{}{
+			"state":             strings.ToLower(pc.iceState.String()),
+			"local-candidates":  append([]string{}, pc.candidates...),
+			"remote-candidates": append([]string{}, pc.remoteCandidates...),
+		},
+		DTLS: map[string]interface{}{
+			"state":       pc.dtlsState,
+			"fingerprint": s.fingerprint,
+		},
+		Media: []map[string]interface{}{},
+	}
+	if pair != nil {
+		info.ICE["selected-pair"] = fmt.Sprintf("%s <-> %s", describeCandidate(pair.Local), describeCandidate(pair.Remote))
+	}
+	switch pc.setup {
+	case setupActive:
+		info.DTLS["role"] = "client"
+	case setupPassive:
+		info.DTLS["role"] = "server"
+	}
+	if pc.params != nil {
+		for name, hash := range hashes {
+			if hash == pc.params.hash {
+				info.DTLS["remote-fingerprint"] = name + " " + pc.params.fingerprint
+			}
+		}
+	}
+	if pc.srtpProfile != "" {
+		info.DTLS["srtp-profile"] = pc.srtpProfile
+	}
+	for _, m := range pc.media {
+		info.Media = append(info.Media, m.info())
+	}
+	return info
+}
+
+func describeCandidate(c ice.Candidate) string {
+	return fmt.Sprintf("%s [%s,%s]", net.JoinHostPort(c.Address(), fmt.Sprint(c.Port())), c.Type(), c.NetworkType().NetworkShort())
+}
+
+func (s *stats) info() map[string]interface{} {
+	return map[string]interface{}{"packets": s.packets, "bytes": s.bytes, "rtcp-packets": s.rtcpPackets}
+}
+
+func (m *mline) info() map[string]interface{} {
+	ssrc := map[string]interface{}{"local": m.localSSRC}
+	if len(m.remoteSSRCs) > 0 {
+		ssrc["remote"] = m.remoteSSRCs[0]
+	}
+	kind := m.kind.String()
+	if m.kind == sdp.Application {
+		kind = "data"
+	}
+	return map[string]interface{}{
+		"mid":       m.mid,
+		"type":      kind,
+		"ssrc":      ssrc,
+		"in-stats":  m.in.info(),
+		"out-stats": m.out.info(),
+	}
+}
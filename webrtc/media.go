@@ -0,0 +1,292 @@
+package webrtc
+
+// Media on a PeerConnection: SRTP and SRTCP packets of the client are
+// decrypted and passed to the plugin with the m-line they belong to, and
+// what the plugin relays is sent with the SSRCs of our own description.
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/rtcp"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sdp"
+)
+
+var errNotConnected = errors.New("PeerConnection not connected")
+
+// stats are the counters of one direction of an m-line
+type stats struct {
+	packets, bytes, rtcpPackets uint64
+}
+
+// mline is an m-line, as negotiated with the client
+type mline struct {
+	index       int
+	kind        sdp.MediaType
+	mid         string
+	formats     map[uint8]bool // payload types
+	remoteSSRCs []uint32       // from the a=ssrc of the client
+	localSSRC   uint32
+	sends       bool // whether we send media on it
+	disabled    bool
+	in, out     stats
+	lastIn      time.Time
+	receiving   bool
+}
+
+func (m *mline) video() bool { return m.kind == sdp.Video }
+
+// negotiate pairs the m-lines of both descriptions, once there are both
+func (pc *PeerConnection) negotiate() {
+	if pc.local == nil || pc.remote == nil {
+		return
+	}
+	old := pc.media
+	pc.media = nil
+	pc.ssrcs = make(map[uint32]*mline)
+	pc.midExtID = 0
+	for i, remote := range pc.remote.MLines {
+		if i >= len(pc.local.MLines) {
+			break
+		}
+		local := pc.local.MLines[i]
+		m := &mline{
+			index:       i,
+			kind:        remote.Type,
+			mid:         remote.Mid(),
+			formats:     formats(remote),
+			remoteSSRCs: remote.SSRCs(),
+			localSSRC:   pc.localSSRCs[i],
+			sends:       local.Port != 0 && local.Direction.Sends(),
+			disabled:    remote.Disabled() || local.Disabled(),
+		}
+		if i < len(old) && old[i].kind == m.kind {
+			m.in, m.out, m.lastIn, m.receiving = old[i].in, old[i].out, old[i].lastIn, old[i].receiving
+		}
+		if id := remote.ExtmapID(sdp.ExtMid); id != 0 && id == local.ExtmapID(sdp.ExtMid) {
+			pc.midExtID = id
+		}
+		pc.media = append(pc.media, m)
+	}
+}
+
+// incoming handles an SRTP or SRTCP packet of the client
+func (pc *PeerConnection) incoming(buf []byte) {
+	pc.mutex.Lock()
+	decrypt := pc.decrypt
+	pc.mutex.Unlock()
+	if decrypt == nil {
+		return
+	}
+	if rtcp.IsRTCP(buf) {
+		packet, err := decrypt.DecryptRTCP(nil, buf, nil)
+		if err != nil {
+			log.Debugf("[%d] Dropping SRTCP packet: %v", pc.handle.ID, err)
+			return
+		}
+		pc.mutex.Lock()
+		m := pc.rtcpMLine(packet)
+		if m != nil {
+			m.in.rtcpPackets++
+		}
+		pc.mutex.Unlock()
+		if m != nil {
+			pc.handle.IncomingRTCP(&plugins.RTCPPacket{Video: m.video(), Buffer: packet})
+		}
+		return
+	}
+	packet, err := decrypt.DecryptRTP(nil, buf, nil)
+	if err != nil {
+		log.Debugf("[%d] Dropping SRTP packet: %v", pc.handle.ID, err)
+		return
+	}
+	pc.mutex.Lock()
+	m := pc.rtpMLine(packet)
+	first := false
+	if m != nil {
+		m.in.packets++
+		m.in.bytes += uint64(len(packet))
+		m.lastIn = time.Now()
+		first, m.receiving = !m.receiving, true
+	}
+	pc.mutex.Unlock()
+	if m == nil {
+		return
+	}
+	if first {
+		pc.handle.Media(m.kind.String(), true)
+	}
+	pc.handle.IncomingRTP(&plugins.RTPPacket{Video: m.video(), Mindex: m.index, Buffer: packet})
+}
+
+// rtpMLine finds the m-line of an RTP packet: by the SSRC if already
+// seen, or else by the mid extension, the a=ssrc of the client, or the
+// payload type, in that order
+func (pc *PeerConnection) rtpMLine(packet []byte) *mline {
+	ssrc := rtp.SSRC(packet)
+	if m := pc.ssrcs[ssrc]; m != nil {
+		return m
+	}
+	var found *mline
+	if pc.midExtID != 0 {
+		if mid := string(rtp.Extension(packet, pc.midExtID)); mid != "" {
+			for _, m := range pc.media {
+				if m.mid == mid {
+					found = m
+				}
+			}
+		}
+	}
+	for _, m := range pc.media {
+		for _, s := range m.remoteSSRCs {
+			if found == nil && s == ssrc {
+				found = m
+			}
+		}
+	}
+	pt := rtp.PayloadType(packet)
+	for _, m := range pc.media {
+		if found == nil && !m.disabled && m.kind != sdp.Application && m.formats[pt] {
+			found = m
+		}
+	}
+	if found != nil && !found.disabled {
+		pc.ssrcs[ssrc] = found
+		return found
+	}
+	return nil
+}
+
+// rtcpMLine finds the m-line of an RTCP packet: the one of its sender, or
+// the one we send with the SSRC it's about
+func (pc *PeerConnection) rtcpMLine(packet []byte) *mline {
+	if m := pc.ssrcs[rtcp.SenderSSRC(packet)]; m != nil {
+		return m
+	}
+	media := rtcp.MediaSSRC(packet)
+	for _, m := range pc.media {
+		if media != 0 && m.localSSRC == media && !m.disabled {
+			return m
+		}
+	}
+	return nil
+}
+
+// sendMLine picks the m-line a packet of the plugin goes on
+func (pc *PeerConnection) sendMLine(video bool, mindex int) *mline {
+	for _, m := range pc.media {
+		if mindex >= 0 && m.index == mindex || mindex < 0 && m.kind != sdp.Application && m.video() == video {
+			return m
+		}
+	}
+	return nil
+}
+
+// SendRTP sends an RTP packet of the plugin to the client
+func (pc *PeerConnection) SendRTP(packet *plugins.RTPPacket) error {
+	if !rtp.IsRTP(packet.Buffer) {
+		return errors.New("invalid RTP packet")
+	}
+	pc.mutex.Lock()
+	m := pc.sendMLine(packet.Video, packet.Mindex)
+	if m == nil || !m.sends || m.disabled {
+		pc.mutex.Unlock()
+		return nil
+	}
+	buf := append([]byte(nil), packet.Buffer...)
+	rtp.SetSSRC(buf, m.localSSRC)
+	conn := pc.conn
+	pc.mutex.Unlock()
+	if conn == nil {
+		return errNotConnected
+	}
+
+	pc.sendMutex.Lock()
+	encrypted, err := pc.encrypt.EncryptRTP(nil, buf, nil)
+	pc.sendMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(encrypted); err != nil {
+		return err
+	}
+	pc.mutex.Lock()
+	m.out.packets++
+	m.out.bytes += uint64(len(buf))
+	pc.mutex.Unlock()
+	return nil
+}
+
+// SendRTCP sends an RTCP packet of the plugin to the client, about the
+// media it sends on the m-line of its kind
+func (pc *PeerConnection) SendRTCP(packet *plugins.RTCPPacket) error {
+	if !rtcp.IsRTCP(packet.Buffer) {
+		return errors.New("invalid RTCP packet")
+	}
+	pc.mutex.Lock()
+	m := pc.sendMLine(packet.Video, -1)
+	if m == nil || m.disabled {
+		pc.mutex.Unlock()
+		return nil
+	}
+	var media uint32
+	for ssrc, seen := range pc.ssrcs {
+		if seen == m {
+			media = ssrc
+		}
+	}
+	if media == 0 && len(m.remoteSSRCs) > 0 {
+		media = m.remoteSSRCs[0]
+	}
+	buf := append([]byte(nil), packet.Buffer...)
+	rtcp.FixSSRC(buf, m.localSSRC, media)
+	conn := pc.conn
+	pc.mutex.Unlock()
+	if conn == nil {
+		return errNotConnected
+	}
+
+	pc.sendMutex.Lock()
+	encrypted, err := pc.encrypt.EncryptRTCP(nil, buf, nil)
+	pc.sendMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(encrypted); err != nil {
+		return err
+	}
+	pc.mutex.Lock()
+	m.out.rtcpPackets++
+	pc.mutex.Unlock()
+	return nil
+}
+
+// watchMedia tells the handle when media stops coming for a while (the
+// no_media_timer of conf.yaml)
+func (pc *PeerConnection) watchMedia() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	noMedia := load().noMedia
+	for {
+		select {
+		case <-pc.closed:
+			return
+		case now := <-ticker.C:
+			var stopped []string
+			pc.mutex.Lock()
+			for _, m := range pc.media {
+				if m.receiving && now.Sub(m.lastIn) >= noMedia {
+					m.receiving = false
+					stopped = append(stopped, m.kind.String())
+				}
+			}
+			pc.mutex.Unlock()
+			for _, kind := range stopped {
+				pc.handle.Media(kind, false)
+			}
+		}
+	}
+}
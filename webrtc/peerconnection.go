@@ -0,0 +1,519 @@
+package webrtc
+
+// A PeerConnection is ICE first, then DTLS on the pair ICE picked, then
+// SRTP keyed by the DTLS handshake, and SCTP over DTLS for data channels.
+// The ICE transport carries both DTLS and SRTP: packets are told apart by
+// their first byte (RFC 7983).
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/datachannel"
+	"github.com/pion/dtls/v2"
+	"github.com/pion/ice/v2"
+	"github.com/pion/sctp"
+	"github.com/pion/srtp/v2"
+	"github.com/pion/transport/v2/packetio"
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/sdp"
+	"github.com/xroger88/go-janus/session"
+)
+
+// DTLS states, as in handle_info
+const (
+	dtlsCreated   = "created"
+	dtlsTrying    = "trying"
+	dtlsConnected = "connected"
+	dtlsFailed    = "failed"
+	dtlsClosed    = "closed"
+)
+
+const (
+	// how long the DTLS handshake may take, once ICE is done
+	handshakeTimeout = 30 * time.Second
+	// the largest packet we expect on the ICE transport
+	receiveMTU = 8192
+	// how much DTLS may be waiting to be read
+	dtlsBufferSize = 1000 * 1000
+	// the size of the SRTP replay protection windows
+	replayWindow = 64
+)
+
+var srtpProfiles = map[dtls.SRTPProtectionProfile]string{
+	dtls.SRTP_AES128_CM_HMAC_SHA1_80: "SRTP_AES128_CM_SHA1_80",
+	dtls.SRTP_AES128_CM_HMAC_SHA1_32: "SRTP_AES128_CM_SHA1_32",
+	dtls.SRTP_AEAD_AES_128_GCM:       "SRTP_AEAD_AES_128_GCM",
+	dtls.SRTP_AEAD_AES_256_GCM:       "SRTP_AEAD_AES_256_GCM",
+}
+
+// PeerConnection is the session.PeerConnection of a handle
+type PeerConnection struct {
+	handle     *session.Handle
+	agent      *ice.Agent
+	ufrag, pwd string
+	gathered   chan struct{} // closed once the local candidates are all there
+	closed     chan struct{}
+	closeOnce  sync.Once
+
+	mutex            sync.Mutex
+	candidates       []string // local ones, as in a=candidate
+	remoteCandidates []string
+	local, remote    *sdp.SDP
+	offerer          bool       // whether the last offer was ours
+	params           *transport // of the client
+	setup            string     // our DTLS role, once known
+	started          bool
+	iceState         ice.ConnectionState
+	dtlsState        string
+	srtpProfile      string
+	conn             *ice.Conn
+	dtls             *dtls.Conn
+	decrypt          *srtp.Context     // only used by the read loop
+	localSSRCs       map[int]uint32    // what we send with, by mindex
+	media            []*mline          // as negotiated
+	ssrcs            map[uint32]*mline // of the client, as seen in its packets
+	midExtID         int
+	sctp             *sctp.Association
+	channels         []*datachannel.DataChannel
+	nextStreamID     uint16
+
+	sendMutex sync.Mutex
+	encrypt   *srtp.Context
+}
+
+func newPeerConnection(h *session.Handle) (*PeerConnection, error) {
+	s := load()
+	if s.err != nil {
+		return nil, s.err
+	}
+	config := s.agent
+	agent, err := ice.NewAgent(&config)
+	if err != nil {
+		return nil, err
+	}
+	pc := &PeerConnection{
+		handle:     h,
+		agent:      agent,
+		gathered:   make(chan struct{}),
+		closed:     make(chan struct{}),
+		iceState:   ice.ConnectionStateNew,
+		dtlsState:  dtlsCreated,
+		localSSRCs: make(map[int]uint32),
+		ssrcs:      make(map[uint32]*mline),
+	}
+	if pc.ufrag, pc.pwd, err = agent.GetLocalUserCredentials(); err != nil {
+		agent.Close()
+		return nil, err
+	}
+	agent.OnCandidate(func(c ice.Candidate) {
+		if c == nil {
+			close(pc.gathered)
+			return
+		}
+		pc.mutex.Lock()
+		pc.candidates = append(pc.candidates, c.Marshal())
+		pc.mutex.Unlock()
+	})
+	agent.OnConnectionStateChange(func(state ice.ConnectionState) {
+		pc.mutex.Lock()
+		pc.iceState = state
+		pc.mutex.Unlock()
+		log.Debugf("[%d] ICE %s", h.ID, state)
+		if state == ice.ConnectionStateFailed {
+			// not from here: closing the agent waits for this to return
+			go pc.fail("ICE failed", nil)
+		}
+	})
+	if err := agent.GatherCandidates(); err != nil {
+		agent.Close()
+		return nil, err
+	}
+	log.Infof("[%d] Created PeerConnection", h.ID)
+	return pc, nil
+}
+
+// setRemote takes a description of the client
+func (pc *PeerConnection) setRemote(kind string, description *sdp.SDP, params *transport) error {
+	trickles := pc.handle.TakeTrickles()
+	pc.mutex.Lock()
+	err := pc.setRemoteLocked(kind, description, params, trickles)
+	start := err == nil && pc.ready()
+	pc.mutex.Unlock()
+	if err != nil {
+		// they'll do for the next description
+		pc.handle.Trickle(trickles...)
+		return err
+	}
+	if start {
+		go pc.connect()
+	}
+	return nil
+}
+
+func (pc *PeerConnection) setRemoteLocked(kind string, description *sdp.SDP, params *transport, trickles []json.RawMessage) error {
+	if pc.params != nil && (params.ufrag != pc.params.ufrag || params.pwd != pc.params.pwd) {
+		return ErrICERestart
+	}
+	switch {
+	case kind == "offer":
+		pc.offerer = false
+	case pc.local == nil || !pc.offerer:
+		return ErrUnexpectedAnswer
+	}
+	if pc.setup == "" {
+		switch {
+		case kind == "offer" && params.setup == setupActive:
+			pc.setup = setupPassive
+		case kind == "offer":
+			pc.setup = setupActive
+		case params.setup == setupActive:
+			pc.setup = setupPassive
+		case params.setup == setupPassive:
+			pc.setup = setupActive
+		default:
+			return fmt.Errorf("invalid DTLS setup %s in an answer", params.setup)
+		}
+	}
+	pc.params = params
+	pc.remote = description
+	for _, c := range params.candidates {
+		pc.addRemoteCandidate(c)
+	}
+	pc.addTrickles(trickles)
+	pc.negotiate()
+	return nil
+}
+
+// setLocal takes a description of the plugin, and adds our ICE and DTLS
+// parameters to it, once the local candidates have been gathered
+func (pc *PeerConnection) setLocal(kind string, description *sdp.SDP) error {
+	select {
+	case <-pc.gathered:
+	case <-pc.closed:
+	case <-time.After(gatheringTimeout):
+		log.Warnf("[%d] Still gathering candidates, sending the ones we have", pc.handle.ID)
+	}
+	pc.mutex.Lock()
+	switch {
+	case kind == "offer":
+		pc.offerer = true
+	case pc.remote == nil || pc.offerer:
+		pc.mutex.Unlock()
+		return ErrUnexpectedAnswer
+	}
+	setup := pc.setup
+	if setup == "" {
+		setup = setupActPass
+	}
+	for i, m := range description.MLines {
+		if ssrc := ssrcOf(m); ssrc != 0 {
+			pc.localSSRCs[i] = ssrc
+		} else if pc.localSSRCs[i] == 0 {
+			pc.localSSRCs[i] = rand.Uint32()
+		}
+	}
+	pc.describe(description, setup, pc.candidates, pc.localSSRCs)
+	pc.local = description
+	pc.negotiate()
+	start := pc.ready()
+	pc.mutex.Unlock()
+	if start {
+		go pc.connect()
+	}
+	return nil
+}
+
+// ready tells whether it's time to connect, which only happens once
+func (pc *PeerConnection) ready() bool {
+	if pc.started || pc.local == nil || pc.remote == nil || pc.setup == "" {
+		return false
+	}
+	pc.started = true
+	return true
+}
+
+// addRemoteCandidate passes a candidate of the client to ICE
+func (pc *PeerConnection) addRemoteCandidate(value string) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "candidate:")
+	c, err := ice.UnmarshalCandidate(value)
+	if err != nil {
+		log.Warnf("[%d] Invalid candidate %q: %v", pc.handle.ID, value, err)
+		return
+	}
+	// with rtcp-mux, there's only the RTP component
+	if c.Component() != ice.ComponentRTP {
+		return
+	}
+	if err := pc.agent.AddRemoteCandidate(c); err != nil {
+		log.Warnf("[%d] Can't add candidate %q: %v", pc.handle.ID, value, err)
+		return
+	}
+	pc.remoteCandidates = append(pc.remoteCandidates, value)
+}
+
+// takeTrickles takes the candidates queued on the handle, once we know
+// the ICE credentials of the client
+func (pc *PeerConnection) takeTrickles() {
+	pc.mutex.Lock()
+	known := pc.params != nil
+	pc.mutex.Unlock()
+	if !known {
+		return
+	}
+	trickles := pc.handle.TakeTrickles()
+	pc.mutex.Lock()
+	pc.addTrickles(trickles)
+	pc.mutex.Unlock()
+}
+
+func (pc *PeerConnection) addTrickles(trickles []json.RawMessage) {
+	for _, raw := range trickles {
+		var trickle struct {
+			Candidate string
+			Completed bool
+		}
+		if json.Unmarshal(raw, &trickle) == nil && !trickle.Completed && trickle.Candidate != "" {
+			pc.addRemoteCandidate(trickle.Candidate)
+		}
+	}
+}
+
+// connect runs ICE and the DTLS handshake, and gets the media going
+func (pc *PeerConnection) connect() {
+	h, s := pc.handle, load()
+	pc.mutex.Lock()
+	params, setup, offerer := pc.params, pc.setup, pc.offerer
+	pc.mutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-pc.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// the offerer is in control, unless one of us is ICE lite
+	controlling := offerer && !s.agent.Lite || params.lite
+	if h.SetState(session.StateICE) != nil {
+		return
+	}
+	var conn *ice.Conn
+	var err error
+	if controlling {
+		conn, err = pc.agent.Dial(ctx, params.ufrag, params.pwd)
+	} else {
+		conn, err = pc.agent.Accept(ctx, params.ufrag, params.pwd)
+	}
+	if err != nil {
+		pc.fail("ICE failed", err)
+		return
+	}
+	log.Infof("[%d] ICE connected", h.ID)
+
+	if h.SetState(session.StateDTLS) != nil {
+		return
+	}
+	pc.setDTLSState(dtlsTrying)
+	incoming := packetio.NewBuffer()
+	incoming.SetLimitSize(dtlsBufferSize)
+	go pc.read(conn, incoming)
+	config := &dtls.Config{
+		Certificates:           []tls.Certificate{s.certificate},
+		SRTPProtectionProfiles: []dtls.SRTPProtectionProfile{dtls.SRTP_AEAD_AES_128_GCM, dtls.SRTP_AES128_CM_HMAC_SHA1_80},
+		ClientAuth:             dtls.RequireAnyClientCert,
+		ExtendedMasterSecret:   dtls.RequestExtendedMasterSecret,
+		// there's no CA to check the certificate against, only the fingerprint
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: params.verify,
+		MTU:                   s.mtu,
+		LoggerFactory:         loggerFactory{},
+	}
+	handshake, done := context.WithTimeout(ctx, handshakeTimeout)
+	defer done()
+	var dconn *dtls.Conn
+	endpoint := &dtlsEndpoint{conn: conn, incoming: incoming}
+	if setup == setupActive {
+		dconn, err = dtls.ClientWithContext(handshake, endpoint, config)
+	} else {
+		dconn, err = dtls.ServerWithContext(handshake, endpoint, config)
+	}
+	if err != nil {
+		pc.setDTLSState(dtlsFailed)
+		pc.fail("DTLS alert", err)
+		return
+	}
+	encrypt, decrypt, profile, err := keys(dconn, setup == setupActive)
+	if err != nil {
+		dconn.Close()
+		pc.setDTLSState(dtlsFailed)
+		pc.fail("DTLS alert", err)
+		return
+	}
+
+	pc.sendMutex.Lock()
+	pc.encrypt = encrypt
+	pc.sendMutex.Unlock()
+	pc.mutex.Lock()
+	select {
+	case <-pc.closed:
+		pc.mutex.Unlock()
+		dconn.Close()
+		return
+	default:
+	}
+	pc.conn, pc.dtls, pc.decrypt = conn, dconn, decrypt
+	pc.dtlsState, pc.srtpProfile = dtlsConnected, srtpProfiles[profile]
+	data := false
+	for _, m := range pc.media {
+		data = data || m.kind == sdp.Application && !m.disabled
+	}
+	pc.mutex.Unlock()
+	log.Infof("[%d] DTLS connected (%s), %s", h.ID, map[bool]string{true: "client", false: "server"}[setup == setupActive], srtpProfiles[profile])
+
+	h.SetState(session.StateMedia)
+	if data {
+		go pc.serveData(dconn)
+	} else {
+		go pc.watchDTLS(dconn)
+	}
+	go pc.watchMedia()
+}
+
+// verify checks the certificate of the client has the fingerprint of its SDP
+func (t *transport) verify(certificates [][]byte, _ [][]*x509.Certificate) error {
+	if len(certificates) == 0 {
+		return errors.New("no certificate")
+	}
+	digest := t.hash.New()
+	digest.Write(certificates[0])
+	hex := fmt.Sprintf("%X", digest.Sum(nil))
+	var colons []string
+	for i := 0; i+2 <= len(hex); i += 2 {
+		colons = append(colons, hex[i:i+2])
+	}
+	if got := strings.Join(colons, ":"); got != t.fingerprint {
+		return fmt.Errorf("fingerprint mismatch: got %s, want %s", got, t.fingerprint)
+	}
+	return nil
+}
+
+// keys creates the SRTP contexts, from the keys of the DTLS handshake
+func keys(dconn *dtls.Conn, client bool) (encrypt, decrypt *srtp.Context, profile dtls.SRTPProtectionProfile, err error) {
+	profile, ok := dconn.SelectedSRTPProtectionProfile()
+	if !ok {
+		return nil, nil, 0, errors.New("no SRTP protection profile")
+	}
+	config := &srtp.Config{Profile: srtp.ProtectionProfile(profile)}
+	state := dconn.ConnectionState()
+	if err = config.ExtractSessionKeysFromDTLS(&state, client); err != nil {
+		return
+	}
+	k := config.Keys
+	if encrypt, err = srtp.CreateContext(k.LocalMasterKey, k.LocalMasterSalt, config.Profile); err != nil {
+		return
+	}
+	decrypt, err = srtp.CreateContext(k.RemoteMasterKey, k.RemoteMasterSalt, config.Profile,
+		srtp.SRTPReplayProtection(replayWindow), srtp.SRTCPReplayProtection(replayWindow))
+	return
+}
+
+// read dispatches what comes on the ICE transport, until it's closed
+func (pc *PeerConnection) read(conn *ice.Conn, dtlsIncoming *packetio.Buffer) {
+	defer dtlsIncoming.Close()
+	buf := make([]byte, receiveMTU)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		switch b := buf[0]; {
+		case n == 0:
+		case b >= 20 && b <= 63:
+			dtlsIncoming.Write(buf[:n])
+		case b >= 128 && b <= 191:
+			pc.incoming(buf[:n])
+		}
+	}
+}
+
+// watchDTLS waits for the client to close DTLS, without data channels
+// (SCTP reads DTLS otherwise)
+func (pc *PeerConnection) watchDTLS(dconn *dtls.Conn) {
+	buf := make([]byte, receiveMTU)
+	for {
+		if _, err := dconn.Read(buf); err != nil {
+			pc.fail("DTLS alert", err)
+			return
+		}
+	}
+}
+
+// fail hangs the handle up, unless the PeerConnection is already closed
+func (pc *PeerConnection) fail(reason string, err error) {
+	select {
+	case <-pc.closed:
+		return
+	default:
+	}
+	if err != nil {
+		log.Warnf("[%d] %s: %v", pc.handle.ID, reason, err)
+	}
+	pc.handle.Hangup(reason)
+}
+
+func (pc *PeerConnection) setDTLSState(state string) {
+	pc.mutex.Lock()
+	pc.dtlsState = state
+	pc.mutex.Unlock()
+}
+
+// Close tears everything down, e.g. when the handle hangs up
+func (pc *PeerConnection) Close() {
+	pc.closeOnce.Do(func() {
+		close(pc.closed)
+		forget(pc)
+		pc.mutex.Lock()
+		association, dconn := pc.sctp, pc.dtls
+		if dconn != nil {
+			pc.dtlsState = dtlsClosed
+		}
+		pc.mutex.Unlock()
+		if association != nil {
+			association.Close()
+		}
+		if dconn != nil {
+			dconn.Close()
+		}
+		pc.agent.Close()
+		log.Infof("[%d] PeerConnection closed", pc.handle.ID)
+	})
+}
+
+// dtlsEndpoint is the DTLS side of the ICE transport: it reads what the
+// read loop found to be DTLS, and writes straight to ICE
+type dtlsEndpoint struct {
+	conn     *ice.Conn
+	incoming *packetio.Buffer
+}
+
+func (e *dtlsEndpoint) Read(p []byte) (int, error)         { return e.incoming.Read(p) }
+func (e *dtlsEndpoint) Write(p []byte) (int, error)        { return e.conn.Write(p) }
+func (e *dtlsEndpoint) Close() error                       { return e.incoming.Close() }
+func (e *dtlsEndpoint) LocalAddr() net.Addr                { return e.conn.LocalAddr() }
+func (e *dtlsEndpoint) RemoteAddr() net.Addr               { return e.conn.RemoteAddr() }
+func (e *dtlsEndpoint) SetDeadline(t time.Time) error      { return e.incoming.SetReadDeadline(t) }
+func (e *dtlsEndpoint) SetReadDeadline(t time.Time) error  { return e.incoming.SetReadDeadline(t) }
+func (e *dtlsEndpoint) SetWriteDeadline(t time.Time) error { return nil }
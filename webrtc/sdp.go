@@ -0,0 +1,210 @@
+package webrtc
+
+// What the stack takes from (and adds to) the session descriptions: the
+// ICE credentials and candidates, and the DTLS fingerprint and role. All
+// the m-lines share the transport of the first one that isn't rejected.
+
+import (
+	"crypto"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/xroger88/go-janus/sdp"
+)
+
+// transportAttributes are the attributes of the ICE and DTLS transport
+var transportAttributes = []string{
+	"ice-ufrag", "ice-pwd", "ice-options", "ice-lite", "ice-mismatch",
+	"fingerprint", "setup", "candidate", "end-of-candidates", "remote-candidates",
+}
+
+// DTLS setup roles (RFC 4145), as in a=setup
+const (
+	setupActive  = "active"
+	setupPassive = "passive"
+	setupActPass = "actpass"
+)
+
+// hashes are the fingerprint algorithms we can check, by SDP name
+var hashes = map[string]crypto.Hash{
+	"sha-1":   crypto.SHA1,
+	"sha-224": crypto.SHA224,
+	"sha-256": crypto.SHA256,
+	"sha-384": crypto.SHA384,
+	"sha-512": crypto.SHA512,
+}
+
+// transport is the ICE and DTLS side of a description of the client
+type transport struct {
+	ufrag, pwd  string
+	lite        bool
+	hash        crypto.Hash
+	fingerprint string // upper case hex, with colons
+	setup       string
+	candidates  []string // what follows "a=candidate:"
+}
+
+func parse(text string) (*sdp.SDP, error) {
+	description, err := sdp.Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SDP: %v", err)
+	}
+	return description, nil
+}
+
+// bundled returns the first m-line that isn't rejected, or nil
+func bundled(description *sdp.SDP) *sdp.MLine {
+	for _, m := range description.MLines {
+		if m.Port != 0 {
+			return m
+		}
+	}
+	return nil
+}
+
+// transportOf returns the ICE and DTLS parameters of a description: the
+// ones of the first m-line that isn't rejected, or the session ones
+func transportOf(description *sdp.SDP) (*transport, error) {
+	value := func(name string) string {
+		if m := bundled(description); m != nil {
+			if a := m.Attribute(name); a != nil {
+				return a.Value
+			}
+		}
+		if a := description.Attribute(name); a != nil {
+			return a.Value
+		}
+		return ""
+	}
+	t := &transport{
+		ufrag: value("ice-ufrag"),
+		pwd:   value("ice-pwd"),
+		lite:  description.Attribute("ice-lite") != nil,
+		setup: value("setup"),
+	}
+	if t.ufrag == "" || t.pwd == "" {
+		return nil, fmt.Errorf("missing ICE credentials")
+	}
+	f := strings.Fields(value("fingerprint"))
+	if len(f) != 2 {
+		return nil, fmt.Errorf("missing DTLS fingerprint")
+	}
+	hash, known := hashes[strings.ToLower(f[0])]
+	if !known {
+		return nil, fmt.Errorf("unsupported fingerprint hash %s", f[0])
+	}
+	t.hash, t.fingerprint = hash, strings.ToUpper(f[1])
+	switch t.setup {
+	case "":
+		t.setup = setupActPass
+	case setupActive, setupPassive, setupActPass:
+	default:
+		return nil, fmt.Errorf("invalid DTLS setup %s", t.setup)
+	}
+	for _, m := range description.MLines {
+		if m.Port != 0 {
+			t.candidates = append(t.candidates, m.Values("candidate")...)
+		}
+	}
+	return t, nil
+}
+
+// strip takes the ICE and DTLS attributes out of a description
+func strip(description *sdp.SDP) {
+	kept := description.Attributes[:0]
+	for _, a := range description.Attributes {
+		if !contains(transportAttributes, a.Name) {
+			kept = append(kept, a)
+		}
+	}
+	description.Attributes = kept
+	for _, m := range description.MLines {
+		for _, name := range transportAttributes {
+			m.RemoveAttributes(name)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// describe adds the ICE and DTLS parameters of the PeerConnection to a
+// description of the plugin, as well as the SSRCs it sends media with
+func (pc *PeerConnection) describe(description *sdp.SDP, setup string, candidates []string, ssrcs map[int]uint32) {
+	s := load()
+	if s.agent.Lite {
+		description.Attributes = append(description.Attributes, &sdp.Attribute{Name: "ice-lite"})
+	}
+	if description.Connection == "" {
+		description.Connection = connection(candidates)
+	}
+	first := true
+	for i, m := range description.MLines {
+		if m.Port == 0 {
+			continue
+		}
+		m.AddAttribute("ice-ufrag", pc.ufrag)
+		m.AddAttribute("ice-pwd", pc.pwd)
+		m.AddAttribute("ice-options", "trickle")
+		m.AddAttribute("fingerprint", s.fingerprint)
+		m.AddAttribute("setup", setup)
+		if m.Type != sdp.Application && m.Attribute("rtcp-mux") == nil {
+			m.AddAttribute("rtcp-mux", "")
+		}
+		if ssrc := ssrcs[i]; ssrc != 0 && m.Type != sdp.Application && m.Direction.Sends() && m.Attribute("ssrc") == nil {
+			label := "janus" + m.Type.String()
+			m.AddAttribute("msid", "janus "+label)
+			m.AddAttribute("ssrc", fmt.Sprintf("%d cname:janus", ssrc))
+			m.AddAttribute("ssrc", fmt.Sprintf("%d msid:janus %s", ssrc, label))
+		}
+		if first {
+			for _, c := range candidates {
+				m.AddAttribute("candidate", c)
+			}
+			m.AddAttribute("end-of-candidates", "")
+			first = false
+		}
+	}
+}
+
+// connection returns the c= line of a description with candidates
+func connection(candidates []string) string {
+	for _, c := range candidates {
+		if f := strings.Fields(c); len(f) >= 5 {
+			if ip := net.ParseIP(f[4]); ip != nil {
+				if ip.To4() == nil {
+					return "IN IP6 " + ip.String()
+				}
+				return "IN IP4 " + ip.String()
+			}
+		}
+	}
+	return "IN IP4 0.0.0.0"
+}
+
+// ssrcOf returns the first SSRC of the a=ssrc attributes of an m-line, or 0
+func ssrcOf(m *sdp.MLine) uint32 {
+	if ssrcs := m.SSRCs(); len(ssrcs) > 0 {
+		return ssrcs[0]
+	}
+	return 0
+}
+
+// formats returns the payload types of an m-line
+func formats(m *sdp.MLine) map[uint8]bool {
+	pts := make(map[uint8]bool)
+	for _, f := range m.Formats {
+		if pt, err := strconv.Atoi(f); err == nil && pt >= 0 && pt < 128 {
+			pts[uint8(pt)] = true
+		}
+	}
+	return pts
+}
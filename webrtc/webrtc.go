@@ -0,0 +1,345 @@
+package webrtc
+
+// The WebRTC stack: each handle negotiating a PeerConnection gets one here,
+// with ICE, DTLS and SRTP on a single transport (BUNDLE and rtcp-mux are a
+// must) and data channels over SCTP, all built on the pion libraries.
+// Plugins never deal with any of it: the ICE and DTLS attributes of what
+// clients send are taken out before plugins see it, and the ones of the
+// PeerConnection are added to what plugins send. Once both sides have a
+// description, the PeerConnection connects and drives the handle through
+// its states (ice, dtls, media), and media goes between it and the plugin.
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/dtls/v2/pkg/crypto/fingerprint"
+	"github.com/pion/dtls/v2/pkg/crypto/selfsign"
+	"github.com/pion/ice/v2"
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	log "github.com/sirupsen/logrus"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/session"
+)
+
+var (
+	// ErrUnexpectedAnswer is an answer coming without an offer to answer
+	ErrUnexpectedAnswer = errors.New("unexpected answer")
+	// ErrICERestart is an offer with new ICE credentials
+	ErrICERestart = errors.New("ICE restarts are not supported")
+)
+
+// defaults of the media section of conf.yaml
+const (
+	defaultDTLSMTU = 1200
+	defaultNoMedia = time.Second
+	// how long a description waits for the candidates to be gathered
+	gatheringTimeout = 5 * time.Second
+)
+
+// settings are what the stack takes from conf.yaml, once
+type settings struct {
+	agent       ice.AgentConfig
+	certificate tls.Certificate
+	fingerprint string // as in a=fingerprint, e.g. "sha-256 AB:CD:..."
+	mtu         int
+	noMedia     time.Duration
+	err         error
+}
+
+var (
+	settingsOnce sync.Once
+	current      settings
+)
+
+// Init reads the settings of the stack (the certificates, media and nat
+// sections of conf.yaml), so that a broken one stops the server right away.
+// Without Init, they're read when the first PeerConnection is created.
+func Init() error {
+	return load().err
+}
+
+func load() *settings {
+	settingsOnce.Do(func() {
+		current.err = current.read()
+		if current.err == nil {
+			log.Infof("WebRTC stack ready, DTLS fingerprint %s", current.fingerprint)
+		}
+	})
+	return &current
+}
+
+func (s *settings) read() error {
+	media, nat := &config.Conf.Media, &config.Conf.Nat
+	s.mtu = media.Dtls_mtu
+	if s.mtu <= 0 {
+		s.mtu = defaultDTLSMTU
+	}
+	s.noMedia = time.Duration(media.No_media_timer) * time.Second
+	if s.noMedia <= 0 {
+		s.noMedia = defaultNoMedia
+	}
+
+	certs := &config.Conf.Certificates
+	if certs.Cert_pem != "" && certs.Cert_key != "" {
+		cert, err := config.LoadCertificate(certs.Cert_pem, certs.Cert_key, certs.Cert_pwd)
+		if err != nil {
+			return fmt.Errorf("can't load the DTLS certificate: %v", err)
+		}
+		s.certificate = *cert
+	} else {
+		cert, err := selfsign.GenerateSelfSigned()
+		if err != nil {
+			return fmt.Errorf("can't generate a DTLS certificate: %v", err)
+		}
+		s.certificate = cert
+	}
+	leaf, err := x509.ParseCertificate(s.certificate.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("invalid DTLS certificate: %v", err)
+	}
+	digest, err := fingerprint.Fingerprint(leaf, crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	s.fingerprint = "sha-256 " + strings.ToUpper(digest)
+
+	disconnected, failed := 5*time.Second, 25*time.Second
+	s.agent = ice.AgentConfig{
+		NetworkTypes:        []ice.NetworkType{ice.NetworkTypeUDP4},
+		CandidateTypes:      []ice.CandidateType{ice.CandidateTypeHost},
+		MulticastDNSMode:    ice.MulticastDNSModeDisabled,
+		Lite:                nat.Ice_lite,
+		DisconnectedTimeout: &disconnected,
+		FailedTimeout:       &failed,
+		LoggerFactory:       loggerFactory{},
+	}
+	if media.Ipv6 {
+		s.agent.NetworkTypes = append(s.agent.NetworkTypes, ice.NetworkTypeUDP6)
+	}
+	if nat.Ice_tcp {
+		log.Warnf("ICE-TCP is not supported, only UDP candidates will be gathered")
+	}
+	if media.Rtp_port_range != "" {
+		min, max := rtp.PortRange()
+		s.agent.PortMin, s.agent.PortMax = uint16(min), uint16(max)
+	}
+	if nat.Nat_1_1_mapping != "" {
+		for _, ip := range strings.Split(nat.Nat_1_1_mapping, ",") {
+			s.agent.NAT1To1IPs = append(s.agent.NAT1To1IPs, strings.TrimSpace(ip))
+		}
+		s.agent.NAT1To1IPCandidateType = ice.CandidateTypeHost
+	}
+	if nat.Stun_server != "" && !nat.Ice_lite {
+		port := nat.Stun_port
+		if port == 0 {
+			port = 3478
+		}
+		uri, err := stun.ParseURI(fmt.Sprintf("stun:%s:%d", nat.Stun_server, port))
+		if err != nil {
+			return fmt.Errorf("invalid STUN server: %v", err)
+		}
+		s.agent.Urls = append(s.agent.Urls, uri)
+		s.agent.CandidateTypes = append(s.agent.CandidateTypes, ice.CandidateTypeServerReflexive)
+	}
+	if nat.Turn_server != "" && !nat.Ice_lite {
+		uri, err := stun.ParseURI(fmt.Sprintf("turn:%s:%d?transport=%s", nat.Turn_server, nat.Turn_port, strings.ToLower(nat.Turn_type)))
+		if err != nil {
+			return fmt.Errorf("invalid TURN server: %v", err)
+		}
+		uri.Username, uri.Password = nat.Turn_user, nat.Turn_pwd
+		s.agent.Urls = append(s.agent.Urls, uri)
+		s.agent.CandidateTypes = append(s.agent.CandidateTypes, ice.CandidateTypeRelay)
+	}
+	s.filter(list(nat.Ice_enforce_list), list(nat.Ice_ignore_list))
+	return nil
+}
+
+// list splits a comma-separated list of the nat section
+func list(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// filter sets which interfaces and addresses candidates are gathered on.
+// Like in the original Janus, the lists have interface names or address
+// prefixes: ice_enforce_list names the only ones to use, and
+// ice_ignore_list the ones to leave out. Loopback addresses are only used
+// if the enforce list names them.
+func (s *settings) filter(enforce, ignore []string) {
+	isName := func(item string) bool {
+		_, err := net.InterfaceByName(item)
+		return err == nil
+	}
+	var enforceNames, enforceAddresses []string
+	for _, item := range enforce {
+		if isName(item) {
+			enforceNames = append(enforceNames, item)
+			if ifi, _ := net.InterfaceByName(item); ifi.Flags&net.FlagLoopback != 0 {
+				s.agent.IncludeLoopback = true
+			}
+		} else {
+			enforceAddresses = append(enforceAddresses, item)
+			if ip := net.ParseIP(item); ip != nil && ip.IsLoopback() || strings.HasPrefix(item, "127.") {
+				s.agent.IncludeLoopback = true
+			}
+		}
+	}
+	s.agent.InterfaceFilter = func(name string) bool {
+		for _, item := range ignore {
+			if item == name {
+				return false
+			}
+		}
+		if len(enforceNames) == 0 {
+			return true
+		}
+		for _, item := range enforceNames {
+			if item == name {
+				return true
+			}
+		}
+		return len(enforceAddresses) > 0
+	}
+	s.agent.IPFilter = func(ip net.IP) bool {
+		address := ip.String()
+		for _, item := range ignore {
+			if strings.HasPrefix(address, item) {
+				return false
+			}
+		}
+		if len(enforceAddresses) == 0 {
+			return true
+		}
+		for _, item := range enforceAddresses {
+			if strings.HasPrefix(address, item) {
+				return true
+			}
+		}
+		return len(enforceNames) > 0
+	}
+}
+
+// the PeerConnections, by handle
+var (
+	peerConnectionsMutex sync.Mutex
+	peerConnections      = make(map[*session.Handle]*PeerConnection)
+)
+
+// peerConnectionOf returns the PeerConnection of a handle, creating it
+// (and binding it to the handle) if asked to
+func peerConnectionOf(h *session.Handle, create bool) (*PeerConnection, error) {
+	peerConnectionsMutex.Lock()
+	defer peerConnectionsMutex.Unlock()
+	if pc := peerConnections[h]; pc != nil || !create {
+		return pc, nil
+	}
+	if h.State() == session.StateDetached {
+		return nil, session.ErrHandleDetached
+	}
+	pc, err := newPeerConnection(h)
+	if err != nil {
+		return nil, err
+	}
+	peerConnections[h] = pc
+	h.SetPeerConnection(pc)
+	return pc, nil
+}
+
+func forget(pc *PeerConnection) {
+	peerConnectionsMutex.Lock()
+	defer peerConnectionsMutex.Unlock()
+	if peerConnections[pc.handle] == pc {
+		delete(peerConnections, pc.handle)
+	}
+}
+
+// Remote takes a description the client of a handle sent: the
+// PeerConnection learns the ICE and DTLS parameters of the client, and
+// what's returned is the description for the plugin, without them
+func Remote(h *session.Handle, jsep *plugins.JSEP) (*plugins.JSEP, error) {
+	description, err := parse(jsep.SDP)
+	if err != nil {
+		return nil, err
+	}
+	params, err := transportOf(description)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := peerConnectionOf(h, jsep.Type == "offer")
+	if err != nil {
+		return nil, err
+	}
+	if pc == nil {
+		return nil, ErrUnexpectedAnswer
+	}
+	if err := pc.setRemote(jsep.Type, description, params); err != nil {
+		return nil, err
+	}
+	strip(description)
+	return &plugins.JSEP{Type: jsep.Type, SDP: description.String(), Trickle: jsep.Trickle}, nil
+}
+
+// Local takes a description the plugin of a handle sends to its client,
+// and returns it with the ICE and DTLS parameters of the PeerConnection
+func Local(h *session.Handle, jsep *plugins.JSEP) (*plugins.JSEP, error) {
+	description, err := parse(jsep.SDP)
+	if err != nil {
+		return nil, err
+	}
+	strip(description)
+	pc, err := peerConnectionOf(h, jsep.Type == "offer")
+	if err != nil {
+		return nil, err
+	}
+	if pc == nil {
+		return nil, ErrUnexpectedAnswer
+	}
+	if err := pc.setLocal(jsep.Type, description); err != nil {
+		return nil, err
+	}
+	return &plugins.JSEP{Type: jsep.Type, SDP: description.String()}, nil
+}
+
+// Trickle passes the candidates the client of a handle trickled to its
+// PeerConnection, unless it doesn't know the ICE credentials of the
+// client yet: they then wait on the handle until it does
+func Trickle(h *session.Handle) {
+	if pc, _ := peerConnectionOf(h, false); pc != nil {
+		pc.takeTrickles()
+	}
+}
+
+// loggerFactory sends what pion logs to our log: it's mostly details, so
+// only its errors make it past the debug level
+type loggerFactory struct{}
+
+func (loggerFactory) NewLogger(scope string) logging.LeveledLogger { return logger(scope) }
+
+type logger string
+
+func (l logger) Trace(msg string)                          {}
+func (l logger) Tracef(format string, args ...interface{}) {}
+func (l logger) Debug(msg string)                          { log.Debugf("[%s] %s", l, msg) }
+func (l logger) Debugf(format string, args ...interface{}) { l.Debug(fmt.Sprintf(format, args...)) }
+func (l logger) Info(msg string)                           { log.Debugf("[%s] %s", l, msg) }
+func (l logger) Infof(format string, args ...interface{})  { l.Info(fmt.Sprintf(format, args...)) }
+func (l logger) Warn(msg string)                           { log.Debugf("[%s] %s", l, msg) }
+func (l logger) Warnf(format string, args ...interface{})  { l.Warn(fmt.Sprintf(format, args...)) }
+func (l logger) Error(msg string)                          { log.Warnf("[%s] %s", l, msg) }
+func (l logger) Errorf(format string, args ...interface{}) { l.Error(fmt.Sprintf(format, args...)) }
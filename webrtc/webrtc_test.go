@@ -0,0 +1,375 @@
+package webrtc
+
+import (
+	"crypto"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/ice/v2"
+	pionrtp "github.com/pion/rtp"
+	pion "github.com/pion/webrtc/v3"
+	"github.com/xroger88/go-janus/config"
+	"github.com/xroger88/go-janus/plugins"
+	"github.com/xroger88/go-janus/rtp"
+	"github.com/xroger88/go-janus/sdp"
+	"github.com/xroger88/go-janus/session"
+)
+
+const testFingerprint = "sha-256 AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB:AB"
+
+// offer is what a browser would offer, with the transport attributes added
+func offer(session, audio string) string {
+	return "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\na=group:BUNDLE 0 1\r\n" + session +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\nc=IN IP4 0.0.0.0\r\na=mid:0\r\na=rtpmap:111 opus/48000/2\r\n" + audio +
+		"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\nc=IN IP4 0.0.0.0\r\na=mid:1\r\na=sctp-port:5000\r\n" +
+		"a=candidate:2 1 udp 1 192.0.2.2 6000 typ host\r\n"
+}
+
+func TestTransportOf(t *testing.T) {
+	credentials := "a=ice-ufrag:u\r\na=ice-pwd:p\r\n"
+	tests := []struct {
+		name       string
+		session    string
+		audio      string
+		err        string
+		setup      string
+		candidates int
+	}{
+		{"in the m-line", "", credentials + "a=fingerprint:" + testFingerprint + "\r\na=setup:active\r\na=candidate:1 1 udp 1 192.0.2.1 5000 typ host\r\n", "", setupActive, 2},
+		{"in the session", credentials + "a=fingerprint:" + testFingerprint + "\r\n", "", "", setupActPass, 1},
+		{"no credentials", "a=fingerprint:" + testFingerprint + "\r\n", "", "missing ICE credentials", "", 0},
+		{"no fingerprint", credentials, "", "missing DTLS fingerprint", "", 0},
+		{"unknown hash", credentials + "a=fingerprint:md5 AB:CD\r\n", "", "unsupported fingerprint hash", "", 0},
+		{"invalid setup", credentials + "a=fingerprint:" + testFingerprint + "\r\na=setup:both\r\n", "", "invalid DTLS setup", "", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			description, err := parse(offer(test.session, test.audio))
+			if err != nil {
+				t.Fatal(err)
+			}
+			params, err := transportOf(description)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if params.ufrag != "u" || params.pwd != "p" || params.hash != crypto.SHA256 || params.setup != test.setup {
+				t.Errorf("got %+v", params)
+			}
+			if len(params.candidates) != test.candidates {
+				t.Errorf("got candidates %v, want %d", params.candidates, test.candidates)
+			}
+		})
+	}
+}
+
+func TestStrip(t *testing.T) {
+	description, err := parse(offer("a=ice-lite\r\na=ice-ufrag:u\r\na=ice-pwd:p\r\n", "a=fingerprint:"+testFingerprint+"\r\na=setup:actpass\r\na=end-of-candidates\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	strip(description)
+	text := description.String()
+	for _, name := range transportAttributes {
+		if strings.Contains(text, "a="+name) {
+			t.Errorf("got a=%s in %q", name, text)
+		}
+	}
+	if !strings.Contains(text, "a=rtpmap:111 opus/48000/2") || !strings.Contains(text, "a=group:BUNDLE 0 1") {
+		t.Errorf("got %q, want the media attributes kept", text)
+	}
+}
+
+func TestConnection(t *testing.T) {
+	tests := []struct {
+		candidates []string
+		want       string
+	}{
+		{nil, "IN IP4 0.0.0.0"},
+		{[]string{"1 1 udp 1 192.0.2.1 5000 typ host"}, "IN IP4 192.0.2.1"},
+		{[]string{"1 1 udp 1 2001:db8::1 5000 typ host"}, "IN IP6 2001:db8::1"},
+		{[]string{"1 1 udp 1 x.local 5000 typ host", "2 1 udp 1 192.0.2.2 5000 typ host"}, "IN IP4 192.0.2.2"},
+	}
+	for _, test := range tests {
+		if got := connection(test.candidates); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.candidates, got, test.want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	if got := list(" lo, eth0 ,,"); !reflect.DeepEqual(got, []string{"lo", "eth0"}) {
+		t.Errorf("got %v", got)
+	}
+	loopback := net.ParseIP("127.0.0.1")
+	other := net.ParseIP("192.0.2.1")
+	tests := []struct {
+		name              string
+		enforce, ignore   []string
+		lo                bool // the loopback interface
+		loopbackIP, other bool
+		includeLoopback   bool
+	}{
+		{"nothing", nil, nil, true, true, true, false},
+		{"enforce lo", []string{"lo"}, nil, true, true, true, true},
+		{"enforce an address", []string{"192.0.2."}, nil, true, false, true, false},
+		{"enforce a loopback address", []string{"127.0.0.1"}, nil, true, true, false, true},
+		{"ignore lo", nil, []string{"lo"}, false, true, true, false},
+		{"ignore an address", nil, []string{"192.0.2."}, true, true, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var s settings
+			s.filter(test.enforce, test.ignore)
+			if got := s.agent.InterfaceFilter("lo"); got != test.lo {
+				t.Errorf("got %v for lo", got)
+			}
+			if got := s.agent.IPFilter(loopback); got != test.loopbackIP {
+				t.Errorf("got %v for %s", got, loopback)
+			}
+			if got := s.agent.IPFilter(other); got != test.other {
+				t.Errorf("got %v for %s", got, other)
+			}
+			if s.agent.IncludeLoopback != test.includeLoopback {
+				t.Errorf("got IncludeLoopback %v", s.agent.IncludeLoopback)
+			}
+		})
+	}
+}
+
+// testPlugin passes what comes from the client to the test
+type testPlugin struct {
+	plugins.Plugin
+	rtp  chan *plugins.RTPPacket
+	data chan *plugins.DataPacket
+}
+
+func (testPlugin) Package() string                                                    { return "janus.plugin.test" }
+func (testPlugin) CreateSession(ps *plugins.PluginSession) error                      { return nil }
+func (testPlugin) DestroySession(ps *plugins.PluginSession) error                     { return nil }
+func (testPlugin) SetupMedia(ps *plugins.PluginSession)                               {}
+func (testPlugin) HangupMedia(ps *plugins.PluginSession)                              {}
+func (testPlugin) SlowLink(ps *plugins.PluginSession, uplink, video bool)             {}
+func (testPlugin) IncomingRTCP(ps *plugins.PluginSession, packet *plugins.RTCPPacket) {}
+
+func (p testPlugin) IncomingRTP(ps *plugins.PluginSession, packet *plugins.RTPPacket) {
+	select {
+	case p.rtp <- packet:
+	default:
+	}
+}
+
+func (p testPlugin) IncomingData(ps *plugins.PluginSession, packet *plugins.DataPacket) {
+	select {
+	case p.data <- packet:
+	default:
+	}
+}
+
+func newTestHandle(t *testing.T, p plugins.Plugin) *session.Handle {
+	config.Conf.Nat.Ice_enforce_list = "lo"
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := session.New(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Destroy(false) })
+	h, err := s.Attach(p, "", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// newBrowser is a PeerConnection the way a browser would do it, only on the
+// loopback interface
+func newBrowser(t *testing.T) *pion.PeerConnection {
+	var s pion.SettingEngine
+	s.SetNetworkTypes([]pion.NetworkType{pion.NetworkTypeUDP4})
+	s.SetIncludeLoopbackCandidate(true)
+	s.SetInterfaceFilter(func(name string) bool { return name == "lo" })
+	s.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	var m pion.MediaEngine
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+	pc, err := pion.NewAPI(pion.WithSettingEngine(s), pion.WithMediaEngine(&m)).NewPeerConnection(pion.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !done(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestRemoteErrors(t *testing.T) {
+	h := newTestHandle(t, testPlugin{})
+	h.SetState(session.StateNegotiating)
+	valid := offer("", "a=ice-ufrag:u\r\na=ice-pwd:p\r\na=fingerprint:"+testFingerprint+"\r\n")
+	if _, err := Remote(h, &plugins.JSEP{Type: "answer", SDP: valid}); err != ErrUnexpectedAnswer {
+		t.Errorf("got %v for an answer without an offer, want %v", err, ErrUnexpectedAnswer)
+	}
+	if _, err := Remote(h, &plugins.JSEP{Type: "offer", SDP: "v=0"}); err == nil {
+		t.Error("got no error for an invalid SDP")
+	}
+	stripped, err := Remote(h, &plugins.JSEP{Type: "offer", SDP: valid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stripped.SDP, "a=ice-ufrag") || strings.Contains(stripped.SDP, "a=candidate") {
+		t.Errorf("got %q, want it without the transport attributes", stripped.SDP)
+	}
+	restart := strings.Replace(valid, "a=ice-ufrag:u", "a=ice-ufrag:v", 1)
+	if _, err := Remote(h, &plugins.JSEP{Type: "offer", SDP: restart}); err != ErrICERestart {
+		t.Errorf("got %v for new credentials, want %v", err, ErrICERestart)
+	}
+	h.Hangup("test")
+	if pc, _ := peerConnectionOf(h, false); pc != nil {
+		t.Error("the PeerConnection is still there after a hangup")
+	}
+	if err := h.Detach(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Remote(h, &plugins.JSEP{Type: "offer", SDP: valid}); err != session.ErrHandleDetached {
+		t.Errorf("got %v for a detached handle, want %v", err, session.ErrHandleDetached)
+	}
+}
+
+// TestOfferToBrowser goes the other way round from the EchoTest: the
+// plugin offers, the browser answers and is the DTLS client
+func TestOfferToBrowser(t *testing.T) {
+	p := testPlugin{rtp: make(chan *plugins.RTPPacket, 1), data: make(chan *plugins.DataPacket, 1)}
+	h := newTestHandle(t, p)
+	h.SetState(session.StateNegotiating)
+	local, err := Local(h, &plugins.JSEP{Type: "offer", SDP: sdp.GenerateOffer(sdp.OfferOptions{AudioCodec: "opus", Data: true}).String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(local.SDP, "a=fingerprint:"+load().fingerprint) || !strings.Contains(local.SDP, "a=end-of-candidates") {
+		t.Fatalf("got offer %q, without our transport", local.SDP)
+	}
+
+	browser := newBrowser(t)
+	received := make(chan struct{})
+	browser.OnTrack(func(remote *pion.TrackRemote, _ *pion.RTPReceiver) {
+		if _, _, err := remote.ReadRTP(); err == nil {
+			close(received)
+		}
+	})
+	channels := make(chan *pion.DataChannel, 1)
+	browser.OnDataChannel(func(dc *pion.DataChannel) {
+		dc.OnOpen(func() { dc.SendText("hi") })
+		channels <- dc
+	})
+	if err := browser.SetRemoteDescription(pion.SessionDescription{Type: pion.SDPTypeOffer, SDP: local.SDP}); err != nil {
+		t.Fatalf("the browser can't take the offer: %v\n%s", err, local.SDP)
+	}
+	track, err := pion.NewTrackLocalStaticRTP(pion.RTPCodecCapability{MimeType: pion.MimeTypeOpus, ClockRate: 48000, Channels: 2}, "audio", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := browser.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := browser.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := pion.GatheringCompletePromise(browser)
+	if err := browser.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	if _, err := Remote(h, &plugins.JSEP{Type: "answer", SDP: browser.LocalDescription().SDP}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "media", func() bool { return h.State() == session.StateMedia })
+
+	// audio goes both ways
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		packet := make([]byte, rtp.HeaderSize+3)
+		packet[0] = 0x80
+		rtp.SetPayloadType(packet, 111)
+		for seq := uint16(0); ; seq++ {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				track.WriteRTP(&pionrtp.Packet{Header: pionrtp.Header{Version: 2, SequenceNumber: seq, Timestamp: uint32(seq) * 960}, Payload: []byte{0xfc, 0xff, 0xfe}})
+				rtp.SetSeq(packet, seq)
+				h.RelayRTP(&plugins.RTPPacket{Mindex: -1, Buffer: packet})
+			}
+		}
+	}()
+	select {
+	case packet := <-p.rtp:
+		if packet.Video || packet.Mindex != 0 || rtp.PayloadType(packet.Buffer) != 111 {
+			t.Errorf("got %+v", packet)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no audio from the browser")
+	}
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no audio to the browser")
+	}
+
+	// the plugin opens a data channel, and the browser answers on it
+	if err := h.RelayData(&plugins.DataPacket{Label: "chat", Buffer: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case dc := <-channels:
+		if dc.Label() != "chat" {
+			t.Errorf("got channel %q, want chat", dc.Label())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no data channel")
+	}
+	select {
+	case packet := <-p.data:
+		if packet.Label != "chat" || packet.Binary || string(packet.Buffer) != "hi" {
+			t.Errorf("got %+v", packet)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no data from the browser")
+	}
+
+	info := h.PeerConnectionInfo()
+	if info.ICE["state"] != "connected" || info.DTLS["state"] != "connected" || info.DTLS["role"] != "server" || len(info.Media) != 2 {
+		t.Fatalf("got %+v", info)
+	}
+	data, _ := json.Marshal(info)
+	if !strings.Contains(string(data), `"type":"data"`) {
+		t.Errorf("got %s, want the data m-line", data)
+	}
+
+	h.Hangup("test")
+	if pc, _ := peerConnectionOf(h, false); pc != nil {
+		t.Error("the PeerConnection is still there after a hangup")
+	}
+}